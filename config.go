@@ -0,0 +1,40 @@
+package main
+
+import (
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+
+	"github.com/webtor-io/vault/services"
+)
+
+func makeConfigCMD() cli.Command {
+	return cli.Command{
+		Name:  "config",
+		Usage: "Manages config file",
+		Subcommands: []cli.Command{
+			{
+				Name:      "validate",
+				Usage:     "Validates config file against options of serve command",
+				ArgsUsage: "<config-file>",
+				Action:    configValidate,
+			},
+		},
+	}
+}
+
+func configValidate(c *cli.Context) error {
+	path := c.Args().First()
+	if path == "" {
+		return errors.New("config file is required")
+	}
+	cf, err := services.LoadConfigFile(path)
+	if err != nil {
+		return err
+	}
+	if err = cf.Validate(makeServeCMD().Flags); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	log.WithField("options", len(cf)).Infof("config %v is valid", path)
+	return nil
+}
@@ -6,5 +6,15 @@ import (
 
 func configure(app *cli.App) {
 	serveCmd := makeServeCMD()
-	app.Commands = []cli.Command{serveCmd}
+	verifyCmd := makeVerifyCMD()
+	metricsSchemaCmd := makeMetricsSchemaCMD()
+	configCmd := makeConfigCMD()
+	exportCmd := makeExportCMD()
+	importCmd := makeImportCMD()
+	migrateKeysCmd := makeMigrateKeysCMD()
+	simulateCmd := makeSimulateCMD()
+	storeCmd := makeStoreCMD()
+	rmCmd := makeRmCMD()
+	purgeCmd := makePurgeCMD()
+	app.Commands = []cli.Command{serveCmd, verifyCmd, metricsSchemaCmd, configCmd, exportCmd, importCmd, migrateKeysCmd, simulateCmd, storeCmd, rmCmd, purgeCmd}
 }
@@ -19,30 +19,53 @@ const docTemplatevault = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
-        "/resource/{id}": {
+        "/admin/blocklist": {
             "get": {
                 "tags": [
-                    "resource"
+                    "admin"
                 ],
-                "summary": "Get resource",
+                "summary": "List blocklisted infohashes",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Resource ID",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
+                        "description": "Entry source: manual or remote",
+                        "name": "source",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page size (default 50, max 1000)",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page offset",
+                        "name": "offset",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/services.Resource"
+                            "$ref": "#/definitions/services.BlocklistResponse"
                         }
                     },
-                    "404": {
-                        "description": "Not Found",
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
                         "schema": {
                             "$ref": "#/definitions/services.ErrorResponse"
                         }
@@ -54,27 +77,58 @@ const docTemplatevault = `{
                         }
                     }
                 }
-            },
+            }
+        },
+        "/admin/blocklist/{infohash}": {
             "put": {
-                "description": "Creates the resource if missing or marks it queued for processing",
+                "description": "Blocklisted infohashes are rejected with 451 on store",
+                "consumes": [
+                    "application/json"
+                ],
                 "tags": [
-                    "resource"
+                    "admin"
                 ],
-                "summary": "Queue storing of a resource",
+                "summary": "Blocklist infohash",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Resource ID",
-                        "name": "id",
+                        "description": "Infohash",
+                        "name": "infohash",
                         "in": "path",
                         "required": true
+                    },
+                    {
+                        "description": "Block reason",
+                        "name": "request",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/services.BlocklistRequest"
+                        }
                     }
                 ],
                 "responses": {
-                    "202": {
-                        "description": "Accepted",
+                    "200": {
+                        "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/services.Resource"
+                            "$ref": "#/definitions/services.BlocklistEntry"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
                         }
                     },
                     "500": {
@@ -87,23 +141,38 @@ const docTemplatevault = `{
             },
             "delete": {
                 "tags": [
-                    "resource"
+                    "admin"
                 ],
-                "summary": "Queue deletion of a resource",
+                "summary": "Remove infohash from blocklist",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Resource ID",
-                        "name": "id",
+                        "description": "Infohash",
+                        "name": "infohash",
                         "in": "path",
                         "required": true
                     }
                 ],
                 "responses": {
-                    "202": {
-                        "description": "Accepted",
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "401": {
+                        "description": "Unauthorized",
                         "schema": {
-                            "$ref": "#/definitions/services.Resource"
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
                         }
                     },
                     "500": {
@@ -115,38 +184,160 @@ const docTemplatevault = `{
                 }
             }
         },
-        "/webseed/{id}/{path}": {
+        "/admin/bulk": {
             "get": {
-                "description": "Proxies stored files from S3 with Range support. Returns 404 if resource is not fully stored or file not found.",
-                "produces": [
-                    "application/octet-stream"
-                ],
+                "description": "Returns bulk operations, newest first.",
                 "tags": [
-                    "webseed"
+                    "admin"
                 ],
-                "summary": "Webseed proxy",
+                "summary": "List bulk operations",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Resource ID",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
+                        "description": "Status: queued, running, done, failed or cancelled",
+                        "name": "status",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page size (default 50, max 1000)",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page offset",
+                        "name": "offset",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/services.BulkOperationsResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Queues action for resources matched by label selector, by status (e.g. store_error) or listed in ids.\nBesides actions of POST /resources/bulk, rehash re-hashes stored files marking mismatching ones\ncorrupted and migrate rewrites stored objects with storage_class of params (or their current class)\nand current server-side encryption settings.",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Queue bulk operation",
+                "parameters": [
+                    {
+                        "description": "Action and target",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/services.BulkRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "$ref": "#/definitions/services.BulkOperation"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
                     },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/bulk/{id}/cancel": {
+            "post": {
+                "description": "Stops a queued or running bulk operation after the resource being processed, pending resources\nare reported as cancelled. Finished operations are returned unchanged.",
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Cancel bulk operation",
+                "parameters": [
                     {
                         "type": "string",
-                        "description": "Path inside resource",
-                        "name": "path",
+                        "description": "Bulk operation ID",
+                        "name": "id",
                         "in": "path",
                         "required": true
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "OK"
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/services.BulkOperation"
+                        }
                     },
-                    "206": {
-                        "description": "Partial Content"
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
                     },
                     "404": {
                         "description": "Not Found",
@@ -161,16 +352,91 @@ const docTemplatevault = `{
                         }
                     }
                 }
-            },
-            "head": {
-                "description": "Proxies stored files from S3 with Range support. Returns 404 if resource is not fully stored or file not found.",
-                "produces": [
-                    "application/octet-stream"
+            }
+        },
+        "/admin/instances": {
+            "get": {
+                "description": "Lists running vault instances with their heartbeat and resources they currently hold leases for",
+                "tags": [
+                    "admin"
+                ],
+                "summary": "List vault instances",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/services.InstancesResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/replica": {
+            "get": {
+                "description": "Counts copies of stored files in the replica bucket by status: pending, done and failed (retried with backoff).",
+                "tags": [
+                    "admin"
                 ],
+                "summary": "Replication status",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/services.ReplicaResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/resource/{id}/cdn-audit": {
+            "post": {
+                "description": "Replays a representative set of webseed requests of a stored file through the full webseed path (HEAD,\nfull GET of files up to 1MB, single and multiple ranges, unsatisfiable range, revalidation with\nIf-None-Match, directory index, missing path and a request without token) and reports status, caching\nheaders and whether a shared cache (CDN) may store every response, with issues found.",
                 "tags": [
-                    "webseed"
+                    "admin"
                 ],
-                "summary": "Webseed proxy",
+                "summary": "Audit CDN cacheability of webseed",
                 "parameters": [
                     {
                         "type": "string",
@@ -181,18 +447,29 @@ const docTemplatevault = `{
                     },
                     {
                         "type": "string",
-                        "description": "Path inside resource",
+                        "description": "Path of the file (default: smallest stored file)",
                         "name": "path",
-                        "in": "path",
-                        "required": true
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "OK"
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/services.CDNAuditReport"
+                        }
                     },
-                    "206": {
-                        "description": "Partial Content"
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
                     },
                     "404": {
                         "description": "Not Found",
@@ -208,45 +485,5185 @@ const docTemplatevault = `{
                     }
                 }
             }
-        }
-    },
-    "definitions": {
-        "services.ErrorResponse": {
-            "type": "object",
+        },
+        "/admin/resource/{id}/legal-hold": {
+            "put": {
+                "description": "Blocks deletion, expiry, eviction and gc of the resource and its files until cleared",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Put resource under legal hold",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Resource ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Legal hold reason",
+                        "name": "request",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/services.LegalHoldRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/services.Resource"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Clear legal hold of resource",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Resource ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/services.Resource"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/resource/{id}/self-test": {
+            "post": {
+                "description": "Fetches random ranges of every stored file through the full webseed path (with token of the request) and\ncompares them with stored objects. Content covered by the file hash (head and tail of sampled hashes, whole\nfiles up to 16MB for full hashes) is fetched the same way and re-hashed. Files are not marked corrupted.",
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Self-test webseed of the resource",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Resource ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Random ranges per file (default 3, max 16)",
+                        "name": "ranges",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Size of random ranges in bytes (default 65536, max 4MB)",
+                        "name": "range_size",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/services.SelfTestReport"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/stalled": {
+            "get": {
+                "description": "Lists storing resources whose stored size didn't change for STALLED_AFTER (or ?after), longest stalled first.",
+                "tags": [
+                    "admin"
+                ],
+                "summary": "List stalled resources",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Period without progress (Go duration, e.g. 1h)",
+                        "name": "after",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page size (default 50, max 1000)",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page offset",
+                        "name": "offset",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/services.StalledResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/takedown/{id}": {
+            "get": {
+                "tags": [
+                    "admin"
+                ],
+                "summary": "List takedowns of resource",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Resource ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/services.Takedown"
+                            }
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Immediately blocks webseed access to the resource (410 Gone), queues deletion according to policy\nand records the takedown. Resources under legal hold are blocked but not deleted.",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Take down resource",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Resource ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Takedown details",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/services.TakedownRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/services.TakedownResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/tenants": {
+            "get": {
+                "description": "Number and size of resources owned by every tenant, largest first.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "List tenant usage",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/services.TenantUsage"
+                            }
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/tokens": {
+            "get": {
+                "tags": [
+                    "admin"
+                ],
+                "summary": "List minted tokens",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Tenant",
+                        "name": "tenant",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page size (default 50, max 1000)",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page offset",
+                        "name": "offset",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/services.TokensResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Mints a token signed by vault limited to tenant, scopes and expiry. Scopes: resource:store,\nresource:delete, resource:read, webseed:read. Token is enforced when REQUIRE_TOKEN is set.",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Mint scoped token",
+                "parameters": [
+                    {
+                        "description": "Token parameters",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/services.TokenRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/services.TokenResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/tokens/{id}": {
+            "delete": {
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Revoke minted token",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Token ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/services.ApiToken"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/audit": {
+            "get": {
+                "description": "Returns POST/PUT/PATCH/DELETE requests with identity of the caller (JWT subject, role and session id,\nremote address, tenant and id of scoped tokens), client IP and request id, newest first. Rejected requests\nare recorded too.",
+                "tags": [
+                    "admin"
+                ],
+                "summary": "List audited API mutations",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Resource ID",
+                        "name": "resource_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "JWT subject or tenant of scoped token",
+                        "name": "subject",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Session ID",
+                        "name": "session_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Client IP or remote address of JWT",
+                        "name": "ip",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Request ID",
+                        "name": "request_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "HTTP method",
+                        "name": "method",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Created at or after (RFC3339)",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Created before (RFC3339)",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page size (default 50, max 1000)",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page offset",
+                        "name": "offset",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/services.ApiAuditResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/collection/{id}": {
+            "get": {
+                "description": "Returns collection with aggregate size and a page of its resources, most recently added first.",
+                "tags": [
+                    "collection"
+                ],
+                "summary": "Get collection",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Collection ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page size (default 50, max 1000)",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page offset",
+                        "name": "offset",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/services.CollectionResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Deletes collection, its resources are kept.",
+                "tags": [
+                    "collection"
+                ],
+                "summary": "Delete collection",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Collection ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/services.Collection"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/collection/{id}/export": {
+            "get": {
+                "description": "Streams stored files of all stored resources of the collection as a single uncompressed zip archive,\nfiles are placed under \u003cresource_id\u003e/\u003cpath\u003e. Resources which are not stored or are taken down are skipped.",
+                "produces": [
+                    "application/zip"
+                ],
+                "tags": [
+                    "collection"
+                ],
+                "summary": "Export collection as zip",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Collection ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/collection/{id}/resource/{resource_id}": {
+            "put": {
+                "tags": [
+                    "collection"
+                ],
+                "summary": "Add resource to collection",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Collection ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Resource ID",
+                        "name": "resource_id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/services.Collection"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Removes resource from collection, the resource itself is kept.",
+                "tags": [
+                    "collection"
+                ],
+                "summary": "Remove resource from collection",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Collection ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Resource ID",
+                        "name": "resource_id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/services.Collection"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/collections": {
+            "get": {
+                "description": "Returns collections with resource count and aggregate size, newest first.",
+                "tags": [
+                    "collection"
+                ],
+                "summary": "List collections",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Page size (default 50, max 1000)",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page offset",
+                        "name": "offset",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/services.CollectionsResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Creates an empty named group of resources.",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "collection"
+                ],
+                "summary": "Create collection",
+                "parameters": [
+                    {
+                        "description": "Collection",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/services.CollectionRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/services.Collection"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/dav/{id}/{path}": {
+            "get": {
+                "description": "Read-only WebDAV (PROPFIND, GET, HEAD, OPTIONS) over stored files of the resource,\nso it can be mounted in file managers and media players.",
+                "tags": [
+                    "webseed"
+                ],
+                "summary": "WebDAV access to stored resource",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Resource ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Path inside resource",
+                        "name": "path",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK"
+                    },
+                    "207": {
+                        "description": "Multi-Status"
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "405": {
+                        "description": "Method Not Allowed"
+                    },
+                    "410": {
+                        "description": "Gone",
+                        "schema": {
+                            "$ref": "#/definitions/services.GoneResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/import": {
+            "post": {
+                "description": "Queues storing of every resource listed in a JSON array of ImportRow or a CSV file with header\n(columns id, labels as \"k=v,k2=v2\", priority, ttl, expires_at and storage_class; only id is required).\nThe file is the body or file field of a multipart form, format is detected unless set by ?format.\nRows are validated up front: invalid and duplicate rows are reported by row number, valid rows are\nqueued by a bulk operation of store action, tracked by GET /resources/bulk/{id}. Higher priority\nresources are picked up for storing first.",
+                "consumes": [
+                    "application/json",
+                    "text/csv",
+                    "multipart/form-data"
+                ],
+                "tags": [
+                    "resource"
+                ],
+                "summary": "Import list of infohashes",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "json or csv",
+                        "name": "format",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "$ref": "#/definitions/services.ImportResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/services.ImportResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/liveness": {
+            "get": {
+                "tags": [
+                    "health"
+                ],
+                "summary": "Liveness check",
+                "responses": {
+                    "200": {
+                        "description": "OK"
+                    }
+                }
+            }
+        },
+        "/operations": {
+            "get": {
+                "description": "Returns store/delete history of all resources, newest first",
+                "tags": [
+                    "operations"
+                ],
+                "summary": "List operations",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Resource ID",
+                        "name": "resource_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Operation type: store or delete",
+                        "name": "type",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Operation status: success, fail or running",
+                        "name": "status",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Started at or after (RFC3339)",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Started before (RFC3339)",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page size (default 50, max 1000)",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page offset",
+                        "name": "offset",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/services.OperationsResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/operations/daily": {
+            "get": {
+                "description": "Returns counts and durations of store/delete operations finished per UTC day, newest first.\nOperation logs pruned by log retention are kept rolled up, so stats cover days past retention.",
+                "tags": [
+                    "operations"
+                ],
+                "summary": "Daily operation stats",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Operation type: store or delete",
+                        "name": "type",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Number of days including today (default 30, max 3660)",
+                        "name": "days",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/services.OperationStatsDailyResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/preview/{token}": {
+            "get": {
+                "description": "Serves the first bytes of the file of a preview link without X-Token. Single ranges within the preview are\nsupported, requests without a range get 206 with the preview part if the file is longer. Content-Range\nreports the full size of the file, ranges past the preview return 416.",
+                "produces": [
+                    "application/octet-stream"
+                ],
+                "tags": [
+                    "webseed"
+                ],
+                "summary": "Serve preview of stored file",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Preview token",
+                        "name": "token",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK"
+                    },
+                    "206": {
+                        "description": "Partial Content"
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "410": {
+                        "description": "Gone",
+                        "schema": {
+                            "$ref": "#/definitions/services.GoneResponse"
+                        }
+                    },
+                    "416": {
+                        "description": "Requested Range Not Satisfiable"
+                    },
+                    "429": {
+                        "description": "Too Many Requests"
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "head": {
+                "description": "Serves the first bytes of the file of a preview link without X-Token. Single ranges within the preview are\nsupported, requests without a range get 206 with the preview part if the file is longer. Content-Range\nreports the full size of the file, ranges past the preview return 416.",
+                "produces": [
+                    "application/octet-stream"
+                ],
+                "tags": [
+                    "webseed"
+                ],
+                "summary": "Serve preview of stored file",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Preview token",
+                        "name": "token",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK"
+                    },
+                    "206": {
+                        "description": "Partial Content"
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "410": {
+                        "description": "Gone",
+                        "schema": {
+                            "$ref": "#/definitions/services.GoneResponse"
+                        }
+                    },
+                    "416": {
+                        "description": "Requested Range Not Satisfiable"
+                    },
+                    "429": {
+                        "description": "Too Many Requests"
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/readiness": {
+            "get": {
+                "description": "Checks Postgres, S3 bucket and optionally webtor rest-api availability and store circuit breaker. States of\ncircuits are reported if the circuit breaker is enabled.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "health"
+                ],
+                "summary": "Readiness check",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/services.HealthResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/services.HealthResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/resource": {
+            "post": {
+                "description": "Pushes magnet URI or .torrent file to webtor rest-api, creates the resource with the derived infohash\nand queues it for storing, so no separate rest-api call is needed. The body is the magnet URI or .torrent\ncontent, or a multipart form with magnet or torrent (file) field. Options are the same as of PUT /resource/{id}.",
+                "consumes": [
+                    "text/plain",
+                    "application/x-bittorrent",
+                    "multipart/form-data"
+                ],
+                "tags": [
+                    "resource"
+                ],
+                "summary": "Queue storing of a resource from magnet URI or .torrent file",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Time to live (Go duration, e.g. 720h)",
+                        "name": "ttl",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Max store duration (Go duration, e.g. 6h)",
+                        "name": "max_duration",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "S3 storage class of the resource files",
+                        "name": "storage_class",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Queue only if content is available in the swarm",
+                        "name": "preflight",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "$ref": "#/definitions/services.Resource"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "424": {
+                        "description": "Failed Dependency",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/resource/{id}": {
+            "get": {
+                "description": "While the resource is storing, progress tells the phase of the job: hashing while content of a file is\ndownloaded to compute its hash before the upload (with hashed bytes), uploading otherwise, and how many\nfiles of the job are stored.",
+                "tags": [
+                    "resource"
+                ],
+                "summary": "Get resource",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Resource ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/services.Resource"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "description": "Creates the resource if missing or marks it queued for processing.\nOptional expiration can be set with ?ttl=720h or expires_at/ttl in the body.\nstorage_class in the body overrides default S3 storage class of the resource files.\nWith preflight in the body the resource is queued only if its content is available in the swarm.\nmax_duration (?max_duration=6h) fails storing with \"store deadline exceeded\" if it takes longer.\nIdempotency-Key header makes retries of the request replay the first response.",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "resource"
+                ],
+                "summary": "Queue storing of a resource",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Resource ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Time to live (Go duration, e.g. 720h)",
+                        "name": "ttl",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Max store duration (Go duration, e.g. 6h)",
+                        "name": "max_duration",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Key identifying the request across retries",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    },
+                    {
+                        "description": "Expiration and storage class",
+                        "name": "request",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/services.StoreRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "$ref": "#/definitions/services.Resource"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "424": {
+                        "description": "Failed Dependency",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "With If-Match header (ETag of GET /resource/{id}) the resource is deleted only if it has not changed\nsince it was read, otherwise 412 is returned. Idempotency-Key header makes retries replay the first response.",
+                "tags": [
+                    "resource"
+                ],
+                "summary": "Queue deletion of a resource",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Resource ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag the resource must still have",
+                        "name": "If-Match",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Key identifying the request across retries",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "$ref": "#/definitions/services.Resource"
+                        }
+                    },
+                    "412": {
+                        "description": "Precondition Failed",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "patch": {
+                "description": "Extends, shortens or removes (ttl=0) expiration of the resource, replaces its labels, name and annotations.\nFields which are not set stay untouched.",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "resource"
+                ],
+                "summary": "Update resource expiration and metadata",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Resource ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Expiration and metadata",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/services.PatchRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/services.Resource"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/resource/{id}/abort-and-clean": {
+            "post": {
+                "description": "Cancels storing of a queued_for_storing, storing or store_error resource and queues it for deletion\nwithout the restore window. Files uploaded for it are removed unless other resources reference them,\nincluding uploads which were not finished, so the resource is gone as if it was never stored.",
+                "tags": [
+                    "resource"
+                ],
+                "summary": "Abort and clean resource",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Resource ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "$ref": "#/definitions/services.Resource"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "423": {
+                        "description": "Locked",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/resource/{id}/archive": {
+            "post": {
+                "description": "Marks the stored resource archived and moves objects of its files to the archive storage class in\nthe background. Files shared with resources which are not archived stay where they are. Archived\nresources are not served by webseed until they are restored with POST /resource/{id}/restore.",
+                "tags": [
+                    "resource"
+                ],
+                "summary": "Archive resource",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Resource ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "GLACIER or DEEP_ARCHIVE (default S3_ARCHIVE_STORAGE_CLASS)",
+                        "name": "storage_class",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/services.Resource"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/resource/{id}/archive-contents": {
+            "get": {
+                "description": "Returns entries of a stored zip or rar file of the resource. Entries are read from the archive\nwith ranged S3 requests (zip central directory, rar file headers) and indexed on first request\nunless ARCHIVE_INDEX indexed them at store time.",
+                "tags": [
+                    "resource"
+                ],
+                "summary": "List entries of a stored archive",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Resource ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Path of the archive inside resource",
+                        "name": "path",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/services.ArchiveContents"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/resource/{id}/archive-entry": {
+            "get": {
+                "description": "Streams decompressed content of one entry of a stored zip or rar file, reading only the parts\nof the archive needed with ranged S3 requests (solid rar archives are decoded up to the entry).",
+                "tags": [
+                    "webseed"
+                ],
+                "summary": "Extract a single entry of a stored archive",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Resource ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Path of the archive inside resource",
+                        "name": "path",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Entry name inside archive",
+                        "name": "entry",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "410": {
+                        "description": "Gone",
+                        "schema": {
+                            "$ref": "#/definitions/services.GoneResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/resource/{id}/clone": {
+            "post": {
+                "description": "Creates stored resource target_id linking the same files as the resource, so nothing is uploaded again.\nUseful when the same content appears under a different infohash. The clone is recorded in the operation log\nof the target (cloned_from), which keeps its files while they are referenced by either resource.\nResources which are blocklisted or taken down can't be cloned, nor can they be cloned to.",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "resource"
+                ],
+                "summary": "Clone stored resource",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Resource ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Target resource",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/services.CloneRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/services.Resource"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "410": {
+                        "description": "Gone",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "451": {
+                        "description": "Unavailable For Legal Reasons",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/resource/{id}/cross-seeds": {
+            "get": {
+                "description": "Lists other resources containing files of the resource (by file hash), with the number and total size\nof shared files and their share of the resource size, most shared bytes first. Scoped tokens only\nsee resources of their tenant.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "resource"
+                ],
+                "summary": "Resources sharing files",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Resource ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page size",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page offset",
+                        "name": "offset",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/services.CrossSeedReport"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/resource/{id}/estimate": {
+            "get": {
+                "description": "Lists the torrent via rest-api without queueing it and returns total size, file count\nand projected storage growth (files already stored are deduplicated).",
+                "tags": [
+                    "resource"
+                ],
+                "summary": "Estimate resource size",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Resource ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/services.EstimateResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/resource/{id}/file": {
+            "get": {
+                "description": "Returns storing state of the file at path: stored (or corrupted) file with its hash,\nstore_error with the error of its last attempt, or queued_for_storing while a store job is pending.\nWhile the job stores the file, progress tells whether it is being hashed or uploaded and how many bytes are done.",
+                "tags": [
+                    "resource"
+                ],
+                "summary": "Get status of a single file",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Resource ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Path inside resource",
+                        "name": "path",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/services.ResourceFileStatus"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/resource/{id}/file-url": {
+            "get": {
+                "description": "Returns a time-limited pre-signed S3 url of the file, so heavy downloads bypass vault.\nNot available with client-side encryption.",
+                "tags": [
+                    "webseed"
+                ],
+                "summary": "Pre-signed S3 url of stored file",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Resource ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Path inside resource",
+                        "name": "path",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Url lifetime, e.g. 1h (default PRESIGN_EXPIRY, capped by PRESIGN_MAX_EXPIRY)",
+                        "name": "expiry",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Response Content-Disposition type: inline or attachment (with file name)",
+                        "name": "disposition",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/services.FileURLResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "410": {
+                        "description": "Gone",
+                        "schema": {
+                            "$ref": "#/definitions/services.GoneResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "501": {
+                        "description": "Not Implemented",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/resource/{id}/file/retry": {
+            "post": {
+                "description": "Queues a store job limited to the file at path of a store_error, stored or corrupted resource,\nother files of the resource are kept as is. The retry is recorded in the operation log.",
+                "tags": [
+                    "resource"
+                ],
+                "summary": "Re-store a single file",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Resource ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Path inside resource",
+                        "name": "path",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "$ref": "#/definitions/services.Resource"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/resource/{id}/operations": {
+            "get": {
+                "description": "Returns store/delete history of the resource, newest first",
+                "tags": [
+                    "operations"
+                ],
+                "summary": "List operations of a resource",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Resource ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Operation type: store or delete",
+                        "name": "type",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Operation status: success, fail or running",
+                        "name": "status",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Started at or after (RFC3339)",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Started before (RFC3339)",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page size (default 50, max 1000)",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page offset",
+                        "name": "offset",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/services.OperationsResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/resource/{id}/preview": {
+            "post": {
+                "description": "Returns a link serving only the first max_bytes of the file without X-Token until it expires, for sharing\nsamples. Links are signed with VAULT_TOKEN_SECRET and can't be revoked one by one; takedowns apply to them.",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "webseed"
+                ],
+                "summary": "Public preview link of stored file",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Resource ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Preview",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/services.PreviewRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/services.PreviewResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "410": {
+                        "description": "Gone",
+                        "schema": {
+                            "$ref": "#/definitions/services.GoneResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/resource/{id}/probe": {
+            "get": {
+                "description": "Checks that torrent metadata is resolvable and its content can be downloaded from the swarm\nby reading first bytes of the largest file, so dead torrents are not queued for storing.",
+                "tags": [
+                    "resource"
+                ],
+                "summary": "Probe resource availability",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Resource ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/services.ProbeResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/resource/{id}/restore": {
+            "post": {
+                "description": "Undoes deletion of a pending_purge resource within DELETE_GRACE_PERIOD, its files are served again.\nArchived resources become restoring and 202 is returned: worker requests restores of their objects,\ncopies them back once retrievable and marks the resource stored.",
+                "tags": [
+                    "resource"
+                ],
+                "summary": "Restore deleted or archived resource",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Resource ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/services.Resource"
+                        }
+                    },
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "$ref": "#/definitions/services.Resource"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/resource/{id}/retry": {
+            "post": {
+                "description": "Resets a store_error/delete_error resource back to queued status, clears the error and records the retry in the operation log",
+                "tags": [
+                    "resource"
+                ],
+                "summary": "Retry failed resource",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Resource ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "$ref": "#/definitions/services.Resource"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/resource/{id}/stats": {
+            "get": {
+                "description": "Returns requests and bytes served by webseed for the resource and a page of its accessed files, most bytes\nserved first. Usage is buffered by web replicas for ACCESS_STATS_FLUSH_INTERVAL before it shows up.",
+                "tags": [
+                    "resource"
+                ],
+                "summary": "Webseed usage of resource",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Resource ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page size of files (default 50, max 1000)",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page offset of files",
+                        "name": "offset",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/services.AccessStatsResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/resource/{id}/update": {
+            "post": {
+                "description": "Queues target_id (e.g. an updated season pack re-announced under a new infohash) as the next version\nof the stored resource. Files with the same path and size as in the resource are hashed and linked if\ntheir content is unchanged, so only changed files are uploaded. The resource is kept, versions are\nlisted by GET /resource/{id}/versions. Versions which are blocklisted or taken down can't be updated.",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "resource"
+                ],
+                "summary": "Update stored resource from a new infohash",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Resource ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "New version",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/services.UpdateRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "$ref": "#/definitions/services.Resource"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "410": {
+                        "description": "Gone",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "451": {
+                        "description": "Unavailable For Legal Reasons",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/resource/{id}/verify": {
+            "post": {
+                "description": "Checks that S3 objects of all files of the resource exist and match sizes recorded in DB,\nre-hashing content of a sample of files (or all of them with rehash=true).\nMismatching files and resources referencing them are marked as corrupted.",
+                "tags": [
+                    "resource"
+                ],
+                "summary": "Verify stored resource",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Resource ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Re-hash content of every file",
+                        "name": "rehash",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/services.VerifyReport"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/resource/{id}/versions": {
+            "get": {
+                "description": "Returns resources linked to the resource by updates, from the oldest version to the newest.",
+                "tags": [
+                    "resource"
+                ],
+                "summary": "List versions of resource",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Resource ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/services.ResourceVersionList"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/resource/{id}/webseed-urls": {
+            "get": {
+                "description": "Returns url-list entries (BEP 19) of the stored resource to inject into magnet links (ws=) and .torrent files,\nand direct webseed URLs of its files. URLs are built on --webseed-public-url, or on the host of the request\nif it is not set. Torrent clients can't send X-Token, so webseed must be reachable without it.\nBEP 17 (httpseeds) is not served by webseed, so no such URLs are returned.",
+                "tags": [
+                    "resource"
+                ],
+                "summary": "Get web seed URLs",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Resource ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/services.WebseedURLsResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "410": {
+                        "description": "Gone",
+                        "schema": {
+                            "$ref": "#/definitions/services.GoneResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/resources": {
+            "get": {
+                "description": "Returns resources, most recently updated first. Use status=store_error or status=delete_error to find failed ones.\nlabel filters by labels, e.g. ?label=user:123. order=lru lists least recently accessed through webseed\nfirst (by access stats, falling back to last_accessed_at and created_at), e.g. to pick resources to evict.",
+                "tags": [
+                    "resource"
+                ],
+                "summary": "List resources",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Resource status, e.g. store_error",
+                        "name": "status",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "updated (default) or lru",
+                        "name": "order",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "Label selector, key=value, key:value or key, repeated terms must all match",
+                        "name": "label",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page size (default 50, max 1000)",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page offset",
+                        "name": "offset",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/services.ResourcesResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/resources/batch": {
+            "post": {
+                "description": "Queues all resources in a single transaction. Either every id is queued or none is.",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "resource"
+                ],
+                "summary": "Queue storing and deletion of multiple resources",
+                "parameters": [
+                    {
+                        "description": "Resource IDs to store and delete",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/services.BatchRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/services.BatchResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/resources/bulk": {
+            "post": {
+                "description": "Queues action for every resource whose labels match selector (comma-separated key=value or key terms,\ne.g. tenant=x,tier=cold). Actions are delete (requires delete scope), retry (delete_error resources\nare only retried with delete scope), policy (params ttl/expires_at and storage_class) and export\n(metadata dump written to exports/{bulk_id}.ndjson.gz of the bucket). The operation runs asynchronously\non a worker, progress and per-resource results are returned by GET /resources/bulk/{id}.",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "resource"
+                ],
+                "summary": "Queue bulk operation on resources matched by labels",
+                "parameters": [
+                    {
+                        "description": "Action and label selector",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/services.BulkRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "$ref": "#/definitions/services.BulkOperation"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/resources/bulk/{id}": {
+            "get": {
+                "description": "Returns status and progress of the bulk operation with a page of per-resource results.\nThe same is served for admins by GET /admin/bulk/{id}.",
+                "tags": [
+                    "resource"
+                ],
+                "summary": "Get bulk operation",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Bulk operation ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only items with result: pending, done, skipped, not_found, failed or cancelled",
+                        "name": "result",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page size (default 50, max 1000)",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page offset",
+                        "name": "offset",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/services.BulkOperationResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/resources/events": {
+            "get": {
+                "description": "Server-sent events stream sending a resource event with the resource JSON every time a resource changes:\nstatus, stored size (progress while storing) or error. Changes are polled every 2 seconds, so every\ninstance serves changes made by any worker. Reconnecting clients resume from Last-Event-ID.\nBrowsers may pass the token as ?token since EventSource can't set headers.",
+                "produces": [
+                    "text/event-stream"
+                ],
+                "tags": [
+                    "resource"
+                ],
+                "summary": "Stream resource updates",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "X-Token for clients which can't set headers",
+                        "name": "token",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/services.Resource"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/resources/purge": {
+            "post": {
+                "description": "Queues deletion of all resources matching status (e.g. store_error), older_than (created earlier than\nthis long ago, Go duration or days, e.g. 30d) and last_accessed_before (not served by webseed since, never\naccessed ones by creation time), optionally narrowed down by label selector. Resources being deleted\nalready are not matched unless status says so. Deletion runs as a bulk operation in batches, progress is\nreturned by GET /admin/bulk/{id}; resources under legal hold are skipped. With dry_run nothing is queued,\nthe number of matched resources is returned instead.",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Purge resources by status or age",
+                "parameters": [
+                    {
+                        "description": "Criteria",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/services.PurgeRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/services.PurgeResponse"
+                        }
+                    },
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "$ref": "#/definitions/services.PurgeResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/stats": {
+            "get": {
+                "description": "Returns resources by status, stored bytes, dedup savings, operation success rates over 24h\nand queue depth. Values are cached for a few seconds. States of store circuits are reported live if the\ncircuit breaker is enabled.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "stats"
+                ],
+                "summary": "Storage statistics",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/services.StatsResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/tenant/usage": {
+            "get": {
+                "description": "Number and size of resources owned by the tenant of the token, quotas limit them if configured.\nCallers without a tenant token pass the tenant as ?tenant.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "resource"
+                ],
+                "summary": "Tenant usage",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Tenant (only without a tenant token)",
+                        "name": "tenant",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/services.TenantUsage"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/webseed/{id}/{path}": {
+            "get": {
+                "description": "Proxies stored files from S3 with Range support. Returns 404 if resource is not fully stored or file not found.\nMultiple ranges are served as multipart/byteranges, unsatisfiable ranges return 416.\nPaths follow BEP 19 multi-file layout ({name}/{path}), so /webseed/{id}/ can be used as a torrent url-list entry.\nWhile DB is unavailable, cached lookups are served with X-Vault-Degraded: true header, others return 503.\nDirectory paths return an HTML index or JSON (Accept: application/json or ?format=json).\n?download=1 or ?disposition=attachment makes browsers save the file under its original name,\n?disposition=inline displays it with media type of the file (sandboxed by Content-Security-Policy).\nIf-None-Match and If-Modified-Since are evaluated against ETag and Last-Modified of the stored object,\nmatching GET and HEAD requests return 304. Cache-Control is set by --webseed-cache-control unless the\ncontent class of the file has its own max-age.\nArchived resources return 503 with Retry-After estimating when they are served again once restored.",
+                "produces": [
+                    "application/octet-stream"
+                ],
+                "tags": [
+                    "webseed"
+                ],
+                "summary": "Webseed proxy",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Resource ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Path inside resource",
+                        "name": "path",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "1 is a shortcut for disposition=attachment",
+                        "name": "download",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Content-Disposition type: inline or attachment (with file name)",
+                        "name": "disposition",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag of the cached copy",
+                        "name": "If-None-Match",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Last-Modified of the cached copy",
+                        "name": "If-Modified-Since",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/services.IndexResponse"
+                        }
+                    },
+                    "206": {
+                        "description": "Partial Content"
+                    },
+                    "304": {
+                        "description": "Not Modified"
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "410": {
+                        "description": "Gone",
+                        "schema": {
+                            "$ref": "#/definitions/services.GoneResponse"
+                        }
+                    },
+                    "416": {
+                        "description": "Requested Range Not Satisfiable"
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "head": {
+                "description": "Proxies stored files from S3 with Range support. Returns 404 if resource is not fully stored or file not found.\nMultiple ranges are served as multipart/byteranges, unsatisfiable ranges return 416.\nPaths follow BEP 19 multi-file layout ({name}/{path}), so /webseed/{id}/ can be used as a torrent url-list entry.\nWhile DB is unavailable, cached lookups are served with X-Vault-Degraded: true header, others return 503.\nDirectory paths return an HTML index or JSON (Accept: application/json or ?format=json).\n?download=1 or ?disposition=attachment makes browsers save the file under its original name,\n?disposition=inline displays it with media type of the file (sandboxed by Content-Security-Policy).\nIf-None-Match and If-Modified-Since are evaluated against ETag and Last-Modified of the stored object,\nmatching GET and HEAD requests return 304. Cache-Control is set by --webseed-cache-control unless the\ncontent class of the file has its own max-age.\nArchived resources return 503 with Retry-After estimating when they are served again once restored.",
+                "produces": [
+                    "application/octet-stream"
+                ],
+                "tags": [
+                    "webseed"
+                ],
+                "summary": "Webseed proxy",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Resource ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Path inside resource",
+                        "name": "path",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "1 is a shortcut for disposition=attachment",
+                        "name": "download",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Content-Disposition type: inline or attachment (with file name)",
+                        "name": "disposition",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag of the cached copy",
+                        "name": "If-None-Match",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Last-Modified of the cached copy",
+                        "name": "If-Modified-Since",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/services.IndexResponse"
+                        }
+                    },
+                    "206": {
+                        "description": "Partial Content"
+                    },
+                    "304": {
+                        "description": "Not Modified"
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "410": {
+                        "description": "Gone",
+                        "schema": {
+                            "$ref": "#/definitions/services.GoneResponse"
+                        }
+                    },
+                    "416": {
+                        "description": "Requested Range Not Satisfiable"
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "services.AccessStatsResponse": {
+            "type": "object",
+            "properties": {
+                "bytes_served": {
+                    "type": "integer"
+                },
+                "files": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/services.ResourceAccessStat"
+                    }
+                },
+                "first_accessed_at": {
+                    "type": "string"
+                },
+                "last_accessed_at": {
+                    "type": "string"
+                },
+                "limit": {
+                    "type": "integer"
+                },
+                "offset": {
+                    "type": "integer"
+                },
+                "requests": {
+                    "description": "Requests is the number of webseed requests which served content of the resource",
+                    "type": "integer"
+                },
+                "resource_id": {
+                    "type": "string"
+                },
+                "total": {
+                    "type": "integer"
+                }
+            }
+        },
+        "services.ApiAudit": {
+            "type": "object",
+            "properties": {
+                "audit_id": {
+                    "type": "string"
+                },
+                "client_ip": {
+                    "type": "string"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "method": {
+                    "type": "string"
+                },
+                "path": {
+                    "type": "string"
+                },
+                "remote_addr": {
+                    "description": "RemoteAddr is the remoteAddress claim of webtor api JWTs, address of the end user behind the caller",
+                    "type": "string"
+                },
+                "request_id": {
+                    "type": "string"
+                },
+                "resource_id": {
+                    "type": "string"
+                },
+                "role": {
+                    "type": "string"
+                },
+                "route": {
+                    "type": "string"
+                },
+                "session_id": {
+                    "description": "SessionID is the sessionID claim of webtor api JWTs",
+                    "type": "string"
+                },
+                "status": {
+                    "type": "integer"
+                },
+                "subject": {
+                    "description": "Subject is the JWT subject, tenant of scoped tokens",
+                    "type": "string"
+                },
+                "token_id": {
+                    "type": "string"
+                },
+                "user_agent": {
+                    "type": "string"
+                }
+            }
+        },
+        "services.ApiAuditResponse": {
+            "type": "object",
+            "properties": {
+                "limit": {
+                    "type": "integer"
+                },
+                "offset": {
+                    "type": "integer"
+                },
+                "records": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/services.ApiAudit"
+                    }
+                },
+                "total": {
+                    "type": "integer"
+                }
+            }
+        },
+        "services.ApiToken": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "created_by": {
+                    "type": "string"
+                },
+                "expires_at": {
+                    "type": "string"
+                },
+                "note": {
+                    "type": "string"
+                },
+                "revoked_at": {
+                    "type": "string"
+                },
+                "scopes": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/services.TokenScope"
+                    }
+                },
+                "tenant": {
+                    "type": "string"
+                },
+                "token_id": {
+                    "type": "string"
+                }
+            }
+        },
+        "services.ArchiveContents": {
+            "type": "object",
+            "properties": {
+                "entries": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/services.ArchiveEntry"
+                    }
+                },
+                "format": {
+                    "type": "string"
+                },
+                "path": {
+                    "type": "string"
+                },
+                "resource_id": {
+                    "type": "string"
+                }
+            }
+        },
+        "services.ArchiveEntry": {
+            "type": "object",
+            "properties": {
+                "encrypted": {
+                    "type": "boolean"
+                },
+                "is_dir": {
+                    "type": "boolean"
+                },
+                "modified_at": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "packed_size": {
+                    "type": "integer"
+                },
+                "size": {
+                    "type": "integer"
+                }
+            }
+        },
+        "services.BatchRequest": {
+            "type": "object",
+            "properties": {
+                "delete": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "store": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "services.BatchResponse": {
+            "type": "object",
+            "properties": {
+                "results": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/services.BatchResult"
+                    }
+                }
+            }
+        },
+        "services.BatchResult": {
+            "type": "object",
+            "properties": {
+                "operation_type": {
+                    "$ref": "#/definitions/services.OperationType"
+                },
+                "resource": {
+                    "$ref": "#/definitions/services.Resource"
+                },
+                "resource_id": {
+                    "type": "string"
+                },
+                "result": {
+                    "$ref": "#/definitions/services.BatchResultStatus"
+                }
+            }
+        },
+        "services.BatchResultStatus": {
+            "type": "string",
+            "enum": [
+                "queued",
+                "cancelled",
+                "not_found"
+            ],
+            "x-enum-varnames": [
+                "BatchResultQueued",
+                "BatchResultCancelled",
+                "BatchResultNotFound"
+            ]
+        },
+        "services.BlocklistEntry": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "infohash": {
+                    "type": "string"
+                },
+                "reason": {
+                    "type": "string"
+                },
+                "source": {
+                    "$ref": "#/definitions/services.BlocklistSource"
+                }
+            }
+        },
+        "services.BlocklistRequest": {
+            "type": "object",
+            "properties": {
+                "reason": {
+                    "type": "string"
+                }
+            }
+        },
+        "services.BlocklistResponse": {
+            "type": "object",
+            "properties": {
+                "entries": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/services.BlocklistEntry"
+                    }
+                },
+                "limit": {
+                    "type": "integer"
+                },
+                "offset": {
+                    "type": "integer"
+                },
+                "total": {
+                    "type": "integer"
+                }
+            }
+        },
+        "services.BlocklistSource": {
+            "type": "string",
+            "enum": [
+                "manual",
+                "remote"
+            ],
+            "x-enum-comments": {
+                "BlocklistSourceManual": "added via admin API",
+                "BlocklistSourceRemote": "synced from remote blocklist url"
+            },
+            "x-enum-descriptions": [
+                "added via admin API",
+                "synced from remote blocklist url"
+            ],
+            "x-enum-varnames": [
+                "BlocklistSourceManual",
+                "BlocklistSourceRemote"
+            ]
+        },
+        "services.BulkAction": {
+            "type": "string",
+            "enum": [
+                "delete",
+                "retry",
+                "policy",
+                "export",
+                "rehash",
+                "migrate",
+                "store"
+            ],
+            "x-enum-comments": {
+                "BulkActionDelete": "queue deletion",
+                "BulkActionExport": "dump metadata of resources to S3",
+                "BulkActionPolicy": "set expiration and storage class",
+                "BulkActionRehash": "re-hash stored files, mismatching ones are marked corrupted",
+                "BulkActionRetry": "requeue failed resources"
+            },
+            "x-enum-descriptions": [
+                "queue deletion",
+                "requeue failed resources",
+                "set expiration and storage class",
+                "dump metadata of resources to S3",
+                "re-hash stored files, mismatching ones are marked corrupted",
+                "",
+                ""
+            ],
+            "x-enum-varnames": [
+                "BulkActionDelete",
+                "BulkActionRetry",
+                "BulkActionPolicy",
+                "BulkActionExport",
+                "BulkActionRehash",
+                "BulkActionMigrate",
+                "BulkActionStore"
+            ]
+        },
+        "services.BulkItemResult": {
+            "type": "string",
+            "enum": [
+                "pending",
+                "done",
+                "skipped",
+                "not_found",
+                "failed",
+                "cancelled"
+            ],
+            "x-enum-comments": {
+                "BulkItemSkipped": "action does not apply, e.g. retry of a stored resource"
+            },
+            "x-enum-descriptions": [
+                "",
+                "",
+                "action does not apply, e.g. retry of a stored resource",
+                "",
+                "",
+                ""
+            ],
+            "x-enum-varnames": [
+                "BulkItemPending",
+                "BulkItemDone",
+                "BulkItemSkipped",
+                "BulkItemNotFound",
+                "BulkItemFailed",
+                "BulkItemCancelled"
+            ]
+        },
+        "services.BulkOperation": {
+            "type": "object",
+            "properties": {
+                "action": {
+                    "$ref": "#/definitions/services.BulkAction"
+                },
+                "bulk_id": {
+                    "type": "string"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "created_before": {
+                    "description": "CreatedBefore and LastAccessedBefore narrow matched resources down by age, they are set by purge",
+                    "type": "string"
+                },
+                "error": {
+                    "type": "string"
+                },
+                "failed": {
+                    "type": "integer"
+                },
+                "finished_at": {
+                    "type": "string"
+                },
+                "last_accessed_before": {
+                    "type": "string"
+                },
+                "lease_expires_at": {
+                    "type": "string"
+                },
+                "lease_owner": {
+                    "type": "string"
+                },
+                "location": {
+                    "type": "string"
+                },
+                "params": {
+                    "$ref": "#/definitions/services.BulkParams"
+                },
+                "processed": {
+                    "type": "integer"
+                },
+                "request_id": {
+                    "type": "string"
+                },
+                "selector": {
+                    "type": "string"
+                },
+                "started_at": {
+                    "type": "string"
+                },
+                "status": {
+                    "$ref": "#/definitions/services.BulkStatus"
+                },
+                "target_status": {
+                    "type": "string"
+                },
+                "total": {
+                    "type": "integer"
+                }
+            }
+        },
+        "services.BulkOperationItem": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string"
+                },
+                "finished_at": {
+                    "type": "string"
+                },
+                "params": {
+                    "description": "Params of this resource, applied instead of params of the operation",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/services.BulkParams"
+                        }
+                    ]
+                },
+                "resource_id": {
+                    "type": "string"
+                },
+                "result": {
+                    "$ref": "#/definitions/services.BulkItemResult"
+                }
+            }
+        },
+        "services.BulkOperationResponse": {
+            "type": "object",
+            "properties": {
+                "bulk_operation": {
+                    "$ref": "#/definitions/services.BulkOperation"
+                },
+                "items": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/services.BulkOperationItem"
+                    }
+                },
+                "limit": {
+                    "type": "integer"
+                },
+                "offset": {
+                    "type": "integer"
+                },
+                "total": {
+                    "type": "integer"
+                }
+            }
+        },
+        "services.BulkOperationsResponse": {
+            "type": "object",
+            "properties": {
+                "bulk_operations": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/services.BulkOperation"
+                    }
+                },
+                "limit": {
+                    "type": "integer"
+                },
+                "offset": {
+                    "type": "integer"
+                },
+                "total": {
+                    "type": "integer"
+                }
+            }
+        },
+        "services.BulkParams": {
+            "type": "object",
+            "properties": {
+                "expires_at": {
+                    "type": "string"
+                },
+                "labels": {
+                    "description": "Labels replace labels of the resource stored by store action",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "priority": {
+                    "description": "Priority is set as queue priority of the resource stored by store action",
+                    "type": "integer"
+                },
+                "retry_deletes": {
+                    "description": "RetryDeletes lets retry action requeue delete_error resources, set if the creator holds delete scope",
+                    "type": "boolean"
+                },
+                "storage_class": {
+                    "description": "StorageClass is set by policy action as storage class of files stored later, migrate action\nalso moves stored objects to it",
+                    "type": "string"
+                },
+                "ttl": {
+                    "type": "string"
+                }
+            }
+        },
+        "services.BulkRequest": {
+            "type": "object",
+            "properties": {
+                "action": {
+                    "$ref": "#/definitions/services.BulkAction"
+                },
+                "ids": {
+                    "description": "IDs lists resources explicitly",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "params": {
+                    "description": "Params of policy and migrate actions",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/services.BulkParams"
+                        }
+                    ]
+                },
+                "selector": {
+                    "description": "Selector matches resources by labels, e.g. \"tenant=x,tier=cold\"",
+                    "type": "string"
+                },
+                "status": {
+                    "description": "Status matches resources by status, e.g. store_error",
+                    "type": "string"
+                }
+            }
+        },
+        "services.BulkStatus": {
+            "type": "string",
+            "enum": [
+                "queued",
+                "running",
+                "done",
+                "failed",
+                "cancelled"
+            ],
+            "x-enum-varnames": [
+                "BulkStatusQueued",
+                "BulkStatusRunning",
+                "BulkStatusDone",
+                "BulkStatusFailed",
+                "BulkStatusCancelled"
+            ]
+        },
+        "services.CDNAuditCheck": {
+            "type": "object",
+            "properties": {
+                "cacheable": {
+                    "description": "Cacheable tells whether a shared cache may store the response",
+                    "type": "boolean"
+                },
+                "error": {
+                    "type": "string"
+                },
+                "headers": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "issues": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "method": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "path": {
+                    "type": "string"
+                },
+                "request": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "status": {
+                    "type": "integer"
+                },
+                "ttl": {
+                    "description": "TTL is the explicit freshness lifetime in seconds, nil if caches would fall back to heuristics",
+                    "type": "integer"
+                }
+            }
+        },
+        "services.CDNAuditReport": {
+            "type": "object",
+            "properties": {
+                "cacheable": {
+                    "description": "Cacheable is set if content responses (full and range reads) are cacheable",
+                    "type": "boolean"
+                },
+                "checks": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/services.CDNAuditCheck"
+                    }
+                },
+                "path": {
+                    "type": "string"
+                },
+                "resource_id": {
+                    "type": "string"
+                }
+            }
+        },
+        "services.CheckResult": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string"
+                },
+                "latency_ms": {
+                    "type": "integer"
+                },
+                "status": {
+                    "type": "string"
+                }
+            }
+        },
+        "services.CircuitState": {
+            "type": "object",
+            "properties": {
+                "failures": {
+                    "description": "Failures is the number of consecutive failures",
+                    "type": "integer"
+                },
+                "open_until": {
+                    "type": "string"
+                },
+                "state": {
+                    "description": "State is closed, open or half_open (cool-down passed, the next failure opens the circuit again)",
+                    "type": "string"
+                }
+            }
+        },
+        "services.CloneRequest": {
+            "type": "object",
+            "properties": {
+                "expires_at": {
+                    "type": "string"
+                },
+                "target_id": {
+                    "description": "TargetID is the id the resource is cloned to, it must not exist yet",
+                    "type": "string"
+                },
+                "ttl": {
+                    "type": "string"
+                }
+            }
+        },
+        "services.Collection": {
+            "type": "object",
+            "properties": {
+                "collection_id": {
+                    "type": "string"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "description": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "owner": {
+                    "description": "Owner is the tenant which created the collection, only its scoped tokens see it",
+                    "type": "string"
+                },
+                "stats": {
+                    "description": "Stats is aggregated from resources of the collection, not stored",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/services.CollectionStats"
+                        }
+                    ]
+                },
+                "updated_at": {
+                    "type": "string"
+                }
+            }
+        },
+        "services.CollectionRequest": {
+            "type": "object",
+            "properties": {
+                "description": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                }
+            }
+        },
+        "services.CollectionResponse": {
+            "type": "object",
+            "properties": {
+                "collection": {
+                    "$ref": "#/definitions/services.Collection"
+                },
+                "limit": {
+                    "type": "integer"
+                },
+                "offset": {
+                    "type": "integer"
+                },
+                "resources": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/services.Resource"
+                    }
+                },
+                "total": {
+                    "type": "integer"
+                }
+            }
+        },
+        "services.CollectionStats": {
+            "type": "object",
+            "properties": {
+                "resources": {
+                    "type": "integer"
+                },
+                "stored_resources": {
+                    "type": "integer"
+                },
+                "stored_size": {
+                    "type": "integer"
+                },
+                "total_size": {
+                    "type": "integer"
+                }
+            }
+        },
+        "services.CollectionsResponse": {
+            "type": "object",
+            "properties": {
+                "collections": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/services.Collection"
+                    }
+                },
+                "limit": {
+                    "type": "integer"
+                },
+                "offset": {
+                    "type": "integer"
+                },
+                "total": {
+                    "type": "integer"
+                }
+            }
+        },
+        "services.CrossSeed": {
+            "type": "object",
+            "properties": {
+                "resource_id": {
+                    "type": "string"
+                },
+                "share": {
+                    "description": "Share is SharedSize as a fraction of the unique size of the resource of the report",
+                    "type": "number"
+                },
+                "shared_files": {
+                    "description": "SharedFiles is the number of distinct files both resources contain",
+                    "type": "integer"
+                },
+                "shared_size": {
+                    "description": "SharedSize is the total size of shared files, counted once per file",
+                    "type": "integer"
+                }
+            }
+        },
+        "services.CrossSeedReport": {
+            "type": "object",
+            "properties": {
+                "files": {
+                    "description": "Files and Size count distinct files of the resource",
+                    "type": "integer"
+                },
+                "limit": {
+                    "type": "integer"
+                },
+                "offset": {
+                    "type": "integer"
+                },
+                "resource_id": {
+                    "type": "string"
+                },
+                "resources": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/services.CrossSeed"
+                    }
+                },
+                "size": {
+                    "type": "integer"
+                },
+                "total": {
+                    "type": "integer"
+                }
+            }
+        },
+        "services.DedupStats": {
+            "type": "object",
+            "properties": {
+                "savings": {
+                    "description": "Savings is the size which would be stored again without deduplication",
+                    "type": "integer"
+                },
+                "shared_files": {
+                    "description": "SharedFiles is the number of files referenced by more than one resource",
+                    "type": "integer"
+                },
+                "shared_size": {
+                    "description": "SharedSize is the total size of shared files",
+                    "type": "integer"
+                }
+            }
+        },
+        "services.ErrorResponse": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string"
+                }
+            }
+        },
+        "services.EstimateResponse": {
+            "type": "object",
+            "properties": {
+                "file_count": {
+                    "type": "integer"
+                },
+                "new_size": {
+                    "description": "NewSize is the projected storage usage growth",
+                    "type": "integer"
+                },
+                "resource": {
+                    "description": "Resource is present if the resource is already known to vault",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/services.Resource"
+                        }
+                    ]
+                },
+                "resource_id": {
+                    "type": "string"
+                },
+                "stored_size": {
+                    "description": "StoredSize is the size of files which are already stored and would be deduplicated",
+                    "type": "integer"
+                },
+                "total_size": {
+                    "type": "integer"
+                }
+            }
+        },
+        "services.FileSelfTest": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string"
+                },
+                "hash": {
+                    "type": "string"
+                },
+                "hash_checked": {
+                    "description": "HashChecked is set if content hashed by the file algorithm was fetched through webseed: always\nfor sampled hashes, for full hashes only if the file is small enough",
+                    "type": "boolean"
+                },
+                "passed": {
+                    "type": "boolean"
+                },
+                "path": {
+                    "type": "string"
+                },
+                "ranges": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/services.RangeCheck"
+                    }
+                },
+                "size": {
+                    "type": "integer"
+                }
+            }
+        },
+        "services.FileStoreProgress": {
+            "type": "object",
+            "properties": {
+                "done": {
+                    "description": "Done bytes of the phase",
+                    "type": "integer"
+                },
+                "path": {
+                    "type": "string"
+                },
+                "phase": {
+                    "type": "string"
+                },
+                "size": {
+                    "description": "Size of the phase: bytes to hash (only head and tail with the sampled hash) or to upload",
+                    "type": "integer"
+                },
+                "total_size": {
+                    "type": "integer"
+                }
+            }
+        },
+        "services.FileURLResponse": {
+            "type": "object",
+            "properties": {
+                "expires_at": {
+                    "type": "string"
+                },
+                "url": {
+                    "type": "string"
+                }
+            }
+        },
+        "services.FileVerification": {
+            "type": "object",
+            "properties": {
+                "corrupted": {
+                    "type": "boolean"
+                },
+                "error": {
+                    "type": "string"
+                },
+                "exists": {
+                    "type": "boolean"
+                },
+                "hash": {
+                    "type": "string"
+                },
+                "object_size": {
+                    "description": "ObjectSize is the plaintext size of the S3 object, absent if object is missing",
+                    "type": "integer"
+                },
+                "path": {
+                    "type": "string"
+                },
+                "rehashed": {
+                    "type": "boolean"
+                },
+                "size": {
+                    "description": "Size expected by DB",
+                    "type": "integer"
+                }
+            }
+        },
+        "services.GoneResponse": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string"
+                },
+                "reason_code": {
+                    "type": "string"
+                }
+            }
+        },
+        "services.HealthResponse": {
+            "type": "object",
+            "properties": {
+                "checks": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "$ref": "#/definitions/services.CheckResult"
+                    }
+                },
+                "circuits": {
+                    "description": "Circuits are states of the store circuit breaker, omitted if it is disabled",
+                    "type": "object",
+                    "additionalProperties": {
+                        "$ref": "#/definitions/services.CircuitState"
+                    }
+                },
+                "status": {
+                    "type": "string"
+                }
+            }
+        },
+        "services.IDType": {
+            "type": "string",
+            "enum": [
+                "infohash",
+                "infohash_v2",
+                "uuid",
+                "cid"
+            ],
+            "x-enum-varnames": [
+                "IDTypeInfohash",
+                "IDTypeInfohashV2",
+                "IDTypeUUID",
+                "IDTypeCID"
+            ]
+        },
+        "services.ImportResponse": {
+            "type": "object",
+            "properties": {
+                "bulk_operation": {
+                    "$ref": "#/definitions/services.BulkOperation"
+                },
+                "errors": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/services.ImportRowError"
+                    }
+                },
+                "queued": {
+                    "type": "integer"
+                }
+            }
+        },
+        "services.ImportRowError": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "row": {
+                    "type": "integer"
+                }
+            }
+        },
+        "services.IndexEntry": {
+            "type": "object",
+            "properties": {
+                "href": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "path": {
+                    "type": "string"
+                },
+                "size": {
+                    "type": "integer"
+                },
+                "type": {
+                    "description": "file or directory",
+                    "type": "string"
+                }
+            }
+        },
+        "services.IndexResponse": {
+            "type": "object",
+            "properties": {
+                "entries": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/services.IndexEntry"
+                    }
+                },
+                "path": {
+                    "type": "string"
+                },
+                "resource_id": {
+                    "type": "string"
+                }
+            }
+        },
+        "services.InstanceJob": {
+            "type": "object",
+            "properties": {
+                "lease_expires_at": {
+                    "type": "string"
+                },
+                "progress_at": {
+                    "description": "ProgressAt is the last moment the job changed status or stored size",
+                    "type": "string"
+                },
+                "resource_id": {
+                    "type": "string"
+                },
+                "status": {
+                    "$ref": "#/definitions/services.Status"
+                },
+                "stored_size": {
+                    "type": "integer"
+                },
+                "total_size": {
+                    "type": "integer"
+                }
+            }
+        },
+        "services.InstanceStatus": {
+            "type": "object",
+            "properties": {
+                "alive": {
+                    "description": "Alive is false if the instance missed 3 heartbeats",
+                    "type": "boolean"
+                },
+                "heartbeat_at": {
+                    "type": "string"
+                },
+                "hostname": {
+                    "type": "string"
+                },
+                "instance_id": {
+                    "type": "string"
+                },
+                "jobs": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/services.InstanceJob"
+                    }
+                },
+                "started_at": {
+                    "type": "string"
+                },
+                "workers": {
+                    "type": "integer"
+                }
+            }
+        },
+        "services.InstancesResponse": {
+            "type": "object",
+            "properties": {
+                "instances": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/services.InstanceStatus"
+                    }
+                }
+            }
+        },
+        "services.LegalHoldRequest": {
+            "type": "object",
+            "properties": {
+                "reason": {
+                    "type": "string"
+                }
+            }
+        },
+        "services.OperationLog": {
+            "type": "object",
+            "properties": {
+                "cloned_from": {
+                    "description": "ClonedFrom is the resource whose files were linked instead of storing them",
+                    "type": "string"
+                },
+                "error_text": {
+                    "description": "ErrorText stores error message when operation fails",
+                    "type": "string"
+                },
+                "file_retries": {
+                    "description": "FileRetries is the number of file downloads retried after transient torrent http proxy errors",
+                    "type": "integer"
+                },
+                "finished_at": {
+                    "type": "string"
+                },
+                "instance_id": {
+                    "description": "InstanceID of the vault instance which ran the operation",
+                    "type": "string"
+                },
+                "log_id": {
+                    "type": "string"
+                },
+                "operation_type": {
+                    "$ref": "#/definitions/services.OperationType"
+                },
+                "request_id": {
+                    "description": "RequestID of the API call which queued the operation",
+                    "type": "string"
+                },
+                "resource_id": {
+                    "description": "ResourceID becomes nullable to preserve logs when resource is deleted",
+                    "type": "string"
+                },
+                "retry": {
+                    "description": "Retry marks entries recording a manual retry of a failed operation, ErrorText holds the retried error",
+                    "type": "boolean"
+                },
+                "started_at": {
+                    "type": "string"
+                },
+                "status": {
+                    "description": "Status is nullable until the operation completes",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/services.OperationStatus"
+                        }
+                    ]
+                },
+                "taken_over_from": {
+                    "description": "TakenOverFrom is the instance which was running the operation before it stopped heartbeating\nor lost its lease",
+                    "type": "string"
+                }
+            }
+        },
+        "services.OperationStats": {
+            "type": "object",
+            "properties": {
+                "fail": {
+                    "type": "integer"
+                },
+                "running": {
+                    "type": "integer"
+                },
+                "success": {
+                    "type": "integer"
+                },
+                "success_rate": {
+                    "description": "SuccessRate is the share of successful operations among finished ones (0 if none finished)",
+                    "type": "number"
+                }
+            }
+        },
+        "services.OperationStatsDaily": {
+            "type": "object",
+            "properties": {
+                "day": {
+                    "type": "string"
+                },
+                "duration_ms": {
+                    "description": "DurationMs is the total duration of successful and failed operations",
+                    "type": "integer"
+                },
+                "fail": {
+                    "type": "integer"
+                },
+                "file_retries": {
+                    "type": "integer"
+                },
+                "max_duration_ms": {
+                    "type": "integer"
+                },
+                "operation_type": {
+                    "$ref": "#/definitions/services.OperationType"
+                },
+                "retries": {
+                    "type": "integer"
+                },
+                "success": {
+                    "type": "integer"
+                }
+            }
+        },
+        "services.OperationStatsDailyResponse": {
+            "type": "object",
+            "properties": {
+                "days": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/services.OperationStatsDaily"
+                    }
+                }
+            }
+        },
+        "services.OperationStatus": {
+            "type": "integer",
+            "format": "int32",
+            "enum": [
+                0,
+                1
+            ],
+            "x-enum-comments": {
+                "OperationFail": "1 - fail",
+                "OperationSuccess": "0 - success"
+            },
+            "x-enum-descriptions": [
+                "0 - success",
+                "1 - fail"
+            ],
+            "x-enum-varnames": [
+                "OperationSuccess",
+                "OperationFail"
+            ]
+        },
+        "services.OperationType": {
+            "type": "integer",
+            "format": "int32",
+            "enum": [
+                0,
+                1
+            ],
+            "x-enum-comments": {
+                "OperationDelete": "1 - delete",
+                "OperationStore": "0 - store"
+            },
+            "x-enum-descriptions": [
+                "0 - store",
+                "1 - delete"
+            ],
+            "x-enum-varnames": [
+                "OperationStore",
+                "OperationDelete"
+            ]
+        },
+        "services.OperationsResponse": {
+            "type": "object",
+            "properties": {
+                "limit": {
+                    "type": "integer"
+                },
+                "offset": {
+                    "type": "integer"
+                },
+                "operations": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/services.OperationLog"
+                    }
+                },
+                "total": {
+                    "type": "integer"
+                }
+            }
+        },
+        "services.PatchRequest": {
+            "type": "object",
+            "properties": {
+                "annotations": {
+                    "description": "Annotations replace arbitrary JSON attached to the resource, empty object removes them",
+                    "type": "object",
+                    "additionalProperties": {}
+                },
+                "expires_at": {
+                    "type": "string"
+                },
+                "labels": {
+                    "description": "Labels replace labels of the resource, empty object removes them",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "name": {
+                    "description": "Name sets name of the resource, empty string removes it",
+                    "type": "string"
+                },
+                "ttl": {
+                    "type": "string"
+                }
+            }
+        },
+        "services.PreviewRequest": {
+            "type": "object",
+            "required": [
+                "path"
+            ],
+            "properties": {
+                "max_bytes": {
+                    "description": "MaxBytes is the number of leading bytes served, defaults to PREVIEW_DEFAULT_BYTES",
+                    "type": "integer"
+                },
+                "path": {
+                    "type": "string"
+                },
+                "ttl": {
+                    "description": "TTL is a Go duration (e.g. 1h), defaults to PREVIEW_DEFAULT_TTL",
+                    "type": "string"
+                }
+            }
+        },
+        "services.PreviewResponse": {
+            "type": "object",
+            "properties": {
+                "expires_at": {
+                    "type": "string"
+                },
+                "max_bytes": {
+                    "type": "integer"
+                },
+                "path": {
+                    "type": "string"
+                },
+                "url": {
+                    "type": "string"
+                }
+            }
+        },
+        "services.ProbeResponse": {
+            "type": "object",
+            "properties": {
+                "available": {
+                    "description": "Available is true if content could be downloaded from the swarm",
+                    "type": "boolean"
+                },
+                "bytes_read": {
+                    "description": "BytesRead during the probe",
+                    "type": "integer"
+                },
+                "error": {
+                    "type": "string"
+                },
+                "file_count": {
+                    "type": "integer"
+                },
+                "latency_ms": {
+                    "description": "LatencyMs is the time to the first byte",
+                    "type": "integer"
+                },
+                "path": {
+                    "description": "Path of the probed file",
+                    "type": "string"
+                },
+                "resource_id": {
+                    "type": "string"
+                }
+            }
+        },
+        "services.PurgeRequest": {
+            "type": "object",
+            "properties": {
+                "dry_run": {
+                    "description": "DryRun only counts matched resources",
+                    "type": "boolean"
+                },
+                "last_accessed_before": {
+                    "description": "LastAccessedBefore matches resources not served by webseed since then (never accessed ones by creation)",
+                    "type": "string"
+                },
+                "older_than": {
+                    "description": "OlderThan matches resources created earlier than this long ago (Go duration or days, e.g. 30d)",
+                    "type": "string"
+                },
+                "selector": {
+                    "description": "Selector matches resources by labels, e.g. \"tenant=x,tier=cold\"",
+                    "type": "string"
+                },
+                "status": {
+                    "description": "Status matches resources by status, e.g. store_error",
+                    "type": "string"
+                }
+            }
+        },
+        "services.PurgeResponse": {
+            "type": "object",
+            "properties": {
+                "bulk_operation": {
+                    "description": "BulkOperation deletes matched resources in batches, progress is returned by GET /admin/bulk/{id}",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/services.BulkOperation"
+                        }
+                    ]
+                },
+                "dry_run": {
+                    "type": "boolean"
+                },
+                "legal_hold": {
+                    "description": "LegalHold is the number of matched resources under legal hold, which would be skipped",
+                    "type": "integer"
+                },
+                "matched": {
+                    "description": "Matched is the number of resources which would be queued for deletion, set by dry run",
+                    "type": "integer"
+                }
+            }
+        },
+        "services.QueueDepth": {
+            "type": "object",
+            "properties": {
+                "delete": {
+                    "type": "integer"
+                },
+                "store": {
+                    "type": "integer"
+                }
+            }
+        },
+        "services.RangeCheck": {
+            "type": "object",
+            "properties": {
+                "end": {
+                    "type": "integer"
+                },
+                "error": {
+                    "type": "string"
+                },
+                "passed": {
+                    "type": "boolean"
+                },
+                "start": {
+                    "type": "integer"
+                },
+                "status": {
+                    "type": "integer"
+                }
+            }
+        },
+        "services.ReplicaResponse": {
+            "type": "object",
+            "properties": {
+                "bucket": {
+                    "type": "string"
+                },
+                "files": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "integer"
+                    }
+                }
+            }
+        },
+        "services.Resource": {
+            "type": "object",
+            "properties": {
+                "annotations": {
+                    "description": "Annotations are arbitrary JSON attached by upstream services, not interpreted by vault",
+                    "type": "object",
+                    "additionalProperties": {}
+                },
+                "archive_storage_class": {
+                    "description": "ArchiveStorageClass is the storage class objects of the archived resource are moved to",
+                    "type": "string"
+                },
+                "archived_at": {
+                    "description": "ArchivedAt is set while the resource is archived or restoring, it is archived again if restored from pending_purge",
+                    "type": "string"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "error": {
+                    "type": "string"
+                },
+                "expires_at": {
+                    "description": "ExpiresAt is a moment after which the resource is queued for deletion (nil means store forever)",
+                    "type": "string"
+                },
+                "id_type": {
+                    "description": "IDType is the scheme of ID, only resources with infohash ids are stored from torrents",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/services.IDType"
+                        }
+                    ]
+                },
+                "labels": {
+                    "description": "Labels are matched by label selectors of bulk operations and resource listing",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "last_accessed_at": {
+                    "description": "LastAccessedAt is updated by webseed (at most hourly)",
+                    "type": "string"
+                },
+                "lease_expires_at": {
+                    "type": "string"
+                },
+                "lease_owner": {
+                    "description": "LeaseOwner is the worker replica processing the resource until LeaseExpiresAt",
+                    "type": "string"
+                },
+                "legal_hold": {
+                    "description": "LegalHold blocks deletion, expiry, eviction and gc of the resource and its files until cleared",
+                    "type": "boolean"
+                },
+                "legal_hold_at": {
+                    "type": "string"
+                },
+                "legal_hold_reason": {
+                    "type": "string"
+                },
+                "max_store_duration": {
+                    "description": "MaxStoreDuration in seconds, storing taking longer fails with ErrStoreDeadline",
+                    "type": "integer"
+                },
+                "name": {
+                    "description": "Name is a human readable name of the resource",
+                    "type": "string"
+                },
+                "owner": {
+                    "description": "Owner is the tenant which stored the resource, only its scoped tokens see it",
+                    "type": "string"
+                },
+                "previous_id": {
+                    "description": "PreviousID is the version the resource was updated from, its unchanged files are linked instead of stored",
+                    "type": "string"
+                },
+                "priority": {
+                    "description": "Priority orders queued resources, higher priority resources are stored first",
+                    "type": "integer"
+                },
+                "progress": {
+                    "description": "Progress of the running store job: phase (hashing or uploading), file counts and files in progress",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/services.StoreProgress"
+                        }
+                    ]
+                },
+                "progress_at": {
+                    "description": "ProgressAt is the last change of status or stored size, maintained by trigger",
+                    "type": "string"
+                },
+                "purge_at": {
+                    "description": "PurgeAt is when a pending_purge resource is deleted for good, it can be restored until then",
+                    "type": "string"
+                },
+                "request_id": {
+                    "description": "RequestID of the API call which last queued the resource",
+                    "type": "string"
+                },
+                "resource_id": {
+                    "type": "string"
+                },
+                "restore_requested_at": {
+                    "description": "RestoreRequestedAt is when restore of the archived resource was requested",
+                    "type": "string"
+                },
+                "stalled_at": {
+                    "description": "StalledAt is set when storing is reported as stalled, cleared by trigger on progress",
+                    "type": "string"
+                },
+                "status": {
+                    "$ref": "#/definitions/services.Status"
+                },
+                "storage_class": {
+                    "description": "StorageClass overrides default S3 storage class of files uploaded for the resource",
+                    "type": "string"
+                },
+                "store_paths": {
+                    "description": "StorePaths limits the queued store job to these files, other files must be stored already",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "stored_size": {
+                    "type": "integer"
+                },
+                "total_size": {
+                    "type": "integer"
+                },
+                "ttl": {
+                    "description": "TTL is remaining time to live in seconds, computed on read",
+                    "type": "integer"
+                },
+                "updated_at": {
+                    "type": "string"
+                }
+            }
+        },
+        "services.ResourceAccessStat": {
+            "type": "object",
+            "properties": {
+                "bytes_served": {
+                    "type": "integer"
+                },
+                "first_accessed_at": {
+                    "type": "string"
+                },
+                "last_accessed_at": {
+                    "type": "string"
+                },
+                "path": {
+                    "type": "string"
+                },
+                "requests": {
+                    "type": "integer"
+                }
+            }
+        },
+        "services.ResourceFileStatus": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string"
+                },
+                "hash": {
+                    "description": "Hash of the stored file, absent until the file is stored",
+                    "type": "string"
+                },
+                "path": {
+                    "type": "string"
+                },
+                "progress": {
+                    "description": "Progress of the file while the store job hashes or uploads it",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/services.FileStoreProgress"
+                        }
+                    ]
+                },
+                "resource_id": {
+                    "type": "string"
+                },
+                "status": {
+                    "$ref": "#/definitions/services.Status"
+                },
+                "storage_class": {
+                    "type": "string"
+                },
+                "stored_size": {
+                    "type": "integer"
+                },
+                "total_size": {
+                    "type": "integer"
+                },
+                "updated_at": {
+                    "type": "string"
+                }
+            }
+        },
+        "services.ResourceVersionList": {
+            "type": "object",
             "properties": {
-                "error": {
+                "resource_id": {
                     "type": "string"
+                },
+                "versions": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/services.Resource"
+                    }
                 }
             }
         },
-        "services.Resource": {
+        "services.ResourcesResponse": {
             "type": "object",
             "properties": {
-                "created_at": {
-                    "type": "string"
+                "limit": {
+                    "type": "integer"
                 },
-                "error": {
-                    "type": "string"
+                "offset": {
+                    "type": "integer"
+                },
+                "resources": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/services.Resource"
+                    }
+                },
+                "total": {
+                    "type": "integer"
+                }
+            }
+        },
+        "services.SelfTestReport": {
+            "type": "object",
+            "properties": {
+                "files": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/services.FileSelfTest"
+                    }
+                },
+                "passed": {
+                    "type": "boolean"
                 },
                 "resource_id": {
                     "type": "string"
+                }
+            }
+        },
+        "services.StalledResponse": {
+            "type": "object",
+            "properties": {
+                "after": {
+                    "description": "After is the period without progress resources are considered stalled after",
+                    "type": "string"
                 },
-                "status": {
-                    "$ref": "#/definitions/services.Status"
+                "limit": {
+                    "type": "integer"
                 },
-                "stored_size": {
+                "offset": {
                     "type": "integer"
                 },
-                "total_size": {
+                "resources": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/services.Resource"
+                    }
+                },
+                "total": {
+                    "type": "integer"
+                }
+            }
+        },
+        "services.StatsResponse": {
+            "type": "object",
+            "properties": {
+                "circuits": {
+                    "description": "Circuits are current states of the store circuit breaker, omitted if it is disabled",
+                    "type": "object",
+                    "additionalProperties": {
+                        "$ref": "#/definitions/services.CircuitState"
+                    }
+                },
+                "dedup": {
+                    "$ref": "#/definitions/services.DedupStats"
+                },
+                "file_count": {
+                    "description": "FileCount and StoredSize account unique stored objects",
                     "type": "integer"
                 },
-                "updated_at": {
+                "generated_at": {
                     "type": "string"
+                },
+                "operations": {
+                    "description": "Operations over the last 24h grouped by operation type",
+                    "type": "object",
+                    "additionalProperties": {
+                        "$ref": "#/definitions/services.OperationStats"
+                    }
+                },
+                "queue_depth": {
+                    "$ref": "#/definitions/services.QueueDepth"
+                },
+                "resource_count": {
+                    "type": "integer"
+                },
+                "resources": {
+                    "description": "Resources are grouped by status name",
+                    "type": "object",
+                    "additionalProperties": {
+                        "$ref": "#/definitions/services.StatusStats"
+                    }
+                },
+                "stored_size": {
+                    "type": "integer"
                 }
             }
         },
         "services.Status": {
             "type": "integer",
+            "format": "int32",
             "enum": [
                 0,
                 1,
@@ -254,7 +5671,11 @@ const docTemplatevault = `{
                 3,
                 4,
                 5,
-                6
+                6,
+                7,
+                8,
+                9,
+                10
             ],
             "x-enum-varnames": [
                 "StatusQueuedForStoring",
@@ -263,8 +5684,359 @@ const docTemplatevault = `{
                 "StatusStoreError",
                 "StatusQueuedForDeletion",
                 "StatusDeleting",
-                "StatusDeleteError"
+                "StatusDeleteError",
+                "StatusCorrupted",
+                "StatusPendingPurge",
+                "StatusArchived",
+                "StatusRestoring"
+            ]
+        },
+        "services.StatusStats": {
+            "type": "object",
+            "properties": {
+                "count": {
+                    "type": "integer"
+                },
+                "stored_size": {
+                    "type": "integer"
+                },
+                "total_size": {
+                    "type": "integer"
+                }
+            }
+        },
+        "services.StoreProgress": {
+            "type": "object",
+            "properties": {
+                "files": {
+                    "description": "Files is the number of files of the job listed so far, FilesStored of them are stored",
+                    "type": "integer"
+                },
+                "files_stored": {
+                    "type": "integer"
+                },
+                "hashed_size": {
+                    "description": "HashedSize of HashingSize bytes of files being hashed are hashed",
+                    "type": "integer"
+                },
+                "hashing_size": {
+                    "type": "integer"
+                },
+                "in_progress": {
+                    "description": "InProgress are files being stored, ordered by path",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/services.FileStoreProgress"
+                    }
+                },
+                "phase": {
+                    "description": "Phase is hashing while any file is being hashed, uploading otherwise",
+                    "type": "string"
+                }
+            }
+        },
+        "services.StoreRequest": {
+            "type": "object",
+            "properties": {
+                "annotations": {
+                    "description": "Annotations replace arbitrary JSON attached to the resource, empty object removes them",
+                    "type": "object",
+                    "additionalProperties": {}
+                },
+                "expires_at": {
+                    "type": "string"
+                },
+                "labels": {
+                    "description": "Labels replace labels of the resource, they are matched by label selectors of bulk operations",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "max_duration": {
+                    "description": "MaxDuration caps storing time (Go duration, \"0\" removes the cap), storing is failed with\n\"store deadline exceeded\" error once it is exceeded",
+                    "type": "string"
+                },
+                "name": {
+                    "description": "Name sets name of the resource, empty string removes it",
+                    "type": "string"
+                },
+                "preflight": {
+                    "description": "Preflight probes swarm availability and rejects the request if content can't be downloaded",
+                    "type": "boolean"
+                },
+                "storage_class": {
+                    "description": "StorageClass overrides default S3 storage class of the resource files",
+                    "type": "string"
+                },
+                "ttl": {
+                    "type": "string"
+                }
+            }
+        },
+        "services.Takedown": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "notes": {
+                    "type": "string"
+                },
+                "notice_url": {
+                    "type": "string"
+                },
+                "policy": {
+                    "$ref": "#/definitions/services.TakedownPolicy"
+                },
+                "reason_code": {
+                    "type": "string"
+                },
+                "remote_address": {
+                    "type": "string"
+                },
+                "reporter": {
+                    "type": "string"
+                },
+                "reporter_contact": {
+                    "type": "string"
+                },
+                "requested_by": {
+                    "type": "string"
+                },
+                "resource_id": {
+                    "type": "string"
+                },
+                "takedown_id": {
+                    "type": "string"
+                }
+            }
+        },
+        "services.TakedownPolicy": {
+            "type": "string",
+            "enum": [
+                "delete",
+                "block"
+            ],
+            "x-enum-comments": {
+                "TakedownPolicyBlock": "block access, keep content (e.g. for investigation)",
+                "TakedownPolicyDelete": "block access and queue deletion"
+            },
+            "x-enum-descriptions": [
+                "block access and queue deletion",
+                "block access, keep content (e.g. for investigation)"
+            ],
+            "x-enum-varnames": [
+                "TakedownPolicyDelete",
+                "TakedownPolicyBlock"
+            ]
+        },
+        "services.TakedownRequest": {
+            "type": "object",
+            "required": [
+                "reason_code"
+            ],
+            "properties": {
+                "notes": {
+                    "type": "string"
+                },
+                "notice_url": {
+                    "type": "string"
+                },
+                "policy": {
+                    "$ref": "#/definitions/services.TakedownPolicy"
+                },
+                "reason_code": {
+                    "type": "string"
+                },
+                "reporter": {
+                    "type": "string"
+                },
+                "reporter_contact": {
+                    "type": "string"
+                }
+            }
+        },
+        "services.TakedownResponse": {
+            "type": "object",
+            "properties": {
+                "resource": {
+                    "$ref": "#/definitions/services.Resource"
+                },
+                "takedown": {
+                    "$ref": "#/definitions/services.Takedown"
+                }
+            }
+        },
+        "services.TenantUsage": {
+            "type": "object",
+            "properties": {
+                "resources": {
+                    "type": "integer"
+                },
+                "stored_size": {
+                    "type": "integer"
+                },
+                "tenant": {
+                    "type": "string"
+                },
+                "total_size": {
+                    "description": "TotalSize of all files of the resources, including ones not stored yet",
+                    "type": "integer"
+                }
+            }
+        },
+        "services.TokenRequest": {
+            "type": "object",
+            "required": [
+                "scopes",
+                "tenant"
+            ],
+            "properties": {
+                "expires_at": {
+                    "type": "string"
+                },
+                "note": {
+                    "type": "string"
+                },
+                "scopes": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "tenant": {
+                    "type": "string"
+                },
+                "ttl": {
+                    "description": "TTL is a Go duration (e.g. 720h), defaults to 30 days",
+                    "type": "string"
+                }
+            }
+        },
+        "services.TokenResponse": {
+            "type": "object",
+            "properties": {
+                "record": {
+                    "$ref": "#/definitions/services.ApiToken"
+                },
+                "token": {
+                    "type": "string"
+                }
+            }
+        },
+        "services.TokenScope": {
+            "type": "string",
+            "enum": [
+                "resource:store",
+                "resource:delete",
+                "resource:read",
+                "webseed:read"
+            ],
+            "x-enum-varnames": [
+                "TokenScopeStore",
+                "TokenScopeDelete",
+                "TokenScopeRead",
+                "TokenScopeWebseed"
             ]
+        },
+        "services.TokensResponse": {
+            "type": "object",
+            "properties": {
+                "limit": {
+                    "type": "integer"
+                },
+                "offset": {
+                    "type": "integer"
+                },
+                "tokens": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/services.ApiToken"
+                    }
+                },
+                "total": {
+                    "type": "integer"
+                }
+            }
+        },
+        "services.UpdateRequest": {
+            "type": "object",
+            "properties": {
+                "expires_at": {
+                    "type": "string"
+                },
+                "target_id": {
+                    "description": "TargetID is the infohash of the new version, it must not exist yet",
+                    "type": "string"
+                },
+                "ttl": {
+                    "type": "string"
+                }
+            }
+        },
+        "services.VerifyReport": {
+            "type": "object",
+            "properties": {
+                "checked": {
+                    "type": "integer"
+                },
+                "corrupted": {
+                    "type": "integer"
+                },
+                "corrupted_resources": {
+                    "description": "Resources marked as corrupted during verification",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "files": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/services.FileVerification"
+                    }
+                },
+                "rehashed": {
+                    "type": "integer"
+                },
+                "resource_id": {
+                    "type": "string"
+                }
+            }
+        },
+        "services.WebseedFileURL": {
+            "type": "object",
+            "properties": {
+                "path": {
+                    "type": "string"
+                },
+                "url": {
+                    "type": "string"
+                }
+            }
+        },
+        "services.WebseedURLsResponse": {
+            "type": "object",
+            "properties": {
+                "files": {
+                    "description": "Files are direct URLs of files of the resource",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/services.WebseedFileURL"
+                    }
+                },
+                "resource_id": {
+                    "type": "string"
+                },
+                "url_list": {
+                    "description": "URLList are url-list entries (BEP 19) of the torrent, clients append name and path of files to them",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
         }
     }
 }`
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+
+	cs "github.com/webtor-io/common-services"
+	"github.com/webtor-io/vault/services"
+)
+
+const (
+	dumpGzipFlag      = "gzip"
+	dumpBatchSizeFlag = "batch-size"
+)
+
+func configureDump(c *cli.Command) {
+	c.Flags = services.RegisterConfigFlags(c.Flags)
+	c.Flags = cs.RegisterPGFlags(c.Flags)
+	c.Flags = services.RegisterDBFlags(c.Flags)
+	c.Flags = services.RegisterPGPoolFlags(c.Flags)
+	c.Flags = services.RegisterS3Flags(c.Flags)
+	c.Flags = services.RegisterSecretsFlags(c.Flags)
+	c.Flags = services.RegisterHCVaultFlags(c.Flags)
+	c.Flags = services.RegisterKMSFlags(c.Flags)
+}
+
+func makeExportCMD() cli.Command {
+	exportCmd := cli.Command{
+		Name:      "export",
+		Usage:     "Dumps resource, file, resource_file and log tables as newline-delimited JSON",
+		ArgsUsage: "[file|s3://bucket/key|-]",
+		Action:    export,
+	}
+	configureDump(&exportCmd)
+	exportCmd.Flags = append(exportCmd.Flags,
+		cli.BoolFlag{
+			Name:  dumpGzipFlag,
+			Usage: "gzip the dump (always on for targets ending with .gz)",
+		},
+	)
+	return exportCmd
+}
+
+func makeImportCMD() cli.Command {
+	importCmd := cli.Command{
+		Name:      "import",
+		Usage:     "Loads dump made by export, rows already present are skipped",
+		ArgsUsage: "[file|s3://bucket/key|-]",
+		Action:    importDump,
+	}
+	configureDump(&importCmd)
+	importCmd.Flags = append(importCmd.Flags,
+		cli.IntFlag{
+			Name:  dumpBatchSizeFlag,
+			Usage: "number of rows inserted at once",
+			Value: 1000,
+		},
+	)
+	return importCmd
+}
+
+// setupDump connects DB and S3 client for export and import.
+func setupDump(c *cli.Context) (*services.PG, *cs.S3Client, func(), error) {
+	// Setting Config
+	if _, err := services.LoadConfig(c); err != nil {
+		return nil, nil, nil, err
+	}
+
+	// Setting DB
+	pg := services.NewPG(c, services.DBRoleWorker)
+	if pg.Get() == nil {
+		pg.Close()
+		return nil, nil, nil, errors.New("DB not configured")
+	}
+
+	// Waiting for DB
+	if err := services.WaitForDB(c, pg); err != nil {
+		pg.Close()
+		return nil, nil, nil, err
+	}
+
+	cl := http.DefaultClient
+
+	// Setting Secrets
+	secrets, err := services.NewSecrets(c, cl)
+	if err != nil {
+		pg.Close()
+		return nil, nil, nil, err
+	}
+
+	// Setting S3Client
+	s3c, err := services.NewS3Client(c)
+	if err != nil {
+		secrets.Close()
+		pg.Close()
+		return nil, nil, nil, err
+	}
+	secrets.WatchS3(s3c)
+
+	return pg, s3c, func() {
+		secrets.Close()
+		pg.Close()
+	}, nil
+}
+
+func export(c *cli.Context) error {
+	pg, s3c, closer, err := setupDump(c)
+	if err != nil {
+		return err
+	}
+	defer closer()
+	ctx := context.Background()
+	loc := c.Args().First()
+	w, err := services.CreateDump(ctx, s3c, loc, c.Bool(dumpGzipFlag))
+	if err != nil {
+		return err
+	}
+	counts, err := services.ExportMetadata(ctx, pg.Get(), w, nil)
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	log.WithField("rows", counts).Info("metadata exported")
+	return nil
+}
+
+func importDump(c *cli.Context) error {
+	if c.Int(dumpBatchSizeFlag) < 1 {
+		return errors.New("batch size must be positive")
+	}
+	pg, s3c, closer, err := setupDump(c)
+	if err != nil {
+		return err
+	}
+	defer closer()
+
+	// Setting Migrations
+	mpg := cs.NewPG(c)
+	m := cs.NewPGMigration(mpg)
+	err = m.Run()
+	mpg.Close()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	r, err := services.OpenDump(ctx, s3c, c.Args().First())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = r.Close()
+	}()
+	counts, err := services.ImportMetadata(ctx, pg.Get(), r, c.Int(dumpBatchSizeFlag))
+	if err != nil {
+		return err
+	}
+	log.WithField("rows", counts).Info("metadata imported")
+	return nil
+}
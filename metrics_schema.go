@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+
+	"github.com/webtor-io/vault/services"
+)
+
+const (
+	metricsSchemaFormatFlag = "format"
+)
+
+func configureMetricsSchema(c *cli.Command) {
+	c.Flags = append(c.Flags,
+		cli.StringFlag{
+			Name:  metricsSchemaFormatFlag,
+			Usage: "output format: json (metric catalog) or grafana (example dashboard)",
+			Value: "json",
+		},
+	)
+}
+
+func makeMetricsSchemaCMD() cli.Command {
+	metricsSchemaCmd := cli.Command{
+		Name:   "metrics-schema",
+		Usage:  "Prints catalog of exported metrics with their labels and help",
+		Action: metricsSchema,
+	}
+	configureMetricsSchema(&metricsSchemaCmd)
+	return metricsSchemaCmd
+}
+
+func metricsSchema(c *cli.Context) error {
+	schema := services.MetricsSchema()
+	var out any
+	switch f := c.String(metricsSchemaFormatFlag); f {
+	case "json":
+		out = map[string]any{"metrics": schema}
+	case "grafana":
+		out = services.GrafanaDashboard(schema)
+	default:
+		return errors.Errorf("unsupported format %v", f)
+	}
+	je := json.NewEncoder(os.Stdout)
+	je.SetIndent("", "  ")
+	return je.Encode(out)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+
+	cs "github.com/webtor-io/common-services"
+	"github.com/webtor-io/vault/services"
+)
+
+const (
+	migrateKeysFromTemplateFlag = "from-template"
+	migrateKeysFromPrefixFlag   = "from-prefix"
+	migrateKeysDeleteFlag       = "delete-source"
+	migrateKeysPageSizeFlag     = "page-size"
+	migrateKeysConcurrencyFlag  = "concurrency"
+)
+
+func configureMigrateKeys(c *cli.Command) {
+	c.Flags = services.RegisterConfigFlags(c.Flags)
+	c.Flags = cs.RegisterPGFlags(c.Flags)
+	c.Flags = services.RegisterPGPoolFlags(c.Flags)
+	c.Flags = services.RegisterS3Flags(c.Flags)
+	c.Flags = services.RegisterKeyMigrationCommandFlags(c.Flags)
+	c.Flags = services.RegisterEncryptionFlags(c.Flags)
+	c.Flags = services.RegisterObjectTagFlags(c.Flags)
+	c.Flags = services.RegisterReplicaFlags(c.Flags)
+	c.Flags = services.RegisterSecretsFlags(c.Flags)
+	c.Flags = services.RegisterHCVaultFlags(c.Flags)
+	c.Flags = services.RegisterKMSFlags(c.Flags)
+	c.Flags = append(c.Flags,
+		cli.StringFlag{
+			Name:  migrateKeysFromTemplateFlag,
+			Usage: "key template objects are currently stored with",
+			Value: services.DefaultKeyTemplate,
+		},
+		cli.StringFlag{
+			Name:  migrateKeysFromPrefixFlag,
+			Usage: "value of {prefix} placeholder of the current key template",
+		},
+		cli.BoolFlag{
+			Name:  migrateKeysDeleteFlag,
+			Usage: "delete objects under the current keys once they are copied",
+		},
+		cli.IntFlag{
+			Name:  migrateKeysPageSizeFlag,
+			Usage: "number of files loaded from DB at once",
+			Value: 1000,
+		},
+		cli.IntFlag{
+			Name:  migrateKeysConcurrencyFlag,
+			Usage: "number of objects copied in parallel",
+			Value: 16,
+		},
+	)
+}
+
+func makeMigrateKeysCMD() cli.Command {
+	migrateKeysCmd := cli.Command{
+		Name:   "migrate-keys",
+		Usage:  "Moves stored objects from keys of --from-template to keys of --s3-key-template",
+		Action: migrateKeys,
+	}
+	configureMigrateKeys(&migrateKeysCmd)
+	return migrateKeysCmd
+}
+
+func migrateKeys(c *cli.Context) error {
+	if c.Int(migrateKeysPageSizeFlag) < 1 || c.Int(migrateKeysConcurrencyFlag) < 1 {
+		return errors.New("page size and concurrency must be positive")
+	}
+	from, err := services.ParseObjectKeys(c.String(migrateKeysFromTemplateFlag), c.String(migrateKeysFromPrefixFlag))
+	if err != nil {
+		return err
+	}
+	to, err := services.NewObjectKeys(c)
+	if err != nil {
+		return err
+	}
+
+	// Setting Config
+	if _, err := services.LoadConfig(c); err != nil {
+		return err
+	}
+
+	// Setting DB
+	pg := services.NewPG(c, services.DBRoleWorker)
+	defer pg.Close()
+	if pg.Get() == nil {
+		return errors.New("DB not configured")
+	}
+
+	cl := http.DefaultClient
+
+	// Setting Secrets
+	secrets, err := services.NewSecrets(c, cl)
+	if err != nil {
+		return err
+	}
+	defer secrets.Close()
+
+	// Setting S3Client
+	s3c, err := services.NewS3Client(c)
+	if err != nil {
+		return err
+	}
+	secrets.WatchS3(s3c)
+
+	// Setting Encryption
+	enc, err := services.NewEncryption(c, secrets)
+	if err != nil {
+		return err
+	}
+
+	tags, err := services.NewObjectTags(c)
+	if err != nil {
+		return err
+	}
+
+	// Setting Replica
+	replica, err := services.NewReplica(c, secrets, cl)
+	if err != nil {
+		return err
+	}
+
+	m := &services.KeyMigration{
+		DB:           pg.Get(),
+		S3:           s3c,
+		Enc:          enc,
+		Tags:         tags,
+		Replica:      replica,
+		Bucket:       c.String("aws-bucket"),
+		From:         from,
+		To:           to,
+		DeleteSource: c.Bool(migrateKeysDeleteFlag),
+		PageSize:     c.Int(migrateKeysPageSizeFlag),
+		Concurrency:  c.Int(migrateKeysConcurrencyFlag),
+	}
+	r, err := m.Run(context.Background())
+	je := json.NewEncoder(os.Stdout)
+	je.SetIndent("", "  ")
+	if eerr := je.Encode(r); eerr != nil && err == nil {
+		err = eerr
+	}
+	return err
+}
@@ -0,0 +1,2 @@
+ALTER TABLE log DROP COLUMN IF EXISTS request_id;
+ALTER TABLE resource DROP COLUMN IF EXISTS request_id;
@@ -0,0 +1,3 @@
+-- Request id of the API call which last queued the resource, propagated to worker logs and operation log
+ALTER TABLE resource ADD COLUMN IF NOT EXISTS request_id TEXT;
+ALTER TABLE log ADD COLUMN IF NOT EXISTS request_id TEXT;
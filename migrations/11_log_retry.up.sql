@@ -0,0 +1,2 @@
+-- Marks log entries recording a manual retry of a failed store/delete
+ALTER TABLE log ADD COLUMN IF NOT EXISTS retry BOOLEAN NOT NULL DEFAULT false;
@@ -0,0 +1,5 @@
+DROP INDEX CONCURRENTLY IF EXISTS idx_log_resource_started_at;
+--gopg:split
+DROP INDEX CONCURRENTLY IF EXISTS idx_file_status_updated_at;
+--gopg:split
+DROP INDEX CONCURRENTLY IF EXISTS idx_resource_file_resource_path;
@@ -0,0 +1,8 @@
+-- Indexes for listing, webseed lookup and gc queries. Built concurrently (migration runs outside of a
+-- transaction, one statement per split) so that large tables are not locked for writes.
+-- resource_file(file_hash) is already covered by idx_resource_file_file from 1_init.
+CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_resource_file_resource_path ON resource_file(resource_id, path);
+--gopg:split
+CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_file_status_updated_at ON file(status, updated_at);
+--gopg:split
+CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_log_resource_started_at ON log(resource_id, started_at);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_resource_file_resource_dir;
+DROP TRIGGER IF EXISTS trg_resource_file_set_dir ON resource_file;
+DROP FUNCTION IF EXISTS set_resource_file_dir();
+ALTER TABLE resource_file DROP COLUMN IF EXISTS dir;
@@ -0,0 +1,20 @@
+-- Directory prefix of resource_file.path (with trailing slash, e.g. "/name/sub/"), maintained by trigger,
+-- so directory listings and sub-tree queries are index-backed instead of scanning full paths
+ALTER TABLE resource_file ADD COLUMN IF NOT EXISTS dir TEXT NOT NULL DEFAULT '';
+
+CREATE OR REPLACE FUNCTION set_resource_file_dir()
+RETURNS TRIGGER AS $$
+BEGIN
+  NEW.dir = regexp_replace(NEW.path, '[^/]*$', '');
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS trg_resource_file_set_dir ON resource_file;
+CREATE TRIGGER trg_resource_file_set_dir
+BEFORE INSERT OR UPDATE OF path ON resource_file
+FOR EACH ROW EXECUTE FUNCTION set_resource_file_dir();
+
+UPDATE resource_file SET dir = regexp_replace(path, '[^/]*$', '') WHERE dir = '';
+
+CREATE INDEX IF NOT EXISTS idx_resource_file_resource_dir ON resource_file(resource_id, dir text_pattern_ops);
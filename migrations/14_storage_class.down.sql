@@ -0,0 +1,3 @@
+ALTER TABLE resource DROP COLUMN IF EXISTS last_accessed_at;
+ALTER TABLE file DROP COLUMN IF EXISTS storage_class;
+ALTER TABLE resource DROP COLUMN IF EXISTS storage_class;
@@ -0,0 +1,5 @@
+-- Storage class override of a resource and actual storage class of stored objects
+ALTER TABLE resource ADD COLUMN IF NOT EXISTS storage_class TEXT;
+ALTER TABLE file ADD COLUMN IF NOT EXISTS storage_class TEXT;
+-- Last webseed access, used to transition cold objects to a colder storage class
+ALTER TABLE resource ADD COLUMN IF NOT EXISTS last_accessed_at TIMESTAMPTZ;
@@ -0,0 +1,2 @@
+-- Normalization is not reversible, original paths are not kept
+SELECT 1;
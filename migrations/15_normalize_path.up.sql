@@ -0,0 +1,13 @@
+-- Normalize stored paths the same way vault does at write time (NFC, no repeated slashes).
+-- Rows which become duplicates of already normalized ones are removed first.
+DELETE FROM resource_file a USING resource_file b
+WHERE a.resource_id = b.resource_id
+  AND a.file_hash = b.file_hash
+  AND a.path <> b.path
+  AND normalize(regexp_replace(a.path, '/{2,}', '/', 'g'), NFC) = b.path;
+
+UPDATE resource_file SET path = normalize(regexp_replace(path, '/{2,}', '/', 'g'), NFC)
+WHERE path <> normalize(regexp_replace(path, '/{2,}', '/', 'g'), NFC);
+
+UPDATE file SET path = normalize(regexp_replace(path, '/{2,}', '/', 'g'), NFC)
+WHERE path <> normalize(regexp_replace(path, '/{2,}', '/', 'g'), NFC);
@@ -0,0 +1,2 @@
+ALTER TABLE resource DROP COLUMN IF EXISTS lease_expires_at;
+ALTER TABLE resource DROP COLUMN IF EXISTS lease_owner;
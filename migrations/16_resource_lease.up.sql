@@ -0,0 +1,3 @@
+-- Lease of a resource taken by a worker replica, renewed while the job runs
+ALTER TABLE resource ADD COLUMN IF NOT EXISTS lease_owner TEXT;
+ALTER TABLE resource ADD COLUMN IF NOT EXISTS lease_expires_at TIMESTAMPTZ;
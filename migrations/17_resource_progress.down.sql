@@ -0,0 +1,9 @@
+DROP INDEX CONCURRENTLY IF EXISTS idx_resource_storing_progress_at;
+--gopg:split
+DROP TRIGGER IF EXISTS trg_resource_set_progress_at ON resource;
+--gopg:split
+DROP FUNCTION IF EXISTS set_resource_progress_at();
+--gopg:split
+ALTER TABLE resource DROP COLUMN IF EXISTS stalled_at;
+--gopg:split
+ALTER TABLE resource DROP COLUMN IF EXISTS progress_at;
@@ -0,0 +1,27 @@
+-- progress_at is the last moment a resource changed status or stored_size, so stalled storing can be told
+-- apart from slow storing (updated_at is bumped by lease renewals too). stalled_at is set once a stalled
+-- resource is reported and cleared on progress.
+ALTER TABLE resource ADD COLUMN IF NOT EXISTS progress_at TIMESTAMPTZ;
+--gopg:split
+ALTER TABLE resource ADD COLUMN IF NOT EXISTS stalled_at TIMESTAMPTZ;
+--gopg:split
+UPDATE resource SET progress_at = updated_at WHERE progress_at IS NULL;
+--gopg:split
+CREATE OR REPLACE FUNCTION set_resource_progress_at()
+RETURNS TRIGGER AS $$
+BEGIN
+  IF TG_OP = 'INSERT' OR NEW.status IS DISTINCT FROM OLD.status OR NEW.stored_size IS DISTINCT FROM OLD.stored_size THEN
+    NEW.progress_at = NOW();
+    NEW.stalled_at = NULL;
+  END IF;
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+--gopg:split
+DROP TRIGGER IF EXISTS trg_resource_set_progress_at ON resource;
+--gopg:split
+CREATE TRIGGER trg_resource_set_progress_at
+BEFORE INSERT OR UPDATE ON resource
+FOR EACH ROW EXECUTE FUNCTION set_resource_progress_at();
+--gopg:split
+CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_resource_storing_progress_at ON resource(progress_at) WHERE status = 1;
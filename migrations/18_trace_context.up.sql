@@ -0,0 +1,2 @@
+-- W3C trace context of the API call which last queued the resource, continued by the worker
+ALTER TABLE resource ADD COLUMN IF NOT EXISTS trace_context JSONB;
@@ -0,0 +1 @@
+ALTER TABLE resource DROP COLUMN IF EXISTS max_store_duration;
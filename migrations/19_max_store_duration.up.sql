@@ -0,0 +1,2 @@
+-- Max store duration of the resource in seconds, storing taking longer is failed
+ALTER TABLE resource ADD COLUMN IF NOT EXISTS max_store_duration BIGINT;
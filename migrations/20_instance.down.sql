@@ -0,0 +1,2 @@
+ALTER TABLE log DROP COLUMN IF EXISTS instance_id;
+DROP TABLE IF EXISTS instance;
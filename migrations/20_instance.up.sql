@@ -0,0 +1,11 @@
+-- Running vault instances, heartbeat_at is bumped periodically while the instance is alive
+CREATE TABLE IF NOT EXISTS instance (
+  instance_id  TEXT        NOT NULL PRIMARY KEY, -- hostname-uuid unless set with WORKER_ID
+  hostname     TEXT        NOT NULL,
+  workers      INTEGER     NOT NULL DEFAULT 0,
+  started_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  heartbeat_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Instance which ran the operation
+ALTER TABLE log ADD COLUMN IF NOT EXISTS instance_id TEXT;
@@ -0,0 +1,5 @@
+DROP INDEX CONCURRENTLY IF EXISTS idx_resource_pending_purge_at;
+--gopg:split
+ALTER TABLE file DROP COLUMN IF EXISTS purge_at;
+--gopg:split
+ALTER TABLE resource DROP COLUMN IF EXISTS purge_at;
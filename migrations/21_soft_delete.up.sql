@@ -0,0 +1,6 @@
+-- purge_at is the moment a soft deleted (pending_purge) resource or file is removed for good
+ALTER TABLE resource ADD COLUMN IF NOT EXISTS purge_at TIMESTAMPTZ;
+--gopg:split
+ALTER TABLE file ADD COLUMN IF NOT EXISTS purge_at TIMESTAMPTZ;
+--gopg:split
+CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_resource_pending_purge_at ON resource(purge_at) WHERE status = 8;
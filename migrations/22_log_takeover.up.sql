@@ -0,0 +1,2 @@
+-- Instance whose job was taken over by the instance running the operation
+ALTER TABLE log ADD COLUMN IF NOT EXISTS taken_over_from TEXT;
@@ -0,0 +1,2 @@
+ALTER TABLE resource DROP COLUMN IF EXISTS store_paths;
+DROP TABLE IF EXISTS resource_file_error;
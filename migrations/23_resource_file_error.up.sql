@@ -0,0 +1,11 @@
+-- Files of a resource which failed to store, cleared once the file is stored
+CREATE TABLE IF NOT EXISTS resource_file_error (
+  resource_id TEXT        NOT NULL REFERENCES resource(resource_id) ON DELETE CASCADE,
+  path        TEXT        NOT NULL,
+  error       TEXT        NOT NULL,
+  updated_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (resource_id, path)
+);
+
+-- Paths a store job is limited to, other files of the resource are expected to be stored already
+ALTER TABLE resource ADD COLUMN IF NOT EXISTS store_paths TEXT[];
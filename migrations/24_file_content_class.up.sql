@@ -0,0 +1,13 @@
+-- Coarse kind of file content (video, audio, image, subtitle, archive, other) detected at store time
+ALTER TABLE file ADD COLUMN IF NOT EXISTS content_class TEXT;
+
+-- Classify already stored files by extension
+UPDATE file SET content_class = CASE
+  WHEN lower(path) ~ '\.(mkv|mp4|m4v|avi|mov|wmv|webm|ts|m2ts|mpg|mpeg|flv)$' THEN 'video'
+  WHEN lower(path) ~ '\.(mp3|flac|m4a|aac|ogg|opus|wav|ape)$' THEN 'audio'
+  WHEN lower(path) ~ '\.(jpg|jpeg|png|gif|webp|bmp)$' THEN 'image'
+  WHEN lower(path) ~ '\.(srt|vtt|ass|ssa|sub|idx|sup)$' THEN 'subtitle'
+  WHEN lower(path) ~ '\.(zip|rar|7z|tar|gz|bz2|xz|iso)$' THEN 'archive'
+  ELSE 'other'
+END
+WHERE content_class IS NULL AND path IS NOT NULL;
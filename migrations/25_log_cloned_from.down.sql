@@ -0,0 +1 @@
+ALTER TABLE log DROP COLUMN IF EXISTS cloned_from;
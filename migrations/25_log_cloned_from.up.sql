@@ -0,0 +1,2 @@
+-- Resource a cloned resource links the same files of
+ALTER TABLE log ADD COLUMN IF NOT EXISTS cloned_from TEXT;
@@ -0,0 +1,2 @@
+ALTER TABLE file DROP COLUMN IF EXISTS archive_indexed_at;
+DROP TABLE IF EXISTS archive_entry;
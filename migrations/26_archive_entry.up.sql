@@ -0,0 +1,14 @@
+-- Entries of stored zip/rar files, listed from central directory or file headers
+CREATE TABLE IF NOT EXISTS archive_entry (
+  file_hash   TEXT        NOT NULL REFERENCES file(hash) ON DELETE CASCADE,
+  name        TEXT        NOT NULL,
+  is_dir      BOOLEAN     NOT NULL DEFAULT FALSE,
+  size        BIGINT      NOT NULL DEFAULT 0,
+  packed_size BIGINT      NOT NULL DEFAULT 0,
+  modified_at TIMESTAMPTZ,
+  encrypted   BOOLEAN     NOT NULL DEFAULT FALSE,
+  PRIMARY KEY (file_hash, name)
+);
+
+-- When entries of the archive file were indexed, NULL if never
+ALTER TABLE file ADD COLUMN IF NOT EXISTS archive_indexed_at TIMESTAMPTZ;
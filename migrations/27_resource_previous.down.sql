@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS resource_previous_id_idx;
+ALTER TABLE resource DROP COLUMN IF EXISTS previous_id;
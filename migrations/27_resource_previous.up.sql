@@ -0,0 +1,3 @@
+-- Previous version of a resource updated from a mutable source, e.g. a re-announced season pack
+ALTER TABLE resource ADD COLUMN IF NOT EXISTS previous_id TEXT;
+CREATE INDEX IF NOT EXISTS resource_previous_id_idx ON resource (previous_id) WHERE previous_id IS NOT NULL;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS collection_resource;
+DROP TABLE IF EXISTS collection;
@@ -0,0 +1,17 @@
+-- Named groups of resources managed as a library
+CREATE TABLE IF NOT EXISTS collection (
+  collection_id uuid DEFAULT uuid_generate_v4() NOT NULL PRIMARY KEY,
+  name          TEXT        NOT NULL,
+  description   TEXT,
+  created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at    TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS collection_resource (
+  collection_id uuid        NOT NULL REFERENCES collection(collection_id) ON DELETE CASCADE,
+  resource_id   TEXT        NOT NULL REFERENCES resource(resource_id) ON DELETE CASCADE,
+  added_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (collection_id, resource_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_collection_resource_resource_id ON collection_resource(resource_id);
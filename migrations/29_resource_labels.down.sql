@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS resource_labels_idx;
+ALTER TABLE resource DROP COLUMN IF EXISTS labels;
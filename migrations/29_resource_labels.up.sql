@@ -0,0 +1,3 @@
+-- Labels of the resource as a flat string map, e.g. {"tenant": "x", "tier": "cold"}, matched by label selectors
+ALTER TABLE resource ADD COLUMN IF NOT EXISTS labels JSONB;
+CREATE INDEX IF NOT EXISTS resource_labels_idx ON resource USING GIN (labels);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS bulk_operation_item;
+DROP TABLE IF EXISTS bulk_operation;
@@ -0,0 +1,31 @@
+-- Asynchronous actions on resources matched by a label selector
+CREATE TABLE IF NOT EXISTS bulk_operation (
+  bulk_id          uuid DEFAULT uuid_generate_v4() NOT NULL PRIMARY KEY,
+  action           TEXT        NOT NULL,
+  selector         TEXT        NOT NULL,
+  params           JSONB,
+  status           TEXT        NOT NULL DEFAULT 'queued',
+  total            INT,                 -- number of matched resources, NULL until resolved
+  processed        INT         NOT NULL DEFAULT 0,
+  failed           INT         NOT NULL DEFAULT 0,
+  location         TEXT,                -- where the export is written
+  error            TEXT,
+  request_id       TEXT,
+  lease_owner      TEXT,
+  lease_expires_at TIMESTAMPTZ,
+  created_at       TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  started_at       TIMESTAMPTZ,
+  finished_at      TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_bulk_operation_pending ON bulk_operation(created_at) WHERE status IN ('queued', 'running');
+
+-- Resources matched by a bulk operation and per-resource results, pending until processed
+CREATE TABLE IF NOT EXISTS bulk_operation_item (
+  bulk_id     uuid        NOT NULL REFERENCES bulk_operation(bulk_id) ON DELETE CASCADE,
+  resource_id TEXT        NOT NULL,
+  result      TEXT        NOT NULL DEFAULT 'pending',
+  error       TEXT,
+  finished_at TIMESTAMPTZ,
+  PRIMARY KEY (bulk_id, resource_id)
+);
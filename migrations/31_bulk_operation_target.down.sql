@@ -0,0 +1,3 @@
+ALTER TABLE bulk_operation DROP COLUMN IF EXISTS target_status;
+UPDATE bulk_operation SET selector = '' WHERE selector IS NULL;
+ALTER TABLE bulk_operation ALTER COLUMN selector SET NOT NULL;
@@ -0,0 +1,3 @@
+-- Bulk operations target resources by label selector, by status or by explicit ids recorded as pending items
+ALTER TABLE bulk_operation ALTER COLUMN selector DROP NOT NULL;
+ALTER TABLE bulk_operation ADD COLUMN IF NOT EXISTS target_status TEXT;
@@ -0,0 +1,2 @@
+ALTER TABLE bulk_operation_item DROP COLUMN IF EXISTS params;
+ALTER TABLE resource DROP COLUMN IF EXISTS priority;
@@ -0,0 +1,4 @@
+-- Queue order of resources, higher priority resources are picked up for storing first
+ALTER TABLE resource ADD COLUMN IF NOT EXISTS priority INT NOT NULL DEFAULT 0;
+-- Per-resource parameters of bulk operations, e.g. labels and policy of an imported row
+ALTER TABLE bulk_operation_item ADD COLUMN IF NOT EXISTS params JSONB;
@@ -0,0 +1,11 @@
+-- Responses of mutating requests sent with Idempotency-Key header, replayed to duplicate submissions
+CREATE TABLE IF NOT EXISTS idempotency_key (
+  key          TEXT        NOT NULL PRIMARY KEY,
+  request_hash TEXT        NOT NULL, -- sha256 of method, path and body of the original request
+  status       INT,                  -- NULL while the original request is in progress
+  content_type TEXT,
+  body         BYTEA,
+  created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_idempotency_key_created_at ON idempotency_key(created_at);
@@ -0,0 +1,16 @@
+-- Copies of stored files in the replica bucket, pending rows are picked up by workers
+CREATE TABLE IF NOT EXISTS file_replica (
+  file_hash        TEXT        NOT NULL REFERENCES file(hash) ON DELETE CASCADE,
+  bucket           TEXT        NOT NULL,
+  status           TEXT        NOT NULL DEFAULT 'pending', -- pending, done, failed
+  attempts         INT         NOT NULL DEFAULT 0,
+  error            TEXT,
+  next_attempt_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  lease_owner      TEXT,
+  lease_expires_at TIMESTAMPTZ,
+  created_at       TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  replicated_at    TIMESTAMPTZ,
+  PRIMARY KEY (file_hash, bucket)
+);
+
+CREATE INDEX IF NOT EXISTS idx_file_replica_pending ON file_replica(next_attempt_at) WHERE status <> 'done';
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_file_storing_resource_id;
+ALTER TABLE file DROP COLUMN IF EXISTS storing_resource_id;
@@ -0,0 +1,4 @@
+-- Resource whose store job uploads the file, abandoned uploads are removed when the resource is purged
+ALTER TABLE file ADD COLUMN IF NOT EXISTS storing_resource_id TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_file_storing_resource_id ON file(storing_resource_id) WHERE storing_resource_id IS NOT NULL;
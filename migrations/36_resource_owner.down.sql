@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_resource_owner;
+ALTER TABLE resource DROP COLUMN IF EXISTS owner;
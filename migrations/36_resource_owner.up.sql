@@ -0,0 +1,4 @@
+-- Tenant owning the resource, NULL for resources stored without a tenant token
+ALTER TABLE resource ADD COLUMN IF NOT EXISTS owner TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_resource_owner ON resource(owner) WHERE owner IS NOT NULL;
@@ -0,0 +1,2 @@
+ALTER TABLE resource DROP COLUMN IF EXISTS annotations;
+ALTER TABLE resource DROP COLUMN IF EXISTS name;
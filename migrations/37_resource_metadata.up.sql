@@ -0,0 +1,3 @@
+-- Human readable name and arbitrary JSON annotations attached to the resource by upstream services
+ALTER TABLE resource ADD COLUMN IF NOT EXISTS name TEXT;
+ALTER TABLE resource ADD COLUMN IF NOT EXISTS annotations JSONB;
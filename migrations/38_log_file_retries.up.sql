@@ -0,0 +1,2 @@
+-- Number of file downloads retried by the store operation after transient torrent http proxy errors
+ALTER TABLE log ADD COLUMN IF NOT EXISTS file_retries BIGINT NOT NULL DEFAULT 0;
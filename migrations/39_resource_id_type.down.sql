@@ -0,0 +1 @@
+ALTER TABLE resource DROP COLUMN IF EXISTS id_type;
@@ -0,0 +1,2 @@
+-- Scheme of resource_id: infohash, infohash_v2, uuid or cid. Existing resources are v1 infohashes
+ALTER TABLE resource ADD COLUMN IF NOT EXISTS id_type TEXT NOT NULL DEFAULT 'infohash';
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS s3_delete_audit (
+  audit_id    uuid DEFAULT uuid_generate_v4() NOT NULL PRIMARY KEY,
+  bucket      TEXT        NOT NULL,
+  key         TEXT        NOT NULL,
+  size        BIGINT      NOT NULL DEFAULT 0,
+  reason      TEXT        NOT NULL, -- refcount-zero, temporary, key-migration, abandoned
+  resource_id TEXT,                 -- resource which triggered deletion, if any
+  created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_s3_delete_audit_key ON s3_delete_audit(key);
+CREATE INDEX IF NOT EXISTS idx_s3_delete_audit_resource ON s3_delete_audit(resource_id);
+CREATE INDEX IF NOT EXISTS idx_s3_delete_audit_created_at ON s3_delete_audit(created_at);
@@ -0,0 +1,10 @@
+-- Webseed usage per resource (path '') and per file of the resource, accumulated by web replicas
+CREATE TABLE IF NOT EXISTS resource_access_stats (
+  resource_id       TEXT        NOT NULL REFERENCES resource(resource_id) ON DELETE CASCADE,
+  path              TEXT        NOT NULL,
+  requests          BIGINT      NOT NULL DEFAULT 0,
+  bytes_served      BIGINT      NOT NULL DEFAULT 0,
+  first_accessed_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  last_accessed_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (resource_id, path)
+);
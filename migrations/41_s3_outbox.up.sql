@@ -0,0 +1,19 @@
+-- S3 deletions recorded in the same transaction as DB changes requiring them, executed by workers afterwards
+CREATE TABLE IF NOT EXISTS s3_outbox (
+  outbox_id        BIGSERIAL   PRIMARY KEY,
+  bucket           TEXT        NOT NULL,
+  key              TEXT        NOT NULL,
+  size             BIGINT      NOT NULL DEFAULT 0,
+  reason           TEXT        NOT NULL,
+  resource_id      TEXT,
+  file_hash        TEXT,       -- deletion is dropped if the file is stored again before it runs
+  attempts         INT         NOT NULL DEFAULT 0,
+  error            TEXT,
+  next_attempt_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  lease_owner      TEXT,
+  lease_expires_at TIMESTAMPTZ,
+  trace_context    JSONB,      -- trace context of the job which recorded the deletion, continued when it runs
+  created_at       TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_s3_outbox_next_attempt ON s3_outbox(next_attempt_at);
@@ -0,0 +1,4 @@
+ALTER TABLE s3_outbox DROP COLUMN IF EXISTS chunk_hash;
+ALTER TABLE file DROP COLUMN IF EXISTS chunked;
+DROP TABLE IF EXISTS file_chunk;
+DROP TABLE IF EXISTS chunk;
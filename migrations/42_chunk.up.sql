@@ -0,0 +1,24 @@
+-- Content-defined chunks of files stored in chunking mode, shared by files containing the same content
+CREATE TABLE IF NOT EXISTS chunk (
+  hash       TEXT        PRIMARY KEY, -- sha256 of chunk content
+  size       BIGINT      NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Chunks making up a file in order, chunks referenced by files can't be deleted
+CREATE TABLE IF NOT EXISTS file_chunk (
+  file_hash    TEXT   NOT NULL REFERENCES file(hash) ON DELETE CASCADE,
+  seq          INT    NOT NULL,
+  chunk_offset BIGINT NOT NULL,
+  size         BIGINT NOT NULL,
+  chunk_hash   TEXT   NOT NULL REFERENCES chunk(hash) ON DELETE RESTRICT,
+  PRIMARY KEY (file_hash, seq)
+);
+
+CREATE INDEX IF NOT EXISTS idx_file_chunk_chunk ON file_chunk(chunk_hash);
+
+-- Chunked files have no object of their own, content is assembled from file_chunk
+ALTER TABLE file ADD COLUMN IF NOT EXISTS chunked BOOLEAN NOT NULL DEFAULT false;
+
+-- Deletion of a chunk object is dropped while files reference the chunk
+ALTER TABLE s3_outbox ADD COLUMN IF NOT EXISTS chunk_hash TEXT;
@@ -0,0 +1,24 @@
+-- Mutating API requests with identity of the caller, kept for abuse investigations
+CREATE TABLE IF NOT EXISTS api_audit (
+  audit_id    uuid DEFAULT uuid_generate_v4() NOT NULL PRIMARY KEY,
+  method      TEXT        NOT NULL,
+  path        TEXT        NOT NULL,
+  route       TEXT        NOT NULL, -- route pattern, e.g. /resource/:id
+  status      INT         NOT NULL,
+  resource_id TEXT,                 -- resource the request addressed, if any
+  subject     TEXT,                 -- JWT subject, tenant of scoped tokens
+  role        TEXT,                 -- role of webtor api JWTs
+  token_id    TEXT,                 -- id of the scoped token
+  session_id  TEXT,                 -- sessionID claim of webtor api JWTs
+  client_ip   TEXT        NOT NULL, -- IP the request came from
+  remote_addr TEXT,                 -- remoteAddress claim of webtor api JWTs, address of the end user
+  user_agent  TEXT,
+  request_id  TEXT,
+  created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_audit_created_at ON api_audit(created_at);
+CREATE INDEX IF NOT EXISTS idx_api_audit_resource ON api_audit(resource_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_api_audit_subject ON api_audit(subject, created_at);
+CREATE INDEX IF NOT EXISTS idx_api_audit_client_ip ON api_audit(client_ip, created_at);
+CREATE INDEX IF NOT EXISTS idx_api_audit_remote_addr ON api_audit(remote_addr, created_at);
@@ -0,0 +1,2 @@
+ALTER TABLE bulk_operation DROP COLUMN IF EXISTS last_accessed_before;
+ALTER TABLE bulk_operation DROP COLUMN IF EXISTS created_before;
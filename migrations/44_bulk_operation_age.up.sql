@@ -0,0 +1,3 @@
+-- Bulk operations (purge) also target resources by age and by last access
+ALTER TABLE bulk_operation ADD COLUMN IF NOT EXISTS created_before TIMESTAMPTZ;
+ALTER TABLE bulk_operation ADD COLUMN IF NOT EXISTS last_accessed_before TIMESTAMPTZ;
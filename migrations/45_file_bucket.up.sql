@@ -0,0 +1,2 @@
+-- Bucket the file object is stored in, NULL for files stored before bucket routing (default bucket)
+ALTER TABLE file ADD COLUMN IF NOT EXISTS bucket TEXT;
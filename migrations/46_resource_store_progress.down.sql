@@ -0,0 +1,12 @@
+CREATE OR REPLACE FUNCTION set_resource_progress_at()
+RETURNS TRIGGER AS $$
+BEGIN
+  IF TG_OP = 'INSERT' OR NEW.status IS DISTINCT FROM OLD.status OR NEW.stored_size IS DISTINCT FROM OLD.stored_size THEN
+    NEW.progress_at = NOW();
+    NEW.stalled_at = NULL;
+  END IF;
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+--gopg:split
+ALTER TABLE resource DROP COLUMN IF EXISTS progress;
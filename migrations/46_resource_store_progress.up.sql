@@ -0,0 +1,15 @@
+-- progress of the running store job (phase, file counts, files in progress), NULL once the job ends
+ALTER TABLE resource ADD COLUMN IF NOT EXISTS progress JSONB;
+--gopg:split
+-- hashing moves progress too, so long hashing is not reported as stalled
+CREATE OR REPLACE FUNCTION set_resource_progress_at()
+RETURNS TRIGGER AS $$
+BEGIN
+  IF TG_OP = 'INSERT' OR NEW.status IS DISTINCT FROM OLD.status OR NEW.stored_size IS DISTINCT FROM OLD.stored_size
+    OR (NEW.progress IS NOT NULL AND NEW.progress IS DISTINCT FROM OLD.progress) THEN
+    NEW.progress_at = NOW();
+    NEW.stalled_at = NULL;
+  END IF;
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
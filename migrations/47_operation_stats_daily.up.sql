@@ -0,0 +1,12 @@
+-- Daily rollup of operation logs, finished logs are added here before they are pruned
+CREATE TABLE IF NOT EXISTS operation_stats_daily (
+  day             DATE     NOT NULL, -- UTC day the operations finished
+  operation_type  SMALLINT NOT NULL, -- 0 - store, 1 - delete
+  success         BIGINT   NOT NULL DEFAULT 0,
+  fail            BIGINT   NOT NULL DEFAULT 0,
+  retries         BIGINT   NOT NULL DEFAULT 0, -- manual retries of failed operations
+  file_retries    BIGINT   NOT NULL DEFAULT 0,
+  duration_ms     BIGINT   NOT NULL DEFAULT 0, -- total duration of successful and failed operations
+  max_duration_ms BIGINT   NOT NULL DEFAULT 0,
+  PRIMARY KEY (day, operation_type)
+);
@@ -0,0 +1,5 @@
+ALTER TABLE resource DROP COLUMN IF EXISTS restore_requested_at;
+--gopg:split
+ALTER TABLE resource DROP COLUMN IF EXISTS archived_at;
+--gopg:split
+ALTER TABLE resource DROP COLUMN IF EXISTS archive_storage_class;
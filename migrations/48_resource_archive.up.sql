@@ -0,0 +1,7 @@
+-- Archived resources (status 9) have objects moved to archive_storage_class, restoring ones (status 10)
+-- wait for S3 restore requests issued at restore_requested_at
+ALTER TABLE resource ADD COLUMN IF NOT EXISTS archive_storage_class TEXT;
+--gopg:split
+ALTER TABLE resource ADD COLUMN IF NOT EXISTS archived_at TIMESTAMPTZ;
+--gopg:split
+ALTER TABLE resource ADD COLUMN IF NOT EXISTS restore_requested_at TIMESTAMPTZ;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_collection_owner;
+--gopg:split
+ALTER TABLE collection DROP COLUMN IF EXISTS owner;
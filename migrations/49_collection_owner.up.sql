@@ -0,0 +1,4 @@
+-- Collections created with a scoped token are owned by its tenant
+ALTER TABLE collection ADD COLUMN IF NOT EXISTS owner TEXT;
+--gopg:split
+CREATE INDEX IF NOT EXISTS idx_collection_owner ON collection(owner);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_resource_legal_hold;
+
+ALTER TABLE resource DROP COLUMN IF EXISTS legal_hold_at;
+ALTER TABLE resource DROP COLUMN IF EXISTS legal_hold_reason;
+ALTER TABLE resource DROP COLUMN IF EXISTS legal_hold;
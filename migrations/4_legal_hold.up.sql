@@ -0,0 +1,5 @@
+ALTER TABLE resource ADD COLUMN IF NOT EXISTS legal_hold BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE resource ADD COLUMN IF NOT EXISTS legal_hold_reason TEXT;
+ALTER TABLE resource ADD COLUMN IF NOT EXISTS legal_hold_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_resource_legal_hold ON resource(legal_hold) WHERE legal_hold;
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS takedown (
+  takedown_id      uuid DEFAULT uuid_generate_v4() NOT NULL PRIMARY KEY,
+  resource_id      TEXT        NOT NULL,
+  reason_code      TEXT        NOT NULL, -- e.g. dmca, abuse, illegal
+  policy           TEXT        NOT NULL, -- delete or block
+  reporter         TEXT,
+  reporter_contact TEXT,
+  notice_url       TEXT,
+  notes            TEXT,
+  requested_by     TEXT,                 -- session id of the admin who filed the takedown
+  remote_address   TEXT,
+  created_at       TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_takedown_resource ON takedown(resource_id);
+CREATE INDEX IF NOT EXISTS idx_takedown_created_at ON takedown(created_at);
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS blocklist (
+  infohash   TEXT        NOT NULL PRIMARY KEY, -- lowercase hex infohash
+  reason     TEXT,
+  source     TEXT        NOT NULL DEFAULT 'manual', -- manual or remote (synced from blocklist url)
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_blocklist_source ON blocklist(source);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_file_size_path_algo;
+
+ALTER TABLE file DROP COLUMN IF EXISTS hash_algo;
@@ -0,0 +1,4 @@
+-- Existing files were hashed with size + first/last 500KB sampling
+ALTER TABLE file ADD COLUMN IF NOT EXISTS hash_algo TEXT NOT NULL DEFAULT 'sampled';
+
+CREATE INDEX IF NOT EXISTS idx_file_size_path_algo ON file(total_size, path, hash_algo);
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS api_token (
+  token_id   uuid DEFAULT uuid_generate_v4() NOT NULL PRIMARY KEY, -- jti claim of issued token
+  tenant     TEXT        NOT NULL,
+  scopes     TEXT[]      NOT NULL,
+  note       TEXT,
+  created_by TEXT,                 -- session id of the admin who minted the token
+  expires_at TIMESTAMPTZ NOT NULL,
+  revoked_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_token_tenant ON api_token(tenant);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_resource_expires_at;
+
+ALTER TABLE resource DROP COLUMN IF EXISTS expires_at;
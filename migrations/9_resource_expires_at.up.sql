@@ -0,0 +1,3 @@
+ALTER TABLE resource ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_resource_expires_at ON resource(expires_at) WHERE expires_at IS NOT NULL;
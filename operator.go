@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+
+	cs "github.com/webtor-io/common-services"
+	"github.com/webtor-io/vault/services"
+)
+
+func configureOperator(c *cli.Command) {
+	c.Flags = services.RegisterConfigFlags(c.Flags)
+	c.Flags = cs.RegisterPGFlags(c.Flags)
+	c.Flags = services.RegisterPGPoolFlags(c.Flags)
+	c.Flags = services.RegisterOperatorFlags(c.Flags)
+}
+
+func makeStoreCMD() cli.Command {
+	storeCmd := cli.Command{
+		Name:      "store",
+		Usage:     "Queues storing of resources and waits until they are stored, exits non-zero if any fails",
+		ArgsUsage: "<infohash> [<infohash>...]",
+		Action: func(c *cli.Context) error {
+			return operate(c, (*services.Operator).Store)
+		},
+	}
+	configureOperator(&storeCmd)
+	storeCmd.Flags = services.RegisterOperatorStoreFlags(storeCmd.Flags)
+	return storeCmd
+}
+
+func makeRmCMD() cli.Command {
+	rmCmd := cli.Command{
+		Name:      "rm",
+		Usage:     "Queues deletion of resources and waits until they are deleted, exits non-zero if any fails",
+		ArgsUsage: "<infohash> [<infohash>...]",
+		Action: func(c *cli.Context) error {
+			return operate(c, (*services.Operator).Remove)
+		},
+	}
+	configureOperator(&rmCmd)
+	return rmCmd
+}
+
+func makePurgeCMD() cli.Command {
+	purgeCmd := cli.Command{
+		Name:   "purge",
+		Usage:  "Queues deletion of resources matching status, age or last access and waits until it finishes, --dry-run only counts them",
+		Action: purge,
+	}
+	configureOperator(&purgeCmd)
+	purgeCmd.Flags = services.RegisterOperatorPurgeFlags(purgeCmd.Flags)
+	return purgeCmd
+}
+
+// newOperator returns operator of command flags, close releases DB of --direct.
+func newOperator(c *cli.Context) (o *services.Operator, closeFn func(), err error) {
+	closeFn = func() {}
+
+	// Setting Config
+	if _, err = services.LoadConfig(c); err != nil {
+		return nil, closeFn, err
+	}
+
+	// Setting DB
+	var pg *services.PG
+	if c.Bool("direct") {
+		pg = services.NewPG(c, services.DBRoleWorker)
+		closeFn = pg.Close
+	}
+
+	// Setting Operator
+	o, err = services.NewOperator(c, pg)
+	return o, closeFn, err
+}
+
+// purge prints the result of purge. Exit code is 1 if it failed or deletion of some resources failed
+// and 2 if waiting for it timed out.
+func purge(c *cli.Context) error {
+	req, err := services.PurgeRequestFromFlags(c)
+	if err != nil {
+		return err
+	}
+	o, closeFn, err := newOperator(c)
+	defer closeFn()
+	if err != nil {
+		return err
+	}
+	res, err := o.Purge(context.Background(), req)
+	if res != nil {
+		je := json.NewEncoder(os.Stdout)
+		je.SetIndent("", "  ")
+		if err := je.Encode(res); err != nil {
+			return err
+		}
+	}
+	if errors.Is(err, services.ErrWaitTimeout) {
+		return cli.NewExitError(err.Error(), 2)
+	}
+	if err != nil {
+		return err
+	}
+	if res.BulkOperation != nil && res.BulkOperation.Failed > 0 {
+		return cli.NewExitError("", 1)
+	}
+	return nil
+}
+
+// operate runs op for resources of command arguments and prints results. Exit code is 1 if any
+// resource failed and 2 if the others succeeded but waiting for some of them timed out.
+func operate(c *cli.Context, op func(s *services.Operator, ctx context.Context, ids []string) []services.OperatorResult) error {
+	ids := c.Args()
+	if len(ids) == 0 {
+		return errors.New("at least one resource id is required")
+	}
+
+	o, closeFn, err := newOperator(c)
+	defer closeFn()
+	if err != nil {
+		return err
+	}
+
+	results := op(o, context.Background(), ids)
+	je := json.NewEncoder(os.Stdout)
+	je.SetIndent("", "  ")
+	if err := je.Encode(results); err != nil {
+		return err
+	}
+	code := 0
+	for _, r := range results {
+		if r.OK {
+			continue
+		}
+		if !r.TimedOut {
+			code = 1
+		} else if code == 0 {
+			code = 2
+		}
+	}
+	if code != 0 {
+		return cli.NewExitError("", code)
+	}
+	return nil
+}
@@ -11,13 +11,59 @@ import (
 )
 
 func configureServe(c *cli.Command) {
+	c.Flags = services.RegisterConfigFlags(c.Flags)
 	c.Flags = cs.RegisterProbeFlags(c.Flags)
 	c.Flags = cs.RegisterPprofFlags(c.Flags)
+	c.Flags = cs.RegisterPromFlags(c.Flags)
 	c.Flags = cs.RegisterPGFlags(c.Flags)
-	c.Flags = cs.RegisterS3ClientFlags(c.Flags)
+	c.Flags = services.RegisterDBFlags(c.Flags)
+	c.Flags = services.RegisterPGPoolFlags(c.Flags)
+	c.Flags = services.RegisterS3Flags(c.Flags)
+	c.Flags = cs.RegisterRedisClientFlags(c.Flags)
+	c.Flags = services.RegisterLookupCacheFlags(c.Flags)
 	c.Flags = services.RegisterWebFlags(c.Flags)
+	c.Flags = services.RegisterChunkCacheFlags(c.Flags)
+	c.Flags = services.RegisterPresignFlags(c.Flags)
+	c.Flags = services.RegisterPreviewFlags(c.Flags)
+	c.Flags = services.RegisterAccessStatsFlags(c.Flags)
+	c.Flags = services.RegisterRateLimitFlags(c.Flags)
 	c.Flags = services.RegisterWorkerFlags(c.Flags)
+	c.Flags = services.RegisterInstanceFlags(c.Flags)
+	c.Flags = services.RegisterPurgeFlags(c.Flags)
+	c.Flags = services.RegisterHashFlags(c.Flags)
+	c.Flags = services.RegisterChunkingFlags(c.Flags)
 	c.Flags = services.RegisterApiFlags(c.Flags)
+	c.Flags = services.RegisterStoreLimitFlags(c.Flags)
+	c.Flags = services.RegisterEncryptionFlags(c.Flags)
+	c.Flags = services.RegisterObjectKeyFlags(c.Flags)
+	c.Flags = services.RegisterReplicaFlags(c.Flags)
+	c.Flags = services.RegisterCanaryFlags(c.Flags)
+	c.Flags = services.RegisterIdempotencyFlags(c.Flags)
+	c.Flags = services.RegisterTenantFlags(c.Flags)
+	c.Flags = services.RegisterStorageClassFlags(c.Flags)
+	c.Flags = services.RegisterObjectTagFlags(c.Flags)
+	c.Flags = services.RegisterContentClassFlags(c.Flags)
+	c.Flags = services.RegisterArchiveFlags(c.Flags)
+	c.Flags = services.RegisterTakedownFlags(c.Flags)
+	c.Flags = services.RegisterHealthFlags(c.Flags)
+	c.Flags = services.RegisterBlocklistFlags(c.Flags)
+	c.Flags = services.RegisterAbuseFlags(c.Flags)
+	c.Flags = services.RegisterEventsFlags(c.Flags)
+	c.Flags = services.RegisterStalledFlags(c.Flags)
+	c.Flags = services.RegisterTracingFlags(c.Flags)
+	c.Flags = services.RegisterScheduleFlags(c.Flags)
+	c.Flags = services.RegisterJobSchedulerFlags(c.Flags)
+	c.Flags = services.RegisterFileRetryFlags(c.Flags)
+	c.Flags = services.RegisterParallelDownloadFlags(c.Flags)
+	c.Flags = services.RegisterCircuitBreakerFlags(c.Flags)
+	c.Flags = services.RegisterBucketRouterFlags(c.Flags)
+	c.Flags = services.RegisterLogRetentionFlags(c.Flags)
+	c.Flags = services.RegisterArchivalFlags(c.Flags)
+	c.Flags = services.RegisterVerifyFlags(c.Flags)
+	c.Flags = services.RegisterAuthFlags(c.Flags)
+	c.Flags = services.RegisterSecretsFlags(c.Flags)
+	c.Flags = services.RegisterHCVaultFlags(c.Flags)
+	c.Flags = services.RegisterKMSFlags(c.Flags)
 }
 
 func makeServeCMD() cli.Command {
@@ -32,19 +78,41 @@ func makeServeCMD() cli.Command {
 }
 
 func serve(c *cli.Context) (err error) {
+	// Setting Config
+	cfg, err := services.LoadConfig(c)
+	if err != nil {
+		return err
+	}
+
 	// Setting DB
-	pg := cs.NewPG(c)
-	if pg != nil {
-		defer pg.Close()
+	pg := services.NewPG(c, services.DBRoleWeb)
+	defer pg.Close()
+
+	// Waiting for DB
+	err = services.WaitForDB(c, pg)
+	if err != nil {
+		return err
+	}
+
+	// Setting DB Read Replica
+	err = pg.UseReadReplica(c, services.DBRoleWeb)
+	if err != nil {
+		return err
 	}
 
 	// Setting Migrations
-	m := cs.NewPGMigration(pg)
+	mpg := cs.NewPG(c)
+	m := cs.NewPGMigration(mpg)
 	err = m.Run()
+	mpg.Close()
 	if err != nil {
 		return err
 	}
 
+	// Setting Worker DB
+	wpg := services.NewPG(c, services.DBRoleWorker)
+	defer wpg.Close()
+
 	var svcs []cs.Servable
 
 	// Setting Probe
@@ -61,24 +129,167 @@ func serve(c *cli.Context) (err error) {
 		defer pprof.Close()
 	}
 
+	// Setting Prometheus
+	prom := cs.NewProm(c)
+	if prom != nil {
+		svcs = append(svcs, prom)
+		defer prom.Close()
+	}
+
+	// Setting Tracing
+	tracing, err := services.NewTracing(c)
+	if err != nil {
+		return err
+	}
+	defer tracing.Close()
+
 	cl := http.DefaultClient
 
+	// Setting Secrets
+	secrets, err := services.NewSecrets(c, cl)
+	if err != nil {
+		return err
+	}
+	svcs = append(svcs, secrets)
+	defer secrets.Close()
+
 	// Setting S3Client
-	s3c := cs.NewS3Client(c, cl)
+	s3c, err := services.NewS3Client(c)
+	if err != nil {
+		return err
+	}
+	secrets.WatchS3(s3c)
+	if err := services.CheckS3(c, s3c); err != nil {
+		return err
+	}
+
+	// Setting RateLimiter
+	rl, err := services.NewRateLimiter(c)
+	if err != nil {
+		return err
+	}
+
+	// Setting ConfigReloader
+	cr := services.NewConfigReloader(c, cfg, rl)
+	if cr != nil {
+		svcs = append(svcs, cr)
+		defer cr.Close()
+	}
+
+	// Setting Encryption
+	enc, err := services.NewEncryption(c, secrets)
+	if err != nil {
+		return err
+	}
+
+	// Setting ObjectKeys
+	keys, err := services.NewObjectKeys(c)
+	if err != nil {
+		return err
+	}
+
+	// Setting Replica
+	replica, err := services.NewReplica(c, secrets, cl)
+	if err != nil {
+		return err
+	}
+
+	// Setting Auth
+	auth := services.NewAuth(c, pg, secrets)
+
+	// Setting Webtor Rest API
+	api := services.NewApi(c, cl, secrets)
+
+	// Setting CircuitBreaker
+	breaker, err := services.NewCircuitBreaker(c)
+	if err != nil {
+		return err
+	}
+
+	// Setting BucketRouter
+	router, err := services.NewBucketRouter(c)
+	if err != nil {
+		return err
+	}
+
+	// Setting Health
+	health := services.NewHealth(c, pg, s3c, api, breaker, router)
+
+	// Setting AbuseDetector
+	abuse := services.NewAbuseDetector(c, cl)
+
+	// Setting LookupCache
+	lc, err := services.NewLookupCache(c)
+	if err != nil {
+		return err
+	}
+	defer lc.Close()
+
+	// Setting ChunkCache
+	cc, err := services.NewChunkCache(c)
+	if err != nil {
+		return err
+	}
+
+	// Setting AccessStats
+	as := services.NewAccessStats(c, pg)
+	if as != nil {
+		svcs = append(svcs, as)
+		defer as.Close()
+	}
 
 	// Setting Web
-	web := services.NewWeb(c, pg, s3c)
+	web, err := services.NewWeb(c, pg, s3c, api, rl, enc, keys, replica, auth, health, abuse, lc, cc, as)
+	if err != nil {
+		return err
+	}
 	svcs = append(svcs, web)
 	defer web.Close()
 
-	// Setting Webtor Rest API
-	api := services.NewApi(c, cl)
+	// Setting Canary
+	canary, err := services.NewCanary(c, web, api)
+	if err != nil {
+		return err
+	}
+	if canary != nil {
+		svcs = append(svcs, canary)
+		defer canary.Close()
+	}
+
+	// Setting Events
+	events, err := services.NewEvents(c)
+	if err != nil {
+		return err
+	}
+	defer events.Close()
+
+	// Setting InstanceHeartbeat
+	inst := services.NewInstanceHeartbeat(c, wpg)
+	svcs = append(svcs, inst)
+	defer inst.Close()
 
 	// Setting Worker
-	worker := services.NewWorker(c, pg, s3c, api)
+	worker, err := services.NewWorker(c, wpg, s3c, api, enc, keys, replica, events, inst, breaker, router)
+	if err != nil {
+		return err
+	}
 	svcs = append(svcs, worker)
 	defer worker.Close()
 
+	// Setting BlocklistSync
+	bs := services.NewBlocklistSync(c, wpg, cl)
+	if bs != nil {
+		svcs = append(svcs, bs)
+		defer bs.Close()
+	}
+
+	// Setting StalledMonitor
+	sm := services.NewStalledMonitor(c, wpg, cl, events)
+	if sm != nil {
+		svcs = append(svcs, sm)
+		defer sm.Close()
+	}
+
 	// Setting Serve
 	s := cs.NewServe(svcs...)
 
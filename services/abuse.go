@@ -0,0 +1,246 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+const (
+	abuseWindowFlag            = "abuse-window"
+	abuseRegisterThresholdFlag = "abuse-register-threshold"
+	abuseDownloadThresholdFlag = "abuse-download-threshold"
+	abuseBlockDurationFlag     = "abuse-block-duration"
+	abuseWebhookURLFlag        = "abuse-webhook-url"
+)
+
+// RegisterAbuseFlags registers CLI flags for abuse rate anomaly detection.
+func RegisterAbuseFlags(f []cli.Flag) []cli.Flag {
+	return append(f,
+		cli.DurationFlag{
+			Name:   abuseWindowFlag,
+			Usage:  "window abuse thresholds are counted in",
+			Value:  time.Minute,
+			EnvVar: "ABUSE_WINDOW",
+		},
+		cli.IntFlag{
+			Name:   abuseRegisterThresholdFlag,
+			Usage:  "number of resources a single client ip may register per window before it is reported (0 disables)",
+			EnvVar: "ABUSE_REGISTER_THRESHOLD",
+		},
+		cli.Int64Flag{
+			Name:   abuseDownloadThresholdFlag,
+			Usage:  "number of webseed bytes a single client ip may download per window before it is reported (0 disables)",
+			EnvVar: "ABUSE_DOWNLOAD_THRESHOLD",
+		},
+		cli.DurationFlag{
+			Name:   abuseBlockDurationFlag,
+			Usage:  "temporarily block reported client ip for this period (0 disables blocking)",
+			EnvVar: "ABUSE_BLOCK_DURATION",
+		},
+		cli.StringFlag{
+			Name:   abuseWebhookURLFlag,
+			Usage:  "url abuse events are POSTed to as json",
+			EnvVar: "ABUSE_WEBHOOK_URL",
+		},
+	)
+}
+
+// AbuseKind is a kind of detected anomaly.
+type AbuseKind string
+
+const (
+	AbuseKindRegister AbuseKind = "register"
+	AbuseKindDownload AbuseKind = "download"
+)
+
+// AbuseEvent is emitted when a client exceeds an abuse threshold.
+type AbuseEvent struct {
+	Kind         AbuseKind  `json:"kind"`
+	IP           string     `json:"ip"`
+	Count        int64      `json:"count"`
+	Threshold    int64      `json:"threshold"`
+	Window       string     `json:"window"`
+	DetectedAt   time.Time  `json:"detected_at"`
+	BlockedUntil *time.Time `json:"blocked_until,omitempty"`
+}
+
+// abuseCounter counts events of a single client ip in fixed windows.
+type abuseCounter struct {
+	windowStart time.Time
+	counts      map[AbuseKind]int64
+	reported    map[AbuseKind]bool
+}
+
+// AbuseDetector tracks per-ip registration and download rates, reports anomalies
+// and optionally blocks offending clients for a while.
+type AbuseDetector struct {
+	cl            *http.Client
+	window        time.Duration
+	thresholds    map[AbuseKind]int64
+	blockDuration time.Duration
+	webhookURL    string
+	counters      map[string]*abuseCounter
+	blocked       map[string]time.Time
+	mux           sync.Mutex
+	lastSweep     time.Time
+}
+
+// NewAbuseDetector returns nil if all thresholds are disabled.
+func NewAbuseDetector(c *cli.Context, cl *http.Client) *AbuseDetector {
+	thresholds := map[AbuseKind]int64{}
+	if v := c.Int(abuseRegisterThresholdFlag); v > 0 {
+		thresholds[AbuseKindRegister] = int64(v)
+	}
+	if v := c.Int64(abuseDownloadThresholdFlag); v > 0 {
+		thresholds[AbuseKindDownload] = v
+	}
+	if len(thresholds) == 0 {
+		return nil
+	}
+	return &AbuseDetector{
+		cl:            cl,
+		window:        c.Duration(abuseWindowFlag),
+		thresholds:    thresholds,
+		blockDuration: c.Duration(abuseBlockDurationFlag),
+		webhookURL:    c.String(abuseWebhookURLFlag),
+		counters:      map[string]*abuseCounter{},
+		blocked:       map[string]time.Time{},
+		lastSweep:     time.Now(),
+	}
+}
+
+func (s *AbuseDetector) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < s.window {
+		return
+	}
+	for k, v := range s.counters {
+		if now.Sub(v.windowStart) > s.window {
+			delete(s.counters, k)
+		}
+	}
+	for k, v := range s.blocked {
+		if now.After(v) {
+			delete(s.blocked, k)
+		}
+	}
+	s.lastSweep = now
+}
+
+// Record accounts n events of the kind for the client ip. Nil detector is a no-op.
+func (s *AbuseDetector) Record(kind AbuseKind, ip string, n int64) {
+	if s == nil {
+		return
+	}
+	threshold, ok := s.thresholds[kind]
+	if !ok {
+		return
+	}
+	s.mux.Lock()
+	now := time.Now()
+	s.sweep(now)
+	cnt, ok := s.counters[ip]
+	if !ok || now.Sub(cnt.windowStart) > s.window {
+		cnt = &abuseCounter{windowStart: now, counts: map[AbuseKind]int64{}, reported: map[AbuseKind]bool{}}
+		s.counters[ip] = cnt
+	}
+	cnt.counts[kind] += n
+	if cnt.counts[kind] <= threshold || cnt.reported[kind] {
+		s.mux.Unlock()
+		return
+	}
+	cnt.reported[kind] = true
+	ev := &AbuseEvent{
+		Kind:       kind,
+		IP:         ip,
+		Count:      cnt.counts[kind],
+		Threshold:  threshold,
+		Window:     s.window.String(),
+		DetectedAt: now,
+	}
+	if s.blockDuration > 0 {
+		until := now.Add(s.blockDuration)
+		s.blocked[ip] = until
+		ev.BlockedUntil = &until
+	}
+	s.mux.Unlock()
+	s.emit(ev)
+}
+
+// Blocked reports whether the client ip is temporarily blocked and for how long.
+func (s *AbuseDetector) Blocked(ip string) (bool, time.Duration) {
+	if s == nil {
+		return false, 0
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	until, ok := s.blocked[ip]
+	if !ok {
+		return false, 0
+	}
+	d := time.Until(until)
+	if d <= 0 {
+		delete(s.blocked, ip)
+		return false, 0
+	}
+	return true, d
+}
+
+func (s *AbuseDetector) emit(ev *AbuseEvent) {
+	promAbuseAnomalies.WithLabelValues(string(ev.Kind)).Inc()
+	log.WithFields(log.Fields{
+		"kind":          ev.Kind,
+		"ip":            ev.IP,
+		"count":         ev.Count,
+		"threshold":     ev.Threshold,
+		"window":        ev.Window,
+		"blocked_until": ev.BlockedUntil,
+	}).Warn("abuse rate anomaly detected")
+	if s.webhookURL == "" {
+		return
+	}
+	go func() {
+		if err := s.postWebhook(ev); err != nil {
+			log.WithError(err).Error("failed to post abuse event")
+		}
+	}()
+}
+
+func (s *AbuseDetector) postWebhook(ev *AbuseEvent) error {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", s.webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := s.cl.Do(req)
+	if err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
+
+// abuseBlock is a gin middleware rejecting temporarily blocked clients with 429.
+func (s *Web) abuseBlock(c *gin.Context) {
+	blocked, d := s.abuse.Blocked(c.ClientIP())
+	if !blocked {
+		return
+	}
+	promAbuseBlockedRequests.Inc()
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(d.Seconds()))))
+	c.AbortWithStatus(http.StatusTooManyRequests)
+}
@@ -0,0 +1,220 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+const (
+	accessStatsFlushIntervalFlag = "access-stats-flush-interval"
+	accessStatsMaxKeysFlag       = "access-stats-max-keys"
+)
+
+// RegisterAccessStatsFlags registers CLI flags for webseed access stats.
+func RegisterAccessStatsFlags(f []cli.Flag) []cli.Flag {
+	return append(f,
+		cli.DurationFlag{
+			Name:   accessStatsFlushIntervalFlag,
+			Usage:  "how often webseed usage buffered in memory is added to resource_access_stats (0 disables stats)",
+			Value:  30 * time.Second,
+			EnvVar: "ACCESS_STATS_FLUSH_INTERVAL",
+		},
+		cli.IntFlag{
+			Name:   accessStatsMaxKeysFlag,
+			Usage:  "max number of files with usage buffered between flushes, requests for other files are not counted until the next flush",
+			Value:  100000,
+			EnvVar: "ACCESS_STATS_MAX_KEYS",
+		},
+	)
+}
+
+type accessStatKey struct {
+	id   string
+	path string
+}
+
+// AccessStats buffers webseed requests and bytes served per resource and file and periodically adds them
+// to resource_access_stats, so serving doesn't wait for DB.
+type AccessStats struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	pg       *PG
+	interval time.Duration
+	maxKeys  int
+	mux      sync.Mutex
+	buf      map[accessStatKey]*ResourceAccessStat
+}
+
+// NewAccessStats returns nil if stats are disabled.
+func NewAccessStats(c *cli.Context, pg *PG) *AccessStats {
+	interval := c.Duration(accessStatsFlushIntervalFlag)
+	if interval <= 0 {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &AccessStats{
+		ctx:      ctx,
+		cancel:   cancel,
+		pg:       pg,
+		interval: interval,
+		maxKeys:  c.Int(accessStatsMaxKeysFlag),
+		buf:      map[accessStatKey]*ResourceAccessStat{},
+	}
+}
+
+// Record counts a webseed request of the file which served n bytes.
+func (s *AccessStats) Record(id, path string, n int64) {
+	if s == nil {
+		return
+	}
+	now := time.Now()
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	for _, k := range []accessStatKey{{id: id}, {id: id, path: path}} {
+		st, ok := s.buf[k]
+		if !ok {
+			if len(s.buf) >= s.maxKeys {
+				promAccessStatsDropped.Inc()
+				return
+			}
+			st = &ResourceAccessStat{ResourceID: k.id, Path: k.path, FirstAccessedAt: now}
+			s.buf[k] = st
+		}
+		st.Requests++
+		st.BytesServed += n
+		st.LastAccessedAt = now
+	}
+}
+
+func (s *AccessStats) Serve() error {
+	log.Infof("flushing webseed access stats every %v", s.interval)
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			// usage buffered since the last tick is not lost on shutdown
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			s.flush(ctx)
+			cancel()
+			return nil
+		case <-ticker.C:
+			s.flush(s.ctx)
+		}
+	}
+}
+
+// flush adds buffered usage to DB. Usage which failed to be added is dropped, stats are advisory.
+func (s *AccessStats) flush(ctx context.Context) {
+	s.mux.Lock()
+	buf := s.buf
+	s.buf = map[accessStatKey]*ResourceAccessStat{}
+	s.mux.Unlock()
+	if len(buf) == 0 {
+		return
+	}
+	db := s.pg.Get()
+	if db == nil {
+		promAccessStatsDropped.Add(float64(len(buf)))
+		return
+	}
+	err := db.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		for _, st := range buf {
+			if err := ResourceAccessStatAdd(ctx, tx, st); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		promAccessStatsDropped.Add(float64(len(buf)))
+		log.WithError(err).WithField("keys", len(buf)).Warn("failed to flush access stats")
+	}
+}
+
+func (s *AccessStats) Close() {
+	if s != nil {
+		s.cancel()
+	}
+}
+
+// AccessStatsResponse is webseed usage of the resource and its files.
+type AccessStatsResponse struct {
+	ResourceID string `json:"resource_id"`
+	// Requests is the number of webseed requests which served content of the resource
+	Requests        int64                `json:"requests"`
+	BytesServed     int64                `json:"bytes_served"`
+	FirstAccessedAt *time.Time           `json:"first_accessed_at,omitempty"`
+	LastAccessedAt  *time.Time           `json:"last_accessed_at,omitempty"`
+	Files           []ResourceAccessStat `json:"files"`
+	Total           int                  `json:"total"`
+	Limit           int                  `json:"limit"`
+	Offset          int                  `json:"offset"`
+}
+
+// GET /resource/{id}/stats
+// getResourceStats godoc
+// @Summary      Webseed usage of resource
+// @Description  Returns requests and bytes served by webseed for the resource and a page of its accessed files, most bytes
+// @Description  served first. Usage is buffered by web replicas for ACCESS_STATS_FLUSH_INTERVAL before it shows up.
+// @Tags         resource
+// @Param        id      path      string  true   "Resource ID"
+// @Param        limit   query     int     false  "Page size of files (default 50, max 1000)"
+// @Param        offset  query     int     false  "Page offset of files"
+// @Success      200  {object}  AccessStatsResponse
+// @Failure      400  {object}  ErrorResponse
+// @Failure      404  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /resource/{id}/stats [get]
+func (s *Web) getResourceStats(c *gin.Context) {
+	db := s.pg.Read()
+	if db == nil {
+		_ = c.Error(errors.New("DB not configured"))
+		return
+	}
+	limit, offset, err := parseListLimits(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	ctx := c.Request.Context()
+	id := c.Param("id")
+	res, err := ResourceGetByID(ctx, db, id)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if res == nil {
+		_ = c.Error(errors.New("resource not found"))
+		return
+	}
+	r := &AccessStatsResponse{ResourceID: id, Limit: limit, Offset: offset}
+	st, err := ResourceAccessStatGet(ctx, db, id)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if st != nil {
+		r.Requests = st.Requests
+		r.BytesServed = st.BytesServed
+		r.FirstAccessedAt = &st.FirstAccessedAt
+		r.LastAccessedAt = &st.LastAccessedAt
+	}
+	r.Files, r.Total, err = ResourceAccessStatListFiles(ctx, db, id, limit, offset)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if r.Files == nil {
+		r.Files = []ResourceAccessStat{}
+	}
+	c.JSON(http.StatusOK, r)
+}
@@ -0,0 +1,81 @@
+package services
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// LegalHoldRequest is a body of legal hold request.
+type LegalHoldRequest struct {
+	Reason string `json:"reason"`
+}
+
+// PUT /admin/resource/{id}/legal-hold
+// putLegalHold godoc
+// @Summary      Put resource under legal hold
+// @Description  Blocks deletion, expiry, eviction and gc of the resource and its files until cleared
+// @Tags         admin
+// @Accept       json
+// @Param        id       path      string            true  "Resource ID"
+// @Param        request  body      LegalHoldRequest  false "Legal hold reason"
+// @Success      200      {object}  Resource
+// @Failure      401      {object}  ErrorResponse
+// @Failure      403      {object}  ErrorResponse
+// @Failure      404      {object}  ErrorResponse
+// @Failure      500      {object}  ErrorResponse
+// @Router       /admin/resource/{id}/legal-hold [put]
+func (s *Web) putLegalHold(c *gin.Context) {
+	db := s.pg.Get()
+	if db == nil {
+		_ = c.Error(errors.New("DB not configured"))
+		return
+	}
+	var req LegalHoldRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			_ = c.Error(errors.Wrap(err, "failed to parse legal hold request"))
+			return
+		}
+	}
+	res, err := ResourceSetLegalHold(c.Request.Context(), db, c.Param("id"), req.Reason)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if res == nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"resource": res})
+}
+
+// DELETE /admin/resource/{id}/legal-hold
+// deleteLegalHold godoc
+// @Summary      Clear legal hold of resource
+// @Tags         admin
+// @Param        id   path      string  true  "Resource ID"
+// @Success      200  {object}  Resource
+// @Failure      401  {object}  ErrorResponse
+// @Failure      403  {object}  ErrorResponse
+// @Failure      404  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /admin/resource/{id}/legal-hold [delete]
+func (s *Web) deleteLegalHold(c *gin.Context) {
+	db := s.pg.Get()
+	if db == nil {
+		_ = c.Error(errors.New("DB not configured"))
+		return
+	}
+	res, err := ResourceClearLegalHold(c.Request.Context(), db, c.Param("id"))
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if res == nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"resource": res})
+}
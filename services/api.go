@@ -18,6 +18,8 @@ import (
 	ra "github.com/webtor-io/rest-api/services"
 
 	"github.com/dgrijalva/jwt-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
@@ -104,6 +106,7 @@ type Api struct {
 	useInternalTorrentHTTPProxy bool
 	torrentHTTPProxyHost        string
 	torrentHTTPProxyPort        int
+	dl                          *StoreDownloadLimiter
 }
 
 type ListResourceContentOutputType string
@@ -141,11 +144,10 @@ func (s *ListResourceContentArgs) ToQuery() url.Values {
 	return q
 }
 
-func NewApi(c *cli.Context, cl *http.Client) *Api {
+func NewApi(c *cli.Context, cl *http.Client, secrets *Secrets) *Api {
 	host := c.String(apiHostFlag)
 	port := c.Int(apiPortFlag)
 	secure := c.Bool(apiSecureFlag)
-	secret := c.String(apiSecretFlag)
 	expire := c.Int(apiExpireFlag)
 	key := c.String(apiKeyFlag)
 	protocol := "http"
@@ -155,7 +157,7 @@ func NewApi(c *cli.Context, cl *http.Client) *Api {
 	u := fmt.Sprintf("%v://%v:%v", protocol, host, port)
 	prepareRequest := func(r *http.Request, cl *Claims) (*http.Request, error) {
 		token := jwt.NewWithClaims(jwt.SigningMethodHS256, cl)
-		tokenString, err := token.SignedString([]byte(secret))
+		tokenString, err := token.SignedString(secrets.WebtorSecret.Get())
 		if err != nil {
 			return nil, err
 		}
@@ -172,6 +174,7 @@ func NewApi(c *cli.Context, cl *http.Client) *Api {
 		useInternalTorrentHTTPProxy: c.Bool(useInternalTorrentHTTPProxyFlag),
 		torrentHTTPProxyHost:        c.String(torrentHTTPProxyHostFlag),
 		torrentHTTPProxyPort:        c.Int(torrentHTTPProxyPortFlag),
+		dl:                          NewStoreDownloadLimiter(c),
 	}
 }
 
@@ -182,7 +185,67 @@ func (s *Api) ListResourceContent(ctx context.Context, c *Claims, infohash strin
 	return
 }
 
+// StoreResource pushes magnet URI or .torrent content to rest-api and returns the resource it was stored as.
+func (s *Api) StoreResource(ctx context.Context, c *Claims, data []byte) (*ra.ResourceResponse, error) {
+	e := &ra.ResourceResponse{}
+	if err := s.doRequest(ctx, c, s.url+"/resource/", "POST", data, e); err != nil {
+		return nil, err
+	}
+	if e.ID == "" {
+		return nil, errors.New("rest-api returned no resource id")
+	}
+	return e, nil
+}
+
+// ListResourceFiles pages through resource content and returns all files.
+// Returns nil if resource is not found.
+func (s *Api) ListResourceFiles(ctx context.Context, c *Claims, infohash string) ([]ra.ListItem, error) {
+	args := &ListResourceContentArgs{Limit: 100}
+	var files []ra.ListItem
+	for {
+		resp, err := s.ListResourceContent(ctx, c, infohash, args)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range resp.Items {
+			if item.Type == ra.ListTypeFile {
+				files = append(files, item)
+			}
+		}
+		if len(resp.Items) == 0 || resp.Count-int(args.Offset) == len(resp.Items) {
+			break
+		}
+		args.Offset += args.Limit
+	}
+	return files, nil
+}
+
+// Ping checks that rest-api responds.
+func (s *Api) Ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", s.url+"/", nil)
+	if err != nil {
+		return err
+	}
+	res, err := s.cl.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = res.Body.Close()
+	if res.StatusCode >= http.StatusInternalServerError {
+		return errors.Errorf("rest-api responded with status=%v", res.StatusCode)
+	}
+	return nil
+}
+
 func (s *Api) doRequestRaw(ctx context.Context, c *Claims, url string, method string, data []byte) (res *http.Response, err error) {
+	ctx, span := tracer.Start(ctx, "rest-api "+method, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("url.full", url)))
+	defer func() {
+		if res != nil {
+			span.SetAttributes(attribute.Int("http.response.status_code", res.StatusCode))
+		}
+		endSpan(span, err)
+	}()
 	var payload io.Reader
 
 	if data != nil {
@@ -199,6 +262,10 @@ func (s *Api) doRequestRaw(ctx context.Context, c *Claims, url string, method st
 	if err != nil {
 		return
 	}
+	if id := RequestIDFromContext(ctx); id != "" {
+		req.Header.Set(requestIDHeader, id)
+	}
+	injectTraceHeaders(req)
 
 	res, err = s.cl.Do(req)
 	if err != nil {
@@ -253,6 +320,44 @@ func (s *Api) ExportResourceContent(ctx context.Context, c *Claims, infohash str
 	return
 }
 
+// ProxyError is a failed torrent http proxy request: an error status or a network error of the request
+// or of reading the body.
+type ProxyError struct {
+	// StatusCode of the response, 0 for network errors
+	StatusCode int
+	Err        error
+}
+
+func (e *ProxyError) Error() string {
+	if e.StatusCode != 0 {
+		return fmt.Sprintf("torrent http proxy responded with status=%v", e.StatusCode)
+	}
+	return e.Err.Error()
+}
+
+func (e *ProxyError) Unwrap() error {
+	return e.Err
+}
+
+// Retryable reports whether the request may succeed if repeated: network errors, 5xx and 429 are
+// transient, other 4xx are permanent.
+func (e *ProxyError) Retryable() bool {
+	return e.StatusCode == 0 || e.StatusCode >= http.StatusInternalServerError || e.StatusCode == http.StatusTooManyRequests
+}
+
+// proxyBody marks errors of reading the proxy response as ProxyError.
+type proxyBody struct {
+	io.ReadCloser
+}
+
+func (r *proxyBody) Read(b []byte) (int, error) {
+	n, err := r.ReadCloser.Read(b)
+	if err != nil && err != io.EOF {
+		err = &ProxyError{Err: err}
+	}
+	return n, err
+}
+
 func (s *Api) Download(ctx context.Context, u string) (io.ReadCloser, error) {
 	return s.DownloadWithRange(ctx, u, 0, -1)
 }
@@ -272,11 +377,27 @@ func (s *Api) makeTorrentHTTPProxyRequest(ctx context.Context, u string) (*http.
 }
 
 func (s *Api) DownloadWithRange(ctx context.Context, u string, start int, end int) (io.ReadCloser, error) {
+	rc, _, err := s.downloadRange(ctx, u, start, end)
+	return rc, err
+}
+
+// downloadRange also reports whether the proxy responded with the requested range only, proxies without
+// Range support respond with the whole content.
+func (s *Api) downloadRange(ctx context.Context, u string, start int, end int) (rc io.ReadCloser, partial bool, err error) {
+	ctx, span := tracer.Start(ctx, "torrent-http-proxy download", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.Int("range.start", start), attribute.Int("range.end", end)))
+	defer func() {
+		// on success span is ended when the body is closed
+		if err != nil {
+			endSpan(span, err)
+		}
+	}()
 	req, err := s.makeTorrentHTTPProxyRequest(ctx, u)
 	if err != nil {
 		log.WithError(err).Error("failed to make new request")
-		return nil, err
+		return nil, false, err
 	}
+	injectTraceHeaders(req)
 	if start != 0 || end != -1 {
 		startStr := strconv.Itoa(start)
 		endStr := ""
@@ -288,8 +409,12 @@ func (s *Api) DownloadWithRange(ctx context.Context, u string, start int, end in
 	res, err := s.cl.Do(req)
 	if err != nil {
 		log.WithError(err).Error("failed to do request")
-		return nil, err
+		return nil, false, &ProxyError{Err: err}
+	}
+	if res.StatusCode >= http.StatusBadRequest {
+		_ = res.Body.Close()
+		return nil, false, &ProxyError{StatusCode: res.StatusCode}
 	}
-	b := res.Body
-	return b, nil
+	b := &proxyBody{ReadCloser: res.Body}
+	return &spanReadCloser{ReadCloser: &readCloser{Reader: s.dl.Reader(ctx, b), Closer: b}, span: span}, res.StatusCode == http.StatusPartialContent, nil
 }
@@ -0,0 +1,139 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// auditedMethods are methods of mutating requests recorded in api_audit.
+var auditedMethods = map[string]bool{
+	http.MethodPost:   true,
+	http.MethodPut:    true,
+	http.MethodPatch:  true,
+	http.MethodDelete: true,
+}
+
+func optionalString(v string) *string {
+	if v == "" {
+		return nil
+	}
+	return &v
+}
+
+// auditResourceID returns the resource addressed by the route, empty if there is none.
+func auditResourceID(c *gin.Context) string {
+	if id := c.Param("resource_id"); id != "" {
+		return id
+	}
+	route := c.FullPath()
+	if strings.HasPrefix(route, "/resource/:id") || strings.HasPrefix(route, "/admin/resource/:id") || strings.HasPrefix(route, "/admin/takedown/:id") {
+		return c.Param("id")
+	}
+	return ""
+}
+
+// auditRequest is a gin middleware recording mutating requests with identity of the caller once they
+// are handled, rejected ones included. Requests to unknown routes are not recorded.
+func (s *Web) auditRequest(c *gin.Context) {
+	c.Next()
+	if !auditedMethods[c.Request.Method] || c.FullPath() == "" {
+		return
+	}
+	db := s.pg.Get()
+	if db == nil {
+		return
+	}
+	a := &ApiAudit{
+		Method:     c.Request.Method,
+		Path:       c.Request.URL.Path,
+		Route:      c.FullPath(),
+		Status:     c.Writer.Status(),
+		ResourceID: optionalString(auditResourceID(c)),
+		ClientIP:   c.ClientIP(),
+		UserAgent:  optionalString(c.Request.UserAgent()),
+		RequestID:  optionalString(RequestIDFromContext(c.Request.Context())),
+	}
+	if cl := s.auth.claims(c); cl != nil {
+		a.Subject = optionalString(cl.Subject)
+		a.Role = optionalString(cl.Role)
+		a.SessionID = optionalString(cl.SessionID)
+		a.RemoteAddr = optionalString(cl.RemoteAddress)
+	} else if tc := s.auth.tokenClaims(c); tc != nil {
+		a.Subject = optionalString(tc.Subject)
+		a.TokenID = optionalString(tc.Id)
+	}
+	// the request is handled already, failing to audit it is only logged
+	if err := ApiAuditAdd(context.WithoutCancel(c.Request.Context()), db, a); err != nil {
+		logger(c.Request.Context()).WithError(err).Warn("failed to record api audit")
+	}
+}
+
+// ApiAuditResponse is a page of api audit records.
+type ApiAuditResponse struct {
+	Records []ApiAudit `json:"records"`
+	Total   int        `json:"total"`
+	Limit   int        `json:"limit"`
+	Offset  int        `json:"offset"`
+}
+
+// GET /audit
+// getAudit godoc
+// @Summary      List audited API mutations
+// @Description  Returns POST/PUT/PATCH/DELETE requests with identity of the caller (JWT subject, role and session id,
+// @Description  remote address, tenant and id of scoped tokens), client IP and request id, newest first. Rejected requests
+// @Description  are recorded too.
+// @Tags         admin
+// @Param        resource_id  query     string  false  "Resource ID"
+// @Param        subject      query     string  false  "JWT subject or tenant of scoped token"
+// @Param        session_id   query     string  false  "Session ID"
+// @Param        ip           query     string  false  "Client IP or remote address of JWT"
+// @Param        request_id   query     string  false  "Request ID"
+// @Param        method       query     string  false  "HTTP method"
+// @Param        from         query     string  false  "Created at or after (RFC3339)"
+// @Param        to           query     string  false  "Created before (RFC3339)"
+// @Param        limit        query     int     false  "Page size (default 50, max 1000)"
+// @Param        offset       query     int     false  "Page offset"
+// @Success      200          {object}  ApiAuditResponse
+// @Failure      400          {object}  ErrorResponse
+// @Failure      401          {object}  ErrorResponse
+// @Failure      403          {object}  ErrorResponse
+// @Failure      500          {object}  ErrorResponse
+// @Router       /audit [get]
+func (s *Web) getAudit(c *gin.Context) {
+	db := s.pg.Read()
+	if db == nil {
+		_ = c.Error(errors.New("DB not configured"))
+		return
+	}
+	f := &ApiAuditFilter{
+		ResourceID: c.Query("resource_id"),
+		Subject:    c.Query("subject"),
+		SessionID:  c.Query("session_id"),
+		IP:         c.Query("ip"),
+		RequestID:  c.Query("request_id"),
+		Method:     strings.ToUpper(c.Query("method")),
+	}
+	var err error
+	if f.Limit, f.Offset, err = parseListLimits(c); err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if f.From, err = parseTimeParam(c, "from"); err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if f.To, err = parseTimeParam(c, "to"); err != nil {
+		_ = c.Error(err)
+		return
+	}
+	list, total, err := ApiAuditList(c.Request.Context(), db, f)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, &ApiAuditResponse{Records: list, Total: total, Limit: f.Limit, Offset: f.Offset})
+}
@@ -0,0 +1,301 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	awss3 "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gin-gonic/gin"
+	"github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+const (
+	s3ArchiveStorageClassFlag = "s3-archive-storage-class"
+	s3RestoreTierFlag         = "s3-restore-tier"
+	s3RestoreDaysFlag         = "s3-restore-days"
+	s3ArchiveIntervalFlag     = "s3-archive-interval"
+)
+
+// RegisterArchivalFlags registers CLI flags for archival of resources to Glacier.
+func RegisterArchivalFlags(f []cli.Flag) []cli.Flag {
+	return append(f,
+		cli.StringFlag{
+			Name:   s3ArchiveStorageClassFlag,
+			Usage:  "S3 storage class objects of archived resources are moved to unless requested otherwise: GLACIER or DEEP_ARCHIVE",
+			Value:  "GLACIER",
+			EnvVar: "S3_ARCHIVE_STORAGE_CLASS",
+		},
+		cli.StringFlag{
+			Name:   s3RestoreTierFlag,
+			Usage:  "retrieval tier of restores of archived objects: Expedited (GLACIER only), Standard or Bulk",
+			Value:  "Standard",
+			EnvVar: "S3_RESTORE_TIER",
+		},
+		cli.IntFlag{
+			Name:   s3RestoreDaysFlag,
+			Usage:  "days temporary copies of restored objects are kept, they are copied back to their storage class right away",
+			Value:  1,
+			EnvVar: "S3_RESTORE_DAYS",
+		},
+		cli.DurationFlag{
+			Name:   s3ArchiveIntervalFlag,
+			Usage:  "how often worker moves objects of archived resources and polls restores",
+			Value:  5 * time.Minute,
+			EnvVar: "S3_ARCHIVE_INTERVAL",
+		},
+	)
+}
+
+// archiveStorageClassNames lists storage classes whose objects can't be read until they are restored.
+var archiveStorageClassNames = []string{"GLACIER", "DEEP_ARCHIVE"}
+
+// ParseArchiveStorageClass validates archive storage class name.
+func ParseArchiveStorageClass(v string) (string, error) {
+	if isArchiveStorageClass(v) {
+		return v, nil
+	}
+	return "", errors.Errorf("failed to parse archive storage class %v", v)
+}
+
+// isArchiveStorageClass reports whether objects of storage class sc must be restored to be read.
+func isArchiveStorageClass(sc string) bool {
+	for _, v := range archiveStorageClassNames {
+		if sc == v {
+			return true
+		}
+	}
+	return false
+}
+
+// restoreTimes are typical durations of restores by storage class and retrieval tier.
+var restoreTimes = map[string]map[string]time.Duration{
+	"GLACIER": {
+		awss3.TierExpedited: 5 * time.Minute,
+		awss3.TierStandard:  5 * time.Hour,
+		awss3.TierBulk:      12 * time.Hour,
+	},
+	"DEEP_ARCHIVE": {
+		awss3.TierStandard: 12 * time.Hour,
+		awss3.TierBulk:     48 * time.Hour,
+	},
+}
+
+// Archival moves objects of archived resources to an archive storage class and brings them back
+// once restore is requested.
+type Archival struct {
+	storageClass string
+	tier         string
+	days         int
+	interval     time.Duration
+	last         time.Time
+}
+
+func NewArchival(c *cli.Context) (*Archival, error) {
+	sc, err := ParseArchiveStorageClass(c.String(s3ArchiveStorageClassFlag))
+	if err != nil {
+		return nil, err
+	}
+	tier := c.String(s3RestoreTierFlag)
+	if _, ok := restoreTimes["GLACIER"][tier]; !ok {
+		return nil, errors.Errorf("failed to parse restore tier %v", tier)
+	}
+	if c.Int(s3RestoreDaysFlag) < 1 {
+		return nil, errors.New("restore days must be positive")
+	}
+	if c.Duration(s3ArchiveIntervalFlag) <= 0 {
+		return nil, errors.New("archive interval must be positive")
+	}
+	return &Archival{
+		storageClass: sc,
+		tier:         tier,
+		days:         c.Int(s3RestoreDaysFlag),
+		interval:     c.Duration(s3ArchiveIntervalFlag),
+	}, nil
+}
+
+// due reports whether archived objects should be moved and restores polled now.
+func (s *Archival) due(now time.Time) bool {
+	if now.Sub(s.last) < s.interval {
+		return false
+	}
+	s.last = now
+	return true
+}
+
+// tierOf returns the retrieval tier of objects of storage class sc, Expedited is not available for DEEP_ARCHIVE.
+func (s *Archival) tierOf(sc string) string {
+	if _, ok := restoreTimes[sc][s.tier]; !ok {
+		return awss3.TierStandard
+	}
+	return s.tier
+}
+
+// retryAfter estimates when the archived or restoring resource is served again.
+func (s *Archival) retryAfter(res *Resource, now time.Time) time.Duration {
+	sc := s.storageClass
+	if res.ArchiveStorageClass != nil {
+		sc = *res.ArchiveStorageClass
+	}
+	d := restoreTimes[sc][s.tierOf(sc)] + s.interval
+	if res.Status == StatusRestoring && res.RestoreRequestedAt != nil {
+		d -= now.Sub(*res.RestoreRequestedAt)
+	}
+	return max(d, time.Minute)
+}
+
+// archiveObjects moves objects of files referenced by archived resources only to the archive storage
+// class, restores archived objects of files needed again and marks restoring resources without
+// archived objects left stored.
+func (s *Worker) archiveObjects(ctx context.Context, db *pg.DB) error {
+	if !s.archival.due(time.Now()) {
+		return nil
+	}
+	files, err := FileListToArchive(ctx, db, 100)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		l := logger(ctx).WithFields(log.Fields{"key": f.Hash, "storage_class": f.StorageClass})
+		bucket := s.bucket
+		if f.Bucket != nil {
+			bucket = *f.Bucket
+		}
+		if err := s.copyObject(ctx, bucket, s.keys.Key(f.Hash), s.keys.Key(f.Hash), f.StorageClass); err != nil {
+			l.WithError(err).Error("failed to archive object")
+			continue
+		}
+		if err := FileSetStorageClass(ctx, db, f.Hash, f.StorageClass); err != nil {
+			l.WithError(err).Error("failed to update storage class")
+			continue
+		}
+		l.Info("object archived")
+	}
+	restores, err := FileListToRestore(ctx, db, 100)
+	if err != nil {
+		return err
+	}
+	for i := range restores {
+		if err := s.restoreObject(ctx, db, &restores[i]); err != nil {
+			logger(ctx).WithError(err).WithField("key", restores[i].Hash).Error("failed to restore object")
+		}
+	}
+	ids, err := ResourceCompleteRestores(ctx, db)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		log.WithField("id", id).Info("resource restored")
+		s.events.Publish(EventResourceStored, &ResourceEvent{ResourceID: id, Status: StatusStored.String(), Time: time.Now()})
+	}
+	return nil
+}
+
+// restoreObject requests restore of the archived object of the file and, once it is restored, copies it
+// back to the storage class new files of its content class are uploaded with.
+func (s *Worker) restoreObject(ctx context.Context, db *pg.DB, f *File) error {
+	bucket := f.bucketOr(s.bucket)
+	key := s.keys.Key(f.Hash)
+	sc := s.policies.StorageClass(f.ContentClass, s.storageClass)
+	if sc == "" {
+		// a copy onto itself must change the storage class explicitly
+		sc = awss3.StorageClassStandard
+	}
+	l := logger(ctx).WithFields(log.Fields{"key": f.Hash, "storage_class": sc})
+	head, err := s.s3.Get().HeadObjectWithContext(ctx, &awss3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to head %v", key)
+	}
+	objectClass := aws.StringValue(head.StorageClass)
+	restore := aws.StringValue(head.Restore)
+	switch {
+	case !isArchiveStorageClass(objectClass):
+		// the object was copied back, but its storage class was not recorded
+		if objectClass == "" {
+			objectClass = awss3.StorageClassStandard
+		}
+		return FileSetStorageClass(ctx, db, f.Hash, objectClass)
+	case restore == "":
+		_, err = s.s3.Get().RestoreObjectWithContext(ctx, &awss3.RestoreObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+			RestoreRequest: &awss3.RestoreRequest{
+				Days:                 aws.Int64(int64(s.archival.days)),
+				GlacierJobParameters: &awss3.GlacierJobParameters{Tier: aws.String(s.archival.tierOf(objectClass))},
+			},
+		})
+		var aerr awserr.Error
+		if errors.As(err, &aerr) && aerr.Code() == "RestoreAlreadyInProgress" {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrapf(err, "failed to request restore of %v", key)
+		}
+		l.Info("object restore requested")
+		return nil
+	case strings.Contains(restore, `ongoing-request="true"`):
+		return nil
+	}
+	if err := s.copyObject(ctx, bucket, key, key, sc); err != nil {
+		return err
+	}
+	if err := FileSetStorageClass(ctx, db, f.Hash, sc); err != nil {
+		return err
+	}
+	l.Info("object restored")
+	return nil
+}
+
+// POST /resource/{id}/archive
+// postResourceArchive godoc
+// @Summary      Archive resource
+// @Description  Marks the stored resource archived and moves objects of its files to the archive storage class in
+// @Description  the background. Files shared with resources which are not archived stay where they are. Archived
+// @Description  resources are not served by webseed until they are restored with POST /resource/{id}/restore.
+// @Tags         resource
+// @Param        id             path      string  true   "Resource ID"
+// @Param        storage_class  query     string  false  "GLACIER or DEEP_ARCHIVE (default S3_ARCHIVE_STORAGE_CLASS)"
+// @Success      200  {object}  Resource
+// @Failure      400  {object}  ErrorResponse
+// @Failure      404  {object}  ErrorResponse
+// @Failure      409  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /resource/{id}/archive [post]
+func (s *Web) postResourceArchive(c *gin.Context) {
+	db := s.pg.Get()
+	if db == nil {
+		_ = c.Error(errors.New("DB not configured"))
+		return
+	}
+	sc := s.archival.storageClass
+	if v := c.Query("storage_class"); v != "" {
+		var err error
+		if sc, err = ParseArchiveStorageClass(v); err != nil {
+			_ = c.Error(err)
+			return
+		}
+	}
+	var res *Resource
+	err := db.RunInTransaction(c.Request.Context(), func(tx *pg.Tx) (err error) {
+		res, err = ResourceArchive(c.Request.Context(), tx, c.Param("id"), sc)
+		return
+	})
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if res == nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"resource": res.SetTTL(time.Now())})
+}
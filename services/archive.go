@@ -0,0 +1,451 @@
+package services
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	awss3 "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gin-gonic/gin"
+	"github.com/go-pg/pg/v10"
+	"github.com/nwaples/rardecode/v2"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	cs "github.com/webtor-io/common-services"
+)
+
+const (
+	archiveIndexFlag = "archive-index"
+)
+
+// RegisterArchiveFlags registers CLI flags for archive introspection.
+func RegisterArchiveFlags(f []cli.Flag) []cli.Flag {
+	return append(f,
+		cli.BoolFlag{
+			Name:   archiveIndexFlag,
+			Usage:  "index entries of zip and rar files once they are stored (otherwise archives are indexed on first listing)",
+			EnvVar: "ARCHIVE_INDEX",
+		},
+	)
+}
+
+const (
+	ArchiveFormatZip = "zip"
+	ArchiveFormatRar = "rar"
+)
+
+// archiveReadBlock is the size of ranged S3 reads, archive readers do lots of small reads
+// close to each other.
+const archiveReadBlock = 1024 * 1024
+
+// ArchiveFormatOf detects archive format by file extension, empty if the file is not a supported archive.
+func ArchiveFormatOf(p string) string {
+	switch strings.ToLower(path.Ext(p)) {
+	case ".zip":
+		return ArchiveFormatZip
+	case ".rar":
+		return ArchiveFormatRar
+	}
+	return ""
+}
+
+// ArchiveContents lists entries of a stored archive.
+type ArchiveContents struct {
+	ResourceID string         `json:"resource_id"`
+	Path       string         `json:"path"`
+	Format     string         `json:"format"`
+	Entries    []ArchiveEntry `json:"entries"`
+}
+
+// s3Object reads plaintext of a stored object with ranged S3 requests, caching the last block read.
+type s3Object struct {
+	ctx    context.Context
+	cl     *cs.S3Client
+	bucket string
+	key    string
+	size   int64
+	eo     *encryptedObject
+	offset int64
+	buf    []byte
+	bufOff int64
+}
+
+func openS3Object(ctx context.Context, cl *cs.S3Client, enc *Encryption, bucket, key string, f *File) (*s3Object, error) {
+	head, err := cl.Get().HeadObjectWithContext(ctx, &awss3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to head %v", key)
+	}
+	eo, err := enc.Object(head.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	return &s3Object{ctx: ctx, cl: cl, bucket: bucket, key: key, size: f.TotalSize, eo: eo}, nil
+}
+
+func (s *s3Object) ReadAt(p []byte, off int64) (int, error) {
+	if off >= s.size {
+		return 0, io.EOF
+	}
+	n := 0
+	for n < len(p) && off+int64(n) < s.size {
+		pos := off + int64(n)
+		if s.buf == nil || pos < s.bufOff || pos >= s.bufOff+int64(len(s.buf)) {
+			if err := s.fetch(pos, max(len(p)-n, archiveReadBlock)); err != nil {
+				return n, err
+			}
+		}
+		n += copy(p[n:], s.buf[pos-s.bufOff:])
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// fetch reads size bytes at off into the block cache.
+func (s *s3Object) fetch(off int64, size int) error {
+	start, end := off, min(off+int64(size), s.size)-1
+	rng := fmt.Sprintf("bytes=%d-%d", start, end)
+	if s.eo != nil {
+		rng = s.eo.CipherRange(start, end).String()
+	}
+	out, err := s.cl.Get().GetObjectWithContext(s.ctx, &awss3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key),
+		Range:  aws.String(rng),
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to get %v", s.key)
+	}
+	defer func(b io.ReadCloser) {
+		_ = b.Close()
+	}(out.Body)
+	var r io.Reader = out.Body
+	if s.eo != nil {
+		r = s.eo.Reader(r, start, end)
+	}
+	buf := make([]byte, end-start+1)
+	if _, err = io.ReadFull(r, buf); err != nil {
+		return errors.Wrapf(err, "failed to read %v", s.key)
+	}
+	s.buf, s.bufOff = buf, start
+	return nil
+}
+
+func (s *s3Object) Read(p []byte) (int, error) {
+	n, err := s.ReadAt(p, s.offset)
+	s.offset += int64(n)
+	return n, err
+}
+
+func (s *s3Object) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += s.offset
+	case io.SeekEnd:
+		offset += s.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	s.offset = offset
+	return offset, nil
+}
+
+// listArchive reads entry list of the archive: zip central directory or rar file headers,
+// skipping content of rar entries by seeking.
+func listArchive(obj *s3Object, format string) ([]ArchiveEntry, error) {
+	var entries []ArchiveEntry
+	switch format {
+	case ArchiveFormatZip:
+		zr, err := zip.NewReader(obj, obj.size)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read zip")
+		}
+		for _, f := range zr.File {
+			e := ArchiveEntry{
+				Name:       f.Name,
+				IsDir:      f.FileInfo().IsDir(),
+				Size:       int64(f.UncompressedSize64),
+				PackedSize: int64(f.CompressedSize64),
+				Encrypted:  f.Flags&0x1 != 0,
+			}
+			if mt := f.Modified; !mt.IsZero() {
+				e.ModifiedAt = &mt
+			}
+			entries = append(entries, e)
+		}
+	case ArchiveFormatRar:
+		rr, err := rardecode.NewReader(obj)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read rar")
+		}
+		for {
+			h, err := rr.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to read rar")
+			}
+			e := ArchiveEntry{
+				Name:       h.Name,
+				IsDir:      h.IsDir,
+				Size:       h.UnPackedSize,
+				PackedSize: h.PackedSize,
+				Encrypted:  h.Encrypted,
+			}
+			if mt := h.ModificationTime; !mt.IsZero() {
+				e.ModifiedAt = &mt
+			}
+			entries = append(entries, e)
+		}
+	default:
+		return nil, errors.Errorf("unsupported archive format %v", format)
+	}
+	return entries, nil
+}
+
+// openArchiveEntry returns decompressed content of the entry and its size, nil if there is no such entry.
+func openArchiveEntry(obj *s3Object, format, name string) (io.Reader, int64, error) {
+	switch format {
+	case ArchiveFormatZip:
+		zr, err := zip.NewReader(obj, obj.size)
+		if err != nil {
+			return nil, 0, errors.Wrap(err, "failed to read zip")
+		}
+		for _, f := range zr.File {
+			if f.Name != name || f.FileInfo().IsDir() {
+				continue
+			}
+			r, err := f.Open()
+			if err != nil {
+				return nil, 0, errors.Wrapf(err, "failed to open %v", name)
+			}
+			return r, int64(f.UncompressedSize64), nil
+		}
+	case ArchiveFormatRar:
+		rr, err := rardecode.NewReader(obj)
+		if err != nil {
+			return nil, 0, errors.Wrap(err, "failed to read rar")
+		}
+		for {
+			h, err := rr.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return nil, 0, errors.Wrap(err, "failed to read rar")
+			}
+			if h.Name == name && !h.IsDir {
+				size := h.UnPackedSize
+				if h.UnKnownSize {
+					size = -1
+				}
+				return rr, size, nil
+			}
+		}
+	default:
+		return nil, 0, errors.Errorf("unsupported archive format %v", format)
+	}
+	return nil, 0, nil
+}
+
+// indexArchive lists entries of the stored archive file and saves them.
+func indexArchive(ctx context.Context, db pg.DBI, cl *cs.S3Client, enc *Encryption, bucket, key string, f *File, format string) ([]ArchiveEntry, error) {
+	obj, err := openS3Object(ctx, cl, enc, bucket, key, f)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := listArchive(obj, format)
+	if err != nil {
+		return nil, err
+	}
+	if err = ArchiveEntriesSet(ctx, db, f.Hash, entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// indexStoredArchive indexes a just stored archive, failures are only logged.
+func (s *Worker) indexStoredArchive(ctx context.Context, db *pg.DB, f *File, p string) {
+	format := ArchiveFormatOf(p)
+	if !s.archiveIndex || format == "" || f.ArchiveIndexedAt != nil || f.Chunked {
+		return
+	}
+	l := logger(ctx).WithFields(log.Fields{"key": f.Hash, "path": p})
+	entries, err := indexArchive(ctx, db, s.s3, s.enc, f.bucketOr(s.bucket), s.keys.Key(f.Hash), f, format)
+	if err != nil {
+		l.WithError(err).Warn("failed to index archive")
+		return
+	}
+	l.WithField("entries", len(entries)).Info("archive indexed")
+}
+
+// storedArchive resolves stored archive file of the resource at path.
+func storedArchive(ctx context.Context, db *pg.DB, id, p string) (*File, string, error) {
+	format := ArchiveFormatOf(p)
+	if format == "" {
+		return nil, "", errors.Errorf("failed to parse path: %v is not a zip or rar file", p)
+	}
+	rf := &ResourceFile{}
+	err := db.Model(rf).Context(ctx).
+		Relation("File").
+		Where("resource_file.resource_id = ? AND resource_file.path = ?", id, p).
+		Select()
+	if errors.Is(err, pg.ErrNoRows) || (err == nil && (rf.File == nil || rf.File.Status != StatusStored)) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if rf.File.Chunked {
+		return nil, "", errors.Errorf("failed to parse path: %v is stored in chunks, entries of chunked archives are not listed", p)
+	}
+	return rf.File, format, nil
+}
+
+// GET /resource/{id}/archive-contents
+// getArchiveContents godoc
+// @Summary      List entries of a stored archive
+// @Description  Returns entries of a stored zip or rar file of the resource. Entries are read from the archive
+// @Description  with ranged S3 requests (zip central directory, rar file headers) and indexed on first request
+// @Description  unless ARCHIVE_INDEX indexed them at store time.
+// @Tags         resource
+// @Param        id    path      string  true  "Resource ID"
+// @Param        path  query     string  true  "Path of the archive inside resource"
+// @Success      200  {object}  ArchiveContents
+// @Failure      400  {object}  ErrorResponse
+// @Failure      404  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /resource/{id}/archive-contents [get]
+func (s *Web) getArchiveContents(c *gin.Context) {
+	db := s.pg.Get()
+	if db == nil {
+		_ = c.Error(errors.New("DB not configured"))
+		return
+	}
+	ctx := c.Request.Context()
+	id, p := c.Param("id"), NormalizePath(c.Query("path"))
+	f, format, err := storedArchive(ctx, db, id, p)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if f == nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	var entries []ArchiveEntry
+	if f.ArchiveIndexedAt != nil {
+		entries, err = ArchiveEntryList(ctx, db, f.Hash)
+	} else {
+		entries, err = indexArchive(ctx, db, s.s3, s.enc, f.bucketOr(s.bucket), s.keys.Key(f.Hash), f, format)
+	}
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if entries == nil {
+		entries = []ArchiveEntry{}
+	}
+	c.JSON(http.StatusOK, &ArchiveContents{ResourceID: id, Path: p, Format: format, Entries: entries})
+}
+
+// GET /resource/{id}/archive-entry
+// getArchiveEntry godoc
+// @Summary      Extract a single entry of a stored archive
+// @Description  Streams decompressed content of one entry of a stored zip or rar file, reading only the parts
+// @Description  of the archive needed with ranged S3 requests (solid rar archives are decoded up to the entry).
+// @Tags         webseed
+// @Param        id     path      string  true  "Resource ID"
+// @Param        path   query     string  true  "Path of the archive inside resource"
+// @Param        entry  query     string  true  "Entry name inside archive"
+// @Success      200
+// @Failure      400  {object}  ErrorResponse
+// @Failure      404  {object}  ErrorResponse
+// @Failure      410  {object}  GoneResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /resource/{id}/archive-entry [get]
+func (s *Web) getArchiveEntry(c *gin.Context) {
+	db := s.pg.Get()
+	if db == nil {
+		_ = c.Error(errors.New("DB not configured"))
+		return
+	}
+	ctx := c.Request.Context()
+	id, p, name := c.Param("id"), NormalizePath(c.Query("path")), c.Query("entry")
+	if name == "" {
+		_ = c.Error(errors.New("failed to parse entry: entry is required"))
+		return
+	}
+	st, err := s.lookupResourceState(c, db, id)
+	if err != nil {
+		s.webseedLookupError(c, err)
+		return
+	}
+	if st.takedown {
+		c.AbortWithStatusJSON(http.StatusGone, &GoneResponse{Error: ErrTakenDown.Error(), ReasonCode: st.reasonCode})
+		return
+	}
+	if !st.stored {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	f, format, err := storedArchive(ctx, db, id, p)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if f == nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	obj, err := openS3Object(ctx, s.s3, s.enc, f.bucketOr(s.bucket), s.keys.Key(f.Hash), f)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	r, size, err := openArchiveEntry(obj, format, name)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if r == nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	ct := mime.TypeByExtension(path.Ext(name))
+	if ct == "" {
+		ct = "application/octet-stream"
+	}
+	c.Header("Content-Type", ct)
+	if size >= 0 {
+		c.Header("Content-Length", strconv.FormatInt(size, 10))
+	}
+	if s.rl != nil {
+		r = s.rl.Reader(ctx, c.ClientIP(), r)
+	}
+	c.Status(http.StatusOK)
+	start := time.Now()
+	n, err := io.Copy(c.Writer, r)
+	promWebseedBytesServed.Add(float64(n))
+	if err != nil {
+		logger(ctx).WithError(err).WithFields(log.Fields{"id": id, "path": p, "entry": name, "bytes": n, "duration": time.Since(start)}).Warn("archive entry transfer aborted")
+	}
+}
@@ -0,0 +1,284 @@
+package services
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+const (
+	RoleAdmin = "admin"
+)
+
+const (
+	tokenSecretFlag  = "token-secret"
+	requireTokenFlag = "require-token"
+)
+
+// RegisterAuthFlags registers CLI flags for vault-issued tokens.
+func RegisterAuthFlags(f []cli.Flag) []cli.Flag {
+	return append(f,
+		cli.StringFlag{
+			Name:   tokenSecretFlag,
+			Usage:  "secret used to sign scoped tokens minted by vault (minting is disabled if empty)",
+			EnvVar: "VAULT_TOKEN_SECRET",
+		},
+		cli.BoolFlag{
+			Name:   requireTokenFlag,
+			Usage:  "require admin or scoped X-Token for resource and webseed endpoints",
+			EnvVar: "REQUIRE_TOKEN",
+		},
+	)
+}
+
+// TokenScope is an operation allowed by a scoped token.
+type TokenScope string
+
+const (
+	TokenScopeStore   TokenScope = "resource:store"
+	TokenScopeDelete  TokenScope = "resource:delete"
+	TokenScopeRead    TokenScope = "resource:read"
+	TokenScopeWebseed TokenScope = "webseed:read"
+)
+
+// ParseTokenScope validates scope name.
+func ParseTokenScope(v string) (TokenScope, error) {
+	switch sc := TokenScope(v); sc {
+	case TokenScopeStore, TokenScopeDelete, TokenScopeRead, TokenScopeWebseed:
+		return sc, nil
+	}
+	return "", errors.Errorf("failed to parse token scope %v", v)
+}
+
+const tokenIssuer = "vault"
+
+// TokenClaims are claims of scoped tokens minted by vault.
+type TokenClaims struct {
+	jwt.StandardClaims
+	Tenant string       `json:"tenant"`
+	Scopes []TokenScope `json:"scopes"`
+}
+
+const (
+	claimsContextKey      = "claims"
+	tokenClaimsContextKey = "token_claims"
+)
+
+// Auth validates X-Token JWTs issued with the webtor api secret and scoped tokens minted by vault.
+type Auth struct {
+	secret       *Secret
+	tokenSecret  *Secret
+	requireToken bool
+	pg           *PG
+}
+
+func NewAuth(c *cli.Context, pg *PG, secrets *Secrets) *Auth {
+	if len(secrets.WebtorSecret.Get()) == 0 {
+		log.Warn("webtor api secret is not set, admin endpoints are disabled")
+	}
+	return &Auth{
+		secret:       secrets.WebtorSecret,
+		tokenSecret:  secrets.TokenSecret,
+		requireToken: c.Bool(requireTokenFlag),
+		pg:           pg,
+	}
+}
+
+// Enabled reports whether tokens are verified.
+func (s *Auth) Enabled() bool {
+	return len(s.secret.Get()) > 0
+}
+
+func hmacKey(key []byte) jwt.Keyfunc {
+	return func(t *jwt.Token) (any, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.Errorf("unexpected signing method %v", t.Header["alg"])
+		}
+		return key, nil
+	}
+}
+
+// ParseClaims parses and verifies the token.
+func (s *Auth) ParseClaims(token string) (*Claims, error) {
+	cl := &Claims{}
+	t, err := jwt.ParseWithClaims(token, cl, hmacKey(s.secret.Get()))
+	if err != nil {
+		return nil, err
+	}
+	if !t.Valid {
+		return nil, errors.New("invalid token")
+	}
+	return cl, nil
+}
+
+// MintToken signs a scoped token for the token record.
+func (s *Auth) MintToken(t *ApiToken) (string, error) {
+	key := s.tokenSecret.Get()
+	if len(key) == 0 {
+		return "", errors.New("token secret is not configured")
+	}
+	cl := &TokenClaims{
+		StandardClaims: jwt.StandardClaims{
+			Id:        t.TokenID.String(),
+			Issuer:    tokenIssuer,
+			Subject:   t.Tenant,
+			IssuedAt:  t.CreatedAt.Unix(),
+			ExpiresAt: t.ExpiresAt.Unix(),
+		},
+		Tenant: t.Tenant,
+		Scopes: t.Scopes,
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, cl).SignedString(key)
+}
+
+// internalToken signs a short-lived admin token for requests vault makes to itself.
+// Empty token is returned if tokens are not verified.
+func (s *Auth) internalToken(ttl time.Duration) (string, error) {
+	if !s.Enabled() {
+		return "", nil
+	}
+	cl := &Claims{
+		StandardClaims: jwt.StandardClaims{ExpiresAt: time.Now().Add(ttl).Unix()},
+		Role:           RoleAdmin,
+		Agent:          "vault",
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, cl).SignedString(s.secret.Get())
+}
+
+// parseTokenClaims parses and verifies a scoped token, including revocation.
+func (s *Auth) parseTokenClaims(c *gin.Context, token string) (*TokenClaims, error) {
+	cl := &TokenClaims{}
+	t, err := jwt.ParseWithClaims(token, cl, hmacKey(s.tokenSecret.Get()))
+	if err != nil {
+		return nil, err
+	}
+	if !t.Valid || cl.Issuer != tokenIssuer {
+		return nil, errors.New("invalid token")
+	}
+	id, err := uuid.Parse(cl.Id)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid token id")
+	}
+	db := s.pg.Get()
+	if db == nil {
+		return nil, errors.New("DB not configured")
+	}
+	rec, err := ApiTokenGet(c.Request.Context(), db, id)
+	if err != nil {
+		return nil, err
+	}
+	if rec == nil || rec.RevokedAt != nil {
+		return nil, errors.New("token was revoked")
+	}
+	return cl, nil
+}
+
+// claims returns verified claims of the request or nil if there is no valid token.
+func (s *Auth) claims(c *gin.Context) *Claims {
+	if v, ok := c.Get(claimsContextKey); ok {
+		return v.(*Claims)
+	}
+	token := c.GetHeader("X-Token")
+	if token == "" || !s.Enabled() {
+		return nil
+	}
+	cl, err := s.ParseClaims(token)
+	if err != nil {
+		log.WithError(err).Debug("failed to parse token")
+		return nil
+	}
+	c.Set(claimsContextKey, cl)
+	return cl
+}
+
+// tokenClaims returns verified scoped token claims of the request or nil if there is no valid scoped token.
+func (s *Auth) tokenClaims(c *gin.Context) *TokenClaims {
+	if v, ok := c.Get(tokenClaimsContextKey); ok {
+		return v.(*TokenClaims)
+	}
+	token := c.GetHeader("X-Token")
+	if token == "" || len(s.tokenSecret.Get()) == 0 {
+		return nil
+	}
+	cl, err := s.parseTokenClaims(c, token)
+	if err != nil {
+		log.WithError(err).Debug("failed to parse scoped token")
+		return nil
+	}
+	c.Set(tokenClaimsContextKey, cl)
+	return cl
+}
+
+// Allowed reports whether the request may perform operation of the scope. Admin tokens are allowed everything.
+func (s *Auth) Allowed(c *gin.Context, scope TokenScope) bool {
+	if !s.requireToken {
+		return true
+	}
+	if cl := s.claims(c); cl != nil && cl.Role == RoleAdmin {
+		return true
+	}
+	cl := s.tokenClaims(c)
+	if cl == nil {
+		return false
+	}
+	for _, sc := range cl.Scopes {
+		if sc == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Tenant returns tenant of the scoped token of the request. Empty tenant is returned for admin tokens and
+// requests without a scoped token, which are not limited to resources of a tenant.
+func (s *Auth) Tenant(c *gin.Context) string {
+	if cl := s.claims(c); cl != nil && cl.Role == RoleAdmin {
+		return ""
+	}
+	if cl := s.tokenClaims(c); cl != nil {
+		return cl.Tenant
+	}
+	return ""
+}
+
+// RequireScope returns a gin middleware allowing admin tokens and scoped tokens with the scope.
+// It is a no-op unless require-token is set.
+func (s *Auth) RequireScope(scope TokenScope) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !s.requireToken {
+			return
+		}
+		if s.claims(c) == nil && s.tokenClaims(c) == nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, &ErrorResponse{Error: "valid X-Token required"})
+			return
+		}
+		if !s.Allowed(c, scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, &ErrorResponse{Error: string(scope) + " scope required"})
+			return
+		}
+	}
+}
+
+// RequireAdmin is a gin middleware allowing only tokens with admin role. Admin endpoints are closed
+// while the secret is not set.
+func (s *Auth) RequireAdmin(c *gin.Context) {
+	if !s.Enabled() {
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, &ErrorResponse{Error: "admin endpoints are disabled: WEBTOR_API_SECRET is not set"})
+		return
+	}
+	cl := s.claims(c)
+	if cl == nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, &ErrorResponse{Error: "valid X-Token required"})
+		return
+	}
+	if cl.Role != RoleAdmin {
+		c.AbortWithStatusJSON(http.StatusForbidden, &ErrorResponse{Error: "admin role required"})
+		return
+	}
+}
@@ -0,0 +1,273 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+const (
+	blocklistURLFlag          = "blocklist-url"
+	blocklistSyncIntervalFlag = "blocklist-sync-interval"
+)
+
+// RegisterBlocklistFlags registers CLI flags for remote blocklist sync.
+func RegisterBlocklistFlags(f []cli.Flag) []cli.Flag {
+	return append(f,
+		cli.StringFlag{
+			Name:   blocklistURLFlag,
+			Usage:  "url of remote blocklist (one infohash per line, optionally followed by reason, # for comments)",
+			EnvVar: "BLOCKLIST_URL",
+		},
+		cli.DurationFlag{
+			Name:   blocklistSyncIntervalFlag,
+			Usage:  "remote blocklist sync interval",
+			Value:  time.Hour,
+			EnvVar: "BLOCKLIST_SYNC_INTERVAL",
+		},
+	)
+}
+
+var infohashRe = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// BlocklistSync periodically replaces remote blocklist entries with the content of blocklist url.
+type BlocklistSync struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	pg       *PG
+	cl       *http.Client
+	url      string
+	interval time.Duration
+}
+
+// NewBlocklistSync returns nil if blocklist url is not configured.
+func NewBlocklistSync(c *cli.Context, pg *PG, cl *http.Client) *BlocklistSync {
+	url := c.String(blocklistURLFlag)
+	if url == "" {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &BlocklistSync{
+		ctx:      ctx,
+		cancel:   cancel,
+		pg:       pg,
+		cl:       cl,
+		url:      url,
+		interval: c.Duration(blocklistSyncIntervalFlag),
+	}
+}
+
+func (s *BlocklistSync) Serve() error {
+	log.Infof("syncing blocklist from %v every %v", s.url, s.interval)
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		if err := s.sync(s.ctx); err != nil {
+			log.WithError(err).Error("blocklist sync failed")
+		}
+		select {
+		case <-s.ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *BlocklistSync) sync(ctx context.Context) error {
+	db := s.pg.Get()
+	if db == nil {
+		return errors.New("DB not configured")
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", s.url, nil)
+	if err != nil {
+		return err
+	}
+	res, err := s.cl.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func(r io.ReadCloser) {
+		_ = r.Close()
+	}(res.Body)
+	if res.StatusCode != http.StatusOK {
+		return errors.Errorf("blocklist url responded with status=%v", res.StatusCode)
+	}
+	entries, err := parseBlocklist(res.Body)
+	if err != nil {
+		return err
+	}
+	err = db.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		return BlocklistReplaceRemote(ctx, tx, entries)
+	})
+	if err != nil {
+		return err
+	}
+	log.WithField("entries", len(entries)).Info("blocklist synced")
+	return nil
+}
+
+func parseBlocklist(r io.Reader) ([]BlocklistEntry, error) {
+	var entries []BlocklistEntry
+	seen := map[string]bool{}
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, " ", 2)
+		h := NormalizeInfohash(parts[0])
+		if !infohashRe.MatchString(h) {
+			log.WithField("line", line).Warn("skipping invalid blocklist entry")
+			continue
+		}
+		if seen[h] {
+			continue
+		}
+		seen[h] = true
+		e := BlocklistEntry{Infohash: h}
+		if len(parts) == 2 {
+			reason := strings.TrimSpace(parts[1])
+			e.Reason = &reason
+		}
+		entries = append(entries, e)
+	}
+	return entries, sc.Err()
+}
+
+func (s *BlocklistSync) Close() {
+	log.Info("closing BlocklistSync")
+	s.cancel()
+}
+
+// BlocklistRequest is a body of blocklist request.
+type BlocklistRequest struct {
+	Reason string `json:"reason"`
+}
+
+// BlocklistResponse is a page of blocklist entries.
+type BlocklistResponse struct {
+	Entries []BlocklistEntry `json:"entries"`
+	Total   int              `json:"total"`
+	Limit   int              `json:"limit"`
+	Offset  int              `json:"offset"`
+}
+
+// GET /admin/blocklist
+// getBlocklist godoc
+// @Summary      List blocklisted infohashes
+// @Tags         admin
+// @Param        source  query     string  false  "Entry source: manual or remote"
+// @Param        limit   query     int     false  "Page size (default 50, max 1000)"
+// @Param        offset  query     int     false  "Page offset"
+// @Success      200     {object}  BlocklistResponse
+// @Failure      400     {object}  ErrorResponse
+// @Failure      401     {object}  ErrorResponse
+// @Failure      403     {object}  ErrorResponse
+// @Failure      500     {object}  ErrorResponse
+// @Router       /admin/blocklist [get]
+func (s *Web) getBlocklist(c *gin.Context) {
+	db := s.pg.Get()
+	if db == nil {
+		_ = c.Error(errors.New("DB not configured"))
+		return
+	}
+	limit, offset, err := parseListLimits(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	source := BlocklistSource(c.Query("source"))
+	if source != "" && source != BlocklistSourceManual && source != BlocklistSourceRemote {
+		_ = c.Error(errors.Errorf("failed to parse source %v", source))
+		return
+	}
+	list, total, err := BlocklistList(c.Request.Context(), db, source, limit, offset)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, &BlocklistResponse{Entries: list, Total: total, Limit: limit, Offset: offset})
+}
+
+// PUT /admin/blocklist/{infohash}
+// putBlocklist godoc
+// @Summary      Blocklist infohash
+// @Description  Blocklisted infohashes are rejected with 451 on store
+// @Tags         admin
+// @Accept       json
+// @Param        infohash  path      string            true   "Infohash"
+// @Param        request   body      BlocklistRequest  false  "Block reason"
+// @Success      200       {object}  BlocklistEntry
+// @Failure      400       {object}  ErrorResponse
+// @Failure      401       {object}  ErrorResponse
+// @Failure      403       {object}  ErrorResponse
+// @Failure      500       {object}  ErrorResponse
+// @Router       /admin/blocklist/{infohash} [put]
+func (s *Web) putBlocklist(c *gin.Context) {
+	db := s.pg.Get()
+	if db == nil {
+		_ = c.Error(errors.New("DB not configured"))
+		return
+	}
+	h := NormalizeInfohash(c.Param("infohash"))
+	if !infohashRe.MatchString(h) {
+		_ = c.Error(errors.Errorf("failed to parse infohash %v", h))
+		return
+	}
+	var req BlocklistRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			_ = c.Error(errors.Wrap(err, "failed to parse blocklist request"))
+			return
+		}
+	}
+	e := &BlocklistEntry{Infohash: h, Source: BlocklistSourceManual}
+	if req.Reason != "" {
+		e.Reason = &req.Reason
+	}
+	if err := BlocklistAdd(c.Request.Context(), db, e); err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, e)
+}
+
+// DELETE /admin/blocklist/{infohash}
+// deleteBlocklist godoc
+// @Summary      Remove infohash from blocklist
+// @Tags         admin
+// @Param        infohash  path  string  true  "Infohash"
+// @Success      204
+// @Failure      401  {object}  ErrorResponse
+// @Failure      403  {object}  ErrorResponse
+// @Failure      404  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /admin/blocklist/{infohash} [delete]
+func (s *Web) deleteBlocklist(c *gin.Context) {
+	db := s.pg.Get()
+	if db == nil {
+		_ = c.Error(errors.New("DB not configured"))
+		return
+	}
+	ok, err := BlocklistRemove(c.Request.Context(), db, c.Param("infohash"))
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if !ok {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
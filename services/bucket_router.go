@@ -0,0 +1,203 @@
+package services
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+)
+
+const (
+	bucketRuleFlag = "bucket-rule"
+)
+
+// RegisterBucketRouterFlags registers CLI flags for routing of stored files to buckets.
+func RegisterBucketRouterFlags(f []cli.Flag) []cli.Flag {
+	return append(f,
+		cli.StringSliceFlag{
+			Name: bucketRuleFlag,
+			Usage: "route stored files to a bucket as \"<condition> => <bucket>\", condition is size>10GB (also >=, <, <=; B, KB, MB, GB, TB are binary units), " +
+				"label:tier=cold,region=eu (label selector of the resource, commas of BUCKET_RULE within it are kept) or hash:0a (prefix of the file hash); " +
+				"the first matching rule wins, other files go to aws-bucket",
+			EnvVar: "BUCKET_RULE",
+		},
+	)
+}
+
+type bucketRule struct {
+	bucket string
+	// size condition
+	op   string
+	size int64
+	// label condition
+	sel *LabelSelector
+	// hash condition
+	prefix string
+}
+
+var sizeUnits = map[string]int64{
+	"":   1,
+	"B":  1,
+	"KB": 1 << 10,
+	"MB": 1 << 20,
+	"GB": 1 << 30,
+	"TB": 1 << 40,
+}
+
+// parseSize parses size like 10GB, units are binary and also accepted as KiB, MiB and so on.
+func parseSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	i := strings.IndexFunc(s, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	num, unit := s, ""
+	if i >= 0 {
+		num, unit = s[:i], strings.Replace(strings.TrimSpace(s[i:]), "IB", "B", 1)
+	}
+	m, ok := sizeUnits[unit]
+	if !ok {
+		return 0, errors.Errorf("unknown size unit %q", unit)
+	}
+	v, err := strconv.ParseFloat(num, 64)
+	if err != nil || v < 0 {
+		return 0, errors.Errorf("invalid size %q", s)
+	}
+	return int64(v * float64(m)), nil
+}
+
+func parseBucketRule(s string) (*bucketRule, error) {
+	cond, bucket, ok := strings.Cut(s, "=>")
+	cond, bucket = strings.TrimSpace(cond), strings.TrimSpace(bucket)
+	if !ok || cond == "" || bucket == "" {
+		return nil, errors.Errorf("failed to parse bucket rule %q: expected \"<condition> => <bucket>\"", s)
+	}
+	r := &bucketRule{bucket: bucket}
+	switch {
+	case strings.HasPrefix(cond, "size"):
+		v := strings.TrimSpace(strings.TrimPrefix(cond, "size"))
+		for _, op := range []string{">=", "<=", ">", "<", "="} {
+			if strings.HasPrefix(v, op) {
+				r.op = op
+				break
+			}
+		}
+		if r.op == "" {
+			return nil, errors.Errorf("failed to parse bucket rule %q: size must be compared with >, >=, <, <= or =", s)
+		}
+		size, err := parseSize(strings.TrimPrefix(v, r.op))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse bucket rule %q", s)
+		}
+		r.size = size
+	case strings.HasPrefix(cond, "label:"):
+		sel, err := ParseLabelSelector(strings.TrimPrefix(cond, "label:"))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse bucket rule %q", s)
+		}
+		r.sel = sel
+	case strings.HasPrefix(cond, "hash:"):
+		r.prefix = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(cond, "hash:")))
+		if r.prefix == "" {
+			return nil, errors.Errorf("failed to parse bucket rule %q: empty hash prefix", s)
+		}
+	default:
+		return nil, errors.Errorf("failed to parse bucket rule %q: condition must be size, label: or hash:", s)
+	}
+	return r, nil
+}
+
+// match reports whether the file matches the rule. Hash rules never match while hash is unknown.
+func (r *bucketRule) match(size int64, labels map[string]string, hash string) bool {
+	switch {
+	case r.op != "":
+		switch r.op {
+		case ">=":
+			return size >= r.size
+		case "<=":
+			return size <= r.size
+		case ">":
+			return size > r.size
+		case "<":
+			return size < r.size
+		default:
+			return size == r.size
+		}
+	case r.sel != nil:
+		return r.sel.matches(labels)
+	default:
+		return hash != "" && strings.HasPrefix(hash, r.prefix)
+	}
+}
+
+// BucketRouter chooses the bucket a file is stored in. The chosen bucket is persisted with the file,
+// so objects are read and deleted from it even after rules change.
+type BucketRouter struct {
+	def   string
+	rules []*bucketRule
+}
+
+// joinBucketRules rejoins rules split at commas of label selectors, since BUCKET_RULE is split at every
+// comma: a part without "=>" continues with the next one, e.g. "label:a=b" and "c=d => x".
+func joinBucketRules(vs []string) []string {
+	var res []string
+	pending := ""
+	for _, v := range vs {
+		if pending != "" {
+			v = pending + "," + v
+			pending = ""
+		}
+		if !strings.Contains(v, "=>") {
+			pending = v
+			continue
+		}
+		res = append(res, v)
+	}
+	if pending != "" {
+		// fails to parse
+		res = append(res, pending)
+	}
+	return res
+}
+
+// NewBucketRouter parses bucket rules, without rules every file is stored in aws-bucket.
+func NewBucketRouter(c *cli.Context) (*BucketRouter, error) {
+	s := &BucketRouter{def: c.String(awsBucketFlag)}
+	for _, v := range joinBucketRules(c.StringSlice(bucketRuleFlag)) {
+		r, err := parseBucketRule(v)
+		if err != nil {
+			return nil, err
+		}
+		s.rules = append(s.rules, r)
+	}
+	if len(s.rules) > 0 && s.def == "" {
+		return nil, errors.New("bucket rules require aws-bucket")
+	}
+	return s, nil
+}
+
+// Route returns the bucket of the file of size, labels of its resource and hash (empty if not known yet).
+func (s *BucketRouter) Route(size int64, labels map[string]string, hash string) string {
+	for _, r := range s.rules {
+		if r.match(size, labels, hash) {
+			return r.bucket
+		}
+	}
+	return s.def
+}
+
+// Routed reports whether any bucket rule is configured.
+func (s *BucketRouter) Routed() bool {
+	return len(s.rules) > 0
+}
+
+// Buckets returns the default bucket followed by buckets of rules, each once.
+func (s *BucketRouter) Buckets() []string {
+	res := []string{s.def}
+	seen := map[string]bool{s.def: true}
+	for _, r := range s.rules {
+		if !seen[r.bucket] {
+			seen[r.bucket] = true
+			res = append(res, r.bucket)
+		}
+	}
+	return res
+}
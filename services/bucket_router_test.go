@@ -0,0 +1,174 @@
+package services
+
+import (
+	"flag"
+	"reflect"
+	"testing"
+
+	"github.com/urfave/cli"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{in: "0", want: 0},
+		{in: "100", want: 100},
+		{in: "100B", want: 100},
+		{in: "1KB", want: 1 << 10},
+		{in: "1kb", want: 1 << 10},
+		{in: "1KiB", want: 1 << 10},
+		{in: "2MB", want: 2 << 20},
+		{in: "2MiB", want: 2 << 20},
+		{in: "10GB", want: 10 << 30},
+		{in: "10 GiB", want: 10 << 30},
+		{in: "1TB", want: 1 << 40},
+		{in: "1TiB", want: 1 << 40},
+		{in: "1.5GB", want: 3 << 29},
+		{in: " 5 MB ", want: 5 << 20},
+		{in: "", wantErr: true},
+		{in: "GB", wantErr: true},
+		{in: "10PB", wantErr: true},
+		{in: "10 bytes", wantErr: true},
+		{in: "-1GB", wantErr: true},
+		{in: "1.2.3GB", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseSize(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("parseSize(%q) = %v, want %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseBucketRule(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    *bucketRule
+		wantErr bool
+	}{
+		{in: "size>10GB => big", want: &bucketRule{bucket: "big", op: ">", size: 10 << 30}},
+		{in: "size>=10GB => big", want: &bucketRule{bucket: "big", op: ">=", size: 10 << 30}},
+		{in: "size<1MiB=>small", want: &bucketRule{bucket: "small", op: "<", size: 1 << 20}},
+		{in: "size <= 1 MiB => small", want: &bucketRule{bucket: "small", op: "<=", size: 1 << 20}},
+		{in: "size=0 => empty", want: &bucketRule{bucket: "empty", op: "=", size: 0}},
+		{in: "label:tier=cold => cold", want: &bucketRule{bucket: "cold", sel: &LabelSelector{Match: map[string]string{"tier": "cold"}}}},
+		{in: "label:tier=cold,region=eu => eucold", want: &bucketRule{bucket: "eucold", sel: &LabelSelector{Match: map[string]string{"tier": "cold", "region": "eu"}}}},
+		{in: "label:pinned => pinned", want: &bucketRule{bucket: "pinned", sel: &LabelSelector{Match: map[string]string{}, Has: []string{"pinned"}}}},
+		{in: "hash:0A => shard", want: &bucketRule{bucket: "shard", prefix: "0a"}},
+		{in: "size>10GB", wantErr: true},
+		{in: "size>10GB => ", wantErr: true},
+		{in: " => big", wantErr: true},
+		{in: "size~10GB => big", wantErr: true},
+		{in: "size>10PB => big", wantErr: true},
+		{in: "size> => big", wantErr: true},
+		{in: "label: => cold", wantErr: true},
+		{in: "hash: => shard", wantErr: true},
+		{in: "name:x => big", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseBucketRule(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseBucketRule(%q) = %+v, want %+v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBucketRuleMatch(t *testing.T) {
+	tests := []struct {
+		rule   string
+		size   int64
+		labels map[string]string
+		hash   string
+		want   bool
+	}{
+		{rule: "size>1KB => x", size: 1025, want: true},
+		{rule: "size>1KB => x", size: 1024, want: false},
+		{rule: "size>=1KB => x", size: 1024, want: true},
+		{rule: "size<1KB => x", size: 1024, want: false},
+		{rule: "size<=1KB => x", size: 1024, want: true},
+		{rule: "size=1KB => x", size: 1024, want: true},
+		{rule: "size=1KB => x", size: 1023, want: false},
+		{rule: "label:tier=cold,region=eu => x", labels: map[string]string{"tier": "cold", "region": "eu"}, want: true},
+		{rule: "label:tier=cold,region=eu => x", labels: map[string]string{"tier": "cold"}, want: false},
+		{rule: "hash:0a => x", hash: "0a1b", want: true},
+		{rule: "hash:0a => x", hash: "1b0a", want: false},
+		{rule: "hash:0a => x", hash: "", want: false},
+	}
+	for _, tt := range tests {
+		r, err := parseBucketRule(tt.rule)
+		if err != nil {
+			t.Fatalf("parseBucketRule(%q): %v", tt.rule, err)
+		}
+		if got := r.match(tt.size, tt.labels, tt.hash); got != tt.want {
+			t.Errorf("%q match(%v, %v, %q) = %v, want %v", tt.rule, tt.size, tt.labels, tt.hash, got, tt.want)
+		}
+	}
+}
+
+func TestJoinBucketRules(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []string
+		want []string
+	}{
+		{name: "none", in: nil, want: nil},
+		{name: "whole", in: []string{"size>10GB => big", "hash:0a => shard"}, want: []string{"size>10GB => big", "hash:0a => shard"}},
+		{name: "split selector", in: []string{"label:a=b", "c=d => x"}, want: []string{"label:a=b,c=d => x"}},
+		{name: "split selector between rules", in: []string{"size>1GB => big", "label:a=b", "c=d", "e => x", "hash:0a => shard"},
+			want: []string{"size>1GB => big", "label:a=b,c=d,e => x", "hash:0a => shard"}},
+		{name: "trailing part", in: []string{"size>1GB => big", "label:a=b"}, want: []string{"size>1GB => big", "label:a=b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := joinBucketRules(tt.in); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("joinBucketRules(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewBucketRouterEnv(t *testing.T) {
+	t.Setenv("BUCKET_RULE", "size>10GB => big,label:tier=cold,region=eu => eucold")
+	set := flag.NewFlagSet("test", flag.ContinueOnError)
+	for _, f := range RegisterBucketRouterFlags([]cli.Flag{cli.StringFlag{Name: awsBucketFlag, Value: "def"}}) {
+		f.Apply(set)
+	}
+	s, err := NewBucketRouter(cli.NewContext(nil, set, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := s.Buckets(), []string{"def", "big", "eucold"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Buckets() = %q, want %q", got, want)
+	}
+	if got := s.Route(1, map[string]string{"tier": "cold", "region": "eu"}, ""); got != "eucold" {
+		t.Errorf("Route() = %q, want eucold", got)
+	}
+	if got := s.Route(1, map[string]string{"tier": "cold"}, ""); got != "def" {
+		t.Errorf("Route() = %q, want def", got)
+	}
+}
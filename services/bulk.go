@@ -0,0 +1,579 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pg/pg/v10"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// bulkBatchSize is the number of pending items loaded at once.
+const bulkBatchSize = 100
+
+var (
+	// errBulkLeaseLost stops a bulk operation taken over by another instance.
+	errBulkLeaseLost = errors.New("bulk operation lease lost")
+	// errBulkRetryDelete skips delete_error resources of retry queued without delete scope.
+	errBulkRetryDelete = errors.Errorf("forbidden: %v scope required", TokenScopeDelete)
+)
+
+// maxBulkIDs caps resources listed explicitly in a bulk request.
+const maxBulkIDs = 100000
+
+// BulkRequest is a body of bulk operation request. Exactly one of selector, status and ids targets resources.
+type BulkRequest struct {
+	Action BulkAction `json:"action"`
+	// Selector matches resources by labels, e.g. "tenant=x,tier=cold"
+	Selector string `json:"selector,omitempty"`
+	// Status matches resources by status, e.g. store_error
+	Status string `json:"status,omitempty"`
+	// IDs lists resources explicitly
+	IDs []string `json:"ids,omitempty"`
+	// Params of policy and migrate actions
+	Params *BulkParams `json:"params,omitempty"`
+}
+
+// BulkOperationsResponse is a page of bulk operations.
+type BulkOperationsResponse struct {
+	Operations []BulkOperation `json:"bulk_operations"`
+	Total      int             `json:"total"`
+	Limit      int             `json:"limit"`
+	Offset     int             `json:"offset"`
+}
+
+// BulkOperationResponse is a bulk operation with a page of its per-resource results.
+type BulkOperationResponse struct {
+	Operation *BulkOperation      `json:"bulk_operation"`
+	Items     []BulkOperationItem `json:"items"`
+	Total     int                 `json:"total"`
+	Limit     int                 `json:"limit"`
+	Offset    int                 `json:"offset"`
+}
+
+// startBulkOperation claims a queued bulk operation and runs it in background, one at a time per instance.
+func (s *Worker) startBulkOperation(ctx context.Context, db *pg.DB) error {
+	if !s.bulkRunning.CompareAndSwap(false, true) {
+		return nil
+	}
+	op, err := BulkOperationClaim(ctx, db, s.id, s.leaseTTL, s.deadAfter)
+	if err != nil || op == nil {
+		s.bulkRunning.Store(false)
+		return err
+	}
+	go func() {
+		defer s.bulkRunning.Store(false)
+		s.runBulkOperation(ctx, db, op)
+	}()
+	return nil
+}
+
+func (s *Worker) runBulkOperation(ctx context.Context, db *pg.DB, op *BulkOperation) {
+	if op.RequestID != nil {
+		ctx = WithRequestID(ctx, *op.RequestID)
+	}
+	l := logger(ctx).WithFields(log.Fields{"bulk_id": op.BulkID, "action": op.Action})
+	l.Info("bulk operation started")
+	err := s.executeBulkOperation(ctx, db, op)
+	if ctx.Err() != nil {
+		// resumed once the lease expires
+		l.Info("bulk operation interrupted")
+		return
+	}
+	if errors.Is(err, errBulkLeaseLost) {
+		l.Warn("bulk operation cancelled or taken over by another instance")
+		return
+	}
+	var errText *string
+	if err != nil {
+		l.WithError(err).Error("bulk operation failed")
+		e := err.Error()
+		errText = &e
+	}
+	if err = BulkOperationFinish(ctx, db, op, errText); err != nil {
+		l.WithError(err).Error("failed to finish bulk operation")
+		return
+	}
+	l.WithFields(log.Fields{"processed": op.Processed, "failed": op.Failed}).Info("bulk operation finished")
+}
+
+func (s *Worker) executeBulkOperation(ctx context.Context, db *pg.DB, op *BulkOperation) error {
+	if err := BulkOperationResolve(ctx, db, op); err != nil {
+		return err
+	}
+	if op.Action == BulkActionExport {
+		return s.exportBulkOperation(ctx, db, op)
+	}
+	for {
+		ids, err := BulkOperationPendingItems(ctx, db, op.BulkID, bulkBatchSize)
+		if err != nil || len(ids) == 0 {
+			return err
+		}
+		for _, id := range ids {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			result, errText := s.applyBulkAction(ctx, db, op, id)
+			promBulkItems.WithLabelValues(string(op.Action), string(result)).Inc()
+			var ok bool
+			err = db.RunInTransaction(ctx, func(tx *pg.Tx) error {
+				var err error
+				ok, err = BulkOperationSetItem(ctx, tx, op, id, result, errText, s.leaseTTL)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return errBulkLeaseLost
+			}
+		}
+	}
+}
+
+// applyBulkAction applies the action of op to the resource and returns its result.
+func (s *Worker) applyBulkAction(ctx context.Context, db *pg.DB, op *BulkOperation, id string) (BulkItemResult, *string) {
+	params := op.Params
+	if params == nil {
+		params = &BulkParams{}
+	}
+	var res *Resource
+	err := db.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		if op.Action == BulkActionStore {
+			var err error
+			res, err = storeBulkItem(ctx, tx, op, id)
+			return err
+		}
+		cur, err := ResourceGetByID(ctx, tx, id)
+		if err != nil || cur == nil {
+			return err
+		}
+		switch op.Action {
+		case BulkActionDelete:
+			// resource which was only queued for storing is deleted right away
+			res = cur
+			_, err = ResourceQueueForDeletion(ctx, tx, id)
+		case BulkActionRetry:
+			if cur.Status == StatusDeleteError && !params.RetryDeletes {
+				return errBulkRetryDelete
+			}
+			res, err = ResourceRetry(ctx, tx, id)
+		case BulkActionPolicy:
+			res = cur
+			expiresAt, setExpiry, err := params.expiry(time.Now())
+			if err != nil {
+				return err
+			}
+			if setExpiry {
+				if _, err = ResourceSetExpiry(ctx, tx, id, expiresAt); err != nil {
+					return err
+				}
+			}
+			if params.StorageClass != "" {
+				_, err = ResourceSetStorageClass(ctx, tx, id, params.StorageClass)
+			}
+			return err
+		case BulkActionRehash, BulkActionMigrate:
+			// objects are processed outside of the transaction
+			res = cur
+		default:
+			err = errors.Errorf("unknown bulk action %v", op.Action)
+		}
+		return err
+	})
+	if err == nil && res != nil {
+		switch op.Action {
+		case BulkActionRehash:
+			err = s.rehashResource(ctx, res.ID)
+		case BulkActionMigrate:
+			err = s.migrateResource(ctx, db, res.ID, params.StorageClass)
+		}
+	}
+	switch {
+	case err == nil && res == nil:
+		return BulkItemNotFound, nil
+	case err == nil:
+		return BulkItemDone, nil
+	}
+	e := err.Error()
+	if errors.Is(err, ErrNotRetryable) || errors.Is(err, ErrLegalHold) || errors.Is(err, errBulkRetryDelete) ||
+		errors.Is(err, ErrBlocked) || errors.Is(err, ErrTakenDown) {
+		return BulkItemSkipped, &e
+	}
+	logger(ctx).WithError(err).WithFields(log.Fields{"bulk_id": op.BulkID, "id": id}).Warn("bulk action failed")
+	return BulkItemFailed, &e
+}
+
+// storeBulkItem queues storing of the resource and applies labels, priority and policy of its item.
+func storeBulkItem(ctx context.Context, tx *pg.Tx, op *BulkOperation, id string) (*Resource, error) {
+	params, err := BulkOperationItemParams(ctx, tx, op.BulkID, id)
+	if err != nil {
+		return nil, err
+	}
+	if params == nil {
+		params = op.Params
+	}
+	if params == nil {
+		params = &BulkParams{}
+	}
+	res, err := ResourceQueueForStoring(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	expiresAt, setExpiry, err := params.expiry(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if setExpiry {
+		if res, err = ResourceSetExpiry(ctx, tx, id, expiresAt); err != nil {
+			return nil, err
+		}
+	}
+	if params.StorageClass != "" {
+		if res, err = ResourceSetStorageClass(ctx, tx, id, params.StorageClass); err != nil {
+			return nil, err
+		}
+	}
+	if params.Labels != nil {
+		if res, err = ResourceSetLabels(ctx, tx, id, params.Labels); err != nil {
+			return nil, err
+		}
+	}
+	if params.Priority != nil {
+		if res, err = ResourceSetPriority(ctx, tx, id, *params.Priority); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// rehashResource re-hashes every stored file of the resource, mismatching files are marked corrupted.
+func (s *Worker) rehashResource(ctx context.Context, id string) error {
+	r, err := s.verifier.VerifyResource(ctx, id, true)
+	if err != nil || r == nil {
+		return err
+	}
+	if r.Corrupted > 0 {
+		return errors.Errorf("%v of %v files corrupted", r.Corrupted, r.Checked)
+	}
+	return nil
+}
+
+// migrateResource rewrites objects of stored files of the resource with storage class sc (empty keeps
+// class of each file) and current server-side encryption settings. sc becomes the class of files stored later.
+func (s *Worker) migrateResource(ctx context.Context, db *pg.DB, id string, sc string) error {
+	rfs, err := ResourceFileListByPrefix(ctx, db, id, "/")
+	if err != nil {
+		return err
+	}
+	for _, rf := range rfs {
+		// chunks are shared by files, so they stay in the class they were uploaded with
+		if rf.File == nil || rf.File.Status != StatusStored || rf.File.Chunked {
+			continue
+		}
+		fsc := sc
+		if fsc == "" && rf.File.StorageClass != nil {
+			fsc = *rf.File.StorageClass
+		}
+		if err = s.copyObject(ctx, rf.File.bucketOr(s.bucket), s.keys.Key(rf.FileHash), s.keys.Key(rf.FileHash), fsc); err != nil {
+			return err
+		}
+		if err = FileSetStorageClass(ctx, db, rf.FileHash, fsc); err != nil {
+			return err
+		}
+	}
+	if sc != "" {
+		_, err = ResourceSetStorageClass(ctx, db, id, sc)
+	}
+	return err
+}
+
+// exportBulkOperation dumps metadata of all matched resources to exports/{bulk_id}.ndjson.gz of the bucket.
+func (s *Worker) exportBulkOperation(ctx context.Context, db *pg.DB, op *BulkOperation) error {
+	ids, err := BulkOperationPendingItems(ctx, db, op.BulkID, 0)
+	if err != nil {
+		return err
+	}
+	loc := "s3://" + s.bucket + "/" + s.keys.join("exports/"+op.BulkID.String()+".ndjson.gz")
+	w, err := CreateDump(ctx, s.s3, loc, true)
+	if err != nil {
+		return err
+	}
+	_, err = ExportMetadata(ctx, db, w, ids)
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	op.Location = &loc
+	return BulkOperationCompleteItems(ctx, db, op)
+}
+
+// createBulkOperation validates the request and queues the bulk operation. Only admins run rehash
+// and migrate actions or target resources not by labels.
+func (s *Web) createBulkOperation(c *gin.Context, admin bool) {
+	db := s.pg.Get()
+	if db == nil {
+		_ = c.Error(errors.New("DB not configured"))
+		return
+	}
+	var req BulkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		_ = c.Error(errors.Wrap(err, "failed to parse bulk request"))
+		return
+	}
+	op := &BulkOperation{Action: req.Action}
+	targets := 0
+	if req.Selector != "" {
+		if _, err := ParseLabelSelector(req.Selector); err != nil {
+			_ = c.Error(err)
+			return
+		}
+		op.Selector = &req.Selector
+		targets++
+	}
+	if req.Status != "" {
+		st, err := ParseStatus(req.Status)
+		if err != nil {
+			_ = c.Error(errors.Wrap(err, "failed to parse bulk request"))
+			return
+		}
+		name := st.String()
+		op.TargetStatus = &name
+		targets++
+	}
+	if req.IDs != nil {
+		if len(req.IDs) == 0 || len(req.IDs) > maxBulkIDs {
+			_ = c.Error(errors.Errorf("failed to parse bulk request: 1-%v ids are required", maxBulkIDs))
+			return
+		}
+		targets++
+	}
+	if targets != 1 {
+		_ = c.Error(errors.New("failed to parse bulk request: exactly one of selector, status and ids is required"))
+		return
+	}
+	if !admin && op.Selector == nil {
+		_ = c.Error(errors.New("forbidden: only admins target resources by status or ids"))
+		return
+	}
+	params := req.Params
+	if params == nil {
+		params = &BulkParams{}
+	}
+	switch req.Action {
+	case BulkActionDelete:
+		if !admin && !s.auth.Allowed(c, TokenScopeDelete) {
+			_ = c.Error(errors.Errorf("forbidden: %v scope required", TokenScopeDelete))
+			return
+		}
+	case BulkActionRetry:
+		params.RetryDeletes = admin || s.auth.Allowed(c, TokenScopeDelete)
+	case BulkActionPolicy:
+		_, setExpiry, err := params.expiry(time.Now())
+		if err != nil {
+			_ = c.Error(err)
+			return
+		}
+		if _, err = ParseStorageClass(params.StorageClass); err != nil {
+			_ = c.Error(err)
+			return
+		}
+		if !setExpiry && params.StorageClass == "" {
+			_ = c.Error(errors.New("failed to parse bulk request: policy params are required"))
+			return
+		}
+	case BulkActionExport:
+	case BulkActionRehash, BulkActionMigrate:
+		if !admin {
+			_ = c.Error(errors.Errorf("forbidden: %v action is only available to admins", req.Action))
+			return
+		}
+		if _, err := ParseStorageClass(params.StorageClass); err != nil {
+			_ = c.Error(err)
+			return
+		}
+	default:
+		_ = c.Error(errors.Errorf("failed to parse bulk action %v", req.Action))
+		return
+	}
+	op.Params = params
+	err := db.RunInTransaction(c.Request.Context(), func(tx *pg.Tx) error {
+		return BulkOperationCreate(c.Request.Context(), tx, op, req.IDs)
+	})
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	log.WithFields(log.Fields{"bulk_id": op.BulkID, "action": op.Action}).Info("bulk operation queued")
+	c.JSON(http.StatusAccepted, gin.H{"bulk_operation": op})
+}
+
+// POST /resources/bulk
+// postBulkOperation godoc
+// @Summary      Queue bulk operation on resources matched by labels
+// @Description  Queues action for every resource whose labels match selector (comma-separated key=value or key terms,
+// @Description  e.g. tenant=x,tier=cold). Actions are delete (requires delete scope), retry (delete_error resources
+// @Description  are only retried with delete scope), policy (params ttl/expires_at and storage_class) and export
+// @Description  (metadata dump written to exports/{bulk_id}.ndjson.gz of the bucket). The operation runs asynchronously
+// @Description  on a worker, progress and per-resource results are returned by GET /resources/bulk/{id}.
+// @Tags         resource
+// @Accept       json
+// @Param        request  body      BulkRequest  true  "Action and label selector"
+// @Success      202  {object}  BulkOperation
+// @Failure      400  {object}  ErrorResponse
+// @Failure      403  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /resources/bulk [post]
+func (s *Web) postBulkOperation(c *gin.Context) {
+	s.createBulkOperation(c, false)
+}
+
+// POST /admin/bulk
+// postAdminBulkOperation godoc
+// @Summary      Queue bulk operation
+// @Description  Queues action for resources matched by label selector, by status (e.g. store_error) or listed in ids.
+// @Description  Besides actions of POST /resources/bulk, rehash re-hashes stored files marking mismatching ones
+// @Description  corrupted and migrate rewrites stored objects with storage_class of params (or their current class)
+// @Description  and current server-side encryption settings.
+// @Tags         admin
+// @Accept       json
+// @Param        request  body      BulkRequest  true  "Action and target"
+// @Success      202  {object}  BulkOperation
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      403  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /admin/bulk [post]
+func (s *Web) postAdminBulkOperation(c *gin.Context) {
+	s.createBulkOperation(c, true)
+}
+
+// GET /admin/bulk
+// getBulkOperations godoc
+// @Summary      List bulk operations
+// @Description  Returns bulk operations, newest first.
+// @Tags         admin
+// @Param        status  query     string  false  "Status: queued, running, done, failed or cancelled"
+// @Param        limit   query     int     false  "Page size (default 50, max 1000)"
+// @Param        offset  query     int     false  "Page offset"
+// @Success      200  {object}  BulkOperationsResponse
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      403  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /admin/bulk [get]
+func (s *Web) getBulkOperations(c *gin.Context) {
+	db := s.pg.Get()
+	if db == nil {
+		_ = c.Error(errors.New("DB not configured"))
+		return
+	}
+	limit, offset, err := parseListLimits(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	list, total, err := BulkOperationList(c.Request.Context(), db, BulkStatus(c.Query("status")), limit, offset)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, &BulkOperationsResponse{Operations: list, Total: total, Limit: limit, Offset: offset})
+}
+
+// GET /resources/bulk/{id}
+// getBulkOperation godoc
+// @Summary      Get bulk operation
+// @Description  Returns status and progress of the bulk operation with a page of per-resource results.
+// @Description  The same is served for admins by GET /admin/bulk/{id}.
+// @Tags         resource
+// @Param        id      path      string  true   "Bulk operation ID"
+// @Param        result  query     string  false  "Only items with result: pending, done, skipped, not_found, failed or cancelled"
+// @Param        limit   query     int     false  "Page size (default 50, max 1000)"
+// @Param        offset  query     int     false  "Page offset"
+// @Success      200  {object}  BulkOperationResponse
+// @Failure      400  {object}  ErrorResponse
+// @Failure      404  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /resources/bulk/{id} [get]
+func (s *Web) getBulkOperation(c *gin.Context) {
+	db := s.pg.Get()
+	if db == nil {
+		_ = c.Error(errors.New("DB not configured"))
+		return
+	}
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		_ = c.Error(errors.Wrap(err, "failed to parse bulk operation id"))
+		return
+	}
+	limit, offset, err := parseListLimits(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	op, err := BulkOperationGet(c.Request.Context(), db, id)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if op == nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	items, total, err := BulkOperationItemList(c.Request.Context(), db, id, BulkItemResult(c.Query("result")), limit, offset)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, &BulkOperationResponse{Operation: op, Items: items, Total: total, Limit: limit, Offset: offset})
+}
+
+// POST /admin/bulk/{id}/cancel
+// postBulkOperationCancel godoc
+// @Summary      Cancel bulk operation
+// @Description  Stops a queued or running bulk operation after the resource being processed, pending resources
+// @Description  are reported as cancelled. Finished operations are returned unchanged.
+// @Tags         admin
+// @Param        id   path      string  true  "Bulk operation ID"
+// @Success      200  {object}  BulkOperation
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      403  {object}  ErrorResponse
+// @Failure      404  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /admin/bulk/{id}/cancel [post]
+func (s *Web) postBulkOperationCancel(c *gin.Context) {
+	db := s.pg.Get()
+	if db == nil {
+		_ = c.Error(errors.New("DB not configured"))
+		return
+	}
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		_ = c.Error(errors.Wrap(err, "failed to parse bulk operation id"))
+		return
+	}
+	var op *BulkOperation
+	err = db.RunInTransaction(c.Request.Context(), func(tx *pg.Tx) error {
+		var err error
+		op, err = BulkOperationCancel(c.Request.Context(), tx, id)
+		return err
+	})
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if op == nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	log.WithFields(log.Fields{"bulk_id": op.BulkID, "status": op.Status}).Info("bulk operation cancelled")
+	c.JSON(http.StatusOK, gin.H{"bulk_operation": op})
+}
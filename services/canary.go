@@ -0,0 +1,222 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+const (
+	canaryResourceFlag = "canary-resource"
+	canaryIntervalFlag = "canary-interval"
+	canaryTimeoutFlag  = "canary-timeout"
+)
+
+// Canary check stages in the order they are run.
+const (
+	CanaryStageRestAPI = "rest_api"
+	CanaryStageStored  = "stored"
+	CanaryStageS3      = "s3"
+	CanaryStageWebseed = "webseed"
+)
+
+var canaryStages = []string{CanaryStageRestAPI, CanaryStageStored, CanaryStageS3, CanaryStageWebseed}
+
+// canaryRemoteAddr is the client address of canary webseed requests.
+const canaryRemoteAddr = "127.0.0.1:0"
+
+// RegisterCanaryFlags registers CLI flags for synthetic canary monitoring.
+func RegisterCanaryFlags(f []cli.Flag) []cli.Flag {
+	return append(f,
+		cli.StringFlag{
+			Name:   canaryResourceFlag,
+			Usage:  "infohash of a small resource re-verified end-to-end (rest-api listing, S3 read, webseed fetch) every canary-interval, it is queued for storing if missing (empty disables)",
+			EnvVar: "CANARY_RESOURCE",
+		},
+		cli.DurationFlag{
+			Name:   canaryIntervalFlag,
+			Usage:  "how often the canary resource is verified",
+			Value:  5 * time.Minute,
+			EnvVar: "CANARY_INTERVAL",
+		},
+		cli.DurationFlag{
+			Name:   canaryTimeoutFlag,
+			Usage:  "timeout of a single canary check",
+			Value:  time.Minute,
+			EnvVar: "CANARY_TIMEOUT",
+		},
+	)
+}
+
+// Canary periodically verifies the canary resource through the whole pipeline and exports the result
+// as vault_canary_up and per stage vault_canary_stage_up metrics.
+type Canary struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	web      *Web
+	api      *Api
+	id       string
+	interval time.Duration
+	timeout  time.Duration
+}
+
+// NewCanary returns nil if canary resource is not set.
+func NewCanary(c *cli.Context, web *Web, api *Api) (*Canary, error) {
+	v := c.String(canaryResourceFlag)
+	if v == "" {
+		return nil, nil
+	}
+	id := NormalizeInfohash(v)
+	if !infohashRe.MatchString(id) {
+		return nil, errors.Errorf("failed to parse canary resource %v", v)
+	}
+	if c.Duration(canaryIntervalFlag) <= 0 || c.Duration(canaryTimeoutFlag) <= 0 {
+		return nil, errors.New("canary interval and timeout must be positive")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Canary{
+		ctx:      ctx,
+		cancel:   cancel,
+		web:      web,
+		api:      api,
+		id:       id,
+		interval: c.Duration(canaryIntervalFlag),
+		timeout:  c.Duration(canaryTimeoutFlag),
+	}, nil
+}
+
+func (s *Canary) Serve() error {
+	log.Infof("verifying canary resource %v every %v", s.id, s.interval)
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		s.run()
+		select {
+		case <-s.ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// run checks the canary and updates metrics. Stages following the failed one are reported down.
+func (s *Canary) run() {
+	ctx, cancel := context.WithTimeout(s.ctx, s.timeout)
+	defer cancel()
+	start := time.Now()
+	stage, err := s.check(ctx)
+	if s.ctx.Err() != nil {
+		return
+	}
+	up := true
+	for _, st := range canaryStages {
+		if st == stage {
+			up = false
+		}
+		promCanaryStageUp.WithLabelValues(st).Set(boolGauge(up))
+	}
+	promCanaryUp.Set(boolGauge(err == nil))
+	l := log.WithFields(log.Fields{"id": s.id, "duration": time.Since(start)})
+	if err != nil {
+		l.WithError(err).WithField("stage", stage).Warn("canary check failed")
+		return
+	}
+	promCanaryLastSuccess.SetToCurrentTime()
+	l.Debug("canary check passed")
+}
+
+// check verifies the canary and returns the failed stage along with the error.
+func (s *Canary) check(ctx context.Context) (string, error) {
+	db := s.web.pg.Get()
+	if db == nil {
+		return CanaryStageStored, errors.New("DB not configured")
+	}
+	items, err := s.api.ListResourceFiles(ctx, &Claims{Role: "vault"}, s.id)
+	if err != nil {
+		return CanaryStageRestAPI, errors.Wrap(err, "failed to list canary resource")
+	}
+	if len(items) == 0 {
+		return CanaryStageRestAPI, errors.New("canary resource has no files in rest-api")
+	}
+	rf, err := s.storedFile(ctx, db)
+	if err != nil {
+		return CanaryStageStored, err
+	}
+	eo, _, err := s.web.headEncryptedObject(ctx, rf.File.bucketOr(s.web.bucket), rf.FileHash)
+	if err != nil {
+		return CanaryStageS3, errors.Wrap(err, "failed to head canary object")
+	}
+	hash, err := s.web.verifier.hashObject(ctx, rf.File, eo)
+	if err != nil {
+		return CanaryStageS3, errors.Wrap(err, "failed to read canary object")
+	}
+	if hash != rf.FileHash {
+		return CanaryStageS3, errors.Errorf("canary object hash %v does not match", hash)
+	}
+	token, err := s.web.auth.internalToken(s.timeout)
+	if err != nil {
+		return CanaryStageWebseed, err
+	}
+	st := &selfTester{web: s.web, ctx: ctx, token: token, remoteAddr: canaryRemoteAddr}
+	ft := st.checkFile(s.id, rf, 1, defaultSelfTestRangeSize)
+	if !ft.Passed {
+		if ft.Error == "" && len(ft.Ranges) > 0 {
+			ft.Error = ft.Ranges[0].Error
+		}
+		return CanaryStageWebseed, errors.Errorf("canary file %v failed webseed fetch: %v", rf.Path, ft.Error)
+	}
+	return "", nil
+}
+
+// storedFile returns the smallest stored file of the canary resource, queuing the resource for
+// storing if it does not exist.
+func (s *Canary) storedFile(ctx context.Context, db *pg.DB) (*ResourceFile, error) {
+	res, err := ResourceGetByID(ctx, db, s.id)
+	if err != nil {
+		return nil, err
+	}
+	if res == nil {
+		if _, err = ResourceQueueForStoring(ctx, db, s.id); err != nil {
+			return nil, errors.Wrap(err, "failed to register canary resource")
+		}
+		log.WithField("id", s.id).Info("canary resource queued for storing")
+		return nil, errors.New("canary resource is not stored yet")
+	}
+	if res.Status != StatusStored {
+		if res.Error != nil {
+			return nil, errors.Errorf("canary resource is %v: %v", res.Status, *res.Error)
+		}
+		return nil, errors.Errorf("canary resource is %v", res.Status)
+	}
+	var rfs []ResourceFile
+	err = db.Model(&rfs).Context(ctx).
+		Relation("File").
+		Where("resource_file.resource_id = ?", s.id).
+		Where("file.status = ?", StatusStored).
+		Order("file.total_size").
+		Limit(1).
+		Select()
+	if err != nil && !errors.Is(err, pg.ErrNoRows) {
+		return nil, err
+	}
+	if len(rfs) == 0 {
+		return nil, errors.New("canary resource has no stored files")
+	}
+	return &rfs[0], nil
+}
+
+func (s *Canary) Close() {
+	log.Info("closing Canary")
+	s.cancel()
+}
+
+func boolGauge(v bool) float64 {
+	if v {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,217 @@
+package services
+
+import (
+	"context"
+	"io"
+	"math/bits"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	awss3 "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+)
+
+const (
+	chunkingFlag     = "chunking"
+	chunkAvgSizeFlag = "chunk-avg-size"
+)
+
+// chunkKeyPrefix is where chunks of files stored in chunking mode are uploaded
+const chunkKeyPrefix = "chunks/"
+
+// RegisterChunkingFlags registers CLI flags for content-defined chunking of stored files.
+func RegisterChunkingFlags(f []cli.Flag) []cli.Flag {
+	return append(f,
+		cli.BoolFlag{
+			Name:   chunkingFlag,
+			Usage:  "split new files into content-defined chunks (FastCDC) stored once under their sha256, so content shared by different files is deduplicated; requires full-sha256 or blake3 hash mode",
+			EnvVar: "CHUNKING",
+		},
+		cli.IntFlag{
+			Name:   chunkAvgSizeFlag,
+			Usage:  "average chunk size in bytes, power of two; chunks are 1/4 to 4 times as large",
+			Value:  2 * 1024 * 1024,
+			EnvVar: "CHUNK_AVG_SIZE",
+		},
+	)
+}
+
+// Chunking splits content into chunks with FastCDC, boundaries depend on content only, so the same
+// content inside different files produces the same chunks.
+type Chunking struct {
+	min, avg, max int
+	// maskS is used before the average size is reached and makes cuts less likely, maskL after it
+	maskS, maskL uint64
+}
+
+// NewChunking returns nil if chunking is disabled.
+func NewChunking(c *cli.Context, algo HashAlgo, enc *Encryption) (*Chunking, error) {
+	if !c.Bool(chunkingFlag) {
+		return nil, nil
+	}
+	avg := c.Int(chunkAvgSizeFlag)
+	if avg < 64*1024 || avg&(avg-1) != 0 {
+		return nil, errors.Errorf("chunk avg size %v must be a power of two not less than 64KB", avg)
+	}
+	if !algo.Full() {
+		return nil, errors.Errorf("chunking requires full-sha256 or blake3 hash mode, got %v", algo)
+	}
+	if enc.ClientSide() {
+		return nil, errors.New("chunking is not supported with client-side encryption")
+	}
+	b := bits.TrailingZeros(uint(avg))
+	return &Chunking{
+		min:   avg / 4,
+		avg:   avg,
+		max:   avg * 4,
+		maskS: cdcMask(b + 2),
+		maskL: cdcMask(b - 2),
+	}, nil
+}
+
+// cdcMask has n highest bits set, they depend on the last 64 bytes of the rolling gear hash.
+func cdcMask(n int) uint64 {
+	return ^uint64(0) << (64 - n)
+}
+
+// cdcGear maps bytes to random values of the rolling hash, it must never change as it defines chunk boundaries.
+var cdcGear = func() (g [256]uint64) {
+	// splitmix64 with a fixed seed
+	x := uint64(0x7661756c74636463)
+	for i := range g {
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		g[i] = z ^ (z >> 31)
+	}
+	return
+}()
+
+// cut returns length of the first chunk of b, b holds the whole rest of content or at least max bytes.
+func (s *Chunking) cut(b []byte) int {
+	n := len(b)
+	if n <= s.min {
+		return n
+	}
+	n = min(n, s.max)
+	normal := min(n, s.avg)
+	var fp uint64
+	i := s.min
+	for ; i < normal; i++ {
+		fp = fp<<1 + cdcGear[b[i]]
+		if fp&s.maskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		fp = fp<<1 + cdcGear[b[i]]
+		if fp&s.maskL == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
+
+// cdcChunker reads content chunk by chunk.
+type cdcChunker struct {
+	c   *Chunking
+	r   io.Reader
+	buf []byte
+	eof bool
+}
+
+func (s *Chunking) newChunker(r io.Reader) *cdcChunker {
+	return &cdcChunker{c: s, r: r, buf: make([]byte, 0, s.max)}
+}
+
+// Next returns the next chunk, io.EOF is returned after the last one.
+func (s *cdcChunker) Next() ([]byte, error) {
+	for !s.eof && len(s.buf) < s.c.max {
+		n, err := s.r.Read(s.buf[len(s.buf):s.c.max])
+		s.buf = s.buf[:len(s.buf)+n]
+		if err == io.EOF {
+			s.eof = true
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	if len(s.buf) == 0 {
+		return nil, io.EOF
+	}
+	n := s.c.cut(s.buf)
+	chunk := append([]byte(nil), s.buf[:n]...)
+	s.buf = s.buf[:copy(s.buf, s.buf[n:])]
+	return chunk, nil
+}
+
+// chunkedReader reads bytes [pos, end] of a chunked file, requesting ranges of chunk objects one by one.
+type chunkedReader struct {
+	ctx    context.Context
+	cl     *awss3.S3
+	bucket string
+	keys   *ObjectKeys
+	chunks []FileChunk
+	pos    int64
+	end    int64
+	body   io.ReadCloser
+	// left is the number of bytes to read from body
+	left int64
+}
+
+func newChunkedReader(ctx context.Context, cl *awss3.S3, bucket string, keys *ObjectKeys, chunks []FileChunk, start, end int64) *chunkedReader {
+	return &chunkedReader{ctx: ctx, cl: cl, bucket: bucket, keys: keys, chunks: chunks, pos: start, end: end}
+}
+
+func (s *chunkedReader) open() error {
+	i := sort.Search(len(s.chunks), func(i int) bool {
+		return s.chunks[i].Offset+s.chunks[i].Size > s.pos
+	})
+	if i == len(s.chunks) {
+		return errors.Errorf("no chunk at offset %v", s.pos)
+	}
+	c := &s.chunks[i]
+	from, to := s.pos-c.Offset, min(s.end, c.Offset+c.Size-1)-c.Offset
+	out, err := s.cl.GetObjectWithContext(s.ctx, &awss3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.keys.ChunkKey(c.ChunkHash)),
+		Range:  aws.String((&byteRange{start: from, end: to}).String()),
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to get chunk %v", c.ChunkHash)
+	}
+	s.body = out.Body
+	s.left = to - from + 1
+	return nil
+}
+
+func (s *chunkedReader) Read(b []byte) (int, error) {
+	if s.pos > s.end {
+		return 0, io.EOF
+	}
+	if s.body == nil {
+		if err := s.open(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := s.body.Read(b[:min(int64(len(b)), s.left)])
+	s.pos += int64(n)
+	s.left -= int64(n)
+	if s.left == 0 {
+		_ = s.Close()
+		return n, nil
+	}
+	if err == io.EOF {
+		return n, io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (s *chunkedReader) Close() error {
+	if s.body != nil {
+		_ = s.body.Close()
+		s.body = nil
+	}
+	return nil
+}
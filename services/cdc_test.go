@@ -0,0 +1,267 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"flag"
+	"io"
+	"strconv"
+	"testing"
+	"testing/iotest"
+
+	"github.com/urfave/cli"
+)
+
+const testChunkAvgSize = 64 * 1024
+
+func testChunking(t *testing.T, avg int) *Chunking {
+	t.Helper()
+	set := flag.NewFlagSet("test", flag.ContinueOnError)
+	for _, f := range RegisterChunkingFlags(nil) {
+		f.Apply(set)
+	}
+	if err := set.Set(chunkingFlag, "true"); err != nil {
+		t.Fatal(err)
+	}
+	if err := set.Set(chunkAvgSizeFlag, strconv.Itoa(avg)); err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewChunking(cli.NewContext(nil, set, nil), HashAlgoFullSHA256, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// testChunks splits content with the chunker and returns chunks.
+func testChunks(t *testing.T, s *Chunking, r io.Reader) [][]byte {
+	t.Helper()
+	ch := s.newChunker(r)
+	var res [][]byte
+	for {
+		c, err := ch.Next()
+		if err == io.EOF {
+			return res
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		res = append(res, c)
+	}
+}
+
+func TestNewChunkingAvgSize(t *testing.T) {
+	for _, avg := range []int{32 * 1024, 100 * 1024, 0} {
+		set := flag.NewFlagSet("test", flag.ContinueOnError)
+		for _, f := range RegisterChunkingFlags(nil) {
+			f.Apply(set)
+		}
+		_ = set.Set(chunkingFlag, "true")
+		_ = set.Set(chunkAvgSizeFlag, strconv.Itoa(avg))
+		if _, err := NewChunking(cli.NewContext(nil, set, nil), HashAlgoFullSHA256, nil); err == nil {
+			t.Errorf("expected error for avg size %v", avg)
+		}
+	}
+	s := testChunking(t, testChunkAvgSize)
+	if s.min != testChunkAvgSize/4 || s.avg != testChunkAvgSize || s.max != testChunkAvgSize*4 {
+		t.Errorf("sizes = %v/%v/%v", s.min, s.avg, s.max)
+	}
+}
+
+func TestChunkingBoundaries(t *testing.T) {
+	s := testChunking(t, testChunkAvgSize)
+	tests := []struct {
+		name string
+		size int
+	}{
+		{name: "empty", size: 0},
+		{name: "single byte", size: 1},
+		{name: "min", size: s.min},
+		{name: "min+1", size: s.min + 1},
+		{name: "max", size: s.max},
+		{name: "max+1", size: s.max + 1},
+		{name: "large", size: 8 << 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := testContent(1, tt.size)
+			chunks := testChunks(t, s, bytes.NewReader(content))
+			if tt.size == 0 {
+				if len(chunks) != 0 {
+					t.Fatalf("got %v chunks of empty content", len(chunks))
+				}
+				return
+			}
+			if got := bytes.Join(chunks, nil); !bytes.Equal(got, content) {
+				t.Fatal("chunks do not add up to content")
+			}
+			for i, c := range chunks {
+				if len(c) > s.max {
+					t.Errorf("chunk %v of %v bytes is larger than max %v", i, len(c), s.max)
+				}
+				if i < len(chunks)-1 && len(c) <= s.min {
+					t.Errorf("chunk %v of %v bytes is not larger than min %v", i, len(c), s.min)
+				}
+			}
+			if tt.size <= s.min && len(chunks) != 1 {
+				t.Errorf("got %v chunks of content not larger than min", len(chunks))
+			}
+		})
+	}
+}
+
+func TestChunkingAvgSize(t *testing.T) {
+	s := testChunking(t, testChunkAvgSize)
+	chunks := testChunks(t, s, bytes.NewReader(testContent(2, 32<<20)))
+	avg := (32 << 20) / len(chunks)
+	if avg < s.avg/2 || avg > s.avg*2 {
+		t.Errorf("average chunk size %v is far from %v", avg, s.avg)
+	}
+}
+
+func TestChunkingDeterministic(t *testing.T) {
+	s := testChunking(t, testChunkAvgSize)
+	content := testContent(3, 4<<20)
+	want := testChunks(t, s, bytes.NewReader(content))
+	tests := []struct {
+		name string
+		s    *Chunking
+		r    io.Reader
+	}{
+		{name: "again", s: s, r: bytes.NewReader(content)},
+		// boundaries must not depend on how content is read
+		{name: "short reads", s: s, r: iotest.HalfReader(bytes.NewReader(content))},
+		{name: "another instance", s: testChunking(t, testChunkAvgSize), r: bytes.NewReader(content)},
+	}
+	for _, tt := range tests {
+		if got := testChunks(t, tt.s, tt.r); !equalChunks(got, want) {
+			t.Errorf("%v: boundaries differ", tt.name)
+		}
+	}
+}
+
+func equalChunks(a, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestChunkingShiftResistance(t *testing.T) {
+	s := testChunking(t, testChunkAvgSize)
+	content := testContent(4, 8<<20)
+	tests := []struct {
+		name    string
+		shifted []byte
+	}{
+		{name: "prepended", shifted: append(testContent(5, 100), content...)},
+		{name: "prepended byte", shifted: append([]byte{0}, content...)},
+		{name: "cut head", shifted: content[1000:]},
+		{name: "inserted", shifted: append(append(append([]byte(nil), content[:4<<20]...), testContent(6, 333)...), content[4<<20:]...)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orig := map[[32]byte]bool{}
+			chunks := testChunks(t, s, bytes.NewReader(content))
+			for _, c := range chunks {
+				orig[sha256.Sum256(c)] = true
+			}
+			shared := 0
+			shifted := testChunks(t, s, bytes.NewReader(tt.shifted))
+			for _, c := range shifted {
+				if orig[sha256.Sum256(c)] {
+					shared++
+				}
+			}
+			// only chunks around the edit may change
+			if shared < len(chunks)-3 {
+				t.Errorf("only %v of %v chunks are shared after the edit", shared, len(chunks))
+			}
+		})
+	}
+}
+
+func TestChunkedReader(t *testing.T) {
+	keys, err := ParseObjectKeys("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+	srv := &testS3{objects: map[string][]byte{}}
+	var chunks []FileChunk
+	var off int64
+	for i, size := range []int64{5, 3, 7, 1, 4, 16} {
+		hash := "c" + strconv.Itoa(i)
+		srv.objects[keys.ChunkKey(hash)] = content[off : off+size]
+		chunks = append(chunks, FileChunk{Seq: i, Offset: off, Size: size, ChunkHash: hash})
+		off += size
+	}
+	cl := newTestS3Client(t, srv)
+	tests := []struct {
+		name       string
+		start, end int64
+		requests   int
+	}{
+		{name: "whole", start: 0, end: 35, requests: 6},
+		{name: "inside chunk", start: 1, end: 3, requests: 1},
+		{name: "whole chunk", start: 5, end: 7, requests: 1},
+		{name: "chunk edge", start: 4, end: 5, requests: 2},
+		{name: "from chunk start", start: 8, end: 16, requests: 3},
+		{name: "to chunk end", start: 6, end: 14, requests: 2},
+		{name: "single byte chunk", start: 15, end: 15, requests: 1},
+		{name: "across chunks", start: 3, end: 30, requests: 6},
+		{name: "last byte", start: 35, end: 35, requests: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, one := range []bool{false, true} {
+				srv.ranges = nil
+				var r io.Reader = newChunkedReader(context.Background(), cl, "bucket", keys, chunks, tt.start, tt.end)
+				if one {
+					r = iotest.OneByteReader(r)
+				}
+				got, err := io.ReadAll(r)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if want := content[tt.start : tt.end+1]; !bytes.Equal(got, want) {
+					t.Errorf("read %q, want %q", got, want)
+				}
+				if len(srv.ranges) != tt.requests {
+					t.Errorf("%v requests %q, want %v", len(srv.ranges), srv.ranges, tt.requests)
+				}
+			}
+		})
+	}
+}
+
+func TestChunkedReaderMissingChunk(t *testing.T) {
+	keys, err := ParseObjectKeys("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &testS3{objects: map[string][]byte{keys.ChunkKey("c0"): []byte("01234")}}
+	chunks := []FileChunk{
+		{Seq: 0, Offset: 0, Size: 5, ChunkHash: "c0"},
+		{Seq: 1, Offset: 5, Size: 5, ChunkHash: "c1"},
+	}
+	r := newChunkedReader(context.Background(), newTestS3Client(t, srv), "bucket", keys, chunks, 0, 9)
+	got, err := io.ReadAll(r)
+	if err == nil {
+		t.Fatal("expected error reading missing chunk")
+	}
+	if string(got) != "01234" {
+		t.Errorf("read %q before error, want %q", got, "01234")
+	}
+	// offsets past the last chunk
+	r = newChunkedReader(context.Background(), newTestS3Client(t, srv), "bucket", keys, chunks[:1], 0, 9)
+	if _, err := io.ReadAll(r); err == nil {
+		t.Fatal("expected error reading past the last chunk")
+	}
+}
@@ -0,0 +1,287 @@
+package services
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// cdnAuditMaxFullSize caps files fetched whole by the audit, larger ones are only fetched by ranges.
+const cdnAuditMaxFullSize = 1024 * 1024
+
+// cdnAuditHeaders are response headers reported by the audit.
+var cdnAuditHeaders = []string{
+	"Cache-Control", "Expires", "ETag", "Last-Modified", "Vary", "Accept-Ranges",
+	"Content-Type", "Content-Length", "Content-Range", "Set-Cookie", degradedHeader,
+}
+
+// heuristicallyCacheable are statuses shared caches may store without explicit freshness (RFC 9111 4.2.2).
+var heuristicallyCacheable = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusPartialContent:       true,
+	http.StatusMultipleChoices:      true,
+	http.StatusMovedPermanently:     true,
+	http.StatusPermanentRedirect:    true,
+	http.StatusNotFound:             true,
+	http.StatusMethodNotAllowed:     true,
+	http.StatusGone:                 true,
+	http.StatusRequestURITooLong:    true,
+	http.StatusNotImplemented:       true,
+}
+
+// CDNAuditCheck is a single webseed request replayed by the audit and cacheability of its response
+// by a shared cache.
+type CDNAuditCheck struct {
+	Name    string            `json:"name"`
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Request map[string]string `json:"request,omitempty"`
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers"`
+	// Cacheable tells whether a shared cache may store the response
+	Cacheable bool `json:"cacheable"`
+	// TTL is the explicit freshness lifetime in seconds, nil if caches would fall back to heuristics
+	TTL    *int64   `json:"ttl,omitempty"`
+	Issues []string `json:"issues"`
+	Error  string   `json:"error,omitempty"`
+}
+
+// CDNAuditReport tells how CDNs would cache webseed responses of the resource.
+type CDNAuditReport struct {
+	ResourceID string `json:"resource_id"`
+	Path       string `json:"path"`
+	// Cacheable is set if content responses (full and range reads) are cacheable
+	Cacheable bool            `json:"cacheable"`
+	Checks    []CDNAuditCheck `json:"checks"`
+}
+
+// cacheControl parses Cache-Control directives, lower-cased, with unquoted values.
+func cacheControl(h string) map[string]string {
+	d := map[string]string{}
+	for _, v := range strings.Split(h, ",") {
+		k, val, _ := strings.Cut(strings.TrimSpace(v), "=")
+		if k == "" {
+			continue
+		}
+		d[strings.ToLower(k)] = strings.Trim(val, `"`)
+	}
+	return d
+}
+
+// auditCacheability evaluates response of a request sent with or without token as a shared cache would.
+func auditCacheability(ck *CDNAuditCheck, header http.Header, withToken bool) {
+	cc := cacheControl(header.Get("Cache-Control"))
+	ck.Cacheable = true
+	fail := func(issue string) {
+		ck.Cacheable = false
+		ck.Issues = append(ck.Issues, issue)
+	}
+	if _, ok := cc["no-store"]; ok {
+		fail("Cache-Control: no-store")
+	}
+	if _, ok := cc["private"]; ok {
+		fail("Cache-Control: private")
+	}
+	for _, k := range []string{"s-maxage", "max-age"} {
+		v, ok := cc[k]
+		if !ok {
+			continue
+		}
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			ck.TTL = &n
+			break
+		}
+	}
+	if ck.TTL == nil {
+		if t, err := http.ParseTime(header.Get("Expires")); err == nil {
+			n := max(int64(time.Until(t).Seconds()), 0)
+			ck.TTL = &n
+		}
+	}
+	if ck.TTL == nil {
+		if !heuristicallyCacheable[ck.Status] {
+			fail("status " + strconv.Itoa(ck.Status) + " is not cacheable without explicit freshness")
+		} else {
+			ck.Issues = append(ck.Issues, "no max-age, s-maxage or Expires: freshness is up to CDN heuristics")
+		}
+	} else if *ck.TTL == 0 {
+		ck.Issues = append(ck.Issues, "freshness lifetime is 0, every request is revalidated")
+	}
+	if _, ok := cc["no-cache"]; ok {
+		ck.Issues = append(ck.Issues, "Cache-Control: no-cache, every request is revalidated")
+	}
+	if header.Get("Set-Cookie") != "" {
+		fail("Set-Cookie is present, most CDNs don't cache such responses")
+	}
+	for _, v := range strings.Split(header.Get("Vary"), ",") {
+		v = strings.TrimSpace(v)
+		switch {
+		case v == "":
+		case v == "*":
+			fail("Vary: * prevents caching")
+		default:
+			ck.Issues = append(ck.Issues, "Vary: "+v+" splits cache entries by the request header")
+		}
+	}
+	if ck.Status == http.StatusOK || ck.Status == http.StatusPartialContent {
+		if header.Get("ETag") == "" && header.Get("Last-Modified") == "" {
+			ck.Issues = append(ck.Issues, "no ETag or Last-Modified, stale entries can't be revalidated")
+		}
+		if header.Get("Accept-Ranges") != "bytes" {
+			ck.Issues = append(ck.Issues, "Accept-Ranges: bytes is missing, CDNs may not serve ranges from cache")
+		}
+	}
+	if ck.Status == http.StatusPartialContent {
+		ck.Issues = append(ck.Issues, "206 responses are cached only by CDNs caching range requests or slices")
+	}
+	if withToken && ck.Cacheable && !strings.Contains(strings.ToLower(header.Get("Vary")), "x-token") {
+		ck.Issues = append(ck.Issues, "response depends on X-Token without Vary: X-Token, CDN must not cache authorized responses unless the token is part of the cache key")
+	}
+	if header.Get(degradedHeader) != "" {
+		ck.Issues = append(ck.Issues, "served in degraded mode, DB is unavailable")
+	}
+}
+
+// cdnAuditRequest describes a replayed request.
+type cdnAuditRequest struct {
+	name    string
+	method  string
+	path    string
+	header  http.Header
+	noToken bool
+	// expect is the status of a well-behaved response, 0 if any
+	expect int
+	// content marks requests for file content, which must be cacheable for CDN offload
+	content bool
+}
+
+func (s *selfTester) audit(id string, r *cdnAuditRequest) CDNAuditCheck {
+	ck := CDNAuditCheck{Name: r.name, Method: r.method, Path: r.path, Headers: map[string]string{}, Issues: []string{}}
+	for k := range r.header {
+		if ck.Request == nil {
+			ck.Request = map[string]string{}
+		}
+		ck.Request[k] = r.header.Get(k)
+	}
+	st := s
+	if r.noToken {
+		st = &selfTester{web: s.web, ctx: s.ctx, remoteAddr: s.remoteAddr}
+	}
+	w, err := st.do(r.method, id, r.path, r.header)
+	if err != nil {
+		ck.Error = err.Error()
+		return ck
+	}
+	res := w.Result()
+	ck.Status = res.StatusCode
+	for _, k := range cdnAuditHeaders {
+		if v := res.Header.Values(k); len(v) > 0 {
+			ck.Headers[k] = strings.Join(v, ", ")
+		}
+	}
+	if r.expect != 0 && ck.Status != r.expect {
+		ck.Issues = append(ck.Issues, "expected status "+strconv.Itoa(r.expect))
+	}
+	// 304 only refreshes the stored response
+	if ck.Status != http.StatusNotModified {
+		auditCacheability(&ck, res.Header, st.token != "")
+	}
+	return ck
+}
+
+// cdnAuditRequests returns the representative set of webseed requests for the file following HEAD request,
+// revalidation uses etag of its response.
+func cdnAuditRequests(rf *ResourceFile, etag string) []cdnAuditRequest {
+	f := rf.File
+	rng := func(v string) http.Header {
+		return http.Header{"Range": []string{v}}
+	}
+	end := min(f.TotalSize, 1024) - 1
+	var reqs []cdnAuditRequest
+	if f.TotalSize <= cdnAuditMaxFullSize {
+		reqs = append(reqs, cdnAuditRequest{name: "full", method: http.MethodGet, path: rf.Path, content: true})
+	}
+	if end >= 0 {
+		reqs = append(reqs,
+			cdnAuditRequest{name: "range", method: http.MethodGet, path: rf.Path, header: rng("bytes=0-" + strconv.FormatInt(end, 10)), content: true},
+			cdnAuditRequest{name: "multi-range", method: http.MethodGet, path: rf.Path, header: rng("bytes=0-0,-1")},
+		)
+	}
+	reqs = append(reqs, cdnAuditRequest{name: "unsatisfiable-range", method: http.MethodGet, path: rf.Path,
+		header: rng("bytes=" + strconv.FormatInt(f.TotalSize, 10) + "-"), expect: http.StatusRequestedRangeNotSatisfiable})
+	if etag != "" {
+		reqs = append(reqs, cdnAuditRequest{name: "revalidation", method: http.MethodHead, path: rf.Path,
+			header: http.Header{"If-None-Match": []string{etag}}, expect: http.StatusNotModified})
+	}
+	reqs = append(reqs,
+		cdnAuditRequest{name: "index", method: http.MethodGet, path: "/", expect: http.StatusOK},
+		cdnAuditRequest{name: "missing", method: http.MethodGet, path: "/.vault-cdn-audit-missing", expect: http.StatusNotFound},
+		cdnAuditRequest{name: "no-token", method: http.MethodHead, path: rf.Path, noToken: true},
+	)
+	return reqs
+}
+
+// POST /admin/resource/{id}/cdn-audit
+// postResourceCDNAudit godoc
+// @Summary      Audit CDN cacheability of webseed
+// @Description  Replays a representative set of webseed requests of a stored file through the full webseed path (HEAD,
+// @Description  full GET of files up to 1MB, single and multiple ranges, unsatisfiable range, revalidation with
+// @Description  If-None-Match, directory index, missing path and a request without token) and reports status, caching
+// @Description  headers and whether a shared cache (CDN) may store every response, with issues found.
+// @Tags         admin
+// @Param        id    path      string  true   "Resource ID"
+// @Param        path  query     string  false  "Path of the file (default: smallest stored file)"
+// @Success      200  {object}  CDNAuditReport
+// @Failure      401  {object}  ErrorResponse
+// @Failure      403  {object}  ErrorResponse
+// @Failure      404  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /admin/resource/{id}/cdn-audit [post]
+func (s *Web) postResourceCDNAudit(c *gin.Context) {
+	db := s.pg.Get()
+	if db == nil {
+		_ = c.Error(errors.New("DB not configured"))
+		return
+	}
+	ctx := c.Request.Context()
+	id := c.Param("id")
+	var rfs []ResourceFile
+	q := db.Model(&rfs).Context(ctx).
+		Relation("File").
+		Where("resource_file.resource_id = ?", id).
+		Where("file.status = ?", StatusStored).
+		Order("file.total_size", "resource_file.path")
+	if p := NormalizePath(c.Query("path")); p != "" {
+		q = q.Where("resource_file.path = ?", p)
+	}
+	err := q.Limit(1).Select()
+	if err != nil && !errors.Is(err, pg.ErrNoRows) {
+		_ = c.Error(err)
+		return
+	}
+	if len(rfs) == 0 {
+		_ = c.Error(errors.New("stored file not found"))
+		return
+	}
+	rf := &rfs[0]
+	st := &selfTester{web: s, ctx: ctx, token: c.GetHeader("X-Token"), remoteAddr: c.Request.RemoteAddr}
+	head := st.audit(id, &cdnAuditRequest{name: "head", method: http.MethodHead, path: rf.Path, expect: http.StatusOK})
+	report := &CDNAuditReport{ResourceID: id, Path: rf.Path, Cacheable: head.Cacheable, Checks: []CDNAuditCheck{head}}
+	for _, r := range cdnAuditRequests(rf, head.Headers["ETag"]) {
+		ck := st.audit(id, &r)
+		if r.content && !ck.Cacheable {
+			report.Cacheable = false
+		}
+		report.Checks = append(report.Checks, ck)
+	}
+	log.WithFields(log.Fields{"id": id, "path": rf.Path, "cacheable": report.Cacheable}).Info("webseed CDN audit finished")
+	c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,547 @@
+package services
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	awss3 "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+const (
+	chunkCacheFlag     = "chunk-cache"
+	chunkCacheDirFlag  = "chunk-cache-dir"
+	chunkCacheSizeFlag = "chunk-cache-size"
+	chunkCacheHeadFlag = "chunk-cache-head"
+	chunkCacheTailFlag = "chunk-cache-tail"
+	chunkCacheTTLFlag  = "chunk-cache-ttl"
+)
+
+// RegisterChunkCacheFlags registers CLI flags for caching of video chunks served by webseed.
+func RegisterChunkCacheFlags(f []cli.Flag) []cli.Flag {
+	return append(f,
+		cli.StringFlag{
+			Name:   chunkCacheFlag,
+			Usage:  "cache of seek-heavy chunks of video files (head and MP4 moov atom): none, memory or disk",
+			Value:  "none",
+			EnvVar: "CHUNK_CACHE",
+		},
+		cli.StringFlag{
+			Name:   chunkCacheDirFlag,
+			Usage:  "directory of disk chunk cache (default: vault-chunks in temp dir)",
+			EnvVar: "CHUNK_CACHE_DIR",
+		},
+		cli.Int64Flag{
+			Name:   chunkCacheSizeFlag,
+			Usage:  "max total size of cached chunks in bytes, least recently used files are evicted",
+			Value:  1024 * 1024 * 1024,
+			EnvVar: "CHUNK_CACHE_SIZE",
+		},
+		cli.Int64Flag{
+			Name:   chunkCacheHeadFlag,
+			Usage:  "number of first bytes of video files cached",
+			Value:  4 * 1024 * 1024,
+			EnvVar: "CHUNK_CACHE_HEAD",
+		},
+		cli.Int64Flag{
+			Name:   chunkCacheTailFlag,
+			Usage:  "max size in bytes of MP4 moov atom cached when it is placed after the media data (0 disables)",
+			Value:  16 * 1024 * 1024,
+			EnvVar: "CHUNK_CACHE_TAIL",
+		},
+		cli.DurationFlag{
+			Name:   chunkCacheTTLFlag,
+			Usage:  "cached chunks of a file not accessed for this period are evicted (0 evicts by size only)",
+			Value:  24 * time.Hour,
+			EnvVar: "CHUNK_CACHE_TTL",
+		},
+	)
+}
+
+// chunkRegion is a cached part [Start, Start+Length) of a file.
+type chunkRegion struct {
+	Start  int64 `json:"start"`
+	Length int64 `json:"length"`
+	// Offset of the region in the data file of disk cache
+	Offset int64 `json:"offset"`
+	data   []byte
+}
+
+func (r *chunkRegion) end() int64 {
+	return r.Start + r.Length - 1
+}
+
+// chunkEntry holds cached regions of a file with headers to respond with.
+type chunkEntry struct {
+	Hash         string        `json:"hash"`
+	Size         int64         `json:"size"`
+	ContentType  string        `json:"content_type,omitempty"`
+	ETag         string        `json:"etag,omitempty"`
+	LastModified time.Time     `json:"last_modified"`
+	Regions      []chunkRegion `json:"regions"`
+	accessed     time.Time
+	el           *list.Element
+}
+
+func (e *chunkEntry) bytes() int64 {
+	var n int64
+	for _, r := range e.Regions {
+		n += r.Length
+	}
+	return n
+}
+
+// ChunkCache keeps the head of video files and the moov atom of MP4 files placed at the end,
+// which players request on every start and seek, so these tiny ranges don't hit S3.
+// Files are cached in background after the first access. Nil ChunkCache caches nothing.
+type ChunkCache struct {
+	mux sync.Mutex
+	// dir of disk cache, empty keeps chunks in memory
+	dir     string
+	maxSize int64
+	head    int64
+	tail    int64
+	ttl     time.Duration
+	size    int64
+	ll      *list.List
+	entries map[string]*chunkEntry
+	filling map[string]bool
+}
+
+// NewChunkCache returns nil if chunk cache is none.
+func NewChunkCache(c *cli.Context) (*ChunkCache, error) {
+	s := &ChunkCache{
+		maxSize: c.Int64(chunkCacheSizeFlag),
+		head:    c.Int64(chunkCacheHeadFlag),
+		tail:    c.Int64(chunkCacheTailFlag),
+		ttl:     c.Duration(chunkCacheTTLFlag),
+		ll:      list.New(),
+		entries: map[string]*chunkEntry{},
+		filling: map[string]bool{},
+	}
+	switch b := c.String(chunkCacheFlag); b {
+	case "", "none":
+		return nil, nil
+	case "memory":
+	case "disk":
+		s.dir = c.String(chunkCacheDirFlag)
+		if s.dir == "" {
+			s.dir = filepath.Join(os.TempDir(), "vault-chunks")
+		}
+		if err := os.MkdirAll(s.dir, 0o700); err != nil {
+			return nil, errors.Wrapf(err, "failed to create chunk cache dir %v", s.dir)
+		}
+		if err := s.load(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.Errorf("unsupported chunk cache %v", b)
+	}
+	if s.maxSize <= 0 || s.head <= 0 {
+		return nil, errors.New("chunk cache size and head must be positive")
+	}
+	log.WithFields(log.Fields{"backend": c.String(chunkCacheFlag), "size": s.maxSize, "head": s.head, "tail": s.tail}).Info("caching video chunks")
+	return s, nil
+}
+
+// load restores index of disk cache, most recently modified files are kept if it doesn't fit.
+func (s *ChunkCache) load() error {
+	metas, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	type loaded struct {
+		e   *chunkEntry
+		mod time.Time
+	}
+	var found []loaded
+	for _, m := range metas {
+		data, err := os.ReadFile(m)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read chunk cache index %v", m)
+		}
+		e := &chunkEntry{}
+		fi, serr := os.Stat(s.dataPath(strings.TrimSuffix(filepath.Base(m), ".json")))
+		if json.Unmarshal(data, e) != nil || serr != nil || e.Hash+".json" != filepath.Base(m) {
+			s.removeFiles(strings.TrimSuffix(filepath.Base(m), ".json"))
+			continue
+		}
+		found = append(found, loaded{e: e, mod: fi.ModTime()})
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].mod.Before(found[j].mod) })
+	for _, l := range found {
+		l.e.accessed = l.mod
+		s.add(l.e)
+	}
+	promChunkCacheBytes.Set(float64(s.size))
+	return nil
+}
+
+func (s *ChunkCache) dataPath(hash string) string {
+	return filepath.Join(s.dir, hash)
+}
+
+func (s *ChunkCache) removeFiles(hash string) {
+	_ = os.Remove(s.dataPath(hash))
+	_ = os.Remove(s.dataPath(hash) + ".json")
+}
+
+// add indexes entry and evicts least recently used ones over max size or not accessed for ttl.
+// Caller holds the lock.
+func (s *ChunkCache) add(e *chunkEntry) {
+	e.el = s.ll.PushFront(e)
+	s.entries[e.Hash] = e
+	s.size += e.bytes()
+	for s.ll.Len() > 1 {
+		last := s.ll.Back().Value.(*chunkEntry)
+		if s.size <= s.maxSize && (s.ttl <= 0 || time.Since(last.accessed) <= s.ttl) {
+			break
+		}
+		s.remove(last)
+	}
+}
+
+// remove drops entry. Caller holds the lock.
+func (s *ChunkCache) remove(e *chunkEntry) {
+	s.ll.Remove(e.el)
+	delete(s.entries, e.Hash)
+	s.size -= e.bytes()
+	if s.dir != "" {
+		s.removeFiles(e.Hash)
+	}
+	promChunkCacheBytes.Set(float64(s.size))
+}
+
+// evict drops cached chunks of the file.
+func (s *ChunkCache) evict(hash string) {
+	if s == nil {
+		return
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if e, ok := s.entries[hash]; ok {
+		s.remove(e)
+	}
+}
+
+// cacheable tells if chunks of the file are worth caching.
+func (s *ChunkCache) cacheable(f *webseedFile) bool {
+	return s != nil && f.size > 0 && f.class == ContentClassVideo
+}
+
+// lookup returns cached entry of the file and its region containing offset.
+func (s *ChunkCache) lookup(hash string, offset int64) (*chunkEntry, *chunkRegion) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	e, ok := s.entries[hash]
+	if !ok {
+		return nil, nil
+	}
+	now := time.Now()
+	if s.ttl > 0 && now.Sub(e.accessed) > s.ttl {
+		s.remove(e)
+		return nil, nil
+	}
+	e.accessed = now
+	s.ll.MoveToFront(e.el)
+	for i := range e.Regions {
+		if r := &e.Regions[i]; offset >= r.Start && offset <= r.end() {
+			return e, r
+		}
+	}
+	return e, nil
+}
+
+// reader returns content of region r of e from start to end inclusive.
+func (s *ChunkCache) reader(e *chunkEntry, r *chunkRegion, start, end int64) (io.ReadCloser, error) {
+	if s.dir == "" {
+		return io.NopCloser(bytes.NewReader(r.data[start-r.Start : end-r.Start+1])), nil
+	}
+	// evicted file stays readable through the open descriptor
+	f, err := os.Open(s.dataPath(e.Hash))
+	if err != nil {
+		return nil, err
+	}
+	return &readCloser{Reader: io.NewSectionReader(f, r.Offset+start-r.Start, end-start+1), Closer: f}, nil
+}
+
+// startFill returns true if the file is neither cached nor being cached, marking it being cached.
+func (s *ChunkCache) startFill(hash string) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if _, ok := s.entries[hash]; ok || s.filling[hash] {
+		return false
+	}
+	s.filling[hash] = true
+	return true
+}
+
+func (s *ChunkCache) endFill(hash string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	delete(s.filling, hash)
+}
+
+// put caches regions of e with their content.
+func (s *ChunkCache) put(e *chunkEntry, data [][]byte) error {
+	var off int64
+	for i := range e.Regions {
+		e.Regions[i].Offset = off
+		off += e.Regions[i].Length
+		if s.dir == "" {
+			e.Regions[i].data = data[i]
+		}
+	}
+	if s.dir != "" {
+		if err := s.write(e, data); err != nil {
+			s.removeFiles(e.Hash)
+			return err
+		}
+	}
+	e.accessed = time.Now()
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if old, ok := s.entries[e.Hash]; ok {
+		s.ll.Remove(old.el)
+		delete(s.entries, old.Hash)
+		s.size -= old.bytes()
+	}
+	s.add(e)
+	promChunkCacheBytes.Set(float64(s.size))
+	return nil
+}
+
+// write stores regions to the data file and the index next to it, the index is written last,
+// so interrupted writes are dropped on load.
+func (s *ChunkCache) write(e *chunkEntry, data [][]byte) error {
+	f, err := os.CreateTemp(s.dir, e.Hash+".tmp*")
+	if err != nil {
+		return err
+	}
+	for _, d := range data {
+		if _, err = f.Write(d); err != nil {
+			_ = f.Close()
+			_ = os.Remove(f.Name())
+			return err
+		}
+	}
+	if err = f.Close(); err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+	if err = os.Rename(f.Name(), s.dataPath(e.Hash)); err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+	meta, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.dataPath(e.Hash)+".json", meta, 0o600)
+}
+
+// mp4Exts are extensions of ISO BMFF files whose moov atom may follow media data.
+var mp4Exts = map[string]bool{".mp4": true, ".m4v": true, ".mov": true, ".m4a": true, ".3gp": true}
+
+// maxMP4Boxes bounds walking of top-level boxes.
+const maxMP4Boxes = 64
+
+// findMoov walks top-level MP4 boxes and returns offset and size of the moov box. Box headers
+// outside of head are read with readAt.
+func findMoov(head []byte, size int64, readAt func(off, n int64) ([]byte, error)) (int64, int64, error) {
+	var off int64
+	for i := 0; i < maxMP4Boxes && off+8 <= size; i++ {
+		var hdr []byte
+		if off+16 <= int64(len(head)) {
+			hdr = head[off : off+16]
+		} else {
+			var err error
+			if hdr, err = readAt(off, min(16, size-off)); err != nil {
+				return 0, 0, err
+			}
+		}
+		boxSize := int64(binary.BigEndian.Uint32(hdr[0:4]))
+		typ := string(hdr[4:8])
+		hl := int64(8)
+		switch boxSize {
+		case 0:
+			boxSize = size - off
+		case 1:
+			if len(hdr) < 16 {
+				return 0, 0, errors.New("truncated mp4 box header")
+			}
+			boxSize = int64(binary.BigEndian.Uint64(hdr[8:16]))
+			hl = 16
+		}
+		if boxSize < hl || off+boxSize > size {
+			return 0, 0, errors.Errorf("invalid mp4 box %q at %d", typ, off)
+		}
+		if typ == "moov" {
+			return off, boxSize, nil
+		}
+		off += boxSize
+	}
+	return 0, 0, errors.New("no moov box")
+}
+
+// readObjectRange reads length bytes of the object from offset.
+func (s *Web) readObjectRange(ctx context.Context, bucket, hash string, offset, length int64) ([]byte, *awss3.GetObjectOutput, error) {
+	out, err := s.s3.Get().GetObjectWithContext(ctx, &awss3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(s.keys.Key(hash)),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() { _ = out.Body.Close() }()
+	data := make([]byte, length)
+	if _, err = io.ReadFull(out.Body, data); err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to read %v", hash)
+	}
+	return data, out, nil
+}
+
+// fillChunks caches chunks of the file in background.
+func (s *Web) fillChunks(f *webseedFile, p string) {
+	if !s.chunks.startFill(f.hash) {
+		return
+	}
+	go func() {
+		defer s.chunks.endFill(f.hash)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		l := log.WithFields(log.Fields{"key": f.hash, "path": p})
+		e, data, err := s.loadChunks(ctx, f, p)
+		if err == nil {
+			err = s.chunks.put(e, data)
+		}
+		if err != nil {
+			l.WithError(err).Warn("failed to cache chunks")
+			return
+		}
+		l.WithField("regions", len(e.Regions)).Debug("chunks cached")
+	}()
+}
+
+func (s *Web) loadChunks(ctx context.Context, f *webseedFile, p string) (*chunkEntry, [][]byte, error) {
+	headLen := min(s.chunks.head, f.size)
+	head, out, err := s.readObjectRange(ctx, s.bucketOf(f), f.hash, 0, headLen)
+	if err != nil {
+		return nil, nil, err
+	}
+	e := &chunkEntry{
+		Hash:         f.hash,
+		Size:         f.size,
+		ContentType:  aws.StringValue(out.ContentType),
+		ETag:         aws.StringValue(out.ETag),
+		LastModified: aws.TimeValue(out.LastModified),
+		Regions:      []chunkRegion{{Start: 0, Length: headLen}},
+	}
+	data := [][]byte{head}
+	if s.chunks.tail <= 0 || !mp4Exts[strings.ToLower(path.Ext(p))] {
+		return e, data, nil
+	}
+	readAt := func(off, n int64) ([]byte, error) {
+		b, _, err := s.readObjectRange(ctx, s.bucketOf(f), f.hash, off, n)
+		return b, err
+	}
+	off, n, err := findMoov(head, f.size, readAt)
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{"key": f.hash, "path": p}).Debug("moov atom not found")
+		return e, data, nil
+	}
+	if off+n <= headLen || n > s.chunks.tail {
+		return e, data, nil
+	}
+	start := max(off, headLen)
+	moov, err := readAt(start, off+n-start)
+	if err != nil {
+		return nil, nil, err
+	}
+	e.Regions = append(e.Regions, chunkRegion{Start: start, Length: off + n - start})
+	data = append(data, moov)
+	return e, data, nil
+}
+
+// serveChunks serves GET of a video file starting in a cached region, the rest of the range
+// is streamed from S3. Returns false if the request has to be served from S3, caching chunks
+// of the file for next requests. Client-side encrypted objects are not cached, so plaintext
+// never lands on local disk.
+func (s *Web) serveChunks(ctx context.Context, cancel context.CancelFunc, touch func(bool), c *gin.Context, f *webseedFile, rangeHeader, id, p string) bool {
+	if !s.chunks.cacheable(f) || s.enc.ClientSide() {
+		return false
+	}
+	start, end, partial, ok := resolveRange(rangeHeader, f.size)
+	if !ok {
+		return false
+	}
+	e, r := s.chunks.lookup(f.hash, start)
+	if e == nil {
+		promChunkCacheRequests.WithLabelValues("miss").Inc()
+		s.fillChunks(f, p)
+		return false
+	}
+	if e.Size != f.size {
+		s.chunks.evict(f.hash)
+		return false
+	}
+	if r == nil {
+		promChunkCacheRequests.WithLabelValues("miss").Inc()
+		return false
+	}
+	cachedEnd := min(end, r.end())
+	rc, err := s.chunks.reader(e, r, start, cachedEnd)
+	if err != nil {
+		log.WithError(err).WithField("key", f.hash).Warn("failed to read cached chunks")
+		s.chunks.evict(f.hash)
+		return false
+	}
+	promChunkCacheRequests.WithLabelValues("hit").Inc()
+	defer func() { _ = rc.Close() }()
+	var rd io.Reader = rc
+	if cachedEnd < end {
+		rr := &rangeReader{web: s, ctx: ctx, touch: touch, bucket: s.bucketOf(f), hash: f.hash, r: byteRange{start: cachedEnd + 1, end: end}}
+		defer rr.close()
+		rd = io.MultiReader(rc, rr)
+	}
+	c.Header("Accept-Ranges", "bytes")
+	ct := e.ContentType
+	if ct == "" {
+		ct = "application/octet-stream"
+	}
+	c.Header("Content-Type", ct)
+	if e.ETag != "" {
+		c.Header("ETag", e.ETag)
+	}
+	if !e.LastModified.IsZero() {
+		c.Header("Last-Modified", e.LastModified.UTC().Format(http.TimeFormat))
+	}
+	c.Header("Content-Length", strconv.FormatInt(end-start+1, 10))
+	if partial {
+		c.Header("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, f.size))
+		c.Status(http.StatusPartialContent)
+	} else {
+		c.Status(http.StatusOK)
+	}
+	s.streamToClient(ctx, cancel, c, rd, id, p)
+	return true
+}
@@ -0,0 +1,190 @@
+package services
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+const (
+	circuitBreakerThresholdFlag      = "circuit-breaker-threshold"
+	circuitBreakerCooldownFlag       = "circuit-breaker-cooldown"
+	readinessCheckCircuitBreakerFlag = "readiness-check-circuit-breaker"
+)
+
+// RegisterCircuitBreakerFlags registers CLI flags for pausing of store jobs after consecutive S3 or torrent http proxy failures.
+func RegisterCircuitBreakerFlags(f []cli.Flag) []cli.Flag {
+	return append(f,
+		cli.IntFlag{
+			Name:   circuitBreakerThresholdFlag,
+			Usage:  "number of consecutive S3 upload or torrent http proxy download failures opening the circuit, which pauses new store jobs (0 disables)",
+			Value:  0,
+			EnvVar: "CIRCUIT_BREAKER_THRESHOLD",
+		},
+		cli.DurationFlag{
+			Name:   circuitBreakerCooldownFlag,
+			Usage:  "how long new store jobs are paused once the circuit opens",
+			Value:  time.Minute,
+			EnvVar: "CIRCUIT_BREAKER_COOLDOWN",
+		},
+		cli.BoolFlag{
+			Name:   readinessCheckCircuitBreakerFlag,
+			Usage:  "fail readiness check while the circuit is open",
+			EnvVar: "READINESS_CHECK_CIRCUIT_BREAKER",
+		},
+	)
+}
+
+// Circuits of dependencies store jobs fail on.
+const (
+	CircuitS3           = "s3"
+	CircuitTorrentProxy = "torrent_proxy"
+)
+
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half_open"
+)
+
+// CircuitState describes a single circuit.
+type CircuitState struct {
+	// State is closed, open or half_open (cool-down passed, the next failure opens the circuit again)
+	State string `json:"state"`
+	// Failures is the number of consecutive failures
+	Failures  int        `json:"failures"`
+	OpenUntil *time.Time `json:"open_until,omitempty"`
+}
+
+type circuit struct {
+	failures  int
+	openUntil time.Time
+}
+
+// CircuitBreaker counts consecutive failures of S3 uploads and torrent http proxy downloads. Once a circuit
+// reaches the threshold, new store jobs are paused for the cool-down. Jobs resume when it passes, a single
+// failure then opens the circuit again and a success closes it.
+type CircuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	mux       sync.Mutex
+	circuits  map[string]*circuit
+}
+
+// NewCircuitBreaker returns nil if the circuit breaker is disabled.
+func NewCircuitBreaker(c *cli.Context) (*CircuitBreaker, error) {
+	threshold := c.Int(circuitBreakerThresholdFlag)
+	if threshold < 0 {
+		return nil, errors.New("circuit breaker threshold must not be negative")
+	}
+	if threshold == 0 {
+		return nil, nil
+	}
+	if c.Duration(circuitBreakerCooldownFlag) <= 0 {
+		return nil, errors.New("circuit breaker cooldown must be positive")
+	}
+	return &CircuitBreaker{
+		threshold: threshold,
+		cooldown:  c.Duration(circuitBreakerCooldownFlag),
+		circuits: map[string]*circuit{
+			CircuitS3:           {},
+			CircuitTorrentProxy: {},
+		},
+	}, nil
+}
+
+// record counts a call to the dependency of circuit name. Calls interrupted by cancellation of ctx are
+// not counted, failed reports whether the dependency failed.
+func (s *CircuitBreaker) record(ctx context.Context, name string, failed bool) {
+	if s == nil || ctx.Err() != nil {
+		return
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	cr := s.circuits[name]
+	if !failed {
+		if cr.failures >= s.threshold {
+			log.WithField("circuit", name).Info("circuit closed")
+		}
+		cr.failures = 0
+		cr.openUntil = time.Time{}
+		return
+	}
+	cr.failures++
+	now := time.Now()
+	if cr.failures < s.threshold || now.Before(cr.openUntil) {
+		return
+	}
+	cr.openUntil = now.Add(s.cooldown)
+	promCircuitBreakerOpens.WithLabelValues(name).Inc()
+	log.WithFields(log.Fields{"circuit": name, "failures": cr.failures, "cooldown": s.cooldown}).Warn("circuit opened, new store jobs are paused")
+}
+
+// Open reports whether any circuit is open at now.
+func (s *CircuitBreaker) Open(now time.Time) bool {
+	if s == nil {
+		return false
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	for _, cr := range s.circuits {
+		if now.Before(cr.openUntil) {
+			return true
+		}
+	}
+	return false
+}
+
+// OpenCircuits returns names of circuits open at now.
+func (s *CircuitBreaker) OpenCircuits(now time.Time) []string {
+	var names []string
+	for name, st := range s.State(now) {
+		if st.State == CircuitOpen {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// State returns states of all circuits at now, nil if the circuit breaker is disabled.
+func (s *CircuitBreaker) State(now time.Time) map[string]*CircuitState {
+	if s == nil {
+		return nil
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	res := make(map[string]*CircuitState, len(s.circuits))
+	for name, cr := range s.circuits {
+		st := &CircuitState{State: CircuitClosed, Failures: cr.failures}
+		if now.Before(cr.openUntil) {
+			st.State = CircuitOpen
+			until := cr.openUntil
+			st.OpenUntil = &until
+		} else if cr.failures >= s.threshold {
+			st.State = CircuitHalfOpen
+		}
+		res[name] = st
+	}
+	return res
+}
+
+// recordDownload counts an attempt to download a file from torrent http proxy. Only transient errors
+// (network errors and 5xx) are failures, other errors of the attempt leave the circuit as is.
+func (s *CircuitBreaker) recordDownload(ctx context.Context, err error) {
+	if err == nil || isRetryableDownloadError(err) {
+		s.record(ctx, CircuitTorrentProxy, err != nil)
+	}
+}
+
+// recordUpload counts an S3 upload. Uploads failed reading the download count against torrent http proxy.
+func (s *CircuitBreaker) recordUpload(ctx context.Context, err error) {
+	if err == nil || asProxyError(err) == nil {
+		s.record(ctx, CircuitS3, err != nil)
+	}
+}
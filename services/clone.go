@@ -0,0 +1,82 @@
+package services
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+)
+
+// CloneRequest is a body of clone request.
+type CloneRequest struct {
+	ExpiryRequest
+	// TargetID is the id the resource is cloned to, it must not exist yet
+	TargetID string `json:"target_id"`
+}
+
+// POST /resource/{id}/clone
+// postResourceClone godoc
+// @Summary      Clone stored resource
+// @Description  Creates stored resource target_id linking the same files as the resource, so nothing is uploaded again.
+// @Description  Useful when the same content appears under a different infohash. The clone is recorded in the operation log
+// @Description  of the target (cloned_from), which keeps its files while they are referenced by either resource.
+// @Description  Resources which are blocklisted or taken down can't be cloned, nor can they be cloned to.
+// @Tags         resource
+// @Accept       json
+// @Param        id       path      string        true  "Resource ID"
+// @Param        request  body      CloneRequest  true  "Target resource"
+// @Success      201  {object}  Resource
+// @Failure      400  {object}  ErrorResponse
+// @Failure      404  {object}  ErrorResponse
+// @Failure      409  {object}  ErrorResponse
+// @Failure      410  {object}  ErrorResponse
+// @Failure      451  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /resource/{id}/clone [post]
+func (s *Web) postResourceClone(c *gin.Context) {
+	db := s.pg.Get()
+	if db == nil {
+		_ = c.Error(errors.New("DB not configured"))
+		return
+	}
+	var req CloneRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		_ = c.Error(errors.Wrap(err, "failed to parse clone request"))
+		return
+	}
+	id := c.Param("id")
+	if req.TargetID == "" || req.TargetID == id {
+		_ = c.Error(errors.New("failed to parse clone request: target_id must differ from resource id"))
+		return
+	}
+	expiresAt, setExpiry, err := req.expiry(time.Now())
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	tenant := s.auth.Tenant(c)
+	var res *Resource
+	err = db.RunInTransaction(c.Request.Context(), func(tx *pg.Tx) error {
+		var err error
+		res, err = s.createOwned(c.Request.Context(), tx, tenant, req.TargetID, func() (*Resource, error) {
+			return ResourceClone(c.Request.Context(), tx, id, req.TargetID)
+		})
+		if err != nil || res == nil || !setExpiry {
+			return err
+		}
+		res, err = ResourceSetExpiry(c.Request.Context(), tx, req.TargetID, expiresAt)
+		return err
+	})
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if res == nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	s.abuse.Record(AbuseKindRegister, c.ClientIP(), 1)
+	c.JSON(http.StatusCreated, gin.H{"resource": res.SetTTL(time.Now())})
+}
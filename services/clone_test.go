@@ -0,0 +1,135 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// testStoredResource adds a stored resource linking files at paths, both mapped to file hashes.
+func testStoredResource(t *testing.T, p *PG, id string, files map[string]string) {
+	t.Helper()
+	ctx := context.Background()
+	if _, err := ResourceQueueForStoring(ctx, p.Get(), id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Get().Model(&Resource{ID: id}).Set("status = ?", StatusStored).WherePK().Update(); err != nil {
+		t.Fatal(err)
+	}
+	for path, hash := range files {
+		f := &File{Hash: hash, Status: StatusStored, HashAlgo: HashAlgoSampled}
+		if _, err := p.Get().Model(f).OnConflict("DO NOTHING").Insert(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := p.Get().Model(&ResourceFile{ResourceID: id, FileHash: hash, Path: path}).Insert(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// testResourceFiles returns paths of files linked by the resource mapped to file hashes.
+func testResourceFiles(t *testing.T, p *PG, id string) map[string]string {
+	t.Helper()
+	var rfs []ResourceFile
+	if err := p.Get().Model(&rfs).Where("resource_id = ?", id).Select(); err != nil {
+		t.Fatal(err)
+	}
+	res := map[string]string{}
+	for _, rf := range rfs {
+		res[rf.Path] = rf.FileHash
+	}
+	return res
+}
+
+func TestResourceClone(t *testing.T) {
+	p := testPG(t)
+	ctx := context.Background()
+	src, target := testResourceID(1), testResourceID(2)
+	files := map[string]string{"a/1.mkv": "h1", "a/2.srt": "h2", "b.txt": "h3"}
+	testStoredResource(t, p, src, files)
+
+	res, err := ResourceClone(ctx, p.Get(), src, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res == nil || res.ID != target || res.Status != StatusStored {
+		t.Fatalf("clone = %+v, want stored %v", res, target)
+	}
+	got := testResourceFiles(t, p, target)
+	if len(got) != len(files) {
+		t.Fatalf("clone links %v, want %v", got, files)
+	}
+	for path, hash := range files {
+		if got[path] != hash {
+			t.Errorf("clone links %v to %q, want %q", path, got[path], hash)
+		}
+	}
+	var logs []OperationLog
+	if err := p.Get().Model(&logs).Where("resource_id = ?", target).Select(); err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 1 || logs[0].ClonedFrom == nil || *logs[0].ClonedFrom != src {
+		t.Errorf("operation log of clone = %+v, want one cloned from %v", logs, src)
+	}
+	// files are shared, not copied
+	if n, err := p.Get().Model((*File)(nil)).Count(); err != nil || n != len(files) {
+		t.Errorf("%v files after clone, %v, want %v", n, err, len(files))
+	}
+
+	if _, err := ResourceClone(ctx, p.Get(), src, target); !errors.Is(err, ErrResourceExists) {
+		t.Errorf("clone to existing resource error = %v, want %v", err, ErrResourceExists)
+	}
+	if res, err := ResourceClone(ctx, p.Get(), testResourceID(3), testResourceID(4)); err != nil || res != nil {
+		t.Errorf("clone of missing resource = %v, %v, want nil", res, err)
+	}
+	queued := testResourceID(5)
+	if _, err := ResourceQueueForStoring(ctx, p.Get(), queued); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ResourceClone(ctx, p.Get(), queued, testResourceID(6)); !errors.Is(err, ErrNotClonable) {
+		t.Errorf("clone of queued resource error = %v, want %v", err, ErrNotClonable)
+	}
+}
+
+func TestResourceCloneBlocked(t *testing.T) {
+	p := testPG(t)
+	ctx := context.Background()
+	src := testResourceID(1)
+	testStoredResource(t, p, src, map[string]string{"a.mkv": "h1"})
+	// sources blocked after they were stored, taken down ones keep their files with the block policy
+	takenDownSrc, blockedSrc := testResourceID(2), testResourceID(3)
+	testStoredResource(t, p, takenDownSrc, map[string]string{"a.mkv": "h1"})
+	testStoredResource(t, p, blockedSrc, map[string]string{"a.mkv": "h1"})
+
+	takenDown, blocked := testResourceID(4), testResourceID(5)
+	for _, id := range []string{takenDown, takenDownSrc} {
+		if err := TakedownCreate(ctx, p.Get(), &Takedown{ResourceID: id, ReasonCode: "dmca", Policy: TakedownPolicyBlock}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, id := range []string{blocked, blockedSrc} {
+		if err := BlocklistAdd(ctx, p.Get(), &BlocklistEntry{Infohash: id, Source: BlocklistSourceManual}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name        string
+		src, target string
+		err         error
+	}{
+		{name: "taken down target", src: src, target: takenDown, err: ErrTakenDown},
+		{name: "blocklisted target", src: src, target: blocked, err: ErrBlocked},
+		{name: "taken down source", src: takenDownSrc, target: testResourceID(6), err: ErrTakenDown},
+		{name: "blocklisted source", src: blockedSrc, target: testResourceID(7), err: ErrBlocked},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ResourceClone(ctx, p.Get(), tt.src, tt.target); !errors.Is(err, tt.err) {
+				t.Fatalf("clone error = %v, want %v", err, tt.err)
+			}
+			if files := testResourceFiles(t, p, tt.target); len(files) != 0 {
+				t.Errorf("files %v are linked to %v", files, tt.target)
+			}
+		})
+	}
+}
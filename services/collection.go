@@ -0,0 +1,442 @@
+package services
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	awss3 "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gin-gonic/gin"
+	"github.com/go-pg/pg/v10"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// CollectionRequest is a body of collection create request.
+type CollectionRequest struct {
+	Name        string  `json:"name"`
+	Description *string `json:"description,omitempty"`
+}
+
+// CollectionsResponse is a page of collections.
+type CollectionsResponse struct {
+	Collections []Collection `json:"collections"`
+	Total       int          `json:"total"`
+	Limit       int          `json:"limit"`
+	Offset      int          `json:"offset"`
+}
+
+// CollectionResponse is a collection with a page of its resources.
+type CollectionResponse struct {
+	Collection *Collection `json:"collection"`
+	Resources  []Resource  `json:"resources"`
+	Total      int         `json:"total"`
+	Limit      int         `json:"limit"`
+	Offset     int         `json:"offset"`
+}
+
+func parseCollectionID(c *gin.Context) (uuid.UUID, error) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return uuid.Nil, errors.Wrap(err, "failed to parse collection id")
+	}
+	return id, nil
+}
+
+// ownedCollection returns the collection, nil if it does not exist or the caller has a tenant token of
+// another tenant. Collections without owner are not visible to tenant tokens, like resources.
+func (s *Web) ownedCollection(c *gin.Context, db pg.DBI, id uuid.UUID) (*Collection, error) {
+	col, err := CollectionGet(c.Request.Context(), db, id)
+	if err != nil || col == nil {
+		return nil, err
+	}
+	if tenant := s.auth.Tenant(c); tenant != "" && (col.Owner == nil || *col.Owner != tenant) {
+		return nil, nil
+	}
+	return col, nil
+}
+
+// POST /collections
+// postCollection godoc
+// @Summary      Create collection
+// @Description  Creates an empty named group of resources.
+// @Tags         collection
+// @Accept       json
+// @Param        request  body      CollectionRequest  true  "Collection"
+// @Success      201  {object}  Collection
+// @Failure      400  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /collections [post]
+func (s *Web) postCollection(c *gin.Context) {
+	db := s.pg.Get()
+	if db == nil {
+		_ = c.Error(errors.New("DB not configured"))
+		return
+	}
+	var req CollectionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		_ = c.Error(errors.Wrap(err, "failed to parse collection request"))
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		_ = c.Error(errors.New("failed to parse collection request: name is required"))
+		return
+	}
+	col := &Collection{Name: req.Name, Description: req.Description}
+	if tenant := s.auth.Tenant(c); tenant != "" {
+		col.Owner = &tenant
+	}
+	if err := CollectionCreate(c.Request.Context(), db, col); err != nil {
+		_ = c.Error(err)
+		return
+	}
+	col.Stats = &CollectionStats{CollectionID: col.CollectionID}
+	c.JSON(http.StatusCreated, gin.H{"collection": col})
+}
+
+// GET /collections
+// getCollections godoc
+// @Summary      List collections
+// @Description  Returns collections with resource count and aggregate size, newest first.
+// @Tags         collection
+// @Param        limit   query     int     false  "Page size (default 50, max 1000)"
+// @Param        offset  query     int     false  "Page offset"
+// @Success      200  {object}  CollectionsResponse
+// @Failure      400  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /collections [get]
+func (s *Web) getCollections(c *gin.Context) {
+	db := s.pg.Read()
+	if db == nil {
+		_ = c.Error(errors.New("DB not configured"))
+		return
+	}
+	limit, offset, err := parseListLimits(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	list, total, err := CollectionList(c.Request.Context(), db, s.auth.Tenant(c), limit, offset)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, &CollectionsResponse{Collections: list, Total: total, Limit: limit, Offset: offset})
+}
+
+// GET /collection/{id}
+// getCollection godoc
+// @Summary      Get collection
+// @Description  Returns collection with aggregate size and a page of its resources, most recently added first.
+// @Tags         collection
+// @Param        id      path      string  true   "Collection ID"
+// @Param        limit   query     int     false  "Page size (default 50, max 1000)"
+// @Param        offset  query     int     false  "Page offset"
+// @Success      200  {object}  CollectionResponse
+// @Failure      400  {object}  ErrorResponse
+// @Failure      404  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /collection/{id} [get]
+func (s *Web) getCollection(c *gin.Context) {
+	db := s.pg.Get()
+	if db == nil {
+		_ = c.Error(errors.New("DB not configured"))
+		return
+	}
+	id, err := parseCollectionID(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	limit, offset, err := parseListLimits(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	col, err := s.ownedCollection(c, db, id)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if col == nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	list, total, err := CollectionResourceList(c.Request.Context(), db, id, s.auth.Tenant(c), limit, offset)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	now := time.Now()
+	for i := range list {
+		list[i].SetTTL(now)
+	}
+	c.JSON(http.StatusOK, &CollectionResponse{Collection: col, Resources: list, Total: total, Limit: limit, Offset: offset})
+}
+
+// DELETE /collection/{id}
+// deleteCollection godoc
+// @Summary      Delete collection
+// @Description  Deletes collection, its resources are kept.
+// @Tags         collection
+// @Param        id   path      string  true  "Collection ID"
+// @Success      200  {object}  Collection
+// @Failure      400  {object}  ErrorResponse
+// @Failure      404  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /collection/{id} [delete]
+func (s *Web) deleteCollection(c *gin.Context) {
+	db := s.pg.Get()
+	if db == nil {
+		_ = c.Error(errors.New("DB not configured"))
+		return
+	}
+	id, err := parseCollectionID(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	col, err := s.ownedCollection(c, db, id)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if col != nil {
+		col, err = CollectionDelete(c.Request.Context(), db, id)
+	}
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if col == nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"collection": col})
+}
+
+// PUT /collection/{id}/resource/{resource_id}
+// putCollectionResource godoc
+// @Summary      Add resource to collection
+// @Tags         collection
+// @Param        id           path      string  true  "Collection ID"
+// @Param        resource_id  path      string  true  "Resource ID"
+// @Success      200  {object}  Collection
+// @Failure      400  {object}  ErrorResponse
+// @Failure      404  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /collection/{id}/resource/{resource_id} [put]
+func (s *Web) putCollectionResource(c *gin.Context) {
+	s.updateCollectionResource(c, true, CollectionAddResource)
+}
+
+// DELETE /collection/{id}/resource/{resource_id}
+// deleteCollectionResource godoc
+// @Summary      Remove resource from collection
+// @Description  Removes resource from collection, the resource itself is kept.
+// @Tags         collection
+// @Param        id           path      string  true  "Collection ID"
+// @Param        resource_id  path      string  true  "Resource ID"
+// @Success      200  {object}  Collection
+// @Failure      400  {object}  ErrorResponse
+// @Failure      404  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /collection/{id}/resource/{resource_id} [delete]
+func (s *Web) deleteCollectionResource(c *gin.Context) {
+	s.updateCollectionResource(c, false, CollectionRemoveResource)
+}
+
+// updateCollectionResource applies update to the collection and the resource. Only resources of the tenant
+// may be added with a tenant token, others can still be removed.
+func (s *Web) updateCollectionResource(c *gin.Context, add bool, update func(ctx context.Context, db pg.DBI, id uuid.UUID, resourceID string) (bool, error)) {
+	db := s.pg.Get()
+	if db == nil {
+		_ = c.Error(errors.New("DB not configured"))
+		return
+	}
+	id, err := parseCollectionID(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	var col *Collection
+	err = db.RunInTransaction(c.Request.Context(), func(tx *pg.Tx) error {
+		owned, err := s.ownedCollection(c, tx, id)
+		if err != nil || owned == nil {
+			return err
+		}
+		if tenant := s.auth.Tenant(c); add && tenant != "" {
+			res, err := ResourceGetByID(c.Request.Context(), tx, c.Param("resource_id"))
+			if err != nil || res == nil || !ownedBy(res, tenant) {
+				return err
+			}
+		}
+		ok, err := update(c.Request.Context(), tx, id, c.Param("resource_id"))
+		if err != nil || !ok {
+			return err
+		}
+		col, err = CollectionGet(c.Request.Context(), tx, id)
+		return err
+	})
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if col == nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"collection": col})
+}
+
+// GET /collection/{id}/export
+// getCollectionExport godoc
+// @Summary      Export collection as zip
+// @Description  Streams stored files of all stored resources of the collection as a single uncompressed zip archive,
+// @Description  files are placed under <resource_id>/<path>. Resources which are not stored or are taken down are skipped.
+// @Tags         collection
+// @Produce      application/zip
+// @Param        id   path      string  true  "Collection ID"
+// @Success      200
+// @Failure      400  {object}  ErrorResponse
+// @Failure      404  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /collection/{id}/export [get]
+func (s *Web) getCollectionExport(c *gin.Context) {
+	db := s.pg.Read()
+	if db == nil {
+		_ = c.Error(errors.New("DB not configured"))
+		return
+	}
+	ctx := c.Request.Context()
+	id, err := parseCollectionID(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	col, err := s.ownedCollection(c, db, id)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if col == nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	// ownership is checked again, resources of other tenants are skipped
+	resources, _, err := CollectionResourceList(ctx, db, id, s.auth.Tenant(c), 0, 0)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	var files []ResourceFile
+	for _, r := range resources {
+		if r.Status != StatusStored {
+			continue
+		}
+		// taken down content must not leak through exports
+		st, err := s.lookupResourceState(c, db, r.ID)
+		if err != nil {
+			_ = c.Error(err)
+			return
+		}
+		if st.takedown || !st.stored {
+			continue
+		}
+		list, err := ResourceFileListByPrefix(ctx, db, r.ID, "/")
+		if err != nil {
+			_ = c.Error(err)
+			return
+		}
+		files = append(files, list...)
+	}
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": col.Name + ".zip"}))
+	c.Status(http.StatusOK)
+	start := time.Now()
+	var n int64
+	zw := zip.NewWriter(c.Writer)
+	for _, rf := range files {
+		var m int64
+		m, err = s.exportFile(ctx, c.ClientIP(), zw, &rf)
+		n += m
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = zw.Close()
+	}
+	promWebseedBytesServed.Add(float64(n))
+	if err != nil {
+		// headers are sent, the client gets a truncated archive
+		logger(ctx).WithError(err).WithFields(log.Fields{"collection_id": id, "bytes": n, "duration": time.Since(start)}).Warn("collection export aborted")
+	}
+}
+
+// exportFile writes stored file content to the zip archive and returns number of bytes written.
+func (s *Web) exportFile(ctx context.Context, ip string, zw *zip.Writer, rf *ResourceFile) (int64, error) {
+	w, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     rf.ResourceID + "/" + strings.TrimPrefix(path.Clean("/"+rf.Path), "/"),
+		Method:   zip.Store,
+		Modified: rf.File.UpdatedAt,
+	})
+	if err != nil {
+		return 0, err
+	}
+	if rf.File.TotalSize == 0 {
+		return 0, nil
+	}
+	if rf.File.Chunked {
+		chunks, err := FileChunkList(ctx, s.pg.Read(), rf.FileHash)
+		if err != nil {
+			return 0, err
+		}
+		cr := newChunkedReader(ctx, s.s3.Get(), s.bucket, s.keys, chunks, 0, rf.File.TotalSize-1)
+		defer func() { _ = cr.Close() }()
+		var r io.Reader = cr
+		if s.rl != nil {
+			r = s.rl.Reader(ctx, ip, r)
+		}
+		return io.Copy(w, r)
+	}
+	var eo *encryptedObject
+	if s.enc.ClientSide() {
+		if eo, _, err = s.headEncryptedObject(ctx, rf.File.bucketOr(s.bucket), rf.FileHash); err != nil {
+			return 0, err
+		}
+	}
+	start, end := int64(0), rf.File.TotalSize-1
+	rng := fmt.Sprintf("bytes=%d-%d", start, end)
+	if eo != nil {
+		rng = eo.CipherRange(start, end).String()
+	}
+	out, err := s.s3.Get().GetObjectWithContext(ctx, &awss3.GetObjectInput{
+		Bucket: aws.String(rf.File.bucketOr(s.bucket)),
+		Key:    aws.String(s.keys.Key(rf.FileHash)),
+		Range:  aws.String(rng),
+	})
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to get %v", rf.FileHash)
+	}
+	defer func(b io.ReadCloser) {
+		_ = b.Close()
+	}(out.Body)
+	var r io.Reader = out.Body
+	if eo != nil {
+		r = eo.Reader(r, start, end)
+	}
+	if s.rl != nil {
+		r = s.rl.Reader(ctx, ip, r)
+	}
+	return io.Copy(w, r)
+}
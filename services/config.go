@@ -0,0 +1,353 @@
+package services
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	configFlag               = "config"
+	logLevelFlag             = "log-level"
+	configReloadIntervalFlag = "config-reload-interval"
+)
+
+// RegisterConfigFlags registers CLI flags for the config file and values which can be reloaded from it.
+func RegisterConfigFlags(f []cli.Flag) []cli.Flag {
+	return append(f,
+		cli.StringFlag{
+			Name:   configFlag,
+			Usage:  "YAML (.yaml, .yml) or TOML (.toml) config file, keys are flag names, flags and env vars take precedence",
+			EnvVar: "CONFIG",
+		},
+		cli.StringFlag{
+			Name:   logLevelFlag,
+			Usage:  "log level: trace, debug, info, warn or error",
+			Value:  "info",
+			EnvVar: "LOG_LEVEL",
+		},
+		cli.DurationFlag{
+			Name:   configReloadIntervalFlag,
+			Usage:  "how often config file is checked for changes, also reloaded on SIGHUP (0 disables reloading)",
+			Value:  30 * time.Second,
+			EnvVar: "CONFIG_RELOAD_INTERVAL",
+		},
+	)
+}
+
+// reloadableOptions are applied by ConfigReloader without restart.
+var reloadableOptions = []string{
+	logLevelFlag,
+	webseedRateLimitFlag,
+	webseedGlobalRateLimitFlag,
+	webseedBurstFlag,
+	webseedRequestRateLimitFlag,
+	webseedRequestBurstFlag,
+}
+
+// ConfigFile holds option values read from a config file. Nested sections are joined with "-",
+// so `webseed: {rate-limit: 1000}` sets webseed-rate-limit, underscores are accepted instead of dashes.
+type ConfigFile map[string][]string
+
+// LoadConfigFile reads YAML or TOML config file depending on its extension.
+func LoadConfigFile(path string) (ConfigFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read config %v", path)
+	}
+	var raw map[string]any
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	case ".toml":
+		err = toml.Unmarshal(data, &raw)
+	default:
+		return nil, errors.Errorf("unsupported config format %v", ext)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse config %v", path)
+	}
+	cf := ConfigFile{}
+	if err := cf.add("", raw); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse config %v", path)
+	}
+	return cf, nil
+}
+
+func (s ConfigFile) add(key string, v any) error {
+	switch vv := v.(type) {
+	case nil:
+	case map[string]any:
+		for k, sv := range vv {
+			name := strings.ReplaceAll(strings.ToLower(k), "_", "-")
+			if key != "" {
+				name = key + "-" + name
+			}
+			if err := s.add(name, sv); err != nil {
+				return err
+			}
+		}
+	case []any:
+		for _, sv := range vv {
+			switch sv.(type) {
+			case map[string]any, []any:
+				return errors.Errorf("option %v must be a list of values", key)
+			}
+			if err := s.add(key, sv); err != nil {
+				return err
+			}
+		}
+	default:
+		if key == "" {
+			return errors.New("config must be a map of options")
+		}
+		s[key] = append(s[key], configValue(vv))
+	}
+	return nil
+}
+
+func configValue(v any) string {
+	switch vv := v.(type) {
+	case float64:
+		return strconv.FormatFloat(vv, 'f', -1, 64)
+	case time.Time:
+		return vv.Format(time.RFC3339)
+	default:
+		return fmt.Sprint(vv)
+	}
+}
+
+// Validate checks that every option of the file is one of flags and its value can be parsed.
+func (s ConfigFile) Validate(flags []cli.Flag) error {
+	_, err := s.flagSet(flags, nil, nil)
+	return err
+}
+
+// flagSet returns flag set with values of the file applied over defaults (and env vars).
+// Options listed in skip are left as they are, pinned values are set over the file.
+func (s ConfigFile) flagSet(flags []cli.Flag, skip map[string]bool, pinned map[string]string) (*flag.FlagSet, error) {
+	fs := flag.NewFlagSet("config", flag.ContinueOnError)
+	for _, f := range flags {
+		f.Apply(fs)
+	}
+	var errs []string
+	for _, k := range s.keys() {
+		if k == configFlag || fs.Lookup(k) == nil {
+			errs = append(errs, fmt.Sprintf("unknown option %v", k))
+			continue
+		}
+		if skip[k] {
+			continue
+		}
+		for _, v := range s[k] {
+			if err := fs.Set(k, v); err != nil {
+				errs = append(errs, fmt.Sprintf("invalid value %q of option %v: %v", v, k, err))
+			}
+		}
+	}
+	for k, v := range pinned {
+		if err := fs.Set(k, v); err != nil {
+			errs = append(errs, fmt.Sprintf("invalid value %q of option %v: %v", v, k, err))
+		}
+	}
+	if l := fs.Lookup(logLevelFlag); l != nil {
+		if _, err := log.ParseLevel(l.Value.String()); err != nil {
+			errs = append(errs, fmt.Sprintf("invalid value of option %v: %v", logLevelFlag, err))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.New(strings.Join(errs, "; "))
+	}
+	return fs, nil
+}
+
+func (s ConfigFile) keys() []string {
+	keys := make([]string, 0, len(s))
+	for k := range s {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Config is the config file merged into flags of the running command.
+type Config struct {
+	path  string
+	flags []cli.Flag
+	file  ConfigFile
+	// explicit lists options set by CLI flags or env vars
+	explicit map[string]bool
+	// pinned keeps explicit values of reloadable options
+	pinned map[string]string
+}
+
+// LoadConfig merges config file into flags of the command and applies log level.
+// Options set by CLI flags or env vars are kept. Returns empty Config if no file is set.
+func LoadConfig(c *cli.Context) (*Config, error) {
+	cfg := &Config{
+		path:     c.String(configFlag),
+		flags:    c.Command.Flags,
+		explicit: map[string]bool{},
+		pinned:   map[string]string{},
+	}
+	for _, f := range cfg.flags {
+		name := strings.Split(f.GetName(), ",")[0]
+		if !c.IsSet(name) {
+			continue
+		}
+		cfg.explicit[name] = true
+		if v, ok := c.Generic(name).(flag.Value); ok && slices.Contains(reloadableOptions, name) {
+			cfg.pinned[name] = v.String()
+		}
+	}
+	if cfg.path != "" {
+		cf, err := LoadConfigFile(cfg.path)
+		if err != nil {
+			return nil, err
+		}
+		if err = cf.Validate(cfg.flags); err != nil {
+			return nil, errors.Wrapf(err, "invalid config %v", cfg.path)
+		}
+		for _, k := range cf.keys() {
+			if cfg.explicit[k] {
+				continue
+			}
+			for _, v := range cf[k] {
+				if err := c.Set(k, v); err != nil {
+					return nil, err
+				}
+			}
+		}
+		cfg.file = cf
+		log.WithField("options", len(cf)).Infof("loaded config from %v", cfg.path)
+	}
+	if err := setLogLevel(c.String(logLevelFlag)); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func setLogLevel(v string) error {
+	l, err := log.ParseLevel(v)
+	if err != nil {
+		return errors.Wrap(err, "failed to parse log level")
+	}
+	if log.GetLevel() != l {
+		log.SetLevel(l)
+		log.Infof("log level set to %v", l)
+	}
+	return nil
+}
+
+// ConfigReloader re-reads config file when it changes or on SIGHUP and applies reloadable options
+// (log level and webseed rate limits). Changes of other options are reported as requiring restart.
+type ConfigReloader struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	cfg      *Config
+	rl       *RateLimiter
+	interval time.Duration
+	modTime  time.Time
+}
+
+// NewConfigReloader returns nil if no config file is set or reloading is disabled.
+func NewConfigReloader(c *cli.Context, cfg *Config, rl *RateLimiter) *ConfigReloader {
+	interval := c.Duration(configReloadIntervalFlag)
+	if cfg.path == "" || interval <= 0 {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &ConfigReloader{
+		ctx:      ctx,
+		cancel:   cancel,
+		cfg:      cfg,
+		rl:       rl,
+		interval: interval,
+	}
+	if fi, err := os.Stat(cfg.path); err == nil {
+		s.modTime = fi.ModTime()
+	}
+	return s
+}
+
+func (s *ConfigReloader) Serve() error {
+	log.Infof("watching config %v every %v", s.cfg.path, s.interval)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return nil
+		case <-hup:
+			s.reload()
+		case <-ticker.C:
+			fi, err := os.Stat(s.cfg.path)
+			if err != nil {
+				log.WithError(err).Warn("failed to stat config")
+				continue
+			}
+			if fi.ModTime().Equal(s.modTime) {
+				continue
+			}
+			s.modTime = fi.ModTime()
+			s.reload()
+		}
+	}
+}
+
+// reload applies the file if it is valid, otherwise running values are kept.
+func (s *ConfigReloader) reload() {
+	l := log.WithField("config", s.cfg.path)
+	cf, err := LoadConfigFile(s.cfg.path)
+	if err != nil {
+		l.WithError(err).Error("failed to reload config")
+		return
+	}
+	fs, err := cf.flagSet(s.cfg.flags, s.cfg.explicit, s.cfg.pinned)
+	if err != nil {
+		l.WithError(err).Error("failed to reload config, keeping running values")
+		return
+	}
+	for _, k := range append(cf.keys(), s.cfg.file.keys()...) {
+		if !slices.Contains(reloadableOptions, k) && !slices.Equal(cf[k], s.cfg.file[k]) {
+			l.WithField("option", k).Warn("option changed, restart is required to apply it")
+		}
+	}
+	c := cli.NewContext(nil, fs, nil)
+	if err := setLogLevel(c.String(logLevelFlag)); err != nil {
+		l.WithError(err).Error("failed to apply log level")
+	}
+	if s.rl != nil {
+		if err := s.rl.Update(c); err != nil {
+			l.WithError(err).Error("failed to apply webseed rate limits, keeping running values")
+		}
+	} else if c.Int64(webseedRateLimitFlag) > 0 || c.Int64(webseedGlobalRateLimitFlag) > 0 || c.Float64(webseedRequestRateLimitFlag) > 0 {
+		l.Warn("webseed rate limiting was disabled on start, restart is required to enable it")
+	}
+	s.cfg.file = cf
+	l.Info("config reloaded")
+}
+
+func (s *ConfigReloader) Close() {
+	if s.cancel != nil {
+		s.cancel()
+	}
+}
@@ -0,0 +1,150 @@
+package services
+
+import (
+	"fmt"
+	"mime"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+)
+
+const (
+	contentClassStorageClassFlag = "content-class-storage-class"
+	contentClassCacheMaxAgeFlag  = "content-class-cache-max-age"
+)
+
+// RegisterContentClassFlags registers CLI flags for per content class policies.
+func RegisterContentClassFlags(f []cli.Flag) []cli.Flag {
+	return append(f,
+		cli.StringSliceFlag{
+			Name:   contentClassStorageClassFlag,
+			Usage:  "S3 storage class of files of a content class as class=STORAGE_CLASS, e.g. video=STANDARD_IA (resource storage class takes precedence)",
+			EnvVar: "CONTENT_CLASS_STORAGE_CLASS",
+		},
+		cli.StringSliceFlag{
+			Name:   contentClassCacheMaxAgeFlag,
+			Usage:  "Cache-Control max-age of webseed responses for files of a content class as class=duration, e.g. subtitle=24h",
+			EnvVar: "CONTENT_CLASS_CACHE_MAX_AGE",
+		},
+	)
+}
+
+// ContentClass is a coarse kind of file content detected at store time.
+type ContentClass string
+
+const (
+	ContentClassVideo    ContentClass = "video"
+	ContentClassAudio    ContentClass = "audio"
+	ContentClassImage    ContentClass = "image"
+	ContentClassSubtitle ContentClass = "subtitle"
+	ContentClassArchive  ContentClass = "archive"
+	ContentClassOther    ContentClass = "other"
+)
+
+var contentClasses = map[ContentClass]bool{
+	ContentClassVideo:    true,
+	ContentClassAudio:    true,
+	ContentClassImage:    true,
+	ContentClassSubtitle: true,
+	ContentClassArchive:  true,
+	ContentClassOther:    true,
+}
+
+// contentClassExts covers extensions common in torrents which mime database often misses.
+var contentClassExts = map[string]ContentClass{
+	".mkv": ContentClassVideo, ".mp4": ContentClassVideo, ".m4v": ContentClassVideo, ".avi": ContentClassVideo,
+	".mov": ContentClassVideo, ".wmv": ContentClassVideo, ".webm": ContentClassVideo, ".ts": ContentClassVideo,
+	".m2ts": ContentClassVideo, ".mpg": ContentClassVideo, ".mpeg": ContentClassVideo, ".flv": ContentClassVideo,
+	".mp3": ContentClassAudio, ".flac": ContentClassAudio, ".m4a": ContentClassAudio, ".aac": ContentClassAudio,
+	".ogg": ContentClassAudio, ".opus": ContentClassAudio, ".wav": ContentClassAudio, ".ape": ContentClassAudio,
+	".jpg": ContentClassImage, ".jpeg": ContentClassImage, ".png": ContentClassImage, ".gif": ContentClassImage,
+	".webp": ContentClassImage, ".bmp": ContentClassImage,
+	".srt": ContentClassSubtitle, ".vtt": ContentClassSubtitle, ".ass": ContentClassSubtitle, ".ssa": ContentClassSubtitle,
+	".sub": ContentClassSubtitle, ".idx": ContentClassSubtitle, ".sup": ContentClassSubtitle,
+	".zip": ContentClassArchive, ".rar": ContentClassArchive, ".7z": ContentClassArchive, ".tar": ContentClassArchive,
+	".gz": ContentClassArchive, ".bz2": ContentClassArchive, ".xz": ContentClassArchive, ".iso": ContentClassArchive,
+}
+
+// DetectContentClass classifies file by extension of its path.
+func DetectContentClass(p string) ContentClass {
+	ext := strings.ToLower(path.Ext(p))
+	if cc, ok := contentClassExts[ext]; ok {
+		return cc
+	}
+	t := mime.TypeByExtension(ext)
+	switch {
+	case strings.HasPrefix(t, "video/"):
+		return ContentClassVideo
+	case strings.HasPrefix(t, "audio/"):
+		return ContentClassAudio
+	case strings.HasPrefix(t, "image/"):
+		return ContentClassImage
+	}
+	return ContentClassOther
+}
+
+// ContentPolicy is handling of files of a content class.
+type ContentPolicy struct {
+	// StorageClass of new objects, empty uses default
+	StorageClass string
+	// CacheMaxAge of webseed responses, 0 sends no Cache-Control
+	CacheMaxAge time.Duration
+}
+
+// ContentPolicies keys policies by content class.
+type ContentPolicies map[ContentClass]ContentPolicy
+
+func NewContentPolicies(c *cli.Context) (ContentPolicies, error) {
+	ps := ContentPolicies{}
+	for _, v := range c.StringSlice(contentClassStorageClassFlag) {
+		cc, val, err := parseContentClassValue(v)
+		if err != nil {
+			return nil, err
+		}
+		p := ps[cc]
+		if p.StorageClass, err = ParseStorageClass(val); err != nil {
+			return nil, err
+		}
+		ps[cc] = p
+	}
+	for _, v := range c.StringSlice(contentClassCacheMaxAgeFlag) {
+		cc, val, err := parseContentClassValue(v)
+		if err != nil {
+			return nil, err
+		}
+		p := ps[cc]
+		if p.CacheMaxAge, err = time.ParseDuration(val); err != nil {
+			return nil, errors.Wrapf(err, "failed to parse cache max age of %v", cc)
+		}
+		ps[cc] = p
+	}
+	return ps, nil
+}
+
+func parseContentClassValue(v string) (ContentClass, string, error) {
+	k, val, ok := strings.Cut(v, "=")
+	cc := ContentClass(strings.TrimSpace(k))
+	if !ok || !contentClasses[cc] {
+		return "", "", errors.Errorf("failed to parse content class policy %q", v)
+	}
+	return cc, strings.TrimSpace(val), nil
+}
+
+// StorageClass returns storage class of new objects of the class, def if the policy doesn't set one.
+func (s ContentPolicies) StorageClass(cc ContentClass, def string) string {
+	if sc := s[cc].StorageClass; sc != "" {
+		return sc
+	}
+	return def
+}
+
+// CacheControl returns Cache-Control header of webseed responses, empty if not configured.
+func (s ContentPolicies) CacheControl(cc ContentClass) string {
+	if age := s[cc].CacheMaxAge; age > 0 {
+		return fmt.Sprintf("public, max-age=%d", int64(age.Seconds()))
+	}
+	return ""
+}
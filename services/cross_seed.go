@@ -0,0 +1,123 @@
+package services
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	pg "github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+)
+
+// CrossSeed is a resource sharing files with the resource of the report.
+type CrossSeed struct {
+	ResourceID string `json:"resource_id"`
+	// SharedFiles is the number of distinct files both resources contain
+	SharedFiles int `json:"shared_files"`
+	// SharedSize is the total size of shared files, counted once per file
+	SharedSize int64 `json:"shared_size"`
+	// Share is SharedSize as a fraction of the unique size of the resource of the report
+	Share float64 `json:"share"`
+}
+
+// CrossSeedReport lists resources sharing files with the resource, most shared bytes first.
+type CrossSeedReport struct {
+	ResourceID string `json:"resource_id"`
+	// Files and Size count distinct files of the resource
+	Files     int         `json:"files"`
+	Size      int64       `json:"size"`
+	Resources []CrossSeed `json:"resources"`
+	Total     int         `json:"total"`
+	Limit     int         `json:"limit"`
+	Offset    int         `json:"offset"`
+}
+
+// GetCrossSeeds returns a page of resources sharing files with the resource and their total count.
+// Non-empty owner limits them to resources of the tenant.
+func GetCrossSeeds(ctx context.Context, db pg.DBI, id string, owner string, limit, offset int) (*CrossSeedReport, error) {
+	report := &CrossSeedReport{ResourceID: id, Resources: []CrossSeed{}, Limit: limit, Offset: offset}
+	_, err := db.QueryOneContext(ctx, pg.Scan(&report.Files, &report.Size), `
+		SELECT count(*), coalesce(sum(f.total_size), 0)
+		FROM file f
+		WHERE f.hash IN (SELECT file_hash FROM resource_file WHERE resource_id = ?)`, id)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to count files")
+	}
+	var rows []struct {
+		CrossSeed
+		Total int
+	}
+	_, err = db.QueryContext(ctx, &rows, `
+		SELECT p.resource_id,
+		       count(*) AS shared_files,
+		       coalesce(sum(f.total_size), 0) AS shared_size,
+		       count(*) OVER () AS total
+		FROM (
+			SELECT DISTINCT o.resource_id, o.file_hash
+			FROM resource_file rf
+			JOIN resource_file o ON o.file_hash = rf.file_hash AND o.resource_id <> rf.resource_id
+			WHERE rf.resource_id = ?0
+		) p
+		JOIN file f ON f.hash = p.file_hash
+		JOIN resource r ON r.resource_id = p.resource_id
+		WHERE ?1 = '' OR r.owner = ?1
+		GROUP BY p.resource_id
+		ORDER BY shared_size DESC, p.resource_id
+		LIMIT ?2 OFFSET ?3`, id, owner, limit, offset)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to find cross-seeds")
+	}
+	for _, r := range rows {
+		if report.Size > 0 {
+			r.Share = float64(r.SharedSize) / float64(report.Size)
+		}
+		report.Total = r.Total
+		report.Resources = append(report.Resources, r.CrossSeed)
+	}
+	return report, nil
+}
+
+// GET /resource/{id}/cross-seeds
+// getResourceCrossSeeds godoc
+// @Summary      Resources sharing files
+// @Description  Lists other resources containing files of the resource (by file hash), with the number and total size
+// @Description  of shared files and their share of the resource size, most shared bytes first. Scoped tokens only
+// @Description  see resources of their tenant.
+// @Tags         resource
+// @Produce      json
+// @Param        id      path      string  true   "Resource ID"
+// @Param        limit   query     int     false  "Page size"
+// @Param        offset  query     int     false  "Page offset"
+// @Success      200  {object}  CrossSeedReport
+// @Failure      400  {object}  ErrorResponse
+// @Failure      404  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /resource/{id}/cross-seeds [get]
+func (s *Web) getResourceCrossSeeds(c *gin.Context) {
+	db := s.pg.Get()
+	if db == nil {
+		_ = c.Error(errors.New("DB not configured"))
+		return
+	}
+	limit, offset, err := parseListLimits(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	id := c.Param("id")
+	res, err := ResourceGetByID(c.Request.Context(), db, id)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if res == nil {
+		_ = c.Error(errors.New("resource not found"))
+		return
+	}
+	report, err := GetCrossSeeds(c.Request.Context(), db, id, s.auth.Tenant(c), limit, offset)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,325 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	awss3 "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gin-gonic/gin"
+	"github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+	"golang.org/x/net/webdav"
+)
+
+// davLocks is shared by all WebDAV handlers. Locks are never taken since the interface is read-only,
+// but webdav.Handler requires a lock system.
+var davLocks = webdav.NewMemLS()
+
+// ANY /dav/{id}/{path}
+// dav godoc
+// @Summary      WebDAV access to stored resource
+// @Description  Read-only WebDAV (PROPFIND, GET, HEAD, OPTIONS) over stored files of the resource,
+// @Description  so it can be mounted in file managers and media players.
+// @Tags         webseed
+// @Param        id    path      string  true  "Resource ID"
+// @Param        path  path      string  true  "Path inside resource"
+// @Success      200
+// @Success      207
+// @Failure      404  {object}  ErrorResponse
+// @Failure      405
+// @Failure      410  {object}  GoneResponse
+// @Router       /dav/{id}/{path} [get]
+func (s *Web) dav(c *gin.Context) {
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, "PROPFIND":
+	default:
+		c.Header("Allow", "OPTIONS, GET, HEAD, PROPFIND")
+		c.Status(http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.validateWebSeedDependencies(c) {
+		return
+	}
+	id := c.Param("id")
+	db := s.pg.Read()
+	st, err := s.lookupResourceState(c, db, id)
+	if err != nil {
+		s.webseedLookupError(c, err)
+		return
+	}
+	if st.takedown {
+		c.AbortWithStatusJSON(http.StatusGone, &GoneResponse{Error: ErrTakenDown.Error(), ReasonCode: st.reasonCode})
+		return
+	}
+	if !st.stored {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	h := &webdav.Handler{
+		Prefix:     "/dav/" + id,
+		FileSystem: &davFS{web: s, db: db, id: id, ip: c.ClientIP()},
+		LockSystem: davLocks,
+		Logger: func(r *http.Request, err error) {
+			if err != nil && !os.IsNotExist(err) {
+				logger(r.Context()).WithError(err).WithField("id", id).Warn("webdav error")
+			}
+		},
+	}
+	h.ServeHTTP(c.Writer, c.Request)
+}
+
+// davFS is a read-only webdav.FileSystem over files of a single resource.
+type davFS struct {
+	web *Web
+	db  *pg.DB
+	id  string
+	ip  string
+}
+
+func (s *davFS) Mkdir(context.Context, string, os.FileMode) error {
+	return os.ErrPermission
+}
+
+func (s *davFS) RemoveAll(context.Context, string) error {
+	return os.ErrPermission
+}
+
+func (s *davFS) Rename(context.Context, string, string) error {
+	return os.ErrPermission
+}
+
+func (s *davFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	fi, _, err := s.stat(ctx, name)
+	return fi, err
+}
+
+func (s *davFS) OpenFile(ctx context.Context, name string, flag int, _ os.FileMode) (webdav.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) != 0 {
+		return nil, os.ErrPermission
+	}
+	fi, hash, err := s.stat(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return &davFile{fs: s, ctx: ctx, fi: fi, name: NormalizePath(name), hash: hash}, nil
+}
+
+// stat resolves name to a stored file (returning its hash) or to a directory containing stored files.
+func (s *davFS) stat(ctx context.Context, name string) (*davFileInfo, string, error) {
+	p := NormalizePath(name)
+	if p == "" {
+		p = "/"
+	}
+	if p != "/" {
+		rf := &ResourceFile{}
+		err := s.db.Model(rf).Context(ctx).
+			Relation("File").
+			Where("resource_file.resource_id = ? and resource_file.path = ?", s.id, strings.TrimSuffix(p, "/")).
+			Select()
+		if err != nil && !errors.Is(err, pg.ErrNoRows) {
+			return nil, "", err
+		}
+		if err == nil && rf.File != nil {
+			return &davFileInfo{name: path.Base(rf.Path), size: rf.File.TotalSize, modTime: rf.File.UpdatedAt, chunked: rf.File.Chunked, bucket: rf.File.bucketOr(s.web.bucket)}, rf.FileHash, nil
+		}
+	}
+	dir := strings.TrimSuffix(p, "/") + "/"
+	rfs, err := ResourceFileListByPrefix(ctx, s.db, s.id, dir)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(rfs) == 0 {
+		return nil, "", os.ErrNotExist
+	}
+	fi := &davFileInfo{name: path.Base(dir), dir: true}
+	for _, rf := range rfs {
+		if rf.File != nil && rf.File.UpdatedAt.After(fi.modTime) {
+			fi.modTime = rf.File.UpdatedAt
+		}
+	}
+	return fi, "", nil
+}
+
+type davFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+	chunked bool
+	// bucket of the object of a file
+	bucket string
+}
+
+func (s *davFileInfo) Name() string       { return s.name }
+func (s *davFileInfo) Size() int64        { return s.size }
+func (s *davFileInfo) ModTime() time.Time { return s.modTime }
+func (s *davFileInfo) IsDir() bool        { return s.dir }
+func (s *davFileInfo) Sys() any           { return nil }
+
+func (s *davFileInfo) Mode() os.FileMode {
+	if s.dir {
+		return os.ModeDir | 0555
+	}
+	return 0444
+}
+
+// ContentType prevents webdav from sniffing content, which would cost an extra S3 request.
+func (s *davFileInfo) ContentType(context.Context) (string, error) {
+	if t := mime.TypeByExtension(path.Ext(s.name)); t != "" {
+		return t, nil
+	}
+	return "application/octet-stream", nil
+}
+
+// davFile reads stored object from S3 starting at the current offset, reopening the body on seek.
+type davFile struct {
+	fs     *davFS
+	ctx    context.Context
+	fi     *davFileInfo
+	name   string
+	hash   string
+	offset int64
+	body   io.ReadCloser
+}
+
+func (s *davFile) Stat() (os.FileInfo, error) {
+	return s.fi, nil
+}
+
+func (s *davFile) Write([]byte) (int, error) {
+	return 0, os.ErrPermission
+}
+
+func (s *davFile) Readdir(count int) ([]os.FileInfo, error) {
+	if !s.fi.dir {
+		return nil, errors.New("not a directory")
+	}
+	entries, err := s.fs.web.listDir(s.ctx, s.fs.db, s.fs.id, strings.TrimSuffix(s.name, "/")+"/")
+	if err != nil {
+		return nil, err
+	}
+	fis := make([]os.FileInfo, 0, len(entries))
+	for _, e := range entries {
+		fis = append(fis, &davFileInfo{name: e.Name, size: e.Size, modTime: s.fi.modTime, dir: e.Type == indexEntryDirectory})
+	}
+	if count > 0 && len(fis) > count {
+		fis = fis[:count]
+	}
+	return fis, nil
+}
+
+func (s *davFile) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = s.offset + offset
+	case io.SeekEnd:
+		abs = s.fi.size + offset
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if abs < 0 {
+		return 0, errors.New("negative position")
+	}
+	if abs != s.offset {
+		s.closeBody()
+		s.offset = abs
+	}
+	return abs, nil
+}
+
+func (s *davFile) Read(b []byte) (int, error) {
+	if s.fi.dir {
+		return 0, errors.New("is a directory")
+	}
+	if s.offset >= s.fi.size {
+		return 0, io.EOF
+	}
+	if s.body == nil {
+		if err := s.open(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := s.body.Read(b)
+	s.offset += int64(n)
+	promWebseedBytesServed.Add(float64(n))
+	return n, err
+}
+
+// open requests object content from the current offset to the end, decrypting it if needed.
+func (s *davFile) open() error {
+	w := s.fs.web
+	start, end := s.offset, s.fi.size-1
+	if s.fi.chunked {
+		chunks, err := FileChunkList(s.ctx, s.fs.db, s.hash)
+		if err != nil {
+			return err
+		}
+		r := newChunkedReader(s.ctx, w.s3.Get(), w.bucket, w.keys, chunks, start, end)
+		s.body = &readCloser{Reader: s.limit(r), Closer: r}
+		return nil
+	}
+	var eo *encryptedObject
+	if w.enc.ClientSide() {
+		var err error
+		if eo, _, err = w.headEncryptedObject(s.ctx, s.fi.bucket, s.hash); err != nil {
+			return err
+		}
+	}
+	rng := fmt.Sprintf("bytes=%d-%d", start, end)
+	if eo != nil {
+		rng = eo.CipherRange(start, end).String()
+	}
+	out, err := w.s3.Get().GetObjectWithContext(s.ctx, &awss3.GetObjectInput{
+		Bucket: aws.String(s.fi.bucket),
+		Key:    aws.String(w.keys.Key(s.hash)),
+		Range:  aws.String(rng),
+	})
+	if err != nil {
+		return err
+	}
+	var r io.Reader = out.Body
+	if eo != nil {
+		r = eo.Reader(r, start, end)
+	}
+	s.body = &readCloser{Reader: s.limit(r), Closer: out.Body}
+	return nil
+}
+
+// limit applies rate limit and abuse accounting of the client to r.
+func (s *davFile) limit(r io.Reader) io.Reader {
+	w := s.fs.web
+	if w.rl != nil {
+		r = w.rl.Reader(s.ctx, s.fs.ip, r)
+	}
+	if w.abuse != nil {
+		ip := s.fs.ip
+		r = &progressReader{r: r, onRead: func(n int) error {
+			w.abuse.Record(AbuseKindDownload, ip, int64(n))
+			return nil
+		}}
+	}
+	return r
+}
+
+func (s *davFile) closeBody() {
+	if s.body != nil {
+		_ = s.body.Close()
+		s.body = nil
+	}
+}
+
+func (s *davFile) Close() error {
+	s.closeBody()
+	return nil
+}
@@ -0,0 +1,91 @@
+package services
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+const (
+	dbConnectTimeoutFlag    = "db-connect-timeout"
+	dbConnectMaxBackoffFlag = "db-connect-max-backoff"
+)
+
+func RegisterDBFlags(f []cli.Flag) []cli.Flag {
+	return append(f,
+		cli.DurationFlag{
+			Name:   dbConnectTimeoutFlag,
+			Usage:  "how long to retry connecting to postgres at startup (0 disables retries)",
+			Value:  2 * time.Minute,
+			EnvVar: "DB_CONNECT_TIMEOUT",
+		},
+		cli.DurationFlag{
+			Name:   dbConnectMaxBackoffFlag,
+			Usage:  "max delay between postgres connection attempts at startup",
+			Value:  10 * time.Second,
+			EnvVar: "DB_CONNECT_MAX_BACKOFF",
+		},
+	)
+}
+
+// WaitForDB pings postgres with exponential backoff until it responds or
+// db-connect-timeout passes, so that vault doesn't crash-loop when started
+// before the database.
+func WaitForDB(c *cli.Context, p *PG) error {
+	db := p.Get()
+	if db == nil {
+		return nil
+	}
+	timeout := c.Duration(dbConnectTimeoutFlag)
+	maxBackoff := c.Duration(dbConnectMaxBackoffFlag)
+	deadline := time.Now().Add(timeout)
+	backoff := 500 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err := db.Ping(ctx)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		if time.Now().Add(backoff).After(deadline) {
+			return errors.Wrapf(err, "failed to connect to postgres after %v attempts", attempt)
+		}
+		log.WithError(err).Warnf("postgres is unavailable, retrying in %v", backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+		if maxBackoff > 0 && backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// isDBUnavailable reports whether err means postgres couldn't be reached
+// (as opposed to a query error).
+func isDBUnavailable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var pgErr pg.Error
+	if errors.As(err, &pgErr) {
+		code := pgErr.Field('C')
+		// connection exceptions and operator intervention (shutdown, too many connections)
+		return strings.HasPrefix(code, "08") || strings.HasPrefix(code, "57P") || code == "53300"
+	}
+	return false
+}
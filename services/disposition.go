@@ -0,0 +1,135 @@
+package services
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// contentTypeExts covers media types of extensions common in torrents which mime database often misses.
+var contentTypeExts = map[string]string{
+	".mkv": "video/x-matroska", ".mp4": "video/mp4", ".m4v": "video/mp4", ".avi": "video/x-msvideo",
+	".mov": "video/quicktime", ".webm": "video/webm", ".ts": "video/mp2t", ".m2ts": "video/mp2t",
+	".mpg": "video/mpeg", ".mpeg": "video/mpeg", ".flv": "video/x-flv", ".wmv": "video/x-ms-wmv",
+	".mp3": "audio/mpeg", ".flac": "audio/flac", ".m4a": "audio/mp4", ".aac": "audio/aac",
+	".ogg": "audio/ogg", ".opus": "audio/opus", ".wav": "audio/wav",
+	".srt": "application/x-subrip", ".vtt": "text/vtt; charset=utf-8", ".ass": "text/x-ssa", ".ssa": "text/x-ssa",
+	".txt": "text/plain; charset=utf-8", ".nfo": "text/plain; charset=utf-8",
+}
+
+// contentTypeByPath returns media type of the file by extension of its path, empty if unknown.
+func contentTypeByPath(p string) string {
+	ext := strings.ToLower(path.Ext(p))
+	if t, ok := contentTypeExts[ext]; ok {
+		return t
+	}
+	return mime.TypeByExtension(ext)
+}
+
+// contentDisposition formats Content-Disposition of the file with a quoted ASCII filename for old clients
+// and RFC 5987 filename* carrying the original name if it isn't plain ASCII.
+func contentDisposition(typ, p string) string {
+	name := path.Base(p)
+	ascii := true
+	fallback := strings.Map(func(r rune) rune {
+		if r < 0x20 || r >= 0x7f || r == '"' || r == '\\' {
+			ascii = false
+			return '_'
+		}
+		return r
+	}, name)
+	v := typ + `; filename="` + fallback + `"`
+	if !ascii {
+		v += "; filename*=UTF-8''" + encodeExtValue(name)
+	}
+	return v
+}
+
+// encodeExtValue percent-encodes every byte of s except RFC 5987 attr-char.
+func encodeExtValue(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9' || strings.IndexByte("!#$&+-.^_`|~", ch) >= 0 {
+			b.WriteByte(ch)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", ch)
+	}
+	return b.String()
+}
+
+// parseDisposition returns requested Content-Disposition type of webseed response: download=1 is
+// a shortcut for disposition=attachment. Empty means none.
+func parseDisposition(c *gin.Context) (string, error) {
+	d := c.Query("disposition")
+	switch v := c.Query("download"); v {
+	case "":
+	case "1", "true":
+		if d != "" && d != "attachment" {
+			return "", errors.Errorf("failed to parse disposition %v: conflicts with download", d)
+		}
+		d = "attachment"
+	default:
+		return "", errors.Errorf("failed to parse download %v", v)
+	}
+	if d != "" && d != "inline" && d != "attachment" {
+		return "", errors.Errorf("failed to parse disposition %v", d)
+	}
+	return d, nil
+}
+
+// dispositionWriter sets Content-Disposition and media type of the file on successful responses. Headers
+// are set by handlers from S3 object metadata, so they are only overridden when the status is written.
+type dispositionWriter struct {
+	gin.ResponseWriter
+	disposition string
+	path        string
+	done        bool
+}
+
+func (w *dispositionWriter) apply(status int) {
+	if w.done {
+		return
+	}
+	w.done = true
+	if status != http.StatusOK && status != http.StatusPartialContent {
+		return
+	}
+	h := w.Header()
+	h.Set("Content-Disposition", contentDisposition(w.disposition, w.path))
+	h.Set("X-Content-Type-Options", "nosniff")
+	// multiple ranges keep their multipart type, parts carry media type of the file
+	if ct := contentTypeByPath(w.path); ct != "" && !strings.HasPrefix(h.Get("Content-Type"), "multipart/") {
+		h.Set("Content-Type", ct)
+	}
+	// inline content (e.g. html) must not run scripts on vault origin
+	if w.disposition == "inline" {
+		h.Set("Content-Security-Policy", "sandbox")
+	}
+}
+
+func (w *dispositionWriter) WriteHeader(code int) {
+	w.apply(code)
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *dispositionWriter) WriteHeaderNow() {
+	w.apply(w.Status())
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *dispositionWriter) Write(b []byte) (int, error) {
+	w.apply(w.Status())
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *dispositionWriter) WriteString(s string) (int, error) {
+	w.apply(w.Status())
+	return w.ResponseWriter.WriteString(s)
+}
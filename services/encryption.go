@@ -0,0 +1,391 @@
+package services
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+)
+
+const (
+	s3SSEFlag             = "s3-sse"
+	s3SSEKMSKeyIDFlag     = "s3-sse-kms-key-id"
+	encryptionKeyFlag     = "encryption-key"
+	encryptionKeyFileFlag = "encryption-key-file"
+)
+
+// RegisterEncryptionFlags registers CLI flags for server-side and client-side encryption.
+func RegisterEncryptionFlags(f []cli.Flag) []cli.Flag {
+	return append(f,
+		cli.StringFlag{
+			Name:   s3SSEFlag,
+			Usage:  "S3 server-side encryption: AES256 (SSE-S3) or aws:kms (SSE-KMS)",
+			EnvVar: "S3_SSE",
+		},
+		cli.StringFlag{
+			Name:   s3SSEKMSKeyIDFlag,
+			Usage:  "KMS key id used with aws:kms server-side encryption",
+			EnvVar: "S3_SSE_KMS_KEY_ID",
+		},
+		cli.StringFlag{
+			Name:   encryptionKeyFlag,
+			Usage:  "hex or base64 encoded 32-byte key enabling client-side AES-256-GCM envelope encryption",
+			EnvVar: "ENCRYPTION_KEY",
+		},
+		cli.StringFlag{
+			Name:   encryptionKeyFileFlag,
+			Usage:  "path to a file with the client-side encryption key",
+			EnvVar: "ENCRYPTION_KEY_FILE",
+		},
+	)
+}
+
+const (
+	// encSegmentSize is the plaintext size of a single independently sealed segment.
+	// Segments make random access (Range requests) possible without decrypting the whole object.
+	encSegmentSize = 64 * 1024
+	encTagSize     = 16
+	encNonceSize   = 12
+	encScheme      = "aes-256-gcm-64k"
+
+	encMetaScheme    = "Vault-Encryption"
+	encMetaDEK       = "Vault-Dek"
+	encMetaPlainSize = "Vault-Plain-Size"
+)
+
+// Encryption configures encryption of stored objects.
+type Encryption struct {
+	sse      string
+	kmsKeyID string
+	key      []byte
+}
+
+func NewEncryption(c *cli.Context, secrets *Secrets) (*Encryption, error) {
+	sse := c.String(s3SSEFlag)
+	if sse != "" && sse != "AES256" && sse != "aws:kms" {
+		return nil, errors.Errorf("unsupported server-side encryption %v", sse)
+	}
+	e := &Encryption{
+		sse:      sse,
+		kmsKeyID: c.String(s3SSEKMSKeyIDFlag),
+	}
+	keyStr := c.String(encryptionKeyFlag)
+	if p := c.String(encryptionKeyFileFlag); p != "" {
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read encryption key file")
+		}
+		if len(b) == 32 {
+			e.key = b
+		} else {
+			keyStr = string(b)
+		}
+	}
+	// key fetched from HashiCorp Vault or decrypted with KMS
+	if b := secrets.EncryptionKey.Get(); len(b) == 32 {
+		e.key = b
+	} else if len(b) > 0 {
+		keyStr = string(b)
+	}
+	if e.key == nil && keyStr != "" {
+		key, err := decodeKey(keyStr)
+		if err != nil {
+			return nil, err
+		}
+		e.key = key
+	}
+	if e.sse == "" && e.key == nil {
+		return nil, nil
+	}
+	return e, nil
+}
+
+func decodeKey(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if b, err := hex.DecodeString(s); err == nil && len(b) == 32 {
+		return b, nil
+	}
+	if b, err := base64.StdEncoding.DecodeString(s); err == nil && len(b) == 32 {
+		return b, nil
+	}
+	return nil, errors.New("encryption key must be 32 bytes encoded as hex or base64")
+}
+
+// ClientSide reports whether objects are encrypted by vault before upload.
+func (s *Encryption) ClientSide() bool {
+	return s != nil && s.key != nil
+}
+
+// ObjectSize returns size of the S3 object storing content of the given size.
+func (s *Encryption) ObjectSize(size int64) int64 {
+	if !s.ClientSide() {
+		return size
+	}
+	segments := (size + encSegmentSize - 1) / encSegmentSize
+	return size + segments*encTagSize
+}
+
+// PrepareCopy applies server-side encryption settings to a copy of an object,
+// client-side encryption metadata is copied along with the object.
+func (s *Encryption) PrepareCopy(in *s3.CopyObjectInput) {
+	if s == nil || s.sse == "" {
+		return
+	}
+	in.ServerSideEncryption = aws.String(s.sse)
+	if s.sse == "aws:kms" && s.kmsKeyID != "" {
+		in.SSEKMSKeyId = aws.String(s.kmsKeyID)
+	}
+}
+
+// PrepareMultipartCopy applies server-side encryption settings to a multipart copy of an object.
+func (s *Encryption) PrepareMultipartCopy(in *s3.CreateMultipartUploadInput) {
+	if s == nil || s.sse == "" {
+		return
+	}
+	in.ServerSideEncryption = aws.String(s.sse)
+	if s.sse == "aws:kms" && s.kmsKeyID != "" {
+		in.SSEKMSKeyId = aws.String(s.kmsKeyID)
+	}
+}
+
+// Replica returns settings of copies made within the replica bucket: KMS keys are regional, so the replica
+// bucket default KMS key is used.
+func (s *Encryption) Replica() *Encryption {
+	if s == nil {
+		return nil
+	}
+	return &Encryption{sse: s.sse, key: s.key}
+}
+
+// PrepareReplica applies server-side encryption to a copy in the replica bucket. KMS keys are
+// regional, so the replica bucket default KMS key is used. Client-side encrypted objects are copied as is.
+func (s *Encryption) PrepareReplica(in *s3manager.UploadInput) {
+	if s == nil || s.sse == "" {
+		return
+	}
+	in.ServerSideEncryption = aws.String(s.sse)
+}
+
+// PrepareUpload sets server-side encryption fields of the upload and, if client-side encryption is enabled,
+// wraps the body with an encrypting reader and stores the wrapped data key in object metadata.
+func (s *Encryption) PrepareUpload(in *s3manager.UploadInput, size int64) error {
+	if s == nil {
+		return nil
+	}
+	if s.sse != "" {
+		in.ServerSideEncryption = aws.String(s.sse)
+		if s.sse == "aws:kms" && s.kmsKeyID != "" {
+			in.SSEKMSKeyId = aws.String(s.kmsKeyID)
+		}
+	}
+	if s.key == nil {
+		return nil
+	}
+	dek := make([]byte, 32)
+	if _, err := rand.Read(dek); err != nil {
+		return err
+	}
+	wrapped, err := s.wrapKey(dek)
+	if err != nil {
+		return err
+	}
+	aead, err := newAEAD(dek)
+	if err != nil {
+		return err
+	}
+	if in.Metadata == nil {
+		in.Metadata = map[string]*string{}
+	}
+	in.Metadata[encMetaScheme] = aws.String(encScheme)
+	in.Metadata[encMetaDEK] = aws.String(wrapped)
+	in.Metadata[encMetaPlainSize] = aws.String(strconv.FormatInt(size, 10))
+	in.Body = &encryptingReader{r: in.Body, aead: aead}
+	return nil
+}
+
+func (s *Encryption) wrapKey(dek []byte) (string, error) {
+	aead, err := newAEAD(s.key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, encNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, dek, nil)), nil
+}
+
+func (s *Encryption) unwrapKey(wrapped string) ([]byte, error) {
+	b, err := base64.StdEncoding.DecodeString(wrapped)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < encNonceSize {
+		return nil, errors.New("wrapped data key is too short")
+	}
+	aead, err := newAEAD(s.key)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Open(nil, b[:encNonceSize], b[encNonceSize:], nil)
+}
+
+// encryptedObject holds decryption parameters of a client-side encrypted object.
+type encryptedObject struct {
+	aead      cipher.AEAD
+	plainSize int64
+}
+
+// Object returns decryption parameters from S3 object metadata. Nil is returned for
+// objects stored without client-side encryption.
+func (s *Encryption) Object(meta map[string]*string) (*encryptedObject, error) {
+	if !s.ClientSide() || metaValue(meta, encMetaScheme) != encScheme {
+		return nil, nil
+	}
+	dek, err := s.unwrapKey(metaValue(meta, encMetaDEK))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to unwrap data key")
+	}
+	aead, err := newAEAD(dek)
+	if err != nil {
+		return nil, err
+	}
+	size, err := strconv.ParseInt(metaValue(meta, encMetaPlainSize), 10, 64)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse plain size")
+	}
+	return &encryptedObject{aead: aead, plainSize: size}, nil
+}
+
+// CipherRange returns ciphertext byte range covering plaintext range [start, end].
+func (s *encryptedObject) CipherRange(start, end int64) *byteRange {
+	first := start / encSegmentSize
+	last := end / encSegmentSize
+	return &byteRange{
+		start: first * (encSegmentSize + encTagSize),
+		end:   (last+1)*(encSegmentSize+encTagSize) - 1,
+	}
+}
+
+// Reader decrypts ciphertext r (starting at segment boundary returned by CipherRange)
+// and returns exactly plaintext bytes [start, end].
+func (s *encryptedObject) Reader(r io.Reader, start, end int64) io.Reader {
+	dr := &decryptingReader{r: r, aead: s.aead, seg: uint64(start / encSegmentSize)}
+	skip := start % encSegmentSize
+	return io.LimitReader(&skipReader{r: dr, skip: skip}, end-start+1)
+}
+
+func metaValue(meta map[string]*string, key string) string {
+	for k, v := range meta {
+		if strings.EqualFold(k, key) && v != nil {
+			return *v
+		}
+	}
+	return ""
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	b, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(b)
+}
+
+func segmentNonce(seg uint64) []byte {
+	nonce := make([]byte, encNonceSize)
+	binary.BigEndian.PutUint64(nonce[encNonceSize-8:], seg)
+	return nonce
+}
+
+// encryptingReader seals plaintext in fixed-size segments. Data key is unique per object,
+// so segment index is used as a nonce.
+type encryptingReader struct {
+	r    io.Reader
+	aead cipher.AEAD
+	seg  uint64
+	buf  bytes.Buffer
+	eof  bool
+}
+
+func (s *encryptingReader) Read(b []byte) (int, error) {
+	for s.buf.Len() == 0 {
+		if s.eof {
+			return 0, io.EOF
+		}
+		plain := make([]byte, encSegmentSize)
+		n, err := io.ReadFull(s.r, plain)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			s.eof = true
+		} else if err != nil {
+			return 0, err
+		}
+		if n > 0 {
+			s.buf.Write(s.aead.Seal(nil, segmentNonce(s.seg), plain[:n], nil))
+			s.seg++
+		}
+	}
+	return s.buf.Read(b)
+}
+
+type decryptingReader struct {
+	r    io.Reader
+	aead cipher.AEAD
+	seg  uint64
+	buf  bytes.Buffer
+	eof  bool
+}
+
+func (s *decryptingReader) Read(b []byte) (int, error) {
+	for s.buf.Len() == 0 {
+		if s.eof {
+			return 0, io.EOF
+		}
+		sealed := make([]byte, encSegmentSize+encTagSize)
+		n, err := io.ReadFull(s.r, sealed)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			s.eof = true
+		} else if err != nil {
+			return 0, err
+		}
+		if n == 0 {
+			continue
+		}
+		plain, err := s.aead.Open(nil, segmentNonce(s.seg), sealed[:n], nil)
+		if err != nil {
+			return 0, errors.Wrapf(err, "failed to decrypt segment %v", s.seg)
+		}
+		s.seg++
+		s.buf.Write(plain)
+	}
+	return s.buf.Read(b)
+}
+
+// skipReader discards first skip bytes of the underlying reader.
+type skipReader struct {
+	r    io.Reader
+	skip int64
+}
+
+func (s *skipReader) Read(b []byte) (int, error) {
+	if s.skip > 0 {
+		if _, err := io.CopyN(io.Discard, s.r, s.skip); err != nil {
+			return 0, err
+		}
+		s.skip = 0
+	}
+	return s.r.Read(b)
+}
@@ -0,0 +1,172 @@
+package services
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// testContent returns n pseudo-random bytes, the same for the same seed.
+func testContent(seed int64, n int) []byte {
+	b := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(b)
+	return b
+}
+
+func testEncryption(t *testing.T) *Encryption {
+	t.Helper()
+	return &Encryption{key: bytes.Repeat([]byte{7}, 32)}
+}
+
+// testEncrypt encrypts content the way it is uploaded and returns ciphertext with object metadata.
+func testEncrypt(t *testing.T, s *Encryption, content []byte) ([]byte, map[string]*string) {
+	t.Helper()
+	in := &s3manager.UploadInput{Body: bytes.NewReader(content)}
+	if err := s.PrepareUpload(in, int64(len(content))); err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(in.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b, in.Metadata
+}
+
+func TestDecodeKey(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	tests := []struct {
+		name string
+		s    string
+		ok   bool
+	}{
+		{name: "hex", s: hex.EncodeToString(key), ok: true},
+		{name: "base64", s: base64.StdEncoding.EncodeToString(key), ok: true},
+		{name: "trailing newline", s: hex.EncodeToString(key) + "\n", ok: true},
+		{name: "short", s: hex.EncodeToString(key[:16])},
+		{name: "garbage", s: "not a key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeKey(tt.s)
+			if (err == nil) != tt.ok {
+				t.Fatalf("decodeKey(%q) error = %v", tt.s, err)
+			}
+			if tt.ok && !bytes.Equal(got, key) {
+				t.Errorf("decodeKey(%q) = %x", tt.s, got)
+			}
+		})
+	}
+}
+
+func TestEncryptionSegments(t *testing.T) {
+	s := testEncryption(t)
+	for _, size := range []int{0, 1, encSegmentSize - 1, encSegmentSize, encSegmentSize + 1, 3*encSegmentSize + 100} {
+		content := testContent(int64(size), size)
+		b, meta := testEncrypt(t, s, content)
+		segments := (size + encSegmentSize - 1) / encSegmentSize
+		if want := size + segments*encTagSize; len(b) != want {
+			t.Errorf("%v bytes are encrypted to %v bytes, want %v", size, len(b), want)
+		}
+		// a few bytes may occur in random ciphertext by chance
+		if size >= 16 && bytes.Contains(b, content) {
+			t.Errorf("%v bytes are stored in plain", size)
+		}
+		obj, err := s.Object(meta)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if obj == nil || obj.plainSize != int64(size) {
+			t.Fatalf("object of %v bytes = %+v", size, obj)
+		}
+	}
+}
+
+func TestEncryptionCipherRange(t *testing.T) {
+	const seg = encSegmentSize + encTagSize
+	tests := []struct {
+		name       string
+		start, end int64
+		want       byteRange
+	}{
+		{name: "first byte", start: 0, end: 0, want: byteRange{0, seg - 1}},
+		{name: "first segment", start: 0, end: encSegmentSize - 1, want: byteRange{0, seg - 1}},
+		{name: "inside segment", start: encSegmentSize + 10, end: encSegmentSize + 20, want: byteRange{seg, 2*seg - 1}},
+		{name: "across segments", start: encSegmentSize - 1, end: encSegmentSize, want: byteRange{0, 2*seg - 1}},
+		{name: "many segments", start: 10, end: 3*encSegmentSize + 5, want: byteRange{0, 4*seg - 1}},
+	}
+	obj := &encryptedObject{}
+	for _, tt := range tests {
+		if got := obj.CipherRange(tt.start, tt.end); *got != tt.want {
+			t.Errorf("%v: CipherRange(%v, %v) = %v, want %v", tt.name, tt.start, tt.end, got, tt.want)
+		}
+	}
+}
+
+func TestEncryptionRangeReader(t *testing.T) {
+	s := testEncryption(t)
+	size := int64(3*encSegmentSize + 100)
+	content := testContent(1, int(size))
+	b, meta := testEncrypt(t, s, content)
+	obj, err := s.Object(meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		start, end int64
+	}{
+		{name: "whole", start: 0, end: size - 1},
+		{name: "first byte", start: 0, end: 0},
+		{name: "last byte", start: size - 1, end: size - 1},
+		{name: "segment edge", start: encSegmentSize - 1, end: encSegmentSize},
+		{name: "second segment", start: encSegmentSize, end: 2*encSegmentSize - 1},
+		{name: "inside segment", start: encSegmentSize + 10, end: encSegmentSize + 20},
+		{name: "into last segment", start: 2*encSegmentSize + 5, end: size - 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cr := obj.CipherRange(tt.start, tt.end)
+			// S3 clamps the range at the end of the object
+			end := cr.end + 1
+			if end > int64(len(b)) {
+				end = int64(len(b))
+			}
+			got, err := io.ReadAll(obj.Reader(bytes.NewReader(b[cr.start:end]), tt.start, tt.end))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, content[tt.start:tt.end+1]) {
+				t.Errorf("read %v bytes differing from plaintext [%v, %v]", len(got), tt.start, tt.end)
+			}
+		})
+	}
+}
+
+func TestEncryptionObject(t *testing.T) {
+	s := testEncryption(t)
+	b, meta := testEncrypt(t, s, testContent(2, 100))
+	if obj, err := s.Object(nil); err != nil || obj != nil {
+		t.Errorf("object without metadata = %v, %v, want nil", obj, err)
+	}
+	var plain *Encryption
+	if obj, err := plain.Object(meta); err != nil || obj != nil {
+		t.Errorf("object with encryption disabled = %v, %v, want nil", obj, err)
+	}
+	other := &Encryption{key: bytes.Repeat([]byte{8}, 32)}
+	if _, err := other.Object(meta); err == nil {
+		t.Error("expected error unwrapping data key with another key")
+	}
+	obj, err := s.Object(meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b[10] ^= 1
+	if _, err := io.ReadAll(obj.Reader(bytes.NewReader(b), 0, 99)); err == nil {
+		t.Error("expected error decrypting tampered segment")
+	}
+}
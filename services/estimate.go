@@ -0,0 +1,72 @@
+package services
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// EstimateResponse describes what storing a resource would take.
+type EstimateResponse struct {
+	ResourceID string `json:"resource_id"`
+	FileCount  int    `json:"file_count"`
+	TotalSize  int64  `json:"total_size"`
+	// StoredSize is the size of files which are already stored and would be deduplicated
+	StoredSize int64 `json:"stored_size"`
+	// NewSize is the projected storage usage growth
+	NewSize int64 `json:"new_size"`
+	// Resource is present if the resource is already known to vault
+	Resource *Resource `json:"resource,omitempty"`
+}
+
+// GET /resource/{id}/estimate
+// getResourceEstimate godoc
+// @Summary      Estimate resource size
+// @Description  Lists the torrent via rest-api without queueing it and returns total size, file count
+// @Description  and projected storage growth (files already stored are deduplicated).
+// @Tags         resource
+// @Param        id   path      string  true  "Resource ID"
+// @Success      200  {object}  EstimateResponse
+// @Failure      404  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /resource/{id}/estimate [get]
+func (s *Web) getResourceEstimate(c *gin.Context) {
+	db := s.pg.Get()
+	if db == nil {
+		_ = c.Error(errors.New("DB not configured"))
+		return
+	}
+	id := c.Param("id")
+	items, err := s.api.ListResourceFiles(c.Request.Context(), &Claims{Role: "vault"}, id)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if len(items) == 0 {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	res := &EstimateResponse{ResourceID: id, FileCount: len(items)}
+	for _, item := range items {
+		res.TotalSize += item.Size
+		// same check the worker uses to skip already stored files
+		stored, err := db.Model((*File)(nil)).Context(c.Request.Context()).
+			Where("total_size = ? AND path = ? AND hash_algo = ?", item.Size, NormalizePath(item.PathStr), s.hashAlgo).
+			Where("status = ?", StatusStored).
+			Exists()
+		if err != nil {
+			_ = c.Error(err)
+			return
+		}
+		if stored {
+			res.StoredSize += item.Size
+		}
+	}
+	res.NewSize = res.TotalSize - res.StoredSize
+	if res.Resource, err = ResourceGetByID(c.Request.Context(), db, id); err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
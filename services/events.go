@@ -0,0 +1,123 @@
+package services
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+const (
+	natsURLFlag           = "nats-url"
+	natsSubjectPrefixFlag = "nats-subject-prefix"
+)
+
+// RegisterEventsFlags registers CLI flags for event publishing.
+func RegisterEventsFlags(f []cli.Flag) []cli.Flag {
+	return append(f,
+		cli.StringFlag{
+			Name:   natsURLFlag,
+			Usage:  "NATS url resource lifecycle events are published to (disabled if empty)",
+			EnvVar: "NATS_URL",
+		},
+		cli.StringFlag{
+			Name:   natsSubjectPrefixFlag,
+			Usage:  "prefix of NATS event subjects",
+			Value:  "vault",
+			EnvVar: "NATS_SUBJECT_PREFIX",
+		},
+	)
+}
+
+// Event subjects (without prefix).
+const (
+	EventResourceStored  = "resource.stored"
+	EventResourceDeleted = "resource.deleted"
+	EventResourceError   = "resource.error"
+	EventFileProgress    = "file.progress"
+)
+
+// ResourceEvent is published on resource lifecycle changes.
+type ResourceEvent struct {
+	ResourceID string    `json:"resource_id"`
+	Status     string    `json:"status"`
+	TotalSize  int64     `json:"total_size,omitempty"`
+	StoredSize int64     `json:"stored_size,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Time       time.Time `json:"time"`
+}
+
+// FileProgressEvent is published periodically while a file is being stored.
+type FileProgressEvent struct {
+	ResourceID string    `json:"resource_id"`
+	FileHash   string    `json:"file_hash"`
+	Path       string    `json:"path"`
+	StoredSize int64     `json:"stored_size"`
+	TotalSize  int64     `json:"total_size"`
+	Done       bool      `json:"done"`
+	Time       time.Time `json:"time"`
+	// Phase is hashing or uploading, HashedSize of HashingSize bytes are hashed in the hashing phase
+	Phase       string `json:"phase,omitempty"`
+	HashedSize  int64  `json:"hashed_size,omitempty"`
+	HashingSize int64  `json:"hashing_size,omitempty"`
+}
+
+// Events publishes vault events to NATS.
+type Events struct {
+	nc     *nats.Conn
+	prefix string
+}
+
+// NewEvents returns nil if NATS url is not configured.
+func NewEvents(c *cli.Context) (*Events, error) {
+	u := c.String(natsURLFlag)
+	if u == "" {
+		return nil, nil
+	}
+	nc, err := nats.Connect(u,
+		nats.Name("vault"),
+		nats.MaxReconnects(-1),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err != nil {
+				log.WithError(err).Warn("NATS disconnected")
+			}
+		}),
+		nats.ReconnectHandler(func(nc *nats.Conn) {
+			log.Infof("NATS reconnected to %v", nc.ConnectedUrl())
+		}),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to connect to NATS")
+	}
+	return &Events{nc: nc, prefix: c.String(natsSubjectPrefixFlag)}, nil
+}
+
+// Publish sends event to prefix.subject. Nil Events is a no-op. Failures are logged only,
+// events are best-effort and must not break storing or deletion.
+func (s *Events) Publish(subject string, ev any) {
+	if s == nil {
+		return
+	}
+	b, err := json.Marshal(ev)
+	if err != nil {
+		log.WithError(err).Error("failed to marshal event")
+		return
+	}
+	if s.prefix != "" {
+		subject = s.prefix + "." + subject
+	}
+	if err := s.nc.Publish(subject, b); err != nil {
+		log.WithError(err).WithField("subject", subject).Warn("failed to publish event")
+	}
+}
+
+func (s *Events) Close() {
+	if s == nil {
+		return
+	}
+	log.Info("closing Events")
+	_ = s.nc.Drain()
+}
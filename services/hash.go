@@ -0,0 +1,63 @@
+package services
+
+import (
+	"crypto/sha256"
+	"hash"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+	"github.com/zeebo/blake3"
+)
+
+const (
+	hashModeFlag      = "hash-mode"
+	hashStreamingFlag = "hash-streaming"
+)
+
+// RegisterHashFlags registers CLI flags for file content hashing.
+func RegisterHashFlags(f []cli.Flag) []cli.Flag {
+	return append(f,
+		cli.StringFlag{
+			Name:   hashModeFlag,
+			Usage:  "file hash mode: sampled (size + first/last 500KB), full-sha256 or blake3 (whole content)",
+			Value:  string(HashAlgoSampled),
+			EnvVar: "HASH_MODE",
+		},
+		cli.BoolFlag{
+			Name:   hashStreamingFlag,
+			Usage:  "compute full-sha256/blake3 hash while uploading to a temporary key and rename it afterwards instead of downloading content twice",
+			EnvVar: "HASH_STREAMING",
+		},
+	)
+}
+
+// HashAlgo is an algorithm used to compute file hash (dedup key).
+type HashAlgo string
+
+const (
+	HashAlgoSampled    HashAlgo = "sampled"
+	HashAlgoFullSHA256 HashAlgo = "full-sha256"
+	HashAlgoBLAKE3     HashAlgo = "blake3"
+)
+
+// ParseHashAlgo validates hash mode name.
+func ParseHashAlgo(v string) (HashAlgo, error) {
+	switch a := HashAlgo(v); a {
+	case HashAlgoSampled, HashAlgoFullSHA256, HashAlgoBLAKE3:
+		return a, nil
+	}
+	return "", errors.Errorf("unsupported hash mode %v", v)
+}
+
+// Full reports whether the algorithm hashes the whole content.
+func (s HashAlgo) Full() bool {
+	return s == HashAlgoFullSHA256 || s == HashAlgoBLAKE3
+}
+
+// newHasher returns hash function for the algorithm. Sampled mode uses sha256 over sampled content.
+func (s HashAlgo) newHasher() hash.Hash {
+	if s == HashAlgoBLAKE3 {
+		return blake3.New()
+	}
+	return sha256.New()
+}
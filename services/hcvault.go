@@ -0,0 +1,172 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+const (
+	hcVaultAddrFlag            = "hcvault-addr"
+	hcVaultTokenFlag           = "hcvault-token"
+	hcVaultTokenFileFlag       = "hcvault-token-file"
+	hcVaultSecretPathFlag      = "hcvault-secret-path"
+	hcVaultRefreshIntervalFlag = "hcvault-refresh-interval"
+)
+
+// Keys of the HashiCorp Vault secret mapped to vault secrets.
+const (
+	hcVaultKeyWebtorSecret       = "webtor_secret"
+	hcVaultKeyTokenSecret        = "token_secret"
+	hcVaultKeyAWSAccessKeyID     = "aws_access_key_id"
+	hcVaultKeyAWSSecretAccessKey = "aws_secret_access_key"
+	hcVaultKeyEncryptionKey      = "encryption_key"
+)
+
+// RegisterHCVaultFlags registers CLI flags for fetching secrets from HashiCorp Vault.
+func RegisterHCVaultFlags(f []cli.Flag) []cli.Flag {
+	return append(f,
+		cli.StringFlag{
+			Name:   hcVaultAddrFlag,
+			Usage:  "HashiCorp Vault address, secrets are fetched from it if set",
+			EnvVar: "VAULT_ADDR",
+		},
+		cli.StringFlag{
+			Name:   hcVaultTokenFlag,
+			Usage:  "HashiCorp Vault token",
+			EnvVar: "VAULT_TOKEN",
+		},
+		cli.StringFlag{
+			Name:   hcVaultTokenFileFlag,
+			Usage:  "path to a file with HashiCorp Vault token (e.g. written by vault agent)",
+			EnvVar: "VAULT_TOKEN_FILE",
+		},
+		cli.StringFlag{
+			Name: hcVaultSecretPathFlag,
+			Usage: "path of KV secret with webtor_secret, token_secret, aws_access_key_id, aws_secret_access_key " +
+				"and encryption_key keys (e.g. secret/data/vault for KV v2)",
+			EnvVar: "VAULT_SECRET_PATH",
+		},
+		cli.DurationFlag{
+			Name:   hcVaultRefreshIntervalFlag,
+			Usage:  "how often secrets are re-fetched and the token is renewed",
+			Value:  5 * time.Minute,
+			EnvVar: "VAULT_REFRESH_INTERVAL",
+		},
+	)
+}
+
+// HCVault is a minimal HashiCorp Vault client reading KV secrets.
+type HCVault struct {
+	cl        *http.Client
+	addr      string
+	token     string
+	tokenFile string
+	path      string
+	interval  time.Duration
+}
+
+// NewHCVault returns nil if HashiCorp Vault is not configured.
+func NewHCVault(c *cli.Context, cl *http.Client) (*HCVault, error) {
+	addr := c.String(hcVaultAddrFlag)
+	if addr == "" {
+		return nil, nil
+	}
+	s := &HCVault{
+		cl:        cl,
+		addr:      strings.TrimSuffix(addr, "/"),
+		token:     c.String(hcVaultTokenFlag),
+		tokenFile: c.String(hcVaultTokenFileFlag),
+		path:      strings.Trim(c.String(hcVaultSecretPathFlag), "/"),
+		interval:  c.Duration(hcVaultRefreshIntervalFlag),
+	}
+	if s.path == "" {
+		return nil, errors.Errorf("%v is required with %v", hcVaultSecretPathFlag, hcVaultAddrFlag)
+	}
+	if s.token == "" && s.tokenFile == "" {
+		return nil, errors.Errorf("%v or %v is required with %v", hcVaultTokenFlag, hcVaultTokenFileFlag, hcVaultAddrFlag)
+	}
+	return s, nil
+}
+
+func (s *HCVault) getToken() (string, error) {
+	if s.tokenFile == "" {
+		return s.token, nil
+	}
+	b, err := os.ReadFile(s.tokenFile)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to read vault token file")
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+func (s *HCVault) do(ctx context.Context, method, path string, v any) error {
+	token, err := s.getToken()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, fmt.Sprintf("%v/v1/%v", s.addr, path), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	res, err := s.cl.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func(r io.ReadCloser) {
+		_ = r.Close()
+	}(res.Body)
+	if res.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return errors.Errorf("vault responded with status=%v body=%s", res.StatusCode, b)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// Fetch reads secret values. Both KV v1 and KV v2 responses are supported.
+func (s *HCVault) Fetch(ctx context.Context) (map[string]string, error) {
+	var res struct {
+		Data map[string]any `json:"data"`
+	}
+	if err := s.do(ctx, http.MethodGet, s.path, &res); err != nil {
+		return nil, errors.Wrapf(err, "failed to fetch vault secret %v", s.path)
+	}
+	data := res.Data
+	if inner, ok := data["data"].(map[string]any); ok {
+		if _, v2 := data["metadata"]; v2 {
+			data = inner
+		}
+	}
+	values := map[string]string{}
+	for k, v := range data {
+		if str, ok := v.(string); ok {
+			values[k] = str
+		}
+	}
+	return values, nil
+}
+
+// RenewToken extends the token lease. Non-renewable tokens are left as is.
+func (s *HCVault) RenewToken(ctx context.Context) error {
+	var res struct {
+		Auth struct {
+			LeaseDuration int  `json:"lease_duration"`
+			Renewable     bool `json:"renewable"`
+		} `json:"auth"`
+	}
+	if err := s.do(ctx, http.MethodPost, "auth/token/renew-self", &res); err != nil {
+		return err
+	}
+	log.WithField("lease_duration", res.Auth.LeaseDuration).Debug("vault token renewed")
+	return nil
+}
@@ -0,0 +1,190 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	awss3 "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+	cs "github.com/webtor-io/common-services"
+)
+
+const (
+	readinessCheckRestAPIFlag = "readiness-check-rest-api"
+	readinessTimeoutFlag      = "readiness-timeout"
+)
+
+// RegisterHealthFlags registers CLI flags for readiness checks.
+func RegisterHealthFlags(f []cli.Flag) []cli.Flag {
+	return append(f,
+		cli.BoolFlag{
+			Name:   readinessCheckRestAPIFlag,
+			Usage:  "include webtor rest-api availability into readiness check",
+			EnvVar: "READINESS_CHECK_REST_API",
+		},
+		cli.DurationFlag{
+			Name:   readinessTimeoutFlag,
+			Usage:  "timeout of a single readiness check",
+			Value:  3 * time.Second,
+			EnvVar: "READINESS_TIMEOUT",
+		},
+	)
+}
+
+const (
+	CheckStatusOK   = "ok"
+	CheckStatusFail = "fail"
+)
+
+// CheckResult is a result of a single dependency check.
+type CheckResult struct {
+	Status    string `json:"status"`
+	LatencyMs int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+// HealthResponse aggregates dependency checks.
+type HealthResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+	// Circuits are states of the store circuit breaker, omitted if it is disabled
+	Circuits map[string]*CircuitState `json:"circuits,omitempty"`
+}
+
+// Health checks connectivity to vault dependencies.
+type Health struct {
+	pg           *PG
+	s3           *cs.S3Client
+	api          *Api
+	buckets      []string
+	checkRestAPI bool
+	timeout      time.Duration
+	breaker      *CircuitBreaker
+	checkBreaker bool
+}
+
+func NewHealth(c *cli.Context, pg *PG, s3 *cs.S3Client, api *Api, breaker *CircuitBreaker, router *BucketRouter) *Health {
+	return &Health{
+		pg:           pg,
+		s3:           s3,
+		api:          api,
+		buckets:      router.Buckets(),
+		checkRestAPI: c.Bool(readinessCheckRestAPIFlag),
+		timeout:      c.Duration(readinessTimeoutFlag),
+		breaker:      breaker,
+		checkBreaker: c.Bool(readinessCheckCircuitBreakerFlag) && breaker != nil,
+	}
+}
+
+func (s *Health) checks() map[string]func(ctx context.Context) error {
+	checks := map[string]func(ctx context.Context) error{
+		"postgres": s.checkPG,
+		"s3":       s.checkS3,
+	}
+	if s.checkRestAPI {
+		checks["rest_api"] = s.api.Ping
+	}
+	if s.checkBreaker {
+		checks["circuit_breaker"] = s.checkCircuitBreaker
+	}
+	return checks
+}
+
+func (s *Health) checkCircuitBreaker(_ context.Context) error {
+	if open := s.breaker.OpenCircuits(time.Now()); len(open) > 0 {
+		return errors.Errorf("circuit open: %v", strings.Join(open, ", "))
+	}
+	return nil
+}
+
+func (s *Health) checkPG(ctx context.Context) error {
+	db := s.pg.Get()
+	if db == nil {
+		return errors.New("DB not configured")
+	}
+	return db.Ping(ctx)
+}
+
+func (s *Health) checkS3(ctx context.Context) error {
+	if s.s3 == nil {
+		return errors.New("S3 not configured")
+	}
+	if s.buckets[0] == "" {
+		return errors.New("aws-bucket is not configured")
+	}
+	// every bucket files are routed to has to be reachable
+	for _, b := range s.buckets {
+		if _, err := s.s3.Get().HeadBucketWithContext(ctx, &awss3.HeadBucketInput{
+			Bucket: aws.String(b),
+		}); err != nil {
+			return errors.Wrapf(err, "failed to reach bucket %v", b)
+		}
+	}
+	return nil
+}
+
+// Check runs all checks concurrently.
+func (s *Health) Check(ctx context.Context) *HealthResponse {
+	res := &HealthResponse{Status: CheckStatusOK, Checks: map[string]CheckResult{}}
+	var mux sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range s.checks() {
+		wg.Add(1)
+		go func(name string, check func(ctx context.Context) error) {
+			defer wg.Done()
+			cctx, cancel := context.WithTimeout(ctx, s.timeout)
+			defer cancel()
+			start := time.Now()
+			err := check(cctx)
+			r := CheckResult{Status: CheckStatusOK, LatencyMs: time.Since(start).Milliseconds()}
+			if err != nil {
+				r.Status = CheckStatusFail
+				r.Error = err.Error()
+			}
+			mux.Lock()
+			defer mux.Unlock()
+			res.Checks[name] = r
+			if err != nil {
+				res.Status = CheckStatusFail
+			}
+		}(name, check)
+	}
+	wg.Wait()
+	res.Circuits = s.breaker.State(time.Now())
+	return res
+}
+
+// GET /readiness
+// getReadiness godoc
+// @Summary      Readiness check
+// @Description  Checks Postgres, S3 bucket and optionally webtor rest-api availability and store circuit breaker. States of
+// @Description  circuits are reported if the circuit breaker is enabled.
+// @Tags         health
+// @Produce      json
+// @Success      200  {object}  HealthResponse
+// @Failure      503  {object}  HealthResponse
+// @Router       /readiness [get]
+func (s *Web) getReadiness(c *gin.Context) {
+	res := s.health.Check(c.Request.Context())
+	status := http.StatusOK
+	if res.Status != CheckStatusOK {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, res)
+}
+
+// GET /liveness
+// getLiveness godoc
+// @Summary      Liveness check
+// @Tags         health
+// @Success      200
+// @Router       /liveness [get]
+func (s *Web) getLiveness(c *gin.Context) {
+	c.Status(http.StatusOK)
+}
@@ -0,0 +1,71 @@
+package services
+
+import (
+	"regexp"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// IDType is the scheme of a resource id.
+type IDType string
+
+const (
+	// IDTypeInfohash is a v1 (SHA-1) torrent infohash, 40 hex chars
+	IDTypeInfohash IDType = "infohash"
+	// IDTypeInfohashV2 is a v2 (SHA-256) torrent infohash, 64 hex chars
+	IDTypeInfohashV2 IDType = "infohash_v2"
+	// IDTypeUUID identifies content which doesn't come from a torrent, e.g. uploaded externally
+	IDTypeUUID IDType = "uuid"
+	// IDTypeCID is an IPFS content id: CIDv0 (base58 Qm...) or CIDv1 in base32 (b...)
+	IDTypeCID IDType = "cid"
+)
+
+var (
+	infohashV2Re = regexp.MustCompile(`^[0-9a-f]{64}$`)
+	cidV0Re      = regexp.MustCompile(`^Qm[1-9A-HJ-NP-Za-km-z]{44}$`)
+	cidV1Re      = regexp.MustCompile(`^b[a-z2-7]{58,}$`)
+)
+
+// Torrent reports whether resources of the type are stored from torrents through rest-api.
+func (t IDType) Torrent() bool {
+	return t == IDTypeInfohash || t == IDTypeInfohashV2
+}
+
+// valid reports whether id is well-formed for the type.
+func (t IDType) valid(id string) bool {
+	switch t {
+	case IDTypeInfohash:
+		return infohashRe.MatchString(id)
+	case IDTypeInfohashV2:
+		return infohashV2Re.MatchString(id)
+	case IDTypeUUID:
+		u, err := uuid.Parse(id)
+		return err == nil && u.String() == id
+	case IDTypeCID:
+		return cidV0Re.MatchString(id) || cidV1Re.MatchString(id)
+	}
+	return false
+}
+
+// DetectIDType returns the scheme of the id, formats of the schemes don't overlap.
+func DetectIDType(id string) (IDType, error) {
+	for _, t := range []IDType{IDTypeInfohash, IDTypeInfohashV2, IDTypeUUID, IDTypeCID} {
+		if t.valid(id) {
+			return t, nil
+		}
+	}
+	return "", errors.Errorf("failed to parse resource id %q: lowercase infohash, v2 infohash, uuid or cid is required", id)
+}
+
+// requireTorrentID fails unless resources with the id can be stored from a torrent.
+func requireTorrentID(id string) (IDType, error) {
+	t, err := DetectIDType(id)
+	if err != nil {
+		return "", err
+	}
+	if !t.Torrent() {
+		return "", errors.Errorf("failed to parse resource id %q: resources of id type %v can't be stored from torrents", id, t)
+	}
+	return t, nil
+}
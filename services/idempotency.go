@@ -0,0 +1,160 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+const (
+	idempotencyKeyTTLFlag = "idempotency-key-ttl"
+)
+
+const (
+	idempotencyKeyHeader     = "Idempotency-Key"
+	idempotencyReplayHeader  = "Idempotent-Replayed"
+	maxIdempotencyKeyLength  = 255
+	maxIdempotentRequestSize = 1024 * 1024
+)
+
+// RegisterIdempotencyFlags registers CLI flags for Idempotency-Key support.
+func RegisterIdempotencyFlags(f []cli.Flag) []cli.Flag {
+	return append(f,
+		cli.DurationFlag{
+			Name:   idempotencyKeyTTLFlag,
+			Usage:  "how long responses of requests with Idempotency-Key are replayed to duplicates",
+			Value:  24 * time.Hour,
+			EnvVar: "IDEMPOTENCY_KEY_TTL",
+		},
+	)
+}
+
+// responseRecorder keeps a copy of the response body.
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// idempotent is a gin middleware replaying the recorded response to requests repeating Idempotency-Key
+// of an earlier request. Requests failed with an error or 5xx status are not recorded, so they can be
+// retried with the same key.
+func (s *Web) idempotent(c *gin.Context) {
+	key := c.GetHeader(idempotencyKeyHeader)
+	db := s.pg.Get()
+	if key == "" || db == nil {
+		return
+	}
+	if len(key) > maxIdempotencyKeyLength {
+		c.AbortWithStatusJSON(http.StatusBadRequest, &ErrorResponse{Error: "Idempotency-Key is too long"})
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxIdempotentRequestSize+1))
+	if err != nil {
+		_ = c.Error(errors.Wrap(err, "failed to read request"))
+		c.Abort()
+		return
+	}
+	if len(body) > maxIdempotentRequestSize {
+		c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, &ErrorResponse{Error: "request is too large for Idempotency-Key"})
+		return
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	// keys are scoped to the tenant of the caller, so tenants never get responses recorded for another one
+	tenant := s.auth.Tenant(c)
+	if tenant != "" {
+		key = "tenant:" + tenant + "/" + key
+	}
+	h := sha256.New()
+	h.Write([]byte(tenant + "\n" + c.Request.Method + " " + c.Request.URL.Path + "\n"))
+	h.Write(body)
+	hash := hex.EncodeToString(h.Sum(nil))
+
+	ctx := c.Request.Context()
+	rec, acquired, err := IdempotencyKeyAcquire(ctx, db, key, hash, s.idempotencyTTL)
+	if err != nil {
+		_ = c.Error(err)
+		c.Abort()
+		return
+	}
+	if !acquired {
+		switch {
+		case rec.RequestHash != hash:
+			c.AbortWithStatusJSON(http.StatusUnprocessableEntity, &ErrorResponse{Error: "Idempotency-Key was used for another request"})
+		case rec.Status == nil:
+			c.AbortWithStatusJSON(http.StatusConflict, &ErrorResponse{Error: "request with this Idempotency-Key is in progress"})
+		default:
+			ct := "application/json; charset=utf-8"
+			if rec.ContentType != nil {
+				ct = *rec.ContentType
+			}
+			c.Header(idempotencyReplayHeader, "true")
+			c.Data(*rec.Status, ct, rec.Body)
+			c.Abort()
+		}
+		return
+	}
+
+	w := &responseRecorder{ResponseWriter: c.Writer}
+	c.Writer = w
+	c.Next()
+	// response is recorded even if the client went away
+	ctx = context.WithoutCancel(ctx)
+	l := logger(ctx).WithField("idempotency_key", key)
+	if len(c.Errors) > 0 || w.Status() >= http.StatusInternalServerError {
+		if err := IdempotencyKeyRelease(ctx, db, key); err != nil {
+			l.WithError(err).Error("failed to release idempotency key")
+		}
+		return
+	}
+	if err := IdempotencyKeyComplete(ctx, db, key, w.Status(), w.Header().Get("Content-Type"), w.body.Bytes()); err != nil {
+		l.WithError(err).Error("failed to record idempotent response")
+	}
+}
+
+// sweepIdempotencyKeys removes expired idempotency keys.
+func (s *Worker) sweepIdempotencyKeys(ctx context.Context, db *pg.DB) error {
+	n, err := IdempotencyKeyDeleteExpired(ctx, db, s.idempotencyTTL, 1000)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		log.WithField("count", n).Debug("expired idempotency keys removed")
+	}
+	return nil
+}
+
+// etagMatches reports whether If-Match header value matches the resource, "*" matches any existing resource.
+func etagMatches(ifMatch string, res *Resource) bool {
+	if res == nil {
+		return false
+	}
+	etag := res.ETag()
+	for _, v := range strings.Split(ifMatch, ",") {
+		v = strings.TrimSpace(v)
+		if v == "*" || v == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,162 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+func TestEtagMatches(t *testing.T) {
+	res := &Resource{UpdatedAt: time.Unix(1700000000, 0), Status: StatusStored}
+	etag := res.ETag()
+	tests := []struct {
+		name    string
+		ifMatch string
+		res     *Resource
+		want    bool
+	}{
+		{name: "same", ifMatch: etag, res: res, want: true},
+		{name: "any", ifMatch: "*", res: res, want: true},
+		{name: "in list", ifMatch: `"x", ` + etag, res: res, want: true},
+		{name: "other", ifMatch: `"x"`, res: res},
+		{name: "unquoted", ifMatch: strings.Trim(etag, `"`), res: res},
+		{name: "missing resource", ifMatch: "*"},
+		{name: "status changed", ifMatch: etag, res: &Resource{UpdatedAt: res.UpdatedAt, Status: StatusQueuedForDeletion}},
+	}
+	for _, tt := range tests {
+		if got := etagMatches(tt.ifMatch, tt.res); got != tt.want {
+			t.Errorf("%v: etagMatches(%q) = %v, want %v", tt.name, tt.ifMatch, got, tt.want)
+		}
+	}
+}
+
+// testIdempotentRouter routes requests through the idempotent middleware to handlers counting their calls:
+// /ok responds 201 with the call number, /fail responds 503 and /error fails with an error.
+// Tenant of the caller is taken from X-Test-Tenant header.
+func testIdempotentRouter(t *testing.T, s *Web) (http.Handler, *atomic.Int64) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	var calls atomic.Int64
+	r := gin.New()
+	r.Use(s.errorHandler, func(c *gin.Context) {
+		if tenant := c.GetHeader("X-Test-Tenant"); tenant != "" {
+			c.Set(tokenClaimsContextKey, &TokenClaims{Tenant: tenant})
+		}
+	}, s.idempotent)
+	r.PUT("/ok/:id", func(c *gin.Context) {
+		c.JSON(http.StatusCreated, gin.H{"call": calls.Add(1)})
+	})
+	r.PUT("/fail/:id", func(c *gin.Context) {
+		calls.Add(1)
+		c.JSON(http.StatusServiceUnavailable, gin.H{})
+	})
+	r.PUT("/error/:id", func(c *gin.Context) {
+		calls.Add(1)
+		_ = c.Error(errors.New("boom"))
+	})
+	return r, &calls
+}
+
+type testIdempotentRequest struct {
+	path, key, tenant, body string
+}
+
+func (r testIdempotentRequest) do(h http.Handler) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPut, r.path, strings.NewReader(r.body))
+	if r.key != "" {
+		req.Header.Set(idempotencyKeyHeader, r.key)
+	}
+	if r.tenant != "" {
+		req.Header.Set("X-Test-Tenant", r.tenant)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestIdempotent(t *testing.T) {
+	s := &Web{pg: testPG(t), auth: &Auth{secret: &Secret{}, tokenSecret: &Secret{}}, idempotencyTTL: time.Hour}
+	h, calls := testIdempotentRouter(t, s)
+	first := testIdempotentRequest{path: "/ok/1", key: "k1", body: "a"}
+	tests := []struct {
+		name     string
+		req      testIdempotentRequest
+		status   int
+		calls    int64
+		replayed bool
+	}{
+		{name: "first", req: first, status: http.StatusCreated, calls: 1},
+		{name: "repeated", req: first, status: http.StatusCreated, calls: 1, replayed: true},
+		{name: "another body", req: testIdempotentRequest{path: "/ok/1", key: "k1", body: "b"}, status: http.StatusUnprocessableEntity, calls: 1},
+		{name: "another path", req: testIdempotentRequest{path: "/ok/2", key: "k1", body: "a"}, status: http.StatusUnprocessableEntity, calls: 1},
+		{name: "another key", req: testIdempotentRequest{path: "/ok/1", key: "k2", body: "a"}, status: http.StatusCreated, calls: 2},
+		{name: "no key", req: testIdempotentRequest{path: "/ok/1", body: "a"}, status: http.StatusCreated, calls: 3},
+		{name: "no key again", req: testIdempotentRequest{path: "/ok/1", body: "a"}, status: http.StatusCreated, calls: 4},
+		{name: "long key", req: testIdempotentRequest{path: "/ok/1", key: strings.Repeat("k", maxIdempotencyKeyLength+1)}, status: http.StatusBadRequest, calls: 4},
+		// failed requests are not recorded, so they can be retried
+		{name: "5xx", req: testIdempotentRequest{path: "/fail/1", key: "k3"}, status: http.StatusServiceUnavailable, calls: 5},
+		{name: "5xx retried", req: testIdempotentRequest{path: "/fail/1", key: "k3"}, status: http.StatusServiceUnavailable, calls: 6},
+		{name: "error", req: testIdempotentRequest{path: "/error/1", key: "k4"}, status: http.StatusInternalServerError, calls: 7},
+		{name: "error retried", req: testIdempotentRequest{path: "/error/1", key: "k4"}, status: http.StatusInternalServerError, calls: 8},
+		// keys of tenants don't collide with each other and with keys of unscoped callers
+		{name: "tenant", req: testIdempotentRequest{path: "/ok/1", key: "k1", tenant: "a", body: "a"}, status: http.StatusCreated, calls: 9},
+		{name: "another tenant", req: testIdempotentRequest{path: "/ok/1", key: "k1", tenant: "b", body: "a"}, status: http.StatusCreated, calls: 10},
+		{name: "tenant repeated", req: testIdempotentRequest{path: "/ok/1", key: "k1", tenant: "a", body: "a"}, status: http.StatusCreated, calls: 10, replayed: true},
+	}
+	var firstBody string
+	for _, tt := range tests {
+		w := tt.req.do(h)
+		if w.Code != tt.status {
+			t.Fatalf("%v: status = %v, want %v: %v", tt.name, w.Code, tt.status, w.Body)
+		}
+		if got := calls.Load(); got != tt.calls {
+			t.Fatalf("%v: %v handler calls, want %v", tt.name, got, tt.calls)
+		}
+		if got := w.Header().Get(idempotencyReplayHeader) == "true"; got != tt.replayed {
+			t.Errorf("%v: replayed = %v, want %v", tt.name, got, tt.replayed)
+		}
+		switch tt.name {
+		case "first":
+			firstBody = w.Body.String()
+		case "repeated":
+			if w.Body.String() != firstBody {
+				t.Errorf("replayed body %q, want %q", w.Body, firstBody)
+			}
+			if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+				t.Errorf("replayed Content-Type = %q", ct)
+			}
+		}
+	}
+}
+
+func TestIdempotentInProgress(t *testing.T) {
+	s := &Web{pg: testPG(t), auth: &Auth{secret: &Secret{}, tokenSecret: &Secret{}}, idempotencyTTL: time.Hour}
+	gin.SetMode(gin.TestMode)
+	started, release := make(chan struct{}), make(chan struct{})
+	r := gin.New()
+	r.PUT("/slow", s.idempotent, func(c *gin.Context) {
+		close(started)
+		<-release
+		c.Status(http.StatusNoContent)
+	})
+	req := testIdempotentRequest{path: "/slow", key: "k"}
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- req.do(r) }()
+	<-started
+	if w := req.do(r); w.Code != http.StatusConflict {
+		t.Errorf("status of request in progress = %v, want %v", w.Code, http.StatusConflict)
+	}
+	close(release)
+	if w := <-done; w.Code != http.StatusNoContent {
+		t.Fatalf("status = %v, want %v", w.Code, http.StatusNoContent)
+	}
+	if w := req.do(r); w.Code != http.StatusNoContent || w.Header().Get(idempotencyReplayHeader) != "true" {
+		t.Errorf("status = %v, replayed %q after completion, want replayed %v", w.Code, w.Header().Get(idempotencyReplayHeader), http.StatusNoContent)
+	}
+}
@@ -0,0 +1,305 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxImportSize caps uploaded import files.
+const maxImportSize = 64 * 1024 * 1024
+
+// ImportRow is a single resource of an import file.
+type ImportRow struct {
+	// ID is the infohash of the resource
+	ID string `json:"id"`
+	ExpiryRequest
+	StorageClass string            `json:"storage_class,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	Priority     *int              `json:"priority,omitempty"`
+}
+
+// ImportRowError is a validation error of a single row, rows are numbered from 1 without CSV header.
+type ImportRowError struct {
+	Row   int    `json:"row"`
+	ID    string `json:"id,omitempty"`
+	Error string `json:"error"`
+}
+
+// ImportResponse is the bulk operation storing valid rows and errors of rejected rows.
+type ImportResponse struct {
+	Operation *BulkOperation   `json:"bulk_operation,omitempty"`
+	Queued    int              `json:"queued"`
+	Errors    []ImportRowError `json:"errors"`
+}
+
+// params validates the row and returns its bulk item params.
+func (r *ImportRow) params(now time.Time) (*BulkParams, error) {
+	if _, err := requireTorrentID(r.ID); err != nil {
+		return nil, err
+	}
+	if _, _, err := r.expiry(now); err != nil {
+		return nil, err
+	}
+	if _, err := ParseStorageClass(r.StorageClass); err != nil {
+		return nil, err
+	}
+	if err := validateLabels(r.Labels); err != nil {
+		return nil, err
+	}
+	return &BulkParams{
+		ExpiryRequest: r.ExpiryRequest,
+		StorageClass:  r.StorageClass,
+		Labels:        r.Labels,
+		Priority:      r.Priority,
+	}, nil
+}
+
+// readImportFile returns content of import file sent as the body or as file field of a multipart form.
+func readImportFile(c *gin.Context) (io.Reader, error) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportSize)
+	if !strings.HasPrefix(c.ContentType(), "multipart/form-data") {
+		return c.Request.Body, nil
+	}
+	fh, err := c.FormFile("file")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse import: file is required")
+	}
+	f, err := fh.Open()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse import")
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse import")
+	}
+	return bytes.NewReader(data), nil
+}
+
+// parseImport reads rows of JSON array or CSV content. Format is json or csv, empty format is detected:
+// content starting with "[" is JSON. Rows which can't be decoded are reported as row errors.
+func parseImport(r io.Reader, format string) ([]ImportRow, []ImportRowError, error) {
+	br := bufio.NewReader(r)
+	if format == "" {
+		format = "csv"
+		head, _ := br.Peek(512)
+		if bytes.HasPrefix(bytes.TrimLeft(head, " \t\r\n"), []byte("[")) {
+			format = "json"
+		}
+	}
+	switch format {
+	case "json":
+		return parseImportJSON(br)
+	case "csv":
+		return parseImportCSV(br)
+	}
+	return nil, nil, errors.Errorf("failed to parse import format %v", format)
+}
+
+func parseImportJSON(r io.Reader) ([]ImportRow, []ImportRowError, error) {
+	var raw []json.RawMessage
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to parse import")
+	}
+	rows := make([]ImportRow, 0, len(raw))
+	var rerrs []ImportRowError
+	for i, m := range raw {
+		var row ImportRow
+		if err := json.Unmarshal(m, &row); err != nil {
+			rerrs = append(rerrs, ImportRowError{Row: i + 1, Error: err.Error()})
+			row = ImportRow{}
+		}
+		rows = append(rows, row)
+	}
+	return rows, rerrs, nil
+}
+
+// parseImportCSV reads CSV with a header naming columns: id (or infohash), labels (k=v pairs separated
+// by commas), priority, ttl, expires_at (RFC 3339) and storage_class. Only the id column is required.
+func parseImportCSV(r io.Reader) ([]ImportRow, []ImportRowError, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to parse import header")
+	}
+	cols := map[string]int{}
+	for i, h := range header {
+		h = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))
+		if h == "infohash" {
+			h = "id"
+		}
+		switch h {
+		case "id", "labels", "priority", "ttl", "expires_at", "storage_class":
+			cols[h] = i
+		default:
+			return nil, nil, errors.Errorf("failed to parse import header: unknown column %q", h)
+		}
+	}
+	if _, ok := cols["id"]; !ok {
+		return nil, nil, errors.New("failed to parse import header: id column is required")
+	}
+	var rows []ImportRow
+	var rerrs []ImportRowError
+	for n := 1; ; n++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return rows, rerrs, nil
+		}
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed to parse import")
+		}
+		field := func(name string) string {
+			i, ok := cols[name]
+			if !ok || i >= len(rec) {
+				return ""
+			}
+			return strings.TrimSpace(rec[i])
+		}
+		row, err := parseImportRecord(field)
+		if err != nil {
+			rerrs = append(rerrs, ImportRowError{Row: n, ID: row.ID, Error: err.Error()})
+		}
+		rows = append(rows, row)
+	}
+}
+
+func parseImportRecord(field func(string) string) (ImportRow, error) {
+	row := ImportRow{
+		ID:            field("id"),
+		ExpiryRequest: ExpiryRequest{TTL: field("ttl")},
+		StorageClass:  field("storage_class"),
+	}
+	if v := field("priority"); v != "" {
+		p, err := strconv.Atoi(v)
+		if err != nil {
+			return row, errors.Errorf("failed to parse priority %q", v)
+		}
+		row.Priority = &p
+	}
+	if v := field("expires_at"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return row, errors.Errorf("failed to parse expires_at %q", v)
+		}
+		row.ExpiresAt = &t
+	}
+	if v := field("labels"); v != "" {
+		row.Labels = map[string]string{}
+		for _, term := range strings.Split(v, ",") {
+			k, lv, ok := strings.Cut(term, "=")
+			if !ok {
+				return row, errors.Errorf("failed to parse label %q: key=value is required", term)
+			}
+			row.Labels[strings.TrimSpace(k)] = strings.TrimSpace(lv)
+		}
+	}
+	return row, nil
+}
+
+// POST /import
+// postImport godoc
+// @Summary      Import list of infohashes
+// @Description  Queues storing of every resource listed in a JSON array of ImportRow or a CSV file with header
+// @Description  (columns id, labels as "k=v,k2=v2", priority, ttl, expires_at and storage_class; only id is required).
+// @Description  The file is the body or file field of a multipart form, format is detected unless set by ?format.
+// @Description  Rows are validated up front: invalid and duplicate rows are reported by row number, valid rows are
+// @Description  queued by a bulk operation of store action, tracked by GET /resources/bulk/{id}. Higher priority
+// @Description  resources are picked up for storing first.
+// @Tags         resource
+// @Accept       json
+// @Accept       text/csv
+// @Accept       multipart/form-data
+// @Param        format   query     string  false  "json or csv"
+// @Success      202  {object}  ImportResponse
+// @Failure      400  {object}  ImportResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /import [post]
+func (s *Web) postImport(c *gin.Context) {
+	db := s.pg.Get()
+	if db == nil {
+		_ = c.Error(errors.New("DB not configured"))
+		return
+	}
+	r, err := readImportFile(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	rows, rerrs, err := parseImport(r, c.Query("format"))
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if len(rows) > maxBulkIDs {
+		_ = c.Error(errors.Errorf("failed to parse import: at most %v rows allowed", maxBulkIDs))
+		return
+	}
+	failed := map[int]bool{}
+	for _, e := range rerrs {
+		failed[e.Row] = true
+	}
+	now := time.Now()
+	items := []BulkOperationItem{}
+	seen := map[string]int{}
+	for i := range rows {
+		row := &rows[i]
+		n := i + 1
+		if failed[n] {
+			continue
+		}
+		row.ID = NormalizeInfohash(row.ID)
+		params, err := row.params(now)
+		if err == nil && seen[row.ID] > 0 {
+			err = errors.Errorf("duplicate of row %v", seen[row.ID])
+		}
+		if err != nil {
+			rerrs = append(rerrs, ImportRowError{Row: n, ID: row.ID, Error: err.Error()})
+			continue
+		}
+		seen[row.ID] = n
+		items = append(items, BulkOperationItem{ResourceID: row.ID, Params: params})
+	}
+	sort.Slice(rerrs, func(i, j int) bool { return rerrs[i].Row < rerrs[j].Row })
+	resp := &ImportResponse{Errors: rerrs}
+	if resp.Errors == nil {
+		resp.Errors = []ImportRowError{}
+	}
+	if len(items) == 0 {
+		if len(resp.Errors) == 0 {
+			_ = c.Error(errors.New("failed to parse import: no rows provided"))
+			return
+		}
+		c.JSON(http.StatusBadRequest, resp)
+		return
+	}
+	op := &BulkOperation{Action: BulkActionStore}
+	err = db.RunInTransaction(c.Request.Context(), func(tx *pg.Tx) error {
+		return BulkOperationCreateItems(c.Request.Context(), tx, op, items)
+	})
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	s.abuse.Record(AbuseKindRegister, c.ClientIP(), int64(len(items)))
+	log.WithFields(log.Fields{"bulk_id": op.BulkID, "rows": len(items), "rejected": len(resp.Errors)}).Info("import queued")
+	resp.Operation = op
+	resp.Queued = len(items)
+	c.JSON(http.StatusAccepted, resp)
+}
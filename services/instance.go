@@ -0,0 +1,206 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+const (
+	instanceHeartbeatIntervalFlag = "instance-heartbeat-interval"
+)
+
+const (
+	// instancePruneAfter is how long instances without heartbeat are kept listed
+	instancePruneAfter = time.Hour
+	// instanceMissedHeartbeats after which the instance is considered dead and its jobs are taken over
+	instanceMissedHeartbeats = 3
+)
+
+// RegisterInstanceFlags registers CLI flags for instance registration.
+func RegisterInstanceFlags(f []cli.Flag) []cli.Flag {
+	return append(f,
+		cli.DurationFlag{
+			Name:   instanceHeartbeatIntervalFlag,
+			Usage:  "how often the instance records its heartbeat, jobs of instances missing 3 heartbeats are taken over by other instances",
+			Value:  15 * time.Second,
+			EnvVar: "INSTANCE_HEARTBEAT_INTERVAL",
+		},
+	)
+}
+
+// instanceID returns configured instance id, falling back to hostname with a random suffix,
+// so restarted or co-located processes never share leases.
+func instanceID(c *cli.Context) string {
+	if id := c.String(workerIDFlag); id != "" {
+		return id
+	}
+	if h, err := os.Hostname(); err == nil && h != "" {
+		return h + "-" + uuid.NewString()
+	}
+	return uuid.NewString()
+}
+
+// InstanceHeartbeat identifies this vault process and periodically records it in DB,
+// so jobs and operation logs can be attributed to running instances.
+type InstanceHeartbeat struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	pg       *PG
+	inst     *Instance
+	interval time.Duration
+}
+
+func NewInstanceHeartbeat(c *cli.Context, pg *PG) *InstanceHeartbeat {
+	hostname, _ := os.Hostname()
+	ctx, cancel := context.WithCancel(context.Background())
+	return &InstanceHeartbeat{
+		ctx:    ctx,
+		cancel: cancel,
+		pg:     pg,
+		inst: &Instance{
+			InstanceID: instanceID(c),
+			Hostname:   hostname,
+			Workers:    c.Int(workerCountFlag),
+		},
+		interval: c.Duration(instanceHeartbeatIntervalFlag),
+	}
+}
+
+// ID returns id of this instance.
+func (s *InstanceHeartbeat) ID() string {
+	return s.inst.InstanceID
+}
+
+// deadAfter returns the period without heartbeat after which an instance is considered dead.
+func (s *InstanceHeartbeat) deadAfter() time.Duration {
+	return instanceMissedHeartbeats * s.interval
+}
+
+func (s *InstanceHeartbeat) Serve() error {
+	db := s.pg.Get()
+	if db == nil {
+		return errors.New("DB not configured")
+	}
+	log.WithField("instance_id", s.ID()).Info("instance registered")
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		if err := InstanceTouch(s.ctx, db, s.inst); err != nil {
+			log.WithError(err).Warn("failed to record instance heartbeat")
+		}
+		if err := InstancePrune(s.ctx, db, instancePruneAfter); err != nil {
+			log.WithError(err).Warn("failed to prune instances")
+		}
+		select {
+		case <-s.ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *InstanceHeartbeat) Close() {
+	s.cancel()
+	db := s.pg.Get()
+	if db == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := InstanceRemove(ctx, db, s.ID()); err != nil {
+		log.WithError(err).Warn("failed to unregister instance")
+	}
+}
+
+// InstanceJob is a resource currently leased by an instance.
+type InstanceJob struct {
+	ResourceID     string    `json:"resource_id"`
+	Status         Status    `json:"status"`
+	TotalSize      int64     `json:"total_size"`
+	StoredSize     int64     `json:"stored_size"`
+	LeaseExpiresAt time.Time `json:"lease_expires_at"`
+	// ProgressAt is the last moment the job changed status or stored size
+	ProgressAt *time.Time `json:"progress_at,omitempty"`
+}
+
+// InstanceStatus is a registered instance with its active jobs. Lease owners which are not registered
+// (e.g. instances which just crashed and were pruned) are listed with id only.
+type InstanceStatus struct {
+	Instance
+	// Alive is false if the instance missed 3 heartbeats
+	Alive bool          `json:"alive"`
+	Jobs  []InstanceJob `json:"jobs"`
+}
+
+// InstancesResponse lists vault instances.
+type InstancesResponse struct {
+	Instances []InstanceStatus `json:"instances"`
+}
+
+// GET /admin/instances
+// getInstances godoc
+// @Summary      List vault instances
+// @Description  Lists running vault instances with their heartbeat and resources they currently hold leases for
+// @Tags         admin
+// @Success      200  {object}  InstancesResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      403  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /admin/instances [get]
+func (s *Web) getInstances(c *gin.Context) {
+	db := s.pg.Get()
+	if db == nil {
+		_ = c.Error(errors.New("DB not configured"))
+		return
+	}
+	ctx := c.Request.Context()
+	instances, err := InstanceList(ctx, db)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	leased, err := ResourceListLeased(ctx, db)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	now := time.Now()
+	res := &InstancesResponse{Instances: []InstanceStatus{}}
+	idx := map[string]int{}
+	for _, i := range instances {
+		idx[i.InstanceID] = len(res.Instances)
+		res.Instances = append(res.Instances, InstanceStatus{
+			Instance: i,
+			Alive:    now.Sub(i.HeartbeatAt) < instanceMissedHeartbeats*s.heartbeatInterval,
+			Jobs:     []InstanceJob{},
+		})
+	}
+	for _, r := range leased {
+		n, ok := idx[*r.LeaseOwner]
+		if !ok {
+			n = len(res.Instances)
+			idx[*r.LeaseOwner] = n
+			res.Instances = append(res.Instances, InstanceStatus{
+				Instance: Instance{InstanceID: *r.LeaseOwner},
+				Jobs:     []InstanceJob{},
+			})
+		}
+		res.Instances[n].Jobs = append(res.Instances[n].Jobs, InstanceJob{
+			ResourceID:     r.ID,
+			Status:         r.Status,
+			TotalSize:      r.TotalSize,
+			StoredSize:     r.StoredSize,
+			LeaseExpiresAt: *r.LeaseExpiresAt,
+			ProgressAt:     r.ProgressAt,
+		})
+	}
+	c.JSON(http.StatusOK, res)
+}
@@ -0,0 +1,136 @@
+package services
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+const (
+	storeFileConcurrencyFlag = "store-file-concurrency"
+	maxStoringResourcesFlag  = "max-storing-resources"
+	maxInflightBytesFlag     = "max-inflight-bytes"
+)
+
+// RegisterJobSchedulerFlags registers CLI flags for worker job concurrency limits.
+func RegisterJobSchedulerFlags(f []cli.Flag) []cli.Flag {
+	return append(f,
+		cli.IntFlag{
+			Name:   storeFileConcurrencyFlag,
+			Usage:  "max number of files of a single resource stored concurrently",
+			Value:  1,
+			EnvVar: "STORE_FILE_CONCURRENCY",
+		},
+		cli.IntFlag{
+			Name:   maxStoringResourcesFlag,
+			Usage:  "max number of resources stored concurrently by this instance, other queued resources wait (0 limits by number of workers only)",
+			EnvVar: "MAX_STORING_RESOURCES",
+		},
+		cli.Int64Flag{
+			Name:   maxInflightBytesFlag,
+			Usage:  "max total size in bytes of files being stored concurrently by this instance, a larger file is stored alone (0 disables)",
+			EnvVar: "MAX_INFLIGHT_BYTES",
+		},
+	)
+}
+
+// JobScheduler sits between resource polling and the jobs channel: store jobs are only dispatched
+// while there is a free storing slot, and files of dispatched jobs wait for room in the in-flight
+// bytes budget, so a single massive torrent can't occupy every worker.
+type JobScheduler struct {
+	fileConcurrency int
+	maxStoring      int
+	maxBytes        int64
+	mux             sync.Mutex
+	storing         int
+	bytes           int64
+	// freed is closed and replaced whenever bytes are released
+	freed chan struct{}
+}
+
+func NewJobScheduler(c *cli.Context) (*JobScheduler, error) {
+	s := &JobScheduler{
+		fileConcurrency: c.Int(storeFileConcurrencyFlag),
+		maxStoring:      c.Int(maxStoringResourcesFlag),
+		maxBytes:        c.Int64(maxInflightBytesFlag),
+		freed:           make(chan struct{}),
+	}
+	if s.fileConcurrency < 1 {
+		return nil, errors.New("store file concurrency must be positive")
+	}
+	if s.maxStoring < 0 || s.maxBytes < 0 {
+		return nil, errors.New("job concurrency limits must not be negative")
+	}
+	if s.fileConcurrency > 1 || s.maxStoring > 0 || s.maxBytes > 0 {
+		log.WithFields(log.Fields{
+			"files_per_resource": s.fileConcurrency,
+			"storing_resources":  s.maxStoring,
+			"inflight_bytes":     s.maxBytes,
+		}).Info("job concurrency limits enabled")
+	}
+	return s, nil
+}
+
+// admit takes a storing slot for a job of status, false if the job has to wait. Only store
+// jobs are limited, deletions always pass.
+func (s *JobScheduler) admit(status Status) bool {
+	if status != StatusStoring {
+		return true
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.maxStoring > 0 && s.storing >= s.maxStoring {
+		promSchedulerDeferredJobs.Inc()
+		return false
+	}
+	s.storing++
+	promSchedulerStoringResources.Set(float64(s.storing))
+	return true
+}
+
+// done frees the slot taken by admit.
+func (s *JobScheduler) done(status Status) {
+	if status != StatusStoring {
+		return
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.storing--
+	promSchedulerStoringResources.Set(float64(s.storing))
+}
+
+// acquireBytes waits until size fits in-flight bytes budget and returns func releasing it.
+// A file larger than the budget is admitted once nothing else is in flight.
+func (s *JobScheduler) acquireBytes(ctx context.Context, size int64) (func(), error) {
+	if s.maxBytes <= 0 {
+		return func() {}, nil
+	}
+	for {
+		s.mux.Lock()
+		if s.bytes == 0 || s.bytes+size <= s.maxBytes {
+			s.bytes += size
+			promSchedulerInflightBytes.Set(float64(s.bytes))
+			s.mux.Unlock()
+			return func() { s.releaseBytes(size) }, nil
+		}
+		freed := s.freed
+		s.mux.Unlock()
+		select {
+		case <-freed:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (s *JobScheduler) releaseBytes(size int64) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.bytes -= size
+	promSchedulerInflightBytes.Set(float64(s.bytes))
+	close(s.freed)
+	s.freed = make(chan struct{})
+}
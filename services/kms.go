@@ -0,0 +1,65 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+)
+
+const (
+	encryptionKeyKMSCiphertextFlag = "encryption-key-kms-ciphertext"
+	kmsRegionFlag                  = "kms-region"
+	awsRegionFlag                  = "aws-region"
+)
+
+// RegisterKMSFlags registers CLI flags for decrypting the encryption key with AWS KMS.
+func RegisterKMSFlags(f []cli.Flag) []cli.Flag {
+	return append(f,
+		cli.StringFlag{
+			Name:   encryptionKeyKMSCiphertextFlag,
+			Usage:  "base64 KMS ciphertext of the client-side encryption key, decrypted with AWS KMS at startup",
+			EnvVar: "ENCRYPTION_KEY_KMS_CIPHERTEXT",
+		},
+		cli.StringFlag{
+			Name:   kmsRegionFlag,
+			Usage:  "AWS KMS region (defaults to aws-region)",
+			EnvVar: "KMS_REGION",
+		},
+	)
+}
+
+// decryptKMSKey decrypts the encryption key ciphertext with AWS KMS. Nil is returned if it is not configured.
+func decryptKMSKey(ctx context.Context, c *cli.Context, secrets *Secrets) ([]byte, error) {
+	ct := strings.TrimSpace(c.String(encryptionKeyKMSCiphertextFlag))
+	if ct == "" {
+		return nil, nil
+	}
+	blob, err := base64.StdEncoding.DecodeString(ct)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode kms ciphertext")
+	}
+	region := c.String(kmsRegionFlag)
+	if region == "" {
+		region = c.String(awsRegionFlag)
+	}
+	cfg := aws.NewConfig().WithRegion(region)
+	if id := secrets.AWSAccessKeyID.String(); id != "" {
+		cfg = cfg.WithCredentials(credentials.NewStaticCredentials(id, secrets.AWSSecretAccessKey.String(), ""))
+	}
+	sess, err := session.NewSession(cfg)
+	if err != nil {
+		return nil, err
+	}
+	out, err := kms.New(sess).DecryptWithContext(ctx, &kms.DecryptInput{CiphertextBlob: blob})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decrypt encryption key with kms")
+	}
+	return out.Plaintext, nil
+}
@@ -0,0 +1,135 @@
+package services
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/go-pg/pg/v10/orm"
+	"github.com/pkg/errors"
+)
+
+const (
+	maxLabels      = 64
+	maxLabelLength = 256
+	maxNameLength  = 1024
+	// maxAnnotationsSize caps JSON encoded annotations
+	maxAnnotationsSize = 64 * 1024
+)
+
+// validateLabels checks labels can be matched by selectors: keys are non-empty and
+// neither keys nor values contain selector separators.
+func validateLabels(labels map[string]string) error {
+	if len(labels) > maxLabels {
+		return errors.Errorf("failed to parse labels: more than %v labels", maxLabels)
+	}
+	for k, v := range labels {
+		if k == "" || len(k) > maxLabelLength || len(v) > maxLabelLength {
+			return errors.Errorf("failed to parse label %q: key must be 1-%v chars, value at most %v chars", k, maxLabelLength, maxLabelLength)
+		}
+		if strings.ContainsAny(k, ",=") || strings.ContainsAny(v, ",") {
+			return errors.Errorf("failed to parse label %q: key must not contain \",\" or \"=\", value must not contain \",\"", k)
+		}
+	}
+	return nil
+}
+
+// validateName checks length of resource name.
+func validateName(name *string) error {
+	if name != nil && len(*name) > maxNameLength {
+		return errors.Errorf("failed to parse name: at most %v chars allowed", maxNameLength)
+	}
+	return nil
+}
+
+// validateAnnotations checks size of encoded annotations.
+func validateAnnotations(annotations map[string]any) error {
+	b, err := json.Marshal(annotations)
+	if err != nil {
+		return errors.Wrap(err, "failed to parse annotations")
+	}
+	if len(b) > maxAnnotationsSize {
+		return errors.Errorf("failed to parse annotations: at most %v bytes of JSON allowed", maxAnnotationsSize)
+	}
+	return nil
+}
+
+// LabelSelector matches resources by labels. It is parsed from comma-separated terms:
+// key=value requires the label to have the value, a bare key requires the label to be set.
+type LabelSelector struct {
+	Match map[string]string
+	Has   []string
+}
+
+// ParseLabelSelector parses selector like "tenant=x,tier=cold". Empty selector is rejected,
+// so a typo can't select every resource.
+func ParseLabelSelector(s string) (*LabelSelector, error) {
+	sel := &LabelSelector{Match: map[string]string{}}
+	for _, term := range strings.Split(s, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(term, "=")
+		k, v = strings.TrimSpace(k), strings.TrimSpace(v)
+		if k == "" {
+			return nil, errors.Errorf("failed to parse label selector %q: empty key", s)
+		}
+		if ok {
+			sel.Match[k] = v
+		} else {
+			sel.Has = append(sel.Has, k)
+		}
+	}
+	if len(sel.Match) == 0 && len(sel.Has) == 0 {
+		return nil, errors.New("failed to parse label selector: at least one term is required")
+	}
+	return sel, nil
+}
+
+// ParseLabelQuery parses label query parameters: every value is a selector, terms may also be written
+// as key:value (e.g. ?label=user:123&label=tier=cold). Nil is returned if there are no values.
+func ParseLabelQuery(values []string) (*LabelSelector, error) {
+	var terms []string
+	for _, v := range values {
+		for _, term := range strings.Split(v, ",") {
+			if !strings.Contains(term, "=") {
+				term = strings.Replace(term, ":", "=", 1)
+			}
+			terms = append(terms, term)
+		}
+	}
+	if len(terms) == 0 {
+		return nil, nil
+	}
+	return ParseLabelSelector(strings.Join(terms, ","))
+}
+
+// apply restricts query on resource table to resources matching the selector.
+func (s *LabelSelector) apply(q *orm.Query) (*orm.Query, error) {
+	if len(s.Match) > 0 {
+		m, err := json.Marshal(s.Match)
+		if err != nil {
+			return nil, err
+		}
+		q = q.Where("resource.labels @> ?::jsonb", string(m))
+	}
+	for _, k := range s.Has {
+		q = q.Where("jsonb_exists(resource.labels, ?)", k)
+	}
+	return q, nil
+}
+
+// matches reports whether labels satisfy the selector.
+func (s *LabelSelector) matches(labels map[string]string) bool {
+	for k, v := range s.Match {
+		if lv, ok := labels[k]; !ok || lv != v {
+			return false
+		}
+	}
+	for _, k := range s.Has {
+		if _, ok := labels[k]; !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,50 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+)
+
+// deadLeaseOwnerCondition matches resources leased by an instance which missed its heartbeats,
+// expects the dead period in milliseconds as parameter.
+const deadLeaseOwnerCondition = `lease_owner IN (
+	SELECT instance_id FROM instance WHERE heartbeat_at < now() - ? * interval '1 millisecond'
+)`
+
+// keepLease renews lease of the resource while the job runs and cancels the job if the lease was lost
+// (e.g. taken over by another replica after a long stall). Returned function stops renewal and releases the lease.
+func (s *Worker) keepLease(ctx context.Context, cancel context.CancelFunc, db *pg.DB, id string) (stop func()) {
+	l := logger(ctx).WithField("id", id)
+	done := make(chan struct{})
+	go func() {
+		t := time.NewTicker(s.leaseTTL / 3)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				ok, err := ResourceRenewLease(ctx, db, id, s.id, s.leaseTTL)
+				if err != nil {
+					l.WithError(err).Warn("failed to renew lease")
+					continue
+				}
+				if !ok {
+					l.Warn("lease lost, job cancelled")
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		if err := ResourceReleaseLease(context.Background(), db, id, s.id); err != nil {
+			l.WithError(err).Warn("failed to release lease")
+		}
+	}
+}
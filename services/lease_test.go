@@ -0,0 +1,112 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// testLease sets lease of the resource to owner until ttl from now.
+func testLease(t *testing.T, p *PG, id string, owner string, ttl time.Duration) {
+	t.Helper()
+	if _, err := p.Get().Model(&Resource{ID: id}).
+		Set("lease_owner = ?", owner).
+		Set("lease_expires_at = now() + ? * interval '1 millisecond'", ttl.Milliseconds()).
+		WherePK().
+		Update(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testGetResource(t *testing.T, p *PG, id string) *Resource {
+	t.Helper()
+	res := &Resource{ID: id}
+	if err := p.Get().Model(res).WherePK().Select(); err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestResourceLease(t *testing.T) {
+	p := testPG(t)
+	ctx := context.Background()
+	id := testResourceID(1)
+	if _, err := ResourceQueueForStoring(ctx, p.Get(), id); err != nil {
+		t.Fatal(err)
+	}
+	testLease(t, p, id, "a", time.Minute)
+	before := testGetResource(t, p, id).LeaseExpiresAt
+
+	if ok, err := ResourceRenewLease(ctx, p.Get(), id, "b", time.Hour); err != nil || ok {
+		t.Fatalf("renew by another owner = %v, %v, want false", ok, err)
+	}
+	if ok, err := ResourceRenewLease(ctx, p.Get(), id, "a", time.Hour); err != nil || !ok {
+		t.Fatalf("renew by owner = %v, %v, want true", ok, err)
+	}
+	res := testGetResource(t, p, id)
+	if !res.LeaseExpiresAt.After(*before) {
+		t.Errorf("lease expires at %v, want after %v", res.LeaseExpiresAt, before)
+	}
+
+	if err := ResourceReleaseLease(ctx, p.Get(), id, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if res := testGetResource(t, p, id); res.LeaseOwner == nil || *res.LeaseOwner != "a" {
+		t.Fatalf("lease owner = %v after release by another owner, want a", res.LeaseOwner)
+	}
+	if err := ResourceReleaseLease(ctx, p.Get(), id, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if res := testGetResource(t, p, id); res.LeaseOwner != nil || res.LeaseExpiresAt != nil {
+		t.Fatalf("lease = %v until %v after release, want none", res.LeaseOwner, res.LeaseExpiresAt)
+	}
+	if ok, err := ResourceRenewLease(ctx, p.Get(), id, "a", time.Hour); err != nil || ok {
+		t.Fatalf("renew of released lease = %v, %v, want false", ok, err)
+	}
+}
+
+func TestKeepLease(t *testing.T) {
+	p := testPG(t)
+	id := testResourceID(1)
+	if _, err := ResourceQueueForStoring(context.Background(), p.Get(), id); err != nil {
+		t.Fatal(err)
+	}
+	s := &Worker{id: "a", leaseTTL: 300 * time.Millisecond}
+
+	t.Run("renewed", func(t *testing.T) {
+		testLease(t, p, id, "a", s.leaseTTL)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		stop := s.keepLease(ctx, cancel, p.Get(), id)
+		// lease would have expired twice without renewal
+		time.Sleep(2 * s.leaseTTL)
+		if ctx.Err() != nil {
+			t.Fatal("job of a renewed lease was cancelled")
+		}
+		res := testGetResource(t, p, id)
+		if res.LeaseOwner == nil || *res.LeaseOwner != "a" || !res.LeaseExpiresAt.After(time.Now()) {
+			t.Fatalf("lease = %v until %v, want a in the future", res.LeaseOwner, res.LeaseExpiresAt)
+		}
+		stop()
+		if res := testGetResource(t, p, id); res.LeaseOwner != nil {
+			t.Errorf("lease owner = %v after stop, want none", *res.LeaseOwner)
+		}
+	})
+
+	t.Run("taken over", func(t *testing.T) {
+		testLease(t, p, id, "b", time.Minute)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		stop := s.keepLease(ctx, cancel, p.Get(), id)
+		select {
+		case <-ctx.Done():
+		case <-time.After(5 * time.Second):
+			t.Fatal("job was not cancelled after the lease was lost")
+		}
+		// lease of the new owner is kept
+		stop()
+		if res := testGetResource(t, p, id); res.LeaseOwner == nil || *res.LeaseOwner != "b" {
+			t.Errorf("lease owner = %v, want b", res.LeaseOwner)
+		}
+	})
+}
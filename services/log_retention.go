@@ -0,0 +1,102 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+const (
+	logRetentionFlag          = "log-retention"
+	logRetentionBatchSizeFlag = "log-retention-batch-size"
+	logRetentionIntervalFlag  = "log-retention-interval"
+)
+
+// RegisterLogRetentionFlags registers CLI flags for pruning of operation logs.
+func RegisterLogRetentionFlags(f []cli.Flag) []cli.Flag {
+	return append(f,
+		cli.DurationFlag{
+			Name:   logRetentionFlag,
+			Usage:  "prune operation logs finished earlier than this period ago, they are rolled up into daily stats first (0 keeps logs forever)",
+			EnvVar: "LOG_RETENTION",
+		},
+		cli.IntFlag{
+			Name:   logRetentionBatchSizeFlag,
+			Usage:  "number of operation logs pruned in a single statement",
+			Value:  1000,
+			EnvVar: "LOG_RETENTION_BATCH_SIZE",
+		},
+		cli.DurationFlag{
+			Name:   logRetentionIntervalFlag,
+			Usage:  "how often worker prunes operation logs",
+			Value:  time.Hour,
+			EnvVar: "LOG_RETENTION_INTERVAL",
+		},
+	)
+}
+
+// maxLogPruneBatches caps batches pruned in a single run, the rest is left for the next run.
+const maxLogPruneBatches = 100
+
+// LogRetention prunes finished operation logs.
+type LogRetention struct {
+	retention time.Duration
+	batchSize int
+	interval  time.Duration
+	last      time.Time
+}
+
+func NewLogRetention(c *cli.Context) (*LogRetention, error) {
+	retention := c.Duration(logRetentionFlag)
+	if retention <= 0 {
+		return nil, nil
+	}
+	// success rates of /stats are computed from logs of the last 24h
+	if retention < statsOperationsWindow {
+		return nil, errors.Errorf("log retention must be at least %v", statsOperationsWindow)
+	}
+	if c.Int(logRetentionBatchSizeFlag) <= 0 {
+		return nil, errors.New("log retention batch size must be positive")
+	}
+	return &LogRetention{
+		retention: retention,
+		batchSize: c.Int(logRetentionBatchSizeFlag),
+		interval:  c.Duration(logRetentionIntervalFlag),
+	}, nil
+}
+
+// due reports whether logs should be pruned now.
+func (s *LogRetention) due(now time.Time) bool {
+	if s == nil || now.Sub(s.last) < s.interval {
+		return false
+	}
+	s.last = now
+	return true
+}
+
+// pruneLogs rolls up and deletes operation logs past retention in batches until none is left.
+func (s *Worker) pruneLogs(ctx context.Context, db *pg.DB) error {
+	if !s.logRetention.due(time.Now()) {
+		return nil
+	}
+	total := 0
+	for i := 0; i < maxLogPruneBatches; i++ {
+		n, err := OperationLogPrune(ctx, db, s.logRetention.retention, s.logRetention.batchSize)
+		if err != nil {
+			return err
+		}
+		total += n
+		promOperationLogsPruned.Add(float64(n))
+		if n < s.logRetention.batchSize {
+			break
+		}
+	}
+	if total > 0 {
+		log.WithField("count", total).Info("operation logs pruned")
+	}
+	return nil
+}
@@ -0,0 +1,285 @@
+package services
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	cs "github.com/webtor-io/common-services"
+)
+
+const (
+	cacheBackendFlag = "cache-backend"
+	cacheTTLFlag     = "cache-ttl"
+	cacheSizeFlag    = "cache-size"
+)
+
+// RegisterLookupCacheFlags registers CLI flags for caching of webseed lookups.
+func RegisterLookupCacheFlags(f []cli.Flag) []cli.Flag {
+	return append(f,
+		cli.StringFlag{
+			Name:   cacheBackendFlag,
+			Usage:  "cache of webseed resource and file lookups: none, memory (in-process LRU) or redis (shared by replicas, see redis flags)",
+			Value:  "none",
+			EnvVar: "CACHE_BACKEND",
+		},
+		cli.DurationFlag{
+			Name:   cacheTTLFlag,
+			Usage:  "how long webseed lookups are cached",
+			Value:  time.Minute,
+			EnvVar: "CACHE_TTL",
+		},
+		cli.IntFlag{
+			Name:   cacheSizeFlag,
+			Usage:  "max number of lookups kept by memory cache",
+			Value:  100000,
+			EnvVar: "CACHE_SIZE",
+		},
+	)
+}
+
+// lookupBackend keeps cached values grouped by resource id, so all of them can be dropped at once.
+type lookupBackend interface {
+	get(ctx context.Context, id, field string) ([]byte, bool)
+	set(ctx context.Context, id, field string, v []byte)
+	invalidate(ctx context.Context, id string)
+}
+
+// LookupCache caches webseed lookups of stored (or taken down) resources and their files,
+// saving DB queries of hot streams. Negative lookups are not cached, so newly stored resources
+// are served right away. Nil LookupCache caches nothing.
+type LookupCache struct {
+	backend lookupBackend
+	redis   *cs.RedisClient
+}
+
+// NewLookupCache returns nil if cache backend is none.
+func NewLookupCache(c *cli.Context) (*LookupCache, error) {
+	ttl := c.Duration(cacheTTLFlag)
+	switch b := c.String(cacheBackendFlag); b {
+	case "", "none":
+		return nil, nil
+	case "memory":
+		log.Infof("caching webseed lookups in memory for %v", ttl)
+		return &LookupCache{backend: newMemoryLookupBackend(c.Int(cacheSizeFlag), ttl)}, nil
+	case "redis":
+		log.Infof("caching webseed lookups in redis for %v", ttl)
+		rc := cs.NewRedisClient(c)
+		return &LookupCache{backend: &redisLookupBackend{cl: rc, ttl: ttl}, redis: rc}, nil
+	default:
+		return nil, errors.Errorf("unsupported cache backend %v", b)
+	}
+}
+
+type lookupResource struct {
+	Stored     bool   `json:"stored,omitempty"`
+	Takedown   bool   `json:"takedown,omitempty"`
+	ReasonCode string `json:"reason_code,omitempty"`
+}
+
+type lookupFile struct {
+	Hash    string       `json:"hash"`
+	Size    int64        `json:"size"`
+	Class   ContentClass `json:"class,omitempty"`
+	Chunked bool         `json:"chunked,omitempty"`
+	Bucket  string       `json:"bucket,omitempty"`
+}
+
+const lookupResourceField = "resource"
+
+func (s *LookupCache) getResource(ctx context.Context, id string) (webseedResourceState, bool) {
+	var v lookupResource
+	if !s.get(ctx, id, lookupResourceField, &v) {
+		return webseedResourceState{}, false
+	}
+	return webseedResourceState{stored: v.Stored, takedown: v.Takedown, reasonCode: v.ReasonCode}, true
+}
+
+func (s *LookupCache) setResource(ctx context.Context, id string, st webseedResourceState) {
+	if !st.stored && !st.takedown {
+		return
+	}
+	s.set(ctx, id, lookupResourceField, &lookupResource{Stored: st.stored, Takedown: st.takedown, ReasonCode: st.reasonCode})
+}
+
+func (s *LookupCache) getFile(ctx context.Context, id, path string) (webseedFile, bool) {
+	var v lookupFile
+	if !s.get(ctx, id, "file:"+path, &v) {
+		return webseedFile{}, false
+	}
+	return webseedFile{hash: v.Hash, size: v.Size, class: v.Class, chunked: v.Chunked, bucket: v.Bucket}, true
+}
+
+func (s *LookupCache) setFile(ctx context.Context, id, path string, f webseedFile) {
+	s.set(ctx, id, "file:"+path, &lookupFile{Hash: f.hash, Size: f.size, Class: f.class, Chunked: f.chunked, Bucket: f.bucket})
+}
+
+// Invalidate drops cached lookups of the resource.
+func (s *LookupCache) Invalidate(ctx context.Context, id string) {
+	if s == nil || id == "" {
+		return
+	}
+	s.backend.invalidate(ctx, id)
+}
+
+func (s *LookupCache) get(ctx context.Context, id, field string, v any) bool {
+	if s == nil {
+		return false
+	}
+	data, ok := s.backend.get(ctx, id, field)
+	if ok && json.Unmarshal(data, v) == nil {
+		promLookupCacheRequests.WithLabelValues("hit").Inc()
+		return true
+	}
+	promLookupCacheRequests.WithLabelValues("miss").Inc()
+	return false
+}
+
+func (s *LookupCache) set(ctx context.Context, id, field string, v any) {
+	if s == nil {
+		return
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	s.backend.set(ctx, id, field, data)
+}
+
+func (s *LookupCache) Close() {
+	if s != nil && s.redis != nil {
+		s.redis.Close()
+	}
+}
+
+type memoryLookupEntry struct {
+	id      string
+	field   string
+	value   []byte
+	expires time.Time
+}
+
+// memoryLookupBackend is an LRU cache with expiration.
+type memoryLookupBackend struct {
+	mux   sync.Mutex
+	size  int
+	ttl   time.Duration
+	ll    *list.List
+	items map[string]map[string]*list.Element
+}
+
+func newMemoryLookupBackend(size int, ttl time.Duration) *memoryLookupBackend {
+	return &memoryLookupBackend{
+		size:  size,
+		ttl:   ttl,
+		ll:    list.New(),
+		items: map[string]map[string]*list.Element{},
+	}
+}
+
+func (s *memoryLookupBackend) get(_ context.Context, id, field string) ([]byte, bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	el, ok := s.items[id][field]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*memoryLookupEntry)
+	if time.Now().After(e.expires) {
+		s.remove(el)
+		return nil, false
+	}
+	s.ll.MoveToFront(el)
+	return e.value, true
+}
+
+func (s *memoryLookupBackend) set(_ context.Context, id, field string, v []byte) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if el, ok := s.items[id][field]; ok {
+		s.remove(el)
+	}
+	for s.ll.Len() >= s.size && s.ll.Len() > 0 {
+		s.remove(s.ll.Back())
+	}
+	if s.items[id] == nil {
+		s.items[id] = map[string]*list.Element{}
+	}
+	s.items[id][field] = s.ll.PushFront(&memoryLookupEntry{id: id, field: field, value: v, expires: time.Now().Add(s.ttl)})
+}
+
+func (s *memoryLookupBackend) invalidate(_ context.Context, id string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	for _, el := range s.items[id] {
+		s.remove(el)
+	}
+}
+
+func (s *memoryLookupBackend) remove(el *list.Element) {
+	e := el.Value.(*memoryLookupEntry)
+	s.ll.Remove(el)
+	delete(s.items[e.id], e.field)
+	if len(s.items[e.id]) == 0 {
+		delete(s.items, e.id)
+	}
+}
+
+// redisLookupBackend keeps lookups of a resource in a single hash. Values carry their own
+// expiration, since hash fields can't expire separately.
+type redisLookupBackend struct {
+	cl  *cs.RedisClient
+	ttl time.Duration
+}
+
+type redisLookupValue struct {
+	Value   json.RawMessage `json:"v"`
+	Expires int64           `json:"e"`
+}
+
+func (s *redisLookupBackend) key(id string) string {
+	return "vault:lookup:" + id
+}
+
+func (s *redisLookupBackend) get(ctx context.Context, id, field string) ([]byte, bool) {
+	data, err := s.cl.Get().HGet(ctx, s.key(id), field).Bytes()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			logger(ctx).WithError(err).Warn("failed to get cached lookup")
+		}
+		return nil, false
+	}
+	var v redisLookupValue
+	if err := json.Unmarshal(data, &v); err != nil || time.Now().Unix() >= v.Expires {
+		return nil, false
+	}
+	return v.Value, true
+}
+
+func (s *redisLookupBackend) set(ctx context.Context, id, field string, v []byte) {
+	data, err := json.Marshal(&redisLookupValue{Value: v, Expires: time.Now().Add(s.ttl).Unix()})
+	if err != nil {
+		return
+	}
+	key := s.key(id)
+	_, err = s.cl.Get().TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.HSet(ctx, key, field, data)
+		p.Expire(ctx, key, s.ttl)
+		return nil
+	})
+	if err != nil {
+		logger(ctx).WithError(err).Warn("failed to cache lookup")
+	}
+}
+
+func (s *redisLookupBackend) invalidate(ctx context.Context, id string) {
+	if err := s.cl.Get().Del(ctx, s.key(id)).Err(); err != nil {
+		logger(ctx).WithError(err).WithField("id", id).Warn("failed to invalidate cached lookups")
+	}
+}
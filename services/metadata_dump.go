@@ -0,0 +1,283 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	awss3 "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	cs "github.com/webtor-io/common-services"
+)
+
+// DumpTables are the tables of metadata dump, in the order they are dumped and loaded,
+// so rows referenced by foreign keys are loaded first.
+var DumpTables = []string{"resource", "file", "resource_file", "log"}
+
+// dumpTableFilters restrict dumped rows of each table to resources passed as ?0.
+var dumpTableFilters = map[string]string{
+	"resource":      "resource_id IN (?0)",
+	"file":          "hash IN (SELECT file_hash FROM resource_file WHERE resource_id IN (?0))",
+	"resource_file": "resource_id IN (?0)",
+	"log":           "resource_id IN (?0)",
+}
+
+// DumpRecord is a single line of metadata dump.
+type DumpRecord struct {
+	Table string          `json:"table"`
+	Row   json.RawMessage `json:"row"`
+}
+
+// DumpCounts is number of rows per table dumped or loaded.
+type DumpCounts map[string]int
+
+// ExportMetadata writes rows of DumpTables to w as newline-delimited DumpRecord, limited to rows of
+// resources ids unless ids is nil. All tables are read in a single repeatable read transaction,
+// so the dump is a point-in-time snapshot.
+func ExportMetadata(ctx context.Context, db *pg.DB, w io.Writer, ids []string) (DumpCounts, error) {
+	counts := DumpCounts{}
+	err := db.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		if _, err := tx.ExecContext(ctx, "SET TRANSACTION ISOLATION LEVEL REPEATABLE READ, READ ONLY"); err != nil {
+			return err
+		}
+		for _, t := range DumpTables {
+			where := "TRUE"
+			if ids != nil {
+				where = dumpTableFilters[t]
+			}
+			n, err := exportTable(tx, t, where, ids, w)
+			if err != nil {
+				return errors.Wrapf(err, "failed to export %v", t)
+			}
+			counts[t] = n
+			log.WithFields(log.Fields{"table": t, "rows": n}).Info("table exported")
+		}
+		return nil
+	})
+	return counts, err
+}
+
+// exportTable streams rows of table as JSON through COPY, csv format keeps JSON intact.
+func exportTable(tx *pg.Tx, table, where string, ids []string, w io.Writer) (int, error) {
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		_, err := tx.CopyTo(pw, "COPY (SELECT row_to_json(t) FROM ?1 t WHERE "+where+") TO STDOUT WITH (FORMAT csv)", pg.In(ids), pg.Ident(table))
+		_ = pw.CloseWithError(err)
+		done <- err
+	}()
+	n, err := writeDumpRecords(csv.NewReader(pr), table, w)
+	if err != nil {
+		_ = pr.CloseWithError(err)
+		<-done
+		return n, err
+	}
+	return n, <-done
+}
+
+func writeDumpRecords(r *csv.Reader, table string, w io.Writer) (int, error) {
+	r.FieldsPerRecord = 1
+	prefix := []byte(`{"table":"` + table + `","row":`)
+	n := 0
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		line := append(append(append([]byte{}, prefix...), rec[0]...), '}', '\n')
+		if _, err = w.Write(line); err != nil {
+			return n, err
+		}
+		n++
+	}
+}
+
+// ImportMetadata loads newline-delimited DumpRecord from r in a single transaction. Rows are inserted in
+// batches of batchSize, rows with already existing primary key are skipped, so import can be repeated.
+func ImportMetadata(ctx context.Context, db *pg.DB, r io.Reader, batchSize int) (DumpCounts, error) {
+	known := map[string]bool{}
+	for _, t := range DumpTables {
+		known[t] = true
+	}
+	counts := DumpCounts{}
+	err := db.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		var (
+			table string
+			batch []json.RawMessage
+		)
+		flush := func() error {
+			if len(batch) == 0 {
+				return nil
+			}
+			rows, err := json.Marshal(batch)
+			if err != nil {
+				return err
+			}
+			res, err := tx.ExecContext(ctx, `
+				INSERT INTO ?0 SELECT * FROM json_populate_recordset(NULL::?0, ?1)
+				ON CONFLICT DO NOTHING`, pg.Ident(table), string(rows))
+			if err != nil {
+				return errors.Wrapf(err, "failed to import %v", table)
+			}
+			counts[table] += res.RowsAffected()
+			batch = batch[:0]
+			return nil
+		}
+		sc := bufio.NewScanner(r)
+		// rows with long error texts or paths may exceed default token size
+		sc.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+		line := 0
+		for sc.Scan() {
+			line++
+			if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+				continue
+			}
+			var rec DumpRecord
+			if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+				return errors.Wrapf(err, "failed to parse dump line %v", line)
+			}
+			if !known[rec.Table] {
+				return errors.Errorf("failed to parse dump line %v: unknown table %v", line, rec.Table)
+			}
+			if rec.Table != table || len(batch) >= batchSize {
+				if err := flush(); err != nil {
+					return err
+				}
+				table = rec.Table
+			}
+			batch = append(batch, rec.Row)
+		}
+		if err := sc.Err(); err != nil {
+			return errors.Wrap(err, "failed to read dump")
+		}
+		return flush()
+	})
+	return counts, err
+}
+
+// parseS3Location splits s3://bucket/key location, ok is false for other locations.
+func parseS3Location(loc string) (bucket, key string, ok bool, err error) {
+	rest, ok := strings.CutPrefix(loc, "s3://")
+	if !ok {
+		return "", "", false, nil
+	}
+	bucket, key, _ = strings.Cut(rest, "/")
+	if bucket == "" || key == "" {
+		return "", "", true, errors.Errorf("failed to parse s3 location %v", loc)
+	}
+	return bucket, key, true, nil
+}
+
+type dumpWriter struct {
+	io.Writer
+	closers []func() error
+}
+
+func (s *dumpWriter) Close() error {
+	var err error
+	for _, c := range s.closers {
+		if cerr := c(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// CreateDump opens metadata dump for writing at loc: "-" for stdout, s3://bucket/key
+// or a local file path. Content is gzipped if gz is set or loc ends with .gz.
+func CreateDump(ctx context.Context, cl *cs.S3Client, loc string, gz bool) (io.WriteCloser, error) {
+	gz = gz || strings.HasSuffix(loc, ".gz")
+	w := &dumpWriter{}
+	bucket, key, isS3, err := parseS3Location(loc)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case loc == "" || loc == "-":
+		w.Writer = os.Stdout
+	case isS3:
+		pr, pw := io.Pipe()
+		done := make(chan error, 1)
+		go func() {
+			_, err := s3manager.NewUploaderWithClient(cl.Get()).UploadWithContext(ctx, &s3manager.UploadInput{
+				Bucket: aws.String(bucket),
+				Key:    aws.String(key),
+				Body:   pr,
+			})
+			_ = pr.CloseWithError(err)
+			done <- err
+		}()
+		w.Writer = pw
+		w.closers = append(w.closers, func() error {
+			_ = pw.Close()
+			return errors.Wrapf(<-done, "failed to upload %v", loc)
+		})
+	default:
+		f, err := os.Create(loc)
+		if err != nil {
+			return nil, err
+		}
+		w.Writer = f
+		w.closers = append(w.closers, f.Close)
+	}
+	bw := bufio.NewWriterSize(w.Writer, 1024*1024)
+	w.closers = append([]func() error{bw.Flush}, w.closers...)
+	w.Writer = bw
+	if gz {
+		zw := gzip.NewWriter(bw)
+		w.closers = append([]func() error{zw.Close}, w.closers...)
+		w.Writer = zw
+	}
+	return w, nil
+}
+
+// OpenDump opens metadata dump for reading from loc: "-" for stdin, s3://bucket/key
+// or a local file path. Gzipped content is detected by its magic bytes.
+func OpenDump(ctx context.Context, cl *cs.S3Client, loc string) (io.ReadCloser, error) {
+	bucket, key, isS3, err := parseS3Location(loc)
+	if err != nil {
+		return nil, err
+	}
+	var rc io.ReadCloser
+	switch {
+	case loc == "" || loc == "-":
+		rc = io.NopCloser(os.Stdin)
+	case isS3:
+		out, err := cl.Get().GetObjectWithContext(ctx, &awss3.GetObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to get %v", loc)
+		}
+		rc = out.Body
+	default:
+		if rc, err = os.Open(loc); err != nil {
+			return nil, err
+		}
+	}
+	br := bufio.NewReaderSize(rc, 1024*1024)
+	magic, _ := br.Peek(2)
+	if !bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		return &readCloser{Reader: br, Closer: rc}, nil
+	}
+	zr, err := gzip.NewReader(br)
+	if err != nil {
+		_ = rc.Close()
+		return nil, errors.Wrap(err, "failed to read gzipped dump")
+	}
+	return &readCloser{Reader: zr, Closer: rc}, nil
+}
@@ -0,0 +1,196 @@
+package services
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	promWebseedBytesServed = newCounter(prometheus.CounterOpts{
+		Name: "vault_webseed_bytes_served_total",
+		Help: "Total number of bytes streamed to webseed clients",
+	})
+	promWebseedAbortedTransfers = newCounter(prometheus.CounterOpts{
+		Name: "vault_webseed_aborted_transfers_total",
+		Help: "Total number of webseed transfers aborted by the client",
+	})
+	promWebseedAbortedBytes = newCounter(prometheus.CounterOpts{
+		Name: "vault_webseed_aborted_bytes_total",
+		Help: "Total number of bytes streamed to webseed clients before they aborted the transfer",
+	})
+	promAccessStatsDropped = newCounter(prometheus.CounterOpts{
+		Name: "vault_access_stats_dropped_total",
+		Help: "Total number of resource or file usage entries lost from access stats because the buffer was full or the flush failed",
+	})
+	promWebseedRateLimitedRequests = newCounter(prometheus.CounterOpts{
+		Name: "vault_webseed_rate_limited_requests_total",
+		Help: "Total number of webseed requests rejected by rate limiter",
+	})
+	promWebseedDegradedRequests = newCounter(prometheus.CounterOpts{
+		Name: "vault_webseed_degraded_requests_total",
+		Help: "Total number of webseed requests served from cache while DB was unavailable",
+	})
+	promAbuseAnomalies = newCounterVec(prometheus.CounterOpts{
+		Name: "vault_abuse_anomalies_total",
+		Help: "Total number of clients exceeding abuse thresholds",
+	}, []string{"kind"})
+	promAbuseBlockedRequests = newCounter(prometheus.CounterOpts{
+		Name: "vault_abuse_blocked_requests_total",
+		Help: "Total number of requests rejected because client is temporarily blocked for abuse",
+	})
+	promStoreDownloadBytes = newCounter(prometheus.CounterOpts{
+		Name: "vault_store_download_bytes_total",
+		Help: "Total number of bytes downloaded from torrent proxy while storing",
+	})
+	promStoreDownloadThrottled = newCounter(prometheus.CounterOpts{
+		Name: "vault_store_download_throttled_seconds_total",
+		Help: "Total time store downloads spent waiting for rate limiter",
+	})
+	promStoreDownloadRateLimit = newGaugeVec(prometheus.GaugeOpts{
+		Name: "vault_store_download_rate_limit_bytes",
+		Help: "Configured store download rate limit in bytes per second",
+	}, []string{"scope"})
+	promStoreDeadlineExceeded = newCounter(prometheus.CounterOpts{
+		Name: "vault_store_deadline_exceeded_total",
+		Help: "Total number of stores failed because max store duration of the resource was exceeded",
+	})
+	promStoreTimeouts = newCounterVec(prometheus.CounterOpts{
+		Name: "vault_store_timeouts_total",
+		Help: "Total number of stores failed because storing of the resource or of one of its files timed out, by scope",
+	}, []string{"scope"})
+	promUploadVerifyFailures = newCounterVec(prometheus.CounterOpts{
+		Name: "vault_upload_verify_failures_total",
+		Help: "Total number of uploads whose object did not match the content sent, by failed check",
+	}, []string{"check"})
+	promUploadDedupWaits = newCounterVec(prometheus.CounterOpts{
+		Name: "vault_upload_dedup_waits_total",
+		Help: "Total number of jobs which waited for an upload of the same content by another job, by result (linked or taken_over)",
+	}, []string{"result"})
+	promStoreFileRetries = newCounter(prometheus.CounterOpts{
+		Name: "vault_store_file_retries_total",
+		Help: "Total number of file downloads retried after transient torrent http proxy errors",
+	})
+	promVerifyCorruptedFiles = newCounter(prometheus.CounterOpts{
+		Name: "vault_verify_corrupted_files_total",
+		Help: "Total number of stored files found missing or mismatching during verification",
+	})
+	promJobTakeovers = newCounter(prometheus.CounterOpts{
+		Name: "vault_job_takeovers_total",
+		Help: "Total number of jobs taken over from instances which stopped heartbeating or lost their lease",
+	})
+	promJobTraces = newCounterVec(prometheus.CounterOpts{
+		Name: "vault_job_traces_total",
+		Help: "Total number of worker job traces by tail sampling decision",
+	}, []string{"decision"})
+	promLookupCacheRequests = newCounterVec(prometheus.CounterOpts{
+		Name: "vault_lookup_cache_requests_total",
+		Help: "Total number of webseed lookup cache requests by result",
+	}, []string{"result"})
+	promChunkCacheRequests = newCounterVec(prometheus.CounterOpts{
+		Name: "vault_chunk_cache_requests_total",
+		Help: "Total number of webseed GET requests of cacheable video files by chunk cache result",
+	}, []string{"result"})
+	promChunkCacheBytes = newGauge(prometheus.GaugeOpts{
+		Name: "vault_chunk_cache_bytes",
+		Help: "Total size of cached video chunks",
+	})
+	promSchedulerStoringResources = newGauge(prometheus.GaugeOpts{
+		Name: "vault_scheduler_storing_resources",
+		Help: "Number of resources being stored by this instance",
+	})
+	promSchedulerInflightBytes = newGauge(prometheus.GaugeOpts{
+		Name: "vault_scheduler_inflight_bytes",
+		Help: "Total size of files being stored by this instance counted against in-flight bytes budget",
+	})
+	promSchedulerDeferredJobs = newCounter(prometheus.CounterOpts{
+		Name: "vault_scheduler_deferred_jobs_total",
+		Help: "Total number of store jobs left queued because all storing slots were taken",
+	})
+	promBulkItems = newCounterVec(prometheus.CounterOpts{
+		Name: "vault_bulk_items_total",
+		Help: "Total number of resources processed by bulk operations",
+	}, []string{"action", "result"})
+	promReplicaFiles = newCounterVec(prometheus.CounterOpts{
+		Name: "vault_replica_files_total",
+		Help: "Total number of files copied to the replica bucket by result",
+	}, []string{"result"})
+	promReplicaFailovers = newCounterVec(prometheus.CounterOpts{
+		Name: "vault_replica_failovers_total",
+		Help: "Total number of webseed reads retried on the replica bucket after primary failed, by result",
+	}, []string{"result"})
+	promS3OutboxDeletes = newCounterVec(prometheus.CounterOpts{
+		Name: "vault_s3_outbox_deletes_total",
+		Help: "Total number of S3 deletions executed from the outbox by result: done, dropped or failed",
+	}, []string{"result"})
+	promChunkBytes = newCounterVec(prometheus.CounterOpts{
+		Name: "vault_chunk_bytes_total",
+		Help: "Total number of bytes of files stored in chunking mode by result: uploaded or deduped (chunk already stored)",
+	}, []string{"result"})
+	promCanaryUp = newGauge(prometheus.GaugeOpts{
+		Name: "vault_canary_up",
+		Help: "Whether the last end-to-end check of the canary resource passed (1) or failed (0)",
+	})
+	promCanaryStageUp = newGaugeVec(prometheus.GaugeOpts{
+		Name: "vault_canary_stage_up",
+		Help: "Whether stage of the last canary check passed: rest_api, stored, s3 and webseed",
+	}, []string{"stage"})
+	promCanaryLastSuccess = newGauge(prometheus.GaugeOpts{
+		Name: "vault_canary_last_success_timestamp_seconds",
+		Help: "Unix time of the last passed canary check",
+	})
+	promCircuitBreakerOpens = newCounterVec(prometheus.CounterOpts{
+		Name: "vault_circuit_breaker_opens_total",
+		Help: "Total number of times a circuit opened pausing new store jobs, by circuit: s3 or torrent_proxy",
+	}, []string{"circuit"})
+	promOperationLogsPruned = newCounter(prometheus.CounterOpts{
+		Name: "vault_operation_logs_pruned_total",
+		Help: "Total number of operation logs rolled up into daily stats and deleted by log retention",
+	})
+	promStoreParallelDownloads = newCounterVec(prometheus.CounterOpts{
+		Name: "vault_store_parallel_downloads_total",
+		Help: "Total number of files large enough for parallel download by mode: parallel or sequential (torrent proxy ignored range request)",
+	}, []string{"mode"})
+	promStalledResources = newGauge(prometheus.GaugeOpts{
+		Name: "vault_stalled_resources",
+		Help: "Number of storing resources without progress for the stalled period",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(promWebseedBytesServed)
+	prometheus.MustRegister(promWebseedAbortedTransfers)
+	prometheus.MustRegister(promWebseedAbortedBytes)
+	prometheus.MustRegister(promWebseedRateLimitedRequests)
+	prometheus.MustRegister(promWebseedDegradedRequests)
+	prometheus.MustRegister(promAbuseAnomalies)
+	prometheus.MustRegister(promAbuseBlockedRequests)
+	prometheus.MustRegister(promStoreDownloadBytes)
+	prometheus.MustRegister(promStoreDownloadThrottled)
+	prometheus.MustRegister(promStoreDownloadRateLimit)
+	prometheus.MustRegister(promStalledResources)
+	prometheus.MustRegister(promStoreDeadlineExceeded)
+	prometheus.MustRegister(promStoreTimeouts)
+	prometheus.MustRegister(promUploadVerifyFailures)
+	prometheus.MustRegister(promUploadDedupWaits)
+	prometheus.MustRegister(promStoreFileRetries)
+	prometheus.MustRegister(promAccessStatsDropped)
+	prometheus.MustRegister(promVerifyCorruptedFiles)
+	prometheus.MustRegister(promJobTakeovers)
+	prometheus.MustRegister(promJobTraces)
+	prometheus.MustRegister(promLookupCacheRequests)
+	prometheus.MustRegister(promChunkCacheRequests)
+	prometheus.MustRegister(promChunkCacheBytes)
+	prometheus.MustRegister(promSchedulerStoringResources)
+	prometheus.MustRegister(promSchedulerInflightBytes)
+	prometheus.MustRegister(promSchedulerDeferredJobs)
+	prometheus.MustRegister(promBulkItems)
+	prometheus.MustRegister(promReplicaFiles)
+	prometheus.MustRegister(promReplicaFailovers)
+	prometheus.MustRegister(promS3OutboxDeletes)
+	prometheus.MustRegister(promChunkBytes)
+	prometheus.MustRegister(promCanaryUp)
+	prometheus.MustRegister(promCanaryStageUp)
+	prometheus.MustRegister(promCanaryLastSuccess)
+	prometheus.MustRegister(promCircuitBreakerOpens)
+	prometheus.MustRegister(promOperationLogsPruned)
+	prometheus.MustRegister(promStoreParallelDownloads)
+}
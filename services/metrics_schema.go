@@ -0,0 +1,92 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// MetricSchema describes a metric exported by vault.
+type MetricSchema struct {
+	Name   string   `json:"name"`
+	Type   string   `json:"type"`
+	Help   string   `json:"help"`
+	Labels []string `json:"labels"`
+}
+
+// metricsSchema is filled by metric constructors below, so the catalog can't drift from metrics.go.
+var metricsSchema []MetricSchema
+
+func newCounter(o prometheus.CounterOpts) prometheus.Counter {
+	metricsSchema = append(metricsSchema, MetricSchema{Name: o.Name, Type: "counter", Help: o.Help, Labels: []string{}})
+	return prometheus.NewCounter(o)
+}
+
+func newCounterVec(o prometheus.CounterOpts, labels []string) *prometheus.CounterVec {
+	metricsSchema = append(metricsSchema, MetricSchema{Name: o.Name, Type: "counter", Help: o.Help, Labels: labels})
+	return prometheus.NewCounterVec(o, labels)
+}
+
+func newGauge(o prometheus.GaugeOpts) prometheus.Gauge {
+	metricsSchema = append(metricsSchema, MetricSchema{Name: o.Name, Type: "gauge", Help: o.Help, Labels: []string{}})
+	return prometheus.NewGauge(o)
+}
+
+func newGaugeVec(o prometheus.GaugeOpts, labels []string) *prometheus.GaugeVec {
+	metricsSchema = append(metricsSchema, MetricSchema{Name: o.Name, Type: "gauge", Help: o.Help, Labels: labels})
+	return prometheus.NewGaugeVec(o, labels)
+}
+
+// MetricsSchema returns the catalog of vault metrics sorted by name.
+func MetricsSchema() []MetricSchema {
+	list := append([]MetricSchema(nil), metricsSchema...)
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// GrafanaDashboard builds an example Grafana dashboard with a time series panel per metric:
+// counters are shown as per-second rates, gauges as is, both summed by their labels.
+func GrafanaDashboard(schema []MetricSchema) map[string]any {
+	panels := make([]map[string]any, 0, len(schema))
+	for i, m := range schema {
+		by := ""
+		if len(m.Labels) > 0 {
+			by = " by (" + strings.Join(m.Labels, ", ") + ")"
+		}
+		expr := fmt.Sprintf("sum%v (%v)", by, m.Name)
+		if m.Type == "counter" {
+			expr = fmt.Sprintf("sum%v (rate(%v[$__rate_interval]))", by, m.Name)
+		}
+		legend := "{{" + strings.Join(m.Labels, "}} {{") + "}}"
+		if len(m.Labels) == 0 {
+			legend = m.Name
+		}
+		panels = append(panels, map[string]any{
+			"id":          i + 1,
+			"type":        "timeseries",
+			"title":       strings.TrimPrefix(m.Name, "vault_"),
+			"description": m.Help,
+			"datasource":  map[string]any{"type": "prometheus", "uid": "${datasource}"},
+			"gridPos":     map[string]any{"x": (i % 2) * 12, "y": (i / 2) * 8, "w": 12, "h": 8},
+			"targets": []map[string]any{{
+				"refId":        "A",
+				"expr":         expr,
+				"legendFormat": legend,
+			}},
+		})
+	}
+	return map[string]any{
+		"title":         "Vault",
+		"uid":           "vault",
+		"schemaVersion": 39,
+		"time":          map[string]any{"from": "now-6h", "to": "now"},
+		"templating": map[string]any{"list": []map[string]any{{
+			"name":  "datasource",
+			"type":  "datasource",
+			"query": "prometheus",
+		}}},
+		"panels": panels,
+	}
+}
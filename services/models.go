@@ -3,9 +3,14 @@ package services
 import (
 	"context"
 	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	pg "github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
 	"github.com/google/uuid"
 )
 
@@ -20,10 +25,29 @@ const (
 	StatusQueuedForDeletion
 	StatusDeleting
 	StatusDeleteError
+	// StatusCorrupted marks files whose S3 object is missing or does not match the DB,
+	// and resources referencing them
+	StatusCorrupted
+	// StatusPendingPurge marks soft deleted resources and files kept until PurgeAt
+	StatusPendingPurge
+	// StatusArchived marks resources whose objects are moved to an archive storage class, they are not served
+	StatusArchived
+	// StatusRestoring marks archived resources waiting for restore of their objects
+	StatusRestoring
 )
 
 func (s Status) String() string {
-	return []string{"queued_for_storing", "storing", "stored", "store_error", "queued_for_deletion", "deleting", "delete_error"}[s]
+	return []string{"queued_for_storing", "storing", "stored", "store_error", "queued_for_deletion", "deleting", "delete_error", "corrupted", "pending_purge", "archived", "restoring"}[s]
+}
+
+// ParseStatus parses resource status from its name or numeric code.
+func ParseStatus(v string) (Status, error) {
+	for i, name := range []string{"queued_for_storing", "storing", "stored", "store_error", "queued_for_deletion", "deleting", "delete_error", "corrupted", "pending_purge", "archived", "restoring"} {
+		if v == name || v == strconv.Itoa(i) {
+			return Status(i), nil
+		}
+	}
+	return 0, errors.New("failed to parse status " + v)
 }
 
 // OperationType represents the type of operation performed on a resource.
@@ -34,6 +58,21 @@ const (
 	OperationDelete                      // 1 - delete
 )
 
+func (s OperationType) String() string {
+	return []string{"store", "delete"}[s]
+}
+
+// ParseOperationType parses operation type from its name or numeric code.
+func ParseOperationType(v string) (OperationType, error) {
+	switch v {
+	case "store", "0":
+		return OperationStore, nil
+	case "delete", "1":
+		return OperationDelete, nil
+	}
+	return 0, errors.New("failed to parse operation type " + v)
+}
+
 // OperationStatus represents the result of an operation.
 // 0 - success, 1 - fail
 type OperationStatus int16
@@ -43,6 +82,68 @@ const (
 	OperationFail                           // 1 - fail
 )
 
+// ErrLegalHold is returned when an operation is not allowed for a resource under legal hold.
+var ErrLegalHold = errors.New("resource is under legal hold")
+
+// ParseOperationStatus parses operation status. Nil status means operation is still running.
+func ParseOperationStatus(v string) (*OperationStatus, error) {
+	var st OperationStatus
+	switch v {
+	case "success", "0":
+		st = OperationSuccess
+	case "fail", "1":
+		st = OperationFail
+	case "running":
+		return nil, nil
+	default:
+		return nil, errors.New("failed to parse operation status " + v)
+	}
+	return &st, nil
+}
+
+// ErrTakenDown is returned for resources blocked by a content takedown.
+var ErrTakenDown = errors.New("resource was taken down")
+
+// ErrBlocked is returned for infohashes present in the blocklist.
+var ErrBlocked = errors.New("infohash is blocklisted")
+
+// ErrStoreDeadline is the error of resources which were not stored within their max store duration.
+var ErrStoreDeadline = errors.New("store deadline exceeded")
+
+// ErrStoreTimeout is the error of resources which were not stored within the store timeout of workers.
+var ErrStoreTimeout = errors.New("store timeout exceeded")
+
+// ErrFileTimeout is the error of files which were not stored within the file timeout of workers,
+// e.g. content of dead torrents the torrent proxy waits for forever.
+var ErrFileTimeout = errors.New("file timeout exceeded")
+
+// ErrUploadMismatch is the error of files whose uploaded object does not match the content sent.
+var ErrUploadMismatch = errors.New("uploaded object does not match")
+
+// ErrPreconditionFailed is returned when If-Match of the request does not match the current resource.
+var ErrPreconditionFailed = errors.New("resource changed since it was read")
+
+// ErrNotRetryable is returned when retry is requested for a resource which has not failed.
+var ErrNotRetryable = errors.New("resource is not in error status")
+
+// ErrNotRestorable is returned when restore is requested for a resource which is not pending purge or archived.
+var ErrNotRestorable = errors.New("resource is not pending purge or archived")
+
+// ErrNotArchivable is returned when archival is requested for a resource which is not stored.
+var ErrNotArchivable = errors.New("resource is not stored")
+
+// ErrNotClonable is returned when clone or update is requested for a resource which is not stored.
+var ErrNotClonable = errors.New("resource is not stored")
+
+// ErrNotAbortable is returned when abort is requested for a resource which is not being or failed to be stored.
+var ErrNotAbortable = errors.New("resource is not being stored")
+
+// ErrQuotaExceeded is returned when a tenant stores a new resource beyond its quota.
+var ErrQuotaExceeded = errors.New("tenant quota exceeded")
+
+// ErrResourceExists is returned when a resource is about to be created with an id already in use.
+var ErrResourceExists = errors.New("resource already exists")
+
 type ErrorResponse struct {
 	Error string `json:"error"`
 }
@@ -60,6 +161,57 @@ type Resource struct {
 	Error      *string   `json:"error,omitempty" pg:"error"`
 	CreatedAt  time.Time `json:"created_at" pg:"created_at,notnull,default:now()"`
 	UpdatedAt  time.Time `json:"updated_at" pg:"updated_at,notnull,default:now()"`
+	// LegalHold blocks deletion, expiry, eviction and gc of the resource and its files until cleared
+	LegalHold       bool       `json:"legal_hold" pg:"legal_hold,use_zero"`
+	LegalHoldReason *string    `json:"legal_hold_reason,omitempty" pg:"legal_hold_reason"`
+	LegalHoldAt     *time.Time `json:"legal_hold_at,omitempty" pg:"legal_hold_at"`
+	// ExpiresAt is a moment after which the resource is queued for deletion (nil means store forever)
+	ExpiresAt *time.Time `json:"expires_at,omitempty" pg:"expires_at"`
+	// TTL is remaining time to live in seconds, computed on read
+	TTL *int64 `json:"ttl,omitempty" pg:"-"`
+	// RequestID of the API call which last queued the resource
+	RequestID *string `json:"request_id,omitempty" pg:"request_id"`
+	// StorageClass overrides default S3 storage class of files uploaded for the resource
+	StorageClass *string `json:"storage_class,omitempty" pg:"storage_class"`
+	// LastAccessedAt is updated by webseed (at most hourly)
+	LastAccessedAt *time.Time `json:"last_accessed_at,omitempty" pg:"last_accessed_at"`
+	// LeaseOwner is the worker replica processing the resource until LeaseExpiresAt
+	LeaseOwner     *string    `json:"lease_owner,omitempty" pg:"lease_owner"`
+	LeaseExpiresAt *time.Time `json:"lease_expires_at,omitempty" pg:"lease_expires_at"`
+	// MaxStoreDuration in seconds, storing taking longer fails with ErrStoreDeadline
+	MaxStoreDuration *int64 `json:"max_store_duration,omitempty" pg:"max_store_duration"`
+	// TraceContext of the API call which last queued the resource, continued by the worker
+	TraceContext map[string]string `json:"-" pg:"trace_context"`
+	// ProgressAt is the last change of status or stored size, maintained by trigger
+	ProgressAt *time.Time `json:"progress_at,omitempty" pg:"progress_at"`
+	// StalledAt is set when storing is reported as stalled, cleared by trigger on progress
+	StalledAt *time.Time `json:"stalled_at,omitempty" pg:"stalled_at"`
+	// PurgeAt is when a pending_purge resource is deleted for good, it can be restored until then
+	PurgeAt *time.Time `json:"purge_at,omitempty" pg:"purge_at"`
+	// StorePaths limits the queued store job to these files, other files must be stored already
+	StorePaths []string `json:"store_paths,omitempty" pg:"store_paths,array"`
+	// PreviousID is the version the resource was updated from, its unchanged files are linked instead of stored
+	PreviousID *string `json:"previous_id,omitempty" pg:"previous_id"`
+	// Labels are matched by label selectors of bulk operations and resource listing
+	Labels map[string]string `json:"labels,omitempty" pg:"labels"`
+	// Name is a human readable name of the resource
+	Name *string `json:"name,omitempty" pg:"name"`
+	// Annotations are arbitrary JSON attached by upstream services, not interpreted by vault
+	Annotations map[string]any `json:"annotations,omitempty" pg:"annotations"`
+	// Priority orders queued resources, higher priority resources are stored first
+	Priority int `json:"priority,omitempty" pg:"priority,use_zero"`
+	// Owner is the tenant which stored the resource, only its scoped tokens see it
+	Owner *string `json:"owner,omitempty" pg:"owner"`
+	// IDType is the scheme of ID, only resources with infohash ids are stored from torrents
+	IDType IDType `json:"id_type" pg:"id_type,notnull,default:'infohash'"`
+	// Progress of the running store job: phase (hashing or uploading), file counts and files in progress
+	Progress *StoreProgress `json:"progress,omitempty" pg:"progress"`
+	// ArchiveStorageClass is the storage class objects of the archived resource are moved to
+	ArchiveStorageClass *string `json:"archive_storage_class,omitempty" pg:"archive_storage_class"`
+	// ArchivedAt is set while the resource is archived or restoring, it is archived again if restored from pending_purge
+	ArchivedAt *time.Time `json:"archived_at,omitempty" pg:"archived_at"`
+	// RestoreRequestedAt is when restore of the archived resource was requested
+	RestoreRequestedAt *time.Time `json:"restore_requested_at,omitempty" pg:"restore_requested_at"`
 
 	// Relations
 	// All resource<->file links for this resource. Use with Relation("ResourceFiles") or
@@ -72,13 +224,28 @@ type File struct {
 	// go-pg table name
 	tableName struct{} `pg:"file"`
 
-	Hash       string    `json:"hash" pg:"hash,pk"`
-	Status     Status    `json:"status" pg:"status,use_zero"`
-	TotalSize  int64     `json:"total_size" pg:"total_size,notnull,default:0"`
-	StoredSize int64     `json:"stored_size" pg:"stored_size,notnull,default:0"`
-	Path       *string   `json:"path,omitempty" pg:"path"`
-	CreatedAt  time.Time `json:"created_at" pg:"created_at,notnull,default:now()"`
-	UpdatedAt  time.Time `json:"updated_at" pg:"updated_at,notnull,default:now()"`
+	Hash       string   `json:"hash" pg:"hash,pk"`
+	Status     Status   `json:"status" pg:"status,use_zero"`
+	TotalSize  int64    `json:"total_size" pg:"total_size,notnull,default:0"`
+	StoredSize int64    `json:"stored_size" pg:"stored_size,notnull,default:0"`
+	Path       *string  `json:"path,omitempty" pg:"path"`
+	HashAlgo   HashAlgo `json:"hash_algo" pg:"hash_algo,notnull"`
+	// StorageClass of the S3 object, nil means bucket default
+	StorageClass *string `json:"storage_class,omitempty" pg:"storage_class"`
+	// ContentClass detected from the path at store time
+	ContentClass ContentClass `json:"content_class,omitempty" pg:"content_class"`
+	CreatedAt    time.Time    `json:"created_at" pg:"created_at,notnull,default:now()"`
+	UpdatedAt    time.Time    `json:"updated_at" pg:"updated_at,notnull,default:now()"`
+	// PurgeAt is when a pending_purge file is removed from S3
+	PurgeAt *time.Time `json:"purge_at,omitempty" pg:"purge_at"`
+	// ArchiveIndexedAt is when entries of a zip/rar file were indexed
+	ArchiveIndexedAt *time.Time `json:"archive_indexed_at,omitempty" pg:"archive_indexed_at"`
+	// StoringResourceID is the resource whose store job uploads the file
+	StoringResourceID *string `json:"-" pg:"storing_resource_id"`
+	// Chunked files have no object of their own, content is assembled from chunks listed in file_chunk
+	Chunked bool `json:"chunked,omitempty" pg:"chunked,use_zero"`
+	// Bucket the object is stored in, nil means aws-bucket
+	Bucket *string `json:"bucket,omitempty" pg:"bucket"`
 
 	// Relations
 	// All resource links that reference this file. Use with Relation("ResourceFiles") or
@@ -86,6 +253,14 @@ type File struct {
 	ResourceFiles []ResourceFile `json:"-" pg:"rel:has-many,fk:file_hash"`
 }
 
+// bucketOr returns the bucket of the file object, def if the file was stored before buckets were recorded.
+func (f *File) bucketOr(def string) string {
+	if f.Bucket != nil {
+		return *f.Bucket
+	}
+	return def
+}
+
 // ResourceFile links files to resources and stores a path within the resource.
 type ResourceFile struct {
 	// go-pg table name
@@ -94,12 +269,48 @@ type ResourceFile struct {
 	ResourceID string `json:"resource_id" pg:"resource_id,pk"`
 	FileHash   string `json:"file_hash" pg:"file_hash,pk"`
 	Path       string `json:"path" pg:"path,pk"`
+	// Dir is the directory prefix of Path with trailing slash, maintained by DB trigger
+	Dir string `json:"-" pg:"dir"`
 
 	// Relations
 	Resource *Resource `json:"-" pg:"rel:has-one,fk:resource_id"`
 	File     *File     `json:"-" pg:"rel:has-one,fk:file_hash"`
 }
 
+// ResourceFileStored returns the stored file already linked to the resource at path with the given size,
+// so storing interrupted by a crash or takeover resumes from the last completed file. Returns nil if there is none.
+func ResourceFileStored(ctx context.Context, db pg.DBI, id string, path string, size int64) (*File, error) {
+	rf := &ResourceFile{}
+	err := db.Model(rf).Context(ctx).
+		Relation("File").
+		Where("resource_file.resource_id = ? AND resource_file.path = ?", id, path).
+		Where("file.status = ? AND file.total_size = ?", StatusStored, size).
+		Select()
+	if errors.Is(err, pg.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rf.File, nil
+}
+
+// ResourceFileListByPrefix returns files of the resource within directory prefix (ending with slash)
+// and its subdirectories, ordered by path. Referenced files are loaded as well.
+func ResourceFileListByPrefix(ctx context.Context, db pg.DBI, id, prefix string) ([]ResourceFile, error) {
+	var list []ResourceFile
+	err := db.Model(&list).Context(ctx).
+		Relation("File").
+		Where("resource_file.resource_id = ?", id).
+		Where("resource_file.dir LIKE ? ESCAPE '\\'", likePrefix(prefix)).
+		Order("resource_file.path").
+		Select()
+	if err != nil && !errors.Is(err, pg.ErrNoRows) {
+		return nil, err
+	}
+	return list, nil
+}
+
 // Helper methods for working with the DB using go-pg. These are simple helpers instead of a separate repo layer.
 
 // OperationLog stores audit information about store/delete operations on resources.
@@ -115,15 +326,36 @@ type OperationLog struct {
 	Status *OperationStatus `json:"status,omitempty" pg:"status"`
 	// ErrorText stores error message when operation fails
 	ErrorText *string `json:"error_text,omitempty" pg:"error_text"`
+	// RequestID of the API call which queued the operation
+	RequestID *string `json:"request_id,omitempty" pg:"request_id"`
+	// Retry marks entries recording a manual retry of a failed operation, ErrorText holds the retried error
+	Retry bool `json:"retry,omitempty" pg:"retry,use_zero"`
+	// InstanceID of the vault instance which ran the operation
+	InstanceID *string `json:"instance_id,omitempty" pg:"instance_id"`
+	// TakenOverFrom is the instance which was running the operation before it stopped heartbeating
+	// or lost its lease
+	TakenOverFrom *string `json:"taken_over_from,omitempty" pg:"taken_over_from"`
+	// ClonedFrom is the resource whose files were linked instead of storing them
+	ClonedFrom *string `json:"cloned_from,omitempty" pg:"cloned_from"`
+	// FileRetries is the number of file downloads retried after transient torrent http proxy errors
+	FileRetries int64 `json:"file_retries,omitempty" pg:"file_retries,use_zero"`
+}
+
+// requestIDPtr returns request id of the context or nil.
+func requestIDPtr(ctx context.Context) *string {
+	if id := RequestIDFromContext(ctx); id != "" {
+		return &id
+	}
+	return nil
 }
 
-// LogOperationStart creates a new operation log entry and returns it.
-func LogOperationStart(ctx context.Context, db *pg.DB, resourceID string, s Status) (*OperationLog, error) {
+// LogOperationStart creates a new operation log entry run by the instance and returns it.
+func LogOperationStart(ctx context.Context, db *pg.DB, resourceID string, s Status, instanceID string) (*OperationLog, error) {
 	op := OperationStore
 	if s == StatusDeleting {
 		op = OperationDelete
 	}
-	l := &OperationLog{ResourceID: resourceID, OperationType: op}
+	l := &OperationLog{ResourceID: resourceID, OperationType: op, RequestID: requestIDPtr(ctx), InstanceID: &instanceID}
 	if _, err := db.Model(l).Context(ctx).Insert(); err != nil {
 		return nil, err
 	}
@@ -150,9 +382,228 @@ func LogOperationFinish(ctx context.Context, db *pg.DB, logID uuid.UUID, oerr er
 	return
 }
 
+// LogOperationTakeover records in the log entry that the operation was taken over from another instance
+// and fails entries of the resource left running by that instance.
+func LogOperationTakeover(ctx context.Context, db *pg.DB, l *OperationLog, from string) error {
+	if _, err := db.Model((*OperationLog)(nil)).Context(ctx).
+		Set("finished_at = now()").
+		Set("status = ?", OperationFail).
+		Set("error_text = ?", fmt.Sprintf("instance %v stopped, taken over by %v", from, *l.InstanceID)).
+		Where("resource_id = ?", l.ResourceID).
+		Where("instance_id = ?", from).
+		Where("status IS NULL AND finished_at IS NULL").
+		Update(); err != nil {
+		return err
+	}
+	l.TakenOverFrom = &from
+	_, err := db.Model(l).Context(ctx).Column("taken_over_from").WherePK().Update()
+	return err
+}
+
+// LogOperationSetFileRetries records number of file downloads retried by the operation.
+func LogOperationSetFileRetries(ctx context.Context, db *pg.DB, logID uuid.UUID, n int64) error {
+	_, err := db.Model(&OperationLog{LogID: logID}).Context(ctx).
+		Set("file_retries = ?", n).
+		WherePK().
+		Update()
+	return err
+}
+
+// OperationLogFilter narrows down operation log listing.
+type OperationLogFilter struct {
+	ResourceID string
+	Type       *OperationType
+	Status     *OperationStatus
+	// Running selects operations which are not finished yet
+	Running bool
+	From    *time.Time
+	To      *time.Time
+	Limit   int
+	Offset  int
+}
+
+// OperationLogList returns a page of operation logs matching the filter (newest first) and total count.
+func OperationLogList(ctx context.Context, db pg.DBI, f *OperationLogFilter) ([]OperationLog, int, error) {
+	var list []OperationLog
+	q := db.Model(&list).Context(ctx)
+	if f.ResourceID != "" {
+		q = q.Where("resource_id = ?", f.ResourceID)
+	}
+	if f.Type != nil {
+		q = q.Where("operation_type = ?", *f.Type)
+	}
+	if f.Status != nil {
+		q = q.Where("status = ?", *f.Status)
+	} else if f.Running {
+		q = q.Where("status IS NULL AND finished_at IS NULL")
+	}
+	if f.From != nil {
+		q = q.Where("started_at >= ?", *f.From)
+	}
+	if f.To != nil {
+		q = q.Where("started_at < ?", *f.To)
+	}
+	total, err := q.Order("started_at DESC").Limit(f.Limit).Offset(f.Offset).SelectAndCount()
+	if err != nil {
+		return nil, 0, err
+	}
+	return list, total, nil
+}
+
+// OperationStatsDaily aggregates operations of a type finished on a UTC day. Manual retry entries are
+// only counted in Retries.
+// DB mapping is aligned with migrations/47_operation_stats_daily.*
+type OperationStatsDaily struct {
+	tableName     struct{}      `pg:"operation_stats_daily"`
+	Day           string        `json:"day" pg:"day,pk,type:date"`
+	OperationType OperationType `json:"operation_type" pg:"operation_type,pk,use_zero"`
+	Success       int64         `json:"success" pg:"success,use_zero"`
+	Fail          int64         `json:"fail" pg:"fail,use_zero"`
+	Retries       int64         `json:"retries" pg:"retries,use_zero"`
+	FileRetries   int64         `json:"file_retries" pg:"file_retries,use_zero"`
+	// DurationMs is the total duration of successful and failed operations
+	DurationMs    int64 `json:"duration_ms" pg:"duration_ms,use_zero"`
+	MaxDurationMs int64 `json:"max_duration_ms" pg:"max_duration_ms,use_zero"`
+}
+
+// operationStatsColumns aggregates finished log entries into columns of operation_stats_daily.
+const operationStatsColumns = `
+	(finished_at AT TIME ZONE 'UTC')::date AS day,
+	operation_type,
+	count(*) FILTER (WHERE NOT retry AND status = 0) AS success,
+	count(*) FILTER (WHERE NOT retry AND status = 1) AS fail,
+	count(*) FILTER (WHERE retry) AS retries,
+	coalesce(sum(file_retries), 0) AS file_retries,
+	coalesce(sum(extract(epoch FROM finished_at - started_at) * 1000) FILTER (WHERE NOT retry), 0)::bigint AS duration_ms,
+	coalesce(max(extract(epoch FROM finished_at - started_at) * 1000) FILTER (WHERE NOT retry), 0)::bigint AS max_duration_ms`
+
+// OperationLogPrune deletes up to limit log entries finished earlier than retention ago and adds them
+// to operation_stats_daily in the same statement, so entries are never counted twice or lost.
+// Running operations are kept. Returns the number of deleted entries.
+func OperationLogPrune(ctx context.Context, db pg.DBI, retention time.Duration, limit int) (int, error) {
+	var n int
+	_, err := db.QueryOneContext(ctx, pg.Scan(&n), `
+		WITH pruned AS (
+			DELETE FROM log WHERE log_id IN (
+				SELECT log_id FROM log
+				WHERE finished_at < now() - make_interval(secs => ?)
+				LIMIT ?
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING *
+		), rollup AS (
+			INSERT INTO operation_stats_daily AS s (day, operation_type, success, fail, retries, file_retries, duration_ms, max_duration_ms)
+			SELECT `+operationStatsColumns+`
+			FROM pruned
+			GROUP BY 1, 2
+			ON CONFLICT (day, operation_type) DO UPDATE SET
+				success = s.success + EXCLUDED.success,
+				fail = s.fail + EXCLUDED.fail,
+				retries = s.retries + EXCLUDED.retries,
+				file_retries = s.file_retries + EXCLUDED.file_retries,
+				duration_ms = s.duration_ms + EXCLUDED.duration_ms,
+				max_duration_ms = greatest(s.max_duration_ms, EXCLUDED.max_duration_ms)
+		)
+		SELECT count(*) FROM pruned`, retention.Seconds(), limit)
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// OperationStatsDailyList returns daily stats of operations finished since the UTC day from, newest first.
+// Rolled up stats of pruned entries are combined with entries still in the log.
+func OperationStatsDailyList(ctx context.Context, db pg.DBI, from time.Time, typ *OperationType) ([]OperationStatsDaily, error) {
+	var list []OperationStatsDaily
+	day := from.UTC().Format("2006-01-02")
+	q := `
+		SELECT day, operation_type,
+			sum(success) AS success, sum(fail) AS fail, sum(retries) AS retries, sum(file_retries) AS file_retries,
+			sum(duration_ms) AS duration_ms, max(max_duration_ms) AS max_duration_ms
+		FROM (
+			SELECT day, operation_type, success, fail, retries, file_retries, duration_ms, max_duration_ms
+			FROM operation_stats_daily
+			WHERE day >= ?0::date
+			UNION ALL
+			SELECT ` + operationStatsColumns + `
+			FROM log
+			WHERE finished_at >= (?0::date)::timestamp AT TIME ZONE 'UTC'
+			GROUP BY 1, 2
+		) s`
+	if typ != nil {
+		q += ` WHERE operation_type = ?1`
+	}
+	q += ` GROUP BY day, operation_type ORDER BY day DESC, operation_type`
+	if _, err := db.QueryContext(ctx, &list, q, day, typ); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// DeleteReason explains why an object was removed from S3.
+type DeleteReason string
+
+const (
+	DeleteReasonRefcountZero DeleteReason = "refcount-zero" // last resource referencing the file was deleted
+	DeleteReasonTemporary    DeleteReason = "temporary"     // temporary upload removed after it was renamed or deduplicated
+	DeleteReasonKeyMigration DeleteReason = "key-migration" // object moved to a key of another layout
+	DeleteReasonAbandoned    DeleteReason = "abandoned"     // upload of a file abandoned by an aborted or failed store
+)
+
+// S3DeleteAudit records every S3 object deletion. Rows are written before the delete is issued.
+// DB mapping is aligned with migrations/3_s3_delete_audit.*
+type S3DeleteAudit struct {
+	tableName  struct{}     `pg:"s3_delete_audit"`
+	AuditID    uuid.UUID    `json:"audit_id" pg:"audit_id,pk,type:uuid"`
+	Bucket     string       `json:"bucket" pg:"bucket,notnull"`
+	Key        string       `json:"key" pg:"key,notnull"`
+	Size       int64        `json:"size" pg:"size,use_zero"`
+	Reason     DeleteReason `json:"reason" pg:"reason,notnull"`
+	ResourceID *string      `json:"resource_id,omitempty" pg:"resource_id"`
+	CreatedAt  time.Time    `json:"created_at" pg:"created_at,notnull,default:now()"`
+}
+
+// LogS3Delete stores an audit record about S3 object deletion which is about to happen.
+func LogS3Delete(ctx context.Context, db pg.DBI, bucket, key string, size int64, reason DeleteReason, resourceID string) error {
+	a := &S3DeleteAudit{Bucket: bucket, Key: key, Size: size, Reason: reason}
+	if resourceID != "" {
+		a.ResourceID = &resourceID
+	}
+	_, err := db.Model(a).Context(ctx).Insert()
+	return err
+}
+
+// likePrefix escapes LIKE wildcards of s and returns pattern matching strings starting with it.
+func likePrefix(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s) + "%"
+}
+
+// checkNotBlocked returns ErrBlocked if infohash of the resource is blocklisted and ErrTakenDown if
+// the resource was taken down, whatever the takedown policy is.
+func checkNotBlocked(ctx context.Context, db pg.DBI, id string) error {
+	blocked, err := BlocklistIsBlocked(ctx, db, id)
+	if err != nil {
+		return err
+	}
+	if blocked {
+		return ErrBlocked
+	}
+	td, err := TakedownGetLatest(ctx, db, id)
+	if err != nil {
+		return err
+	}
+	if td != nil {
+		return ErrTakenDown
+	}
+	return nil
+}
+
 // ResourceQueueForStoring inserts a new resource with queued status or updates existing to queued.
-func ResourceQueueForStoring(ctx context.Context, db *pg.DB, id string) (*Resource, error) {
-	res := &Resource{ID: id, Status: StatusQueuedForStoring}
+func ResourceQueueForStoring(ctx context.Context, db pg.DBI, id string) (*Resource, error) {
+	if err := checkNotBlocked(ctx, db, id); err != nil {
+		return nil, err
+	}
+	res := &Resource{ID: id, Status: StatusQueuedForStoring, RequestID: requestIDPtr(ctx), TraceContext: traceContextOf(ctx)}
 	err := db.Model(res).
 		Context(ctx).
 		WherePK().
@@ -161,17 +612,28 @@ func ResourceQueueForStoring(ctx context.Context, db *pg.DB, id string) (*Resour
 		return nil, err
 	}
 	if errors.Is(err, pg.ErrNoRows) {
+		if res.IDType, err = requireTorrentID(id); err != nil {
+			return nil, err
+		}
 		if _, err = db.Model(res).Context(ctx).Insert(); err != nil {
 			return nil, err
 		}
 		return res, nil
 	}
+	// resources of other schemes are added by their own features, e.g. clones of uploads
+	if !res.IDType.Torrent() {
+		return nil, fmt.Errorf("failed to parse resource id %q: resources of id type %v can't be stored from torrents", id, res.IDType)
+	}
 	if res.Status == StatusQueuedForStoring || res.Status == StatusStoring || res.Status == StatusStored {
 		return res, nil
 	}
 	res.Status = StatusQueuedForStoring
+	res.RequestID = requestIDPtr(ctx)
+	res.TraceContext = traceContextOf(ctx)
+	res.PurgeAt = nil
+	res.StorePaths = nil
 	// update
-	if _, err = db.Model(res).Context(ctx).Column("status").WherePK().Update(); err != nil {
+	if _, err = db.Model(res).Context(ctx).Column("status", "request_id", "trace_context", "purge_at", "store_paths").WherePK().Update(); err != nil {
 		return nil, err
 	}
 	// reload
@@ -183,7 +645,7 @@ func ResourceQueueForStoring(ctx context.Context, db *pg.DB, id string) (*Resour
 }
 
 // ResourceGetByID loads a resource by id.
-func ResourceGetByID(ctx context.Context, db *pg.DB, id string) (*Resource, error) {
+func ResourceGetByID(ctx context.Context, db pg.DBI, id string) (*Resource, error) {
 	res := &Resource{ID: id}
 	err := db.Model(res).Context(ctx).WherePK().Select()
 	if err != nil {
@@ -195,8 +657,27 @@ func ResourceGetByID(ctx context.Context, db *pg.DB, id string) (*Resource, erro
 	return res, nil
 }
 
+// ResourceGetForUpdate loads resource by id and locks it until the end of transaction.
+// Returns nil if resource does not exist.
+func ResourceGetForUpdate(ctx context.Context, db pg.DBI, id string) (*Resource, error) {
+	res := &Resource{ID: id}
+	err := db.Model(res).Context(ctx).WherePK().For("UPDATE").Select()
+	if err != nil {
+		if errors.Is(err, pg.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return res, nil
+}
+
+// ETag identifies the state of the resource, it changes on every update of the resource.
+func (r *Resource) ETag() string {
+	return `"` + strconv.FormatInt(r.UpdatedAt.UnixMicro(), 36) + "-" + strconv.Itoa(int(r.Status)) + `"`
+}
+
 // ResourceQueueForDeletion marks the resource as queued (placeholder for deletion workflow).
-func ResourceQueueForDeletion(ctx context.Context, db *pg.DB, id string) (*Resource, error) {
+func ResourceQueueForDeletion(ctx context.Context, db pg.DBI, id string) (*Resource, error) {
 	res := &Resource{ID: id}
 	err := db.Model(res).Context(ctx).WherePK().Select()
 	if err != nil && !errors.Is(err, pg.ErrNoRows) {
@@ -205,7 +686,10 @@ func ResourceQueueForDeletion(ctx context.Context, db *pg.DB, id string) (*Resou
 	if errors.Is(err, pg.ErrNoRows) {
 		return nil, nil
 	}
-	if res.Status == StatusDeleting || res.Status == StatusQueuedForDeletion {
+	if res.LegalHold {
+		return nil, ErrLegalHold
+	}
+	if res.Status == StatusDeleting || res.Status == StatusQueuedForDeletion || res.Status == StatusPendingPurge {
 		return res, nil
 	}
 	if res.Status == StatusQueuedForStoring {
@@ -215,7 +699,9 @@ func ResourceQueueForDeletion(ctx context.Context, db *pg.DB, id string) (*Resou
 		return nil, nil
 	}
 	res.Status = StatusQueuedForDeletion
-	if _, err = db.Model(res).Context(ctx).Column("status").WherePK().Update(); err != nil {
+	res.RequestID = requestIDPtr(ctx)
+	res.TraceContext = traceContextOf(ctx)
+	if _, err = db.Model(res).Context(ctx).Column("status", "request_id", "trace_context").WherePK().Update(); err != nil {
 		return nil, err
 	}
 	if err = db.Model(res).Context(ctx).WherePK().Select(); err != nil {
@@ -223,3 +709,2506 @@ func ResourceQueueForDeletion(ctx context.Context, db *pg.DB, id string) (*Resou
 	}
 	return res, nil
 }
+
+// ResourceClaimOwner makes tenant the owner of the resource unless another tenant owns it already,
+// in which case ErrResourceExists is returned.
+func ResourceClaimOwner(ctx context.Context, db pg.DBI, id string, owner string) (*Resource, error) {
+	res := &Resource{ID: id}
+	up, err := db.Model(res).Context(ctx).
+		Set("owner = ?", owner).
+		WherePK().
+		Where("owner IS NULL OR owner = ?", owner).
+		Returning("*").
+		Update()
+	if err != nil && !errors.Is(err, pg.ErrNoRows) {
+		return nil, err
+	}
+	if errors.Is(err, pg.ErrNoRows) || up.RowsAffected() == 0 {
+		return nil, ErrResourceExists
+	}
+	return res, nil
+}
+
+// TenantUsage counts resources of a tenant and their sizes.
+type TenantUsage struct {
+	Tenant    string `json:"tenant"`
+	Resources int    `json:"resources"`
+	// TotalSize of all files of the resources, including ones not stored yet
+	TotalSize  int64 `json:"total_size"`
+	StoredSize int64 `json:"stored_size"`
+}
+
+// TenantUsageGet returns usage of the tenant.
+func TenantUsageGet(ctx context.Context, db pg.DBI, tenant string) (*TenantUsage, error) {
+	u := &TenantUsage{Tenant: tenant}
+	err := db.Model((*Resource)(nil)).Context(ctx).
+		ColumnExpr("count(*)").
+		ColumnExpr("coalesce(sum(total_size), 0)").
+		ColumnExpr("coalesce(sum(stored_size), 0)").
+		Where("owner = ?", tenant).
+		Select(pg.Scan(&u.Resources, &u.TotalSize, &u.StoredSize))
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// TenantUsageList returns usage of every tenant owning resources, largest first.
+func TenantUsageList(ctx context.Context, db pg.DBI) ([]TenantUsage, error) {
+	var list []TenantUsage
+	err := db.Model((*Resource)(nil)).Context(ctx).
+		ColumnExpr("owner AS tenant").
+		ColumnExpr("count(*) AS resources").
+		ColumnExpr("coalesce(sum(total_size), 0) AS total_size").
+		ColumnExpr("coalesce(sum(stored_size), 0) AS stored_size").
+		Where("owner IS NOT NULL").
+		Group("owner").
+		Order("total_size DESC", "tenant").
+		Select(&list)
+	if err != nil && !errors.Is(err, pg.ErrNoRows) {
+		return nil, err
+	}
+	return list, nil
+}
+
+// ResourceRetry requeues a resource which failed to store or delete, clears its error and
+// records the retry in the operation log. Returns nil if resource does not exist.
+func ResourceRetry(ctx context.Context, db pg.DBI, id string) (*Resource, error) {
+	res := &Resource{ID: id}
+	err := db.Model(res).Context(ctx).WherePK().For("UPDATE").Select()
+	if errors.Is(err, pg.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	l := &OperationLog{ResourceID: id, RequestID: requestIDPtr(ctx), Retry: true, ErrorText: res.Error}
+	switch res.Status {
+	case StatusStoreError, StatusCorrupted:
+		res.Status = StatusQueuedForStoring
+		l.OperationType = OperationStore
+	case StatusDeleteError:
+		if res.LegalHold {
+			return nil, ErrLegalHold
+		}
+		res.Status = StatusQueuedForDeletion
+		l.OperationType = OperationDelete
+	default:
+		return nil, ErrNotRetryable
+	}
+	res.Error = nil
+	res.RequestID = requestIDPtr(ctx)
+	res.TraceContext = traceContextOf(ctx)
+	res.StorePaths = nil
+	if _, err = db.Model(res).Context(ctx).
+		Column("status", "error", "request_id", "trace_context", "store_paths").
+		WherePK().
+		Returning("*").
+		Update(); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	l.FinishedAt = &now
+	if _, err = db.Model(l).Context(ctx).Insert(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// ResourceAbort queues deletion of a resource which is queued for storing, storing or failed to store,
+// bypassing the delete grace period. Store job in progress is cancelled once it sees the status change.
+// Returns nil if resource does not exist.
+func ResourceAbort(ctx context.Context, db pg.DBI, id string) (*Resource, error) {
+	res := &Resource{ID: id}
+	err := db.Model(res).Context(ctx).WherePK().For("UPDATE").Select()
+	if errors.Is(err, pg.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if res.LegalHold {
+		return nil, ErrLegalHold
+	}
+	switch res.Status {
+	case StatusQueuedForStoring, StatusStoring, StatusStoreError:
+	default:
+		return nil, ErrNotAbortable
+	}
+	// purge time in the past skips the restore window of soft deletion
+	now := time.Now()
+	res.Status = StatusQueuedForDeletion
+	res.PurgeAt = &now
+	res.Error = nil
+	res.RequestID = requestIDPtr(ctx)
+	res.TraceContext = traceContextOf(ctx)
+	if _, err = db.Model(res).Context(ctx).
+		Column("status", "purge_at", "error", "request_id", "trace_context").
+		WherePK().
+		Returning("*").
+		Update(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// fileLockClass is the first key of advisory locks of files, so they don't collide with locks of chunks.
+const fileLockClass = 1
+
+// FileLock serializes claims of uploads of the file with deletion of its object until tx ends: claims hold
+// it shared, deletion exclusively. Once a claim is committed, deletion sees the file and is dropped.
+func FileLock(ctx context.Context, tx *pg.Tx, hash string, exclusive bool) error {
+	fn := "pg_advisory_xact_lock_shared"
+	if exclusive {
+		fn = "pg_advisory_xact_lock"
+	}
+	_, err := tx.ExecContext(ctx, "SELECT "+fn+"(?, hashtext(?))", fileLockClass, hash)
+	return err
+}
+
+// FileClaimUpload inserts the file being stored or takes over the row of a file which is not stored and
+// whose upload was abandoned. Returns false if the file is stored or being uploaded by another job.
+func FileClaimUpload(ctx context.Context, db pg.DBI, f *File) (bool, error) {
+	res, err := db.Model(f).Context(ctx).
+		OnConflict("(hash) DO UPDATE").
+		Set("status = EXCLUDED.status").
+		Set("storing_resource_id = EXCLUDED.storing_resource_id").
+		Set("bucket = EXCLUDED.bucket").
+		Set("updated_at = now()").
+		Where("file.status <> ?", StatusStored).
+		Where("file.updated_at < now() - ? * interval '1 millisecond'", uploadStaleAfter.Milliseconds()).
+		Insert()
+	if errors.Is(err, pg.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return res.RowsAffected() > 0, nil
+}
+
+// FileUploadAbandoned reports whether the file which is not stored has no upload in progress.
+func FileUploadAbandoned(ctx context.Context, db pg.DBI, hash string) (bool, error) {
+	return db.Model((*File)(nil)).Context(ctx).
+		Where("hash = ?", hash).
+		Where("status <> ?", StatusStored).
+		Where("updated_at < now() - ? * interval '1 millisecond'", uploadStaleAfter.Milliseconds()).
+		Exists()
+}
+
+// FileListAbandoned returns unreferenced files whose upload for the resource was abandoned:
+// not stored and without progress for longer than a store in progress flushes it.
+func FileListAbandoned(ctx context.Context, db pg.DBI, id string) ([]File, error) {
+	var list []File
+	err := db.Model(&list).Context(ctx).
+		Where("storing_resource_id = ?", id).
+		Where("status IN (?)", pg.In([]Status{StatusStoring, StatusStoreError})).
+		Where("now() - updated_at > ? * interval '1 millisecond'", uploadStaleAfter.Milliseconds()).
+		Where("NOT EXISTS (SELECT 1 FROM resource_file rf WHERE rf.file_hash = file.hash)").
+		Select()
+	if err != nil && !errors.Is(err, pg.ErrNoRows) {
+		return nil, err
+	}
+	return list, nil
+}
+
+// ResourceRetryFile queues a store job limited to the file at path of a failed, stored or corrupted
+// resource and records the retry in the operation log. Returns nil if resource does not exist.
+func ResourceRetryFile(ctx context.Context, db pg.DBI, id string, path string) (*Resource, error) {
+	res := &Resource{ID: id}
+	err := db.Model(res).Context(ctx).WherePK().For("UPDATE").Select()
+	if errors.Is(err, pg.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if res.Status != StatusStoreError && res.Status != StatusStored && res.Status != StatusCorrupted {
+		return nil, ErrNotRetryable
+	}
+	l := &OperationLog{ResourceID: id, OperationType: OperationStore, RequestID: requestIDPtr(ctx), Retry: true, ErrorText: res.Error}
+	if res.Status != StatusStoreError {
+		// paths of a previous single-file job are done
+		res.StorePaths = nil
+	}
+	if !slices.Contains(res.StorePaths, path) {
+		res.StorePaths = append(res.StorePaths, path)
+	}
+	res.Status = StatusQueuedForStoring
+	res.Error = nil
+	res.RequestID = requestIDPtr(ctx)
+	res.TraceContext = traceContextOf(ctx)
+	if _, err = db.Model(res).Context(ctx).
+		Column("status", "error", "request_id", "trace_context", "store_paths").
+		WherePK().
+		Returning("*").
+		Update(); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	l.FinishedAt = &now
+	if _, err = db.Model(l).Context(ctx).Insert(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// ResourceClone creates stored resource target linking the same files as stored resource id, so
+// nothing is uploaded again. The clone is recorded in the operation log of target. Neither id nor target
+// may be blocklisted or taken down. Returns nil if resource id does not exist.
+func ResourceClone(ctx context.Context, db pg.DBI, id string, target string) (*Resource, error) {
+	src := &Resource{ID: id}
+	err := db.Model(src).Context(ctx).WherePK().For("SHARE").Select()
+	if errors.Is(err, pg.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if src.Status != StatusStored {
+		return nil, ErrNotClonable
+	}
+	idType, err := DetectIDType(target)
+	if err != nil {
+		return nil, err
+	}
+	// resources taken down without deleting their files are still stored, their content must not be
+	// served under another id either
+	if err = checkNotBlocked(ctx, db, id); err != nil {
+		return nil, err
+	}
+	if err = checkNotBlocked(ctx, db, target); err != nil {
+		return nil, err
+	}
+	res := &Resource{
+		ID:           target,
+		IDType:       idType,
+		Status:       StatusStored,
+		TotalSize:    src.TotalSize,
+		StoredSize:   src.StoredSize,
+		StorageClass: src.StorageClass,
+		RequestID:    requestIDPtr(ctx),
+	}
+	inserted, err := db.Model(res).Context(ctx).OnConflict("DO NOTHING").Returning("*").Insert()
+	if err != nil {
+		return nil, err
+	}
+	if inserted.RowsAffected() == 0 {
+		return nil, ErrResourceExists
+	}
+	if _, err = db.ExecContext(ctx, `
+		INSERT INTO resource_file (resource_id, file_hash, path)
+		SELECT ?, file_hash, path FROM resource_file WHERE resource_id = ?`, target, id); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	ok := OperationSuccess
+	l := &OperationLog{
+		ResourceID:    target,
+		OperationType: OperationStore,
+		RequestID:     requestIDPtr(ctx),
+		Status:        &ok,
+		FinishedAt:    &now,
+		ClonedFrom:    &id,
+	}
+	if _, err = db.Model(l).Context(ctx).Insert(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// ResourceUpdate queues target as a new version of the stored resource id. Files of target with the same
+// path, size and hash as in id are linked by the store job, so only changed files are uploaded. Neither id
+// nor target may be blocklisted or taken down. Returns nil if the resource doesn't exist.
+func ResourceUpdate(ctx context.Context, db pg.DBI, id string, target string) (*Resource, error) {
+	src := &Resource{ID: id}
+	err := db.Model(src).Context(ctx).WherePK().For("SHARE").Select()
+	if errors.Is(err, pg.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if src.Status != StatusStored {
+		return nil, ErrNotClonable
+	}
+	// the new version is stored from its torrent
+	idType, err := requireTorrentID(target)
+	if err != nil {
+		return nil, err
+	}
+	// files of a taken down version must not be linked by the next one
+	if err = checkNotBlocked(ctx, db, id); err != nil {
+		return nil, err
+	}
+	if err = checkNotBlocked(ctx, db, target); err != nil {
+		return nil, err
+	}
+	res := &Resource{
+		ID:           target,
+		IDType:       idType,
+		Status:       StatusQueuedForStoring,
+		StorageClass: src.StorageClass,
+		RequestID:    requestIDPtr(ctx),
+		TraceContext: traceContextOf(ctx),
+		PreviousID:   &id,
+	}
+	inserted, err := db.Model(res).Context(ctx).OnConflict("DO NOTHING").Returning("*").Insert()
+	if err != nil {
+		return nil, err
+	}
+	if inserted.RowsAffected() == 0 {
+		return nil, ErrResourceExists
+	}
+	return res, nil
+}
+
+// ResourceVersions returns versions of the resource linked by previous_id, ordered from the oldest.
+// Versions deleted for good break the history.
+func ResourceVersions(ctx context.Context, db pg.DBI, id string) ([]Resource, error) {
+	var list []Resource
+	_, err := db.QueryContext(ctx, &list, `
+		WITH RECURSIVE back AS (
+			SELECT resource_id, previous_id, 0 AS depth FROM resource WHERE resource_id = ?0
+			UNION ALL
+			SELECT r.resource_id, r.previous_id, b.depth - 1 FROM resource r
+			JOIN back b ON r.resource_id = b.previous_id
+			WHERE b.depth > -1000
+		), fwd AS (
+			SELECT resource_id, 0 AS depth FROM resource WHERE resource_id = ?0
+			UNION ALL
+			SELECT r.resource_id, f.depth + 1 FROM resource r
+			JOIN fwd f ON r.previous_id = f.resource_id
+			WHERE f.depth < 1000
+		), versions AS (
+			SELECT resource_id, MIN(depth) AS depth FROM (
+				SELECT resource_id, depth FROM back
+				UNION ALL
+				SELECT resource_id, depth FROM fwd
+			) v GROUP BY resource_id
+		)
+		SELECT r.* FROM resource r JOIN versions v USING (resource_id)
+		ORDER BY v.depth, r.created_at`, id)
+	return list, err
+}
+
+// ResourceFileError is a file of the resource which failed to store.
+// DB mapping is aligned with migrations/23_resource_file_error.*
+type ResourceFileError struct {
+	tableName  struct{}  `pg:"resource_file_error"`
+	ResourceID string    `json:"resource_id" pg:"resource_id,pk"`
+	Path       string    `json:"path" pg:"path,pk"`
+	Error      string    `json:"error" pg:"error,notnull"`
+	UpdatedAt  time.Time `json:"updated_at" pg:"updated_at,notnull,default:now()"`
+}
+
+// ResourceFileErrorSet records the store error of the file at path.
+func ResourceFileErrorSet(ctx context.Context, db pg.DBI, id string, path string, ferr error) error {
+	_, err := db.Model(&ResourceFileError{ResourceID: id, Path: path, Error: ferr.Error()}).Context(ctx).
+		OnConflict("(resource_id, path) DO UPDATE").
+		Set("error = EXCLUDED.error").
+		Set("updated_at = now()").
+		Insert()
+	return err
+}
+
+// ResourceFileErrorDelete clears the store error of the file at path.
+func ResourceFileErrorDelete(ctx context.Context, db pg.DBI, id string, path string) error {
+	_, err := db.Model((*ResourceFileError)(nil)).Context(ctx).
+		Where("resource_id = ? AND path = ?", id, path).
+		Delete()
+	return err
+}
+
+// ResourceFileErrorGet returns the store error of the file at path or nil.
+func ResourceFileErrorGet(ctx context.Context, db pg.DBI, id string, path string) (*ResourceFileError, error) {
+	fe := &ResourceFileError{ResourceID: id, Path: path}
+	err := db.Model(fe).Context(ctx).WherePK().Select()
+	if errors.Is(err, pg.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return fe, nil
+}
+
+// ResourceList returns a page of resources (newest first, or least recently accessed first if lru is set), optionally
+// filtered by owner, status and labels, and total count.
+func ResourceList(ctx context.Context, db pg.DBI, owner string, status *Status, sel *LabelSelector, lru bool, limit, offset int) ([]Resource, int, error) {
+	var list []Resource
+	q := db.Model(&list).Context(ctx)
+	if owner != "" {
+		q = q.Where("owner = ?", owner)
+	}
+	if status != nil {
+		q = q.Where("status = ?", *status)
+	}
+	if sel != nil {
+		var err error
+		if q, err = sel.apply(q); err != nil {
+			return nil, 0, err
+		}
+	}
+	if lru {
+		q = q.OrderExpr(resourceLastAccessExpr + " ASC")
+	}
+	total, err := q.Order("updated_at DESC").Limit(limit).Offset(offset).SelectAndCount()
+	if err != nil {
+		return nil, 0, err
+	}
+	return list, total, nil
+}
+
+// ResourceListUpdatedSince returns up to limit resources updated after since, oldest update first.
+// Non-empty owner limits them to resources of the tenant.
+func ResourceListUpdatedSince(ctx context.Context, db pg.DBI, owner string, since time.Time, limit int) ([]Resource, error) {
+	var list []Resource
+	q := db.Model(&list).Context(ctx).
+		Where("updated_at > ?", since)
+	if owner != "" {
+		q = q.Where("owner = ?", owner)
+	}
+	err := q.Order("updated_at ASC").
+		Limit(limit).
+		Select()
+	if err != nil && !errors.Is(err, pg.ErrNoRows) {
+		return nil, err
+	}
+	return list, nil
+}
+
+// ResourceListStalled returns a page of storing resources without progress since the given moment
+// (longest stalled first) and total count.
+func ResourceListStalled(ctx context.Context, db pg.DBI, since time.Time, limit, offset int) ([]Resource, int, error) {
+	var list []Resource
+	total, err := db.Model(&list).Context(ctx).
+		Where("status = ?", StatusStoring).
+		Where("progress_at < ?", since).
+		Order("progress_at ASC").
+		Limit(limit).
+		Offset(offset).
+		SelectAndCount()
+	if err != nil {
+		return nil, 0, err
+	}
+	return list, total, nil
+}
+
+// ResourceMarkStalled marks storing resources without progress since the given moment as stalled and
+// returns those which were not marked before, so every stall is reported once across replicas.
+func ResourceMarkStalled(ctx context.Context, db pg.DBI, since time.Time, limit int) ([]Resource, error) {
+	var list []Resource
+	_, err := db.Model((*Resource)(nil)).Context(ctx).
+		Set("stalled_at = now()").
+		Where("resource_id IN (?)", db.Model((*Resource)(nil)).
+			Column("resource_id").
+			Where("status = ?", StatusStoring).
+			Where("progress_at < ?", since).
+			Where("stalled_at IS NULL").
+			Limit(limit).
+			For("UPDATE SKIP LOCKED")).
+		Returning("*").
+		Update(&list)
+	if err != nil && !errors.Is(err, pg.ErrNoRows) {
+		return nil, err
+	}
+	return list, nil
+}
+
+// ResourceSetExpiry sets or clears (nil) expiration of the resource. Returns nil if resource does not exist.
+func ResourceSetExpiry(ctx context.Context, db pg.DBI, id string, expiresAt *time.Time) (*Resource, error) {
+	res := &Resource{ID: id}
+	r, err := db.Model(res).Context(ctx).
+		Set("expires_at = ?", expiresAt).
+		WherePK().
+		Returning("*").
+		Update()
+	if err != nil {
+		if errors.Is(err, pg.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if r.RowsAffected() == 0 {
+		return nil, nil
+	}
+	return res, nil
+}
+
+// ResourceSetProgress records progress of the store job of the resource, nil clears it.
+func ResourceSetProgress(ctx context.Context, db pg.DBI, id string, p *StoreProgress) error {
+	q := db.Model((*Resource)(nil)).Context(ctx).
+		Set("updated_at = now()").
+		Where("resource_id = ?", id)
+	if p == nil {
+		// SQL NULL rather than JSON null, resources without progress keep their ETag
+		q = q.Set("progress = NULL").Where("progress IS NOT NULL")
+	} else {
+		q = q.Set("progress = ?", p)
+	}
+	_, err := q.Update()
+	return err
+}
+
+// ResourceSetLabels replaces labels of the resource, empty labels clear them. Returns nil if resource does not exist.
+func ResourceSetLabels(ctx context.Context, db pg.DBI, id string, labels map[string]string) (*Resource, error) {
+	var v interface{} = labels
+	if len(labels) == 0 {
+		// SQL NULL rather than JSON null
+		v = nil
+	}
+	res := &Resource{ID: id}
+	_, err := db.Model(res).Context(ctx).
+		Set("labels = ?", v).
+		WherePK().
+		Returning("*").
+		Update()
+	if err != nil {
+		if errors.Is(err, pg.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return res, nil
+}
+
+// ResourceSetName sets or clears (empty) name of the resource. Returns nil if resource does not exist.
+func ResourceSetName(ctx context.Context, db pg.DBI, id string, name string) (*Resource, error) {
+	var v *string
+	if name != "" {
+		v = &name
+	}
+	res := &Resource{ID: id}
+	_, err := db.Model(res).Context(ctx).
+		Set("name = ?", v).
+		WherePK().
+		Returning("*").
+		Update()
+	if err != nil {
+		if errors.Is(err, pg.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return res, nil
+}
+
+// ResourceSetAnnotations replaces annotations of the resource, empty annotations clear them.
+// Returns nil if resource does not exist.
+func ResourceSetAnnotations(ctx context.Context, db pg.DBI, id string, annotations map[string]any) (*Resource, error) {
+	var v interface{} = annotations
+	if len(annotations) == 0 {
+		// SQL NULL rather than JSON null
+		v = nil
+	}
+	res := &Resource{ID: id}
+	_, err := db.Model(res).Context(ctx).
+		Set("annotations = ?", v).
+		WherePK().
+		Returning("*").
+		Update()
+	if err != nil {
+		if errors.Is(err, pg.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return res, nil
+}
+
+// ResourceSetStorageClass sets or clears (empty) storage class override of the resource.
+func ResourceSetStorageClass(ctx context.Context, db pg.DBI, id string, sc string) (*Resource, error) {
+	res := &Resource{ID: id}
+	_, err := db.Model(res).Context(ctx).
+		Set("storage_class = ?", storageClassPtr(sc)).
+		WherePK().
+		Returning("*").
+		Update()
+	if err != nil {
+		if errors.Is(err, pg.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return res, nil
+}
+
+// ResourceSetPriority sets queue priority of the resource. Returns nil if resource does not exist.
+func ResourceSetPriority(ctx context.Context, db pg.DBI, id string, priority int) (*Resource, error) {
+	res := &Resource{ID: id}
+	_, err := db.Model(res).Context(ctx).
+		Set("priority = ?", priority).
+		WherePK().
+		Returning("*").
+		Update()
+	if err != nil {
+		if errors.Is(err, pg.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return res, nil
+}
+
+// ResourceSetMaxStoreDuration sets or clears (0) max store duration of the resource.
+// Returns nil if resource does not exist.
+func ResourceSetMaxStoreDuration(ctx context.Context, db pg.DBI, id string, d time.Duration) (*Resource, error) {
+	var secs *int64
+	if d > 0 {
+		v := int64(d.Seconds())
+		secs = &v
+	}
+	res := &Resource{ID: id}
+	_, err := db.Model(res).Context(ctx).
+		Set("max_store_duration = ?", secs).
+		WherePK().
+		Returning("*").
+		Update()
+	if err != nil {
+		if errors.Is(err, pg.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return res, nil
+}
+
+// ResourceTouch updates last_accessed_at of the resource if it was not updated during the last hour.
+func ResourceTouch(ctx context.Context, db pg.DBI, id string) error {
+	_, err := db.Model((*Resource)(nil)).Context(ctx).
+		Set("last_accessed_at = now()").
+		Where("resource_id = ?", id).
+		Where("last_accessed_at IS NULL OR last_accessed_at < now() - interval '1 hour'").
+		Update()
+	return err
+}
+
+// FileListCold returns stored files in the default or STANDARD class which were not accessed (or created,
+// if never accessed) at any of their paths during the period. Access of files is taken from access stats,
+// files of resources without stats (collected before stats or with stats disabled) fall back to access of
+// the resource, so a file of a resource read through its other files gets cold too.
+func FileListCold(ctx context.Context, db pg.DBI, period time.Duration, limit int) ([]File, error) {
+	var list []File
+	err := db.Model(&list).Context(ctx).
+		Where("status = ?", StatusStored).
+		// chunks are shared by files, so they stay in the class they were uploaded with
+		Where("NOT chunked").
+		Where("storage_class IS NULL OR storage_class = 'STANDARD'").
+		Where(`NOT EXISTS (
+			SELECT 1 FROM resource_file rf JOIN resource r ON r.resource_id = rf.resource_id
+			LEFT JOIN resource_access_stats rs ON rs.resource_id = rf.resource_id AND rs.path = ''
+			LEFT JOIN resource_access_stats fs ON fs.resource_id = rf.resource_id AND fs.path = rf.path
+			WHERE rf.file_hash = file.hash AND greatest(
+				fs.last_accessed_at,
+				CASE WHEN rs.resource_id IS NULL THEN r.last_accessed_at END,
+				r.created_at
+			) > now() - ? * interval '1 second'
+		)`, int64(period.Seconds())).
+		Limit(limit).
+		Select()
+	if err != nil && !errors.Is(err, pg.ErrNoRows) {
+		return nil, err
+	}
+	return list, nil
+}
+
+// FileSetStorageClass records storage class of the file object.
+func FileSetStorageClass(ctx context.Context, db pg.DBI, hash string, sc string) error {
+	_, err := db.Model(&File{Hash: hash}).Context(ctx).
+		Set("storage_class = ?", storageClassPtr(sc)).
+		WherePK().
+		Update()
+	return err
+}
+
+// FileMarkCorrupted marks the file and all stored resources referencing it as corrupted
+// with the reason as resource error. Returns IDs of affected resources.
+func FileMarkCorrupted(ctx context.Context, db pg.DBI, hash string, reason string) ([]string, error) {
+	if _, err := db.Model(&File{Hash: hash}).Context(ctx).
+		Set("status = ?", StatusCorrupted).
+		WherePK().
+		Update(); err != nil {
+		return nil, err
+	}
+	var ids []string
+	_, err := db.QueryContext(ctx, &ids, `
+		UPDATE resource SET status = ?, error = ?
+		WHERE status = ? AND resource_id IN (SELECT resource_id FROM resource_file WHERE file_hash = ?)
+		RETURNING resource_id`, StatusCorrupted, reason, StatusStored, hash)
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// ResourceSoftDelete marks the resource pending_purge until purge_at (set grace period from now
+// unless already set), together with its stored files not referenced by any other live resource.
+func ResourceSoftDelete(ctx context.Context, db pg.DBI, id string, grace time.Duration) (*Resource, error) {
+	res := &Resource{ID: id}
+	if _, err := db.Model(res).Context(ctx).
+		Set("status = ?", StatusPendingPurge).
+		Set("purge_at = coalesce(purge_at, now() + ? * interval '1 second')", int64(grace.Seconds())).
+		WherePK().
+		Returning("*").
+		Update(); err != nil {
+		return nil, err
+	}
+	_, err := db.Model((*File)(nil)).Context(ctx).
+		Set("status = ?", StatusPendingPurge).
+		Set("purge_at = greatest(purge_at, ?)", res.PurgeAt).
+		Where("status = ?", StatusStored).
+		Where("hash IN (SELECT file_hash FROM resource_file WHERE resource_id = ?)", id).
+		Where(`NOT EXISTS (
+			SELECT 1 FROM resource_file rf JOIN resource r ON r.resource_id = rf.resource_id
+			WHERE rf.file_hash = file.hash AND r.resource_id <> ? AND r.status <> ?
+		)`, id, StatusPendingPurge).
+		Update()
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// ResourceRestore brings back a pending_purge resource and its files as stored, or as archived if it was
+// archived, as long as its purge_at is not reached. Archived resources are marked restoring until their
+// objects are restored by the worker. Returns nil if resource does not exist.
+func ResourceRestore(ctx context.Context, db pg.DBI, id string) (*Resource, error) {
+	res := &Resource{ID: id}
+	err := db.Model(res).Context(ctx).WherePK().For("UPDATE").Select()
+	if errors.Is(err, pg.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch res.Status {
+	case StatusRestoring:
+		return res, nil
+	case StatusArchived:
+		if _, err = db.Model(res).Context(ctx).
+			Set("status = ?", StatusRestoring).
+			Set("restore_requested_at = now()").
+			WherePK().
+			Returning("*").
+			Update(); err != nil {
+			return nil, err
+		}
+		return res, nil
+	case StatusPendingPurge:
+		// the restore window is closed even if the purge sweep didn't pick the resource up yet
+		open, err := db.Model((*Resource)(nil)).Context(ctx).
+			Where("resource_id = ?", id).
+			Where("purge_at > now()").
+			Exists()
+		if err != nil {
+			return nil, err
+		}
+		if !open {
+			return nil, ErrNotRestorable
+		}
+	default:
+		return nil, ErrNotRestorable
+	}
+	st := StatusStored
+	if res.ArchivedAt != nil {
+		st = StatusArchived
+	}
+	if _, err = db.Model(res).Context(ctx).
+		Set("status = ?", st).
+		Set("purge_at = NULL").
+		Set("error = NULL").
+		WherePK().
+		Returning("*").
+		Update(); err != nil {
+		return nil, err
+	}
+	if _, err = db.Model((*File)(nil)).Context(ctx).
+		Set("status = ?", StatusStored).
+		Set("purge_at = NULL").
+		Where("status = ?", StatusPendingPurge).
+		Where("hash IN (SELECT file_hash FROM resource_file WHERE resource_id = ?)", id).
+		Update(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// ResourceArchive marks the stored resource archived, its objects are moved to storage class sc by the worker.
+// Returns nil if resource does not exist.
+func ResourceArchive(ctx context.Context, db pg.DBI, id string, sc string) (*Resource, error) {
+	res := &Resource{ID: id}
+	err := db.Model(res).Context(ctx).WherePK().For("UPDATE").Select()
+	if errors.Is(err, pg.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if res.Status == StatusArchived {
+		return res, nil
+	}
+	if res.Status != StatusStored {
+		return nil, ErrNotArchivable
+	}
+	if _, err = db.Model(res).Context(ctx).
+		Set("status = ?", StatusArchived).
+		Set("archive_storage_class = ?", sc).
+		Set("archived_at = now()").
+		Set("restore_requested_at = NULL").
+		WherePK().
+		Returning("*").
+		Update(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// FileTransition is a file whose object is to be moved to StorageClass.
+type FileTransition struct {
+	Hash         string
+	Bucket       *string
+	StorageClass string
+}
+
+// FileListToArchive returns files referenced by archived resources only whose objects are not archived yet,
+// with the archive storage class of their resources. Chunked files stay in their class, since chunks
+// are shared by files.
+func FileListToArchive(ctx context.Context, db pg.DBI, limit int) ([]FileTransition, error) {
+	var list []FileTransition
+	_, err := db.QueryContext(ctx, &list, `
+		SELECT f.hash, f.bucket, (
+			-- DEEP_ARCHIVE sorts before GLACIER, so the colder class wins
+			SELECT min(r.archive_storage_class) FROM resource_file rf JOIN resource r ON r.resource_id = rf.resource_id
+			WHERE rf.file_hash = f.hash
+		) AS storage_class
+		FROM file f
+		WHERE f.status = ?0 AND NOT f.chunked
+		AND coalesce(f.storage_class, '') NOT IN (?1)
+		AND f.hash IN (
+			SELECT rf.file_hash FROM resource_file rf JOIN resource r ON r.resource_id = rf.resource_id
+			WHERE r.status = ?2
+		)
+		AND NOT EXISTS (
+			SELECT 1 FROM resource_file rf JOIN resource r ON r.resource_id = rf.resource_id
+			WHERE rf.file_hash = f.hash AND r.status != ?2
+		)
+		LIMIT ?3`, StatusStored, pg.In(archiveStorageClassNames), StatusArchived, limit)
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// FileListToRestore returns files with archived objects referenced by restoring or stored resources,
+// e.g. by a resource stored later with the same content.
+func FileListToRestore(ctx context.Context, db pg.DBI, limit int) ([]File, error) {
+	var list []File
+	err := db.Model(&list).Context(ctx).
+		Where("storage_class IN (?)", pg.In(archiveStorageClassNames)).
+		Where(`hash IN (
+			SELECT rf.file_hash FROM resource_file rf JOIN resource r ON r.resource_id = rf.resource_id
+			WHERE r.status IN (?)
+		)`, pg.In([]Status{StatusRestoring, StatusStored})).
+		Limit(limit).
+		Select()
+	if err != nil && !errors.Is(err, pg.ErrNoRows) {
+		return nil, err
+	}
+	return list, nil
+}
+
+// ResourceCompleteRestores marks restoring resources without archived objects left stored. Returns their ids.
+func ResourceCompleteRestores(ctx context.Context, db pg.DBI) ([]string, error) {
+	var ids []string
+	_, err := db.QueryContext(ctx, &ids, `
+		UPDATE resource SET status = ?0, archive_storage_class = NULL, archived_at = NULL, restore_requested_at = NULL
+		WHERE status = ?1 AND NOT EXISTS (
+			SELECT 1 FROM resource_file rf JOIN file f ON f.hash = rf.file_hash
+			WHERE rf.resource_id = resource.resource_id AND f.storage_class IN (?2)
+		)
+		RETURNING resource_id`, StatusStored, StatusRestoring, pg.In(archiveStorageClassNames))
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// ResourceQueuePurge queues pending_purge resources whose restore window has passed for deletion,
+// which then deletes them for good. Resources under legal hold are kept. Returns queued ids.
+func ResourceQueuePurge(ctx context.Context, db pg.DBI, limit int) ([]string, error) {
+	var ids []string
+	_, err := db.QueryContext(ctx, &ids, `
+		UPDATE resource SET status = ?
+		WHERE resource_id IN (
+			SELECT resource_id FROM resource
+			WHERE status = ? AND purge_at <= now() AND NOT legal_hold
+			LIMIT ? FOR UPDATE SKIP LOCKED
+		)
+		RETURNING resource_id`, StatusQueuedForDeletion, StatusPendingPurge, limit)
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func storageClassPtr(sc string) *string {
+	if sc == "" {
+		return nil
+	}
+	return &sc
+}
+
+// ResourceRenewLease extends lease of the resource held by owner. Returns false if the lease is not held by owner anymore.
+func ResourceRenewLease(ctx context.Context, db pg.DBI, id string, owner string, ttl time.Duration) (bool, error) {
+	r, err := db.Model((*Resource)(nil)).Context(ctx).
+		Set("lease_expires_at = now() + ? * interval '1 millisecond'", ttl.Milliseconds()).
+		Where("resource_id = ?", id).
+		Where("lease_owner = ?", owner).
+		Update()
+	if err != nil {
+		return false, err
+	}
+	return r.RowsAffected() > 0, nil
+}
+
+// ResourceReleaseLease clears lease of the resource if it is held by owner.
+func ResourceReleaseLease(ctx context.Context, db pg.DBI, id string, owner string) error {
+	_, err := db.Model((*Resource)(nil)).Context(ctx).
+		Set("lease_owner = NULL").
+		Set("lease_expires_at = NULL").
+		Where("resource_id = ?", id).
+		Where("lease_owner = ?", owner).
+		Update()
+	return err
+}
+
+// ResourceDeferStore puts a resource being stored back to the queue, recording its total size and releasing the lease.
+func ResourceDeferStore(ctx context.Context, db pg.DBI, id string, totalSize int64) error {
+	_, err := db.Model((*Resource)(nil)).Context(ctx).
+		Set("status = ?", StatusQueuedForStoring).
+		Set("total_size = ?", totalSize).
+		Set("stored_size = 0").
+		Set("lease_owner = NULL").
+		Set("lease_expires_at = NULL").
+		Where("resource_id = ?", id).
+		Where("status = ?", StatusStoring).
+		Update()
+	return err
+}
+
+// ResourceListExpired returns ids of expired resources which are not under legal hold and not being processed.
+func ResourceListExpired(ctx context.Context, db pg.DBI, limit int) ([]string, error) {
+	var ids []string
+	err := db.Model((*Resource)(nil)).Context(ctx).
+		Column("resource_id").
+		Where("expires_at <= now()").
+		Where("NOT legal_hold").
+		Where("status IN (?)", pg.In([]Status{StatusQueuedForStoring, StatusStored, StatusStoreError})).
+		Order("expires_at").
+		Limit(limit).
+		Select(&ids)
+	if err != nil && !errors.Is(err, pg.ErrNoRows) {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// SetTTL fills remaining time to live from expiration time.
+func (s *Resource) SetTTL(now time.Time) *Resource {
+	if s == nil || s.ExpiresAt == nil {
+		return s
+	}
+	ttl := int64(s.ExpiresAt.Sub(now).Seconds())
+	if ttl < 0 {
+		ttl = 0
+	}
+	s.TTL = &ttl
+	return s
+}
+
+// ResourceSetLegalHold puts the resource under legal hold. Returns nil if resource does not exist.
+func ResourceSetLegalHold(ctx context.Context, db pg.DBI, id string, reason string) (*Resource, error) {
+	res := &Resource{ID: id}
+	r, err := db.Model(res).Context(ctx).
+		Set("legal_hold = true").
+		Set("legal_hold_reason = ?", reason).
+		Set("legal_hold_at = now()").
+		WherePK().
+		Returning("*").
+		Update()
+	if err != nil {
+		if errors.Is(err, pg.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if r.RowsAffected() == 0 {
+		return nil, nil
+	}
+	return res, nil
+}
+
+// ResourceClearLegalHold releases the legal hold. Returns nil if resource does not exist.
+func ResourceClearLegalHold(ctx context.Context, db pg.DBI, id string) (*Resource, error) {
+	res := &Resource{ID: id}
+	r, err := db.Model(res).Context(ctx).
+		Set("legal_hold = false").
+		Set("legal_hold_reason = NULL").
+		Set("legal_hold_at = NULL").
+		WherePK().
+		Returning("*").
+		Update()
+	if err != nil {
+		if errors.Is(err, pg.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if r.RowsAffected() == 0 {
+		return nil, nil
+	}
+	return res, nil
+}
+
+// TakedownPolicy defines what happens with content after takedown.
+type TakedownPolicy string
+
+const (
+	TakedownPolicyDelete TakedownPolicy = "delete" // block access and queue deletion
+	TakedownPolicyBlock  TakedownPolicy = "block"  // block access, keep content (e.g. for investigation)
+)
+
+// Takedown records a content takedown request. DB mapping is aligned with migrations/5_takedown.*
+type Takedown struct {
+	tableName       struct{}       `pg:"takedown"`
+	TakedownID      uuid.UUID      `json:"takedown_id" pg:"takedown_id,pk,type:uuid"`
+	ResourceID      string         `json:"resource_id" pg:"resource_id,notnull"`
+	ReasonCode      string         `json:"reason_code" pg:"reason_code,notnull"`
+	Policy          TakedownPolicy `json:"policy" pg:"policy,notnull"`
+	Reporter        *string        `json:"reporter,omitempty" pg:"reporter"`
+	ReporterContact *string        `json:"reporter_contact,omitempty" pg:"reporter_contact"`
+	NoticeURL       *string        `json:"notice_url,omitempty" pg:"notice_url"`
+	Notes           *string        `json:"notes,omitempty" pg:"notes"`
+	RequestedBy     *string        `json:"requested_by,omitempty" pg:"requested_by"`
+	RemoteAddress   *string        `json:"remote_address,omitempty" pg:"remote_address"`
+	CreatedAt       time.Time      `json:"created_at" pg:"created_at,notnull,default:now()"`
+}
+
+// TakedownCreate records a takedown.
+func TakedownCreate(ctx context.Context, db pg.DBI, t *Takedown) error {
+	_, err := db.Model(t).Context(ctx).Returning("*").Insert()
+	return err
+}
+
+// TakedownGetLatest returns the most recent takedown of the resource or nil if there is none.
+func TakedownGetLatest(ctx context.Context, db pg.DBI, resourceID string) (*Takedown, error) {
+	t := &Takedown{}
+	err := db.Model(t).Context(ctx).
+		Where("resource_id = ?", resourceID).
+		Order("created_at DESC").
+		Limit(1).
+		Select()
+	if err != nil {
+		if errors.Is(err, pg.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return t, nil
+}
+
+// TakedownList returns all takedowns of the resource, newest first.
+func TakedownList(ctx context.Context, db pg.DBI, resourceID string) ([]Takedown, error) {
+	var list []Takedown
+	err := db.Model(&list).Context(ctx).
+		Where("resource_id = ?", resourceID).
+		Order("created_at DESC").
+		Select()
+	if err != nil && !errors.Is(err, pg.ErrNoRows) {
+		return nil, err
+	}
+	return list, nil
+}
+
+// BlocklistSource tells where a blocklist entry came from.
+type BlocklistSource string
+
+const (
+	BlocklistSourceManual BlocklistSource = "manual" // added via admin API
+	BlocklistSourceRemote BlocklistSource = "remote" // synced from remote blocklist url
+)
+
+// BlocklistEntry is a blocked infohash. DB mapping is aligned with migrations/6_blocklist.*
+type BlocklistEntry struct {
+	tableName struct{}        `pg:"blocklist"`
+	Infohash  string          `json:"infohash" pg:"infohash,pk"`
+	Reason    *string         `json:"reason,omitempty" pg:"reason"`
+	Source    BlocklistSource `json:"source" pg:"source,notnull"`
+	CreatedAt time.Time       `json:"created_at" pg:"created_at,notnull,default:now()"`
+}
+
+// NormalizeInfohash lowercases infohash so that lookups are case-insensitive.
+func NormalizeInfohash(h string) string {
+	return strings.ToLower(strings.TrimSpace(h))
+}
+
+// BlocklistIsBlocked reports whether the infohash is blocklisted.
+func BlocklistIsBlocked(ctx context.Context, db pg.DBI, infohash string) (bool, error) {
+	return db.Model((*BlocklistEntry)(nil)).Context(ctx).
+		Where("infohash = ?", NormalizeInfohash(infohash)).
+		Exists()
+}
+
+// BlocklistAdd adds the infohash to the blocklist or updates reason of the existing entry.
+func BlocklistAdd(ctx context.Context, db pg.DBI, e *BlocklistEntry) error {
+	e.Infohash = NormalizeInfohash(e.Infohash)
+	_, err := db.Model(e).Context(ctx).
+		OnConflict("(infohash) DO UPDATE").
+		Set("reason = EXCLUDED.reason").
+		Set("source = EXCLUDED.source").
+		Returning("*").
+		Insert()
+	return err
+}
+
+// BlocklistRemove removes the infohash from the blocklist. Returns false if it was not blocked.
+func BlocklistRemove(ctx context.Context, db pg.DBI, infohash string) (bool, error) {
+	r, err := db.Model((*BlocklistEntry)(nil)).Context(ctx).
+		Where("infohash = ?", NormalizeInfohash(infohash)).
+		Delete()
+	if err != nil {
+		return false, err
+	}
+	return r.RowsAffected() > 0, nil
+}
+
+// BlocklistList returns blocklist entries, newest first, and the total count.
+func BlocklistList(ctx context.Context, db pg.DBI, source BlocklistSource, limit, offset int) ([]BlocklistEntry, int, error) {
+	var list []BlocklistEntry
+	q := db.Model(&list).Context(ctx)
+	if source != "" {
+		q = q.Where("source = ?", source)
+	}
+	total, err := q.Order("created_at DESC", "infohash").Limit(limit).Offset(offset).SelectAndCount()
+	if err != nil && !errors.Is(err, pg.ErrNoRows) {
+		return nil, 0, err
+	}
+	return list, total, nil
+}
+
+// BlocklistReplaceRemote replaces all remote entries with the provided ones. Manual entries are kept intact.
+func BlocklistReplaceRemote(ctx context.Context, db pg.DBI, entries []BlocklistEntry) error {
+	if _, err := db.Model((*BlocklistEntry)(nil)).Context(ctx).
+		Where("source = ?", BlocklistSourceRemote).
+		Delete(); err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	for i := range entries {
+		entries[i].Infohash = NormalizeInfohash(entries[i].Infohash)
+		entries[i].Source = BlocklistSourceRemote
+	}
+	_, err := db.Model(&entries).Context(ctx).
+		OnConflict("(infohash) DO NOTHING").
+		Insert()
+	return err
+}
+
+// ApiToken is a scoped token minted by vault. DB mapping is aligned with migrations/8_api_token.*
+type ApiToken struct {
+	tableName struct{}     `pg:"api_token"`
+	TokenID   uuid.UUID    `json:"token_id" pg:"token_id,pk,type:uuid"`
+	Tenant    string       `json:"tenant" pg:"tenant,notnull"`
+	Scopes    []TokenScope `json:"scopes" pg:"scopes,array,notnull"`
+	Note      *string      `json:"note,omitempty" pg:"note"`
+	CreatedBy *string      `json:"created_by,omitempty" pg:"created_by"`
+	ExpiresAt time.Time    `json:"expires_at" pg:"expires_at,notnull"`
+	RevokedAt *time.Time   `json:"revoked_at,omitempty" pg:"revoked_at"`
+	CreatedAt time.Time    `json:"created_at" pg:"created_at,notnull,default:now()"`
+}
+
+// ApiTokenCreate stores a new token record.
+func ApiTokenCreate(ctx context.Context, db pg.DBI, t *ApiToken) error {
+	_, err := db.Model(t).Context(ctx).Returning("*").Insert()
+	return err
+}
+
+// ApiTokenGet loads token record by id. Returns nil if it does not exist.
+func ApiTokenGet(ctx context.Context, db pg.DBI, id uuid.UUID) (*ApiToken, error) {
+	t := &ApiToken{TokenID: id}
+	err := db.Model(t).Context(ctx).WherePK().Select()
+	if err != nil {
+		if errors.Is(err, pg.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return t, nil
+}
+
+// ApiTokenRevoke marks token as revoked. Returns nil if it does not exist.
+func ApiTokenRevoke(ctx context.Context, db pg.DBI, id uuid.UUID) (*ApiToken, error) {
+	t := &ApiToken{TokenID: id}
+	r, err := db.Model(t).Context(ctx).
+		Set("revoked_at = coalesce(revoked_at, now())").
+		WherePK().
+		Returning("*").
+		Update()
+	if err != nil {
+		if errors.Is(err, pg.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if r.RowsAffected() == 0 {
+		return nil, nil
+	}
+	return t, nil
+}
+
+// ApiTokenList returns tokens, newest first. Empty tenant lists tokens of all tenants.
+func ApiTokenList(ctx context.Context, db pg.DBI, tenant string, limit, offset int) ([]ApiToken, int, error) {
+	var list []ApiToken
+	q := db.Model(&list).Context(ctx)
+	if tenant != "" {
+		q = q.Where("tenant = ?", tenant)
+	}
+	total, err := q.Order("created_at DESC").Limit(limit).Offset(offset).SelectAndCount()
+	if err != nil && !errors.Is(err, pg.ErrNoRows) {
+		return nil, 0, err
+	}
+	return list, total, nil
+}
+
+// Instance is a running vault process. DB mapping is aligned with migrations/20_instance.*
+type Instance struct {
+	tableName   struct{}  `pg:"instance"`
+	InstanceID  string    `json:"instance_id" pg:"instance_id,pk"`
+	Hostname    string    `json:"hostname" pg:"hostname,notnull"`
+	Workers     int       `json:"workers" pg:"workers,use_zero"`
+	StartedAt   time.Time `json:"started_at" pg:"started_at,notnull,default:now()"`
+	HeartbeatAt time.Time `json:"heartbeat_at" pg:"heartbeat_at,notnull,default:now()"`
+}
+
+// InstanceTouch registers the instance or bumps its heartbeat.
+func InstanceTouch(ctx context.Context, db pg.DBI, i *Instance) error {
+	_, err := db.Model(i).Context(ctx).
+		OnConflict("(instance_id) DO UPDATE").
+		Set("hostname = EXCLUDED.hostname").
+		Set("workers = EXCLUDED.workers").
+		Set("heartbeat_at = now()").
+		Insert()
+	return err
+}
+
+// InstanceRemove unregisters the instance.
+func InstanceRemove(ctx context.Context, db pg.DBI, id string) error {
+	_, err := db.Model((*Instance)(nil)).Context(ctx).
+		Where("instance_id = ?", id).
+		Delete()
+	return err
+}
+
+// InstancePrune removes instances without heartbeat for the period.
+func InstancePrune(ctx context.Context, db pg.DBI, period time.Duration) error {
+	_, err := db.Model((*Instance)(nil)).Context(ctx).
+		Where("heartbeat_at < now() - ? * interval '1 second'", int64(period.Seconds())).
+		Delete()
+	return err
+}
+
+// InstanceList returns registered instances, oldest first.
+func InstanceList(ctx context.Context, db pg.DBI) ([]Instance, error) {
+	var list []Instance
+	err := db.Model(&list).Context(ctx).Order("started_at", "instance_id").Select()
+	if err != nil && !errors.Is(err, pg.ErrNoRows) {
+		return nil, err
+	}
+	return list, nil
+}
+
+// ResourceListLeased returns resources with an active lease, i.e. jobs currently run by instances.
+func ResourceListLeased(ctx context.Context, db pg.DBI) ([]Resource, error) {
+	var list []Resource
+	err := db.Model(&list).Context(ctx).
+		Where("lease_owner IS NOT NULL").
+		Where("lease_expires_at > now()").
+		Order("lease_owner", "progress_at").
+		Select()
+	if err != nil && !errors.Is(err, pg.ErrNoRows) {
+		return nil, err
+	}
+	return list, nil
+}
+
+// ArchiveEntry is an entry of a stored zip or rar file.
+// DB mapping is aligned with migrations/26_archive_entry.*
+type ArchiveEntry struct {
+	tableName  struct{}   `pg:"archive_entry"`
+	FileHash   string     `json:"-" pg:"file_hash,pk"`
+	Name       string     `json:"name" pg:"name,pk"`
+	IsDir      bool       `json:"is_dir" pg:"is_dir,use_zero"`
+	Size       int64      `json:"size" pg:"size,use_zero"`
+	PackedSize int64      `json:"packed_size" pg:"packed_size,use_zero"`
+	ModifiedAt *time.Time `json:"modified_at,omitempty" pg:"modified_at"`
+	Encrypted  bool       `json:"encrypted" pg:"encrypted,use_zero"`
+}
+
+// archiveEntryBatch limits rows of a single insert, archives may have lots of entries.
+const archiveEntryBatch = 1000
+
+// ArchiveEntriesSet replaces indexed entries of the archive file and marks it indexed.
+func ArchiveEntriesSet(ctx context.Context, db pg.DBI, hash string, entries []ArchiveEntry) error {
+	return db.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		if _, err := tx.Model((*ArchiveEntry)(nil)).Context(ctx).
+			Where("file_hash = ?", hash).
+			Delete(); err != nil {
+			return err
+		}
+		seen := map[string]bool{}
+		batch := make([]ArchiveEntry, 0, archiveEntryBatch)
+		flush := func() error {
+			if len(batch) == 0 {
+				return nil
+			}
+			_, err := tx.Model(&batch).Context(ctx).Insert()
+			batch = batch[:0]
+			return err
+		}
+		for _, e := range entries {
+			// archives may repeat names (e.g. appended zip entries), the first one is kept
+			if seen[e.Name] {
+				continue
+			}
+			seen[e.Name] = true
+			e.FileHash = hash
+			batch = append(batch, e)
+			if len(batch) == archiveEntryBatch {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		}
+		if err := flush(); err != nil {
+			return err
+		}
+		_, err := tx.Model((*File)(nil)).Context(ctx).
+			Set("archive_indexed_at = now()").
+			Where("hash = ?", hash).
+			Update()
+		return err
+	})
+}
+
+// ArchiveEntryList returns indexed entries of the archive file ordered by name.
+func ArchiveEntryList(ctx context.Context, db pg.DBI, hash string) ([]ArchiveEntry, error) {
+	var entries []ArchiveEntry
+	err := db.Model(&entries).Context(ctx).
+		Where("file_hash = ?", hash).
+		Order("name").
+		Select()
+	return entries, err
+}
+
+// Collection is a named group of resources. DB mapping is aligned with migrations/28_collection.*
+type Collection struct {
+	tableName    struct{}  `pg:"collection"`
+	CollectionID uuid.UUID `json:"collection_id" pg:"collection_id,pk,type:uuid"`
+	Name         string    `json:"name" pg:"name,notnull"`
+	Description  *string   `json:"description,omitempty" pg:"description"`
+	CreatedAt    time.Time `json:"created_at" pg:"created_at,notnull,default:now()"`
+	UpdatedAt    time.Time `json:"updated_at" pg:"updated_at,notnull,default:now()"`
+	// Owner is the tenant which created the collection, only its scoped tokens see it
+	Owner *string `json:"owner,omitempty" pg:"owner"`
+	// Stats is aggregated from resources of the collection, not stored
+	Stats *CollectionStats `json:"stats,omitempty" pg:"-"`
+}
+
+// CollectionStats aggregates sizes of resources of a collection.
+type CollectionStats struct {
+	CollectionID    uuid.UUID `json:"-" pg:"collection_id,type:uuid"`
+	Resources       int       `json:"resources" pg:"resources"`
+	StoredResources int       `json:"stored_resources" pg:"stored_resources"`
+	TotalSize       int64     `json:"total_size" pg:"total_size"`
+	StoredSize      int64     `json:"stored_size" pg:"stored_size"`
+}
+
+// CollectionResource is membership of a resource in a collection.
+type CollectionResource struct {
+	tableName    struct{}  `pg:"collection_resource"`
+	CollectionID uuid.UUID `pg:"collection_id,pk,type:uuid"`
+	ResourceID   string    `pg:"resource_id,pk"`
+	AddedAt      time.Time `pg:"added_at,notnull,default:now()"`
+}
+
+// CollectionCreate stores a new collection.
+func CollectionCreate(ctx context.Context, db pg.DBI, c *Collection) error {
+	_, err := db.Model(c).Context(ctx).Returning("*").Insert()
+	return err
+}
+
+// CollectionGet loads collection with stats by id. Returns nil if it does not exist.
+func CollectionGet(ctx context.Context, db pg.DBI, id uuid.UUID) (*Collection, error) {
+	c := &Collection{CollectionID: id}
+	err := db.Model(c).Context(ctx).WherePK().Select()
+	if err != nil {
+		if errors.Is(err, pg.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	list := []Collection{*c}
+	if err = collectionSetStats(ctx, db, list); err != nil {
+		return nil, err
+	}
+	return &list[0], nil
+}
+
+// CollectionList returns collections with stats, newest first. Non-empty owner limits them to the tenant.
+func CollectionList(ctx context.Context, db pg.DBI, owner string, limit, offset int) ([]Collection, int, error) {
+	var list []Collection
+	q := db.Model(&list).Context(ctx)
+	if owner != "" {
+		q = q.Where("owner = ?", owner)
+	}
+	total, err := q.
+		Order("created_at DESC").
+		Limit(limit).
+		Offset(offset).
+		SelectAndCount()
+	if err != nil && !errors.Is(err, pg.ErrNoRows) {
+		return nil, 0, err
+	}
+	if err = collectionSetStats(ctx, db, list); err != nil {
+		return nil, 0, err
+	}
+	return list, total, nil
+}
+
+func collectionSetStats(ctx context.Context, db pg.DBI, list []Collection) error {
+	if len(list) == 0 {
+		return nil
+	}
+	ids := make([]uuid.UUID, len(list))
+	for i := range list {
+		ids[i] = list[i].CollectionID
+		list[i].Stats = &CollectionStats{CollectionID: list[i].CollectionID}
+	}
+	var stats []CollectionStats
+	_, err := db.QueryContext(ctx, &stats, `
+		SELECT cr.collection_id,
+		       count(*) AS resources,
+		       count(*) FILTER (WHERE r.status = ?1) AS stored_resources,
+		       coalesce(sum(r.total_size), 0) AS total_size,
+		       coalesce(sum(r.stored_size), 0) AS stored_size
+		FROM collection_resource cr
+		JOIN resource r ON r.resource_id = cr.resource_id
+		WHERE cr.collection_id IN (?0)
+		GROUP BY cr.collection_id`, pg.In(ids), StatusStored)
+	if err != nil {
+		return err
+	}
+	for i := range stats {
+		for j := range list {
+			if list[j].CollectionID == stats[i].CollectionID {
+				*list[j].Stats = stats[i]
+			}
+		}
+	}
+	return nil
+}
+
+// CollectionDelete deletes collection, its resources are kept. Returns nil if it does not exist.
+func CollectionDelete(ctx context.Context, db pg.DBI, id uuid.UUID) (*Collection, error) {
+	c := &Collection{CollectionID: id}
+	r, err := db.Model(c).Context(ctx).WherePK().Returning("*").Delete()
+	if err != nil {
+		if errors.Is(err, pg.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if r.RowsAffected() == 0 {
+		return nil, nil
+	}
+	return c, nil
+}
+
+// CollectionAddResource adds resource to collection. Returns false if the collection or the resource does not exist.
+func CollectionAddResource(ctx context.Context, db pg.DBI, id uuid.UUID, resourceID string) (bool, error) {
+	r, err := db.ExecContext(ctx, `
+		INSERT INTO collection_resource (collection_id, resource_id)
+		SELECT c.collection_id, r.resource_id
+		FROM collection c, resource r
+		WHERE c.collection_id = ? AND r.resource_id = ?
+		ON CONFLICT DO NOTHING`, id, resourceID)
+	if err != nil {
+		return false, err
+	}
+	if r.RowsAffected() == 0 {
+		// already a member
+		return db.Model((*CollectionResource)(nil)).Context(ctx).
+			Where("collection_id = ? AND resource_id = ?", id, resourceID).
+			Exists()
+	}
+	_, err = db.Model((*Collection)(nil)).Context(ctx).
+		Set("updated_at = now()").
+		Where("collection_id = ?", id).
+		Update()
+	return true, err
+}
+
+// CollectionRemoveResource removes resource from collection. Returns false if it is not a member.
+func CollectionRemoveResource(ctx context.Context, db pg.DBI, id uuid.UUID, resourceID string) (bool, error) {
+	r, err := db.Model((*CollectionResource)(nil)).Context(ctx).
+		Where("collection_id = ? AND resource_id = ?", id, resourceID).
+		Delete()
+	if err != nil {
+		return false, err
+	}
+	if r.RowsAffected() == 0 {
+		return false, nil
+	}
+	_, err = db.Model((*Collection)(nil)).Context(ctx).
+		Set("updated_at = now()").
+		Where("collection_id = ?", id).
+		Update()
+	return true, err
+}
+
+// CollectionResourceList returns resources of collection, most recently added first. Non-empty owner
+// limits them to resources of the tenant.
+func CollectionResourceList(ctx context.Context, db pg.DBI, id uuid.UUID, owner string, limit, offset int) ([]Resource, int, error) {
+	var list []Resource
+	q := db.Model(&list).Context(ctx).
+		Join("JOIN collection_resource AS cr ON cr.resource_id = resource.resource_id").
+		Where("cr.collection_id = ?", id).
+		Order("cr.added_at DESC")
+	if owner != "" {
+		q = q.Where("resource.owner = ?", owner)
+	}
+	if limit > 0 {
+		q = q.Limit(limit).Offset(offset)
+	}
+	total, err := q.SelectAndCount()
+	if err != nil && !errors.Is(err, pg.ErrNoRows) {
+		return nil, 0, err
+	}
+	return list, total, nil
+}
+
+// BulkAction is an action applied by a bulk operation to every matched resource.
+type BulkAction string
+
+const (
+	BulkActionDelete BulkAction = "delete" // queue deletion
+	BulkActionRetry  BulkAction = "retry"  // requeue failed resources
+	BulkActionPolicy BulkAction = "policy" // set expiration and storage class
+	BulkActionExport BulkAction = "export" // dump metadata of resources to S3
+	BulkActionRehash BulkAction = "rehash" // re-hash stored files, mismatching ones are marked corrupted
+	// BulkActionMigrate rewrites objects of stored files with storage class of params (or their current one)
+	// and current server-side encryption settings
+	BulkActionMigrate BulkAction = "migrate"
+	// BulkActionStore queues storing of listed resources with labels and policy of each item, used by import
+	BulkActionStore BulkAction = "store"
+)
+
+// BulkStatus is the state of a bulk operation.
+type BulkStatus string
+
+const (
+	BulkStatusQueued    BulkStatus = "queued"
+	BulkStatusRunning   BulkStatus = "running"
+	BulkStatusDone      BulkStatus = "done"
+	BulkStatusFailed    BulkStatus = "failed"
+	BulkStatusCancelled BulkStatus = "cancelled"
+)
+
+// BulkItemResult is what happened to a single resource of a bulk operation.
+type BulkItemResult string
+
+const (
+	BulkItemPending  BulkItemResult = "pending"
+	BulkItemDone     BulkItemResult = "done"
+	BulkItemSkipped  BulkItemResult = "skipped" // action does not apply, e.g. retry of a stored resource
+	BulkItemNotFound BulkItemResult = "not_found"
+	BulkItemFailed   BulkItemResult = "failed"
+	// BulkItemCancelled is the result of items left pending when the operation was cancelled
+	BulkItemCancelled BulkItemResult = "cancelled"
+)
+
+// BulkParams are options of the bulk action.
+type BulkParams struct {
+	// ExpiryRequest is the expiration set by policy action, ttl is counted from the moment the resource is processed
+	ExpiryRequest
+	// StorageClass is set by policy action as storage class of files stored later, migrate action
+	// also moves stored objects to it
+	StorageClass string `json:"storage_class,omitempty"`
+	// RetryDeletes lets retry action requeue delete_error resources, set if the creator holds delete scope
+	RetryDeletes bool `json:"retry_deletes,omitempty"`
+	// Labels replace labels of the resource stored by store action
+	Labels map[string]string `json:"labels,omitempty"`
+	// Priority is set as queue priority of the resource stored by store action
+	Priority *int `json:"priority,omitempty"`
+}
+
+// BulkOperation is an asynchronous action on resources matched by a label selector, by status, by age
+// or listed explicitly. DB mapping is aligned with migrations/30_bulk_operation.*, 31_bulk_operation_target.*
+// and 44_bulk_operation_age.*
+type BulkOperation struct {
+	tableName      struct{}    `pg:"bulk_operation"`
+	BulkID         uuid.UUID   `json:"bulk_id" pg:"bulk_id,pk,type:uuid"`
+	Action         BulkAction  `json:"action" pg:"action,notnull"`
+	Selector       *string     `json:"selector,omitempty" pg:"selector"`
+	TargetStatus   *string     `json:"target_status,omitempty" pg:"target_status"`
+	Params         *BulkParams `json:"params,omitempty" pg:"params"`
+	Status         BulkStatus  `json:"status" pg:"status,notnull,default:'queued'"`
+	Total          *int        `json:"total,omitempty" pg:"total"`
+	Processed      int         `json:"processed" pg:"processed,use_zero"`
+	Failed         int         `json:"failed" pg:"failed,use_zero"`
+	Location       *string     `json:"location,omitempty" pg:"location"`
+	Error          *string     `json:"error,omitempty" pg:"error"`
+	RequestID      *string     `json:"request_id,omitempty" pg:"request_id"`
+	LeaseOwner     *string     `json:"lease_owner,omitempty" pg:"lease_owner"`
+	LeaseExpiresAt *time.Time  `json:"lease_expires_at,omitempty" pg:"lease_expires_at"`
+	CreatedAt      time.Time   `json:"created_at" pg:"created_at,notnull,default:now()"`
+	StartedAt      *time.Time  `json:"started_at,omitempty" pg:"started_at"`
+	FinishedAt     *time.Time  `json:"finished_at,omitempty" pg:"finished_at"`
+	// CreatedBefore and LastAccessedBefore narrow matched resources down by age, they are set by purge
+	CreatedBefore      *time.Time `json:"created_before,omitempty" pg:"created_before"`
+	LastAccessedBefore *time.Time `json:"last_accessed_before,omitempty" pg:"last_accessed_before"`
+}
+
+// BulkOperationItem is the result of a bulk operation for a single resource.
+type BulkOperationItem struct {
+	tableName  struct{}       `pg:"bulk_operation_item"`
+	BulkID     uuid.UUID      `json:"-" pg:"bulk_id,pk,type:uuid"`
+	ResourceID string         `json:"resource_id" pg:"resource_id,pk"`
+	Result     BulkItemResult `json:"result" pg:"result,notnull"`
+	Error      *string        `json:"error,omitempty" pg:"error"`
+	FinishedAt *time.Time     `json:"finished_at,omitempty" pg:"finished_at"`
+	// Params of this resource, applied instead of params of the operation
+	Params *BulkParams `json:"params,omitempty" pg:"params"`
+}
+
+// BulkOperationCreate queues a new bulk operation. Explicitly listed resources ids are recorded
+// as pending items right away, otherwise resources are matched when the operation starts.
+func BulkOperationCreate(ctx context.Context, db pg.DBI, op *BulkOperation, ids []string) error {
+	if ids == nil {
+		return BulkOperationCreateItems(ctx, db, op, nil)
+	}
+	items := make([]BulkOperationItem, 0, len(ids))
+	seen := map[string]bool{}
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		items = append(items, BulkOperationItem{ResourceID: id})
+	}
+	return BulkOperationCreateItems(ctx, db, op, items)
+}
+
+// BulkOperationCreateItems queues a new bulk operation on items, which must have distinct resource ids.
+// Nil items leave resources to be matched by selector or status when the operation starts.
+func BulkOperationCreateItems(ctx context.Context, db pg.DBI, op *BulkOperation, items []BulkOperationItem) error {
+	op.Status = BulkStatusQueued
+	op.RequestID = requestIDPtr(ctx)
+	if items != nil {
+		total := 0
+		op.Total = &total
+	}
+	if _, err := db.Model(op).Context(ctx).Returning("*").Insert(); err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		return nil
+	}
+	for i := range items {
+		items[i].BulkID = op.BulkID
+		items[i].Result = BulkItemPending
+	}
+	if _, err := db.Model(&items).Context(ctx).Insert(); err != nil {
+		return err
+	}
+	*op.Total = len(items)
+	_, err := db.Model(op).Context(ctx).Column("total").WherePK().Update()
+	return err
+}
+
+// BulkOperationItemParams loads params of a single item, nil if the item has none.
+func BulkOperationItemParams(ctx context.Context, db pg.DBI, id uuid.UUID, resourceID string) (*BulkParams, error) {
+	item := &BulkOperationItem{BulkID: id, ResourceID: resourceID}
+	err := db.Model(item).Context(ctx).Column("params").WherePK().Select()
+	if err != nil && !errors.Is(err, pg.ErrNoRows) {
+		return nil, err
+	}
+	return item.Params, nil
+}
+
+// BulkOperationGet loads bulk operation by id. Returns nil if it does not exist.
+func BulkOperationGet(ctx context.Context, db pg.DBI, id uuid.UUID) (*BulkOperation, error) {
+	op := &BulkOperation{BulkID: id}
+	err := db.Model(op).Context(ctx).WherePK().Select()
+	if err != nil {
+		if errors.Is(err, pg.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return op, nil
+}
+
+// BulkOperationClaim leases the oldest queued bulk operation, or a running one whose lease expired
+// or whose owner is dead, to owner. Returns nil if there is nothing to run.
+func BulkOperationClaim(ctx context.Context, db pg.DBI, owner string, ttl, deadAfter time.Duration) (*BulkOperation, error) {
+	var list []BulkOperation
+	_, err := db.Model((*BulkOperation)(nil)).Context(ctx).
+		Set("status = ?", BulkStatusRunning).
+		Set("started_at = coalesce(started_at, now())").
+		Set("lease_owner = ?", owner).
+		Set("lease_expires_at = now() + ? * interval '1 millisecond'", ttl.Milliseconds()).
+		Where("bulk_id IN (?)", db.Model((*BulkOperation)(nil)).
+			Column("bulk_id").
+			Where("status IN (?)", pg.In([]BulkStatus{BulkStatusQueued, BulkStatusRunning})).
+			Where("lease_expires_at IS NULL OR lease_expires_at < now() OR "+deadLeaseOwnerCondition, deadAfter.Milliseconds()).
+			Order("created_at").
+			Limit(1).
+			For("UPDATE SKIP LOCKED")).
+		Returning("*").
+		Update(&list)
+	if err != nil && !errors.Is(err, pg.ErrNoRows) {
+		return nil, err
+	}
+	if len(list) == 0 {
+		return nil, nil
+	}
+	return &list[0], nil
+}
+
+// resourceLastAccessExpr is when the resource was last accessed by webseed, its creation if never.
+const resourceLastAccessExpr = `coalesce(
+			(SELECT last_accessed_at FROM resource_access_stats s WHERE s.resource_id = resource.resource_id AND s.path = ''),
+			resource.last_accessed_at, resource.created_at)`
+
+// bulkTargets returns query of resources matched by label selector, status and age of the operation.
+// Deletions without status don't match resources being deleted already.
+func bulkTargets(db pg.DBI, op *BulkOperation) (*orm.Query, error) {
+	q := db.Model((*Resource)(nil))
+	if op.TargetStatus != nil {
+		st, err := ParseStatus(*op.TargetStatus)
+		if err != nil {
+			return nil, err
+		}
+		q = q.Where("resource.status = ?", st)
+	} else if op.Action == BulkActionDelete {
+		q = q.Where("resource.status NOT IN (?)", pg.In([]Status{StatusQueuedForDeletion, StatusDeleting, StatusPendingPurge}))
+	}
+	if op.CreatedBefore != nil {
+		q = q.Where("resource.created_at < ?", *op.CreatedBefore)
+	}
+	if op.LastAccessedBefore != nil {
+		q = q.Where(resourceLastAccessExpr+" < ?", *op.LastAccessedBefore)
+	}
+	if op.Selector != nil {
+		sel, err := ParseLabelSelector(*op.Selector)
+		if err != nil {
+			return nil, err
+		}
+		if q, err = sel.apply(q); err != nil {
+			return nil, err
+		}
+	}
+	return q, nil
+}
+
+// BulkOperationCountTargets counts resources the operation would match if it started now and how many
+// of them are under legal hold.
+func BulkOperationCountTargets(ctx context.Context, db pg.DBI, op *BulkOperation) (matched, held int, err error) {
+	q, err := bulkTargets(db, op)
+	if err != nil {
+		return 0, 0, err
+	}
+	err = q.Context(ctx).
+		ColumnExpr("count(*), count(*) FILTER (WHERE resource.legal_hold)").
+		Select(pg.Scan(&matched, &held))
+	return matched, held, err
+}
+
+// BulkOperationResolve records resources matched by label selector or status of the operation
+// as pending items and sets total. It is a no-op for an operation whose items are recorded already.
+func BulkOperationResolve(ctx context.Context, db pg.DBI, op *BulkOperation) error {
+	if op.Total != nil {
+		return nil
+	}
+	q, err := bulkTargets(db, op)
+	if err != nil {
+		return err
+	}
+	q = q.ColumnExpr("?::uuid, resource.resource_id, ?", op.BulkID, BulkItemPending)
+	r, err := db.ExecContext(ctx, `
+		INSERT INTO bulk_operation_item (bulk_id, resource_id, result) ?
+		ON CONFLICT DO NOTHING`, q)
+	if err != nil {
+		return err
+	}
+	total := r.RowsAffected()
+	op.Total = &total
+	_, err = db.Model(op).Context(ctx).Column("total").WherePK().Update()
+	return err
+}
+
+// BulkOperationPendingItems returns up to limit (all if not positive) resource ids of the operation
+// not processed yet.
+func BulkOperationPendingItems(ctx context.Context, db pg.DBI, id uuid.UUID, limit int) ([]string, error) {
+	var ids []string
+	q := db.Model((*BulkOperationItem)(nil)).Context(ctx).
+		Column("resource_id").
+		Where("bulk_id = ? AND result = ?", id, BulkItemPending).
+		Order("resource_id")
+	if limit > 0 {
+		q = q.Limit(limit)
+	}
+	err := q.Select(&ids)
+	if err != nil && !errors.Is(err, pg.ErrNoRows) {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// BulkOperationSetItem records result of a pending item, counts it and renews the lease of the operation.
+// Returns false if the operation was cancelled or its lease was lost, e.g. taken over by another instance.
+func BulkOperationSetItem(ctx context.Context, db pg.DBI, op *BulkOperation, resourceID string, result BulkItemResult, errText *string, ttl time.Duration) (bool, error) {
+	failed := 0
+	if result == BulkItemFailed {
+		failed = 1
+	}
+	r, err := db.Model(op).Context(ctx).
+		Set("processed = processed + 1").
+		Set("failed = failed + ?", failed).
+		Set("lease_expires_at = now() + ? * interval '1 millisecond'", ttl.Milliseconds()).
+		WherePK().
+		Where("status = ?", BulkStatusRunning).
+		Where("lease_owner = ?", op.LeaseOwner).
+		Returning("*").
+		Update()
+	if errors.Is(err, pg.ErrNoRows) || (err == nil && r.RowsAffected() == 0) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	_, err = db.Model((*BulkOperationItem)(nil)).Context(ctx).
+		Set("result = ?", result).
+		Set("error = ?", errText).
+		Set("finished_at = now()").
+		Where("bulk_id = ? AND resource_id = ? AND result = ?", op.BulkID, resourceID, BulkItemPending).
+		Update()
+	return err == nil, err
+}
+
+// BulkOperationCompleteItems records all pending items of the operation as done, e.g. once all of them are exported.
+func BulkOperationCompleteItems(ctx context.Context, db pg.DBI, op *BulkOperation) error {
+	r, err := db.Model((*BulkOperationItem)(nil)).Context(ctx).
+		Set("result = ?", BulkItemDone).
+		Set("finished_at = now()").
+		Where("bulk_id = ? AND result = ?", op.BulkID, BulkItemPending).
+		Update()
+	if err != nil {
+		return err
+	}
+	op.Processed += r.RowsAffected()
+	_, err = db.Model(op).Context(ctx).Column("processed").WherePK().Update()
+	return err
+}
+
+// BulkOperationFinish marks the operation done, or failed with errText, and releases its lease.
+func BulkOperationFinish(ctx context.Context, db pg.DBI, op *BulkOperation, errText *string) error {
+	op.Status = BulkStatusDone
+	if errText != nil {
+		op.Status = BulkStatusFailed
+	}
+	op.Error = errText
+	now := time.Now()
+	op.FinishedAt = &now
+	op.LeaseOwner = nil
+	op.LeaseExpiresAt = nil
+	_, err := db.Model(op).Context(ctx).
+		Column("status", "error", "location", "finished_at", "lease_owner", "lease_expires_at").
+		WherePK().
+		Where("status = ?", BulkStatusRunning).
+		Update()
+	return err
+}
+
+// BulkOperationCancel cancels a queued or running operation, its pending items are recorded as cancelled.
+// Returns nil if the operation does not exist, finished operations are returned unchanged.
+func BulkOperationCancel(ctx context.Context, db pg.DBI, id uuid.UUID) (*BulkOperation, error) {
+	op := &BulkOperation{BulkID: id}
+	if err := db.Model(op).Context(ctx).WherePK().For("UPDATE").Select(); err != nil {
+		if errors.Is(err, pg.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if op.Status != BulkStatusQueued && op.Status != BulkStatusRunning {
+		return op, nil
+	}
+	if _, err := db.Model((*BulkOperationItem)(nil)).Context(ctx).
+		Set("result = ?", BulkItemCancelled).
+		Set("finished_at = now()").
+		Where("bulk_id = ? AND result = ?", id, BulkItemPending).
+		Update(); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	op.Status = BulkStatusCancelled
+	op.FinishedAt = &now
+	op.LeaseOwner = nil
+	op.LeaseExpiresAt = nil
+	_, err := db.Model(op).Context(ctx).
+		Column("status", "finished_at", "lease_owner", "lease_expires_at").
+		WherePK().
+		Update()
+	return op, err
+}
+
+// BulkOperationList returns bulk operations, newest first, optionally only those with status.
+func BulkOperationList(ctx context.Context, db pg.DBI, status BulkStatus, limit, offset int) ([]BulkOperation, int, error) {
+	var list []BulkOperation
+	q := db.Model(&list).Context(ctx)
+	if status != "" {
+		q = q.Where("status = ?", status)
+	}
+	total, err := q.Order("created_at DESC").Limit(limit).Offset(offset).SelectAndCount()
+	if err != nil && !errors.Is(err, pg.ErrNoRows) {
+		return nil, 0, err
+	}
+	return list, total, nil
+}
+
+// BulkOperationItemList returns a page of items of the operation, optionally only those with result.
+func BulkOperationItemList(ctx context.Context, db pg.DBI, id uuid.UUID, result BulkItemResult, limit, offset int) ([]BulkOperationItem, int, error) {
+	var list []BulkOperationItem
+	q := db.Model(&list).Context(ctx).Where("bulk_id = ?", id)
+	if result != "" {
+		q = q.Where("result = ?", result)
+	}
+	total, err := q.Order("resource_id").Limit(limit).Offset(offset).SelectAndCount()
+	if err != nil && !errors.Is(err, pg.ErrNoRows) {
+		return nil, 0, err
+	}
+	return list, total, nil
+}
+
+// IdempotencyKey is the recorded response of a request sent with Idempotency-Key header.
+// DB mapping is aligned with migrations/33_idempotency_key.*
+type IdempotencyKey struct {
+	tableName   struct{}  `pg:"idempotency_key"`
+	Key         string    `pg:"key,pk"`
+	RequestHash string    `pg:"request_hash,notnull"`
+	Status      *int      `pg:"status"`
+	ContentType *string   `pg:"content_type"`
+	Body        []byte    `pg:"body"`
+	CreatedAt   time.Time `pg:"created_at,notnull,default:now()"`
+}
+
+// idempotencyAbandonedAfter is how long a request may stay in progress before its key can be taken
+// over, so a crashed instance does not block retries until the key expires.
+const idempotencyAbandonedAfter = 5 * time.Minute
+
+// IdempotencyKeyAcquire records the key as in progress. If the key is already recorded and neither expired
+// after ttl nor abandoned, the recorded key is returned instead and the second value is false.
+func IdempotencyKeyAcquire(ctx context.Context, db pg.DBI, key, requestHash string, ttl time.Duration) (*IdempotencyKey, bool, error) {
+	res, err := db.ExecContext(ctx, `
+		INSERT INTO idempotency_key (key, request_hash) VALUES (?, ?)
+		ON CONFLICT (key) DO UPDATE
+		SET request_hash = EXCLUDED.request_hash, status = NULL, content_type = NULL, body = NULL, created_at = now()
+		WHERE idempotency_key.created_at < now() - make_interval(secs => ?)
+		OR (idempotency_key.status IS NULL AND idempotency_key.created_at < now() - make_interval(secs => ?))`,
+		key, requestHash, ttl.Seconds(), idempotencyAbandonedAfter.Seconds())
+	if err != nil {
+		return nil, false, err
+	}
+	if res.RowsAffected() > 0 {
+		return nil, true, nil
+	}
+	k := &IdempotencyKey{Key: key}
+	if err = db.Model(k).Context(ctx).WherePK().Select(); err != nil {
+		return nil, false, err
+	}
+	return k, false, nil
+}
+
+// IdempotencyKeyComplete records response of the request holding the key.
+func IdempotencyKeyComplete(ctx context.Context, db pg.DBI, key string, status int, contentType string, body []byte) error {
+	_, err := db.Model(&IdempotencyKey{Key: key}).Context(ctx).
+		Set("status = ?", status).
+		Set("content_type = ?", contentType).
+		Set("body = ?", body).
+		WherePK().
+		Update()
+	return err
+}
+
+// IdempotencyKeyRelease forgets the key of a failed request, so it can be retried with the same key.
+func IdempotencyKeyRelease(ctx context.Context, db pg.DBI, key string) error {
+	_, err := db.Model(&IdempotencyKey{Key: key}).Context(ctx).WherePK().Where("status IS NULL").Delete()
+	return err
+}
+
+// IdempotencyKeyDeleteExpired removes up to limit keys recorded earlier than ttl ago.
+func IdempotencyKeyDeleteExpired(ctx context.Context, db pg.DBI, ttl time.Duration, limit int) (int, error) {
+	res, err := db.ExecContext(ctx, `
+		DELETE FROM idempotency_key WHERE key IN (
+			SELECT key FROM idempotency_key WHERE created_at < now() - make_interval(secs => ?) LIMIT ?
+		)`, ttl.Seconds(), limit)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected(), nil
+}
+
+// FileReplicaStatus is the state of the copy of a file in the replica bucket.
+type FileReplicaStatus string
+
+const (
+	FileReplicaPending FileReplicaStatus = "pending"
+	FileReplicaDone    FileReplicaStatus = "done"
+	// FileReplicaFailed copies are retried with backoff
+	FileReplicaFailed FileReplicaStatus = "failed"
+)
+
+// FileReplica tracks the copy of a stored file in the replica bucket.
+// DB mapping is aligned with migrations/34_file_replica.*
+type FileReplica struct {
+	tableName      struct{}          `pg:"file_replica"`
+	FileHash       string            `json:"file_hash" pg:"file_hash,pk"`
+	Bucket         string            `json:"bucket" pg:"bucket,pk"`
+	Status         FileReplicaStatus `json:"status" pg:"status,notnull"`
+	Attempts       int               `json:"attempts" pg:"attempts,use_zero"`
+	Error          *string           `json:"error,omitempty" pg:"error"`
+	NextAttemptAt  time.Time         `json:"next_attempt_at" pg:"next_attempt_at,notnull,default:now()"`
+	LeaseOwner     *string           `json:"-" pg:"lease_owner"`
+	LeaseExpiresAt *time.Time        `json:"-" pg:"lease_expires_at"`
+	CreatedAt      time.Time         `json:"created_at" pg:"created_at,notnull,default:now()"`
+	ReplicatedAt   *time.Time        `json:"replicated_at,omitempty" pg:"replicated_at"`
+}
+
+// FileReplicaEnqueue records up to limit stored files without a copy in bucket as pending.
+// Returns number of enqueued files.
+func FileReplicaEnqueue(ctx context.Context, db pg.DBI, bucket string, limit int) (int, error) {
+	res, err := db.ExecContext(ctx, `
+		INSERT INTO file_replica (file_hash, bucket)
+		SELECT f.hash, ? FROM file f
+		WHERE f.status = ? AND NOT f.chunked AND NOT EXISTS (
+			SELECT 1 FROM file_replica fr WHERE fr.file_hash = f.hash AND fr.bucket = ?
+		)
+		LIMIT ?
+		ON CONFLICT DO NOTHING`, bucket, StatusStored, bucket, limit)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected(), nil
+}
+
+// FileReplicaClaim leases up to limit copies due to be made to owner.
+func FileReplicaClaim(ctx context.Context, db pg.DBI, bucket, owner string, ttl, deadAfter time.Duration, limit int) ([]FileReplica, error) {
+	var list []FileReplica
+	_, err := db.Model((*FileReplica)(nil)).Context(ctx).
+		Set("lease_owner = ?", owner).
+		Set("lease_expires_at = now() + ? * interval '1 millisecond'", ttl.Milliseconds()).
+		Where("(file_hash, bucket) IN (?)", db.Model((*FileReplica)(nil)).
+			Column("file_hash", "bucket").
+			Where("bucket = ?", bucket).
+			Where("status <> ?", FileReplicaDone).
+			Where("next_attempt_at <= now()").
+			Where("lease_expires_at IS NULL OR lease_expires_at < now() OR "+deadLeaseOwnerCondition, deadAfter.Milliseconds()).
+			Order("next_attempt_at").
+			Limit(limit).
+			For("UPDATE SKIP LOCKED")).
+		Returning("*").
+		Update(&list)
+	if err != nil && !errors.Is(err, pg.ErrNoRows) {
+		return nil, err
+	}
+	return list, nil
+}
+
+// FileReplicaComplete marks the copy as made and releases its lease.
+func FileReplicaComplete(ctx context.Context, db pg.DBI, r *FileReplica) error {
+	_, err := db.Model(r).Context(ctx).
+		Set("status = ?", FileReplicaDone).
+		Set("error = NULL").
+		Set("replicated_at = now()").
+		Set("lease_owner = NULL").
+		Set("lease_expires_at = NULL").
+		WherePK().
+		Update()
+	return err
+}
+
+// FileReplicaFail records failed attempt of the copy, it is retried after backoff.
+func FileReplicaFail(ctx context.Context, db pg.DBI, r *FileReplica, errText string, backoff time.Duration) error {
+	_, err := db.Model(r).Context(ctx).
+		Set("status = ?", FileReplicaFailed).
+		Set("attempts = attempts + 1").
+		Set("error = ?", errText).
+		Set("next_attempt_at = now() + ? * interval '1 millisecond'", backoff.Milliseconds()).
+		Set("lease_owner = NULL").
+		Set("lease_expires_at = NULL").
+		WherePK().
+		Update()
+	return err
+}
+
+// FileReplicaList returns copies of the file.
+func FileReplicaList(ctx context.Context, db pg.DBI, hash string) ([]FileReplica, error) {
+	var list []FileReplica
+	err := db.Model(&list).Context(ctx).Where("file_hash = ?", hash).Select()
+	if err != nil && !errors.Is(err, pg.ErrNoRows) {
+		return nil, err
+	}
+	return list, nil
+}
+
+// FileReplicaStats counts copies in bucket by status.
+func FileReplicaStats(ctx context.Context, db pg.DBI, bucket string) (map[FileReplicaStatus]int, error) {
+	var rows []struct {
+		Status FileReplicaStatus
+		Count  int
+	}
+	err := db.Model((*FileReplica)(nil)).Context(ctx).
+		Column("status").
+		ColumnExpr("count(*) AS count").
+		Where("bucket = ?", bucket).
+		Group("status").
+		Select(&rows)
+	if err != nil && !errors.Is(err, pg.ErrNoRows) {
+		return nil, err
+	}
+	stats := map[FileReplicaStatus]int{FileReplicaPending: 0, FileReplicaDone: 0, FileReplicaFailed: 0}
+	for _, r := range rows {
+		stats[r.Status] = r.Count
+	}
+	return stats, nil
+}
+
+// ResourceAccessStat is webseed usage of a file of the resource, the row with empty path sums up the resource.
+// DB mapping is aligned with migrations/40_resource_access_stats.*
+type ResourceAccessStat struct {
+	tableName struct{} `pg:"resource_access_stats"`
+
+	ResourceID      string    `json:"-" pg:"resource_id,pk"`
+	Path            string    `json:"path,omitempty" pg:"path,pk,use_zero"`
+	Requests        int64     `json:"requests" pg:"requests,use_zero"`
+	BytesServed     int64     `json:"bytes_served" pg:"bytes_served,use_zero"`
+	FirstAccessedAt time.Time `json:"first_accessed_at" pg:"first_accessed_at"`
+	LastAccessedAt  time.Time `json:"last_accessed_at" pg:"last_accessed_at"`
+}
+
+// ResourceAccessStatAdd adds usage to the accumulated one. Usage of deleted resources is dropped.
+func ResourceAccessStatAdd(ctx context.Context, db pg.DBI, s *ResourceAccessStat) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO resource_access_stats (resource_id, path, requests, bytes_served, first_accessed_at, last_accessed_at)
+		SELECT ?0, ?1, ?2, ?3, ?4, ?5 WHERE EXISTS (SELECT 1 FROM resource WHERE resource_id = ?0)
+		ON CONFLICT (resource_id, path) DO UPDATE SET
+			requests = resource_access_stats.requests + EXCLUDED.requests,
+			bytes_served = resource_access_stats.bytes_served + EXCLUDED.bytes_served,
+			last_accessed_at = greatest(resource_access_stats.last_accessed_at, EXCLUDED.last_accessed_at)`,
+		s.ResourceID, s.Path, s.Requests, s.BytesServed, s.FirstAccessedAt, s.LastAccessedAt)
+	return err
+}
+
+// ResourceAccessStatGet returns usage of the resource, nil if it was never accessed.
+func ResourceAccessStatGet(ctx context.Context, db pg.DBI, id string) (*ResourceAccessStat, error) {
+	s := &ResourceAccessStat{}
+	err := db.Model(s).Context(ctx).Where("resource_id = ?", id).Where("path = ''").Select()
+	if errors.Is(err, pg.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// ResourceAccessStatListFiles returns a page of usage of accessed files of the resource, most bytes served
+// first, and total count.
+func ResourceAccessStatListFiles(ctx context.Context, db pg.DBI, id string, limit, offset int) ([]ResourceAccessStat, int, error) {
+	var list []ResourceAccessStat
+	total, err := db.Model(&list).Context(ctx).
+		Where("resource_id = ?", id).
+		Where("path <> ''").
+		Order("bytes_served DESC", "path").
+		Limit(limit).
+		Offset(offset).
+		SelectAndCount()
+	if err != nil {
+		return nil, 0, err
+	}
+	return list, total, nil
+}
+
+// S3OutboxEntry is an S3 object deletion recorded in the same transaction as the DB change requiring it and
+// executed by workers afterwards, so a crash in between leaves the deletion pending instead of DB and S3
+// out of sync. DB mapping is aligned with migrations/41_s3_outbox.*
+type S3OutboxEntry struct {
+	tableName  struct{}     `pg:"s3_outbox"`
+	ID         int64        `json:"outbox_id" pg:"outbox_id,pk"`
+	Bucket     string       `json:"bucket" pg:"bucket,notnull"`
+	Key        string       `json:"key" pg:"key,notnull"`
+	Size       int64        `json:"size" pg:"size,use_zero"`
+	Reason     DeleteReason `json:"reason" pg:"reason,notnull"`
+	ResourceID *string      `json:"resource_id,omitempty" pg:"resource_id"`
+	// FileHash is set for objects of files, the deletion is dropped if the file is stored again before it runs
+	FileHash *string `json:"file_hash,omitempty" pg:"file_hash"`
+	// ChunkHash is set for objects of chunks, the deletion is dropped while files reference the chunk
+	ChunkHash      *string    `json:"chunk_hash,omitempty" pg:"chunk_hash"`
+	Attempts       int        `json:"attempts" pg:"attempts,use_zero"`
+	Error          *string    `json:"error,omitempty" pg:"error"`
+	NextAttemptAt  time.Time  `json:"next_attempt_at" pg:"next_attempt_at,notnull,default:now()"`
+	LeaseOwner     *string    `json:"-" pg:"lease_owner"`
+	LeaseExpiresAt *time.Time `json:"-" pg:"lease_expires_at"`
+	CreatedAt      time.Time  `json:"created_at" pg:"created_at,notnull,default:now()"`
+	// TraceContext of the job which recorded the deletion, continued when it is executed
+	TraceContext map[string]string `json:"-" pg:"trace_context"`
+}
+
+// S3OutboxAdd records deletion of the object, run not earlier than after delay.
+func S3OutboxAdd(ctx context.Context, db pg.DBI, e *S3OutboxEntry, delay time.Duration) error {
+	if e.TraceContext == nil {
+		e.TraceContext = traceContextOf(ctx)
+	}
+	_, err := db.Model(e).Context(ctx).
+		Value("next_attempt_at", "now() + ? * interval '1 millisecond'", delay.Milliseconds()).
+		Returning("*").
+		Insert()
+	return err
+}
+
+// S3OutboxSchedule makes the pending deletion due right away.
+func S3OutboxSchedule(ctx context.Context, db pg.DBI, id int64) error {
+	_, err := db.Model((*S3OutboxEntry)(nil)).Context(ctx).
+		Set("next_attempt_at = now()").
+		Where("outbox_id = ?", id).
+		Update()
+	return err
+}
+
+// S3OutboxClaim leases up to limit due deletions to owner, leases of dead instances are taken over.
+func S3OutboxClaim(ctx context.Context, db pg.DBI, owner string, ttl, deadAfter time.Duration, limit int) ([]S3OutboxEntry, error) {
+	var list []S3OutboxEntry
+	_, err := db.Model((*S3OutboxEntry)(nil)).Context(ctx).
+		Set("lease_owner = ?", owner).
+		Set("lease_expires_at = now() + ? * interval '1 millisecond'", ttl.Milliseconds()).
+		Where("outbox_id IN (?)", db.Model((*S3OutboxEntry)(nil)).
+			Column("outbox_id").
+			Where("next_attempt_at <= now()").
+			Where("lease_expires_at IS NULL OR lease_expires_at < now() OR "+deadLeaseOwnerCondition, deadAfter.Milliseconds()).
+			Order("next_attempt_at").
+			Limit(limit).
+			For("UPDATE SKIP LOCKED")).
+		Returning("*").
+		Update(&list)
+	if err != nil && !errors.Is(err, pg.ErrNoRows) {
+		return nil, err
+	}
+	return list, nil
+}
+
+// S3OutboxComplete removes the executed deletion, it is recorded in s3_delete_audit.
+func S3OutboxComplete(ctx context.Context, db pg.DBI, e *S3OutboxEntry) error {
+	_, err := db.Model(e).Context(ctx).WherePK().Delete()
+	return err
+}
+
+// S3OutboxFail records the error and releases the lease until the backoff passes.
+func S3OutboxFail(ctx context.Context, db pg.DBI, e *S3OutboxEntry, errText string, backoff time.Duration) error {
+	_, err := db.Model(e).Context(ctx).
+		Set("attempts = attempts + 1").
+		Set("error = ?", errText).
+		Set("next_attempt_at = now() + ? * interval '1 millisecond'", backoff.Milliseconds()).
+		Set("lease_owner = NULL").
+		Set("lease_expires_at = NULL").
+		WherePK().
+		Update()
+	return err
+}
+
+// Chunk is a content-defined chunk of files stored in chunking mode, stored once under its sha256.
+// DB mapping is aligned with migrations/42_chunk.*
+type Chunk struct {
+	tableName struct{}  `pg:"chunk"`
+	Hash      string    `json:"hash" pg:"hash,pk"`
+	Size      int64     `json:"size" pg:"size,use_zero"`
+	CreatedAt time.Time `json:"created_at" pg:"created_at,notnull,default:now()"`
+}
+
+// FileChunk places the chunk at offset of the file, Seq orders chunks of the file.
+type FileChunk struct {
+	tableName struct{} `pg:"file_chunk"`
+	FileHash  string   `json:"file_hash" pg:"file_hash,pk"`
+	Seq       int      `json:"seq" pg:"seq,pk,use_zero"`
+	Offset    int64    `json:"offset" pg:"chunk_offset,use_zero"`
+	Size      int64    `json:"size" pg:"size,use_zero"`
+	ChunkHash string   `json:"chunk_hash" pg:"chunk_hash,notnull"`
+}
+
+// ChunkExists reports whether the chunk is stored.
+func ChunkExists(ctx context.Context, db pg.DBI, hash string) (bool, error) {
+	return db.Model((*Chunk)(nil)).Context(ctx).Where("hash = ?", hash).Exists()
+}
+
+// ChunkAdd records the uploaded chunk, chunks uploaded by other jobs meanwhile are kept.
+func ChunkAdd(ctx context.Context, db pg.DBI, c *Chunk) error {
+	_, err := db.Model(c).Context(ctx).OnConflict("(hash) DO NOTHING").Insert()
+	return err
+}
+
+// FileChunkAdd links chunks to the file, the insert fails if any of the chunks was deleted meanwhile.
+func FileChunkAdd(ctx context.Context, db pg.DBI, chunks []FileChunk) error {
+	const batch = 1000
+	for i := 0; i < len(chunks); i += batch {
+		part := chunks[i:min(i+batch, len(chunks))]
+		if _, err := db.Model(&part).Context(ctx).Insert(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FileChunkList returns chunks of the file in order.
+func FileChunkList(ctx context.Context, db pg.DBI, hash string) ([]FileChunk, error) {
+	var list []FileChunk
+	err := db.Model(&list).Context(ctx).
+		Where("file_hash = ?", hash).
+		Order("seq").
+		Select()
+	if err != nil && !errors.Is(err, pg.ErrNoRows) {
+		return nil, err
+	}
+	return list, nil
+}
+
+// ChunkListReleased returns chunks of the file which no other file references.
+func ChunkListReleased(ctx context.Context, db pg.DBI, hash string) ([]Chunk, error) {
+	var list []Chunk
+	err := db.Model(&list).Context(ctx).
+		Where("hash IN (SELECT chunk_hash FROM file_chunk WHERE file_hash = ?)", hash).
+		Where("NOT EXISTS (SELECT 1 FROM file_chunk fc WHERE fc.chunk_hash = chunk.hash AND fc.file_hash <> ?)", hash).
+		Select()
+	if err != nil && !errors.Is(err, pg.ErrNoRows) {
+		return nil, err
+	}
+	return list, nil
+}
+
+// ChunkLock serializes upload of the chunk with deletion of its object until tx ends: uploads
+// hold it shared, deletion exclusively.
+func ChunkLock(ctx context.Context, tx *pg.Tx, hash string, exclusive bool) error {
+	fn := "pg_advisory_xact_lock_shared"
+	if exclusive {
+		fn = "pg_advisory_xact_lock"
+	}
+	_, err := tx.ExecContext(ctx, "SELECT "+fn+"(hashtext(?))", hash)
+	return err
+}
+
+// ChunkDeleteUnreferenced deletes the chunk row unless files reference it, its object may be deleted then.
+// Row of a chunk whose upload never got recorded is missing, its object may be deleted as well.
+// Expects ChunkLock held exclusively, files can't link the chunk until tx ends.
+func ChunkDeleteUnreferenced(ctx context.Context, tx *pg.Tx, hash string) (bool, error) {
+	c := &Chunk{Hash: hash}
+	if err := tx.Model(c).Context(ctx).WherePK().For("UPDATE").Select(); err != nil {
+		if errors.Is(err, pg.ErrNoRows) {
+			return true, nil
+		}
+		return false, err
+	}
+	referenced, err := tx.Model((*FileChunk)(nil)).Context(ctx).Where("chunk_hash = ?", hash).Exists()
+	if err != nil || referenced {
+		return false, err
+	}
+	_, err = tx.Model(c).Context(ctx).WherePK().Delete()
+	return err == nil, err
+}
+
+// ApiAudit records a mutating API request with identity of the caller.
+// DB mapping is aligned with migrations/43_api_audit.*
+type ApiAudit struct {
+	tableName  struct{}  `pg:"api_audit"`
+	AuditID    uuid.UUID `json:"audit_id" pg:"audit_id,pk,type:uuid"`
+	Method     string    `json:"method" pg:"method,notnull"`
+	Path       string    `json:"path" pg:"path,notnull"`
+	Route      string    `json:"route" pg:"route,notnull"`
+	Status     int       `json:"status" pg:"status,use_zero"`
+	ResourceID *string   `json:"resource_id,omitempty" pg:"resource_id"`
+	// Subject is the JWT subject, tenant of scoped tokens
+	Subject *string `json:"subject,omitempty" pg:"subject"`
+	Role    *string `json:"role,omitempty" pg:"role"`
+	TokenID *string `json:"token_id,omitempty" pg:"token_id"`
+	// SessionID is the sessionID claim of webtor api JWTs
+	SessionID *string `json:"session_id,omitempty" pg:"session_id"`
+	ClientIP  string  `json:"client_ip" pg:"client_ip,notnull"`
+	// RemoteAddr is the remoteAddress claim of webtor api JWTs, address of the end user behind the caller
+	RemoteAddr *string   `json:"remote_addr,omitempty" pg:"remote_addr"`
+	UserAgent  *string   `json:"user_agent,omitempty" pg:"user_agent"`
+	RequestID  *string   `json:"request_id,omitempty" pg:"request_id"`
+	CreatedAt  time.Time `json:"created_at" pg:"created_at,notnull,default:now()"`
+}
+
+// ApiAuditAdd stores an audit record of the request.
+func ApiAuditAdd(ctx context.Context, db pg.DBI, a *ApiAudit) error {
+	_, err := db.Model(a).Context(ctx).Insert()
+	return err
+}
+
+// ApiAuditFilter narrows down api audit listing, empty fields match everything.
+type ApiAuditFilter struct {
+	ResourceID string
+	Subject    string
+	SessionID  string
+	// IP matches either client ip or remote address
+	IP        string
+	RequestID string
+	Method    string
+	From      *time.Time
+	To        *time.Time
+	Limit     int
+	Offset    int
+}
+
+// ApiAuditList returns a page of audit records matching the filter (newest first) and total count.
+func ApiAuditList(ctx context.Context, db pg.DBI, f *ApiAuditFilter) ([]ApiAudit, int, error) {
+	var list []ApiAudit
+	q := db.Model(&list).Context(ctx)
+	for col, v := range map[string]string{
+		"resource_id": f.ResourceID,
+		"subject":     f.Subject,
+		"session_id":  f.SessionID,
+		"request_id":  f.RequestID,
+		"method":      f.Method,
+	} {
+		if v != "" {
+			q = q.Where("? = ?", pg.Ident(col), v)
+		}
+	}
+	if f.IP != "" {
+		q = q.Where("client_ip = ? OR remote_addr = ?", f.IP, f.IP)
+	}
+	if f.From != nil {
+		q = q.Where("created_at >= ?", *f.From)
+	}
+	if f.To != nil {
+		q = q.Where("created_at < ?", *f.To)
+	}
+	total, err := q.Order("created_at DESC").Limit(f.Limit).Offset(f.Offset).SelectAndCount()
+	if err != nil {
+		return nil, 0, err
+	}
+	return list, total, nil
+}
@@ -0,0 +1,52 @@
+package services
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pg/pg/v10"
+	log "github.com/sirupsen/logrus"
+)
+
+// listen returns a channel receiving a value whenever work is queued, nil if LISTEN/NOTIFY is disabled.
+// Notifications arriving while the worker is busy are coalesced into one.
+func (s *Worker) listen(db *pg.DB) <-chan struct{} {
+	if s.notifyChannel == "" {
+		return nil
+	}
+	ln := db.Listen(s.ctx, s.notifyChannel)
+	wake := make(chan struct{}, 1)
+	go func() {
+		<-s.ctx.Done()
+		_ = ln.Close()
+	}()
+	go func() {
+		for range ln.Channel() {
+			select {
+			case wake <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	log.WithField("channel", s.notifyChannel).Info("listening for queued work")
+	return wake
+}
+
+// notifyWorkers is a gin middleware notifying workers after successful mutating requests, so
+// queued resources are picked up without waiting for the next poll.
+func (s *Web) notifyWorkers(c *gin.Context) {
+	c.Next()
+	if s.notifyChannel == "" || c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead ||
+		len(c.Errors) > 0 || c.Writer.Status() >= http.StatusMultipleChoices {
+		return
+	}
+	db := s.pg.Get()
+	if db == nil {
+		return
+	}
+	// queued work is picked up by polling anyway, so a failed notification is only logged
+	if _, err := db.ExecContext(context.WithoutCancel(c.Request.Context()), "SELECT pg_notify(?, '')", s.notifyChannel); err != nil {
+		logger(c.Request.Context()).WithError(err).Warn("failed to notify workers")
+	}
+}
@@ -0,0 +1,356 @@
+package services
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	awss3 "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	cs "github.com/webtor-io/common-services"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	s3KeyTemplateFlag = "s3-key-template"
+	s3KeyPrefixFlag   = "s3-key-prefix"
+)
+
+// DefaultKeyTemplate stores objects flat at the bucket root under their hash.
+const DefaultKeyTemplate = "{hash}"
+
+// RegisterObjectKeyFlags registers CLI flags for layout of object keys in the bucket.
+func RegisterObjectKeyFlags(f []cli.Flag) []cli.Flag {
+	return append(f,
+		cli.StringFlag{
+			Name:   s3KeyTemplateFlag,
+			Usage:  "template of object keys, placeholders: {prefix}, {hash} and {hash[from:to]}, e.g. {prefix}/{hash[0:2]}/{hash}",
+			Value:  DefaultKeyTemplate,
+			EnvVar: "S3_KEY_TEMPLATE",
+		},
+		cli.StringFlag{
+			Name:   s3KeyPrefixFlag,
+			Usage:  "value of {prefix} placeholder, also prepended to temporary and export keys, lets several instances share a bucket",
+			EnvVar: "S3_KEY_PREFIX",
+		},
+	)
+}
+
+// RegisterKeyMigrationCommandFlags registers flags of the standalone key migration command.
+func RegisterKeyMigrationCommandFlags(f []cli.Flag) []cli.Flag {
+	return append(RegisterObjectKeyFlags(f),
+		cli.StringFlag{
+			Name:   awsBucketFlag,
+			Usage:  "aws bucket",
+			EnvVar: "AWS_BUCKET",
+		},
+	)
+}
+
+type keySegment struct {
+	literal string
+	hash    bool
+	// from and to slice the hash, to < 0 means end of hash
+	from, to int
+}
+
+// ObjectKeys maps content hashes to S3 object keys.
+type ObjectKeys struct {
+	template string
+	prefix   string
+	segments []keySegment
+}
+
+// NewObjectKeys parses key layout from CLI flags.
+func NewObjectKeys(c *cli.Context) (*ObjectKeys, error) {
+	return ParseObjectKeys(c.String(s3KeyTemplateFlag), c.String(s3KeyPrefixFlag))
+}
+
+// ParseObjectKeys parses key template with {prefix} substituted by prefix. Template must
+// contain a whole {hash}, so every hash maps to a distinct key.
+func ParseObjectKeys(template, prefix string) (*ObjectKeys, error) {
+	if template == "" {
+		template = DefaultKeyTemplate
+	}
+	prefix = strings.Trim(prefix, "/")
+	s := &ObjectKeys{template: template, prefix: prefix}
+	whole := false
+	rest := template
+	for rest != "" {
+		i := strings.IndexByte(rest, '{')
+		if i < 0 {
+			s.segments = append(s.segments, keySegment{literal: rest})
+			break
+		}
+		if i > 0 {
+			s.segments = append(s.segments, keySegment{literal: rest[:i]})
+		}
+		j := strings.IndexByte(rest[i:], '}')
+		if j < 0 {
+			return nil, errors.Errorf("failed to parse key template %q: unclosed placeholder", template)
+		}
+		name := rest[i+1 : i+j]
+		rest = rest[i+j+1:]
+		switch {
+		case name == "prefix":
+			s.segments = append(s.segments, keySegment{literal: prefix})
+		case name == "hash":
+			s.segments = append(s.segments, keySegment{hash: true, to: -1})
+			whole = true
+		case strings.HasPrefix(name, "hash[") && strings.HasSuffix(name, "]"):
+			seg, err := parseHashSlice(name[len("hash[") : len(name)-1])
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to parse key template %q", template)
+			}
+			s.segments = append(s.segments, seg)
+		default:
+			return nil, errors.Errorf("failed to parse key template %q: unknown placeholder {%v}", template, name)
+		}
+	}
+	if !whole {
+		return nil, errors.Errorf("failed to parse key template %q: {hash} is required", template)
+	}
+	return s, nil
+}
+
+func parseHashSlice(v string) (keySegment, error) {
+	seg := keySegment{hash: true, to: -1}
+	from, to, ok := strings.Cut(v, ":")
+	if !ok {
+		return seg, errors.Errorf("slice %q must be from:to", v)
+	}
+	var err error
+	if from != "" {
+		if seg.from, err = strconv.Atoi(from); err != nil {
+			return seg, errors.Wrapf(err, "bad slice start %q", from)
+		}
+	}
+	if to != "" {
+		if seg.to, err = strconv.Atoi(to); err != nil {
+			return seg, errors.Wrapf(err, "bad slice end %q", to)
+		}
+	}
+	if seg.from < 0 || (seg.to >= 0 && seg.to <= seg.from) {
+		return seg, errors.Errorf("empty slice %q", v)
+	}
+	return seg, nil
+}
+
+// String returns the key template.
+func (s *ObjectKeys) String() string {
+	return s.template
+}
+
+// Key returns object key of content with the hash.
+func (s *ObjectKeys) Key(hash string) string {
+	var b strings.Builder
+	for _, seg := range s.segments {
+		if !seg.hash {
+			b.WriteString(seg.literal)
+			continue
+		}
+		from, to := min(seg.from, len(hash)), len(hash)
+		if seg.to >= 0 {
+			to = min(seg.to, len(hash))
+		}
+		b.WriteString(hash[from:to])
+	}
+	return cleanKey(b.String())
+}
+
+// ChunkKey returns object key of the chunk with the hash, spread over prefixes by the first hash byte.
+func (s *ObjectKeys) ChunkKey(hash string) string {
+	return s.join(chunkKeyPrefix + hash[:min(2, len(hash))] + "/" + hash)
+}
+
+// join prepends prefix to key of objects not addressed by hash, such as temporary uploads.
+func (s *ObjectKeys) join(key string) string {
+	if s.prefix == "" {
+		return key
+	}
+	return s.prefix + "/" + key
+}
+
+// cleanKey drops empty path elements left by an empty {prefix}.
+func cleanKey(key string) string {
+	for strings.Contains(key, "//") {
+		key = strings.ReplaceAll(key, "//", "/")
+	}
+	return strings.TrimPrefix(key, "/")
+}
+
+// KeyMigrationReport is the result of KeyMigration.
+type KeyMigrationReport struct {
+	Files int `json:"files"`
+	// Copied objects to the new key
+	Copied int `json:"copied"`
+	// Deleted objects under the old key
+	Deleted int `json:"deleted"`
+	// Skipped files already under the new key or with the same key in both layouts
+	Skipped int `json:"skipped"`
+	// Missing files with an object under neither key
+	Missing int `json:"missing"`
+	// Archived objects in GLACIER or DEEP_ARCHIVE, they can't be copied until they are restored
+	Archived int `json:"archived"`
+	// Replica reports objects of the replica bucket, nil without replica
+	Replica *KeyMigrationReport `json:"replica,omitempty"`
+}
+
+// add counts result of migration of an object.
+func (r *KeyMigrationReport) add(res string, deleteSource bool) {
+	r.Files++
+	switch res {
+	case "copied":
+		r.Copied++
+	case "missing":
+		r.Missing++
+	case "archived":
+		r.Archived++
+	default:
+		r.Skipped++
+	}
+	if deleteSource && (res == "copied" || res == "deleted") {
+		r.Deleted++
+	}
+}
+
+// KeyMigration moves objects of all files from one key layout to another.
+type KeyMigration struct {
+	DB           *pg.DB
+	S3           *cs.S3Client
+	Enc          *Encryption
+	Bucket       string
+	From         *ObjectKeys
+	To           *ObjectKeys
+	DeleteSource bool
+	PageSize     int
+	Concurrency  int
+	// Tags are copied to objects copied in parts, nil if objects are not tagged
+	Tags *ObjectTags
+	// Replica bucket is migrated along with the primary one, nil without replica
+	Replica *Replica
+}
+
+// Run walks files page by page and copies every object from its old key to its new key,
+// keeping storage class and metadata. Objects already present under the new key are not copied
+// again, so migration can be repeated: once with DeleteSource unset while instances still read
+// the old layout, then with DeleteSource set after instances are switched to the new one.
+// Archived objects are skipped and reported, they are migrated by a run after they are restored.
+func (s *KeyMigration) Run(ctx context.Context) (*KeyMigrationReport, error) {
+	report := &KeyMigrationReport{}
+	if s.Replica != nil {
+		report.Replica = &KeyMigrationReport{}
+	}
+	var mux sync.Mutex
+	last := ""
+	for {
+		var files []File
+		err := s.DB.Model(&files).Context(ctx).
+			Column("hash", "total_size", "bucket").
+			Where("hash > ?", last).
+			// chunked files have no object of their own
+			Where("NOT chunked").
+			Order("hash").
+			Limit(s.PageSize).
+			Select()
+		if err != nil && !errors.Is(err, pg.ErrNoRows) {
+			return report, err
+		}
+		g, gctx := errgroup.WithContext(ctx)
+		g.SetLimit(s.Concurrency)
+		for i := range files {
+			f := &files[i]
+			g.Go(func() error {
+				res, err := s.migrate(gctx, s.S3.Get(), s.Enc, f.bucketOr(s.Bucket), f)
+				if err != nil {
+					return errors.Wrapf(err, "failed to migrate %v", f.Hash)
+				}
+				replicaRes := ""
+				if s.Replica != nil {
+					replicaRes, err = s.migrate(gctx, s.Replica.s3, s.Enc.Replica(), s.Replica.bucket, f)
+					if err != nil {
+						return errors.Wrapf(err, "failed to migrate replica of %v", f.Hash)
+					}
+				}
+				mux.Lock()
+				defer mux.Unlock()
+				report.add(res, s.DeleteSource)
+				if s.Replica != nil {
+					report.Replica.add(replicaRes, s.DeleteSource)
+				}
+				return nil
+			})
+		}
+		if err := g.Wait(); err != nil {
+			return report, err
+		}
+		log.WithField("files", report.Files).Info("object keys migrated")
+		if len(files) < s.PageSize {
+			return report, nil
+		}
+		last = files[len(files)-1].Hash
+	}
+}
+
+// migrate moves object of a single file in the bucket and returns what was done: copied, deleted
+// (already copied, old object removed), skipped, archived (not copied) or missing.
+func (s *KeyMigration) migrate(ctx context.Context, cl *awss3.S3, enc *Encryption, bucket string, f *File) (string, error) {
+	src, dst := s.From.Key(f.Hash), s.To.Key(f.Hash)
+	if src == dst {
+		return "skipped", nil
+	}
+	srcHead, err := cl.HeadObjectWithContext(ctx, &awss3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(src),
+	})
+	if err != nil && !isS3NotFoundError(err) {
+		return "", errors.Wrapf(err, "failed to head %v", src)
+	}
+	srcExists := err == nil
+	_, err = cl.HeadObjectWithContext(ctx, &awss3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(dst),
+	})
+	if err != nil && !isS3NotFoundError(err) {
+		return "", errors.Wrapf(err, "failed to head %v", dst)
+	}
+	dstExists := err == nil
+	res := "skipped"
+	switch {
+	case !srcExists && !dstExists:
+		log.WithFields(log.Fields{"hash": f.Hash, "src": src, "dst": dst}).Warn("object not found under either key")
+		return "missing", nil
+	case !srcExists:
+		return "skipped", nil
+	case !dstExists && isArchiveStorageClass(aws.StringValue(srcHead.StorageClass)):
+		// copies of archived objects fail with InvalidObjectState
+		log.WithFields(log.Fields{"hash": f.Hash, "bucket": bucket, "src": src, "storage_class": aws.StringValue(srcHead.StorageClass)}).Warn("object is archived, skipped")
+		return "archived", nil
+	case !dstExists:
+		if err = copyS3Object(ctx, cl, enc, s.Tags, bucket, src, bucket, dst, aws.StringValue(srcHead.StorageClass)); err != nil {
+			return "", err
+		}
+		res = "copied"
+	}
+	if !s.DeleteSource {
+		return res, nil
+	}
+	if err = LogS3Delete(ctx, s.DB, bucket, src, f.TotalSize, DeleteReasonKeyMigration, ""); err != nil {
+		return "", err
+	}
+	if _, err = cl.DeleteObjectWithContext(ctx, &awss3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(src),
+	}); err != nil {
+		return "", errors.Wrapf(err, "failed to delete %v", src)
+	}
+	if res == "skipped" {
+		res = "deleted"
+	}
+	return res, nil
+}
@@ -0,0 +1,74 @@
+package services
+
+import "testing"
+
+const testKeyHash = "0a1b2c3d4e5f"
+
+func TestParseObjectKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		prefix   string
+		want     string
+		wantErr  bool
+	}{
+		{name: "default", template: "", want: testKeyHash},
+		{name: "flat", template: "{hash}", want: testKeyHash},
+		{name: "prefix", template: "{prefix}/{hash}", prefix: "vault", want: "vault/" + testKeyHash},
+		{name: "prefix trimmed", template: "{prefix}/{hash}", prefix: "/vault/", want: "vault/" + testKeyHash},
+		{name: "empty prefix", template: "{prefix}/{hash}", want: testKeyHash},
+		{name: "sharded", template: "{prefix}/{hash[0:2]}/{hash}", prefix: "p", want: "p/0a/" + testKeyHash},
+		{name: "open start", template: "{hash[:4]}/{hash}", want: "0a1b/" + testKeyHash},
+		{name: "open end", template: "{hash[8:]}/{hash}", want: "4e5f/" + testKeyHash},
+		{name: "slice past end", template: "{hash[10:20]}/{hash}", want: "5f/" + testKeyHash},
+		{name: "slice start past end", template: "{hash[20:30]}/{hash}", want: testKeyHash},
+		{name: "literals", template: "objects/{hash[0:1]}-{hash}.bin", want: "objects/0-" + testKeyHash + ".bin"},
+		{name: "no whole hash", template: "{hash[0:2]}", wantErr: true},
+		{name: "unclosed", template: "{hash", wantErr: true},
+		{name: "unknown placeholder", template: "{name}/{hash}", wantErr: true},
+		{name: "slice without colon", template: "{hash[2]}/{hash}", wantErr: true},
+		{name: "bad slice start", template: "{hash[a:2]}/{hash}", wantErr: true},
+		{name: "bad slice end", template: "{hash[0:b]}/{hash}", wantErr: true},
+		{name: "empty slice", template: "{hash[2:2]}/{hash}", wantErr: true},
+		{name: "reversed slice", template: "{hash[4:2]}/{hash}", wantErr: true},
+		{name: "negative slice", template: "{hash[-1:2]}/{hash}", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := ParseObjectKeys(tt.template, tt.prefix)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got keys %q", keys.Key(testKeyHash))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := keys.Key(testKeyHash); got != tt.want {
+				t.Errorf("Key() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestObjectKeysChunkKey(t *testing.T) {
+	keys, err := ParseObjectKeys("{prefix}/{hash}", "p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := keys.ChunkKey(testKeyHash), "p/"+chunkKeyPrefix+"0a/"+testKeyHash; got != want {
+		t.Errorf("ChunkKey() = %q, want %q", got, want)
+	}
+}
+
+func TestKeyMigrationReportAdd(t *testing.T) {
+	r := &KeyMigrationReport{}
+	for _, res := range []string{"copied", "deleted", "skipped", "missing", "archived", "copied"} {
+		r.add(res, true)
+	}
+	want := KeyMigrationReport{Files: 6, Copied: 2, Deleted: 3, Skipped: 2, Missing: 1, Archived: 1}
+	if *r != want {
+		t.Errorf("report = %+v, want %+v", *r, want)
+	}
+}
@@ -0,0 +1,192 @@
+package services
+
+import (
+	"context"
+	"net/url"
+	"path"
+	"strings"
+	"unicode"
+
+	"github.com/aws/aws-sdk-go/aws"
+	awss3 "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+)
+
+const (
+	s3ObjectTaggingFlag = "s3-object-tagging"
+	s3ObjectTagsFlag    = "s3-object-tags"
+)
+
+// RegisterObjectTagFlags registers CLI flags for tagging of uploaded S3 objects.
+func RegisterObjectTagFlags(f []cli.Flag) []cli.Flag {
+	return append(f,
+		cli.BoolFlag{
+			Name:   s3ObjectTaggingFlag,
+			Usage:  "tag uploaded objects with vault-resource-id and vault-path of the file",
+			EnvVar: "S3_OBJECT_TAGGING",
+		},
+		cli.StringSliceFlag{
+			Name:   s3ObjectTagsFlag,
+			Usage:  "static tags of uploaded objects as key=value, e.g. team=media (enables tagging)",
+			EnvVar: "S3_OBJECT_TAGS",
+		},
+	)
+}
+
+const (
+	objectTagResourceID = "vault-resource-id"
+	objectTagPath       = "vault-path"
+	// S3 limits of object tags
+	maxObjectTags           = 10
+	maxObjectTagKeyLength   = 128
+	maxObjectTagValueLength = 256
+)
+
+// User metadata of uploaded objects.
+const (
+	objectMetaFilename = "Vault-Filename"
+	objectMetaHashAlgo = "Vault-Hash-Algo"
+	// maxObjectMetaFilenameLength keeps metadata well below the 2KB limit of S3
+	maxObjectMetaFilenameLength = 1024
+)
+
+// objectTagChar reports whether S3 allows r in tag keys and values.
+func objectTagChar(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune(" +-=._:/@", r)
+}
+
+// objectTagValue replaces characters S3 does not allow in tags with "_" and keeps the tail of long values,
+// which is the most specific part of a path.
+func objectTagValue(v string) string {
+	rs := []rune(strings.Map(func(r rune) rune {
+		if objectTagChar(r) {
+			return r
+		}
+		return '_'
+	}, v))
+	if len(rs) > maxObjectTagValueLength {
+		rs = rs[len(rs)-maxObjectTagValueLength:]
+	}
+	return string(rs)
+}
+
+func parseObjectTag(s string) (string, string, error) {
+	k, v, ok := strings.Cut(s, "=")
+	k, v = strings.TrimSpace(k), strings.TrimSpace(v)
+	switch {
+	case !ok || k == "":
+		return "", "", errors.Errorf("failed to parse object tag %q: expected key=value", s)
+	case len([]rune(k)) > maxObjectTagKeyLength || len([]rune(v)) > maxObjectTagValueLength:
+		return "", "", errors.Errorf("failed to parse object tag %q: key is limited to %v and value to %v characters", s, maxObjectTagKeyLength, maxObjectTagValueLength)
+	case strings.HasPrefix(strings.ToLower(k), "aws:"):
+		return "", "", errors.Errorf("failed to parse object tag %q: aws: prefix is reserved", s)
+	case k == objectTagResourceID || k == objectTagPath:
+		return "", "", errors.Errorf("failed to parse object tag %q: %v is set by vault", s, k)
+	case strings.IndexFunc(k+v, func(r rune) bool { return !objectTagChar(r) }) >= 0:
+		return "", "", errors.Errorf("failed to parse object tag %q: only letters, digits, spaces and + - = . _ : / @ are allowed", s)
+	}
+	return k, v, nil
+}
+
+// ObjectTags tags uploaded objects, so bucket lifecycle policies and cost allocation reports can select
+// them. Objects are shared by resources with the same content, so they are tagged with the resource
+// and path which stored them first.
+type ObjectTags struct {
+	resource bool
+	static   url.Values
+}
+
+// NewObjectTags returns nil if objects are not tagged.
+func NewObjectTags(c *cli.Context) (*ObjectTags, error) {
+	s := &ObjectTags{resource: c.Bool(s3ObjectTaggingFlag), static: url.Values{}}
+	for _, v := range c.StringSlice(s3ObjectTagsFlag) {
+		k, v, err := parseObjectTag(v)
+		if err != nil {
+			return nil, err
+		}
+		if s.static.Has(k) {
+			return nil, errors.Errorf("object tag %v is set twice", k)
+		}
+		s.static.Set(k, v)
+	}
+	if !s.resource && len(s.static) == 0 {
+		return nil, nil
+	}
+	n := len(s.static)
+	if s.resource {
+		n += 2
+	}
+	if n > maxObjectTags {
+		return nil, errors.Errorf("objects are limited to %v tags, %v configured", maxObjectTags, n)
+	}
+	return s, nil
+}
+
+// Tagging returns the tag set of the object of the file at path stored by the resource, nil if objects
+// are not tagged.
+func (s *ObjectTags) Tagging(resourceID, path string) *string {
+	if s == nil {
+		return nil
+	}
+	v := url.Values{}
+	for k := range s.static {
+		v.Set(k, s.static.Get(k))
+	}
+	if s.resource {
+		v.Set(objectTagResourceID, objectTagValue(resourceID))
+		v.Set(objectTagPath, objectTagValue(path))
+	}
+	return aws.String(v.Encode())
+}
+
+// Static returns the tag set of objects shared by files, such as chunks, nil if there are no static tags.
+func (s *ObjectTags) Static() *string {
+	if s == nil || len(s.static) == 0 {
+		return nil
+	}
+	return aws.String(s.static.Encode())
+}
+
+// copyTagging returns the tag set of the object to be set on its copy, nil if objects are not tagged.
+// Single request copies keep tags on their own, multipart copies and replicas need them explicitly.
+func (s *ObjectTags) copyTagging(ctx context.Context, cl *awss3.S3, bucket, key string) (*string, error) {
+	if s == nil {
+		return nil, nil
+	}
+	out, err := cl.GetObjectTaggingWithContext(ctx, &awss3.GetObjectTaggingInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get tags of %v", key)
+	}
+	if len(out.TagSet) == 0 {
+		return nil, nil
+	}
+	v := url.Values{}
+	for _, t := range out.TagSet {
+		v.Set(aws.StringValue(t.Key), aws.StringValue(t.Value))
+	}
+	return aws.String(v.Encode()), nil
+}
+
+// setObjectMetadata sets user metadata of the object of the file at p hashed with algo: percent-encoded
+// base name of the file, which is lost in content addressed keys, and the hash algorithm of the key.
+func setObjectMetadata(in *s3manager.UploadInput, p string, algo HashAlgo) {
+	if in.Metadata == nil {
+		in.Metadata = map[string]*string{}
+	}
+	// long names are cut at a character boundary
+	var name strings.Builder
+	for _, r := range path.Base(p) {
+		e := url.PathEscape(string(r))
+		if name.Len()+len(e) > maxObjectMetaFilenameLength {
+			break
+		}
+		name.WriteString(e)
+	}
+	in.Metadata[objectMetaFilename] = aws.String(name.String())
+	in.Metadata[objectMetaHashAlgo] = aws.String(string(algo))
+}
@@ -0,0 +1,213 @@
+package services
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultListLimit = 50
+	maxListLimit     = 1000
+)
+
+// OperationsResponse is a page of operation logs.
+type OperationsResponse struct {
+	Operations []OperationLog `json:"operations"`
+	Total      int            `json:"total"`
+	Limit      int            `json:"limit"`
+	Offset     int            `json:"offset"`
+}
+
+// parseListLimits parses limit and offset query params.
+func parseListLimits(c *gin.Context) (limit int, offset int, err error) {
+	limit = defaultListLimit
+	if v := c.Query("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			return 0, 0, errors.Errorf("failed to parse limit %v", v)
+		}
+		if limit > maxListLimit {
+			limit = maxListLimit
+		}
+	}
+	if v := c.Query("offset"); v != "" {
+		offset, err = strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			return 0, 0, errors.Errorf("failed to parse offset %v", v)
+		}
+	}
+	return
+}
+
+// parseIntParam parses optional integer query parameter within [lo, hi], def is returned if it is not set.
+func parseIntParam(c *gin.Context, name string, def, lo, hi int) (int, error) {
+	v := c.Query(name)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < lo || n > hi {
+		return 0, errors.Errorf("failed to parse %v %v: must be between %v and %v", name, v, lo, hi)
+	}
+	return n, nil
+}
+
+func parseTimeParam(c *gin.Context, name string) (*time.Time, error) {
+	v := c.Query(name)
+	if v == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse %v", name)
+	}
+	return &t, nil
+}
+
+func parseOperationLogFilter(c *gin.Context) (*OperationLogFilter, error) {
+	f := &OperationLogFilter{}
+	var err error
+	if f.Limit, f.Offset, err = parseListLimits(c); err != nil {
+		return nil, err
+	}
+	if v := c.Query("type"); v != "" {
+		t, err := ParseOperationType(v)
+		if err != nil {
+			return nil, err
+		}
+		f.Type = &t
+	}
+	if v := c.Query("status"); v != "" {
+		st, err := ParseOperationStatus(v)
+		if err != nil {
+			return nil, err
+		}
+		f.Status = st
+		f.Running = st == nil
+	}
+	if f.From, err = parseTimeParam(c, "from"); err != nil {
+		return nil, err
+	}
+	if f.To, err = parseTimeParam(c, "to"); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// GET /resource/{id}/operations
+// getResourceOperations godoc
+// @Summary      List operations of a resource
+// @Description  Returns store/delete history of the resource, newest first
+// @Tags         operations
+// @Param        id      path      string  true   "Resource ID"
+// @Param        type    query     string  false  "Operation type: store or delete"
+// @Param        status  query     string  false  "Operation status: success, fail or running"
+// @Param        from    query     string  false  "Started at or after (RFC3339)"
+// @Param        to      query     string  false  "Started before (RFC3339)"
+// @Param        limit   query     int     false  "Page size (default 50, max 1000)"
+// @Param        offset  query     int     false  "Page offset"
+// @Success      200     {object}  OperationsResponse
+// @Failure      400     {object}  ErrorResponse
+// @Failure      500     {object}  ErrorResponse
+// @Router       /resource/{id}/operations [get]
+func (s *Web) getResourceOperations(c *gin.Context) {
+	s.listOperations(c, c.Param("id"))
+}
+
+// GET /operations
+// getOperations godoc
+// @Summary      List operations
+// @Description  Returns store/delete history of all resources, newest first
+// @Tags         operations
+// @Param        resource_id  query     string  false  "Resource ID"
+// @Param        type         query     string  false  "Operation type: store or delete"
+// @Param        status       query     string  false  "Operation status: success, fail or running"
+// @Param        from         query     string  false  "Started at or after (RFC3339)"
+// @Param        to           query     string  false  "Started before (RFC3339)"
+// @Param        limit        query     int     false  "Page size (default 50, max 1000)"
+// @Param        offset       query     int     false  "Page offset"
+// @Success      200          {object}  OperationsResponse
+// @Failure      400          {object}  ErrorResponse
+// @Failure      500          {object}  ErrorResponse
+// @Router       /operations [get]
+func (s *Web) getOperations(c *gin.Context) {
+	s.listOperations(c, c.Query("resource_id"))
+}
+
+func (s *Web) listOperations(c *gin.Context, resourceID string) {
+	db := s.pg.Read()
+	if db == nil {
+		_ = c.Error(errors.New("DB not configured"))
+		return
+	}
+	f, err := parseOperationLogFilter(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	f.ResourceID = resourceID
+	list, total, err := OperationLogList(c.Request.Context(), db, f)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, &OperationsResponse{
+		Operations: list,
+		Total:      total,
+		Limit:      f.Limit,
+		Offset:     f.Offset,
+	})
+}
+
+// OperationStatsDailyResponse lists daily operation stats, newest first.
+type OperationStatsDailyResponse struct {
+	Days []OperationStatsDaily `json:"days"`
+}
+
+// GET /operations/daily
+// getOperationStatsDaily godoc
+// @Summary      Daily operation stats
+// @Description  Returns counts and durations of store/delete operations finished per UTC day, newest first.
+// @Description  Operation logs pruned by log retention are kept rolled up, so stats cover days past retention.
+// @Tags         operations
+// @Param        type  query     string  false  "Operation type: store or delete"
+// @Param        days  query     int     false  "Number of days including today (default 30, max 3660)"
+// @Success      200   {object}  OperationStatsDailyResponse
+// @Failure      400   {object}  ErrorResponse
+// @Failure      500   {object}  ErrorResponse
+// @Router       /operations/daily [get]
+func (s *Web) getOperationStatsDaily(c *gin.Context) {
+	db := s.pg.Read()
+	if db == nil {
+		_ = c.Error(errors.New("DB not configured"))
+		return
+	}
+	days, err := parseIntParam(c, "days", 30, 1, 3660)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	var typ *OperationType
+	if v := c.Query("type"); v != "" {
+		t, err := ParseOperationType(v)
+		if err != nil {
+			_ = c.Error(err)
+			return
+		}
+		typ = &t
+	}
+	from := time.Now().UTC().AddDate(0, 0, 1-days)
+	list, err := OperationStatsDailyList(c.Request.Context(), db, from, typ)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if list == nil {
+		list = []OperationStatsDaily{}
+	}
+	c.JSON(http.StatusOK, &OperationStatsDailyResponse{Days: list})
+}
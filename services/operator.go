@@ -0,0 +1,559 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+const (
+	operatorURLFlag          = "url"
+	operatorTokenFlag        = "token"
+	operatorDirectFlag       = "direct"
+	operatorNoWaitFlag       = "no-wait"
+	operatorTimeoutFlag      = "timeout"
+	operatorPollIntervalFlag = "poll-interval"
+	operatorTTLFlag          = "ttl"
+
+	operatorStatusFlag             = "status"
+	operatorOlderThanFlag          = "older-than"
+	operatorLastAccessedBeforeFlag = "last-accessed-before"
+	operatorSelectorFlag           = "selector"
+	operatorDryRunFlag             = "dry-run"
+)
+
+// ErrWaitTimeout is returned when a queued resource does not reach its final status in time.
+var ErrWaitTimeout = errors.New("timed out waiting for resource")
+
+// RegisterOperatorFlags registers CLI flags of operator commands queueing stores and deletions.
+func RegisterOperatorFlags(f []cli.Flag) []cli.Flag {
+	return append(f,
+		cli.StringFlag{
+			Name:   operatorURLFlag,
+			Usage:  "base URL of the running vault API, e.g. http://vault:8080",
+			EnvVar: "VAULT_URL",
+		},
+		cli.StringFlag{
+			Name:   operatorTokenFlag,
+			Usage:  "X-Token of the vault API with store or delete scope, purge requires admin JWT",
+			EnvVar: "VAULT_API_TOKEN",
+		},
+		cli.BoolFlag{
+			Name:  operatorDirectFlag,
+			Usage: "queue directly in DB instead of calling the API, tenant quotas and ownership are not applied",
+		},
+		cli.StringFlag{
+			Name:   notifyChannelFlag,
+			Usage:  "postgres LISTEN/NOTIFY channel notified after queueing with --direct (empty disables)",
+			EnvVar: "NOTIFY_CHANNEL",
+		},
+		cli.BoolFlag{
+			Name:  operatorNoWaitFlag,
+			Usage: "exit once queued instead of waiting for completion",
+		},
+		cli.DurationFlag{
+			Name:  operatorTimeoutFlag,
+			Usage: "max time of waiting for a resource (0 waits forever)",
+		},
+		cli.DurationFlag{
+			Name:  operatorPollIntervalFlag,
+			Usage: "interval of polling the resource while waiting",
+			Value: 2 * time.Second,
+		},
+	)
+}
+
+// RegisterOperatorStoreFlags registers CLI flags of the store command only.
+func RegisterOperatorStoreFlags(f []cli.Flag) []cli.Flag {
+	return append(f,
+		cli.StringFlag{
+			Name:  operatorTTLFlag,
+			Usage: "time to live of the stored resource (Go duration, e.g. 720h)",
+		},
+	)
+}
+
+// RegisterOperatorPurgeFlags registers CLI flags of the purge command only.
+func RegisterOperatorPurgeFlags(f []cli.Flag) []cli.Flag {
+	return append(f,
+		cli.StringFlag{
+			Name:  operatorStatusFlag,
+			Usage: "purge resources in this status, e.g. store_error",
+		},
+		cli.StringFlag{
+			Name:  operatorOlderThanFlag,
+			Usage: "purge resources created earlier than this long ago (Go duration or days, e.g. 30d)",
+		},
+		cli.StringFlag{
+			Name:  operatorLastAccessedBeforeFlag,
+			Usage: "purge resources not served by webseed since then (RFC3339)",
+		},
+		cli.StringFlag{
+			Name:  operatorSelectorFlag,
+			Usage: "purge only resources matching label selector, e.g. tenant=x,tier=cold",
+		},
+		cli.BoolFlag{
+			Name:  operatorDryRunFlag,
+			Usage: "only count resources which would be purged",
+		},
+	)
+}
+
+// PurgeRequestFromFlags returns purge request of the purge command.
+func PurgeRequestFromFlags(c *cli.Context) (*PurgeRequest, error) {
+	req := &PurgeRequest{
+		Status:    c.String(operatorStatusFlag),
+		OlderThan: c.String(operatorOlderThanFlag),
+		Selector:  c.String(operatorSelectorFlag),
+		DryRun:    c.Bool(operatorDryRunFlag),
+	}
+	if v := c.String(operatorLastAccessedBeforeFlag); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse %v", operatorLastAccessedBeforeFlag)
+		}
+		req.LastAccessedBefore = &t
+	}
+	// validated here, so mistakes are reported before anything is queued
+	if _, err := req.operation(time.Now()); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// OperatorResult is the outcome of a queued store or deletion of a resource.
+type OperatorResult struct {
+	ResourceID string `json:"resource_id"`
+	OK         bool   `json:"ok"`
+	// Status is the last seen status, empty if the resource is gone
+	Status string `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
+	// TimedOut is set if the resource was still being processed when waiting for it timed out
+	TimedOut bool `json:"timed_out,omitempty"`
+}
+
+// Operator queues stores, deletions and purges of resources through the API of a running vault or
+// directly in DB and waits until workers complete them.
+type Operator struct {
+	pg            *PG
+	url           string
+	token         string
+	direct        bool
+	notifyChannel string
+	wait          bool
+	timeout       time.Duration
+	interval      time.Duration
+	ttl           string
+	cl            *http.Client
+	out           io.Writer
+	// tty enables redrawing of the progress line, otherwise status changes are printed line by line
+	tty bool
+}
+
+// NewOperator returns operator of commands, pg is only used with --direct.
+func NewOperator(c *cli.Context, pg *PG) (*Operator, error) {
+	s := &Operator{
+		pg:            pg,
+		url:           strings.TrimSuffix(c.String(operatorURLFlag), "/"),
+		token:         c.String(operatorTokenFlag),
+		direct:        c.Bool(operatorDirectFlag),
+		notifyChannel: c.String(notifyChannelFlag),
+		wait:          !c.Bool(operatorNoWaitFlag),
+		timeout:       c.Duration(operatorTimeoutFlag),
+		interval:      c.Duration(operatorPollIntervalFlag),
+		ttl:           c.String(operatorTTLFlag),
+		cl:            &http.Client{Timeout: time.Minute},
+		out:           os.Stderr,
+	}
+	if s.direct {
+		if pg == nil || pg.Get() == nil {
+			return nil, errors.New("DB not configured")
+		}
+	} else if pu, err := url.Parse(s.url); err != nil || (pu.Scheme != "http" && pu.Scheme != "https") || pu.Host == "" {
+		return nil, errors.Errorf("failed to parse %v %q: http or https URL is required unless --%v is set", operatorURLFlag, s.url, operatorDirectFlag)
+	}
+	if s.timeout < 0 || s.interval <= 0 {
+		return nil, errors.New("timeout must not be negative and poll interval must be positive")
+	}
+	if s.ttl != "" {
+		if _, _, err := (&ExpiryRequest{TTL: s.ttl}).expiry(time.Now()); err != nil {
+			return nil, err
+		}
+	}
+	if fi, err := os.Stderr.Stat(); err == nil && fi.Mode()&os.ModeCharDevice != 0 {
+		s.tty = true
+	}
+	return s, nil
+}
+
+// Store queues storing of resources and waits until they are stored.
+func (s *Operator) Store(ctx context.Context, ids []string) []OperatorResult {
+	return s.run(ctx, ids, s.queueStore, storeDone)
+}
+
+// Remove queues deletion of resources and waits until they are deleted or pending purge.
+func (s *Operator) Remove(ctx context.Context, ids []string) []OperatorResult {
+	return s.run(ctx, ids, s.queueDelete, deleteDone)
+}
+
+// storeDone returns true once the resource is stored, failed statuses are returned as error.
+func storeDone(r *Resource) (bool, error) {
+	if r == nil {
+		return false, errors.New("resource was deleted while storing")
+	}
+	switch r.Status {
+	case StatusStored:
+		return true, nil
+	case StatusQueuedForStoring, StatusStoring:
+		return false, nil
+	case StatusStoreError:
+		return false, errors.Errorf("store failed: %v", resourceError(r))
+	}
+	return false, errors.Errorf("resource is %v", r.Status)
+}
+
+// deleteDone returns true once the resource is gone or soft deleted, failed statuses are returned as error.
+func deleteDone(r *Resource) (bool, error) {
+	if r == nil || r.Status == StatusPendingPurge {
+		return true, nil
+	}
+	switch r.Status {
+	case StatusQueuedForDeletion, StatusDeleting:
+		return false, nil
+	case StatusDeleteError:
+		return false, errors.Errorf("delete failed: %v", resourceError(r))
+	}
+	return false, errors.Errorf("resource was queued again, it is %v", r.Status)
+}
+
+// run queues all resources first, so workers process them in parallel, then waits for them one by one.
+func (s *Operator) run(ctx context.Context, ids []string, queue func(ctx context.Context, id string) (*Resource, error), done func(r *Resource) (bool, error)) []OperatorResult {
+	results := make([]OperatorResult, len(ids))
+	queued := make([]*Resource, len(ids))
+	for i, id := range ids {
+		results[i].ResourceID = id
+		r, err := queue(ctx, id)
+		if err != nil {
+			results[i].Error = err.Error()
+			log.WithError(err).WithField("resource_id", id).Error("failed to queue")
+			continue
+		}
+		queued[i] = r
+		results[i].OK = true
+		if r != nil {
+			results[i].Status = r.Status.String()
+		}
+		log.WithFields(log.Fields{"resource_id": id, "status": results[i].Status}).Info("queued")
+	}
+	if s.direct {
+		s.notify(ctx)
+	}
+	if !s.wait {
+		return results
+	}
+	for i, id := range ids {
+		if !results[i].OK {
+			continue
+		}
+		r, err := s.waitFor(ctx, id, queued[i], done)
+		results[i].Status = ""
+		if r != nil {
+			results[i].Status = r.Status.String()
+		}
+		if err != nil {
+			results[i].OK = false
+			results[i].Error = err.Error()
+			results[i].TimedOut = errors.Is(err, ErrWaitTimeout)
+			log.WithError(err).WithField("resource_id", id).Error("failed")
+			continue
+		}
+		log.WithFields(log.Fields{"resource_id": id, "status": results[i].Status}).Info("done")
+	}
+	return results
+}
+
+// waitFor polls the resource and draws its progress until done returns true, an error or the timeout.
+func (s *Operator) waitFor(ctx context.Context, id string, r *Resource, done func(r *Resource) (bool, error)) (*Resource, error) {
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+	last, lastStatus := "", ""
+	defer func() {
+		if s.tty && last != "" {
+			_, _ = fmt.Fprintln(s.out)
+		}
+	}()
+	for {
+		ok, err := done(r)
+		// without a tty lines are only printed when the status changes rather than on every byte stored
+		line, status := progressLine(id, r), statusOf(r)
+		if s.tty && line != last {
+			_, _ = fmt.Fprintf(s.out, "\r\033[K%v", line)
+		} else if !s.tty && status != lastStatus {
+			_, _ = fmt.Fprintln(s.out, line)
+		}
+		last, lastStatus = line, status
+		if err != nil || ok {
+			return r, err
+		}
+		select {
+		case <-ctx.Done():
+			return r, errors.Wrapf(ErrWaitTimeout, "%v is still %v", id, statusOf(r))
+		case <-time.After(s.interval):
+		}
+		if r, err = s.get(ctx, id); err != nil {
+			if ctx.Err() != nil {
+				return r, errors.Wrapf(ErrWaitTimeout, "%v", id)
+			}
+			return nil, err
+		}
+	}
+}
+
+func statusOf(r *Resource) string {
+	if r == nil {
+		return "gone"
+	}
+	return r.Status.String()
+}
+
+// progressLine renders a bar of stored size while storing followed by the phase of the job,
+// other statuses are rendered without it.
+func progressLine(id string, r *Resource) string {
+	if r == nil || (r.Status != StatusStoring && r.Status != StatusStored) || r.TotalSize <= 0 {
+		return fmt.Sprintf("%v %v", id, statusOf(r))
+	}
+	const width = 30
+	ratio := float64(r.StoredSize) / float64(r.TotalSize)
+	ratio = min(max(ratio, 0), 1)
+	filled := int(ratio * width)
+	line := fmt.Sprintf("%v %v [%v%v] %5.1f%% %v/%v", id, r.Status,
+		strings.Repeat("#", filled), strings.Repeat("-", width-filled), ratio*100,
+		formatBytes(r.StoredSize), formatBytes(r.TotalSize))
+	if r.Status == StatusStoring && r.Progress != nil {
+		line += ", " + r.Progress.String()
+	}
+	return line
+}
+
+// formatBytes renders size with binary units.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func (s *Operator) queueStore(ctx context.Context, id string) (*Resource, error) {
+	if !s.direct {
+		req := &StoreRequest{ExpiryRequest: ExpiryRequest{TTL: s.ttl}}
+		return s.request(ctx, http.MethodPut, id, req)
+	}
+	expiresAt, setExpiry, err := (&ExpiryRequest{TTL: s.ttl}).expiry(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	var res *Resource
+	err = s.pg.Get().RunInTransaction(ctx, func(tx *pg.Tx) error {
+		var err error
+		if res, err = ResourceQueueForStoring(ctx, tx, id); err != nil || !setExpiry {
+			return err
+		}
+		res, err = ResourceSetExpiry(ctx, tx, id, expiresAt)
+		return err
+	})
+	return res, err
+}
+
+// queueDelete returns nil if the resource does not exist or was only queued for storing and is deleted right away.
+func (s *Operator) queueDelete(ctx context.Context, id string) (*Resource, error) {
+	if !s.direct {
+		return s.request(ctx, http.MethodDelete, id, nil)
+	}
+	var res *Resource
+	err := s.pg.Get().RunInTransaction(ctx, func(tx *pg.Tx) (err error) {
+		res, err = ResourceQueueForDeletion(ctx, tx, id)
+		return
+	})
+	return res, err
+}
+
+// get returns nil if the resource does not exist.
+func (s *Operator) get(ctx context.Context, id string) (*Resource, error) {
+	if s.direct {
+		return ResourceGetByID(ctx, s.pg.Get(), id)
+	}
+	return s.request(ctx, http.MethodGet, id, nil)
+}
+
+// notify wakes workers up, so resources queued in DB are picked up without waiting for their poll.
+func (s *Operator) notify(ctx context.Context) {
+	if s.notifyChannel == "" {
+		return
+	}
+	if _, err := s.pg.Get().ExecContext(ctx, "SELECT pg_notify(?, '')", s.notifyChannel); err != nil {
+		log.WithError(err).Warn("failed to notify workers")
+	}
+}
+
+// Purge queues deletion of resources matched by req and waits until the bulk operation deleting them
+// finishes. Dry run only counts them.
+func (s *Operator) Purge(ctx context.Context, req *PurgeRequest) (*PurgeResponse, error) {
+	var res *PurgeResponse
+	var err error
+	if s.direct {
+		res, err = Purge(ctx, s.pg.Get(), req)
+		if err == nil && !req.DryRun {
+			s.notify(ctx)
+		}
+	} else {
+		res = &PurgeResponse{}
+		var found bool
+		if found, err = s.call(ctx, http.MethodPost, "/resources/purge", req, res); err == nil && !found {
+			err = errors.New("purge is not supported by the API")
+		}
+	}
+	if err != nil || res.BulkOperation == nil || !s.wait {
+		return res, err
+	}
+	res.BulkOperation, err = s.waitForBulk(ctx, res.BulkOperation)
+	return res, err
+}
+
+// waitForBulk polls the bulk operation until it is finished or the timeout.
+func (s *Operator) waitForBulk(ctx context.Context, op *BulkOperation) (*BulkOperation, error) {
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+	last := ""
+	for {
+		line := bulkProgressLine(op)
+		if line != last {
+			_, _ = fmt.Fprintln(s.out, line)
+		}
+		last = line
+		switch op.Status {
+		case BulkStatusDone:
+			return op, nil
+		case BulkStatusFailed, BulkStatusCancelled:
+			return op, errors.Errorf("bulk operation %v is %v", op.BulkID, op.Status)
+		}
+		select {
+		case <-ctx.Done():
+			return op, errors.Wrapf(ErrWaitTimeout, "bulk operation %v is still %v", op.BulkID, op.Status)
+		case <-time.After(s.interval):
+		}
+		cur, err := s.getBulk(ctx, op.BulkID.String())
+		if err != nil {
+			if ctx.Err() != nil {
+				return op, errors.Wrapf(ErrWaitTimeout, "bulk operation %v", op.BulkID)
+			}
+			return op, err
+		}
+		if cur == nil {
+			return op, errors.Errorf("bulk operation %v not found", op.BulkID)
+		}
+		op = cur
+	}
+}
+
+func bulkProgressLine(op *BulkOperation) string {
+	total := "?"
+	if op.Total != nil {
+		total = fmt.Sprint(*op.Total)
+	}
+	return fmt.Sprintf("%v %v %v/%v processed, %v failed", op.BulkID, op.Status, op.Processed, total, op.Failed)
+}
+
+// getBulk returns nil if the bulk operation does not exist.
+func (s *Operator) getBulk(ctx context.Context, id string) (*BulkOperation, error) {
+	if s.direct {
+		bid, err := uuid.Parse(id)
+		if err != nil {
+			return nil, err
+		}
+		return BulkOperationGet(ctx, s.pg.Get(), bid)
+	}
+	var out BulkOperationResponse
+	found, err := s.call(ctx, http.MethodGet, "/admin/bulk/"+url.PathEscape(id)+"?limit=1", nil, &out)
+	if err != nil || !found {
+		return nil, err
+	}
+	return out.Operation, nil
+}
+
+// request calls resource endpoint of the API, nil is returned if the resource is not found.
+func (s *Operator) request(ctx context.Context, method, id string, body any) (*Resource, error) {
+	var out struct {
+		Resource *Resource `json:"resource"`
+	}
+	if _, err := s.call(ctx, method, "/resource/"+url.PathEscape(id), body, &out); err != nil {
+		return nil, err
+	}
+	return out.Resource, nil
+}
+
+// call calls the API decoding the response into out, false is returned if it responds with 404.
+func (s *Operator) call(ctx context.Context, method, path string, body any, out any) (bool, error) {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return false, err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, s.url+path, r)
+	if err != nil {
+		return false, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if s.token != "" {
+		req.Header.Set("X-Token", s.token)
+	}
+	res, err := s.cl.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = res.Body.Close() }()
+	if res.StatusCode == http.StatusNotFound {
+		_, _ = io.Copy(io.Discard, res.Body)
+		return false, nil
+	}
+	if res.StatusCode >= http.StatusMultipleChoices {
+		var er ErrorResponse
+		if err := json.NewDecoder(res.Body).Decode(&er); err != nil || er.Error == "" {
+			er.Error = res.Status
+		}
+		return false, errors.Errorf("%v %v: %v", method, req.URL.Path, er.Error)
+	}
+	if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+		return false, errors.Wrapf(err, "failed to decode response of %v %v", method, req.URL.Path)
+	}
+	return true, nil
+}
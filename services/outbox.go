@@ -0,0 +1,219 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	awss3 "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// outboxBatchSize is the number of deletions claimed at once
+	outboxBatchSize = 100
+	// outboxLeaseTTL covers a batch of deletions, leases of dead instances are taken over earlier
+	outboxLeaseTTL   = 5 * time.Minute
+	outboxMinBackoff = 10 * time.Second
+	outboxMaxBackoff = time.Hour
+	// tempObjectTTL delays deletion of a temporary upload recorded before it starts, it is scheduled
+	// right away once the upload finishes and only runs late if the job crashed
+	tempObjectTTL = 24 * time.Hour
+)
+
+// outboxBackoff is the delay before the next deletion attempt after attempts failures.
+func outboxBackoff(attempts int) time.Duration {
+	d := outboxMinBackoff
+	for i := 0; i < attempts && d < outboxMaxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, outboxMaxBackoff)
+}
+
+// addFileDeletes records deletion of objects of the file in the bucket it is stored in and the replica.
+func (s *Worker) addFileDeletes(ctx context.Context, db pg.DBI, f *File, size int64, reason DeleteReason, resourceID string) error {
+	buckets := []string{f.bucketOr(s.bucket)}
+	if s.replica != nil {
+		buckets = append(buckets, s.replica.bucket)
+	}
+	for _, b := range buckets {
+		e := &S3OutboxEntry{
+			Bucket:   b,
+			Key:      s.keys.Key(f.Hash),
+			Size:     size,
+			Reason:   reason,
+			FileHash: &f.Hash,
+		}
+		if resourceID != "" {
+			e.ResourceID = &resourceID
+		}
+		if err := S3OutboxAdd(ctx, db, e, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addChunkDeletes records deletion of chunks of the chunked file which no other file references.
+func (s *Worker) addChunkDeletes(ctx context.Context, db pg.DBI, f *File, resourceID string) error {
+	chunks, err := ChunkListReleased(ctx, db, f.Hash)
+	if err != nil {
+		return err
+	}
+	for i := range chunks {
+		c := &chunks[i]
+		e := &S3OutboxEntry{
+			Bucket:    s.bucket,
+			Key:       s.keys.ChunkKey(c.Hash),
+			Size:      c.Size,
+			Reason:    DeleteReasonRefcountZero,
+			ChunkHash: &c.Hash,
+		}
+		if resourceID != "" {
+			e.ResourceID = &resourceID
+		}
+		if err := S3OutboxAdd(ctx, db, e, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dispatchOutbox executes due deletions of the outbox. Deletions are idempotent, so one executed
+// by an instance which died before completing it is just repeated.
+func (s *Worker) dispatchOutbox(ctx context.Context, db *pg.DB) error {
+	list, err := S3OutboxClaim(ctx, db, s.id, outboxLeaseTTL, s.deadAfter, outboxBatchSize)
+	if err != nil {
+		return err
+	}
+	for i := range list {
+		if ctx.Err() != nil {
+			// resumed once the lease expires
+			return nil
+		}
+		s.dispatchDelete(ctx, db, &list[i])
+	}
+	return nil
+}
+
+// dispatchDelete executes the deletion in a span of the trace of the job which recorded it.
+func (s *Worker) dispatchDelete(ctx context.Context, db *pg.DB, e *S3OutboxEntry) {
+	ctx, span := tracer.Start(withTraceContext(ctx, e.TraceContext), "s3.delete", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("bucket", e.Bucket),
+		attribute.String("key", e.Key),
+		attribute.String("reason", string(e.Reason)),
+		attribute.Int("attempt", e.Attempts+1),
+	))
+	var err error
+	defer func() { endSpan(span, err) }()
+	if e.ResourceID != nil {
+		span.SetAttributes(attribute.String("resource_id", *e.ResourceID))
+	}
+	l := logger(ctx).WithFields(log.Fields{"bucket": e.Bucket, "key": e.Key, "reason": e.Reason})
+	dropped, err := s.executeDelete(ctx, db, e)
+	if err != nil {
+		promS3OutboxDeletes.WithLabelValues("failed").Inc()
+		backoff := outboxBackoff(e.Attempts)
+		l.WithError(err).WithField("retry_in", backoff).Warn("failed to delete from s3")
+		if err := S3OutboxFail(ctx, db, e, err.Error(), backoff); err != nil {
+			l.WithError(err).Error("failed to update outbox")
+		}
+		return
+	}
+	if dropped {
+		span.SetAttributes(attribute.Bool("dropped", true))
+		promS3OutboxDeletes.WithLabelValues("dropped").Inc()
+		if err := S3OutboxComplete(ctx, db, e); err != nil {
+			l.WithError(err).Error("failed to update outbox")
+			return
+		}
+		l.Info("deletion dropped, content is referenced again")
+		return
+	}
+	promS3OutboxDeletes.WithLabelValues("done").Inc()
+	resourceID := ""
+	if e.ResourceID != nil {
+		resourceID = *e.ResourceID
+	}
+	err = db.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		if err := LogS3Delete(ctx, tx, e.Bucket, e.Key, e.Size, e.Reason, resourceID); err != nil {
+			return err
+		}
+		return S3OutboxComplete(ctx, tx, e)
+	})
+	if err != nil {
+		l.WithError(err).Error("failed to update outbox")
+		return
+	}
+	l.Info("deleted from s3")
+}
+
+// executeDelete deletes the object unless its file was stored again or its chunk is referenced in the meantime,
+// then the deletion is dropped.
+func (s *Worker) executeDelete(ctx context.Context, db *pg.DB, e *S3OutboxEntry) (dropped bool, err error) {
+	if e.FileHash != nil {
+		return s.executeFileDelete(ctx, db, e)
+	}
+	if e.ChunkHash != nil {
+		return s.executeChunkDelete(ctx, db, e)
+	}
+	return false, s.deleteObject(ctx, e)
+}
+
+// executeFileDelete deletes the object of the file unless the file was stored again. Uploads of the same
+// content are claimed once the object is gone.
+func (s *Worker) executeFileDelete(ctx context.Context, db *pg.DB, e *S3OutboxEntry) (dropped bool, err error) {
+	err = db.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		if err := FileLock(ctx, tx, *e.FileHash, true); err != nil {
+			return err
+		}
+		exists, err := tx.Model((*File)(nil)).Context(ctx).Where("hash = ?", *e.FileHash).Exists()
+		if err != nil {
+			return err
+		}
+		if exists {
+			dropped = true
+			return nil
+		}
+		return s.deleteObject(ctx, e)
+	})
+	return dropped, err
+}
+
+// executeChunkDelete deletes the chunk object and row unless files reference the chunk. Uploads and
+// links of the chunk wait until the object is gone.
+func (s *Worker) executeChunkDelete(ctx context.Context, db *pg.DB, e *S3OutboxEntry) (dropped bool, err error) {
+	err = db.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		if err := ChunkLock(ctx, tx, *e.ChunkHash, true); err != nil {
+			return err
+		}
+		ok, err := ChunkDeleteUnreferenced(ctx, tx, *e.ChunkHash)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			dropped = true
+			return nil
+		}
+		return s.deleteObject(ctx, e)
+	})
+	return dropped, err
+}
+
+func (s *Worker) deleteObject(ctx context.Context, e *S3OutboxEntry) error {
+	cl := s.s3.Get()
+	if s.replica != nil && e.Bucket == s.replica.bucket {
+		cl = s.replica.s3
+	}
+	if _, err := cl.DeleteObjectWithContext(ctx, &awss3.DeleteObjectInput{
+		Bucket: aws.String(e.Bucket),
+		Key:    aws.String(e.Key),
+	}); err != nil {
+		return errors.Wrapf(err, "failed to delete %v", e.Key)
+	}
+	return nil
+}
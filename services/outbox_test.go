@@ -0,0 +1,226 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	awss3 "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/urfave/cli"
+	cs "github.com/webtor-io/common-services"
+)
+
+// testS3 serves objects of a bucket, records requested ranges of GET requests and deleted keys.
+// Deletions of keys in fail are denied.
+type testS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	ranges  []string
+	deleted []string
+	fail    map[string]bool
+}
+
+func (s *testS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// path-style addressing, the first segment is the bucket
+	_, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	s.mu.Lock()
+	if r.Method == http.MethodDelete {
+		if s.fail[key] {
+			s.mu.Unlock()
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = io.WriteString(w, `<Error><Code>AccessDenied</Code><Message>denied</Message></Error>`)
+			return
+		}
+		delete(s.objects, key)
+		s.deleted = append(s.deleted, key)
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	b, ok := s.objects[key]
+	s.ranges = append(s.ranges, key+" "+r.Header.Get("Range"))
+	s.mu.Unlock()
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = io.WriteString(w, `<Error><Code>NoSuchKey</Code><Message>not found</Message></Error>`)
+		return
+	}
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(b))
+}
+
+func (s *testS3) deletedKeys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.deleted...)
+}
+
+func newTestS3Client(t *testing.T, h http.Handler) *awss3.S3 {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	sess, err := session.NewSession(&aws.Config{
+		Endpoint:         aws.String(srv.URL),
+		Region:           aws.String("us-east-1"),
+		Credentials:      credentials.NewStaticCredentials("key", "secret", ""),
+		S3ForcePathStyle: aws.Bool(true),
+		DisableSSL:       aws.Bool(true),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return awss3.New(sess)
+}
+
+// newTestS3ClientFlags returns a client of h configured by flags the way worker gets it.
+func newTestS3ClientFlags(t *testing.T, h http.Handler) *cs.S3Client {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	set := flag.NewFlagSet("test", flag.ContinueOnError)
+	for _, f := range cs.RegisterS3ClientFlags(nil) {
+		f.Apply(set)
+	}
+	for k, v := range map[string]string{
+		"aws-access-key-id":     "key",
+		"aws-secret-access-key": "secret",
+		"aws-endpoint":          srv.URL,
+		"aws-region":            "us-east-1",
+		"aws-no-ssl":            "true",
+	} {
+		if err := set.Set(k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return cs.NewS3Client(cli.NewContext(nil, set, nil), srv.Client())
+}
+
+// testOutboxWorker returns worker deleting from primary bucket "bucket" and replica bucket "replica".
+func testOutboxWorker(t *testing.T, primary, replica *testS3) *Worker {
+	t.Helper()
+	keys, err := ParseObjectKeys("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Worker{
+		id:      "worker",
+		s3:      newTestS3ClientFlags(t, primary),
+		bucket:  "bucket",
+		keys:    keys,
+		replica: &Replica{s3: newTestS3Client(t, replica), bucket: "replica"},
+	}
+}
+
+func TestOutboxBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{attempts: 0, want: 10 * time.Second},
+		{attempts: 1, want: 20 * time.Second},
+		{attempts: 5, want: 320 * time.Second},
+		{attempts: 8, want: 2560 * time.Second},
+		{attempts: 9, want: time.Hour},
+		{attempts: 1000, want: time.Hour},
+	}
+	for _, tt := range tests {
+		if got := outboxBackoff(tt.attempts); got != tt.want {
+			t.Errorf("outboxBackoff(%v) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestExecuteDelete(t *testing.T) {
+	primary := &testS3{fail: map[string]bool{"denied": true}}
+	replica := &testS3{}
+	s := testOutboxWorker(t, primary, replica)
+	ctx := context.Background()
+	for _, e := range []*S3OutboxEntry{
+		{Bucket: "bucket", Key: "a"},
+		{Bucket: "replica", Key: "b"},
+	} {
+		// deletions of objects without file are executed unconditionally
+		if dropped, err := s.executeDelete(ctx, nil, e); err != nil || dropped {
+			t.Fatalf("delete of %v/%v = %v, %v", e.Bucket, e.Key, dropped, err)
+		}
+	}
+	if got := primary.deletedKeys(); !reflect.DeepEqual(got, []string{"a"}) {
+		t.Errorf("deleted from primary %v, want [a]", got)
+	}
+	if got := replica.deletedKeys(); !reflect.DeepEqual(got, []string{"b"}) {
+		t.Errorf("deleted from replica %v, want [b]", got)
+	}
+	if _, err := s.executeDelete(ctx, nil, &S3OutboxEntry{Bucket: "bucket", Key: "denied"}); err == nil {
+		t.Error("expected error of denied deletion")
+	}
+}
+
+func TestDispatchOutbox(t *testing.T) {
+	p := testPG(t)
+	db := p.Get()
+	ctx := context.Background()
+	primary := &testS3{fail: map[string]bool{"denied": true}}
+	replica := &testS3{}
+	s := testOutboxWorker(t, primary, replica)
+
+	gone, kept := &File{Hash: "gone"}, &File{Hash: "kept", Status: StatusStored, HashAlgo: HashAlgoSampled}
+	if _, err := db.Model(kept).Insert(); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []*File{gone, kept} {
+		if err := s.addFileDeletes(ctx, db, f, 10, DeleteReasonRefcountZero, testResourceID(1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	denied := &S3OutboxEntry{Bucket: "bucket", Key: "denied", Reason: DeleteReasonRefcountZero}
+	if err := S3OutboxAdd(ctx, db, denied, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.dispatchOutbox(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+
+	// objects of the file stored again are kept
+	if got, want := primary.deletedKeys(), []string{s.keys.Key("gone")}; !reflect.DeepEqual(got, want) {
+		t.Errorf("deleted from primary %v, want %v", got, want)
+	}
+	if got, want := replica.deletedKeys(), []string{s.keys.Key("gone")}; !reflect.DeepEqual(got, want) {
+		t.Errorf("deleted from replica %v, want %v", got, want)
+	}
+	var audit []S3DeleteAudit
+	if err := db.Model(&audit).Order("bucket").Select(); err != nil {
+		t.Fatal(err)
+	}
+	if len(audit) != 2 || audit[0].Bucket != "bucket" || audit[1].Bucket != "replica" ||
+		audit[0].Key != s.keys.Key("gone") || audit[0].Size != 10 || audit[0].ResourceID == nil {
+		t.Errorf("audit = %+v, want deletions of gone from both buckets", audit)
+	}
+	var left []S3OutboxEntry
+	if err := db.Model(&left).Select(); err != nil {
+		t.Fatal(err)
+	}
+	if len(left) != 1 || left[0].Key != "denied" {
+		t.Fatalf("outbox = %+v, want only the failed deletion", left)
+	}
+	e := left[0]
+	if e.Attempts != 1 || e.Error == nil || e.LeaseOwner != nil || !e.NextAttemptAt.After(time.Now()) {
+		t.Errorf("failed deletion = %+v, want it retried later", e)
+	}
+	// not due yet
+	if err := s.dispatchOutbox(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := db.Model((*S3OutboxEntry)(nil)).Where("attempts = 1").Count(); err != nil || n != 1 {
+		t.Errorf("%v failed deletions after retry before backoff, %v, want 1", n, err)
+	}
+}
@@ -0,0 +1,197 @@
+package services
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+)
+
+const (
+	storeDownloadConcurrencyFlag = "store-download-concurrency"
+	storeDownloadChunkSizeFlag   = "store-download-chunk-size"
+)
+
+// RegisterParallelDownloadFlags registers CLI flags for parallel downloads of stored files.
+func RegisterParallelDownloadFlags(f []cli.Flag) []cli.Flag {
+	return append(f,
+		cli.IntFlag{
+			Name:   storeDownloadConcurrencyFlag,
+			Usage:  "number of concurrent range requests a file is downloaded with from torrent proxy (1 downloads sequentially)",
+			Value:  1,
+			EnvVar: "STORE_DOWNLOAD_CONCURRENCY",
+		},
+		cli.Int64Flag{
+			Name:   storeDownloadChunkSizeFlag,
+			Usage:  "size in bytes of range requests of parallel downloads, smaller files are downloaded sequentially",
+			Value:  16 * 1024 * 1024,
+			EnvVar: "STORE_DOWNLOAD_CHUNK_SIZE",
+		},
+	)
+}
+
+// ParallelDownload downloads large files from torrent proxy with concurrent range requests. Ranges are
+// passed on in order, so at most concurrency ranges are buffered in memory per file.
+type ParallelDownload struct {
+	api         *Api
+	concurrency int
+	chunkSize   int64
+}
+
+// NewParallelDownload returns nil if files are downloaded sequentially.
+func NewParallelDownload(c *cli.Context, api *Api) (*ParallelDownload, error) {
+	n := c.Int(storeDownloadConcurrencyFlag)
+	if n < 1 {
+		return nil, errors.New("store download concurrency must be positive")
+	}
+	if c.Int64(storeDownloadChunkSizeFlag) <= 0 {
+		return nil, errors.New("store download chunk size must be positive")
+	}
+	if n == 1 {
+		return nil, nil
+	}
+	return &ParallelDownload{
+		api:         api,
+		concurrency: n,
+		chunkSize:   c.Int64(storeDownloadChunkSizeFlag),
+	}, nil
+}
+
+// download returns content of the file of size at u, files larger than a range are downloaded in parallel
+// if it is enabled.
+func (s *Worker) download(ctx context.Context, u string, size int64) (io.ReadCloser, error) {
+	if s.parallel == nil || size <= s.parallel.chunkSize {
+		return s.api.Download(ctx, u)
+	}
+	return s.parallel.download(ctx, u, size)
+}
+
+// download returns content of the file of size at u. The first range request tells whether the proxy
+// supports ranges, the whole content it responds with otherwise is read sequentially.
+func (s *ParallelDownload) download(ctx context.Context, u string, size int64) (io.ReadCloser, error) {
+	rc, partial, err := s.api.downloadRange(ctx, u, 0, int(s.chunkSize-1))
+	if err != nil {
+		return nil, err
+	}
+	if !partial {
+		logger(ctx).Debug("torrent proxy ignored range request, downloading sequentially")
+		promStoreParallelDownloads.WithLabelValues("sequential").Inc()
+		return rc, nil
+	}
+	promStoreParallelDownloads.WithLabelValues("parallel").Inc()
+	ctx, cancel := context.WithCancel(ctx)
+	n := int((size + s.chunkSize - 1) / s.chunkSize)
+	r := &parallelReader{
+		ctx:    ctx,
+		cancel: cancel,
+		slots:  make(chan struct{}, s.concurrency),
+		ranges: make([]chan downloadedRange, n),
+	}
+	for i := range r.ranges {
+		r.ranges[i] = make(chan downloadedRange, 1)
+	}
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		for i := 0; i < n; i++ {
+			// a slot is freed once the range is read, which bounds buffered ranges
+			select {
+			case r.slots <- struct{}{}:
+			case <-ctx.Done():
+				if rc != nil {
+					_ = rc.Close()
+				}
+				return
+			}
+			start := int64(i) * s.chunkSize
+			end := min(start+s.chunkSize, size) - 1
+			r.wg.Add(1)
+			go func(i int, first io.ReadCloser) {
+				defer r.wg.Done()
+				data, err := s.fetch(ctx, u, first, start, end)
+				r.ranges[i] <- downloadedRange{data: data, err: err}
+			}(i, rc)
+			rc = nil
+		}
+	}()
+	return r, nil
+}
+
+// fetch reads range [start, end] of the file from rc, requesting it if rc is nil.
+func (s *ParallelDownload) fetch(ctx context.Context, u string, rc io.ReadCloser, start, end int64) ([]byte, error) {
+	if rc == nil {
+		var partial bool
+		var err error
+		rc, partial, err = s.api.downloadRange(ctx, u, int(start), int(end))
+		if err != nil {
+			return nil, err
+		}
+		if !partial {
+			_ = rc.Close()
+			return nil, errors.Errorf("torrent proxy ignored request of range %v-%v", start, end)
+		}
+	}
+	defer func() { _ = rc.Close() }()
+	data := make([]byte, end-start+1)
+	if _, err := io.ReadFull(rc, data); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			// a truncated range is retried like a dropped connection
+			err = &ProxyError{Err: errors.Errorf("torrent proxy responded with truncated range %v-%v", start, end)}
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+type downloadedRange struct {
+	data []byte
+	err  error
+}
+
+// parallelReader reads ranges of the file in order as they are downloaded.
+type parallelReader struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	slots  chan struct{}
+	ranges []chan downloadedRange
+	next   int
+	cur    []byte
+	err    error
+}
+
+func (r *parallelReader) Read(b []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	if len(r.cur) == 0 {
+		if r.next == len(r.ranges) {
+			return 0, io.EOF
+		}
+		var d downloadedRange
+		select {
+		case d = <-r.ranges[r.next]:
+		case <-r.ctx.Done():
+			d.err = r.ctx.Err()
+		}
+		if d.err != nil {
+			r.err = d.err
+			return 0, d.err
+		}
+		r.cur = d.data
+		r.next++
+		<-r.slots
+	}
+	n := copy(b, r.cur)
+	r.cur = r.cur[n:]
+	return n, nil
+}
+
+// Close stops downloading of ranges which are not read yet.
+func (r *parallelReader) Close() error {
+	r.cancel()
+	r.wg.Wait()
+	return nil
+}
@@ -0,0 +1,22 @@
+package services
+
+import (
+	"path"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// NormalizePath brings a path inside a resource to the form it is stored in: NFC normalized,
+// starting with a slash, without repeated separators and dot segments. Trailing slash is kept.
+func NormalizePath(p string) string {
+	if p == "" {
+		return p
+	}
+	dir := strings.HasSuffix(p, "/")
+	p = path.Clean("/" + norm.NFC.String(p))
+	if dir && p != "/" {
+		p += "/"
+	}
+	return p
+}
@@ -0,0 +1,146 @@
+package services
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	awss3 "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+)
+
+const (
+	presignExpiryFlag    = "presign-expiry"
+	presignMaxExpiryFlag = "presign-max-expiry"
+)
+
+// RegisterPresignFlags registers CLI flags for pre-signed S3 download urls.
+func RegisterPresignFlags(f []cli.Flag) []cli.Flag {
+	return append(f,
+		cli.DurationFlag{
+			Name:   presignExpiryFlag,
+			Usage:  "default lifetime of pre-signed S3 download urls",
+			Value:  15 * time.Minute,
+			EnvVar: "PRESIGN_EXPIRY",
+		},
+		cli.DurationFlag{
+			Name:   presignMaxExpiryFlag,
+			Usage:  "max lifetime of pre-signed S3 download urls requested with expiry (S3 allows up to 7 days)",
+			Value:  24 * time.Hour,
+			EnvVar: "PRESIGN_MAX_EXPIRY",
+		},
+	)
+}
+
+// ErrPresignUnavailable is returned for pre-signed url requests when objects are encrypted client-side,
+// since S3 would serve ciphertext.
+var ErrPresignUnavailable = errors.New("pre-signed urls are not available with client-side encryption")
+
+// ErrPresignChunked is returned for pre-signed url requests of files stored in chunking mode, they have
+// no object of their own and are served by webseed only.
+var ErrPresignChunked = errors.New("pre-signed urls are not available for files stored in chunks")
+
+// FileURLResponse is a pre-signed url of a stored file.
+type FileURLResponse struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// GET /resource/{id}/file-url
+// getFileURL godoc
+// @Summary      Pre-signed S3 url of stored file
+// @Description  Returns a time-limited pre-signed S3 url of the file, so heavy downloads bypass vault.
+// @Description  Not available with client-side encryption.
+// @Tags         webseed
+// @Param        id           path      string  true   "Resource ID"
+// @Param        path         query     string  true   "Path inside resource"
+// @Param        expiry       query     string  false  "Url lifetime, e.g. 1h (default PRESIGN_EXPIRY, capped by PRESIGN_MAX_EXPIRY)"
+// @Param        disposition  query     string  false  "Response Content-Disposition type: inline or attachment (with file name)"
+// @Success      200  {object}  FileURLResponse
+// @Failure      400  {object}  ErrorResponse
+// @Failure      404  {object}  ErrorResponse
+// @Failure      410  {object}  GoneResponse
+// @Failure      501  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /resource/{id}/file-url [get]
+func (s *Web) getFileURL(c *gin.Context) {
+	if !s.validateWebSeedDependencies(c) {
+		return
+	}
+	if s.enc.ClientSide() {
+		_ = c.Error(ErrPresignUnavailable)
+		return
+	}
+	expiry := s.presignExpiry
+	if v := c.Query("expiry"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			_ = c.Error(errors.Errorf("failed to parse expiry %v", v))
+			return
+		}
+		expiry = d
+	}
+	if expiry > s.presignMaxExpiry {
+		expiry = s.presignMaxExpiry
+	}
+	disposition := c.Query("disposition")
+	if disposition != "" && disposition != "inline" && disposition != "attachment" {
+		_ = c.Error(errors.Errorf("failed to parse disposition %v", disposition))
+		return
+	}
+	id := c.Param("id")
+	p := NormalizePath(c.Query("path"))
+	if p == "" {
+		_ = c.Error(errors.New("failed to parse path: path is required"))
+		return
+	}
+
+	db := s.pg.Get()
+	st, err := s.lookupResourceState(c, db, id)
+	if err != nil {
+		s.webseedLookupError(c, err)
+		return
+	}
+	if st.takedown {
+		c.AbortWithStatusJSON(http.StatusGone, &GoneResponse{Error: ErrTakenDown.Error(), ReasonCode: st.reasonCode})
+		return
+	}
+	if !st.stored {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	f, err := s.lookupFile(c, db, id, p)
+	if err != nil {
+		s.webseedLookupError(c, err)
+		return
+	}
+	if f == nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	if f.chunked {
+		_ = c.Error(ErrPresignChunked)
+		return
+	}
+
+	in := &awss3.GetObjectInput{
+		Bucket: aws.String(s.bucketOf(f)),
+		Key:    aws.String(s.keys.Key(f.hash)),
+	}
+	if ct := contentTypeByPath(p); ct != "" {
+		in.ResponseContentType = aws.String(ct)
+	}
+	if disposition != "" {
+		in.ResponseContentDisposition = aws.String(contentDisposition(disposition, p))
+	}
+	req, _ := s.s3.Get().GetObjectRequest(in)
+	u, err := req.Presign(expiry)
+	if err != nil {
+		_ = c.Error(errors.Wrap(err, "failed to presign url"))
+		return
+	}
+	s.touchResource(id)
+	c.JSON(http.StatusOK, &FileURLResponse{URL: u, ExpiresAt: time.Now().Add(expiry)})
+}
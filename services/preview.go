@@ -0,0 +1,342 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	"golang.org/x/time/rate"
+)
+
+const (
+	previewMaxBytesFlag     = "preview-max-bytes"
+	previewDefaultBytesFlag = "preview-default-bytes"
+	previewMaxTTLFlag       = "preview-max-ttl"
+	previewDefaultTTLFlag   = "preview-default-ttl"
+	previewRateLimitFlag    = "preview-rate-limit"
+	previewBurstFlag        = "preview-burst"
+)
+
+// RegisterPreviewFlags registers CLI flags for public preview links.
+func RegisterPreviewFlags(f []cli.Flag) []cli.Flag {
+	return append(f,
+		cli.Int64Flag{
+			Name:   previewMaxBytesFlag,
+			Usage:  "max number of leading bytes of a file served through a preview link",
+			Value:  50 * 1024 * 1024,
+			EnvVar: "PREVIEW_MAX_BYTES",
+		},
+		cli.Int64Flag{
+			Name:   previewDefaultBytesFlag,
+			Usage:  "number of leading bytes served through preview links created without max_bytes",
+			Value:  10 * 1024 * 1024,
+			EnvVar: "PREVIEW_DEFAULT_BYTES",
+		},
+		cli.DurationFlag{
+			Name:   previewMaxTTLFlag,
+			Usage:  "max lifetime of preview links",
+			Value:  7 * 24 * time.Hour,
+			EnvVar: "PREVIEW_MAX_TTL",
+		},
+		cli.DurationFlag{
+			Name:   previewDefaultTTLFlag,
+			Usage:  "lifetime of preview links created without ttl",
+			Value:  24 * time.Hour,
+			EnvVar: "PREVIEW_DEFAULT_TTL",
+		},
+		cli.Float64Flag{
+			Name:   previewRateLimitFlag,
+			Usage:  "preview requests per second per client ip",
+			Value:  1,
+			EnvVar: "PREVIEW_RATE_LIMIT",
+		},
+		cli.IntFlag{
+			Name:   previewBurstFlag,
+			Usage:  "preview request burst per client ip",
+			Value:  10,
+			EnvVar: "PREVIEW_BURST",
+		},
+	)
+}
+
+// previewIssuer tells preview tokens apart from scoped tokens signed with the same secret.
+const previewIssuer = "vault-preview"
+
+// previewLimitHeader tells clients how many leading bytes of the file the preview serves.
+const previewLimitHeader = "X-Vault-Preview-Limit"
+
+// PreviewClaims are claims of a preview link token.
+type PreviewClaims struct {
+	jwt.StandardClaims
+	ResourceID string `json:"rid"`
+	Path       string `json:"path"`
+	MaxBytes   int64  `json:"max"`
+}
+
+// Preview holds limits of public preview links.
+type Preview struct {
+	maxBytes     int64
+	defaultBytes int64
+	maxTTL       time.Duration
+	defaultTTL   time.Duration
+	rl           *RateLimiter
+}
+
+func NewPreview(c *cli.Context) *Preview {
+	return &Preview{
+		maxBytes:     c.Int64(previewMaxBytesFlag),
+		defaultBytes: min(c.Int64(previewDefaultBytesFlag), c.Int64(previewMaxBytesFlag)),
+		maxTTL:       c.Duration(previewMaxTTLFlag),
+		defaultTTL:   min(c.Duration(previewDefaultTTLFlag), c.Duration(previewMaxTTLFlag)),
+		rl: &RateLimiter{
+			ipRate:       rate.Inf,
+			requestRate:  rate.Limit(c.Float64(previewRateLimitFlag)),
+			burst:        c.Int(webseedBurstFlag),
+			requestBurst: c.Int(previewBurstFlag),
+			ips:          map[string]*ipLimiter{},
+			lastSweep:    time.Now(),
+		},
+	}
+}
+
+// mintPreview signs a preview token with the scoped token secret.
+func (s *Auth) mintPreview(cl *PreviewClaims) (string, error) {
+	key := s.tokenSecret.Get()
+	if len(key) == 0 {
+		return "", errors.New("token secret is not configured")
+	}
+	cl.Issuer = previewIssuer
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, cl).SignedString(key)
+}
+
+// parsePreview verifies a preview token.
+func (s *Auth) parsePreview(token string) (*PreviewClaims, error) {
+	cl := &PreviewClaims{}
+	t, err := jwt.ParseWithClaims(token, cl, hmacKey(s.tokenSecret.Get()))
+	if err != nil {
+		return nil, err
+	}
+	if !t.Valid || cl.Issuer != previewIssuer {
+		return nil, errors.New("invalid token")
+	}
+	return cl, nil
+}
+
+// PreviewRequest is a body of preview link request.
+type PreviewRequest struct {
+	Path string `json:"path" binding:"required"`
+	// MaxBytes is the number of leading bytes served, defaults to PREVIEW_DEFAULT_BYTES
+	MaxBytes int64 `json:"max_bytes,omitempty"`
+	// TTL is a Go duration (e.g. 1h), defaults to PREVIEW_DEFAULT_TTL
+	TTL string `json:"ttl,omitempty"`
+}
+
+// PreviewResponse is a public preview link of a stored file.
+type PreviewResponse struct {
+	URL       string    `json:"url"`
+	Path      string    `json:"path"`
+	MaxBytes  int64     `json:"max_bytes"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// POST /resource/{id}/preview
+// postResourcePreview godoc
+// @Summary      Public preview link of stored file
+// @Description  Returns a link serving only the first max_bytes of the file without X-Token until it expires, for sharing
+// @Description  samples. Links are signed with VAULT_TOKEN_SECRET and can't be revoked one by one; takedowns apply to them.
+// @Tags         webseed
+// @Accept       json
+// @Param        id       path      string          true  "Resource ID"
+// @Param        request  body      PreviewRequest  true  "Preview"
+// @Success      200  {object}  PreviewResponse
+// @Failure      400  {object}  ErrorResponse
+// @Failure      404  {object}  ErrorResponse
+// @Failure      410  {object}  GoneResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /resource/{id}/preview [post]
+func (s *Web) postResourcePreview(c *gin.Context) {
+	if !s.validateWebSeedDependencies(c) {
+		return
+	}
+	var req PreviewRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		_ = c.Error(errors.Wrap(err, "failed to parse preview request"))
+		return
+	}
+	maxBytes := s.preview.defaultBytes
+	if req.MaxBytes != 0 {
+		if req.MaxBytes < 0 || req.MaxBytes > s.preview.maxBytes {
+			_ = c.Error(errors.Errorf("failed to parse max_bytes %v: at most %v allowed", req.MaxBytes, s.preview.maxBytes))
+			return
+		}
+		maxBytes = req.MaxBytes
+	}
+	ttl := s.preview.defaultTTL
+	if req.TTL != "" {
+		d, err := time.ParseDuration(req.TTL)
+		if err != nil || d <= 0 {
+			_ = c.Error(errors.Errorf("failed to parse ttl %v", req.TTL))
+			return
+		}
+		ttl = min(d, s.preview.maxTTL)
+	}
+	id := c.Param("id")
+	p := NormalizePath(req.Path)
+	if p == "" {
+		_ = c.Error(errors.New("failed to parse path: path is required"))
+		return
+	}
+
+	db := s.pg.Get()
+	st, err := s.lookupResourceState(c, db, id)
+	if err != nil {
+		s.webseedLookupError(c, err)
+		return
+	}
+	if st.takedown {
+		c.AbortWithStatusJSON(http.StatusGone, &GoneResponse{Error: ErrTakenDown.Error(), ReasonCode: st.reasonCode})
+		return
+	}
+	if !st.stored {
+		_ = c.Error(errors.New("resource not found"))
+		return
+	}
+	f, err := s.lookupFile(c, db, id, p)
+	if err != nil {
+		s.webseedLookupError(c, err)
+		return
+	}
+	if f == nil {
+		_ = c.Error(errors.New("file not found"))
+		return
+	}
+
+	now := time.Now()
+	cl := &PreviewClaims{
+		StandardClaims: jwt.StandardClaims{
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(ttl).Unix(),
+		},
+		ResourceID: id,
+		Path:       p,
+		MaxBytes:   maxBytes,
+	}
+	token, err := s.auth.mintPreview(cl)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	log.WithFields(log.Fields{"id": id, "path": p, "max_bytes": maxBytes, "ttl": ttl}).Info("preview link created")
+	c.JSON(http.StatusOK, &PreviewResponse{
+		URL:       "/preview/" + token,
+		Path:      p,
+		MaxBytes:  maxBytes,
+		ExpiresAt: time.Unix(cl.ExpiresAt, 0),
+	})
+}
+
+// previewRateLimit is a gin middleware rejecting clients exceeding preview request limit with 429.
+func (s *Web) previewRateLimit(c *gin.Context) {
+	ok, d := s.preview.rl.AllowRequest(c.ClientIP())
+	if ok {
+		return
+	}
+	promWebseedRateLimitedRequests.Inc()
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(d.Seconds()))))
+	c.AbortWithStatus(http.StatusTooManyRequests)
+}
+
+// GET /preview/{token}
+// getPreview godoc
+// @Summary      Serve preview of stored file
+// @Description  Serves the first bytes of the file of a preview link without X-Token. Single ranges within the preview are
+// @Description  supported, requests without a range get 206 with the preview part if the file is longer. Content-Range
+// @Description  reports the full size of the file, ranges past the preview return 416.
+// @Tags         webseed
+// @Param        token  path      string  true  "Preview token"
+// @Produce      application/octet-stream
+// @Success      200
+// @Success      206
+// @Failure      403  {object}  ErrorResponse
+// @Failure      404  {object}  ErrorResponse
+// @Failure      410  {object}  GoneResponse
+// @Failure      416
+// @Failure      429
+// @Failure      500  {object}  ErrorResponse
+// @Router       /preview/{token} [get]
+// @Router       /preview/{token} [head]
+func (s *Web) getPreview(c *gin.Context) {
+	if !s.validateWebSeedDependencies(c) {
+		return
+	}
+	pc, err := s.auth.parsePreview(c.Param("token"))
+	if err != nil {
+		logger(c.Request.Context()).WithError(err).Debug("failed to parse preview token")
+		c.AbortWithStatusJSON(http.StatusForbidden, &ErrorResponse{Error: "preview link is invalid or expired"})
+		return
+	}
+	id := pc.ResourceID
+
+	db := s.pg.Get()
+	st, err := s.lookupResourceState(c, db, id)
+	if err != nil {
+		s.webseedLookupError(c, err)
+		return
+	}
+	if st.takedown {
+		c.AbortWithStatusJSON(http.StatusGone, &GoneResponse{Error: ErrTakenDown.Error(), ReasonCode: st.reasonCode})
+		return
+	}
+	if !st.stored {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	f, err := s.lookupFile(c, db, id, pc.Path)
+	if err != nil {
+		s.webseedLookupError(c, err)
+		return
+	}
+	// the limit can't be enforced without knowing the size
+	if f == nil || f.size < 0 {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	if c.Writer.Header().Get(degradedHeader) == "" {
+		s.touchResource(id)
+	}
+
+	limit := min(pc.MaxBytes, f.size)
+	c.Header(previewLimitHeader, strconv.FormatInt(limit, 10))
+	if cc := s.cacheControl(f.class); cc != "" {
+		c.Header("Cache-Control", cc)
+	}
+	rangeHeader := ""
+	if f.size > 0 {
+		ranges, err := parseRanges(c.GetHeader("Range"), limit)
+		if err != nil || limit == 0 {
+			c.Header("Content-Range", fmt.Sprintf("bytes */%d", f.size))
+			c.Status(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		// multiple ranges are served as the whole preview
+		br := &byteRange{start: 0, end: limit - 1}
+		if len(ranges) == 1 {
+			br = &ranges[0]
+		}
+		if br.start > 0 || br.end < f.size-1 {
+			rangeHeader = br.String()
+		}
+	}
+	if c.Request.Method == http.MethodHead {
+		s.handleHeadRequest(c, s.bucketOf(f), f.hash, rangeHeader)
+	} else {
+		s.handleGetRequest(c, f, rangeHeader, id, pc.Path)
+	}
+}
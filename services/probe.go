@@ -0,0 +1,119 @@
+package services
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	ra "github.com/webtor-io/rest-api/services"
+)
+
+// probeSize is the number of bytes read from the swarm to consider it alive.
+const probeSize = 64 * 1024
+
+// ErrUnavailable is returned when preflight probe finds no live swarm for the resource.
+var ErrUnavailable = errors.New("resource is not available in the swarm")
+
+// ProbeResponse reports whether storing of a resource is likely to succeed.
+type ProbeResponse struct {
+	ResourceID string `json:"resource_id"`
+	// Available is true if content could be downloaded from the swarm
+	Available bool `json:"available"`
+	FileCount int  `json:"file_count"`
+	// Path of the probed file
+	Path string `json:"path,omitempty"`
+	// BytesRead during the probe
+	BytesRead int64 `json:"bytes_read"`
+	// LatencyMs is the time to the first byte
+	LatencyMs int64  `json:"latency_ms,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// GET /resource/{id}/probe
+// getResourceProbe godoc
+// @Summary      Probe resource availability
+// @Description  Checks that torrent metadata is resolvable and its content can be downloaded from the swarm
+// @Description  by reading first bytes of the largest file, so dead torrents are not queued for storing.
+// @Tags         resource
+// @Param        id   path      string  true  "Resource ID"
+// @Success      200  {object}  ProbeResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /resource/{id}/probe [get]
+func (s *Web) getResourceProbe(c *gin.Context) {
+	res, err := s.probeResource(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// probeResource lists the torrent and reads first bytes of its largest file within probe timeout.
+// Swarm failures are reported in the response, only unexpected errors are returned.
+func (s *Web) probeResource(ctx context.Context, id string) (*ProbeResponse, error) {
+	if s.probeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.probeTimeout)
+		defer cancel()
+	}
+	res := &ProbeResponse{ResourceID: id}
+	cla := &Claims{Role: "vault"}
+	items, err := s.api.ListResourceFiles(ctx, cla, id)
+	if err != nil {
+		if ctx.Err() != nil {
+			res.Error = "metadata is not resolved in time"
+			return res, nil
+		}
+		return nil, err
+	}
+	res.FileCount = len(items)
+	var item *ra.ListItem
+	for i := range items {
+		if items[i].Size > 0 && (item == nil || items[i].Size > item.Size) {
+			item = &items[i]
+		}
+	}
+	if item == nil {
+		res.Error = "no files to store"
+		return res, nil
+	}
+	res.Path = item.PathStr
+	ei, err := s.api.ExportResourceContent(ctx, cla, id, item.ID)
+	if err != nil {
+		return nil, err
+	}
+	u := ei.ExportItems["download"].URL
+	if u == "" {
+		res.Error = "no download url"
+		return res, nil
+	}
+	start := time.Now()
+	r, err := s.api.DownloadWithRange(ctx, u, 0, int(min(item.Size, probeSize))-1)
+	if err != nil {
+		res.Error = err.Error()
+		return res, nil
+	}
+	defer func() { _ = r.Close() }()
+	buf := make([]byte, 4096)
+	for res.BytesRead < probeSize {
+		n, err := r.Read(buf)
+		if n > 0 && res.BytesRead == 0 {
+			res.LatencyMs = time.Since(start).Milliseconds()
+		}
+		res.BytesRead += int64(n)
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				res.Error = err.Error()
+			}
+			break
+		}
+	}
+	res.Available = res.BytesRead > 0
+	if !res.Available && res.Error == "" {
+		res.Error = "no data received"
+	}
+	return res, nil
+}
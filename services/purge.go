@@ -0,0 +1,246 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+const (
+	deleteGracePeriodFlag = "delete-grace-period"
+)
+
+// RegisterPurgeFlags registers CLI flags for soft deletion.
+func RegisterPurgeFlags(f []cli.Flag) []cli.Flag {
+	return append(f,
+		cli.DurationFlag{
+			Name:   deleteGracePeriodFlag,
+			Usage:  "deleted resources are kept as pending_purge for this period and can be restored until purged (0 deletes immediately)",
+			EnvVar: "DELETE_GRACE_PERIOD",
+		},
+	)
+}
+
+// softDelete marks the resource pending purge instead of deleting it. Returns false if the
+// restore window of the resource has already passed, so it has to be deleted for good.
+func (s *Worker) softDelete(ctx context.Context, db *pg.DB, res *Resource) (bool, error) {
+	if s.deleteGrace <= 0 && res.PurgeAt == nil {
+		return false, nil
+	}
+	if res.PurgeAt != nil && !res.PurgeAt.After(time.Now()) {
+		return false, nil
+	}
+	var r *Resource
+	err := db.RunInTransaction(ctx, func(tx *pg.Tx) (err error) {
+		r, err = ResourceSoftDelete(ctx, tx, res.ID, s.deleteGrace)
+		return
+	})
+	if err != nil {
+		return false, err
+	}
+	logger(ctx).WithFields(log.Fields{"id": res.ID, "purge_at": r.PurgeAt}).Info("resource pending purge")
+	return true, nil
+}
+
+// sweepPurge queues resources whose restore window has passed for final deletion.
+func (s *Worker) sweepPurge(ctx context.Context, db *pg.DB) error {
+	ids, err := ResourceQueuePurge(ctx, db, 100)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		log.WithField("id", id).Info("restore window passed, queued for purge")
+	}
+	return nil
+}
+
+// POST /resource/{id}/restore
+// postResourceRestore godoc
+// @Summary      Restore deleted or archived resource
+// @Description  Undoes deletion of a pending_purge resource within DELETE_GRACE_PERIOD, its files are served again.
+// @Description  Archived resources become restoring and 202 is returned: worker requests restores of their objects,
+// @Description  copies them back once retrievable and marks the resource stored.
+// @Tags         resource
+// @Param        id   path      string  true  "Resource ID"
+// @Success      200  {object}  Resource
+// @Success      202  {object}  Resource
+// @Failure      403  {object}  ErrorResponse
+// @Failure      404  {object}  ErrorResponse
+// @Failure      409  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /resource/{id}/restore [post]
+func (s *Web) postResourceRestore(c *gin.Context) {
+	db := s.pg.Get()
+	if db == nil {
+		_ = c.Error(errors.New("DB not configured"))
+		return
+	}
+	var res *Resource
+	err := db.RunInTransaction(c.Request.Context(), func(tx *pg.Tx) (err error) {
+		res, err = ResourceRestore(c.Request.Context(), tx, c.Param("id"))
+		return
+	})
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if res == nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	status := http.StatusOK
+	if res.Status == StatusRestoring {
+		status = http.StatusAccepted
+	}
+	c.JSON(status, gin.H{"resource": res.SetTTL(time.Now())})
+}
+
+// PurgeRequest matches resources to delete in bulk. At least one of status, older_than and
+// last_accessed_before is required, selector narrows them down further.
+type PurgeRequest struct {
+	// Status matches resources by status, e.g. store_error
+	Status string `json:"status,omitempty"`
+	// OlderThan matches resources created earlier than this long ago (Go duration or days, e.g. 30d)
+	OlderThan string `json:"older_than,omitempty"`
+	// LastAccessedBefore matches resources not served by webseed since then (never accessed ones by creation)
+	LastAccessedBefore *time.Time `json:"last_accessed_before,omitempty"`
+	// Selector matches resources by labels, e.g. "tenant=x,tier=cold"
+	Selector string `json:"selector,omitempty"`
+	// DryRun only counts matched resources
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+// PurgeResponse is a queued purge, or counts of resources it would match if dry run.
+type PurgeResponse struct {
+	DryRun bool `json:"dry_run"`
+	// Matched is the number of resources which would be queued for deletion, set by dry run
+	Matched *int `json:"matched,omitempty"`
+	// LegalHold is the number of matched resources under legal hold, which would be skipped
+	LegalHold *int `json:"legal_hold,omitempty"`
+	// BulkOperation deletes matched resources in batches, progress is returned by GET /admin/bulk/{id}
+	BulkOperation *BulkOperation `json:"bulk_operation,omitempty"`
+}
+
+// parseAge parses Go duration extended with days, e.g. 30d.
+func parseAge(v string) (time.Duration, error) {
+	if d, ok := strings.CutSuffix(v, "d"); ok {
+		n, err := strconv.Atoi(d)
+		if err != nil || n < 0 {
+			return 0, errors.Errorf("failed to parse age %v", v)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, errors.Errorf("failed to parse age %v", v)
+	}
+	return d, nil
+}
+
+// operation validates the request and returns bulk delete operation matching its resources at now.
+func (r *PurgeRequest) operation(now time.Time) (*BulkOperation, error) {
+	op := &BulkOperation{Action: BulkActionDelete, Params: &BulkParams{}, LastAccessedBefore: r.LastAccessedBefore}
+	if r.Status != "" {
+		st, err := ParseStatus(r.Status)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse purge request")
+		}
+		name := st.String()
+		op.TargetStatus = &name
+	}
+	if r.OlderThan != "" {
+		age, err := parseAge(r.OlderThan)
+		if err != nil {
+			return nil, err
+		}
+		t := now.Add(-age)
+		op.CreatedBefore = &t
+	}
+	if op.TargetStatus == nil && op.CreatedBefore == nil && op.LastAccessedBefore == nil {
+		return nil, errors.New("failed to parse purge request: at least one of status, older_than and last_accessed_before is required")
+	}
+	if r.Selector != "" {
+		if _, err := ParseLabelSelector(r.Selector); err != nil {
+			return nil, err
+		}
+		op.Selector = &r.Selector
+	}
+	return op, nil
+}
+
+// Purge counts resources matched by the request if it is a dry run, otherwise queues their deletion
+// by a bulk operation, which resolves them once a worker starts it.
+func Purge(ctx context.Context, db *pg.DB, req *PurgeRequest) (*PurgeResponse, error) {
+	op, err := req.operation(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	res := &PurgeResponse{DryRun: req.DryRun}
+	if req.DryRun {
+		matched, held, err := BulkOperationCountTargets(ctx, db, op)
+		if err != nil {
+			return nil, err
+		}
+		res.Matched, res.LegalHold = &matched, &held
+		return res, nil
+	}
+	err = db.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		return BulkOperationCreate(ctx, tx, op, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.WithFields(log.Fields{"bulk_id": op.BulkID, "action": op.Action}).Info("purge queued")
+	res.BulkOperation = op
+	return res, nil
+}
+
+// POST /resources/purge
+// postResourcesPurge godoc
+// @Summary      Purge resources by status or age
+// @Description  Queues deletion of all resources matching status (e.g. store_error), older_than (created earlier than
+// @Description  this long ago, Go duration or days, e.g. 30d) and last_accessed_before (not served by webseed since, never
+// @Description  accessed ones by creation time), optionally narrowed down by label selector. Resources being deleted
+// @Description  already are not matched unless status says so. Deletion runs as a bulk operation in batches, progress is
+// @Description  returned by GET /admin/bulk/{id}; resources under legal hold are skipped. With dry_run nothing is queued,
+// @Description  the number of matched resources is returned instead.
+// @Tags         admin
+// @Accept       json
+// @Param        request  body      PurgeRequest  true  "Criteria"
+// @Success      200      {object}  PurgeResponse
+// @Success      202      {object}  PurgeResponse
+// @Failure      400      {object}  ErrorResponse
+// @Failure      401      {object}  ErrorResponse
+// @Failure      403      {object}  ErrorResponse
+// @Failure      500      {object}  ErrorResponse
+// @Router       /resources/purge [post]
+func (s *Web) postResourcesPurge(c *gin.Context) {
+	db := s.pg.Get()
+	if db == nil {
+		_ = c.Error(errors.New("DB not configured"))
+		return
+	}
+	var req PurgeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		_ = c.Error(errors.Wrap(err, "failed to parse purge request"))
+		return
+	}
+	res, err := Purge(c.Request.Context(), db, &req)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	status := http.StatusAccepted
+	if res.DryRun {
+		status = http.StatusOK
+	}
+	c.JSON(status, res)
+}
@@ -0,0 +1,243 @@
+package services
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+	"golang.org/x/time/rate"
+)
+
+const (
+	webseedRateLimitFlag        = "webseed-rate-limit"
+	webseedGlobalRateLimitFlag  = "webseed-global-rate-limit"
+	webseedBurstFlag            = "webseed-burst"
+	webseedRequestRateLimitFlag = "webseed-request-rate-limit"
+	webseedRequestBurstFlag     = "webseed-request-burst"
+)
+
+// RegisterRateLimitFlags registers CLI flags for webseed rate limiting.
+func RegisterRateLimitFlags(f []cli.Flag) []cli.Flag {
+	return append(f,
+		cli.Int64Flag{
+			Name:   webseedRateLimitFlag,
+			Usage:  "webseed bytes per second per client ip (0 disables)",
+			EnvVar: "WEBSEED_RATE_LIMIT",
+		},
+		cli.Int64Flag{
+			Name:   webseedGlobalRateLimitFlag,
+			Usage:  "webseed bytes per second for all clients (0 disables)",
+			EnvVar: "WEBSEED_GLOBAL_RATE_LIMIT",
+		},
+		cli.IntFlag{
+			Name:   webseedBurstFlag,
+			Usage:  "webseed burst in bytes",
+			Value:  1024 * 1024,
+			EnvVar: "WEBSEED_BURST",
+		},
+		cli.Float64Flag{
+			Name:   webseedRequestRateLimitFlag,
+			Usage:  "webseed requests per second per client ip (0 disables)",
+			EnvVar: "WEBSEED_REQUEST_RATE_LIMIT",
+		},
+		cli.IntFlag{
+			Name:   webseedRequestBurstFlag,
+			Usage:  "webseed request burst per client ip",
+			Value:  20,
+			EnvVar: "WEBSEED_REQUEST_BURST",
+		},
+	)
+}
+
+// ipLimiter holds limiters of a single client ip.
+type ipLimiter struct {
+	bytes    *rate.Limiter
+	requests *rate.Limiter
+	lastSeen time.Time
+}
+
+// RateLimiter applies token-bucket limits to webseed traffic per client ip and globally.
+type RateLimiter struct {
+	ipRate       rate.Limit
+	requestRate  rate.Limit
+	burst        int
+	requestBurst int
+	global       *rate.Limiter
+	ips          map[string]*ipLimiter
+	mux          sync.Mutex
+	lastSweep    time.Time
+}
+
+const ipLimiterIdleTTL = 10 * time.Minute
+
+// NewRateLimiter returns nil if webseed traffic is not limited.
+func NewRateLimiter(c *cli.Context) (*RateLimiter, error) {
+	if c.Int64(webseedRateLimitFlag) <= 0 && c.Int64(webseedGlobalRateLimitFlag) <= 0 && c.Float64(webseedRequestRateLimitFlag) <= 0 {
+		return nil, nil
+	}
+	if err := validateRateLimits(c); err != nil {
+		return nil, err
+	}
+	rl := &RateLimiter{
+		ips:       map[string]*ipLimiter{},
+		lastSweep: time.Now(),
+	}
+	rl.setLimits(c)
+	return rl, nil
+}
+
+// validateRateLimits checks bursts of enabled limits, reads with a zero byte burst would never make progress.
+func validateRateLimits(c *cli.Context) error {
+	if (c.Int64(webseedRateLimitFlag) > 0 || c.Int64(webseedGlobalRateLimitFlag) > 0) && c.Int(webseedBurstFlag) <= 0 {
+		return errors.New("webseed burst must be positive")
+	}
+	if c.Float64(webseedRequestRateLimitFlag) > 0 && c.Int(webseedRequestBurstFlag) <= 0 {
+		return errors.New("webseed request burst must be positive")
+	}
+	return nil
+}
+
+func (s *RateLimiter) setLimits(c *cli.Context) {
+	s.ipRate = rate.Inf
+	s.requestRate = rate.Inf
+	s.burst = c.Int(webseedBurstFlag)
+	s.requestBurst = c.Int(webseedRequestBurstFlag)
+	if ipRate := c.Int64(webseedRateLimitFlag); ipRate > 0 {
+		s.ipRate = rate.Limit(ipRate)
+	}
+	if requestRate := c.Float64(webseedRequestRateLimitFlag); requestRate > 0 {
+		s.requestRate = rate.Limit(requestRate)
+	}
+	globalRate := c.Int64(webseedGlobalRateLimitFlag)
+	switch {
+	case globalRate <= 0:
+		s.global = nil
+	case s.global == nil:
+		s.global = rate.NewLimiter(rate.Limit(globalRate), s.burst)
+	default:
+		s.global.SetLimit(rate.Limit(globalRate))
+		s.global.SetBurst(s.burst)
+	}
+}
+
+// Update applies new limits, including to limiters of already seen client ips. Invalid limits are
+// rejected and running ones are kept.
+func (s *RateLimiter) Update(c *cli.Context) error {
+	if err := validateRateLimits(c); err != nil {
+		return err
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.setLimits(c)
+	for _, l := range s.ips {
+		l.bytes.SetLimit(s.ipRate)
+		l.bytes.SetBurst(s.burst)
+		l.requests.SetLimit(s.requestRate)
+		l.requests.SetBurst(s.requestBurst)
+	}
+	return nil
+}
+
+func (s *RateLimiter) get(ip string) *ipLimiter {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	now := time.Now()
+	if now.Sub(s.lastSweep) > time.Minute {
+		for k, v := range s.ips {
+			if now.Sub(v.lastSeen) > ipLimiterIdleTTL {
+				delete(s.ips, k)
+			}
+		}
+		s.lastSweep = now
+	}
+	l, ok := s.ips[ip]
+	if !ok {
+		l = &ipLimiter{
+			bytes:    rate.NewLimiter(s.ipRate, s.burst),
+			requests: rate.NewLimiter(s.requestRate, s.requestBurst),
+		}
+		s.ips[ip] = l
+	}
+	l.lastSeen = now
+	return l
+}
+
+// AllowRequest checks per-ip request limit. If the limit is exceeded it returns the delay
+// after which the client may retry.
+func (s *RateLimiter) AllowRequest(ip string) (bool, time.Duration) {
+	r := s.get(ip).requests.Reserve()
+	if !r.OK() {
+		return false, time.Second
+	}
+	d := r.Delay()
+	if d > 0 {
+		r.Cancel()
+		return false, d
+	}
+	return true, 0
+}
+
+// Reader wraps r so that reads are throttled by per-ip and global byte limits.
+func (s *RateLimiter) Reader(ctx context.Context, ip string, r io.Reader) io.Reader {
+	limiters := []*rate.Limiter{s.get(ip).bytes}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.global != nil {
+		limiters = append(limiters, s.global)
+	}
+	return &rateLimitedReader{ctx: ctx, r: r, limiters: limiters, burst: s.burst}
+}
+
+type rateLimitedReader struct {
+	ctx      context.Context
+	r        io.Reader
+	limiters []*rate.Limiter
+	burst    int
+	// onWait is optionally called with time spent waiting for limiters
+	onWait func(d time.Duration)
+}
+
+func (s *rateLimitedReader) Read(b []byte) (int, error) {
+	// limits may be lowered while reading
+	burst := s.burst
+	for _, l := range s.limiters {
+		burst = min(burst, l.Burst())
+	}
+	if len(b) > burst {
+		b = b[:burst]
+	}
+	n, err := s.r.Read(b)
+	if n > 0 {
+		start := time.Now()
+		for _, l := range s.limiters {
+			if werr := l.WaitN(s.ctx, n); werr != nil {
+				return n, werr
+			}
+		}
+		if s.onWait != nil {
+			s.onWait(time.Since(start))
+		}
+	}
+	return n, err
+}
+
+// webseedRateLimit is a gin middleware rejecting clients exceeding request limit with 429.
+func (s *Web) webseedRateLimit(c *gin.Context) {
+	if s.rl == nil {
+		return
+	}
+	ok, d := s.rl.AllowRequest(c.ClientIP())
+	if ok {
+		return
+	}
+	promWebseedRateLimitedRequests.Inc()
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(d.Seconds()))))
+	c.AbortWithStatus(http.StatusTooManyRequests)
+}
@@ -0,0 +1,61 @@
+package services
+
+import (
+	"context"
+	"io"
+)
+
+const readAheadChunkSize = 64 * 1024
+
+// readAheadReader prefetches up to size bytes from the underlying reader in background,
+// so that slow reads from the object store overlap with writes to the client.
+type readAheadReader struct {
+	ch  chan []byte
+	err error
+	cur []byte
+}
+
+func newReadAheadReader(ctx context.Context, r io.Reader, size int64) *readAheadReader {
+	chunks := int(size / readAheadChunkSize)
+	if chunks < 1 {
+		chunks = 1
+	}
+	ra := &readAheadReader{
+		ch: make(chan []byte, chunks),
+	}
+	go ra.fill(ctx, r)
+	return ra
+}
+
+func (s *readAheadReader) fill(ctx context.Context, r io.Reader) {
+	defer close(s.ch)
+	for {
+		buf := make([]byte, readAheadChunkSize)
+		n, err := r.Read(buf)
+		if n > 0 {
+			select {
+			case s.ch <- buf[:n]:
+			case <-ctx.Done():
+				s.err = ctx.Err()
+				return
+			}
+		}
+		if err != nil {
+			s.err = err
+			return
+		}
+	}
+}
+
+func (s *readAheadReader) Read(b []byte) (int, error) {
+	if len(s.cur) == 0 {
+		buf, ok := <-s.ch
+		if !ok {
+			return 0, s.err
+		}
+		s.cur = buf
+	}
+	n := copy(b, s.cur)
+	s.cur = s.cur[n:]
+	return n, nil
+}
@@ -0,0 +1,306 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	awss3 "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/gin-gonic/gin"
+	"github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+const (
+	replicaBucketFlag          = "replica-bucket"
+	replicaEndpointFlag        = "replica-endpoint"
+	replicaRegionFlag          = "replica-region"
+	replicaAccessKeyIDFlag     = "replica-access-key-id"
+	replicaSecretAccessKeyFlag = "replica-secret-access-key"
+	replicaConcurrencyFlag     = "replica-concurrency"
+)
+
+const (
+	// replicaBatchSize is the number of files enqueued and claimed at once
+	replicaBatchSize = 100
+	// replicaLeaseTTL covers copying of the largest files, leases of dead instances are taken over earlier
+	replicaLeaseTTL   = time.Hour
+	replicaMinBackoff = time.Minute
+	replicaMaxBackoff = time.Hour
+)
+
+// RegisterReplicaFlags registers CLI flags for replication of stored files to a secondary bucket.
+func RegisterReplicaFlags(f []cli.Flag) []cli.Flag {
+	return append(f,
+		cli.StringFlag{
+			Name:   replicaBucketFlag,
+			Usage:  "secondary bucket stored files are copied to, webseed falls back to it when primary fails (empty disables)",
+			EnvVar: "REPLICA_BUCKET",
+		},
+		cli.StringFlag{
+			Name:   replicaEndpointFlag,
+			Usage:  "S3 endpoint of the replica bucket (defaults to AWS)",
+			EnvVar: "REPLICA_ENDPOINT",
+		},
+		cli.StringFlag{
+			Name:   replicaRegionFlag,
+			Usage:  "region of the replica bucket (defaults to aws-region)",
+			EnvVar: "REPLICA_REGION",
+		},
+		cli.StringFlag{
+			Name:   replicaAccessKeyIDFlag,
+			Usage:  "access key id of the replica bucket (defaults to primary credentials)",
+			EnvVar: "REPLICA_ACCESS_KEY_ID",
+		},
+		cli.StringFlag{
+			Name:   replicaSecretAccessKeyFlag,
+			Usage:  "secret access key of the replica bucket",
+			EnvVar: "REPLICA_SECRET_ACCESS_KEY",
+		},
+		cli.IntFlag{
+			Name:   replicaConcurrencyFlag,
+			Usage:  "number of files copied to the replica bucket at once",
+			Value:  4,
+			EnvVar: "REPLICA_CONCURRENCY",
+		},
+	)
+}
+
+// Replica is a secondary bucket keeping copies of stored files under the same keys.
+type Replica struct {
+	s3          *awss3.S3
+	bucket      string
+	concurrency int
+}
+
+// NewReplica returns nil if replica bucket is not set. Unless replica credentials are set,
+// primary ones are used, following their rotation.
+func NewReplica(c *cli.Context, secrets *Secrets, cl *http.Client) (*Replica, error) {
+	bucket := c.String(replicaBucketFlag)
+	if bucket == "" {
+		return nil, nil
+	}
+	if c.Int(replicaConcurrencyFlag) < 1 {
+		return nil, errors.New("replica concurrency must be positive")
+	}
+	region := c.String(replicaRegionFlag)
+	if region == "" {
+		region = c.String(awsRegionFlag)
+	}
+	cfg := aws.NewConfig().
+		WithRegion(region).
+		WithDisableSSL(c.Bool("aws-no-ssl")).
+		WithS3ForcePathStyle(true).
+		WithHTTPClient(cl)
+	if ep := c.String(replicaEndpointFlag); ep != "" {
+		cfg = cfg.WithEndpoint(ep)
+	}
+	if id := c.String(replicaAccessKeyIDFlag); id != "" {
+		cfg = cfg.WithCredentials(credentials.NewStaticCredentials(id, c.String(replicaSecretAccessKeyFlag), ""))
+	} else {
+		cfg = cfg.WithCredentials(credentials.NewCredentials(&secretsCredentialsProvider{s: secrets}))
+	}
+	sess, err := session.NewSession(cfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to init replica s3 session")
+	}
+	return &Replica{
+		s3:          awss3.New(sess),
+		bucket:      bucket,
+		concurrency: c.Int(replicaConcurrencyFlag),
+	}, nil
+}
+
+// replicaBackoff is the delay before the next copy attempt after attempts failures.
+func replicaBackoff(attempts int) time.Duration {
+	d := replicaMinBackoff
+	for i := 0; i < attempts && d < replicaMaxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, replicaMaxBackoff)
+}
+
+// startReplication enqueues newly stored files and copies a batch of pending ones in background,
+// one batch at a time per instance.
+func (s *Worker) startReplication(ctx context.Context, db *pg.DB) error {
+	if s.replica == nil || !s.replicaRunning.CompareAndSwap(false, true) {
+		return nil
+	}
+	if _, err := FileReplicaEnqueue(ctx, db, s.replica.bucket, replicaBatchSize); err != nil {
+		s.replicaRunning.Store(false)
+		return err
+	}
+	list, err := FileReplicaClaim(ctx, db, s.replica.bucket, s.id, replicaLeaseTTL, s.deadAfter, replicaBatchSize)
+	if err != nil || len(list) == 0 {
+		s.replicaRunning.Store(false)
+		return err
+	}
+	go func() {
+		defer s.replicaRunning.Store(false)
+		sem := make(chan struct{}, s.replica.concurrency)
+		for i := range list {
+			sem <- struct{}{}
+			go func(r *FileReplica) {
+				defer func() { <-sem }()
+				s.replicate(ctx, db, r)
+			}(&list[i])
+		}
+		for i := 0; i < cap(sem); i++ {
+			sem <- struct{}{}
+		}
+	}()
+	return nil
+}
+
+func (s *Worker) replicate(ctx context.Context, db *pg.DB, r *FileReplica) {
+	l := log.WithFields(log.Fields{"hash": r.FileHash, "bucket": r.Bucket})
+	err := s.replicateFile(ctx, db, r.FileHash)
+	if ctx.Err() != nil {
+		// resumed once the lease expires
+		return
+	}
+	if err != nil {
+		promReplicaFiles.WithLabelValues("failed").Inc()
+		backoff := replicaBackoff(r.Attempts)
+		l.WithError(err).WithField("retry_in", backoff).Warn("failed to replicate file")
+		if err := FileReplicaFail(ctx, db, r, err.Error(), backoff); err != nil {
+			l.WithError(err).Error("failed to update replica status")
+		}
+		return
+	}
+	promReplicaFiles.WithLabelValues("done").Inc()
+	if err := FileReplicaComplete(ctx, db, r); err != nil {
+		l.WithError(err).Error("failed to update replica status")
+		return
+	}
+	l.Info("file replicated")
+}
+
+// replicateFile streams object of the file from the bucket it is stored in to the replica bucket
+// keeping its metadata and tags, client-side encrypted objects stay readable with the same key.
+func (s *Worker) replicateFile(ctx context.Context, db *pg.DB, hash string) error {
+	f := &File{Hash: hash}
+	if err := db.Model(f).Context(ctx).Column("bucket").WherePK().Select(); err != nil {
+		return errors.Wrapf(err, "failed to get file %v", hash)
+	}
+	key := s.keys.Key(hash)
+	out, err := s.s3.Get().GetObjectWithContext(ctx, &awss3.GetObjectInput{
+		Bucket: aws.String(f.bucketOr(s.bucket)),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to get %v", key)
+	}
+	defer func() { _ = out.Body.Close() }()
+	tagging, err := s.tags.copyTagging(ctx, s.s3.Get(), f.bucketOr(s.bucket), key)
+	if err != nil {
+		return err
+	}
+	in := &s3manager.UploadInput{
+		Bucket:      aws.String(s.replica.bucket),
+		Key:         aws.String(key),
+		Body:        out.Body,
+		ContentType: out.ContentType,
+		Metadata:    out.Metadata,
+		Tagging:     tagging,
+	}
+	s.enc.PrepareReplica(in)
+	if _, err = s3manager.NewUploaderWithClient(s.replica.s3).UploadWithContext(ctx, in); err != nil {
+		return errors.Wrapf(err, "failed to upload %v to replica", key)
+	}
+	return nil
+}
+
+// replicaFailover reports whether a failed primary read should be retried on the replica.
+// Client errors other than a missing object are not retried, nor are requests the client dropped.
+func (s *Web) replicaFailover(ctx context.Context, err error) bool {
+	if s.replica == nil || err == nil || ctx.Err() != nil {
+		return false
+	}
+	var rf awserr.RequestFailure
+	if errors.As(err, &rf) {
+		code := rf.StatusCode()
+		return code >= http.StatusInternalServerError || code == http.StatusNotFound || code == http.StatusForbidden
+	}
+	return true
+}
+
+// getReplicaObject reads the object from the replica after the primary read failed with primaryErr,
+// primaryErr is returned if the replica fails as well.
+func (s *Web) getReplicaObject(ctx context.Context, in *awss3.GetObjectInput, primaryErr error) (*awss3.GetObjectOutput, error) {
+	if !s.replicaFailover(ctx, primaryErr) {
+		return nil, primaryErr
+	}
+	rin := *in
+	rin.Bucket = aws.String(s.replica.bucket)
+	out, err := s.replica.s3.GetObjectWithContext(ctx, &rin)
+	if err != nil {
+		promReplicaFailovers.WithLabelValues("failed").Inc()
+		logger(ctx).WithError(err).WithField("key", aws.StringValue(in.Key)).Warn("replica read failed")
+		return nil, primaryErr
+	}
+	promReplicaFailovers.WithLabelValues("served").Inc()
+	logger(ctx).WithError(primaryErr).WithField("key", aws.StringValue(in.Key)).Warn("primary read failed, served from replica")
+	return out, nil
+}
+
+// headReplicaObject is getReplicaObject for HEAD requests, the status code of the replica response
+// is returned along with output.
+func (s *Web) headReplicaObject(ctx context.Context, in *awss3.HeadObjectInput, primaryErr error) (*awss3.HeadObjectOutput, int, error) {
+	if !s.replicaFailover(ctx, primaryErr) {
+		return nil, 0, primaryErr
+	}
+	rin := *in
+	rin.Bucket = aws.String(s.replica.bucket)
+	req, out := s.replica.s3.HeadObjectRequest(&rin)
+	req.SetContext(ctx)
+	if err := req.Send(); err != nil {
+		promReplicaFailovers.WithLabelValues("failed").Inc()
+		logger(ctx).WithError(err).WithField("key", aws.StringValue(in.Key)).Warn("replica read failed")
+		return nil, 0, primaryErr
+	}
+	promReplicaFailovers.WithLabelValues("served").Inc()
+	return out, req.HTTPResponse.StatusCode, nil
+}
+
+// ReplicaResponse counts copies of stored files in the replica bucket by status.
+type ReplicaResponse struct {
+	Bucket string                    `json:"bucket"`
+	Files  map[FileReplicaStatus]int `json:"files"`
+}
+
+// GET /admin/replica
+// getReplica godoc
+// @Summary      Replication status
+// @Description  Counts copies of stored files in the replica bucket by status: pending, done and failed (retried with backoff).
+// @Tags         admin
+// @Success      200     {object}  ReplicaResponse
+// @Failure      401     {object}  ErrorResponse
+// @Failure      403     {object}  ErrorResponse
+// @Failure      404     {object}  ErrorResponse
+// @Failure      500     {object}  ErrorResponse
+// @Router       /admin/replica [get]
+func (s *Web) getReplica(c *gin.Context) {
+	db := s.pg.Get()
+	if db == nil {
+		_ = c.Error(errors.New("DB not configured"))
+		return
+	}
+	if s.replica == nil {
+		_ = c.Error(errors.New("replica not found: replica bucket is not configured"))
+		return
+	}
+	stats, err := FileReplicaStats(c.Request.Context(), db, s.replica.bucket)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, &ReplicaResponse{Bucket: s.replica.bucket, Files: stats})
+}
@@ -0,0 +1,68 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// WithRequestID returns context carrying request id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	if id == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext returns request id carried by context or empty string.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// logger returns log entry annotated with request id of the context.
+func logger(ctx context.Context) *log.Entry {
+	if id := RequestIDFromContext(ctx); id != "" {
+		return log.WithField("request_id", id)
+	}
+	return log.NewEntry(log.StandardLogger())
+}
+
+// requestLogger is a gin middleware propagating (or generating) X-Request-ID and logging every request.
+func (s *Web) requestLogger(c *gin.Context) {
+	start := time.Now()
+	id := c.GetHeader(requestIDHeader)
+	if id == "" {
+		id = uuid.NewString()
+	}
+	c.Header(requestIDHeader, id)
+	c.Request = c.Request.WithContext(WithRequestID(c.Request.Context(), id))
+
+	c.Next()
+
+	status := c.Writer.Status()
+	l := log.WithFields(log.Fields{
+		"request_id": id,
+		"method":     c.Request.Method,
+		"path":       c.Request.URL.Path,
+		"status":     status,
+		"latency":    time.Since(start),
+		"bytes":      c.Writer.Size(),
+		"client_ip":  c.ClientIP(),
+	})
+	switch {
+	case status >= 500:
+		l.Error("request")
+	case status >= 400:
+		l.Warn("request")
+	default:
+		l.Info("request")
+	}
+}
@@ -3,38 +3,301 @@ package services
 import (
 	"context"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/go-pg/pg/v10"
 	"github.com/pkg/errors"
 )
 
+// ExpiryRequest sets expiration of a resource. Either ttl (Go duration, "0" removes expiration)
+// or expires_at may be provided.
+type ExpiryRequest struct {
+	TTL       string     `json:"ttl,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// expiry returns new expiration time. The second value is false if expiration should stay untouched.
+func (r *ExpiryRequest) expiry(now time.Time) (*time.Time, bool, error) {
+	if r.TTL != "" && r.ExpiresAt != nil {
+		return nil, false, errors.New("failed to parse expiry: ttl and expires_at are mutually exclusive")
+	}
+	if r.ExpiresAt != nil {
+		if !r.ExpiresAt.After(now) {
+			return nil, false, errors.New("failed to parse expiry: expires_at is in the past")
+		}
+		return r.ExpiresAt, true, nil
+	}
+	if r.TTL == "" {
+		return nil, false, nil
+	}
+	ttl, err := time.ParseDuration(r.TTL)
+	if err != nil || ttl < 0 {
+		return nil, false, errors.Errorf("failed to parse ttl %v", r.TTL)
+	}
+	if ttl == 0 {
+		return nil, true, nil
+	}
+	t := now.Add(ttl)
+	return &t, true, nil
+}
+
+// StoreRequest is an optional body of store request.
+type StoreRequest struct {
+	ExpiryRequest
+	// StorageClass overrides default S3 storage class of the resource files
+	StorageClass string `json:"storage_class,omitempty"`
+	// Preflight probes swarm availability and rejects the request if content can't be downloaded
+	Preflight bool `json:"preflight,omitempty"`
+	// MaxDuration caps storing time (Go duration, "0" removes the cap), storing is failed with
+	// "store deadline exceeded" error once it is exceeded
+	MaxDuration string `json:"max_duration,omitempty"`
+	// Labels replace labels of the resource, they are matched by label selectors of bulk operations
+	Labels map[string]string `json:"labels,omitempty"`
+	// Name sets name of the resource, empty string removes it
+	Name *string `json:"name,omitempty"`
+	// Annotations replace arbitrary JSON attached to the resource, empty object removes them
+	Annotations map[string]any `json:"annotations,omitempty"`
+}
+
+// validateMetadata checks labels, name and annotations of the request.
+func validateMetadata(labels map[string]string, name *string, annotations map[string]any) error {
+	if err := validateLabels(labels); err != nil {
+		return err
+	}
+	if err := validateName(name); err != nil {
+		return err
+	}
+	return validateAnnotations(annotations)
+}
+
+// setMetadata replaces labels, name and annotations of the resource which are set.
+func setMetadata(ctx context.Context, tx *pg.Tx, res *Resource, labels map[string]string, name *string, annotations map[string]any) (*Resource, error) {
+	var err error
+	if labels != nil {
+		if res, err = ResourceSetLabels(ctx, tx, res.ID, labels); err != nil || res == nil {
+			return res, err
+		}
+	}
+	if name != nil {
+		if res, err = ResourceSetName(ctx, tx, res.ID, *name); err != nil || res == nil {
+			return res, err
+		}
+	}
+	if annotations != nil {
+		if res, err = ResourceSetAnnotations(ctx, tx, res.ID, annotations); err != nil || res == nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+// maxDuration returns requested max store duration. The second value is false if it should stay untouched.
+func (r *StoreRequest) maxDuration() (time.Duration, bool, error) {
+	if r.MaxDuration == "" {
+		return 0, false, nil
+	}
+	d, err := time.ParseDuration(r.MaxDuration)
+	if err != nil || d < 0 || (d > 0 && d < time.Second) {
+		return 0, false, errors.Errorf("failed to parse max_duration %v", r.MaxDuration)
+	}
+	return d, true, nil
+}
+
 // PUT /resource/{id} — queue storing of a resource (id = infohash)
 // putResource godoc
 // @Summary      Queue storing of a resource
-// @Description  Creates the resource if missing or marks it queued for processing
+// @Description  Creates the resource if missing or marks it queued for processing.
+// @Description  Optional expiration can be set with ?ttl=720h or expires_at/ttl in the body.
+// @Description  storage_class in the body overrides default S3 storage class of the resource files.
+// @Description  With preflight in the body the resource is queued only if its content is available in the swarm.
+// @Description  max_duration (?max_duration=6h) fails storing with "store deadline exceeded" if it takes longer.
+// @Description  Idempotency-Key header makes retries of the request replay the first response.
 // @Tags         resource
-// @Param        id   path      string  true  "Resource ID"
-// @Success      202  {object}  Resource
-// @Failure      500  {object}  ErrorResponse
+// @Accept       json
+// @Param        id       path      string        true   "Resource ID"
+// @Param        ttl      query     string        false  "Time to live (Go duration, e.g. 720h)"
+// @Param        max_duration  query  string      false  "Max store duration (Go duration, e.g. 6h)"
+// @Param        Idempotency-Key  header  string  false  "Key identifying the request across retries"
+// @Param        request  body      StoreRequest  false  "Expiration and storage class"
+// @Success      202      {object}  Resource
+// @Failure      400      {object}  ErrorResponse
+// @Failure      424      {object}  ErrorResponse
+// @Failure      500      {object}  ErrorResponse
 // @Router       /resource/{id} [put]
 func (s *Web) putResource(c *gin.Context) {
 	id := c.Param("id")
+	var req StoreRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			_ = c.Error(errors.Wrap(err, "failed to parse resource request"))
+			return
+		}
+	}
+	if v := c.Query("ttl"); v != "" {
+		req.TTL = v
+	}
+	if v := c.Query("max_duration"); v != "" {
+		req.MaxDuration = v
+	}
+	s.queueResource(c, id, &req)
+}
+
+// queueResource queues storing of the resource with options of req and responds with it.
+func (s *Web) queueResource(c *gin.Context, id string, req *StoreRequest) {
 	db := s.pg.Get()
 	if db == nil {
 		_ = c.Error(errors.New("DB not configured"))
 		return
 	}
-	res, err := ResourceQueueForStoring(c.Request.Context(), db, id)
+	expiresAt, setExpiry, err := req.expiry(time.Now())
 	if err != nil {
 		_ = c.Error(err)
 		return
 	}
-	c.JSON(http.StatusAccepted, gin.H{"resource": res})
+	sc, err := ParseStorageClass(req.StorageClass)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	maxDuration, setMaxDuration, err := req.maxDuration()
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if err = validateMetadata(req.Labels, req.Name, req.Annotations); err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if req.Preflight {
+		pr, err := s.probeResource(c.Request.Context(), id)
+		if err != nil {
+			_ = c.Error(err)
+			return
+		}
+		if !pr.Available {
+			_ = c.Error(errors.Wrap(ErrUnavailable, pr.Error))
+			return
+		}
+	}
+	tenant := s.auth.Tenant(c)
+	var res *Resource
+	err = db.RunInTransaction(c.Request.Context(), func(tx *pg.Tx) error {
+		var err error
+		res, err = s.createOwned(c.Request.Context(), tx, tenant, id, func() (*Resource, error) {
+			return ResourceQueueForStoring(c.Request.Context(), tx, id)
+		})
+		if err != nil {
+			return err
+		}
+		if sc != "" {
+			if res, err = ResourceSetStorageClass(c.Request.Context(), tx, id, sc); err != nil {
+				return err
+			}
+		}
+		if setMaxDuration {
+			if res, err = ResourceSetMaxStoreDuration(c.Request.Context(), tx, id, maxDuration); err != nil {
+				return err
+			}
+		}
+		if res, err = setMetadata(c.Request.Context(), tx, res, req.Labels, req.Name, req.Annotations); err != nil {
+			return err
+		}
+		if !setExpiry {
+			return nil
+		}
+		res, err = ResourceSetExpiry(c.Request.Context(), tx, id, expiresAt)
+		return err
+	})
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	s.abuse.Record(AbuseKindRegister, c.ClientIP(), 1)
+	c.Header("ETag", res.ETag())
+	c.JSON(http.StatusAccepted, gin.H{"resource": res.SetTTL(time.Now())})
+}
+
+// PatchRequest is a body of resource update request.
+type PatchRequest struct {
+	ExpiryRequest
+	// Labels replace labels of the resource, empty object removes them
+	Labels map[string]string `json:"labels,omitempty"`
+	// Name sets name of the resource, empty string removes it
+	Name *string `json:"name,omitempty"`
+	// Annotations replace arbitrary JSON attached to the resource, empty object removes them
+	Annotations map[string]any `json:"annotations,omitempty"`
+}
+
+// PATCH /resource/{id} — update expiration and metadata of a resource
+// patchResource godoc
+// @Summary      Update resource expiration and metadata
+// @Description  Extends, shortens or removes (ttl=0) expiration of the resource, replaces its labels, name and annotations.
+// @Description  Fields which are not set stay untouched.
+// @Tags         resource
+// @Accept       json
+// @Param        id       path      string        true  "Resource ID"
+// @Param        request  body      PatchRequest  true  "Expiration and metadata"
+// @Success      200      {object}  Resource
+// @Failure      400      {object}  ErrorResponse
+// @Failure      404      {object}  ErrorResponse
+// @Failure      500      {object}  ErrorResponse
+// @Router       /resource/{id} [patch]
+func (s *Web) patchResource(c *gin.Context) {
+	db := s.pg.Get()
+	if db == nil {
+		_ = c.Error(errors.New("DB not configured"))
+		return
+	}
+	var req PatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		_ = c.Error(errors.Wrap(err, "failed to parse resource request"))
+		return
+	}
+	expiresAt, setExpiry, err := req.expiry(time.Now())
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if !setExpiry && req.Labels == nil && req.Name == nil && req.Annotations == nil {
+		_ = c.Error(errors.New("failed to parse resource request: nothing to update"))
+		return
+	}
+	if err = validateMetadata(req.Labels, req.Name, req.Annotations); err != nil {
+		_ = c.Error(err)
+		return
+	}
+	id := c.Param("id")
+	var res *Resource
+	err = db.RunInTransaction(c.Request.Context(), func(tx *pg.Tx) error {
+		var err error
+		res = &Resource{ID: id}
+		if setExpiry {
+			if res, err = ResourceSetExpiry(c.Request.Context(), tx, id, expiresAt); err != nil || res == nil {
+				return err
+			}
+		}
+		res, err = setMetadata(c.Request.Context(), tx, res, req.Labels, req.Name, req.Annotations)
+		return err
+	})
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if res == nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	c.Header("ETag", res.ETag())
+	c.JSON(http.StatusOK, gin.H{"resource": res.SetTTL(time.Now())})
 }
 
 // GET /resource/{id}
 // getResource godoc
 // @Summary      Get resource
+// @Description  While the resource is storing, progress tells the phase of the job: hashing while content of a file is
+// @Description  downloaded to compute its hash before the upload (with hashed bytes), uploading otherwise, and how many
+// @Description  files of the job are stored.
 // @Tags         resource
 // @Param        id   path      string  true  "Resource ID"
 // @Success      200  {object}  Resource
@@ -57,15 +320,22 @@ func (s *Web) getResource(c *gin.Context) {
 		c.Status(http.StatusNotFound)
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"resource": res})
+	c.Header("ETag", res.ETag())
+	c.JSON(http.StatusOK, gin.H{"resource": res.SetTTL(time.Now())})
 }
 
 // DELETE /resource/{id} — queue deletion
 // deleteResource godoc
 // @Summary      Queue deletion of a resource
+// @Description  With If-Match header (ETag of GET /resource/{id}) the resource is deleted only if it has not changed
+// @Description  since it was read, otherwise 412 is returned. Idempotency-Key header makes retries replay the first response.
 // @Tags         resource
 // @Param        id   path      string  true  "Resource ID"
+// @Param        If-Match         header  string  false  "ETag the resource must still have"
+// @Param        Idempotency-Key  header  string  false  "Key identifying the request across retries"
 // @Success      202  {object}  Resource
+// @Failure      412  {object}  ErrorResponse
+// @Failure      422  {object}  ErrorResponse
 // @Failure      500  {object}  ErrorResponse
 // @Router       /resource/{id} [delete]
 func (s *Web) deleteResource(c *gin.Context) {
@@ -75,7 +345,22 @@ func (s *Web) deleteResource(c *gin.Context) {
 		return
 	}
 	id := c.Param("id")
-	res, err := ResourceQueueForDeletion(context.Background(), db, id)
+	ifMatch := c.GetHeader("If-Match")
+	var res *Resource
+	err := db.RunInTransaction(context.Background(), func(tx *pg.Tx) error {
+		if ifMatch != "" {
+			cur, err := ResourceGetForUpdate(context.Background(), tx, id)
+			if err != nil {
+				return err
+			}
+			if !etagMatches(ifMatch, cur) {
+				return ErrPreconditionFailed
+			}
+		}
+		var err error
+		res, err = ResourceQueueForDeletion(context.Background(), tx, id)
+		return err
+	})
 	if err != nil {
 		_ = c.Error(err)
 		return
@@ -86,3 +371,278 @@ func (s *Web) deleteResource(c *gin.Context) {
 	}
 	c.JSON(http.StatusAccepted, gin.H{"resource": res})
 }
+
+// POST /resource/{id}/retry — requeue failed resource
+// postResourceRetry godoc
+// @Summary      Retry failed resource
+// @Description  Resets a store_error/delete_error resource back to queued status, clears the error and records the retry in the operation log
+// @Tags         resource
+// @Param        id   path      string  true  "Resource ID"
+// @Success      202  {object}  Resource
+// @Failure      403  {object}  ErrorResponse
+// @Failure      404  {object}  ErrorResponse
+// @Failure      409  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /resource/{id}/retry [post]
+func (s *Web) postResourceRetry(c *gin.Context) {
+	db := s.pg.Get()
+	if db == nil {
+		_ = c.Error(errors.New("DB not configured"))
+		return
+	}
+	id := c.Param("id")
+	var res *Resource
+	err := db.RunInTransaction(c.Request.Context(), func(tx *pg.Tx) error {
+		cur, err := ResourceGetByID(c.Request.Context(), tx, id)
+		if err != nil || cur == nil {
+			return err
+		}
+		if cur.Status == StatusDeleteError && !s.auth.Allowed(c, TokenScopeDelete) {
+			return errors.Errorf("forbidden: %v scope required", TokenScopeDelete)
+		}
+		res, err = ResourceRetry(c.Request.Context(), tx, id)
+		return err
+	})
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if res == nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"resource": res.SetTTL(time.Now())})
+}
+
+// POST /resource/{id}/abort-and-clean — abort storing of the resource
+// postResourceAbortAndClean godoc
+// @Summary      Abort and clean resource
+// @Description  Cancels storing of a queued_for_storing, storing or store_error resource and queues it for deletion
+// @Description  without the restore window. Files uploaded for it are removed unless other resources reference them,
+// @Description  including uploads which were not finished, so the resource is gone as if it was never stored.
+// @Tags         resource
+// @Param        id   path      string  true  "Resource ID"
+// @Success      202  {object}  Resource
+// @Failure      403  {object}  ErrorResponse
+// @Failure      404  {object}  ErrorResponse
+// @Failure      409  {object}  ErrorResponse
+// @Failure      423  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /resource/{id}/abort-and-clean [post]
+func (s *Web) postResourceAbortAndClean(c *gin.Context) {
+	db := s.pg.Get()
+	if db == nil {
+		_ = c.Error(errors.New("DB not configured"))
+		return
+	}
+	id := c.Param("id")
+	var res *Resource
+	err := db.RunInTransaction(c.Request.Context(), func(tx *pg.Tx) (err error) {
+		res, err = ResourceAbort(c.Request.Context(), tx, id)
+		return
+	})
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if res == nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"resource": res.SetTTL(time.Now())})
+}
+
+// ResourcesResponse is a page of resources.
+type ResourcesResponse struct {
+	Resources []Resource `json:"resources"`
+	Total     int        `json:"total"`
+	Limit     int        `json:"limit"`
+	Offset    int        `json:"offset"`
+}
+
+// GET /resources
+// getResources godoc
+// @Summary      List resources
+// @Description  Returns resources, most recently updated first. Use status=store_error or status=delete_error to find failed ones.
+// @Description  label filters by labels, e.g. ?label=user:123. order=lru lists least recently accessed through webseed
+// @Description  first (by access stats, falling back to last_accessed_at and created_at), e.g. to pick resources to evict.
+// @Tags         resource
+// @Param        status  query     string  false  "Resource status, e.g. store_error"
+// @Param        order   query     string  false  "updated (default) or lru"
+// @Param        label   query     []string  false  "Label selector, key=value, key:value or key, repeated terms must all match"  collectionFormat(multi)
+// @Param        limit   query     int     false  "Page size (default 50, max 1000)"
+// @Param        offset  query     int     false  "Page offset"
+// @Success      200     {object}  ResourcesResponse
+// @Failure      400     {object}  ErrorResponse
+// @Failure      500     {object}  ErrorResponse
+// @Router       /resources [get]
+func (s *Web) getResources(c *gin.Context) {
+	db := s.pg.Read()
+	if db == nil {
+		_ = c.Error(errors.New("DB not configured"))
+		return
+	}
+	limit, offset, err := parseListLimits(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	var status *Status
+	if v := c.Query("status"); v != "" {
+		st, err := ParseStatus(v)
+		if err != nil {
+			_ = c.Error(err)
+			return
+		}
+		status = &st
+	}
+	sel, err := ParseLabelQuery(c.QueryArray("label"))
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	lru := false
+	switch v := c.Query("order"); v {
+	case "", "updated":
+	case "lru":
+		lru = true
+	default:
+		_ = c.Error(errors.Errorf("failed to parse order %v", v))
+		return
+	}
+	list, total, err := ResourceList(c.Request.Context(), db, s.auth.Tenant(c), status, sel, lru, limit, offset)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	now := time.Now()
+	for i := range list {
+		list[i].SetTTL(now)
+	}
+	c.JSON(http.StatusOK, &ResourcesResponse{Resources: list, Total: total, Limit: limit, Offset: offset})
+}
+
+const maxBatchSize = 1000
+
+// BatchRequest lists resources to queue for storing and deletion.
+type BatchRequest struct {
+	Store  []string `json:"store"`
+	Delete []string `json:"delete"`
+}
+
+// BatchResultStatus describes what happened to a single resource in a batch.
+type BatchResultStatus string
+
+const (
+	BatchResultQueued    BatchResultStatus = "queued"
+	BatchResultCancelled BatchResultStatus = "cancelled"
+	BatchResultNotFound  BatchResultStatus = "not_found"
+)
+
+// BatchResult is a per-id result of a batch operation.
+type BatchResult struct {
+	ID        string            `json:"resource_id"`
+	Operation OperationType     `json:"operation_type"`
+	Result    BatchResultStatus `json:"result"`
+	Resource  *Resource         `json:"resource,omitempty"`
+}
+
+// BatchResponse contains results in the order ids were submitted (stores first, then deletes).
+type BatchResponse struct {
+	Results []BatchResult `json:"results"`
+}
+
+// POST /resources/batch — queue storing/deletion of many resources at once
+// postResourcesBatch godoc
+// @Summary      Queue storing and deletion of multiple resources
+// @Description  Queues all resources in a single transaction. Either every id is queued or none is.
+// @Tags         resource
+// @Accept       json
+// @Param        request  body      BatchRequest  true  "Resource IDs to store and delete"
+// @Success      200      {object}  BatchResponse
+// @Failure      400      {object}  ErrorResponse
+// @Failure      500      {object}  ErrorResponse
+// @Router       /resources/batch [post]
+func (s *Web) postResourcesBatch(c *gin.Context) {
+	db := s.pg.Get()
+	if db == nil {
+		_ = c.Error(errors.New("DB not configured"))
+		return
+	}
+	var req BatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		_ = c.Error(errors.Wrap(err, "failed to parse batch request"))
+		return
+	}
+	if err := req.validate(); err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if len(req.Delete) > 0 && !s.auth.Allowed(c, TokenScopeDelete) {
+		_ = c.Error(errors.Errorf("forbidden: %v scope required", TokenScopeDelete))
+		return
+	}
+	tenant := s.auth.Tenant(c)
+	var results []BatchResult
+	err := db.RunInTransaction(c.Request.Context(), func(tx *pg.Tx) error {
+		results = make([]BatchResult, 0, len(req.Store)+len(req.Delete))
+		for _, id := range req.Store {
+			res, err := s.createOwned(c.Request.Context(), tx, tenant, id, func() (*Resource, error) {
+				return ResourceQueueForStoring(c.Request.Context(), tx, id)
+			})
+			if err != nil {
+				return errors.Wrapf(err, "failed to queue storing of %v", id)
+			}
+			results = append(results, BatchResult{ID: id, Operation: OperationStore, Result: BatchResultQueued, Resource: res})
+		}
+		for _, id := range req.Delete {
+			existing, err := ResourceGetByID(c.Request.Context(), tx, id)
+			if err != nil {
+				return errors.Wrapf(err, "failed to load %v", id)
+			}
+			if existing == nil || !ownedBy(existing, tenant) {
+				results = append(results, BatchResult{ID: id, Operation: OperationDelete, Result: BatchResultNotFound})
+				continue
+			}
+			res, err := ResourceQueueForDeletion(c.Request.Context(), tx, id)
+			if err != nil {
+				return errors.Wrapf(err, "failed to queue deletion of %v", id)
+			}
+			r := BatchResult{ID: id, Operation: OperationDelete, Result: BatchResultQueued, Resource: res}
+			if res == nil {
+				r.Result = BatchResultCancelled
+			}
+			results = append(results, r)
+		}
+		return nil
+	})
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	for _, id := range req.Delete {
+		s.lookup.Invalidate(c.Request.Context(), id)
+	}
+	s.abuse.Record(AbuseKindRegister, c.ClientIP(), int64(len(req.Store)))
+	c.JSON(http.StatusOK, &BatchResponse{Results: results})
+}
+
+func (r *BatchRequest) validate() error {
+	if len(r.Store)+len(r.Delete) == 0 {
+		return errors.New("failed to parse batch request: no ids provided")
+	}
+	if len(r.Store)+len(r.Delete) > maxBatchSize {
+		return errors.Errorf("failed to parse batch request: at most %v ids allowed", maxBatchSize)
+	}
+	seen := map[string]bool{}
+	for _, id := range append(append([]string{}, r.Store...), r.Delete...) {
+		if id == "" {
+			return errors.New("failed to parse batch request: empty id")
+		}
+		if seen[id] {
+			return errors.Errorf("failed to parse batch request: duplicate id %v", id)
+		}
+		seen[id] = true
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package services
+
+import (
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+)
+
+// ResourceFileStatus is the storing state of a single file of a resource.
+type ResourceFileStatus struct {
+	ResourceID string `json:"resource_id"`
+	Path       string `json:"path"`
+	Status     Status `json:"status"`
+	// Hash of the stored file, absent until the file is stored
+	Hash         *string    `json:"hash,omitempty"`
+	TotalSize    int64      `json:"total_size"`
+	StoredSize   int64      `json:"stored_size"`
+	StorageClass *string    `json:"storage_class,omitempty"`
+	Error        *string    `json:"error,omitempty"`
+	UpdatedAt    *time.Time `json:"updated_at,omitempty"`
+	// Progress of the file while the store job hashes or uploads it
+	Progress *FileStoreProgress `json:"progress,omitempty"`
+}
+
+// resourceFileStatus resolves state of the file at path. Returns nil if the resource doesn't exist
+// or the file is neither stored, failed nor pending.
+func resourceFileStatus(c *gin.Context, db *pg.DB, id, path string) (*ResourceFileStatus, error) {
+	ctx := c.Request.Context()
+	res, err := ResourceGetByID(ctx, db, id)
+	if err != nil || res == nil {
+		return nil, err
+	}
+	st := &ResourceFileStatus{ResourceID: id, Path: path}
+	rf := &ResourceFile{}
+	err = db.Model(rf).Context(ctx).
+		Relation("File").
+		Where("resource_file.resource_id = ? AND resource_file.path = ?", id, path).
+		Select()
+	if err != nil && !errors.Is(err, pg.ErrNoRows) {
+		return nil, err
+	}
+	if err == nil && rf.File != nil {
+		st.Status = rf.File.Status
+		st.Hash = &rf.File.Hash
+		st.TotalSize = rf.File.TotalSize
+		st.StoredSize = rf.File.StoredSize
+		st.StorageClass = rf.File.StorageClass
+		st.UpdatedAt = &rf.File.UpdatedAt
+	}
+	queued := (res.Status == StatusQueuedForStoring || res.Status == StatusStoring) &&
+		(len(res.StorePaths) == 0 || slices.Contains(res.StorePaths, path))
+	if st.Hash != nil && st.Status == StatusStored {
+		return st, nil
+	}
+	if queued {
+		st.Status = StatusQueuedForStoring
+		st.Progress = res.Progress.File(path)
+		return st, nil
+	}
+	fe, err := ResourceFileErrorGet(ctx, db, id, path)
+	if err != nil {
+		return nil, err
+	}
+	if fe != nil {
+		st.Status = StatusStoreError
+		st.Error = &fe.Error
+		st.UpdatedAt = &fe.UpdatedAt
+		return st, nil
+	}
+	if st.Hash != nil {
+		return st, nil
+	}
+	return nil, nil
+}
+
+// GET /resource/{id}/file
+// getResourceFile godoc
+// @Summary      Get status of a single file
+// @Description  Returns storing state of the file at path: stored (or corrupted) file with its hash,
+// @Description  store_error with the error of its last attempt, or queued_for_storing while a store job is pending.
+// @Description  While the job stores the file, progress tells whether it is being hashed or uploaded and how many bytes are done.
+// @Tags         resource
+// @Param        id    path      string  true  "Resource ID"
+// @Param        path  query     string  true  "Path inside resource"
+// @Success      200  {object}  ResourceFileStatus
+// @Failure      400  {object}  ErrorResponse
+// @Failure      404  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /resource/{id}/file [get]
+func (s *Web) getResourceFile(c *gin.Context) {
+	db := s.pg.Get()
+	if db == nil {
+		_ = c.Error(errors.New("DB not configured"))
+		return
+	}
+	p := NormalizePath(c.Query("path"))
+	if p == "" {
+		_ = c.Error(errors.New("failed to parse path: path is required"))
+		return
+	}
+	st, err := resourceFileStatus(c, db, c.Param("id"), p)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if st == nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	c.JSON(http.StatusOK, st)
+}
+
+// POST /resource/{id}/file/retry
+// postResourceFileRetry godoc
+// @Summary      Re-store a single file
+// @Description  Queues a store job limited to the file at path of a store_error, stored or corrupted resource,
+// @Description  other files of the resource are kept as is. The retry is recorded in the operation log.
+// @Tags         resource
+// @Param        id    path      string  true  "Resource ID"
+// @Param        path  query     string  true  "Path inside resource"
+// @Success      202  {object}  Resource
+// @Failure      400  {object}  ErrorResponse
+// @Failure      404  {object}  ErrorResponse
+// @Failure      409  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /resource/{id}/file/retry [post]
+func (s *Web) postResourceFileRetry(c *gin.Context) {
+	db := s.pg.Get()
+	if db == nil {
+		_ = c.Error(errors.New("DB not configured"))
+		return
+	}
+	p := NormalizePath(c.Query("path"))
+	if p == "" {
+		_ = c.Error(errors.New("failed to parse path: path is required"))
+		return
+	}
+	var res *Resource
+	err := db.RunInTransaction(c.Request.Context(), func(tx *pg.Tx) (err error) {
+		res, err = ResourceRetryFile(c.Request.Context(), tx, c.Param("id"), p)
+		return
+	})
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if res == nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"resource": res.SetTTL(time.Now())})
+}
@@ -0,0 +1,153 @@
+package services
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	awss3 "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	cs "github.com/webtor-io/common-services"
+)
+
+const (
+	s3EndpointFlag        = "s3-endpoint"
+	s3PathStyleFlag       = "s3-path-style"
+	s3CABundleFlag        = "s3-ca-bundle"
+	s3ConnectTimeoutFlag  = "s3-connect-timeout"
+	s3ResponseTimeoutFlag = "s3-response-timeout"
+	s3CheckOnStartFlag    = "s3-check-on-start"
+	s3CheckTimeoutFlag    = "s3-check-timeout"
+)
+
+// awsEndpointFlag is the endpoint flag of common-services S3 client, overridden by s3-endpoint.
+const awsEndpointFlag = "aws-endpoint"
+
+// RegisterS3Flags registers common-services S3 client flags along with options of S3-compatible endpoints.
+func RegisterS3Flags(f []cli.Flag) []cli.Flag {
+	return append(cs.RegisterS3ClientFlags(f),
+		cli.StringFlag{
+			Name:   s3EndpointFlag,
+			Usage:  "URL of S3-compatible endpoint with scheme, e.g. https://minio.local:9000 (overrides aws-endpoint)",
+			EnvVar: "S3_ENDPOINT",
+		},
+		cli.BoolTFlag{
+			Name:   s3PathStyleFlag,
+			Usage:  "address buckets by path (endpoint/bucket/key) instead of virtual host (bucket.endpoint/key)",
+			EnvVar: "S3_PATH_STYLE",
+		},
+		cli.StringFlag{
+			Name:   s3CABundleFlag,
+			Usage:  "path of PEM bundle with CA certificates trusted for the S3 endpoint in addition to system ones",
+			EnvVar: "S3_CA_BUNDLE",
+		},
+		cli.DurationFlag{
+			Name:   s3ConnectTimeoutFlag,
+			Usage:  "timeout of establishing connection to S3, including TLS handshake",
+			Value:  30 * time.Second,
+			EnvVar: "S3_CONNECT_TIMEOUT",
+		},
+		cli.DurationFlag{
+			Name:   s3ResponseTimeoutFlag,
+			Usage:  "timeout of waiting for S3 response headers after the request is sent (0 disables)",
+			Value:  time.Minute,
+			EnvVar: "S3_RESPONSE_TIMEOUT",
+		},
+		cli.BoolTFlag{
+			Name:   s3CheckOnStartFlag,
+			Usage:  "check that the bucket is reachable on startup and fail to start otherwise",
+			EnvVar: "S3_CHECK_ON_START",
+		},
+		cli.DurationFlag{
+			Name:   s3CheckTimeoutFlag,
+			Usage:  "timeout of the startup S3 check",
+			Value:  30 * time.Second,
+			EnvVar: "S3_CHECK_TIMEOUT",
+		},
+	)
+}
+
+// s3HTTPClient makes HTTP client of S3 requests trusting the CA bundle. Bodies are streamed to webseed
+// clients for as long as they read, so there is no overall request timeout.
+func s3HTTPClient(c *cli.Context) (*http.Client, error) {
+	tlsConfig := &tls.Config{}
+	if path := c.String(s3CABundleFlag); path != "" {
+		pem, err := os.ReadFile(path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read %v", s3CABundleFlag)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.Errorf("no certificates found in %v %v", s3CABundleFlag, path)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	connectTimeout := c.Duration(s3ConnectTimeoutFlag)
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.DialContext = (&net.Dialer{
+		Timeout:   connectTimeout,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	t.TLSHandshakeTimeout = connectTimeout
+	t.ResponseHeaderTimeout = c.Duration(s3ResponseTimeoutFlag)
+	t.TLSClientConfig = tlsConfig
+	return &http.Client{Transport: t}, nil
+}
+
+// NewS3Client makes common-services S3 client, shared by worker and webseed, with the endpoint, addressing
+// style, CA bundle and timeouts of flags. Returns nil if S3 credentials are not configured.
+func NewS3Client(c *cli.Context) (*cs.S3Client, error) {
+	if e := c.String(s3EndpointFlag); e != "" {
+		u, err := url.Parse(e)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, errors.Errorf("failed to parse %v %q: http or https URL is required", s3EndpointFlag, e)
+		}
+		if err := c.Set(awsEndpointFlag, e); err != nil {
+			return nil, err
+		}
+	}
+	cl, err := s3HTTPClient(c)
+	if err != nil {
+		return nil, err
+	}
+	s3c := cs.NewS3Client(c, cl)
+	if s3c == nil {
+		return nil, nil
+	}
+	// common-services always addresses buckets by path
+	s3c.Get().Config.S3ForcePathStyle = aws.Bool(c.BoolT(s3PathStyleFlag))
+	return s3c, nil
+}
+
+// CheckS3 fails unless the bucket is reachable with the client, if enabled by s3-check-on-start.
+func CheckS3(c *cli.Context, s3c *cs.S3Client) error {
+	if !c.BoolT(s3CheckOnStartFlag) || s3c == nil {
+		return nil
+	}
+	bucket := c.String(awsBucketFlag)
+	if bucket == "" {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.Duration(s3CheckTimeoutFlag))
+	defer cancel()
+	cl := s3c.Get()
+	_, err := cl.HeadBucketWithContext(ctx, &awss3.HeadBucketInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to reach bucket %v at %v", bucket, cl.Endpoint)
+	}
+	log.WithFields(log.Fields{"bucket": bucket, "endpoint": cl.Endpoint}).Info("S3 bucket is reachable")
+	return nil
+}
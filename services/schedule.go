@@ -0,0 +1,215 @@
+package services
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+)
+
+const (
+	heavyJobWindowsFlag  = "heavy-job-windows"
+	heavyJobTimezoneFlag = "heavy-job-timezone"
+	heavyStoreSizeFlag   = "heavy-store-size"
+)
+
+// RegisterScheduleFlags registers CLI flags for heavy job time windows.
+func RegisterScheduleFlags(f []cli.Flag) []cli.Flag {
+	return append(f,
+		cli.StringFlag{
+			Name:   heavyJobWindowsFlag,
+			Usage:  "time windows heavy jobs may run in, e.g. \"Mon-Fri 01:00-06:00; Sat-Sun 00:00-24:00\" (empty allows any time)",
+			EnvVar: "HEAVY_JOB_WINDOWS",
+		},
+		cli.StringFlag{
+			Name:   heavyJobTimezoneFlag,
+			Usage:  "timezone of heavy job windows",
+			Value:  "UTC",
+			EnvVar: "HEAVY_JOB_TIMEZONE",
+		},
+		cli.Int64Flag{
+			Name:   heavyStoreSizeFlag,
+			Usage:  "stores of resources of at least this size in bytes are heavy jobs (0 makes every store heavy)",
+			EnvVar: "HEAVY_STORE_SIZE",
+		},
+	)
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// scheduleWindow is a daily period [start, end) in minutes since midnight on selected weekdays.
+// Windows with end before start cross midnight and belong to the weekday they start on.
+type scheduleWindow struct {
+	days       [7]bool
+	start, end int
+}
+
+func (s *scheduleWindow) contains(t time.Time) bool {
+	m := t.Hour()*60 + t.Minute()
+	wd := t.Weekday()
+	if s.start < s.end {
+		return s.days[wd] && m >= s.start && m < s.end
+	}
+	return (s.days[wd] && m >= s.start) || (s.days[(wd+6)%7] && m < s.end)
+}
+
+// Schedule restricts heavy jobs (large stores, storage class transitions) to configured time windows.
+type Schedule struct {
+	windows []scheduleWindow
+	loc     *time.Location
+	// heavyStoreSize is the resource size stores are restricted from (0 restricts all stores)
+	heavyStoreSize int64
+}
+
+// NewSchedule returns nil if no windows are configured, so heavy jobs may run any time.
+func NewSchedule(c *cli.Context) (*Schedule, error) {
+	v := strings.TrimSpace(c.String(heavyJobWindowsFlag))
+	if v == "" {
+		return nil, nil
+	}
+	loc, err := time.LoadLocation(c.String(heavyJobTimezoneFlag))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load heavy job timezone")
+	}
+	windows, err := parseScheduleWindows(v)
+	if err != nil {
+		return nil, err
+	}
+	return &Schedule{windows: windows, loc: loc, heavyStoreSize: c.Int64(heavyStoreSizeFlag)}, nil
+}
+
+// parseScheduleWindows parses "[days ]HH:MM-HH:MM" windows separated by ";". Days are weekday
+// ranges or lists like "Mon-Fri" or "Sat,Sun", windows without days apply to every day.
+func parseScheduleWindows(v string) ([]scheduleWindow, error) {
+	var res []scheduleWindow
+	for _, spec := range strings.Split(v, ";") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		var w scheduleWindow
+		fields := strings.Fields(spec)
+		switch len(fields) {
+		case 1:
+			for i := range w.days {
+				w.days[i] = true
+			}
+		case 2:
+			if err := parseWeekdays(fields[0], &w.days); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, errors.Errorf("failed to parse heavy job window %q", spec)
+		}
+		startStr, endStr, ok := strings.Cut(fields[len(fields)-1], "-")
+		if !ok {
+			return nil, errors.Errorf("failed to parse heavy job window %q", spec)
+		}
+		var err error
+		if w.start, err = parseClock(startStr); err != nil {
+			return nil, err
+		}
+		if w.end, err = parseClock(endStr); err != nil {
+			return nil, err
+		}
+		if w.start == w.end {
+			return nil, errors.Errorf("failed to parse heavy job window %q: empty window", spec)
+		}
+		res = append(res, w)
+	}
+	if len(res) == 0 {
+		return nil, errors.Errorf("failed to parse heavy job windows %q", v)
+	}
+	return res, nil
+}
+
+func parseWeekdays(v string, days *[7]bool) error {
+	for _, part := range strings.Split(v, ",") {
+		from, to, isRange := strings.Cut(strings.ToLower(part), "-")
+		f, ok := weekdays[from]
+		if !ok {
+			return errors.Errorf("failed to parse weekday %q", from)
+		}
+		t := f
+		if isRange {
+			if t, ok = weekdays[to]; !ok {
+				return errors.Errorf("failed to parse weekday %q", to)
+			}
+		}
+		for d := f; ; d = (d + 1) % 7 {
+			days[d] = true
+			if d == t {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// parseClock parses "HH:MM" (24:00 allowed as end of day) into minutes since midnight.
+func parseClock(v string) (int, error) {
+	h, m, ok := strings.Cut(v, ":")
+	if !ok {
+		return 0, errors.Errorf("failed to parse time %q", v)
+	}
+	hh, err := strconv.Atoi(h)
+	if err != nil {
+		return 0, errors.Errorf("failed to parse time %q", v)
+	}
+	mm, err := strconv.Atoi(m)
+	if err != nil || mm < 0 || mm > 59 || hh < 0 || hh > 24 || (hh == 24 && mm != 0) {
+		return 0, errors.Errorf("failed to parse time %q", v)
+	}
+	return hh*60 + mm, nil
+}
+
+// Open reports whether heavy jobs may run at the moment. Nil schedule is always open.
+func (s *Schedule) Open(now time.Time) bool {
+	if s == nil {
+		return true
+	}
+	t := now.In(s.loc)
+	for _, w := range s.windows {
+		if w.contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// heavyStore reports whether storing of a resource of the size may run only within windows.
+func (s *Schedule) heavyStore(size int64) bool {
+	return s != nil && size >= s.heavyStoreSize
+}
+
+// deferHeavyStore puts the resource back to the queue if it is a heavy store and heavy jobs are not
+// allowed at the moment. Resource size is recorded so the worker skips it until a window opens.
+func (s *Worker) deferHeavyStore(ctx context.Context, db *pg.DB, id string) (bool, error) {
+	if s.schedule.Open(time.Now()) {
+		return false, nil
+	}
+	var size int64
+	if s.schedule.heavyStoreSize > 0 {
+		items, err := s.api.ListResourceFiles(ctx, &Claims{Role: "vault"}, id)
+		if err != nil {
+			return false, err
+		}
+		for _, item := range items {
+			size += item.Size
+		}
+	}
+	if !s.schedule.heavyStore(size) {
+		return false, nil
+	}
+	if err := ResourceDeferStore(ctx, db, id, size); err != nil {
+		return false, err
+	}
+	return true, nil
+}
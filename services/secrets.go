@@ -0,0 +1,300 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	cs "github.com/webtor-io/common-services"
+)
+
+const (
+	apiSecretFileFlag          = "webtor-secret-file"
+	tokenSecretFileFlag        = "token-secret-file"
+	awsAccessKeyIDFileFlag     = "aws-access-key-id-file"
+	awsSecretAccessKeyFileFlag = "aws-secret-access-key-file"
+	secretsReloadIntervalFlag  = "secrets-reload-interval"
+
+	// S3 client flags registered by common-services
+	awsAccessKeyIDFlag     = "aws-access-key-id"
+	awsSecretAccessKeyFlag = "aws-secret-access-key"
+)
+
+// RegisterSecretsFlags registers CLI flags for loading secrets from files (e.g. mounted K8s secrets).
+func RegisterSecretsFlags(f []cli.Flag) []cli.Flag {
+	return append(f,
+		cli.StringFlag{
+			Name:   apiSecretFileFlag,
+			Usage:  "path to a file with webtor api secret (overrides webtor-secret)",
+			EnvVar: "WEBTOR_API_SECRET_FILE",
+		},
+		cli.StringFlag{
+			Name:   tokenSecretFileFlag,
+			Usage:  "path to a file with secret signing vault scoped tokens (overrides token-secret)",
+			EnvVar: "VAULT_TOKEN_SECRET_FILE",
+		},
+		cli.StringFlag{
+			Name:   awsAccessKeyIDFileFlag,
+			Usage:  "path to a file with AWS access key id (overrides aws-access-key-id)",
+			EnvVar: "AWS_ACCESS_KEY_ID_FILE",
+		},
+		cli.StringFlag{
+			Name:   awsSecretAccessKeyFileFlag,
+			Usage:  "path to a file with AWS secret access key (overrides aws-secret-access-key)",
+			EnvVar: "AWS_SECRET_ACCESS_KEY_FILE",
+		},
+		cli.DurationFlag{
+			Name:   secretsReloadIntervalFlag,
+			Usage:  "how often secret files are checked for changes",
+			Value:  30 * time.Second,
+			EnvVar: "SECRETS_RELOAD_INTERVAL",
+		},
+	)
+}
+
+// Secret holds a value which may be reloaded from file at any time.
+type Secret struct {
+	mux   sync.RWMutex
+	path  string
+	value []byte
+}
+
+// Get returns current value of the secret.
+func (s *Secret) Get() []byte {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.value
+}
+
+// String returns current value of the secret as string.
+func (s *Secret) String() string {
+	return string(s.Get())
+}
+
+// set replaces value and reports whether it changed.
+func (s *Secret) set(b []byte) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if bytes.Equal(b, s.value) {
+		return false
+	}
+	s.value = b
+	return true
+}
+
+// reload reads secret file and reports whether the value changed.
+func (s *Secret) reload() (bool, error) {
+	if s.path == "" {
+		return false, nil
+	}
+	b, err := os.ReadFile(s.path)
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to read secret file %v", s.path)
+	}
+	return s.set(bytes.TrimSpace(b)), nil
+}
+
+// Secrets loads secrets from flags, HashiCorp Vault or files (in increasing precedence) and keeps them
+// up to date, so that rotated secrets are picked up without restart.
+type Secrets struct {
+	ctx                context.Context
+	cancel             context.CancelFunc
+	interval           time.Duration
+	hcv                *HCVault
+	WebtorSecret       *Secret
+	TokenSecret        *Secret
+	AWSAccessKeyID     *Secret
+	AWSSecretAccessKey *Secret
+	// EncryptionKey is fetched from HashiCorp Vault or decrypted with KMS at startup only,
+	// as changing the master key would make existing objects unreadable
+	EncryptionKey *Secret
+	// s3Version is incremented every time S3 credentials change
+	s3Version atomic.Int64
+}
+
+func NewSecrets(c *cli.Context, cl *http.Client) (*Secrets, error) {
+	hcv, err := NewHCVault(c, cl)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Secrets{
+		ctx:                ctx,
+		cancel:             cancel,
+		interval:           c.Duration(secretsReloadIntervalFlag),
+		hcv:                hcv,
+		WebtorSecret:       &Secret{path: c.String(apiSecretFileFlag), value: []byte(c.String(apiSecretFlag))},
+		TokenSecret:        &Secret{path: c.String(tokenSecretFileFlag), value: []byte(c.String(tokenSecretFlag))},
+		AWSAccessKeyID:     &Secret{path: c.String(awsAccessKeyIDFileFlag), value: []byte(c.String(awsAccessKeyIDFlag))},
+		AWSSecretAccessKey: &Secret{path: c.String(awsSecretAccessKeyFileFlag), value: []byte(c.String(awsSecretAccessKeyFlag))},
+		EncryptionKey:      &Secret{},
+	}
+	if err := s.init(c); err != nil {
+		cancel()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Secrets) init(c *cli.Context) error {
+	if s.hcv != nil {
+		values, err := s.hcv.Fetch(s.ctx)
+		if err != nil {
+			return err
+		}
+		s.applyHCVault(values)
+		if v, ok := values[hcVaultKeyEncryptionKey]; ok {
+			s.EncryptionKey.set([]byte(v))
+		}
+		log.WithField("path", s.hcv.path).Info("secrets fetched from vault")
+	}
+	for _, sec := range s.all() {
+		if _, err := sec.reload(); err != nil {
+			return err
+		}
+	}
+	key, err := decryptKMSKey(s.ctx, c, s)
+	if err != nil {
+		return err
+	}
+	if key != nil {
+		s.EncryptionKey.set(key)
+		log.Info("encryption key decrypted with kms")
+	}
+	// S3 client from common-services is configured with flags only
+	if s.s3Managed() {
+		if err := c.Set(awsAccessKeyIDFlag, s.AWSAccessKeyID.String()); err != nil {
+			return err
+		}
+		if err := c.Set(awsSecretAccessKeyFlag, s.AWSSecretAccessKey.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyHCVault sets values fetched from HashiCorp Vault to secrets which are not loaded from files.
+func (s *Secrets) applyHCVault(values map[string]string) {
+	m := map[string]*Secret{
+		hcVaultKeyWebtorSecret:       s.WebtorSecret,
+		hcVaultKeyTokenSecret:        s.TokenSecret,
+		hcVaultKeyAWSAccessKeyID:     s.AWSAccessKeyID,
+		hcVaultKeyAWSSecretAccessKey: s.AWSSecretAccessKey,
+	}
+	for k, sec := range m {
+		v, ok := values[k]
+		if !ok || sec.path != "" {
+			continue
+		}
+		if sec.set([]byte(v)) {
+			s.changed(k, sec)
+		}
+	}
+}
+
+func (s *Secrets) changed(name string, sec *Secret) {
+	if sec == s.AWSAccessKeyID || sec == s.AWSSecretAccessKey {
+		s.s3Version.Add(1)
+	}
+	log.WithField("secret", name).Info("secret reloaded")
+}
+
+func (s *Secrets) all() map[string]*Secret {
+	return map[string]*Secret{
+		apiSecretFlag:          s.WebtorSecret,
+		tokenSecretFlag:        s.TokenSecret,
+		awsAccessKeyIDFlag:     s.AWSAccessKeyID,
+		awsSecretAccessKeyFlag: s.AWSSecretAccessKey,
+	}
+}
+
+// s3Managed reports whether S3 credentials come from files or HashiCorp Vault rather than flags.
+func (s *Secrets) s3Managed() bool {
+	return s.AWSAccessKeyID.path != "" || s.AWSSecretAccessKey.path != "" || s.hcv != nil
+}
+
+// WatchS3 makes S3 client use the current managed credentials, so they can be rotated at runtime.
+func (s *Secrets) WatchS3(s3 *cs.S3Client) {
+	if s3 == nil || !s.s3Managed() {
+		return
+	}
+	s3.Get().Config.Credentials = credentials.NewCredentials(&secretsCredentialsProvider{s: s})
+}
+
+func (s *Secrets) Serve() error {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	var hcvC <-chan time.Time
+	if s.hcv != nil {
+		hcvTicker := time.NewTicker(s.hcv.interval)
+		defer hcvTicker.Stop()
+		hcvC = hcvTicker.C
+	}
+	for {
+		select {
+		case <-s.ctx.Done():
+			return nil
+		case <-ticker.C:
+			s.reload()
+		case <-hcvC:
+			s.refreshHCVault()
+		}
+	}
+}
+
+func (s *Secrets) reload() {
+	for name, sec := range s.all() {
+		changed, err := sec.reload()
+		if err != nil {
+			log.WithError(err).WithField("secret", name).Error("failed to reload secret")
+			continue
+		}
+		if changed {
+			s.changed(name, sec)
+		}
+	}
+}
+
+func (s *Secrets) refreshHCVault() {
+	if err := s.hcv.RenewToken(s.ctx); err != nil {
+		log.WithError(err).Warn("failed to renew vault token")
+	}
+	values, err := s.hcv.Fetch(s.ctx)
+	if err != nil {
+		log.WithError(err).Error("failed to refresh secrets from vault")
+		return
+	}
+	s.applyHCVault(values)
+}
+
+func (s *Secrets) Close() {
+	log.Info("closing Secrets")
+	s.cancel()
+}
+
+// secretsCredentialsProvider provides S3 credentials from secrets, expiring them once files change.
+type secretsCredentialsProvider struct {
+	s       *Secrets
+	version int64
+}
+
+func (p *secretsCredentialsProvider) Retrieve() (credentials.Value, error) {
+	p.version = p.s.s3Version.Load()
+	return credentials.Value{
+		AccessKeyID:     p.s.AWSAccessKeyID.String(),
+		SecretAccessKey: p.s.AWSSecretAccessKey.String(),
+		ProviderName:    "VaultSecretsProvider",
+	}, nil
+}
+
+func (p *secretsCredentialsProvider) IsExpired() bool {
+	return p.version != p.s.s3Version.Load()
+}
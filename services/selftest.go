@@ -0,0 +1,255 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultSelfTestRanges    = 3
+	maxSelfTestRanges        = 16
+	defaultSelfTestRangeSize = 64 * 1024
+	maxSelfTestRangeSize     = 4 * 1024 * 1024
+	// selfTestMaxHashSize caps files whose full-content hash is checked through webseed
+	selfTestMaxHashSize = 16 * 1024 * 1024
+)
+
+// RangeCheck is a single range fetched through webseed.
+type RangeCheck struct {
+	Start  int64  `json:"start"`
+	End    int64  `json:"end"`
+	Status int    `json:"status"`
+	Passed bool   `json:"passed"`
+	Error  string `json:"error,omitempty"`
+}
+
+// FileSelfTest is the result of serving a single file through webseed.
+type FileSelfTest struct {
+	Path   string       `json:"path"`
+	Hash   string       `json:"hash"`
+	Size   int64        `json:"size"`
+	Passed bool         `json:"passed"`
+	Ranges []RangeCheck `json:"ranges"`
+	// HashChecked is set if content hashed by the file algorithm was fetched through webseed: always
+	// for sampled hashes, for full hashes only if the file is small enough
+	HashChecked bool   `json:"hash_checked"`
+	Error       string `json:"error,omitempty"`
+}
+
+// SelfTestReport tells whether every stored file of the resource is servable.
+type SelfTestReport struct {
+	ResourceID string         `json:"resource_id"`
+	Passed     bool           `json:"passed"`
+	Files      []FileSelfTest `json:"files"`
+}
+
+// selfTester fetches files through the webseed handler chain, including auth, rate limiting and
+// abuse checks, as a client with token from remoteAddr.
+type selfTester struct {
+	web        *Web
+	ctx        context.Context
+	token      string
+	remoteAddr string
+}
+
+// do sends the request with headers to the webseed path of the resource.
+func (s *selfTester) do(method, id, path string, header http.Header) (*httptest.ResponseRecorder, error) {
+	h := s.web.handler.Load()
+	if h == nil {
+		return nil, errors.New("web is not serving")
+	}
+	u := (&url.URL{Path: "/webseed/" + id + path}).EscapedPath()
+	req, err := http.NewRequestWithContext(s.ctx, method, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.RemoteAddr = s.remoteAddr
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if s.token != "" {
+		req.Header.Set("X-Token", s.token)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w, nil
+}
+
+func (s *selfTester) request(id, path, rng string) (*httptest.ResponseRecorder, error) {
+	header := http.Header{}
+	if rng != "" {
+		header.Set("Range", rng)
+	}
+	return s.do(http.MethodGet, id, path, header)
+}
+
+// fetchRange fetches bytes [start, end] of the file through webseed, returns status of the response.
+func (s *selfTester) fetchRange(id string, rf *ResourceFile, start, end int64) ([]byte, int, error) {
+	br := &byteRange{start: start, end: end}
+	w, err := s.request(id, rf.Path, br.String())
+	if err != nil {
+		return nil, 0, err
+	}
+	body := w.Body.Bytes()
+	switch {
+	case w.Code == http.StatusPartialContent:
+		return body, w.Code, nil
+	case w.Code == http.StatusOK && int64(len(body)) == rf.File.TotalSize:
+		// whole file served instead of the range
+		return body[start : end+1], w.Code, nil
+	}
+	return nil, w.Code, errors.Errorf("unexpected status %v of %v", w.Code, br)
+}
+
+// checkRange fetches bytes [start, end] through webseed and compares them with the stored object.
+func (s *selfTester) checkRange(id string, rf *ResourceFile, eo *encryptedObject, start, end int64) RangeCheck {
+	rc := RangeCheck{Start: start, End: end}
+	got, status, err := s.fetchRange(id, rf, start, end)
+	rc.Status = status
+	if err != nil {
+		rc.Error = err.Error()
+		return rc
+	}
+	var want bytes.Buffer
+	if err := s.web.verifier.copyFileRange(s.ctx, &want, rf.File, eo, start, end); err != nil {
+		rc.Error = err.Error()
+		return rc
+	}
+	if !bytes.Equal(got, want.Bytes()) {
+		rc.Error = fmt.Sprintf("content of %v bytes served does not match stored object", len(got))
+		return rc
+	}
+	rc.Passed = true
+	return rc
+}
+
+// checkHash fetches content hashed by the file algorithm through webseed and compares its hash.
+func (s *selfTester) checkHash(id string, rf *ResourceFile) error {
+	hash, err := hashFile(rf.File, func(h io.Writer, start, end int64) error {
+		if end < start {
+			return nil
+		}
+		body, _, err := s.fetchRange(id, rf, start, end)
+		if err != nil {
+			return err
+		}
+		_, err = h.Write(body)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if hash != rf.FileHash {
+		return errors.Errorf("content hash %v served does not match", hash)
+	}
+	return nil
+}
+
+func (s *selfTester) checkFile(id string, rf *ResourceFile, ranges int, rangeSize int64) FileSelfTest {
+	f := rf.File
+	ft := FileSelfTest{Path: rf.Path, Hash: f.Hash, Size: f.TotalSize, Ranges: []RangeCheck{}}
+	var eo *encryptedObject
+	if !f.Chunked {
+		var err error
+		if eo, _, err = s.web.headEncryptedObject(s.ctx, f.bucketOr(s.web.bucket), f.Hash); err != nil {
+			ft.Error = err.Error()
+			return ft
+		}
+	}
+	ft.Passed = true
+	for i := 0; i < ranges && f.TotalSize > 0; i++ {
+		n := min(rangeSize, f.TotalSize)
+		start := rand.Int63n(f.TotalSize - n + 1)
+		rc := s.checkRange(id, rf, eo, start, start+n-1)
+		ft.Passed = ft.Passed && rc.Passed
+		ft.Ranges = append(ft.Ranges, rc)
+	}
+	if f.HashAlgo == HashAlgoSampled || f.TotalSize <= selfTestMaxHashSize {
+		ft.HashChecked = true
+		if err := s.checkHash(id, rf); err != nil {
+			ft.Passed = false
+			ft.Error = err.Error()
+		}
+	}
+	return ft
+}
+
+// POST /admin/resource/{id}/self-test
+// postResourceSelfTest godoc
+// @Summary      Self-test webseed of the resource
+// @Description  Fetches random ranges of every stored file through the full webseed path (with token of the request) and
+// @Description  compares them with stored objects. Content covered by the file hash (head and tail of sampled hashes, whole
+// @Description  files up to 16MB for full hashes) is fetched the same way and re-hashed. Files are not marked corrupted.
+// @Tags         admin
+// @Param        id          path      string  true   "Resource ID"
+// @Param        ranges      query     int     false  "Random ranges per file (default 3, max 16)"
+// @Param        range_size  query     int     false  "Size of random ranges in bytes (default 65536, max 4MB)"
+// @Success      200  {object}  SelfTestReport
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      403  {object}  ErrorResponse
+// @Failure      404  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /admin/resource/{id}/self-test [post]
+func (s *Web) postResourceSelfTest(c *gin.Context) {
+	db := s.pg.Get()
+	if db == nil {
+		_ = c.Error(errors.New("DB not configured"))
+		return
+	}
+	ranges, err := parseIntParam(c, "ranges", defaultSelfTestRanges, 0, maxSelfTestRanges)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	rangeSize, err := parseIntParam(c, "range_size", defaultSelfTestRangeSize, 1, maxSelfTestRangeSize)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	ctx := c.Request.Context()
+	id := c.Param("id")
+	res, err := ResourceGetByID(ctx, db, id)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if res == nil {
+		_ = c.Error(errors.New("resource not found"))
+		return
+	}
+	var rfs []ResourceFile
+	err = db.Model(&rfs).Context(ctx).
+		Relation("File").
+		Where("resource_file.resource_id = ?", id).
+		Order("resource_file.path").
+		Select()
+	if err != nil && !errors.Is(err, pg.ErrNoRows) {
+		_ = c.Error(err)
+		return
+	}
+	report := &SelfTestReport{ResourceID: id, Passed: true, Files: []FileSelfTest{}}
+	st := &selfTester{web: s, ctx: ctx, token: c.GetHeader("X-Token"), remoteAddr: c.Request.RemoteAddr}
+	for i := range rfs {
+		rf := &rfs[i]
+		if rf.File == nil || rf.File.Status != StatusStored {
+			continue
+		}
+		ft := st.checkFile(id, rf, ranges, int64(rangeSize))
+		report.Passed = report.Passed && ft.Passed
+		report.Files = append(report.Files, ft)
+	}
+	log.WithFields(log.Fields{"id": id, "files": len(report.Files), "passed": report.Passed}).Info("webseed self-test finished")
+	c.JSON(http.StatusOK, report)
+}
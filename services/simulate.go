@@ -0,0 +1,572 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	awss3 "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	cs "github.com/webtor-io/common-services"
+)
+
+const (
+	simulateURLFlag          = "simulate-url"
+	simulateTokenFlag        = "simulate-token"
+	simulateResourceFlag     = "simulate-resource"
+	simulateTTLFlag          = "simulate-ttl"
+	simulateTimeoutFlag      = "simulate-timeout"
+	simulatePollIntervalFlag = "simulate-poll-interval"
+	simulateReadsFlag        = "simulate-reads"
+)
+
+// RegisterSimulateFlags registers CLI flags of the lifecycle simulation.
+func RegisterSimulateFlags(f []cli.Flag) []cli.Flag {
+	return append(f,
+		cli.StringFlag{
+			Name:   simulateURLFlag,
+			Usage:  "base URL of the vault deployment under test, e.g. https://vault.staging.local",
+			EnvVar: "SIMULATE_URL",
+		},
+		cli.StringFlag{
+			Name:   simulateTokenFlag,
+			Usage:  "X-Token with store, read, webseed and delete scopes of the deployment",
+			EnvVar: "SIMULATE_TOKEN",
+		},
+		cli.StringFlag{
+			Name:   simulateResourceFlag,
+			Usage:  "infohash of a small, well seeded torrent which is not stored by the deployment",
+			EnvVar: "SIMULATE_RESOURCE",
+		},
+		cli.DurationFlag{
+			Name:   simulateTTLFlag,
+			Usage:  "ttl set on the stored resource to exercise expiry",
+			Value:  10 * time.Second,
+			EnvVar: "SIMULATE_TTL",
+		},
+		cli.DurationFlag{
+			Name:   simulateTimeoutFlag,
+			Usage:  "max time of a single step waiting for the deployment",
+			Value:  30 * time.Minute,
+			EnvVar: "SIMULATE_TIMEOUT",
+		},
+		cli.DurationFlag{
+			Name:   simulatePollIntervalFlag,
+			Usage:  "interval of polling the resource while waiting for the deployment",
+			Value:  5 * time.Second,
+			EnvVar: "SIMULATE_POLL_INTERVAL",
+		},
+		cli.IntFlag{
+			Name:   simulateReadsFlag,
+			Usage:  "max number of files read through webseed",
+			Value:  10,
+			EnvVar: "SIMULATE_READS",
+		},
+	)
+}
+
+// SimulationCheck is a single asserted invariant.
+type SimulationCheck struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// SimulationStep is a step of the scenario with invariants asserted after it.
+type SimulationStep struct {
+	Name     string            `json:"name"`
+	OK       bool              `json:"ok"`
+	Duration string            `json:"duration"`
+	Checks   []SimulationCheck `json:"checks"`
+	Error    string            `json:"error,omitempty"`
+}
+
+func (s *SimulationStep) check(name string, ok bool, format string, args ...any) {
+	c := SimulationCheck{Name: name, OK: ok}
+	if format != "" {
+		c.Detail = fmt.Sprintf(format, args...)
+	}
+	s.Checks = append(s.Checks, c)
+}
+
+func (s *SimulationStep) failed() bool {
+	for _, c := range s.Checks {
+		if !c.OK {
+			return true
+		}
+	}
+	return s.Error != ""
+}
+
+// SimulationReport is the outcome of the scenario, it stops at the first failed step.
+type SimulationReport struct {
+	URL        string           `json:"url"`
+	ResourceID string           `json:"resource_id"`
+	OK         bool             `json:"ok"`
+	Steps      []SimulationStep `json:"steps"`
+}
+
+// simulatedFile is a file of the resource recorded once it is stored, to check what gc does with it.
+type simulatedFile struct {
+	hash string
+	path string
+	size int64
+	key  string
+	// bucket of the object, chunks are in the default bucket
+	bucket string
+	// chunks of files stored in chunking mode, they have no object of their own
+	chunks []string
+	// shared files are referenced by other resources and must survive gc
+	shared bool
+}
+
+// Simulation runs the content lifecycle (register, store, webseed reads, TTL expiry, delete, gc) against
+// a deployment through its API and asserts invariants of DB counters, refcounts and the S3 object set.
+type Simulation struct {
+	pg       *PG
+	s3       *cs.S3Client
+	keys     *ObjectKeys
+	bucket   string
+	url      string
+	token    string
+	id       string
+	ttl      time.Duration
+	timeout  time.Duration
+	interval time.Duration
+	reads    int
+	cl       *http.Client
+	files    []simulatedFile
+}
+
+func NewSimulation(c *cli.Context, pg *PG, s3 *cs.S3Client, keys *ObjectKeys) (*Simulation, error) {
+	u := strings.TrimSuffix(c.String(simulateURLFlag), "/")
+	if pu, err := url.Parse(u); err != nil || (pu.Scheme != "http" && pu.Scheme != "https") || pu.Host == "" {
+		return nil, errors.Errorf("failed to parse %v %q: http or https URL is required", simulateURLFlag, u)
+	}
+	id := NormalizeInfohash(c.String(simulateResourceFlag))
+	if _, err := requireTorrentID(id); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse %v", simulateResourceFlag)
+	}
+	if s3 == nil || c.String(awsBucketFlag) == "" {
+		return nil, errors.New("S3 credentials and bucket are required to check the object set")
+	}
+	if c.Duration(simulateTTLFlag) <= 0 || c.Duration(simulateTimeoutFlag) <= 0 || c.Duration(simulatePollIntervalFlag) <= 0 {
+		return nil, errors.New("simulate ttl, timeout and poll interval must be positive")
+	}
+	return &Simulation{
+		pg:       pg,
+		s3:       s3,
+		keys:     keys,
+		bucket:   c.String(awsBucketFlag),
+		url:      u,
+		token:    c.String(simulateTokenFlag),
+		id:       id,
+		ttl:      c.Duration(simulateTTLFlag),
+		timeout:  c.Duration(simulateTimeoutFlag),
+		interval: c.Duration(simulatePollIntervalFlag),
+		reads:    c.Int(simulateReadsFlag),
+		cl:       &http.Client{Timeout: time.Minute},
+	}, nil
+}
+
+// Run executes the scenario. Errors are returned only if it could not be started, failures of the
+// deployment are reported by steps.
+func (s *Simulation) Run(ctx context.Context) (*SimulationReport, error) {
+	db := s.pg.Get()
+	if db == nil {
+		return nil, errors.New("DB not configured")
+	}
+	existing, err := ResourceGetByID(ctx, db, s.id)
+	if err != nil {
+		return nil, err
+	}
+	// the scenario deletes the resource, so it must not touch content somebody relies on
+	if existing != nil {
+		return nil, errors.Errorf("resource %v already exists, simulation needs a resource which is not stored", s.id)
+	}
+	report := &SimulationReport{URL: s.url, ResourceID: s.id, OK: true, Steps: []SimulationStep{}}
+	steps := []struct {
+		name string
+		fn   func(ctx context.Context, db *pg.DB, st *SimulationStep) error
+	}{
+		{"register", s.register},
+		{"store", s.store},
+		{"webseed", s.webseed},
+		{"expire", s.expire},
+		{"delete", s.delete},
+		{"gc", s.gc},
+	}
+	for _, step := range steps {
+		st := SimulationStep{Name: step.name, Checks: []SimulationCheck{}}
+		start := time.Now()
+		if err := step.fn(ctx, db, &st); err != nil {
+			st.Error = err.Error()
+		}
+		st.Duration = time.Since(start).Round(time.Millisecond).String()
+		st.OK = !st.failed()
+		report.Steps = append(report.Steps, st)
+		log.WithFields(log.Fields{"step": st.Name, "ok": st.OK, "duration": st.Duration}).Info("simulation step finished")
+		if !st.OK {
+			report.OK = false
+			break
+		}
+	}
+	return report, nil
+}
+
+// request sends an API request to the deployment, decoding JSON response into out if set.
+func (s *Simulation) request(ctx context.Context, method, path string, header http.Header, body any, out any) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, s.url+path, r)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if s.token != "" {
+		req.Header.Set("X-Token", s.token)
+	}
+	res, err := s.cl.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = res.Body.Close() }()
+	if out != nil && res.StatusCode < http.StatusMultipleChoices {
+		if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+			return nil, errors.Wrapf(err, "failed to decode response of %v %v", method, path)
+		}
+	} else {
+		_, _ = io.Copy(io.Discard, res.Body)
+	}
+	return res, nil
+}
+
+// poll loads the resource from DB until done returns true or the step times out.
+func (s *Simulation) poll(ctx context.Context, db *pg.DB, what string, done func(r *Resource) (bool, error)) (*Resource, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	for {
+		r, err := ResourceGetByID(ctx, db, s.id)
+		if err != nil {
+			return nil, err
+		}
+		ok, err := done(r)
+		if err != nil || ok {
+			return r, err
+		}
+		select {
+		case <-ctx.Done():
+			return r, errors.Errorf("timed out waiting for %v", what)
+		case <-time.After(s.interval):
+		}
+	}
+}
+
+func (s *Simulation) register(ctx context.Context, db *pg.DB, st *SimulationStep) error {
+	var out struct {
+		Resource Resource `json:"resource"`
+	}
+	res, err := s.request(ctx, http.MethodPut, "/resource/"+s.id, nil, nil, &out)
+	if err != nil {
+		return err
+	}
+	st.check("put returns 202", res.StatusCode == http.StatusAccepted, "status %v", res.StatusCode)
+	if res.StatusCode != http.StatusAccepted {
+		return nil
+	}
+	r, err := ResourceGetByID(ctx, db, s.id)
+	if err != nil {
+		return err
+	}
+	st.check("resource row exists", r != nil, "")
+	if r == nil {
+		return nil
+	}
+	st.check("resource is queued", r.Status == StatusQueuedForStoring || r.Status == StatusStoring || r.Status == StatusStored,
+		"status %v", r.Status)
+	st.check("resource has infohash id type", r.IDType.Torrent(), "id_type %v", r.IDType)
+	st.check("resource has no expiry", r.ExpiresAt == nil, "")
+	return nil
+}
+
+func (s *Simulation) store(ctx context.Context, db *pg.DB, st *SimulationStep) error {
+	r, err := s.poll(ctx, db, "resource to be stored", func(r *Resource) (bool, error) {
+		if r == nil {
+			return false, errors.New("resource disappeared while storing")
+		}
+		if r.Status == StatusStoreError {
+			return false, errors.Errorf("store failed: %v", resourceError(r))
+		}
+		return r.Status == StatusStored, nil
+	})
+	if err != nil {
+		return err
+	}
+	var rfs []ResourceFile
+	if err = db.Model(&rfs).Context(ctx).Relation("File").Where("resource_file.resource_id = ?", s.id).Order("resource_file.path").Select(); err != nil {
+		return err
+	}
+	st.check("resource has files", len(rfs) > 0, "%v files", len(rfs))
+	var total, stored int64
+	counted := map[string]bool{}
+	for _, rf := range rfs {
+		f := rf.File
+		if f == nil {
+			st.check("file row exists", false, "%v %v", rf.Path, rf.FileHash)
+			continue
+		}
+		st.check("file is stored", f.Status == StatusStored, "%v status %v", rf.Path, f.Status)
+		// resource counters add sizes of files even if the same content appears at several paths
+		total += f.TotalSize
+		stored += f.StoredSize
+		if counted[f.Hash] {
+			continue
+		}
+		counted[f.Hash] = true
+		refs, err := db.Model((*ResourceFile)(nil)).Context(ctx).Where("file_hash = ?", f.Hash).Where("resource_id <> ?", s.id).Count()
+		if err != nil {
+			return err
+		}
+		sf := simulatedFile{hash: f.Hash, path: rf.Path, size: f.TotalSize, key: s.keys.Key(f.Hash), bucket: f.bucketOr(s.bucket), shared: refs > 0}
+		if f.Chunked {
+			if sf.chunks, err = s.checkChunks(ctx, db, st, f, rf.Path); err != nil {
+				return err
+			}
+			s.files = append(s.files, sf)
+			continue
+		}
+		exists, err := s.objectExists(ctx, sf.bucket, sf.key)
+		if err != nil {
+			return err
+		}
+		st.check("s3 object exists", exists, "%v %v", rf.Path, sf.key)
+		s.files = append(s.files, sf)
+	}
+	st.check("total_size matches files", r.TotalSize == total, "resource %v, files %v", r.TotalSize, total)
+	st.check("stored_size matches files", r.StoredSize == stored, "resource %v, files %v", r.StoredSize, stored)
+	return nil
+}
+
+func (s *Simulation) webseed(ctx context.Context, _ *pg.DB, st *SimulationStep) error {
+	for i, f := range s.files {
+		if i >= s.reads {
+			break
+		}
+		p := "/webseed/" + s.id + escapeWebseedPath(f.path)
+		res, err := s.request(ctx, http.MethodHead, p, nil, nil, nil)
+		if err != nil {
+			return err
+		}
+		st.check("head returns 200", res.StatusCode == http.StatusOK, "%v status %v", f.path, res.StatusCode)
+		st.check("head content length matches file", res.ContentLength == f.size, "%v %v, file %v", f.path, res.ContentLength, f.size)
+		if f.size == 0 {
+			continue
+		}
+		res, err = s.request(ctx, http.MethodGet, p, http.Header{"Range": []string{"bytes=0-0"}}, nil, nil)
+		if err != nil {
+			return err
+		}
+		st.check("range read returns 206", res.StatusCode == http.StatusPartialContent, "%v status %v", f.path, res.StatusCode)
+	}
+	res, err := s.request(ctx, http.MethodHead, "/webseed/"+s.id+"/.vault-simulate-missing", nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	st.check("missing path returns 404", res.StatusCode == http.StatusNotFound, "status %v", res.StatusCode)
+	return nil
+}
+
+func (s *Simulation) expire(ctx context.Context, db *pg.DB, st *SimulationStep) error {
+	res, err := s.request(ctx, http.MethodPatch, "/resource/"+s.id, nil, &PatchRequest{ExpiryRequest: ExpiryRequest{TTL: s.ttl.String()}}, nil)
+	if err != nil {
+		return err
+	}
+	st.check("patch returns 200", res.StatusCode == http.StatusOK, "status %v", res.StatusCode)
+	if res.StatusCode != http.StatusOK {
+		return nil
+	}
+	r, err := ResourceGetByID(ctx, db, s.id)
+	if err != nil {
+		return err
+	}
+	st.check("expiry is set", r != nil && r.ExpiresAt != nil, "")
+	_, err = s.poll(ctx, db, "expired resource to be queued for deletion", func(r *Resource) (bool, error) {
+		return r == nil || r.Status != StatusStored, nil
+	})
+	return err
+}
+
+func (s *Simulation) delete(ctx context.Context, db *pg.DB, st *SimulationStep) error {
+	r, err := s.poll(ctx, db, "resource to be deleted (pending purge resources are waited for until DELETE_GRACE_PERIOD passes)", func(r *Resource) (bool, error) {
+		if r != nil && r.Status == StatusDeleteError {
+			return false, errors.Errorf("delete failed: %v", resourceError(r))
+		}
+		return r == nil, nil
+	})
+	if err != nil {
+		return err
+	}
+	st.check("resource row is deleted", r == nil, "")
+	res, err := s.request(ctx, http.MethodGet, "/resource/"+s.id, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	st.check("get returns 404", res.StatusCode == http.StatusNotFound, "status %v", res.StatusCode)
+	return nil
+}
+
+func (s *Simulation) gc(ctx context.Context, db *pg.DB, st *SimulationStep) error {
+	links, err := db.Model((*ResourceFile)(nil)).Context(ctx).Where("resource_id = ?", s.id).Count()
+	if err != nil {
+		return err
+	}
+	st.check("resource files are unlinked", links == 0, "%v links left", links)
+	// objects are deleted by the outbox after the purge commits
+	if _, err = s.poll(ctx, db, "s3 deletions of the resource to be executed", func(*Resource) (bool, error) {
+		pending, err := db.Model((*S3OutboxEntry)(nil)).Context(ctx).
+			Where("resource_id = ?", s.id).
+			Where("reason = ?", DeleteReasonRefcountZero).
+			Count()
+		return pending == 0, err
+	}); err != nil {
+		return err
+	}
+	for _, f := range s.files {
+		refs, err := db.Model((*ResourceFile)(nil)).Context(ctx).Where("file_hash = ?", f.hash).Count()
+		if err != nil {
+			return err
+		}
+		row, err := db.Model((*File)(nil)).Context(ctx).Where("hash = ?", f.hash).Count()
+		if err != nil {
+			return err
+		}
+		exists, err := s.objectExists(ctx, f.bucket, f.key)
+		if err != nil {
+			return err
+		}
+		// files referenced by other resources, before or since, must survive
+		if refs > 0 {
+			st.check("referenced file is kept", row == 1 && exists, "%v refs %v, row %v, object %v", f.path, refs, row == 1, exists)
+			continue
+		}
+		if f.shared {
+			st.check("file shared at store is collected with its last reference", row == 0 && !exists, "%v row %v, object %v", f.path, row == 1, exists)
+			continue
+		}
+		st.check("file row is deleted", row == 0, "%v", f.path)
+		if f.chunks != nil {
+			if err := s.checkReleasedChunks(ctx, db, st, f); err != nil {
+				return err
+			}
+			continue
+		}
+		st.check("s3 object is deleted", !exists, "%v %v", f.path, f.key)
+		audited, err := db.Model((*S3DeleteAudit)(nil)).Context(ctx).
+			Where("key = ?", f.key).
+			Where("resource_id = ?", s.id).
+			Where("reason = ?", DeleteReasonRefcountZero).
+			Count()
+		if err != nil {
+			return err
+		}
+		st.check("s3 delete is audited", audited > 0, "%v %v", f.path, f.key)
+	}
+	return nil
+}
+
+// checkChunks checks that chunks of the chunked file cover it and their objects exist, returns chunk hashes.
+func (s *Simulation) checkChunks(ctx context.Context, db *pg.DB, st *SimulationStep, f *File, p string) ([]string, error) {
+	chunks, err := FileChunkList(ctx, db, f.Hash)
+	if err != nil {
+		return nil, err
+	}
+	hashes := []string{}
+	var size int64
+	for _, c := range chunks {
+		size += c.Size
+		hashes = append(hashes, c.ChunkHash)
+		key := s.keys.ChunkKey(c.ChunkHash)
+		exists, err := s.objectExists(ctx, s.bucket, key)
+		if err != nil {
+			return nil, err
+		}
+		st.check("chunk object exists", exists, "%v %v", p, key)
+	}
+	st.check("chunks cover file", size == f.TotalSize, "%v chunks %v, file %v", p, size, f.TotalSize)
+	return hashes, nil
+}
+
+// checkReleasedChunks checks that chunks of the deleted file no other file references are collected.
+func (s *Simulation) checkReleasedChunks(ctx context.Context, db *pg.DB, st *SimulationStep, f simulatedFile) error {
+	for _, h := range f.chunks {
+		refs, err := db.Model((*FileChunk)(nil)).Context(ctx).Where("chunk_hash = ?", h).Count()
+		if err != nil {
+			return err
+		}
+		if refs > 0 {
+			continue
+		}
+		row, err := db.Model((*Chunk)(nil)).Context(ctx).Where("hash = ?", h).Count()
+		if err != nil {
+			return err
+		}
+		exists, err := s.objectExists(ctx, s.bucket, s.keys.ChunkKey(h))
+		if err != nil {
+			return err
+		}
+		st.check("released chunk is deleted", row == 0 && !exists, "%v chunk %v row %v, object %v", f.path, h, row == 1, exists)
+	}
+	return nil
+}
+
+func (s *Simulation) objectExists(ctx context.Context, bucket, key string) (bool, error) {
+	_, err := s.s3.Get().HeadObjectWithContext(ctx, &awss3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if isS3NotFoundError(err) {
+			return false, nil
+		}
+		return false, errors.Wrapf(err, "failed to head %v", key)
+	}
+	return true, nil
+}
+
+// escapeWebseedPath escapes segments of the normalized file path for webseed URLs.
+func escapeWebseedPath(p string) string {
+	parts := strings.Split(p, "/")
+	for i := range parts {
+		parts[i] = url.PathEscape(parts[i])
+	}
+	return strings.Join(parts, "/")
+}
+
+// resourceError returns the error recorded for the resource.
+func resourceError(r *Resource) string {
+	if r.Error == nil {
+		return "unknown error"
+	}
+	return *r.Error
+}
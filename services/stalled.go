@@ -0,0 +1,241 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+const (
+	stalledAfterFlag         = "stalled-after"
+	stalledCheckIntervalFlag = "stalled-check-interval"
+	stalledWebhookURLFlag    = "stalled-webhook-url"
+)
+
+// RegisterStalledFlags registers CLI flags for stalled storing detection.
+func RegisterStalledFlags(f []cli.Flag) []cli.Flag {
+	return append(f,
+		cli.DurationFlag{
+			Name:   stalledAfterFlag,
+			Usage:  "storing resource is considered stalled if its stored size didn't change for this period (0 disables)",
+			Value:  30 * time.Minute,
+			EnvVar: "STALLED_AFTER",
+		},
+		cli.DurationFlag{
+			Name:   stalledCheckIntervalFlag,
+			Usage:  "how often stalled resources are looked for",
+			Value:  time.Minute,
+			EnvVar: "STALLED_CHECK_INTERVAL",
+		},
+		cli.StringFlag{
+			Name:   stalledWebhookURLFlag,
+			Usage:  "url stalled resource events are POSTed to as json",
+			EnvVar: "STALLED_WEBHOOK_URL",
+		},
+	)
+}
+
+// EventResourceStalled is published once when storing of a resource stops progressing.
+const EventResourceStalled = "resource.stalled"
+
+// StalledEvent reports a storing resource without progress.
+type StalledEvent struct {
+	ResourceID string    `json:"resource_id"`
+	TotalSize  int64     `json:"total_size"`
+	StoredSize int64     `json:"stored_size"`
+	ProgressAt time.Time `json:"progress_at"`
+	LeaseOwner string    `json:"lease_owner,omitempty"`
+	Time       time.Time `json:"time"`
+}
+
+// StalledMonitor periodically reports storing resources whose stored size doesn't advance.
+type StalledMonitor struct {
+	ctx        context.Context
+	cancel     context.CancelFunc
+	pg         *PG
+	cl         *http.Client
+	events     *Events
+	after      time.Duration
+	interval   time.Duration
+	webhookURL string
+}
+
+// NewStalledMonitor returns nil if stalled detection is disabled.
+func NewStalledMonitor(c *cli.Context, pg *PG, cl *http.Client, events *Events) *StalledMonitor {
+	after := c.Duration(stalledAfterFlag)
+	if after <= 0 {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &StalledMonitor{
+		ctx:        ctx,
+		cancel:     cancel,
+		pg:         pg,
+		cl:         cl,
+		events:     events,
+		after:      after,
+		interval:   c.Duration(stalledCheckIntervalFlag),
+		webhookURL: c.String(stalledWebhookURLFlag),
+	}
+}
+
+func (s *StalledMonitor) Serve() error {
+	log.Infof("checking for resources stalled for %v every %v", s.after, s.interval)
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		if err := s.check(s.ctx); err != nil {
+			log.WithError(err).Error("stalled check failed")
+		}
+		select {
+		case <-s.ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *StalledMonitor) check(ctx context.Context) error {
+	db := s.pg.Get()
+	if db == nil {
+		return errors.New("DB not configured")
+	}
+	now := time.Now()
+	_, total, err := ResourceListStalled(ctx, db, now.Add(-s.after), 1, 0)
+	if err != nil {
+		return err
+	}
+	promStalledResources.Set(float64(total))
+	list, err := ResourceMarkStalled(ctx, db, now.Add(-s.after), 100)
+	if err != nil {
+		return err
+	}
+	for _, r := range list {
+		ev := &StalledEvent{
+			ResourceID: r.ID,
+			TotalSize:  r.TotalSize,
+			StoredSize: r.StoredSize,
+			Time:       now,
+		}
+		if r.ProgressAt != nil {
+			ev.ProgressAt = *r.ProgressAt
+		}
+		if r.LeaseOwner != nil {
+			ev.LeaseOwner = *r.LeaseOwner
+		}
+		s.emit(ev)
+	}
+	return nil
+}
+
+func (s *StalledMonitor) emit(ev *StalledEvent) {
+	log.WithFields(log.Fields{
+		"id":          ev.ResourceID,
+		"stored_size": ev.StoredSize,
+		"total_size":  ev.TotalSize,
+		"progress_at": ev.ProgressAt,
+		"lease_owner": ev.LeaseOwner,
+	}).Warn("resource storing stalled")
+	s.events.Publish(EventResourceStalled, ev)
+	if s.webhookURL == "" {
+		return
+	}
+	if err := s.postWebhook(ev); err != nil {
+		log.WithError(err).Error("failed to post stalled event")
+	}
+}
+
+func (s *StalledMonitor) postWebhook(ev *StalledEvent) error {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(s.ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", s.webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := s.cl.Do(req)
+	if err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
+
+func (s *StalledMonitor) Close() {
+	log.Info("closing StalledMonitor")
+	s.cancel()
+}
+
+// StalledResponse is a page of stalled resources.
+type StalledResponse struct {
+	Resources []Resource `json:"resources"`
+	Total     int        `json:"total"`
+	Limit     int        `json:"limit"`
+	Offset    int        `json:"offset"`
+	// After is the period without progress resources are considered stalled after
+	After string `json:"after"`
+}
+
+// GET /admin/stalled
+// getStalled godoc
+// @Summary      List stalled resources
+// @Description  Lists storing resources whose stored size didn't change for STALLED_AFTER (or ?after), longest stalled first.
+// @Tags         admin
+// @Param        after   query     string  false  "Period without progress (Go duration, e.g. 1h)"
+// @Param        limit   query     int     false  "Page size (default 50, max 1000)"
+// @Param        offset  query     int     false  "Page offset"
+// @Success      200     {object}  StalledResponse
+// @Failure      400     {object}  ErrorResponse
+// @Failure      401     {object}  ErrorResponse
+// @Failure      403     {object}  ErrorResponse
+// @Failure      500     {object}  ErrorResponse
+// @Router       /admin/stalled [get]
+func (s *Web) getStalled(c *gin.Context) {
+	db := s.pg.Get()
+	if db == nil {
+		_ = c.Error(errors.New("DB not configured"))
+		return
+	}
+	limit, offset, err := parseListLimits(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	after := s.stalledAfter
+	if v := c.Query("after"); v != "" {
+		if after, err = time.ParseDuration(v); err != nil || after <= 0 {
+			_ = c.Error(errors.Errorf("failed to parse after %v", v))
+			return
+		}
+	}
+	if after <= 0 {
+		_ = c.Error(errors.New("failed to parse after: stalled detection is disabled, set ?after"))
+		return
+	}
+	list, total, err := ResourceListStalled(c.Request.Context(), db, time.Now().Add(-after), limit, offset)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	now := time.Now()
+	for i := range list {
+		list[i].SetTTL(now)
+	}
+	c.JSON(http.StatusOK, &StalledResponse{
+		Resources: list,
+		Total:     total,
+		Limit:     limit,
+		Offset:    offset,
+		After:     after.String(),
+	})
+}
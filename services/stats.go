@@ -0,0 +1,202 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	pg "github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+)
+
+const (
+	// statsCacheTTL is how long computed stats are served before aggregates are queried again
+	statsCacheTTL = 5 * time.Second
+	// statsOperationsWindow is the period operation success rates are computed over
+	statsOperationsWindow = 24 * time.Hour
+)
+
+// StatusStats aggregates resources in a single status.
+type StatusStats struct {
+	Count      int   `json:"count"`
+	TotalSize  int64 `json:"total_size"`
+	StoredSize int64 `json:"stored_size"`
+}
+
+// DedupStats describes storage saved by sharing files between resources.
+type DedupStats struct {
+	// SharedFiles is the number of files referenced by more than one resource
+	SharedFiles int `json:"shared_files"`
+	// SharedSize is the total size of shared files
+	SharedSize int64 `json:"shared_size"`
+	// Savings is the size which would be stored again without deduplication
+	Savings int64 `json:"savings"`
+}
+
+// OperationStats aggregates operations of a single type.
+type OperationStats struct {
+	Success int `json:"success"`
+	Fail    int `json:"fail"`
+	Running int `json:"running"`
+	// SuccessRate is the share of successful operations among finished ones (0 if none finished)
+	SuccessRate float64 `json:"success_rate"`
+}
+
+// QueueDepth is the number of resources waiting for the worker.
+type QueueDepth struct {
+	Store  int `json:"store"`
+	Delete int `json:"delete"`
+}
+
+type StatsResponse struct {
+	// Resources are grouped by status name
+	Resources     map[string]*StatusStats `json:"resources"`
+	ResourceCount int                     `json:"resource_count"`
+	// FileCount and StoredSize account unique stored objects
+	FileCount  int        `json:"file_count"`
+	StoredSize int64      `json:"stored_size"`
+	Dedup      DedupStats `json:"dedup"`
+	// Operations over the last 24h grouped by operation type
+	Operations  map[string]*OperationStats `json:"operations"`
+	QueueDepth  QueueDepth                 `json:"queue_depth"`
+	GeneratedAt time.Time                  `json:"generated_at"`
+	// Circuits are current states of the store circuit breaker, omitted if it is disabled
+	Circuits map[string]*CircuitState `json:"circuits,omitempty"`
+}
+
+// GetStats computes global storage statistics with aggregate queries.
+func GetStats(ctx context.Context, db pg.DBI, now time.Time) (*StatsResponse, error) {
+	res := &StatsResponse{
+		Resources:   map[string]*StatusStats{},
+		Operations:  map[string]*OperationStats{},
+		GeneratedAt: now,
+	}
+
+	var byStatus []struct {
+		Status Status
+		StatusStats
+	}
+	err := db.Model((*Resource)(nil)).Context(ctx).
+		ColumnExpr("status, count(*) AS count, coalesce(sum(total_size), 0) AS total_size, coalesce(sum(stored_size), 0) AS stored_size").
+		Group("status").
+		Select(&byStatus)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to count resources")
+	}
+	for _, r := range byStatus {
+		st := r.StatusStats
+		res.Resources[r.Status.String()] = &st
+		res.ResourceCount += st.Count
+		switch r.Status {
+		case StatusQueuedForStoring:
+			res.QueueDepth.Store = st.Count
+		case StatusQueuedForDeletion:
+			res.QueueDepth.Delete = st.Count
+		}
+	}
+
+	_, err = db.QueryOneContext(ctx, pg.Scan(&res.FileCount, &res.StoredSize),
+		"SELECT count(*), coalesce(sum(total_size), 0) FROM file WHERE status = ?", StatusStored)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to count files")
+	}
+
+	_, err = db.QueryOneContext(ctx, pg.Scan(&res.Dedup.SharedFiles, &res.Dedup.SharedSize, &res.Dedup.Savings), `
+		SELECT count(*), coalesce(sum(f.total_size), 0), coalesce(sum(f.total_size * (rf.refs - 1)), 0)
+		FROM file f
+		JOIN (
+			SELECT file_hash, count(DISTINCT resource_id) AS refs
+			FROM resource_file
+			GROUP BY file_hash
+			HAVING count(DISTINCT resource_id) > 1
+		) rf ON rf.file_hash = f.hash`)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to compute dedup savings")
+	}
+
+	var ops []struct {
+		OperationType OperationType
+		Status        *OperationStatus
+		Count         int
+	}
+	err = db.Model((*OperationLog)(nil)).Context(ctx).
+		ColumnExpr("operation_type, status, count(*) AS count").
+		Where("started_at >= ?", now.Add(-statsOperationsWindow)).
+		Where("NOT retry").
+		Group("operation_type", "status").
+		Select(&ops)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to count operations")
+	}
+	for _, t := range []OperationType{OperationStore, OperationDelete} {
+		res.Operations[t.String()] = &OperationStats{}
+	}
+	for _, o := range ops {
+		st := res.Operations[o.OperationType.String()]
+		switch {
+		case o.Status == nil:
+			st.Running += o.Count
+		case *o.Status == OperationSuccess:
+			st.Success += o.Count
+		default:
+			st.Fail += o.Count
+		}
+	}
+	for _, st := range res.Operations {
+		if finished := st.Success + st.Fail; finished > 0 {
+			st.SuccessRate = float64(st.Success) / float64(finished)
+		}
+	}
+	return res, nil
+}
+
+// statsCache keeps last computed stats for statsCacheTTL.
+type statsCache struct {
+	mux   sync.Mutex
+	stats *StatsResponse
+}
+
+func (s *statsCache) get(ctx context.Context, db pg.DBI) (*StatsResponse, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	now := time.Now()
+	if s.stats != nil && now.Sub(s.stats.GeneratedAt) < statsCacheTTL {
+		return s.stats, nil
+	}
+	st, err := GetStats(ctx, db, now)
+	if err != nil {
+		return nil, err
+	}
+	s.stats = st
+	return st, nil
+}
+
+// GET /stats
+// getStats godoc
+// @Summary      Storage statistics
+// @Description  Returns resources by status, stored bytes, dedup savings, operation success rates over 24h
+// @Description  and queue depth. Values are cached for a few seconds. States of store circuits are reported live if the
+// @Description  circuit breaker is enabled.
+// @Tags         stats
+// @Produce      json
+// @Success      200  {object}  StatsResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /stats [get]
+func (s *Web) getStats(c *gin.Context) {
+	db := s.pg.Read()
+	if db == nil {
+		_ = c.Error(errors.New("DB not configured"))
+		return
+	}
+	st, err := s.stats.get(c.Request.Context(), db)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	// circuits change within cache ttl, so cached stats are not mutated
+	res := *st
+	res.Circuits = s.health.breaker.State(time.Now())
+	c.JSON(http.StatusOK, &res)
+}
@@ -0,0 +1,170 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+const (
+	s3StorageClassFlag           = "s3-storage-class"
+	s3TransitionAfterFlag        = "s3-transition-after"
+	s3TransitionStorageClassFlag = "s3-transition-storage-class"
+	s3TransitionIntervalFlag     = "s3-transition-interval"
+)
+
+// RegisterStorageClassFlags registers CLI flags for S3 storage classes and lifecycle transitions.
+func RegisterStorageClassFlags(f []cli.Flag) []cli.Flag {
+	return append(f,
+		cli.StringFlag{
+			Name:   s3StorageClassFlag,
+			Usage:  "S3 storage class of uploaded objects: STANDARD, STANDARD_IA, ONEZONE_IA, INTELLIGENT_TIERING or GLACIER_IR (empty uses bucket default)",
+			EnvVar: "S3_STORAGE_CLASS",
+		},
+		cli.DurationFlag{
+			Name:   s3TransitionAfterFlag,
+			Usage:  "move objects of resources not accessed for this period to s3-transition-storage-class (0 disables)",
+			EnvVar: "S3_TRANSITION_AFTER",
+		},
+		cli.StringFlag{
+			Name:   s3TransitionStorageClassFlag,
+			Usage:  "S3 storage class cold objects are moved to",
+			Value:  "STANDARD_IA",
+			EnvVar: "S3_TRANSITION_STORAGE_CLASS",
+		},
+		cli.DurationFlag{
+			Name:   s3TransitionIntervalFlag,
+			Usage:  "how often worker looks for objects to transition",
+			Value:  time.Hour,
+			EnvVar: "S3_TRANSITION_INTERVAL",
+		},
+	)
+}
+
+// storageClasses lists supported classes. Only classes with instant retrieval are allowed,
+// since webseed reads objects directly.
+var storageClasses = map[string]bool{
+	"STANDARD":            true,
+	"STANDARD_IA":         true,
+	"ONEZONE_IA":          true,
+	"INTELLIGENT_TIERING": true,
+	"GLACIER_IR":          true,
+}
+
+// ParseStorageClass validates S3 storage class name. Empty value means default class.
+func ParseStorageClass(v string) (string, error) {
+	if v == "" || storageClasses[v] {
+		return v, nil
+	}
+	return "", errors.Errorf("failed to parse storage class %v", v)
+}
+
+// StorageTransition moves objects of cold resources to a colder storage class.
+type StorageTransition struct {
+	after        time.Duration
+	storageClass string
+	interval     time.Duration
+	last         time.Time
+}
+
+func NewStorageTransition(c *cli.Context) (*StorageTransition, error) {
+	after := c.Duration(s3TransitionAfterFlag)
+	if after <= 0 {
+		return nil, nil
+	}
+	sc, err := ParseStorageClass(c.String(s3TransitionStorageClassFlag))
+	if err != nil {
+		return nil, err
+	}
+	if sc == "" {
+		return nil, errors.New("s3 transition storage class is not set")
+	}
+	return &StorageTransition{
+		after:        after,
+		storageClass: sc,
+		interval:     c.Duration(s3TransitionIntervalFlag),
+	}, nil
+}
+
+// due reports whether transition should run now.
+func (s *StorageTransition) due(now time.Time) bool {
+	if s == nil || now.Sub(s.last) < s.interval {
+		return false
+	}
+	s.last = now
+	return true
+}
+
+// transitionStorageClass moves objects of files whose resources were not accessed for a while
+// from the standard class to the transition class.
+func (s *Worker) transitionStorageClass(ctx context.Context, db *pg.DB) error {
+	if !s.schedule.Open(time.Now()) || !s.transition.due(time.Now()) {
+		return nil
+	}
+	files, err := FileListCold(ctx, db, s.transition.after, 100)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		l := logger(ctx).WithFields(log.Fields{"key": f.Hash, "storage_class": s.transition.storageClass})
+		if err := s.copyObject(ctx, f.bucketOr(s.bucket), s.keys.Key(f.Hash), s.keys.Key(f.Hash), s.transition.storageClass); err != nil {
+			l.WithError(err).Error("failed to transition object")
+			continue
+		}
+		if err := FileSetStorageClass(ctx, db, f.Hash, s.transition.storageClass); err != nil {
+			l.WithError(err).Error("failed to update storage class")
+			continue
+		}
+		l.Info("object transitioned")
+	}
+	return nil
+}
+
+const (
+	accessTouchInterval = time.Hour
+	maxAccessTracked    = 100000
+)
+
+// accessTracker throttles last_accessed_at updates made by webseed.
+type accessTracker struct {
+	mux  sync.Mutex
+	last map[string]time.Time
+}
+
+func newAccessTracker() *accessTracker {
+	return &accessTracker{last: map[string]time.Time{}}
+}
+
+// due reports whether access of the resource should be recorded.
+func (s *accessTracker) due(id string, now time.Time) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if t, ok := s.last[id]; ok && now.Sub(t) < accessTouchInterval {
+		return false
+	}
+	if len(s.last) >= maxAccessTracked {
+		s.last = map[string]time.Time{}
+	}
+	s.last[id] = now
+	return true
+}
+
+// touchResource records webseed access of the resource in background.
+func (s *Web) touchResource(id string) {
+	if !s.access.due(id, time.Now()) {
+		return
+	}
+	db := s.pg.Get()
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := ResourceTouch(ctx, db, id); err != nil {
+			log.WithError(err).WithField("id", id).Warn("failed to update last access time")
+		}
+	}()
+}
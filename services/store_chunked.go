@@ -0,0 +1,199 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	ra "github.com/webtor-io/rest-api/services"
+)
+
+// storeFileChunked splits content into content-defined chunks while hashing it and uploads chunks
+// which are not stored yet, so content shared with other files is downloaded but not stored again.
+func (s *Worker) storeFileChunked(ctx context.Context, db *pg.DB, id string, item ra.ListItem, u string, progress *storeProgress, sc string) (*File, error) {
+	h := s.hashAlgo.newHasher()
+	progress.start(item.PathStr, StorePhaseUploading, item.Size, item.Size)
+
+	var stored int64
+	flush := func(stored int64) error {
+		if _, err := db.Model(&Resource{ID: id}).
+			Context(ctx).
+			Set("stored_size = ?", progress.set(item.PathStr, stored)).
+			Set("updated_at = now()").
+			Where("resource_id = ?", id).
+			Update(); err != nil {
+			return err
+		}
+		s.events.Publish(EventFileProgress, &FileProgressEvent{
+			ResourceID: id,
+			Path:       item.PathStr,
+			StoredSize: stored,
+			TotalSize:  item.Size,
+			Phase:      StorePhaseUploading,
+			Time:       time.Now(),
+		})
+		return nil
+	}
+	flushCtx, cancel := context.WithCancel(ctx)
+	flushTicker := time.NewTicker(5 * time.Second)
+	defer flushTicker.Stop()
+	defer cancel()
+	go func() {
+		for {
+			select {
+			case <-flushCtx.Done():
+				return
+			case <-flushTicker.C:
+				if err := flush(stored); err != nil {
+					log.WithError(err).Error("flush progress failed")
+				}
+			}
+		}
+	}()
+
+	var chunks []FileChunk
+	var uploaded, deduped int64
+	// a failed download is retried from the start, chunks uploaded by the attempt are skipped
+	err := s.retry.do(ctx, item.PathStr, func() error {
+		stored, uploaded, deduped = 0, 0, 0
+		h.Reset()
+		chunks = chunks[:0]
+		r, err := s.download(ctx, u, item.Size)
+		if err != nil {
+			return err
+		}
+		defer func(r io.ReadCloser) {
+			_ = r.Close()
+		}(r)
+		cr := s.chunking.newChunker(io.TeeReader(r, h))
+		for {
+			data, err := cr.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			hash := fmt.Sprintf("%x", sha256.Sum256(data))
+			up, err := s.storeChunk(ctx, db, id, hash, data, sc)
+			if err != nil {
+				return err
+			}
+			if up {
+				uploaded += int64(len(data))
+			} else {
+				deduped += int64(len(data))
+			}
+			chunks = append(chunks, FileChunk{Seq: len(chunks), Offset: stored, Size: int64(len(data)), ChunkHash: hash})
+			stored += int64(len(data))
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if stored != item.Size {
+		return nil, errors.Errorf("downloaded %v bytes of %v", stored, item.Size)
+	}
+
+	hash := fmt.Sprintf("%x", h.Sum(nil))
+	f := &File{
+		Hash:         hash,
+		TotalSize:    item.Size,
+		Path:         &item.PathStr,
+		Status:       StatusStoring,
+		HashAlgo:     s.hashAlgo,
+		StorageClass: storageClassPtr(sc),
+		ContentClass: DetectContentClass(item.PathStr),
+		Chunked:      true,
+	}
+	f.StoringResourceID = &id
+	if done, err := s.claimUpload(ctx, db, f); err != nil || done != nil {
+		// same content is already stored, chunks uploaded for it are deleted unless other files use them
+		return done, err
+	}
+	for i := range chunks {
+		chunks[i].FileHash = hash
+	}
+	err = db.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		// chunks of an abandoned upload taken over are replaced
+		if _, err := tx.Model((*FileChunk)(nil)).Context(ctx).Where("file_hash = ?", hash).Delete(); err != nil {
+			return err
+		}
+		if err := FileChunkAdd(ctx, tx, chunks); err != nil {
+			return err
+		}
+		f.Status = StatusStored
+		f.StoredSize = f.TotalSize
+		_, err := tx.Model(f).Context(ctx).Column("status", "stored_size", "chunked").WherePK().Update()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	promChunkBytes.WithLabelValues("uploaded").Add(float64(uploaded))
+	promChunkBytes.WithLabelValues("deduped").Add(float64(deduped))
+	logger(ctx).WithFields(log.Fields{"bucket": s.bucket, "resource_id": id, "path": item.PathStr, "hash": hash, "size": item.Size, "chunks": len(chunks), "deduped": deduped}).Info("stored to s3 in chunks")
+	s.events.Publish(EventFileProgress, &FileProgressEvent{
+		ResourceID: id,
+		FileHash:   hash,
+		Path:       item.PathStr,
+		StoredSize: item.Size,
+		TotalSize:  item.Size,
+		Done:       true,
+		Phase:      StorePhaseUploading,
+		Time:       time.Now(),
+	})
+	return f, nil
+}
+
+// storeChunk uploads the chunk unless it is stored already. Deletion of the object is recorded before
+// the upload and dropped once a file references the chunk, so chunks of crashed jobs are deleted.
+// Returns true if the chunk was uploaded.
+func (s *Worker) storeChunk(ctx context.Context, db *pg.DB, id, hash string, data []byte, sc string) (bool, error) {
+	exists, err := ChunkExists(ctx, db, hash)
+	if err != nil || exists {
+		return false, err
+	}
+	key := s.keys.ChunkKey(hash)
+	intent := &S3OutboxEntry{Bucket: s.bucket, Key: key, Size: int64(len(data)), Reason: DeleteReasonAbandoned, ResourceID: &id, ChunkHash: &hash}
+	if err := S3OutboxAdd(ctx, db, intent, tempObjectTTL); err != nil {
+		return false, err
+	}
+	uploaded := false
+	// deletion of the chunk waits until the upload is recorded
+	err = db.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		if err := ChunkLock(ctx, tx, hash, false); err != nil {
+			return err
+		}
+		if exists, err := ChunkExists(ctx, tx, hash); err != nil || exists {
+			return err
+		}
+		// chunks are shared by files of any resource, so only static tags apply
+		input := &s3manager.UploadInput{
+			Bucket:  aws.String(s.bucket),
+			Key:     aws.String(key),
+			Body:    bytes.NewReader(data),
+			Tagging: s.tags.Static(),
+		}
+		if sc != "" {
+			input.StorageClass = aws.String(sc)
+		}
+		if err := s.enc.PrepareUpload(input, int64(len(data))); err != nil {
+			return err
+		}
+		if err := s.upload(ctx, s3manager.NewUploaderWithClient(s.s3.Get()), input, int64(len(data))); err != nil {
+			return err
+		}
+		uploaded = true
+		return ChunkAdd(ctx, tx, &Chunk{Hash: hash, Size: int64(len(data))})
+	})
+	return uploaded, err
+}
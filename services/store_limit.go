@@ -0,0 +1,92 @@
+package services
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/urfave/cli"
+	"golang.org/x/time/rate"
+)
+
+const (
+	storeDownloadRateLimitFlag    = "store-download-rate-limit"
+	storeDownloadJobRateLimitFlag = "store-download-job-rate-limit"
+	storeDownloadBurstFlag        = "store-download-burst"
+)
+
+// RegisterStoreLimitFlags registers CLI flags for throttling downloads from the torrent proxy while storing.
+func RegisterStoreLimitFlags(f []cli.Flag) []cli.Flag {
+	return append(f,
+		cli.Int64Flag{
+			Name:   storeDownloadRateLimitFlag,
+			Usage:  "bytes per second downloaded from torrent proxy by all workers together (0 disables)",
+			EnvVar: "STORE_DOWNLOAD_RATE_LIMIT",
+		},
+		cli.Int64Flag{
+			Name:   storeDownloadJobRateLimitFlag,
+			Usage:  "bytes per second downloaded from torrent proxy by a single download (0 disables)",
+			EnvVar: "STORE_DOWNLOAD_JOB_RATE_LIMIT",
+		},
+		cli.IntFlag{
+			Name:   storeDownloadBurstFlag,
+			Usage:  "store download burst in bytes",
+			Value:  1024 * 1024,
+			EnvVar: "STORE_DOWNLOAD_BURST",
+		},
+	)
+}
+
+// StoreDownloadLimiter throttles downloads from the torrent proxy with a global limit shared
+// across workers and a limit per single download.
+type StoreDownloadLimiter struct {
+	global  *rate.Limiter
+	jobRate rate.Limit
+	burst   int
+}
+
+// NewStoreDownloadLimiter returns nil if all limits are disabled.
+func NewStoreDownloadLimiter(c *cli.Context) *StoreDownloadLimiter {
+	globalRate := c.Int64(storeDownloadRateLimitFlag)
+	jobRate := c.Int64(storeDownloadJobRateLimitFlag)
+	if globalRate <= 0 && jobRate <= 0 {
+		return nil
+	}
+	l := &StoreDownloadLimiter{
+		jobRate: rate.Inf,
+		burst:   c.Int(storeDownloadBurstFlag),
+	}
+	if globalRate > 0 {
+		l.global = rate.NewLimiter(rate.Limit(globalRate), l.burst)
+		promStoreDownloadRateLimit.WithLabelValues("global").Set(float64(globalRate))
+	}
+	if jobRate > 0 {
+		l.jobRate = rate.Limit(jobRate)
+		promStoreDownloadRateLimit.WithLabelValues("job").Set(float64(jobRate))
+	}
+	return l
+}
+
+// Reader wraps r so that reads are throttled and accounted in metrics. Nil limiter only accounts bytes.
+func (s *StoreDownloadLimiter) Reader(ctx context.Context, r io.Reader) io.Reader {
+	r = &progressReader{r: r, onRead: func(n int) error {
+		promStoreDownloadBytes.Add(float64(n))
+		return nil
+	}}
+	if s == nil {
+		return r
+	}
+	limiters := []*rate.Limiter{rate.NewLimiter(s.jobRate, s.burst)}
+	if s.global != nil {
+		limiters = append(limiters, s.global)
+	}
+	return &rateLimitedReader{
+		ctx:      ctx,
+		r:        r,
+		limiters: limiters,
+		burst:    s.burst,
+		onWait: func(d time.Duration) {
+			promStoreDownloadThrottled.Add(d.Seconds())
+		},
+	}
+}
@@ -0,0 +1,221 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	pg "github.com/go-pg/pg/v10"
+)
+
+// Phases of storing a file.
+const (
+	// StorePhaseHashing is the download of content to compute its hash before the upload
+	StorePhaseHashing = "hashing"
+	// StorePhaseUploading is the upload of content to S3 (hashed on the fly with streaming hashing)
+	StorePhaseUploading = "uploading"
+)
+
+// storeProgressFlushInterval is how often progress of a store job is written to the resource.
+const storeProgressFlushInterval = 5 * time.Second
+
+// FileStoreProgress is the progress of a file being stored.
+type FileStoreProgress struct {
+	Path      string `json:"path"`
+	Phase     string `json:"phase"`
+	TotalSize int64  `json:"total_size"`
+	// Size of the phase: bytes to hash (only head and tail with the sampled hash) or to upload
+	Size int64 `json:"size"`
+	// Done bytes of the phase
+	Done int64 `json:"done"`
+}
+
+// StoreProgress details a running store job, it is kept with the resource while the job runs.
+type StoreProgress struct {
+	// Phase is hashing while any file is being hashed, uploading otherwise
+	Phase string `json:"phase"`
+	// Files is the number of files of the job listed so far, FilesStored of them are stored
+	Files       int `json:"files"`
+	FilesStored int `json:"files_stored"`
+	// HashedSize of HashingSize bytes of files being hashed are hashed
+	HashedSize  int64 `json:"hashed_size"`
+	HashingSize int64 `json:"hashing_size"`
+	// InProgress are files being stored, ordered by path
+	InProgress []FileStoreProgress `json:"in_progress,omitempty"`
+}
+
+// File returns progress of the file at path, nil if it is not being stored.
+func (p *StoreProgress) File(path string) *FileStoreProgress {
+	if p == nil {
+		return nil
+	}
+	for i := range p.InProgress {
+		if p.InProgress[i].Path == path {
+			return &p.InProgress[i]
+		}
+	}
+	return nil
+}
+
+// String renders progress like "hashing file 3/10 (42%)".
+func (p *StoreProgress) String() string {
+	s := fmt.Sprintf("%v file %v/%v", p.Phase, min(p.FilesStored+1, p.Files), p.Files)
+	if p.Phase == StorePhaseHashing && p.HashingSize > 0 {
+		s += fmt.Sprintf(" (%.0f%%)", float64(p.HashedSize)*100/float64(p.HashingSize))
+	}
+	return s
+}
+
+// storeProgress sums stored bytes of a resource whose files are stored concurrently
+// and tracks phases of files in progress.
+type storeProgress struct {
+	mux      sync.Mutex
+	done     int64
+	inflight map[string]int64
+	files    int
+	stored   int
+	phases   map[string]*FileStoreProgress
+	changed  bool
+}
+
+func newStoreProgress() *storeProgress {
+	return &storeProgress{inflight: map[string]int64{}, phases: map[string]*FileStoreProgress{}}
+}
+
+// set records bytes stored so far of the file at path and returns stored bytes of the resource.
+func (s *storeProgress) set(path string, stored int64) int64 {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.inflight[path] = stored
+	if f, ok := s.phases[path]; ok && f.Phase == StorePhaseUploading {
+		f.Done = stored
+		s.changed = true
+	}
+	return s.total()
+}
+
+// finish counts the file at path as fully stored and returns stored bytes of the resource.
+func (s *storeProgress) finish(path string, size int64) int64 {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	delete(s.inflight, path)
+	delete(s.phases, path)
+	s.done += size
+	s.stored++
+	s.changed = true
+	return s.total()
+}
+
+func (s *storeProgress) total() int64 {
+	t := s.done
+	for _, n := range s.inflight {
+		t += n
+	}
+	return t
+}
+
+// add counts a listed file of the job.
+func (s *storeProgress) add() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.files++
+	s.changed = true
+}
+
+// start enters the file of total size at path into phase of size bytes.
+func (s *storeProgress) start(path, phase string, size, total int64) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.phases[path] = &FileStoreProgress{Path: path, Phase: phase, TotalSize: total, Size: size}
+	s.changed = true
+}
+
+// hashed adds n hashed bytes of the file at path.
+func (s *storeProgress) hashed(path string, n int64) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	f, ok := s.phases[path]
+	if !ok || f.Phase != StorePhaseHashing {
+		return
+	}
+	f.Done += n
+	s.changed = true
+}
+
+// drop forgets the file at path which failed to store.
+func (s *storeProgress) drop(path string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	delete(s.phases, path)
+	s.changed = true
+}
+
+// snapshot returns progress of the job if it changed since the last snapshot, nil otherwise.
+func (s *storeProgress) snapshot() *StoreProgress {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if !s.changed {
+		return nil
+	}
+	s.changed = false
+	p := &StoreProgress{Phase: StorePhaseUploading, Files: s.files, FilesStored: s.stored, InProgress: []FileStoreProgress{}}
+	for _, f := range s.phases {
+		p.InProgress = append(p.InProgress, *f)
+		if f.Phase == StorePhaseHashing {
+			p.Phase = StorePhaseHashing
+			p.HashedSize += f.Done
+			p.HashingSize += f.Size
+		}
+	}
+	sort.Slice(p.InProgress, func(i, j int) bool { return p.InProgress[i].Path < p.InProgress[j].Path })
+	return p
+}
+
+// trackStoreProgress writes progress of the store job of the resource once it changes and publishes
+// hashing progress of its files. The returned function stops tracking and clears progress of the resource.
+func (s *Worker) trackStoreProgress(ctx context.Context, db *pg.DB, id string, progress *storeProgress) func() {
+	pctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		t := time.NewTicker(storeProgressFlushInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-pctx.Done():
+				return
+			case <-t.C:
+			}
+			p := progress.snapshot()
+			if p == nil {
+				continue
+			}
+			if err := ResourceSetProgress(pctx, db, id, p); err != nil && pctx.Err() == nil {
+				logger(ctx).WithError(err).Warn("failed to update store progress")
+			}
+			for _, f := range p.InProgress {
+				if f.Phase != StorePhaseHashing {
+					continue
+				}
+				s.events.Publish(EventFileProgress, &FileProgressEvent{
+					ResourceID:  id,
+					Path:        f.Path,
+					Phase:       StorePhaseHashing,
+					HashedSize:  f.Done,
+					HashingSize: f.Size,
+					TotalSize:   f.TotalSize,
+					Time:        time.Now(),
+				})
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+		if err := ResourceSetProgress(context.WithoutCancel(ctx), db, id, nil); err != nil {
+			logger(ctx).WithError(err).Warn("failed to clear store progress")
+		}
+	}
+}
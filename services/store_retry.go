@@ -0,0 +1,123 @@
+package services
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+const (
+	storeFileRetriesFlag         = "store-file-retries"
+	storeFileRetryBackoffFlag    = "store-file-retry-backoff"
+	storeFileRetryMaxBackoffFlag = "store-file-retry-max-backoff"
+)
+
+// RegisterFileRetryFlags registers CLI flags for retries of torrent http proxy downloads within a store job.
+func RegisterFileRetryFlags(f []cli.Flag) []cli.Flag {
+	return append(f,
+		cli.IntFlag{
+			Name:   storeFileRetriesFlag,
+			Usage:  "number of times download of a file is retried after network errors or 5xx of torrent http proxy before the store fails (0 disables)",
+			Value:  3,
+			EnvVar: "STORE_FILE_RETRIES",
+		},
+		cli.DurationFlag{
+			Name:   storeFileRetryBackoffFlag,
+			Usage:  "delay before the first retry of a file download, doubled on every next one",
+			Value:  time.Second,
+			EnvVar: "STORE_FILE_RETRY_BACKOFF",
+		},
+		cli.DurationFlag{
+			Name:   storeFileRetryMaxBackoffFlag,
+			Usage:  "max delay between retries of a file download",
+			Value:  30 * time.Second,
+			EnvVar: "STORE_FILE_RETRY_MAX_BACKOFF",
+		},
+	)
+}
+
+// FileRetry retries downloads of a file from torrent http proxy failed with transient errors.
+type FileRetry struct {
+	retries    int
+	backoff    time.Duration
+	maxBackoff time.Duration
+	breaker    *CircuitBreaker
+}
+
+func NewFileRetry(c *cli.Context, breaker *CircuitBreaker) (*FileRetry, error) {
+	s := &FileRetry{
+		breaker:    breaker,
+		retries:    c.Int(storeFileRetriesFlag),
+		backoff:    c.Duration(storeFileRetryBackoffFlag),
+		maxBackoff: c.Duration(storeFileRetryMaxBackoffFlag),
+	}
+	if s.retries < 0 {
+		return nil, errors.New("store file retries must not be negative")
+	}
+	if s.retries > 0 && (s.backoff <= 0 || s.maxBackoff < s.backoff) {
+		return nil, errors.New("store file retry backoff must be positive and not exceed max backoff")
+	}
+	return s, nil
+}
+
+// isRetryableDownloadError reports whether err is a transient torrent http proxy error, possibly
+// wrapped by S3 upload reading the download.
+func isRetryableDownloadError(err error) bool {
+	pe := asProxyError(err)
+	return pe != nil && pe.Retryable()
+}
+
+// asProxyError returns torrent http proxy error of err, possibly wrapped by S3 upload reading the download.
+func asProxyError(err error) *ProxyError {
+	for err != nil {
+		var pe *ProxyError
+		if errors.As(err, &pe) {
+			return pe
+		}
+		ae, ok := err.(awserr.Error)
+		if !ok {
+			return nil
+		}
+		err = ae.OrigErr()
+	}
+	return nil
+}
+
+type retryCountKey struct{}
+
+// withRetryCount returns context counting retries of file downloads made within it.
+func withRetryCount(ctx context.Context) (context.Context, *atomic.Int64) {
+	n := &atomic.Int64{}
+	return context.WithValue(ctx, retryCountKey{}, n), n
+}
+
+// do runs fn, downloading the file at path, until it succeeds, fails with a permanent error or
+// retries are exhausted.
+func (s *FileRetry) do(ctx context.Context, path string, fn func() error) error {
+	backoff := s.backoff
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		s.breaker.recordDownload(ctx, err)
+		if err == nil || attempt > s.retries || ctx.Err() != nil || !isRetryableDownloadError(err) {
+			return err
+		}
+		promStoreFileRetries.Inc()
+		if n, ok := ctx.Value(retryCountKey{}).(*atomic.Int64); ok {
+			n.Add(1)
+		}
+		logger(ctx).WithError(err).WithFields(log.Fields{"path": path, "attempt": attempt, "backoff": backoff}).Warn("retrying file download")
+		t := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+		backoff = min(backoff*2, s.maxBackoff)
+	}
+}
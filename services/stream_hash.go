@@ -0,0 +1,262 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	awss3 "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	pg "github.com/go-pg/pg/v10"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	ra "github.com/webtor-io/rest-api/services"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// tempKeyPrefix is where content is uploaded before its hash is known
+	tempKeyPrefix = "tmp/"
+	// maxCopyObjectSize is the largest object S3 can copy in a single request
+	maxCopyObjectSize = 5 * 1024 * 1024 * 1024
+	copyPartSize      = 512 * 1024 * 1024
+)
+
+// storeFileStreaming uploads content to a temporary key while hashing it and copies
+// the object to its hash key afterwards, so content is downloaded from torrent proxy only once.
+// The temporary object is uploaded to the bucket routed before the hash is known, hash rules
+// may move the object to another bucket when it is copied.
+func (s *Worker) storeFileStreaming(ctx context.Context, db *pg.DB, id string, item ra.ListItem, u string, progress *storeProgress, sc string, labels map[string]string) (*File, error) {
+	tmpKey := s.keys.join(tempKeyPrefix + uuid.NewString())
+	tmpBucket := s.router.Route(item.Size, labels, "")
+	// deletion of the temporary object is recorded before the upload and runs once it finishes,
+	// or after tempObjectTTL if the job crashes
+	tmp := &S3OutboxEntry{Bucket: tmpBucket, Key: tmpKey, Size: item.Size, Reason: DeleteReasonTemporary, ResourceID: &id}
+	if err := S3OutboxAdd(ctx, db, tmp, tempObjectTTL); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := S3OutboxSchedule(context.Background(), db, tmp.ID); err != nil {
+			logger(ctx).WithError(err).WithField("key", tmpKey).Warn("failed to schedule deletion of temporary object")
+		}
+	}()
+	h := s.hashAlgo.newHasher()
+	progress.start(item.PathStr, StorePhaseUploading, item.Size, item.Size)
+
+	var stored int64
+	flush := func(stored int64) error {
+		if _, err := db.Model(&Resource{ID: id}).
+			Context(ctx).
+			Set("stored_size = ?", progress.set(item.PathStr, stored)).
+			Set("updated_at = now()").
+			Where("resource_id = ?", id).
+			Update(); err != nil {
+			return err
+		}
+		s.events.Publish(EventFileProgress, &FileProgressEvent{
+			ResourceID: id,
+			Path:       item.PathStr,
+			StoredSize: stored,
+			TotalSize:  item.Size,
+			Phase:      StorePhaseUploading,
+			Time:       time.Now(),
+		})
+		return nil
+	}
+	flushCtx, cancel := context.WithCancel(ctx)
+	flushTicker := time.NewTicker(5 * time.Second)
+	defer flushTicker.Stop()
+	defer cancel()
+	go func() {
+		for {
+			select {
+			case <-flushCtx.Done():
+				return
+			case <-flushTicker.C:
+				if err := flush(stored); err != nil {
+					log.WithError(err).Error("flush progress failed")
+				}
+			}
+		}
+	}()
+
+	// a failed download is retried from the start with a fresh hash
+	err := s.retry.do(ctx, item.PathStr, func() error {
+		stored = 0
+		h.Reset()
+		r, err := s.download(ctx, u, item.Size)
+		if err != nil {
+			return err
+		}
+		defer func(r io.ReadCloser) {
+			_ = r.Close()
+		}(r)
+		pr := &progressReader{
+			r: io.TeeReader(r, h),
+			onRead: func(n int) error {
+				stored += int64(n)
+				return nil
+			},
+		}
+		uploader := s3manager.NewUploaderWithClient(s.s3.Get())
+		input := &s3manager.UploadInput{
+			Bucket:  aws.String(tmpBucket),
+			Key:     aws.String(tmpKey),
+			Body:    pr,
+			Tagging: s.tags.Tagging(id, item.PathStr),
+		}
+		if sc != "" {
+			input.StorageClass = aws.String(sc)
+		}
+		setObjectMetadata(input, item.PathStr, s.hashAlgo)
+		if err = s.enc.PrepareUpload(input, item.Size); err != nil {
+			return err
+		}
+		return s.upload(ctx, uploader, input, item.Size)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if stored != item.Size {
+		return nil, errors.Errorf("downloaded %v bytes of %v", stored, item.Size)
+	}
+
+	hash := fmt.Sprintf("%x", h.Sum(nil))
+	logger(ctx).WithField("hash", hash).Debug("generated hash while uploading")
+	bucket := s.router.Route(item.Size, labels, hash)
+	f := &File{
+		Hash:         hash,
+		TotalSize:    item.Size,
+		Path:         &item.PathStr,
+		Status:       StatusStoring,
+		HashAlgo:     s.hashAlgo,
+		StorageClass: storageClassPtr(sc),
+		ContentClass: DetectContentClass(item.PathStr),
+		Bucket:       &bucket,
+	}
+	f.StoringResourceID = &id
+	if done, err := s.claimUpload(ctx, db, f); err != nil || done != nil {
+		// same content is already stored
+		return done, err
+	}
+	if err = copyS3Object(ctx, s.s3.Get(), s.enc, s.tags, tmpBucket, tmpKey, bucket, s.keys.Key(hash), sc); err != nil {
+		return nil, err
+	}
+	f.Status = StatusStored
+	f.StoredSize = f.TotalSize
+	if _, err := db.Model(f).Context(ctx).Column("status", "stored_size").WherePK().Update(); err != nil {
+		return nil, err
+	}
+	logger(ctx).WithFields(log.Fields{"bucket": bucket, "resource_id": id, "path": item.PathStr, "key": s.keys.Key(hash), "size": item.Size}).Info("stored to s3")
+	s.events.Publish(EventFileProgress, &FileProgressEvent{
+		ResourceID: id,
+		FileHash:   hash,
+		Path:       item.PathStr,
+		StoredSize: item.Size,
+		TotalSize:  item.Size,
+		Done:       true,
+		Phase:      StorePhaseUploading,
+		Time:       time.Now(),
+	})
+	return f, nil
+}
+
+// copyObject copies src to dst within the bucket keeping metadata, with storage class sc (empty uses bucket default).
+func (s *Worker) copyObject(ctx context.Context, bucket, src, dst, sc string) error {
+	return copyS3Object(ctx, s.s3.Get(), s.enc, s.tags, bucket, src, bucket, dst, sc)
+}
+
+// copyS3Object copies src of srcBucket to dst of bucket keeping metadata and tags, with storage class sc
+// (empty uses bucket default). Objects larger than 5GB are copied in parts.
+func copyS3Object(ctx context.Context, cl *awss3.S3, enc *Encryption, tags *ObjectTags, srcBucket, src, bucket, dst, sc string) (err error) {
+	ctx, span := tracer.Start(ctx, "s3.copy", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("src_bucket", srcBucket),
+		attribute.String("bucket", bucket),
+		attribute.String("src", src),
+		attribute.String("dst", dst),
+	))
+	defer func() { endSpan(span, err) }()
+	head, err := cl.HeadObjectWithContext(ctx, &awss3.HeadObjectInput{
+		Bucket: aws.String(srcBucket),
+		Key:    aws.String(src),
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to head %v", src)
+	}
+	source := (&url.URL{Path: srcBucket + "/" + src}).EscapedPath()
+	size := aws.Int64Value(head.ContentLength)
+	if size <= maxCopyObjectSize {
+		in := &awss3.CopyObjectInput{
+			Bucket:            aws.String(bucket),
+			Key:               aws.String(dst),
+			CopySource:        aws.String(source),
+			MetadataDirective: aws.String(awss3.MetadataDirectiveCopy),
+		}
+		if sc != "" {
+			in.StorageClass = aws.String(sc)
+		}
+		enc.PrepareCopy(in)
+		if _, err = cl.CopyObjectWithContext(ctx, in); err != nil {
+			return errors.Wrapf(err, "failed to copy %v to %v", src, dst)
+		}
+		return nil
+	}
+	tagging, err := tags.copyTagging(ctx, cl, srcBucket, src)
+	if err != nil {
+		return err
+	}
+	return multipartCopy(ctx, cl, enc, bucket, source, dst, size, head.Metadata, tagging, sc)
+}
+
+func multipartCopy(ctx context.Context, cl *awss3.S3, enc *Encryption, bucket, source, dst string, size int64, meta map[string]*string, tagging *string, sc string) error {
+	in := &awss3.CreateMultipartUploadInput{
+		Bucket:   aws.String(bucket),
+		Key:      aws.String(dst),
+		Metadata: meta,
+		Tagging:  tagging,
+	}
+	if sc != "" {
+		in.StorageClass = aws.String(sc)
+	}
+	enc.PrepareMultipartCopy(in)
+	mu, err := cl.CreateMultipartUploadWithContext(ctx, in)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create multipart upload for %v", dst)
+	}
+	var parts []*awss3.CompletedPart
+	for n, start := int64(1), int64(0); start < size; n, start = n+1, start+copyPartSize {
+		end := start + copyPartSize - 1
+		if end >= size {
+			end = size - 1
+		}
+		out, err := cl.UploadPartCopyWithContext(ctx, &awss3.UploadPartCopyInput{
+			Bucket:          aws.String(bucket),
+			Key:             aws.String(dst),
+			CopySource:      aws.String(source),
+			CopySourceRange: aws.String(fmt.Sprintf("bytes=%v-%v", start, end)),
+			PartNumber:      aws.Int64(n),
+			UploadId:        mu.UploadId,
+		})
+		if err != nil {
+			_, _ = cl.AbortMultipartUploadWithContext(context.Background(), &awss3.AbortMultipartUploadInput{
+				Bucket:   aws.String(bucket),
+				Key:      aws.String(dst),
+				UploadId: mu.UploadId,
+			})
+			return errors.Wrapf(err, "failed to copy part %v of %v", n, dst)
+		}
+		parts = append(parts, &awss3.CompletedPart{ETag: out.CopyPartResult.ETag, PartNumber: aws.Int64(n)})
+	}
+	_, err = cl.CompleteMultipartUploadWithContext(ctx, &awss3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(bucket),
+		Key:             aws.String(dst),
+		UploadId:        mu.UploadId,
+		MultipartUpload: &awss3.CompletedMultipartUpload{Parts: parts},
+	})
+	return err
+}
@@ -0,0 +1,151 @@
+package services
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+const (
+	takedownPolicyFlag = "takedown-policy"
+)
+
+// RegisterTakedownFlags registers CLI flags for content takedown.
+func RegisterTakedownFlags(f []cli.Flag) []cli.Flag {
+	return append(f,
+		cli.StringFlag{
+			Name:   takedownPolicyFlag,
+			Usage:  "default takedown policy: delete (block and queue deletion) or block (block only)",
+			Value:  string(TakedownPolicyDelete),
+			EnvVar: "TAKEDOWN_POLICY",
+		},
+	)
+}
+
+// TakedownRequest is a body of takedown request.
+type TakedownRequest struct {
+	ReasonCode      string         `json:"reason_code" binding:"required"`
+	Policy          TakedownPolicy `json:"policy,omitempty"`
+	Reporter        *string        `json:"reporter,omitempty"`
+	ReporterContact *string        `json:"reporter_contact,omitempty"`
+	NoticeURL       *string        `json:"notice_url,omitempty"`
+	Notes           *string        `json:"notes,omitempty"`
+}
+
+// TakedownResponse contains recorded takedown and resulting resource state.
+type TakedownResponse struct {
+	Takedown *Takedown `json:"takedown"`
+	Resource *Resource `json:"resource,omitempty"`
+}
+
+// GoneResponse is returned by webseed for content which was taken down.
+type GoneResponse struct {
+	Error      string `json:"error"`
+	ReasonCode string `json:"reason_code"`
+}
+
+// POST /admin/takedown/{id}
+// postTakedown godoc
+// @Summary      Take down resource
+// @Description  Immediately blocks webseed access to the resource (410 Gone), queues deletion according to policy
+// @Description  and records the takedown. Resources under legal hold are blocked but not deleted.
+// @Tags         admin
+// @Accept       json
+// @Param        id       path      string           true  "Resource ID"
+// @Param        request  body      TakedownRequest  true  "Takedown details"
+// @Success      201      {object}  TakedownResponse
+// @Failure      400      {object}  ErrorResponse
+// @Failure      401      {object}  ErrorResponse
+// @Failure      403      {object}  ErrorResponse
+// @Failure      500      {object}  ErrorResponse
+// @Router       /admin/takedown/{id} [post]
+func (s *Web) postTakedown(c *gin.Context) {
+	db := s.pg.Get()
+	if db == nil {
+		_ = c.Error(errors.New("DB not configured"))
+		return
+	}
+	id := c.Param("id")
+	var req TakedownRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		_ = c.Error(errors.Wrap(err, "failed to parse takedown request"))
+		return
+	}
+	if req.Policy == "" {
+		req.Policy = s.takedownPolicy
+	}
+	if req.Policy != TakedownPolicyDelete && req.Policy != TakedownPolicyBlock {
+		_ = c.Error(errors.Errorf("failed to parse takedown policy %v", req.Policy))
+		return
+	}
+	td := &Takedown{
+		ResourceID:      id,
+		ReasonCode:      req.ReasonCode,
+		Policy:          req.Policy,
+		Reporter:        req.Reporter,
+		ReporterContact: req.ReporterContact,
+		NoticeURL:       req.NoticeURL,
+		Notes:           req.Notes,
+	}
+	if cl := s.auth.claims(c); cl != nil {
+		td.RequestedBy = &cl.SessionID
+	}
+	remote := c.ClientIP()
+	td.RemoteAddress = &remote
+
+	var res *Resource
+	err := db.RunInTransaction(c.Request.Context(), func(tx *pg.Tx) error {
+		if err := TakedownCreate(c.Request.Context(), tx, td); err != nil {
+			return err
+		}
+		if td.Policy != TakedownPolicyDelete {
+			return nil
+		}
+		var err error
+		res, err = ResourceQueueForDeletion(c.Request.Context(), tx, id)
+		if errors.Is(err, ErrLegalHold) {
+			log.WithField("resource_id", id).Warn("resource is under legal hold, takedown blocks access only")
+			res, err = ResourceGetByID(c.Request.Context(), tx, id)
+		}
+		return err
+	})
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	log.WithFields(log.Fields{
+		"resource_id": id,
+		"takedown_id": td.TakedownID,
+		"reason_code": td.ReasonCode,
+		"policy":      td.Policy,
+	}).Info("resource taken down")
+	c.JSON(http.StatusCreated, &TakedownResponse{Takedown: td, Resource: res})
+}
+
+// GET /admin/takedown/{id}
+// getTakedowns godoc
+// @Summary      List takedowns of resource
+// @Tags         admin
+// @Param        id   path      string  true  "Resource ID"
+// @Success      200  {array}   Takedown
+// @Failure      401  {object}  ErrorResponse
+// @Failure      403  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /admin/takedown/{id} [get]
+func (s *Web) getTakedowns(c *gin.Context) {
+	db := s.pg.Get()
+	if db == nil {
+		_ = c.Error(errors.New("DB not configured"))
+		return
+	}
+	list, err := TakedownList(c.Request.Context(), db, c.Param("id"))
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"takedowns": list})
+}
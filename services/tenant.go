@@ -0,0 +1,195 @@
+package services
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+)
+
+const (
+	tenantMaxResourcesFlag = "tenant-max-resources"
+	tenantMaxSizeFlag      = "tenant-max-size"
+)
+
+// RegisterTenantFlags registers CLI flags for tenant quotas.
+func RegisterTenantFlags(f []cli.Flag) []cli.Flag {
+	return append(f,
+		cli.IntFlag{
+			Name:   tenantMaxResourcesFlag,
+			Usage:  "max number of resources a tenant may own, further resources are rejected (0 disables)",
+			EnvVar: "TENANT_MAX_RESOURCES",
+		},
+		cli.Int64Flag{
+			Name:   tenantMaxSizeFlag,
+			Usage:  "max total size in bytes of resources a tenant may own, new resources are rejected once it is reached (0 disables)",
+			EnvVar: "TENANT_MAX_SIZE",
+		},
+	)
+}
+
+// TenantQuota limits resources owned by a single tenant.
+type TenantQuota struct {
+	maxResources int
+	maxSize      int64
+}
+
+func NewTenantQuota(c *cli.Context) *TenantQuota {
+	return &TenantQuota{
+		maxResources: c.Int(tenantMaxResourcesFlag),
+		maxSize:      c.Int64(tenantMaxSizeFlag),
+	}
+}
+
+// check returns ErrQuotaExceeded if tenant may not own one more resource. Resources the tenant owns
+// already are not counted twice. Checks of the tenant are serialized until the transaction ends.
+func (s *TenantQuota) check(ctx context.Context, tx *pg.Tx, tenant string, id string) error {
+	if s.maxResources <= 0 && s.maxSize <= 0 {
+		return nil
+	}
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtext(?))", "tenant:"+tenant); err != nil {
+		return err
+	}
+	res, err := ResourceGetByID(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	if res != nil && res.Owner != nil && *res.Owner == tenant {
+		return nil
+	}
+	u, err := TenantUsageGet(ctx, tx, tenant)
+	if err != nil {
+		return err
+	}
+	if s.maxResources > 0 && u.Resources >= s.maxResources {
+		return errors.Wrapf(ErrQuotaExceeded, "%v of %v resources", u.Resources, s.maxResources)
+	}
+	if s.maxSize > 0 && u.TotalSize >= s.maxSize {
+		return errors.Wrapf(ErrQuotaExceeded, "%v of %v bytes", u.TotalSize, s.maxSize)
+	}
+	return nil
+}
+
+// ownedBy reports whether the resource is visible to tenant, empty tenant sees every resource.
+func ownedBy(res *Resource, tenant string) bool {
+	return tenant == "" || (res.Owner != nil && *res.Owner == tenant)
+}
+
+// createOwned runs create of a resource in transaction tx on behalf of tenant: quota of the tenant is checked
+// and the tenant becomes owner of the resource. Resources owned by other tenants fail with ErrResourceExists.
+func (s *Web) createOwned(ctx context.Context, tx *pg.Tx, tenant string, id string, create func() (*Resource, error)) (*Resource, error) {
+	if tenant == "" {
+		return create()
+	}
+	if err := s.quota.check(ctx, tx, tenant, id); err != nil {
+		return nil, err
+	}
+	res, err := create()
+	if err != nil || res == nil {
+		return res, err
+	}
+	return ResourceClaimOwner(ctx, tx, id, tenant)
+}
+
+// ownedResource is a gin middleware hiding resources of other tenants from callers with a tenant token.
+// PUT may store a resource nobody owns, it fails with conflict for resources of other tenants.
+func (s *Web) ownedResource(c *gin.Context) {
+	id := c.Param("id")
+	tenant := s.auth.Tenant(c)
+	db := s.pg.Get()
+	if id == "" || tenant == "" || db == nil {
+		return
+	}
+	res, err := ResourceGetByID(c.Request.Context(), db, id)
+	if err != nil {
+		_ = c.Error(err)
+		c.Abort()
+		return
+	}
+	if res == nil || ownedBy(res, tenant) {
+		return
+	}
+	switch {
+	case c.Request.Method == http.MethodPut && res.Owner == nil:
+		return
+	case c.Request.Method == http.MethodPut:
+		_ = c.Error(ErrResourceExists)
+	default:
+		_ = c.Error(errors.New("resource not found"))
+	}
+	c.Abort()
+}
+
+// requireUnscoped is a gin middleware rejecting callers with a tenant token from operations which can't be
+// limited to resources of a tenant.
+func (s *Web) requireUnscoped(c *gin.Context) {
+	if s.auth.Tenant(c) != "" {
+		_ = c.Error(errors.New("forbidden: not available to tenant tokens"))
+		c.Abort()
+	}
+}
+
+// GET /tenant/usage
+// getTenantUsage godoc
+// @Summary      Tenant usage
+// @Description  Number and size of resources owned by the tenant of the token, quotas limit them if configured.
+// @Description  Callers without a tenant token pass the tenant as ?tenant.
+// @Tags         resource
+// @Produce      json
+// @Param        tenant  query     string  false  "Tenant (only without a tenant token)"
+// @Success      200  {object}  TenantUsage
+// @Failure      400  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /tenant/usage [get]
+func (s *Web) getTenantUsage(c *gin.Context) {
+	db := s.pg.Get()
+	if db == nil {
+		_ = c.Error(errors.New("DB not configured"))
+		return
+	}
+	tenant := s.auth.Tenant(c)
+	if tenant == "" {
+		tenant = c.Query("tenant")
+	}
+	if tenant == "" {
+		_ = c.Error(errors.New("failed to parse tenant: tenant is required"))
+		return
+	}
+	u, err := TenantUsageGet(c.Request.Context(), db, tenant)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, u)
+}
+
+// GET /admin/tenants
+// getTenants godoc
+// @Summary      List tenant usage
+// @Description  Number and size of resources owned by every tenant, largest first.
+// @Tags         admin
+// @Produce      json
+// @Success      200  {array}   TenantUsage
+// @Failure      401  {object}  ErrorResponse
+// @Failure      403  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /admin/tenants [get]
+func (s *Web) getTenants(c *gin.Context) {
+	db := s.pg.Get()
+	if db == nil {
+		_ = c.Error(errors.New("DB not configured"))
+		return
+	}
+	list, err := TenantUsageList(c.Request.Context(), db)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if list == nil {
+		list = []TenantUsage{}
+	}
+	c.JSON(http.StatusOK, list)
+}
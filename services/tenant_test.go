@@ -0,0 +1,184 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func testAuth(p *PG) *Auth {
+	return &Auth{
+		secret:       &Secret{value: []byte("secret")},
+		tokenSecret:  &Secret{value: []byte("token secret")},
+		requireToken: true,
+		pg:           p,
+	}
+}
+
+// testTenantRouter serves 204 from /resource/:id passed through the middlewares. Scoped token claims of
+// tenant in X-Test-Tenant header are set as if they were verified already.
+func testTenantRouter(s *Web, mw ...gin.HandlerFunc) http.Handler {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(s.errorHandler, func(c *gin.Context) {
+		if tenant := c.GetHeader("X-Test-Tenant"); tenant != "" {
+			c.Set(tokenClaimsContextKey, &TokenClaims{Tenant: tenant, Scopes: []TokenScope{TokenScopeRead}})
+		}
+	})
+	h := append(mw, func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+	r.GET("/resource/:id", h...)
+	r.PUT("/resource/:id", h...)
+	return r
+}
+
+func testTenantRequest(h http.Handler, method string, path string, tenant string, token string) int {
+	req := httptest.NewRequest(method, path, nil)
+	if tenant != "" {
+		req.Header.Set("X-Test-Tenant", tenant)
+	}
+	if token != "" {
+		req.Header.Set("X-Token", token)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestOwnedBy(t *testing.T) {
+	a := "a"
+	tests := []struct {
+		name   string
+		owner  *string
+		tenant string
+		want   bool
+	}{
+		{name: "unscoped sees owned", owner: &a, want: true},
+		{name: "unscoped sees unowned", want: true},
+		{name: "owner", owner: &a, tenant: "a", want: true},
+		{name: "another tenant", owner: &a, tenant: "b"},
+		{name: "unowned", tenant: "a"},
+	}
+	for _, tt := range tests {
+		if got := ownedBy(&Resource{Owner: tt.owner}, tt.tenant); got != tt.want {
+			t.Errorf("%v: ownedBy = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRequireUnscoped(t *testing.T) {
+	s := &Web{auth: testAuth(nil)}
+	admin, err := s.auth.internalToken(time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name          string
+		tenant, token string
+		status        int
+	}{
+		{name: "tenant", tenant: "a", status: http.StatusForbidden},
+		{name: "admin", tenant: "a", token: admin, status: http.StatusNoContent},
+		{name: "no token", status: http.StatusNoContent},
+	}
+	h := testTenantRouter(s, s.requireUnscoped)
+	for _, tt := range tests {
+		if got := testTenantRequest(h, http.MethodGet, "/resource/1", tt.tenant, tt.token); got != tt.status {
+			t.Errorf("%v: status = %v, want %v", tt.name, got, tt.status)
+		}
+	}
+}
+
+func TestRequireScope(t *testing.T) {
+	s := &Web{auth: testAuth(nil)}
+	admin, err := s.auth.internalToken(time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name          string
+		scope         TokenScope
+		tenant, token string
+		status        int
+	}{
+		{name: "scope", scope: TokenScopeRead, tenant: "a", status: http.StatusNoContent},
+		{name: "another scope", scope: TokenScopeDelete, tenant: "a", status: http.StatusForbidden},
+		{name: "admin", scope: TokenScopeDelete, token: admin, status: http.StatusNoContent},
+		{name: "no token", scope: TokenScopeRead, status: http.StatusUnauthorized},
+		{name: "invalid token", scope: TokenScopeRead, token: "x", status: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		h := testTenantRouter(s, s.auth.RequireScope(tt.scope))
+		if got := testTenantRequest(h, http.MethodGet, "/resource/1", tt.tenant, tt.token); got != tt.status {
+			t.Errorf("%v: status = %v, want %v", tt.name, got, tt.status)
+		}
+	}
+}
+
+func TestTenantToken(t *testing.T) {
+	p := testPG(t)
+	ctx := context.Background()
+	s := &Web{pg: p, auth: testAuth(p)}
+	rec := &ApiToken{Tenant: "a", Scopes: []TokenScope{TokenScopeRead}, ExpiresAt: time.Now().Add(time.Hour)}
+	if err := ApiTokenCreate(ctx, p.Get(), rec); err != nil {
+		t.Fatal(err)
+	}
+	token, err := s.auth.MintToken(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := testTenantRouter(s, s.auth.RequireScope(TokenScopeRead), s.requireUnscoped)
+	if got := testTenantRequest(read, http.MethodGet, "/resource/1", "", token); got != http.StatusForbidden {
+		t.Errorf("status of tenant token = %v, want %v", got, http.StatusForbidden)
+	}
+	store := testTenantRouter(s, s.auth.RequireScope(TokenScopeStore))
+	if got := testTenantRequest(store, http.MethodGet, "/resource/1", "", token); got != http.StatusForbidden {
+		t.Errorf("status of token without scope = %v, want %v", got, http.StatusForbidden)
+	}
+	if _, err := ApiTokenRevoke(ctx, p.Get(), rec.TokenID); err != nil {
+		t.Fatal(err)
+	}
+	if got := testTenantRequest(read, http.MethodGet, "/resource/1", "", token); got != http.StatusUnauthorized {
+		t.Errorf("status of revoked token = %v, want %v", got, http.StatusUnauthorized)
+	}
+}
+
+func TestOwnedResource(t *testing.T) {
+	p := testPG(t)
+	ctx := context.Background()
+	s := &Web{pg: p, auth: testAuth(p)}
+	owned, unowned, missing := testResourceID(1), testResourceID(2), testResourceID(3)
+	for _, id := range []string{owned, unowned} {
+		if _, err := ResourceQueueForStoring(ctx, p.Get(), id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := ResourceClaimOwner(ctx, p.Get(), owned, "a"); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		method     string
+		id, tenant string
+		status     int
+	}{
+		{name: "owner", method: http.MethodGet, id: owned, tenant: "a", status: http.StatusNoContent},
+		{name: "unscoped", method: http.MethodGet, id: owned, status: http.StatusNoContent},
+		{name: "another tenant", method: http.MethodGet, id: owned, tenant: "b", status: http.StatusNotFound},
+		{name: "unowned", method: http.MethodGet, id: unowned, tenant: "b", status: http.StatusNotFound},
+		{name: "missing", method: http.MethodGet, id: missing, tenant: "b", status: http.StatusNoContent},
+		{name: "store unowned", method: http.MethodPut, id: unowned, tenant: "b", status: http.StatusNoContent},
+		{name: "store of another tenant", method: http.MethodPut, id: owned, tenant: "b", status: http.StatusConflict},
+	}
+	h := testTenantRouter(s, s.ownedResource)
+	for _, tt := range tests {
+		if got := testTenantRequest(h, tt.method, "/resource/"+tt.id, tt.tenant, ""); got != tt.status {
+			t.Errorf("%v: status = %v, want %v", tt.name, got, tt.status)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package services
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultTokenTTL is used when minting request doesn't specify expiry.
+const defaultTokenTTL = 30 * 24 * time.Hour
+
+// TokenRequest is a body of token minting request.
+type TokenRequest struct {
+	Tenant string   `json:"tenant" binding:"required"`
+	Scopes []string `json:"scopes" binding:"required"`
+	// TTL is a Go duration (e.g. 720h), defaults to 30 days
+	TTL       string     `json:"ttl,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Note      *string    `json:"note,omitempty"`
+}
+
+// TokenResponse contains minted token. Token string is returned only once.
+type TokenResponse struct {
+	Token  string    `json:"token"`
+	Record *ApiToken `json:"record"`
+}
+
+// TokensResponse is a page of minted tokens.
+type TokensResponse struct {
+	Tokens []ApiToken `json:"tokens"`
+	Total  int        `json:"total"`
+	Limit  int        `json:"limit"`
+	Offset int        `json:"offset"`
+}
+
+func (r *TokenRequest) toApiToken(now time.Time) (*ApiToken, error) {
+	if len(r.Scopes) == 0 {
+		return nil, errors.New("failed to parse token request: no scopes provided")
+	}
+	t := &ApiToken{
+		TokenID:   uuid.New(),
+		Tenant:    r.Tenant,
+		Note:      r.Note,
+		CreatedAt: now,
+		ExpiresAt: now.Add(defaultTokenTTL),
+	}
+	for _, v := range r.Scopes {
+		sc, err := ParseTokenScope(v)
+		if err != nil {
+			return nil, err
+		}
+		t.Scopes = append(t.Scopes, sc)
+	}
+	if r.TTL != "" && r.ExpiresAt != nil {
+		return nil, errors.New("failed to parse token request: ttl and expires_at are mutually exclusive")
+	}
+	if r.TTL != "" {
+		ttl, err := time.ParseDuration(r.TTL)
+		if err != nil || ttl <= 0 {
+			return nil, errors.Errorf("failed to parse ttl %v", r.TTL)
+		}
+		t.ExpiresAt = now.Add(ttl)
+	}
+	if r.ExpiresAt != nil {
+		if !r.ExpiresAt.After(now) {
+			return nil, errors.New("failed to parse token request: expires_at is in the past")
+		}
+		t.ExpiresAt = *r.ExpiresAt
+	}
+	return t, nil
+}
+
+// POST /admin/tokens
+// postToken godoc
+// @Summary      Mint scoped token
+// @Description  Mints a token signed by vault limited to tenant, scopes and expiry. Scopes: resource:store,
+// @Description  resource:delete, resource:read, webseed:read. Token is enforced when REQUIRE_TOKEN is set.
+// @Tags         admin
+// @Accept       json
+// @Param        request  body      TokenRequest  true  "Token parameters"
+// @Success      201      {object}  TokenResponse
+// @Failure      400      {object}  ErrorResponse
+// @Failure      401      {object}  ErrorResponse
+// @Failure      403      {object}  ErrorResponse
+// @Failure      500      {object}  ErrorResponse
+// @Router       /admin/tokens [post]
+func (s *Web) postToken(c *gin.Context) {
+	db := s.pg.Get()
+	if db == nil {
+		_ = c.Error(errors.New("DB not configured"))
+		return
+	}
+	var req TokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		_ = c.Error(errors.Wrap(err, "failed to parse token request"))
+		return
+	}
+	t, err := req.toApiToken(time.Now().Truncate(time.Second))
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if cl := s.auth.claims(c); cl != nil {
+		t.CreatedBy = &cl.SessionID
+	}
+	token, err := s.auth.MintToken(t)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if err := ApiTokenCreate(c.Request.Context(), db, t); err != nil {
+		_ = c.Error(err)
+		return
+	}
+	log.WithFields(log.Fields{
+		"token_id": t.TokenID,
+		"tenant":   t.Tenant,
+		"scopes":   t.Scopes,
+	}).Info("token minted")
+	c.JSON(http.StatusCreated, &TokenResponse{Token: token, Record: t})
+}
+
+// GET /admin/tokens
+// getTokens godoc
+// @Summary      List minted tokens
+// @Tags         admin
+// @Param        tenant  query     string  false  "Tenant"
+// @Param        limit   query     int     false  "Page size (default 50, max 1000)"
+// @Param        offset  query     int     false  "Page offset"
+// @Success      200     {object}  TokensResponse
+// @Failure      400     {object}  ErrorResponse
+// @Failure      401     {object}  ErrorResponse
+// @Failure      403     {object}  ErrorResponse
+// @Failure      500     {object}  ErrorResponse
+// @Router       /admin/tokens [get]
+func (s *Web) getTokens(c *gin.Context) {
+	db := s.pg.Get()
+	if db == nil {
+		_ = c.Error(errors.New("DB not configured"))
+		return
+	}
+	limit, offset, err := parseListLimits(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	list, total, err := ApiTokenList(c.Request.Context(), db, c.Query("tenant"), limit, offset)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, &TokensResponse{Tokens: list, Total: total, Limit: limit, Offset: offset})
+}
+
+// DELETE /admin/tokens/{id}
+// deleteToken godoc
+// @Summary      Revoke minted token
+// @Tags         admin
+// @Param        id   path      string  true  "Token ID"
+// @Success      200  {object}  ApiToken
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      403  {object}  ErrorResponse
+// @Failure      404  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /admin/tokens/{id} [delete]
+func (s *Web) deleteToken(c *gin.Context) {
+	db := s.pg.Get()
+	if db == nil {
+		_ = c.Error(errors.New("DB not configured"))
+		return
+	}
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		_ = c.Error(errors.Wrap(err, "failed to parse token id"))
+		return
+	}
+	t, err := ApiTokenRevoke(c.Request.Context(), db, id)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if t == nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	log.WithField("token_id", t.TokenID).Info("token revoked")
+	c.JSON(http.StatusOK, gin.H{"token": t})
+}
@@ -0,0 +1,101 @@
+package services
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// maxTorrentSize caps uploaded .torrent files.
+const maxTorrentSize = 10 * 1024 * 1024
+
+// readTorrent returns magnet URI or .torrent content of the request. Multipart forms carry it in
+// torrent (file) or magnet field, other requests in the body.
+func readTorrent(c *gin.Context) ([]byte, error) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxTorrentSize)
+	var data []byte
+	if strings.HasPrefix(c.ContentType(), "multipart/form-data") {
+		if m := c.PostForm("magnet"); m != "" {
+			data = []byte(m)
+		} else {
+			fh, err := c.FormFile("torrent")
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to parse torrent: torrent file or magnet is required")
+			}
+			f, err := fh.Open()
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to parse torrent")
+			}
+			defer func() {
+				_ = f.Close()
+			}()
+			if data, err = io.ReadAll(f); err != nil {
+				return nil, errors.Wrap(err, "failed to parse torrent")
+			}
+		}
+	} else {
+		var err error
+		if data, err = io.ReadAll(c.Request.Body); err != nil {
+			return nil, errors.Wrap(err, "failed to parse torrent")
+		}
+	}
+	data = bytes.TrimSpace(data)
+	// .torrent is a bencoded dictionary
+	if !bytes.HasPrefix(data, []byte("magnet:")) && !bytes.HasPrefix(data, []byte("d")) {
+		return nil, errors.New("failed to parse torrent: body is neither magnet URI nor .torrent file")
+	}
+	return data, nil
+}
+
+// POST /resource — store a resource from magnet URI or .torrent file
+// postResource godoc
+// @Summary      Queue storing of a resource from magnet URI or .torrent file
+// @Description  Pushes magnet URI or .torrent file to webtor rest-api, creates the resource with the derived infohash
+// @Description  and queues it for storing, so no separate rest-api call is needed. The body is the magnet URI or .torrent
+// @Description  content, or a multipart form with magnet or torrent (file) field. Options are the same as of PUT /resource/{id}.
+// @Tags         resource
+// @Accept       plain
+// @Accept       application/x-bittorrent
+// @Accept       multipart/form-data
+// @Param        ttl            query     string  false  "Time to live (Go duration, e.g. 720h)"
+// @Param        max_duration   query     string  false  "Max store duration (Go duration, e.g. 6h)"
+// @Param        storage_class  query     string  false  "S3 storage class of the resource files"
+// @Param        preflight      query     bool    false  "Queue only if content is available in the swarm"
+// @Success      202  {object}  Resource
+// @Failure      400  {object}  ErrorResponse
+// @Failure      424  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /resource [post]
+func (s *Web) postResource(c *gin.Context) {
+	if s.pg.Get() == nil {
+		_ = c.Error(errors.New("DB not configured"))
+		return
+	}
+	data, err := readTorrent(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	req := StoreRequest{
+		StorageClass: c.Query("storage_class"),
+		MaxDuration:  c.Query("max_duration"),
+	}
+	req.TTL = c.Query("ttl")
+	if v := c.Query("preflight"); v != "" {
+		if req.Preflight, err = strconv.ParseBool(v); err != nil {
+			_ = c.Error(errors.Wrapf(err, "failed to parse preflight %v", v))
+			return
+		}
+	}
+	rr, err := s.api.StoreResource(c.Request.Context(), &Claims{Role: "vault"}, data)
+	if err != nil {
+		_ = c.Error(errors.Wrap(err, "failed to store torrent in rest-api"))
+		return
+	}
+	s.queueResource(c, strings.ToLower(rr.ID), &req)
+}
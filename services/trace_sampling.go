@@ -0,0 +1,124 @@
+package services
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// jobSpanAttribute marks root spans of worker jobs, whose traces are sampled at job completion.
+const jobSpanAttribute = attribute.Key("vault.job")
+
+// maxJobSpans caps spans buffered per job, spans above it are dropped.
+const maxJobSpans = 10000
+
+// jobSampler records every job span regardless of the parent decision, so the export decision
+// can be made once the job completes. Other spans are sampled by base.
+type jobSampler struct {
+	base sdktrace.Sampler
+}
+
+func (s *jobSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	for _, a := range p.Attributes {
+		if a.Key == jobSpanAttribute {
+			return sdktrace.SamplingResult{
+				Decision:   sdktrace.RecordAndSample,
+				Tracestate: trace.SpanContextFromContext(p.ParentContext).TraceState(),
+			}
+		}
+	}
+	return s.base.ShouldSample(p)
+}
+
+func (s *jobSampler) Description() string {
+	return "JobSampler{" + s.base.Description() + "}"
+}
+
+// jobTailProcessor buffers spans of job traces until the job root span ends. Traces of failed jobs
+// are always passed to next, traces of successful ones with probability ratio. Other spans pass through.
+type jobTailProcessor struct {
+	next  sdktrace.SpanProcessor
+	ratio float64
+	mux   sync.Mutex
+	// roots maps span to root span of its job
+	roots   map[trace.SpanID]trace.SpanID
+	pending map[trace.SpanID][]sdktrace.ReadOnlySpan
+}
+
+func newJobTailProcessor(next sdktrace.SpanProcessor, ratio float64) *jobTailProcessor {
+	return &jobTailProcessor{
+		next:    next,
+		ratio:   ratio,
+		roots:   map[trace.SpanID]trace.SpanID{},
+		pending: map[trace.SpanID][]sdktrace.ReadOnlySpan{},
+	}
+}
+
+func (s *jobTailProcessor) OnStart(ctx context.Context, span sdktrace.ReadWriteSpan) {
+	id := span.SpanContext().SpanID()
+	s.mux.Lock()
+	if isJobSpan(span) {
+		s.roots[id] = id
+		s.pending[id] = nil
+	} else if root, ok := s.roots[span.Parent().SpanID()]; ok {
+		s.roots[id] = root
+	}
+	s.mux.Unlock()
+	s.next.OnStart(ctx, span)
+}
+
+func (s *jobTailProcessor) OnEnd(span sdktrace.ReadOnlySpan) {
+	id := span.SpanContext().SpanID()
+	s.mux.Lock()
+	root, ok := s.roots[id]
+	if !ok {
+		s.mux.Unlock()
+		s.next.OnEnd(span)
+		return
+	}
+	if id != root {
+		if len(s.pending[root]) < maxJobSpans {
+			s.pending[root] = append(s.pending[root], span)
+		}
+		s.mux.Unlock()
+		return
+	}
+	spans := append(s.pending[root], span)
+	delete(s.pending, root)
+	for k, v := range s.roots {
+		if v == root {
+			delete(s.roots, k)
+		}
+	}
+	s.mux.Unlock()
+	if span.Status().Code != codes.Error && (s.ratio <= 0 || rand.Float64() >= s.ratio) {
+		promJobTraces.WithLabelValues("dropped").Inc()
+		return
+	}
+	promJobTraces.WithLabelValues("exported").Inc()
+	for _, sp := range spans {
+		s.next.OnEnd(sp)
+	}
+}
+
+func (s *jobTailProcessor) Shutdown(ctx context.Context) error {
+	return s.next.Shutdown(ctx)
+}
+
+func (s *jobTailProcessor) ForceFlush(ctx context.Context) error {
+	return s.next.ForceFlush(ctx)
+}
+
+func isJobSpan(span sdktrace.ReadOnlySpan) bool {
+	for _, a := range span.Attributes() {
+		if a.Key == jobSpanAttribute {
+			return true
+		}
+	}
+	return false
+}
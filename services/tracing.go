@@ -0,0 +1,191 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	otelEndpointFlag       = "otel-endpoint"
+	otelServiceNameFlag    = "otel-service-name"
+	otelSampleRatioFlag    = "otel-sample-ratio"
+	otelJobSampleRatioFlag = "otel-job-sample-ratio"
+)
+
+// RegisterTracingFlags registers CLI flags for OpenTelemetry tracing.
+func RegisterTracingFlags(f []cli.Flag) []cli.Flag {
+	return append(f,
+		cli.StringFlag{
+			Name:   otelEndpointFlag,
+			Usage:  "OTLP/HTTP collector url traces are exported to, e.g. http://otel-collector:4318 (disabled if empty)",
+			EnvVar: "OTEL_ENDPOINT",
+		},
+		cli.StringFlag{
+			Name:   otelServiceNameFlag,
+			Usage:  "service name reported in traces",
+			Value:  "vault",
+			EnvVar: "OTEL_SERVICE_NAME",
+		},
+		cli.Float64Flag{
+			Name:   otelSampleRatioFlag,
+			Usage:  "share of new traces sampled (traces started upstream follow the parent decision)",
+			Value:  1,
+			EnvVar: "OTEL_SAMPLE_RATIO",
+		},
+		cli.Float64Flag{
+			Name:   otelJobSampleRatioFlag,
+			Usage:  "share of successful worker jobs whose traces are exported, traces of failed jobs are always exported",
+			Value:  1,
+			EnvVar: "OTEL_JOB_SAMPLE_RATIO",
+		},
+	)
+}
+
+// tracer is resolved through the global provider, so spans are no-op until tracing is configured.
+var tracer = otel.Tracer("github.com/webtor-io/vault")
+
+// Tracing exports spans to OTLP collector.
+type Tracing struct {
+	tp *sdktrace.TracerProvider
+}
+
+// NewTracing installs global tracer provider and W3C propagator. Returns nil if endpoint is not configured.
+func NewTracing(c *cli.Context) (*Tracing, error) {
+	endpoint := c.String(otelEndpointFlag)
+	if endpoint == "" {
+		return nil, nil
+	}
+	exp, err := otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create OTLP exporter")
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		attribute.String("service.name", c.String(otelServiceNameFlag)),
+	))
+	if err != nil {
+		return nil, err
+	}
+	// job spans are always recorded and exported or dropped once the job completes
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithSpanProcessor(newJobTailProcessor(sdktrace.NewBatchSpanProcessor(exp), c.Float64(otelJobSampleRatioFlag))),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(&jobSampler{base: sdktrace.ParentBased(sdktrace.TraceIDRatioBased(c.Float64(otelSampleRatioFlag)))}),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	log.Infof("exporting traces to %v", endpoint)
+	return &Tracing{tp: tp}, nil
+}
+
+// Close flushes pending spans. Nil Tracing is a no-op.
+func (s *Tracing) Close() {
+	if s == nil {
+		return
+	}
+	log.Info("closing Tracing")
+	if err := s.tp.Shutdown(context.Background()); err != nil {
+		log.WithError(err).Warn("failed to flush traces")
+	}
+}
+
+// endSpan records err (if any) and ends the span.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// traceContextOf serializes trace context of ctx so it can be stored with a queued job. Returns nil
+// if ctx carries no trace.
+func traceContextOf(ctx context.Context) map[string]string {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		return nil
+	}
+	return carrier
+}
+
+// withTraceContext returns ctx continuing the trace stored by traceContextOf.
+func withTraceContext(ctx context.Context, tc map[string]string) context.Context {
+	if len(tc) == 0 {
+		return ctx
+	}
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier(tc))
+}
+
+// injectTraceHeaders propagates trace context of the request context to an outgoing request.
+func injectTraceHeaders(r *http.Request) {
+	otel.GetTextMapPropagator().Inject(r.Context(), propagation.HeaderCarrier(r.Header))
+}
+
+// spanReadCloser ends the span once the body is closed, so the span covers the whole transfer.
+type spanReadCloser struct {
+	io.ReadCloser
+	span trace.Span
+	n    int64
+	err  error
+}
+
+func (s *spanReadCloser) Read(b []byte) (int, error) {
+	n, err := s.ReadCloser.Read(b)
+	s.n += int64(n)
+	if err != nil && !errors.Is(err, io.EOF) {
+		s.err = err
+	}
+	return n, err
+}
+
+func (s *spanReadCloser) Close() error {
+	err := s.ReadCloser.Close()
+	s.span.SetAttributes(attribute.Int64("bytes", s.n))
+	endSpan(s.span, s.err)
+	return err
+}
+
+// traceRequest is a gin middleware continuing the incoming trace (if any) with a server span.
+func (s *Web) traceRequest(c *gin.Context) {
+	ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+	ctx, span := tracer.Start(ctx, fmt.Sprintf("%v %v", c.Request.Method, route),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.request.method", c.Request.Method),
+			attribute.String("http.route", route),
+			attribute.String("request_id", RequestIDFromContext(ctx)),
+		),
+	)
+	defer span.End()
+	c.Request = c.Request.WithContext(ctx)
+
+	c.Next()
+
+	status := c.Writer.Status()
+	span.SetAttributes(attribute.Int("http.response.status_code", status))
+	if status >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+	if len(c.Errors) > 0 {
+		span.RecordError(c.Errors[0])
+	}
+}
@@ -0,0 +1,121 @@
+package services
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+//go:embed ui
+var uiFiles embed.FS
+
+const (
+	// resourceEventsInterval is how often the event stream polls for updated resources
+	resourceEventsInterval = 2 * time.Second
+	// resourceEventsKeepAlive is the longest period without writes to the event stream
+	resourceEventsKeepAlive = 15 * time.Second
+	resourceEventsBatchSize = 500
+)
+
+// uiHandler serves the embedded dashboard.
+func uiHandler() gin.HandlerFunc {
+	sub, err := fs.Sub(uiFiles, "ui")
+	if err != nil {
+		panic(err)
+	}
+	h := http.StripPrefix("/ui", http.FileServer(http.FS(sub)))
+	return func(c *gin.Context) {
+		h.ServeHTTP(c.Writer, c.Request)
+	}
+}
+
+// tokenFromQuery passes token query parameter as X-Token header, as browsers can't set headers of
+// EventSource requests.
+func tokenFromQuery(c *gin.Context) {
+	if t := c.Query("token"); t != "" && c.GetHeader("X-Token") == "" {
+		c.Request.Header.Set("X-Token", t)
+	}
+}
+
+// GET /resources/events
+// getResourceEvents godoc
+// @Summary      Stream resource updates
+// @Description  Server-sent events stream sending a resource event with the resource JSON every time a resource changes:
+// @Description  status, stored size (progress while storing) or error. Changes are polled every 2 seconds, so every
+// @Description  instance serves changes made by any worker. Reconnecting clients resume from Last-Event-ID.
+// @Description  Browsers may pass the token as ?token since EventSource can't set headers.
+// @Tags         resource
+// @Produce      text/event-stream
+// @Param        token  query     string  false  "X-Token for clients which can't set headers"
+// @Success      200    {object}  Resource
+// @Failure      500    {object}  ErrorResponse
+// @Router       /resources/events [get]
+func (s *Web) getResourceEvents(c *gin.Context) {
+	db := s.pg.Get()
+	if db == nil {
+		_ = c.Error(errors.New("DB not configured"))
+		return
+	}
+	since := time.Now()
+	if v := c.GetHeader("Last-Event-ID"); v != "" {
+		us, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			_ = c.Error(errors.Wrapf(err, "failed to parse Last-Event-ID %v", v))
+			return
+		}
+		since = time.UnixMicro(us)
+	}
+	ctx := c.Request.Context()
+	tenant := s.auth.Tenant(c)
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+	t := time.NewTicker(resourceEventsInterval)
+	defer t.Stop()
+	written := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		list, err := ResourceListUpdatedSince(ctx, db, tenant, since, resourceEventsBatchSize)
+		if err != nil {
+			if ctx.Err() == nil {
+				logger(ctx).WithError(err).Warn("failed to poll resource events")
+			}
+			continue
+		}
+		now := time.Now()
+		for i := range list {
+			res := list[i].SetTTL(now)
+			b, err := json.Marshal(res)
+			if err != nil {
+				log.WithError(err).Error("failed to marshal resource event")
+				continue
+			}
+			since = res.UpdatedAt
+			if _, err = fmt.Fprintf(c.Writer, "id: %d\nevent: resource\ndata: %s\n\n", since.UnixMicro(), b); err != nil {
+				return
+			}
+			written = now
+		}
+		if now.Sub(written) >= resourceEventsKeepAlive {
+			if _, err = fmt.Fprint(c.Writer, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			written = now
+		}
+		c.Writer.Flush()
+	}
+}
@@ -0,0 +1,158 @@
+'use strict';
+
+// names of numeric resource statuses, see Status in services/models.go
+const statuses = ['queued_for_storing', 'storing', 'stored', 'store_error', 'queued_for_deletion',
+  'deleting', 'delete_error', 'corrupted', 'pending_purge'];
+
+let current = 'storing';
+let events = null;
+const rows = new Map();
+
+function token() {
+  return localStorage.getItem('vault-token') || '';
+}
+
+async function api(method, path) {
+  const headers = {};
+  if (token()) {
+    headers['X-Token'] = token();
+  }
+  const res = await fetch(path, { method, headers });
+  const body = res.status === 204 ? null : await res.json().catch(() => null);
+  if (!res.ok) {
+    throw new Error((body && body.error) || res.status + ' ' + res.statusText);
+  }
+  return body;
+}
+
+function message(text) {
+  document.getElementById('message').textContent = text || '';
+}
+
+function formatSize(n) {
+  const units = ['B', 'KB', 'MB', 'GB', 'TB'];
+  let i = 0;
+  while (n >= 1024 && i < units.length - 1) {
+    n /= 1024;
+    i++;
+  }
+  return n.toFixed(i ? 1 : 0) + ' ' + units[i];
+}
+
+function render(r) {
+  const status = statuses[r.status] || String(r.status);
+  let tr = rows.get(r.resource_id);
+  if (status !== current) {
+    if (tr) {
+      tr.remove();
+      rows.delete(r.resource_id);
+    }
+    return;
+  }
+  if (!tr) {
+    tr = document.createElement('tr');
+    rows.set(r.resource_id, tr);
+    document.getElementById('resources').prepend(tr);
+  }
+  tr.replaceChildren();
+  const id = document.createElement('td');
+  id.className = 'id';
+  id.textContent = r.resource_id;
+  if (r.error) {
+    const err = document.createElement('div');
+    err.className = 'error';
+    err.textContent = r.error;
+    id.append(err);
+  }
+  const st = document.createElement('td');
+  st.textContent = status;
+  const progress = document.createElement('td');
+  const pct = r.total_size > 0 ? Math.min(100, 100 * r.stored_size / r.total_size) : (status === 'stored' ? 100 : 0);
+  const bar = document.createElement('div');
+  bar.className = 'bar';
+  const fill = document.createElement('div');
+  fill.style.width = pct.toFixed(1) + '%';
+  bar.append(fill);
+  const size = document.createElement('div');
+  size.className = 'size';
+  size.textContent = formatSize(r.stored_size) + ' / ' + formatSize(r.total_size) + ' (' + pct.toFixed(1) + '%)';
+  progress.append(bar, size);
+  const updated = document.createElement('td');
+  updated.textContent = new Date(r.updated_at).toLocaleString();
+  const actions = document.createElement('td');
+  actions.className = 'actions';
+  if (status === 'store_error' || status === 'delete_error') {
+    actions.append(button('Retry', () => action('POST', '/resource/' + r.resource_id + '/retry')));
+  }
+  if (status !== 'queued_for_deletion' && status !== 'deleting') {
+    actions.append(button('Delete', () => {
+      if (confirm('Delete ' + r.resource_id + '?')) {
+        action('DELETE', '/resource/' + r.resource_id);
+      }
+    }));
+  }
+  tr.append(id, st, progress, updated, actions);
+}
+
+function button(label, onClick) {
+  const b = document.createElement('button');
+  b.textContent = label;
+  b.addEventListener('click', onClick);
+  return b;
+}
+
+async function action(method, path) {
+  try {
+    const body = await api(method, path);
+    message('');
+    if (body && body.resource) {
+      render(body.resource);
+    }
+  } catch (e) {
+    message(e.message);
+  }
+}
+
+async function load() {
+  rows.clear();
+  document.getElementById('resources').replaceChildren();
+  try {
+    const page = await api('GET', '/resources?status=' + current + '&limit=200');
+    page.resources.reverse().forEach(render);
+    document.getElementById('total').textContent = page.total + ' ' + current.replaceAll('_', ' ');
+    message('');
+  } catch (e) {
+    message(e.message);
+  }
+}
+
+function subscribe() {
+  if (events) {
+    events.close();
+  }
+  const q = token() ? '?token=' + encodeURIComponent(token()) : '';
+  events = new EventSource('/resources/events' + q);
+  events.addEventListener('resource', (e) => render(JSON.parse(e.data)));
+  events.onerror = () => message('event stream disconnected, reconnecting');
+  events.onopen = () => message('');
+}
+
+document.querySelectorAll('#tabs button').forEach((b) => {
+  b.addEventListener('click', () => {
+    document.querySelectorAll('#tabs button').forEach((x) => x.classList.remove('active'));
+    b.classList.add('active');
+    current = b.dataset.status;
+    load();
+  });
+});
+
+document.getElementById('token').value = token();
+document.getElementById('token-form').addEventListener('submit', (e) => {
+  e.preventDefault();
+  localStorage.setItem('vault-token', document.getElementById('token').value);
+  load();
+  subscribe();
+});
+
+load();
+subscribe();
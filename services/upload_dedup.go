@@ -0,0 +1,92 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+)
+
+const (
+	// uploadStaleAfter is how long a file being stored may go without progress flushes before its
+	// upload is considered abandoned and taken over by another job
+	uploadStaleAfter = 10 * time.Second
+	// uploadWaitInterval is how often a job waiting for an upload of the same content checks the file
+	uploadWaitInterval = 2 * time.Second
+)
+
+// uploadStale reports whether nobody uploads the file which is not stored.
+func uploadStale(f *File) bool {
+	return time.Since(f.UpdatedAt) > uploadStaleAfter
+}
+
+// claimUpload claims upload of the file f. If another job uploads the same content, it waits for the
+// upload and returns the stored file to link instead. Uploads abandoned meanwhile are claimed again.
+// A pending deletion of the object of the same content is finished before the upload is claimed.
+func (s *Worker) claimUpload(ctx context.Context, db *pg.DB, f *File) (*File, error) {
+	for {
+		var claimed bool
+		err := db.RunInTransaction(ctx, func(tx *pg.Tx) (err error) {
+			if err = FileLock(ctx, tx, f.Hash, false); err != nil {
+				return err
+			}
+			claimed, err = FileClaimUpload(ctx, tx, f)
+			return err
+		})
+		if err != nil || claimed {
+			return nil, err
+		}
+		done, err := s.awaitUpload(ctx, db, f.Hash)
+		if err != nil || done != nil {
+			return done, err
+		}
+	}
+}
+
+// awaitUpload waits until the file is stored by another job and returns it. Nil is returned once
+// the upload is abandoned or the file is gone.
+func (s *Worker) awaitUpload(ctx context.Context, db *pg.DB, hash string) (*File, error) {
+	t := time.NewTicker(uploadWaitInterval)
+	defer t.Stop()
+	l := logger(ctx).WithField("hash", hash)
+	waiting := false
+	for {
+		f := &File{Hash: hash}
+		err := db.Model(f).Context(ctx).WherePK().Select()
+		if errors.Is(err, pg.ErrNoRows) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if f.Status == StatusStored {
+			if waiting {
+				promUploadDedupWaits.WithLabelValues("linked").Inc()
+				l.Info("linked file uploaded by another job")
+			}
+			return f, nil
+		}
+		// staleness is decided by the DB clock, the same way uploads are claimed
+		stale, err := FileUploadAbandoned(ctx, db, hash)
+		if err != nil {
+			return nil, err
+		}
+		if stale {
+			if waiting {
+				promUploadDedupWaits.WithLabelValues("taken_over").Inc()
+				l.Info("upload of another job abandoned, taking over")
+			}
+			return nil, nil
+		}
+		if !waiting {
+			waiting = true
+			l.WithField("storing_resource_id", f.StoringResourceID).Info("waiting for upload of the same content by another job")
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-t.C:
+		}
+	}
+}
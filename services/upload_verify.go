@@ -0,0 +1,90 @@
+package services
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	awss3 "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	log "github.com/sirupsen/logrus"
+)
+
+// uploadDigest counts and checksums bytes of an upload body as they are sent to S3.
+type uploadDigest struct {
+	r     io.Reader
+	n     int64
+	md5   hash.Hash
+	crc32 hash.Hash32
+}
+
+func newUploadDigest(r io.Reader) *uploadDigest {
+	return &uploadDigest{r: r, md5: md5.New(), crc32: crc32.NewIEEE()}
+}
+
+func (s *uploadDigest) Read(b []byte) (int, error) {
+	n, err := s.r.Read(b)
+	if n > 0 {
+		s.n += int64(n)
+		s.md5.Write(b[:n])
+		s.crc32.Write(b[:n])
+	}
+	return n, err
+}
+
+func (s *uploadDigest) crc32Base64() string {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, s.crc32.Sum32())
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// verifyUpload compares the uploaded object with the body sent: object and body size with the expected
+// object size and, for single-part uploads, ETag with MD5 of the body (unless SSE-KMS makes ETag opaque)
+// and CRC32 returned by the S3 checksum API if the object has it.
+func (s *Worker) verifyUpload(ctx context.Context, input *s3manager.UploadInput, d *uploadDigest, size int64) error {
+	fail := func(check string, format string, args ...any) error {
+		promUploadVerifyFailures.WithLabelValues(check).Inc()
+		err := fmt.Errorf("%w: %v", ErrUploadMismatch, fmt.Sprintf(format, args...))
+		logger(ctx).WithError(err).WithFields(log.Fields{"bucket": aws.StringValue(input.Bucket), "key": aws.StringValue(input.Key)}).Warn("upload verification failed")
+		return err
+	}
+	want := s.enc.ObjectSize(size)
+	if d.n != want {
+		return fail("size", "sent %v bytes, expected %v", d.n, want)
+	}
+	head, err := s.s3.Get().HeadObjectWithContext(ctx, &awss3.HeadObjectInput{
+		Bucket:       input.Bucket,
+		Key:          input.Key,
+		ChecksumMode: aws.String(awss3.ChecksumModeEnabled),
+	})
+	if err != nil {
+		return err
+	}
+	if n := aws.Int64Value(head.ContentLength); n != want {
+		return fail("size", "object has %v bytes, expected %v", n, want)
+	}
+	etag := strings.Trim(aws.StringValue(head.ETag), `"`)
+	if strings.Contains(etag, "-") {
+		// ETag and checksums of multipart objects are composed of part checksums
+		return nil
+	}
+	if !strings.HasPrefix(aws.StringValue(head.ServerSideEncryption), awss3.ServerSideEncryptionAwsKms) {
+		if sum := hex.EncodeToString(d.md5.Sum(nil)); etag != sum {
+			return fail("md5", "object ETag %v does not match MD5 %v", etag, sum)
+		}
+	}
+	if v := aws.StringValue(head.ChecksumCRC32); v != "" && !strings.Contains(v, "-") {
+		if sum := d.crc32Base64(); v != sum {
+			return fail("crc32", "object CRC32 %v does not match %v", v, sum)
+		}
+	}
+	return nil
+}
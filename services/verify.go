@@ -0,0 +1,410 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	awss3 "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gin-gonic/gin"
+	"github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	cs "github.com/webtor-io/common-services"
+)
+
+const (
+	verifySampleRateFlag = "verify-sample-rate"
+)
+
+// RegisterVerifyFlags registers CLI flags for verification of stored objects.
+func RegisterVerifyFlags(f []cli.Flag) []cli.Flag {
+	return append(f,
+		cli.Float64Flag{
+			Name:   verifySampleRateFlag,
+			Usage:  "fraction of verified files whose content is re-hashed (0 only checks existence and size, 1 re-hashes all)",
+			Value:  0.1,
+			EnvVar: "VERIFY_SAMPLE_RATE",
+		},
+	)
+}
+
+// RegisterVerifyCommandFlags registers flags of the standalone verify command which
+// are otherwise registered by serve.
+func RegisterVerifyCommandFlags(f []cli.Flag) []cli.Flag {
+	return append(RegisterVerifyFlags(f),
+		cli.StringFlag{
+			Name:   awsBucketFlag,
+			Usage:  "aws bucket",
+			EnvVar: "AWS_BUCKET",
+		},
+	)
+}
+
+// sampledHashChunk is the size of content hashed at each end of the file in sampled mode,
+// must be kept in sync with Worker.generateFileHash.
+const sampledHashChunk = 500 * 1024
+
+// FileVerification is the result of checking a single stored file.
+type FileVerification struct {
+	Hash string `json:"hash"`
+	Path string `json:"path,omitempty"`
+	// Size expected by DB
+	Size int64 `json:"size"`
+	// ObjectSize is the plaintext size of the S3 object, absent if object is missing
+	ObjectSize *int64 `json:"object_size,omitempty"`
+	Exists     bool   `json:"exists"`
+	Rehashed   bool   `json:"rehashed"`
+	Corrupted  bool   `json:"corrupted"`
+	Error      string `json:"error,omitempty"`
+}
+
+// VerifyReport summarizes verification of a resource or of the whole storage.
+type VerifyReport struct {
+	ResourceID string             `json:"resource_id,omitempty"`
+	Files      []FileVerification `json:"files"`
+	Checked    int                `json:"checked"`
+	Rehashed   int                `json:"rehashed"`
+	Corrupted  int                `json:"corrupted"`
+	// Resources marked as corrupted during verification
+	CorruptedResources []string `json:"corrupted_resources,omitempty"`
+}
+
+func (s *VerifyReport) add(fv *FileVerification, ids []string) {
+	s.Files = append(s.Files, *fv)
+	s.Checked++
+	if fv.Rehashed {
+		s.Rehashed++
+	}
+	if fv.Corrupted {
+		s.Corrupted++
+	}
+	s.CorruptedResources = append(s.CorruptedResources, ids...)
+}
+
+// Verifier checks that S3 objects of stored files exist, match sizes recorded in DB
+// and, for a sample of files, still produce the recorded hash.
+type Verifier struct {
+	pg         *PG
+	s3         *cs.S3Client
+	enc        *Encryption
+	keys       *ObjectKeys
+	bucket     string
+	sampleRate float64
+}
+
+func NewVerifier(c *cli.Context, pg *PG, s3 *cs.S3Client, enc *Encryption, keys *ObjectKeys) *Verifier {
+	return &Verifier{
+		pg:         pg,
+		s3:         s3,
+		enc:        enc,
+		keys:       keys,
+		bucket:     c.String(awsBucketFlag),
+		sampleRate: c.Float64(verifySampleRateFlag),
+	}
+}
+
+// VerifyResource checks all stored files of the resource. If rehash is set, content of every
+// file is re-hashed, otherwise only a sample of files is. Returns nil if resource does not exist.
+func (s *Verifier) VerifyResource(ctx context.Context, id string, rehash bool) (*VerifyReport, error) {
+	db := s.pg.Get()
+	if db == nil {
+		return nil, errors.New("DB not configured")
+	}
+	res, err := ResourceGetByID(ctx, db, id)
+	if err != nil || res == nil {
+		return nil, err
+	}
+	var rfs []ResourceFile
+	err = db.Model(&rfs).Context(ctx).
+		Relation("File").
+		Where("resource_file.resource_id = ?", id).
+		Order("resource_file.path").
+		Select()
+	if err != nil && !errors.Is(err, pg.ErrNoRows) {
+		return nil, err
+	}
+	report := &VerifyReport{ResourceID: id, Files: []FileVerification{}}
+	for _, rf := range rfs {
+		if rf.File == nil || (rf.File.Status != StatusStored && rf.File.Status != StatusCorrupted) {
+			continue
+		}
+		fv, ids, err := s.verifyFile(ctx, db, rf.File, rehash || s.sampled())
+		if err != nil {
+			return nil, err
+		}
+		fv.Path = rf.Path
+		report.add(fv, ids)
+	}
+	return report, nil
+}
+
+// VerifyAll checks all stored files page by page. Only corrupted files are kept in the report.
+func (s *Verifier) VerifyAll(ctx context.Context, pageSize int) (*VerifyReport, error) {
+	db := s.pg.Get()
+	if db == nil {
+		return nil, errors.New("DB not configured")
+	}
+	report := &VerifyReport{Files: []FileVerification{}}
+	last := ""
+	for {
+		var files []File
+		err := db.Model(&files).Context(ctx).
+			Where("status = ?", StatusStored).
+			Where("hash > ?", last).
+			Order("hash").
+			Limit(pageSize).
+			Select()
+		if err != nil && !errors.Is(err, pg.ErrNoRows) {
+			return nil, err
+		}
+		for i := range files {
+			fv, ids, err := s.verifyFile(ctx, db, &files[i], s.sampled())
+			if err != nil {
+				return nil, err
+			}
+			report.add(fv, ids)
+			if !fv.Corrupted {
+				report.Files = report.Files[:len(report.Files)-1]
+			}
+		}
+		if len(files) < pageSize {
+			return report, nil
+		}
+		last = files[len(files)-1].Hash
+	}
+}
+
+func (s *Verifier) sampled() bool {
+	return s.sampleRate >= 1 || (s.sampleRate > 0 && rand.Float64() < s.sampleRate)
+}
+
+// verifyFile checks the S3 object of the file and marks it corrupted on mismatch.
+// Errors are returned only if the check itself could not be completed.
+func (s *Verifier) verifyFile(ctx context.Context, db pg.DBI, f *File, rehash bool) (*FileVerification, []string, error) {
+	fv := &FileVerification{Hash: f.Hash, Size: f.TotalSize}
+	if f.Path != nil {
+		fv.Path = *f.Path
+	}
+	var err error
+	if f.Chunked {
+		err = s.verifyChunked(ctx, db, f, rehash, fv)
+	} else {
+		err = s.verifyObject(ctx, f, rehash, fv)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if fv.Error == "" {
+		return fv, nil, nil
+	}
+	fv.Corrupted = true
+	promVerifyCorruptedFiles.Inc()
+	log.WithFields(log.Fields{"key": f.Hash, "reason": fv.Error}).Warn("corrupted file found")
+	ids, err := FileMarkCorrupted(ctx, db, f.Hash, "verification failed: "+fv.Error)
+	if err != nil {
+		return nil, nil, err
+	}
+	return fv, ids, nil
+}
+
+// verifyObject checks the S3 object of the file, recording a mismatch in fv.
+func (s *Verifier) verifyObject(ctx context.Context, f *File, rehash bool, fv *FileVerification) error {
+	head, err := s.s3.Get().HeadObjectWithContext(ctx, &awss3.HeadObjectInput{
+		Bucket: aws.String(f.bucketOr(s.bucket)),
+		Key:    aws.String(s.keys.Key(f.Hash)),
+	})
+	if err != nil && !isS3NotFoundError(err) {
+		return errors.Wrapf(err, "failed to head %v", f.Hash)
+	}
+	if err == nil {
+		fv.Exists = true
+		eo, err := s.enc.Object(head.Metadata)
+		if err != nil {
+			return err
+		}
+		size := aws.Int64Value(head.ContentLength)
+		if eo != nil {
+			size = eo.plainSize
+		}
+		fv.ObjectSize = &size
+		switch {
+		case size != f.TotalSize:
+			fv.Error = fmt.Sprintf("object size %v does not match %v", size, f.TotalSize)
+		case rehash && isArchiveStorageClass(aws.StringValue(head.StorageClass)):
+			// archived objects can't be read until they are restored
+		case rehash:
+			fv.Rehashed = true
+			hash, err := s.hashObject(ctx, f, eo)
+			if err != nil {
+				return err
+			}
+			if hash != f.Hash {
+				fv.Error = fmt.Sprintf("content hash %v does not match", hash)
+			}
+		}
+	} else {
+		fv.Error = "object not found"
+	}
+	return nil
+}
+
+// verifyChunked checks that chunks of the chunked file cover it and, with rehash, that their objects
+// still produce the file hash, recording a mismatch in fv.
+func (s *Verifier) verifyChunked(ctx context.Context, db pg.DBI, f *File, rehash bool, fv *FileVerification) error {
+	chunks, err := FileChunkList(ctx, db, f.Hash)
+	if err != nil {
+		return err
+	}
+	if len(chunks) == 0 && f.TotalSize > 0 {
+		fv.Error = "chunks not found"
+		return nil
+	}
+	fv.Exists = true
+	var size int64
+	for _, c := range chunks {
+		size += c.Size
+	}
+	fv.ObjectSize = &size
+	switch {
+	case size != f.TotalSize:
+		fv.Error = fmt.Sprintf("chunks size %v does not match %v", size, f.TotalSize)
+	case rehash:
+		fv.Rehashed = true
+		hash, err := hashFile(f, func(w io.Writer, start, end int64) error {
+			return s.copyChunks(ctx, w, chunks, start, end)
+		})
+		if err != nil && isS3NotFoundError(err) {
+			fv.Error = err.Error()
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hash != f.Hash {
+			fv.Error = fmt.Sprintf("content hash %v does not match", hash)
+		}
+	}
+	return nil
+}
+
+// hashObject re-computes file hash from the S3 object the same way worker did while storing.
+func (s *Verifier) hashObject(ctx context.Context, f *File, eo *encryptedObject) (string, error) {
+	bucket, key := f.bucketOr(s.bucket), s.keys.Key(f.Hash)
+	return hashFile(f, func(w io.Writer, start, end int64) error {
+		return s.copyRange(ctx, w, bucket, key, eo, start, end)
+	})
+}
+
+// hashFile computes hash of the file with its algorithm, read writes content bytes [start, end] to w.
+func hashFile(f *File, read func(w io.Writer, start, end int64) error) (string, error) {
+	size := f.TotalSize
+	h := f.HashAlgo.newHasher()
+	if f.HashAlgo == HashAlgoSampled {
+		h.Write([]byte(fmt.Sprintf("%v", size)))
+	}
+	if f.HashAlgo != HashAlgoSampled || size < 2*sampledHashChunk {
+		if err := read(h, 0, size-1); err != nil {
+			return "", err
+		}
+	} else {
+		if err := read(h, 0, sampledHashChunk); err != nil {
+			return "", err
+		}
+		if err := read(h, size-sampledHashChunk, size-1); err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// copyRange writes plaintext bytes [start, end] of the object of the bucket to w.
+func (s *Verifier) copyRange(ctx context.Context, w io.Writer, bucket, key string, eo *encryptedObject, start, end int64) error {
+	if end < start {
+		return nil
+	}
+	rng := fmt.Sprintf("bytes=%d-%d", start, end)
+	if eo != nil {
+		rng = eo.CipherRange(start, end).String()
+	}
+	out, err := s.s3.Get().GetObjectWithContext(ctx, &awss3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Range:  aws.String(rng),
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to get %v", key)
+	}
+	defer func(b io.ReadCloser) {
+		_ = b.Close()
+	}(out.Body)
+	var r io.Reader = out.Body
+	if eo != nil {
+		r = eo.Reader(r, start, end)
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
+
+// copyChunks writes bytes [start, end] of the chunked file to w.
+func (s *Verifier) copyChunks(ctx context.Context, w io.Writer, chunks []FileChunk, start, end int64) error {
+	if end < start {
+		return nil
+	}
+	r := newChunkedReader(ctx, s.s3.Get(), s.bucket, s.keys, chunks, start, end)
+	defer func() { _ = r.Close() }()
+	_, err := io.Copy(w, r)
+	return err
+}
+
+// copyFileRange writes plaintext bytes [start, end] of the stored file to w.
+func (s *Verifier) copyFileRange(ctx context.Context, w io.Writer, f *File, eo *encryptedObject, start, end int64) error {
+	if !f.Chunked {
+		return s.copyRange(ctx, w, f.bucketOr(s.bucket), s.keys.Key(f.Hash), eo, start, end)
+	}
+	chunks, err := FileChunkList(ctx, s.pg.Get(), f.Hash)
+	if err != nil {
+		return err
+	}
+	return s.copyChunks(ctx, w, chunks, start, end)
+}
+
+// POST /resource/{id}/verify
+// postResourceVerify godoc
+// @Summary      Verify stored resource
+// @Description  Checks that S3 objects of all files of the resource exist and match sizes recorded in DB,
+// @Description  re-hashing content of a sample of files (or all of them with rehash=true).
+// @Description  Mismatching files and resources referencing them are marked as corrupted.
+// @Tags         resource
+// @Param        id      path      string  true   "Resource ID"
+// @Param        rehash  query     bool    false  "Re-hash content of every file"
+// @Success      200  {object}  VerifyReport
+// @Failure      400  {object}  ErrorResponse
+// @Failure      404  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /resource/{id}/verify [post]
+func (s *Web) postResourceVerify(c *gin.Context) {
+	rehash := false
+	if v := c.Query("rehash"); v != "" {
+		var err error
+		if rehash, err = strconv.ParseBool(v); err != nil {
+			_ = c.Error(errors.Wrap(err, "failed to parse rehash"))
+			return
+		}
+	}
+	report, err := s.verifier.VerifyResource(c.Request.Context(), c.Param("id"), rehash)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if report == nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
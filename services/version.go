@@ -0,0 +1,121 @@
+package services
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+)
+
+// UpdateRequest is a body of update request.
+type UpdateRequest struct {
+	ExpiryRequest
+	// TargetID is the infohash of the new version, it must not exist yet
+	TargetID string `json:"target_id"`
+}
+
+// ResourceVersionList is the version history of a resource.
+type ResourceVersionList struct {
+	ResourceID string     `json:"resource_id"`
+	Versions   []Resource `json:"versions"`
+}
+
+// POST /resource/{id}/update
+// postResourceUpdate godoc
+// @Summary      Update stored resource from a new infohash
+// @Description  Queues target_id (e.g. an updated season pack re-announced under a new infohash) as the next version
+// @Description  of the stored resource. Files with the same path and size as in the resource are hashed and linked if
+// @Description  their content is unchanged, so only changed files are uploaded. The resource is kept, versions are
+// @Description  listed by GET /resource/{id}/versions. Versions which are blocklisted or taken down can't be updated.
+// @Tags         resource
+// @Accept       json
+// @Param        id       path      string         true  "Resource ID"
+// @Param        request  body      UpdateRequest  true  "New version"
+// @Success      202  {object}  Resource
+// @Failure      400  {object}  ErrorResponse
+// @Failure      404  {object}  ErrorResponse
+// @Failure      409  {object}  ErrorResponse
+// @Failure      410  {object}  ErrorResponse
+// @Failure      451  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /resource/{id}/update [post]
+func (s *Web) postResourceUpdate(c *gin.Context) {
+	db := s.pg.Get()
+	if db == nil {
+		_ = c.Error(errors.New("DB not configured"))
+		return
+	}
+	var req UpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		_ = c.Error(errors.Wrap(err, "failed to parse update request"))
+		return
+	}
+	id := c.Param("id")
+	if req.TargetID == "" || req.TargetID == id {
+		_ = c.Error(errors.New("failed to parse update request: target_id must differ from resource id"))
+		return
+	}
+	expiresAt, setExpiry, err := req.expiry(time.Now())
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	tenant := s.auth.Tenant(c)
+	var res *Resource
+	err = db.RunInTransaction(c.Request.Context(), func(tx *pg.Tx) error {
+		var err error
+		res, err = s.createOwned(c.Request.Context(), tx, tenant, req.TargetID, func() (*Resource, error) {
+			return ResourceUpdate(c.Request.Context(), tx, id, req.TargetID)
+		})
+		if err != nil || res == nil || !setExpiry {
+			return err
+		}
+		res, err = ResourceSetExpiry(c.Request.Context(), tx, req.TargetID, expiresAt)
+		return err
+	})
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if res == nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	s.abuse.Record(AbuseKindRegister, c.ClientIP(), 1)
+	c.JSON(http.StatusAccepted, gin.H{"resource": res.SetTTL(time.Now())})
+}
+
+// GET /resource/{id}/versions
+// getResourceVersions godoc
+// @Summary      List versions of resource
+// @Description  Returns resources linked to the resource by updates, from the oldest version to the newest.
+// @Tags         resource
+// @Param        id   path      string  true  "Resource ID"
+// @Success      200  {object}  ResourceVersionList
+// @Failure      404  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /resource/{id}/versions [get]
+func (s *Web) getResourceVersions(c *gin.Context) {
+	db := s.pg.Get()
+	if db == nil {
+		_ = c.Error(errors.New("DB not configured"))
+		return
+	}
+	id := c.Param("id")
+	list, err := ResourceVersions(c.Request.Context(), db, id)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if len(list) == 0 {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	now := time.Now()
+	for i := range list {
+		list[i].SetTTL(now)
+	}
+	c.JSON(http.StatusOK, &ResourceVersionList{ResourceID: id, Versions: list})
+}
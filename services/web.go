@@ -5,6 +5,8 @@ import (
 	"net"
 	"net/http"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/pkg/errors"
@@ -25,8 +27,16 @@ import (
 // @contact.email  support@webtor.io
 
 const (
-	webHostFlag = "host"
-	webPortFlag = "port"
+	webHostFlag             = "host"
+	webPortFlag             = "port"
+	webseedBlockSizeFlag    = "webseed-block-size"
+	webseedReadAheadFlag    = "webseed-read-ahead"
+	webseedHeadTimeoutFlag  = "webseed-head-timeout"
+	webseedIdleTimeoutFlag  = "webseed-idle-timeout"
+	webseedCacheSizeFlag    = "webseed-cache-size"
+	webseedPublicURLFlag    = "webseed-public-url"
+	webseedCacheControlFlag = "webseed-cache-control"
+	probeTimeoutFlag        = "probe-timeout"
 )
 
 func RegisterWebFlags(f []cli.Flag) []cli.Flag {
@@ -43,6 +53,52 @@ func RegisterWebFlags(f []cli.Flag) []cli.Flag {
 			Value:  8080,
 			EnvVar: "WEB_PORT",
 		},
+		cli.Int64Flag{
+			Name:   webseedBlockSizeFlag,
+			Usage:  "align webseed S3 range reads to blocks of this size in bytes (0 disables alignment)",
+			Value:  4 * 1024 * 1024,
+			EnvVar: "WEBSEED_BLOCK_SIZE",
+		},
+		cli.Int64Flag{
+			Name:   webseedReadAheadFlag,
+			Usage:  "number of bytes to read ahead from S3 while streaming webseed responses (0 disables read-ahead)",
+			Value:  0,
+			EnvVar: "WEBSEED_READ_AHEAD",
+		},
+		cli.DurationFlag{
+			Name:   webseedHeadTimeoutFlag,
+			Usage:  "deadline for webseed HEAD requests (0 disables)",
+			Value:  10 * time.Second,
+			EnvVar: "WEBSEED_HEAD_TIMEOUT",
+		},
+		cli.DurationFlag{
+			Name:   webseedIdleTimeoutFlag,
+			Usage:  "webseed GET is aborted if no data was transferred for this period (0 disables)",
+			Value:  time.Minute,
+			EnvVar: "WEBSEED_IDLE_TIMEOUT",
+		},
+		cli.IntFlag{
+			Name:   webseedCacheSizeFlag,
+			Usage:  "number of webseed lookups kept in memory to serve from while DB is unavailable (0 disables)",
+			Value:  100000,
+			EnvVar: "WEBSEED_CACHE_SIZE",
+		},
+		cli.StringFlag{
+			Name:   webseedPublicURLFlag,
+			Usage:  "public base URL webseed is reachable at by torrent clients, e.g. https://vault.example.com, used by web seed URLs of resources (default: scheme and host of the request)",
+			EnvVar: "WEBSEED_PUBLIC_URL",
+		},
+		cli.StringFlag{
+			Name:   webseedCacheControlFlag,
+			Usage:  "Cache-Control header of webseed responses, e.g. \"public, max-age=86400\", content class max-age takes precedence (empty omits it)",
+			EnvVar: "WEBSEED_CACHE_CONTROL",
+		},
+		cli.DurationFlag{
+			Name:   probeTimeoutFlag,
+			Usage:  "deadline for resource availability probes (0 disables)",
+			Value:  30 * time.Second,
+			EnvVar: "PROBE_TIMEOUT",
+		},
 	)
 }
 
@@ -50,20 +106,121 @@ type Web struct {
 	host string
 	port int
 	ln   net.Listener
-	pg   *cs.PG
+	pg   *PG
 	s3   *cs.S3Client
 	// bucket to read objects from (same as worker's AWS_BUCKET)
 	bucket string
+	// blockSize is the boundary S3 range reads are aligned to
+	blockSize int64
+	// readAhead is the number of bytes prefetched from S3 ahead of the client
+	readAhead int64
+	rl        *RateLimiter
+	enc       *Encryption
+	keys      *ObjectKeys
+	auth      *Auth
+	health    *Health
+	abuse     *AbuseDetector
+	// takedownPolicy is applied to takedowns which don't specify policy explicitly
+	takedownPolicy TakedownPolicy
+	// headTimeout is a deadline for metadata (HEAD) requests
+	headTimeout time.Duration
+	// idleTimeout aborts GET streaming when no progress happens for the period
+	idleTimeout time.Duration
+	// cache serves webseed lookups in degraded mode while DB is unavailable
+	cache *webseedCache
+	// lookup caches webseed lookups to save DB queries
+	lookup *LookupCache
+	// chunks caches seek-heavy chunks of video files
+	chunks *ChunkCache
+	// access throttles last_accessed_at updates
+	access *accessTracker
+	api    *Api
+	// hashAlgo is used by worker for new files (validated there), estimates dedup with it
+	hashAlgo HashAlgo
+	// probeTimeout bounds swarm availability probes
+	probeTimeout time.Duration
+	stats        statsCache
+	// stalledAfter is the default period without progress for /admin/stalled
+	stalledAfter time.Duration
+	verifier     *Verifier
+	// heartbeatInterval of instances, used to tell alive ones
+	heartbeatInterval time.Duration
+	// presignExpiry is the default lifetime of pre-signed urls, presignMaxExpiry caps requested one
+	presignExpiry    time.Duration
+	presignMaxExpiry time.Duration
+	// policies set webseed caching by content class of the file
+	policies ContentPolicies
+	// archival estimates when archived resources are served again
+	archival *Archival
+	// idempotencyTTL is how long responses of requests with Idempotency-Key are replayed
+	idempotencyTTL time.Duration
+	// replica serves webseed reads failed on the primary bucket
+	replica *Replica
+	// handler serves self-test requests through the same middleware chain as clients
+	handler atomic.Pointer[gin.Engine]
+	// notifyChannel is notified after requests queueing work, empty if LISTEN/NOTIFY is disabled
+	notifyChannel string
+	// quota limits resources owned by a tenant
+	quota *TenantQuota
+	// preview limits public preview links
+	preview *Preview
+	// usage collects webseed access stats, nil if disabled
+	usage *AccessStats
+	// webseedPublicURL is the base of web seed URLs of resources, empty takes it from the request
+	webseedPublicURL string
+	// webseedCacheControl is Cache-Control of webseed files without content class max-age, empty omits it
+	webseedCacheControl string
 }
 
-func NewWeb(c *cli.Context, pg *cs.PG, s3 *cs.S3Client) *Web {
-	return &Web{
-		host:   c.String(webHostFlag),
-		port:   c.Int(webPortFlag),
-		pg:     pg,
-		s3:     s3,
-		bucket: c.String("aws-bucket"),
+func NewWeb(c *cli.Context, pg *PG, s3 *cs.S3Client, api *Api, rl *RateLimiter, enc *Encryption, keys *ObjectKeys, replica *Replica, auth *Auth, health *Health, abuse *AbuseDetector, lookup *LookupCache, chunks *ChunkCache, usage *AccessStats) (*Web, error) {
+	policies, err := NewContentPolicies(c)
+	if err != nil {
+		return nil, err
 	}
+	archival, err := NewArchival(c)
+	if err != nil {
+		return nil, err
+	}
+	return &Web{
+		host:                c.String(webHostFlag),
+		port:                c.Int(webPortFlag),
+		pg:                  pg,
+		s3:                  s3,
+		api:                 api,
+		bucket:              c.String("aws-bucket"),
+		blockSize:           c.Int64(webseedBlockSizeFlag),
+		readAhead:           c.Int64(webseedReadAheadFlag),
+		rl:                  rl,
+		enc:                 enc,
+		keys:                keys,
+		auth:                auth,
+		health:              health,
+		abuse:               abuse,
+		takedownPolicy:      TakedownPolicy(c.String(takedownPolicyFlag)),
+		headTimeout:         c.Duration(webseedHeadTimeoutFlag),
+		idleTimeout:         c.Duration(webseedIdleTimeoutFlag),
+		cache:               newWebseedCache(c.Int(webseedCacheSizeFlag)),
+		access:              newAccessTracker(),
+		hashAlgo:            HashAlgo(c.String(hashModeFlag)),
+		probeTimeout:        c.Duration(probeTimeoutFlag),
+		stalledAfter:        c.Duration(stalledAfterFlag),
+		verifier:            NewVerifier(c, pg, s3, enc, keys),
+		heartbeatInterval:   c.Duration(instanceHeartbeatIntervalFlag),
+		presignExpiry:       c.Duration(presignExpiryFlag),
+		policies:            policies,
+		archival:            archival,
+		lookup:              lookup,
+		chunks:              chunks,
+		presignMaxExpiry:    c.Duration(presignMaxExpiryFlag),
+		idempotencyTTL:      c.Duration(idempotencyKeyTTLFlag),
+		replica:             replica,
+		notifyChannel:       c.String(notifyChannelFlag),
+		quota:               NewTenantQuota(c),
+		preview:             NewPreview(c),
+		usage:               usage,
+		webseedPublicURL:    strings.TrimSuffix(c.String(webseedPublicURLFlag), "/"),
+		webseedCacheControl: c.String(webseedCacheControlFlag),
+	}, nil
 }
 
 func (s *Web) Serve() error {
@@ -73,18 +230,105 @@ func (s *Web) Serve() error {
 	if err != nil {
 		return errors.Wrap(err, "Failed to web listen to tcp connection")
 	}
-	r := gin.Default()
+	r := gin.New()
 	r.UseRawPath = true
-	r.Use(s.errorHandler)
-	rg := r.Group("/resource")
+	r.Use(s.requestLogger, s.traceRequest, gin.Recovery(), s.auditRequest, s.errorHandler)
+	s.handler.Store(r)
+	rg := r.Group("/resource", s.invalidateLookup, s.notifyWorkers, s.ownedResource)
 
-	rg.PUT("/:id", s.putResource)
-	rg.GET("/:id", s.getResource)
-	rg.DELETE("/:id", s.deleteResource)
+	rg.POST("", s.auth.RequireScope(TokenScopeStore), s.abuseBlock, s.postResource)
+	rg.PUT("/:id", s.auth.RequireScope(TokenScopeStore), s.abuseBlock, s.idempotent, s.putResource)
+	rg.GET("/:id", s.auth.RequireScope(TokenScopeRead), s.getResource)
+	rg.PATCH("/:id", s.auth.RequireScope(TokenScopeStore), s.patchResource)
+	rg.DELETE("/:id", s.auth.RequireScope(TokenScopeDelete), s.idempotent, s.deleteResource)
+	rg.GET("/:id/operations", s.auth.RequireScope(TokenScopeRead), s.getResourceOperations)
+	rg.POST("/:id/retry", s.auth.RequireScope(TokenScopeStore), s.postResourceRetry)
+	rg.POST("/:id/abort-and-clean", s.auth.RequireScope(TokenScopeDelete), s.postResourceAbortAndClean)
+	rg.GET("/:id/file", s.auth.RequireScope(TokenScopeRead), s.getResourceFile)
+	rg.POST("/:id/file/retry", s.auth.RequireScope(TokenScopeStore), s.postResourceFileRetry)
+	rg.POST("/:id/clone", s.auth.RequireScope(TokenScopeStore), s.abuseBlock, s.postResourceClone)
+	rg.POST("/:id/update", s.auth.RequireScope(TokenScopeStore), s.abuseBlock, s.postResourceUpdate)
+	rg.GET("/:id/versions", s.auth.RequireScope(TokenScopeRead), s.getResourceVersions)
+	rg.GET("/:id/cross-seeds", s.auth.RequireScope(TokenScopeRead), s.getResourceCrossSeeds)
+	rg.GET("/:id/stats", s.auth.RequireScope(TokenScopeRead), s.getResourceStats)
+	rg.POST("/:id/restore", s.auth.RequireScope(TokenScopeDelete), s.postResourceRestore)
+	rg.POST("/:id/archive", s.auth.RequireScope(TokenScopeStore), s.postResourceArchive)
+	rg.GET("/:id/archive-contents", s.auth.RequireScope(TokenScopeRead), s.getArchiveContents)
+	rg.GET("/:id/archive-entry", s.auth.RequireScope(TokenScopeWebseed), s.abuseBlock, s.webseedRateLimit, s.getArchiveEntry)
+	rg.GET("/:id/file-url", s.auth.RequireScope(TokenScopeWebseed), s.abuseBlock, s.getFileURL)
+	rg.GET("/:id/webseed-urls", s.auth.RequireScope(TokenScopeRead), s.getWebseedURLs)
+	rg.POST("/:id/preview", s.auth.RequireScope(TokenScopeWebseed), s.abuseBlock, s.postResourcePreview)
+	rg.GET("/:id/estimate", s.auth.RequireScope(TokenScopeStore), s.getResourceEstimate)
+	rg.GET("/:id/probe", s.auth.RequireScope(TokenScopeStore), s.getResourceProbe)
+	rg.POST("/:id/verify", s.auth.RequireScope(TokenScopeStore), s.postResourceVerify)
 	// files listing endpoint is not needed per requirements
 
+	rgs := r.Group("/resources", s.notifyWorkers)
+	rgs.GET("", s.auth.RequireScope(TokenScopeRead), s.getResources)
+	rgs.GET("/events", tokenFromQuery, s.auth.RequireScope(TokenScopeRead), s.getResourceEvents)
+	rgs.POST("/batch", s.auth.RequireScope(TokenScopeStore), s.abuseBlock, s.postResourcesBatch)
+	rgs.POST("/bulk", s.auth.RequireScope(TokenScopeStore), s.requireUnscoped, s.postBulkOperation)
+	rgs.GET("/bulk/:id", s.auth.RequireScope(TokenScopeRead), s.requireUnscoped, s.getBulkOperation)
+	rgs.POST("/purge", s.auth.RequireAdmin, s.postResourcesPurge)
+
+	cg := r.Group("/collection")
+	cg.GET("/:id", s.auth.RequireScope(TokenScopeRead), s.getCollection)
+	cg.DELETE("/:id", s.auth.RequireScope(TokenScopeStore), s.deleteCollection)
+	cg.PUT("/:id/resource/:resource_id", s.auth.RequireScope(TokenScopeStore), s.putCollectionResource)
+	cg.DELETE("/:id/resource/:resource_id", s.auth.RequireScope(TokenScopeStore), s.deleteCollectionResource)
+	cg.GET("/:id/export", s.auth.RequireScope(TokenScopeWebseed), s.abuseBlock, s.webseedRateLimit, s.getCollectionExport)
+	r.GET("/collections", s.auth.RequireScope(TokenScopeRead), s.getCollections)
+	r.POST("/collections", s.auth.RequireScope(TokenScopeStore), s.postCollection)
+	r.POST("/import", s.notifyWorkers, s.auth.RequireScope(TokenScopeStore), s.requireUnscoped, s.abuseBlock, s.postImport)
+	r.GET("/tenant/usage", s.auth.RequireScope(TokenScopeRead), s.getTenantUsage)
+
+	r.GET("/operations", s.auth.RequireScope(TokenScopeRead), s.requireUnscoped, s.getOperations)
+	r.GET("/operations/daily", s.auth.RequireScope(TokenScopeRead), s.requireUnscoped, s.getOperationStatsDaily)
+	r.GET("/stats", s.auth.RequireScope(TokenScopeRead), s.requireUnscoped, s.getStats)
+	r.GET("/audit", s.auth.RequireAdmin, s.getAudit)
+
+	ag := r.Group("/admin", s.auth.RequireAdmin)
+	ag.PUT("/resource/:id/legal-hold", s.putLegalHold)
+	ag.DELETE("/resource/:id/legal-hold", s.deleteLegalHold)
+	ag.POST("/resource/:id/self-test", s.postResourceSelfTest)
+	ag.POST("/resource/:id/cdn-audit", s.postResourceCDNAudit)
+	ag.POST("/takedown/:id", s.invalidateLookup, s.postTakedown)
+	ag.GET("/takedown/:id", s.getTakedowns)
+	ag.GET("/stalled", s.getStalled)
+	ag.GET("/instances", s.getInstances)
+	ag.GET("/replica", s.getReplica)
+	ag.GET("/tenants", s.getTenants)
+	ag.GET("/blocklist", s.getBlocklist)
+	ag.PUT("/blocklist/:infohash", s.putBlocklist)
+	ag.DELETE("/blocklist/:infohash", s.deleteBlocklist)
+	ag.POST("/tokens", s.postToken)
+	ag.GET("/tokens", s.getTokens)
+	ag.DELETE("/tokens/:id", s.deleteToken)
+	ag.POST("/bulk", s.postAdminBulkOperation)
+	ag.GET("/bulk", s.getBulkOperations)
+	ag.GET("/bulk/:id", s.getBulkOperation)
+	ag.POST("/bulk/:id/cancel", s.postBulkOperationCancel)
+
 	// WebSeed: /webseed/{id}/{path}
-	r.Any("/webseed/:id/*path", s.webSeed)
+	r.Any("/webseed/:id", s.auth.RequireScope(TokenScopeWebseed), s.abuseBlock, s.ownedResource, s.webseedRateLimit, s.webSeed)
+	r.Any("/webseed/:id/*path", s.auth.RequireScope(TokenScopeWebseed), s.abuseBlock, s.ownedResource, s.webseedRateLimit, s.webSeed)
+
+	// Preview: /preview/{token}
+	r.GET("/preview/:token", s.abuseBlock, s.previewRateLimit, s.webseedRateLimit, s.getPreview)
+	r.HEAD("/preview/:token", s.abuseBlock, s.previewRateLimit, s.webseedRateLimit, s.getPreview)
+
+	// WebDAV: /dav/{id}/{path}
+	for _, p := range []string{"/dav/:id", "/dav/:id/*path"} {
+		r.Any(p, s.auth.RequireScope(TokenScopeWebseed), s.abuseBlock, s.ownedResource, s.webseedRateLimit, s.dav)
+		r.Handle("PROPFIND", p, s.auth.RequireScope(TokenScopeWebseed), s.abuseBlock, s.ownedResource, s.webseedRateLimit, s.dav)
+	}
+
+	// Dashboard: /ui
+	r.GET("/ui", func(c *gin.Context) { c.Redirect(http.StatusMovedPermanently, "/ui/") })
+	r.GET("/ui/*path", uiHandler())
+
+	r.GET("/liveness", s.getLiveness)
+	r.GET("/readiness", s.getReadiness)
 
 	// Swagger UI
 	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler, ginSwagger.InstanceName("vault")))
@@ -100,11 +344,28 @@ func (s *Web) errorHandler(c *gin.Context) {
 		return
 	}
 	err := c.Errors[0]
-	log.Error(err)
+	logger(c.Request.Context()).Error(err)
 
 	status := http.StatusInternalServerError
 
-	if strings.Contains(err.Error(), "failed to parse") {
+	if errors.Is(err, ErrLegalHold) {
+		status = http.StatusLocked
+	} else if errors.Is(err, ErrTakenDown) {
+		status = http.StatusGone
+	} else if errors.Is(err, ErrBlocked) {
+		status = http.StatusUnavailableForLegalReasons
+	} else if errors.Is(err, ErrNotRetryable) || errors.Is(err, ErrNotRestorable) || errors.Is(err, ErrNotArchivable) ||
+		errors.Is(err, ErrNotClonable) || errors.Is(err, ErrNotAbortable) || errors.Is(err, ErrResourceExists) {
+		status = http.StatusConflict
+	} else if errors.Is(err, ErrQuotaExceeded) {
+		status = http.StatusForbidden
+	} else if errors.Is(err, ErrPreconditionFailed) {
+		status = http.StatusPreconditionFailed
+	} else if errors.Is(err, ErrUnavailable) {
+		status = http.StatusFailedDependency
+	} else if errors.Is(err, ErrPresignUnavailable) || errors.Is(err, ErrPresignChunked) {
+		status = http.StatusNotImplemented
+	} else if strings.Contains(err.Error(), "failed to parse") {
 		status = http.StatusBadRequest
 	} else if strings.Contains(err.Error(), "forbidden") {
 		status = http.StatusForbidden
@@ -5,7 +5,10 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	awss3 "github.com/aws/aws-sdk-go/service/s3"
@@ -15,16 +18,37 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+const degradedHeader = "X-Vault-Degraded"
+
 // WebSeed handler — GET/HEAD /webseed/{id}/{path}
 // @Summary      Webseed proxy
 // @Description  Proxies stored files from S3 with Range support. Returns 404 if resource is not fully stored or file not found.
+// @Description  Multiple ranges are served as multipart/byteranges, unsatisfiable ranges return 416.
+// @Description  Paths follow BEP 19 multi-file layout ({name}/{path}), so /webseed/{id}/ can be used as a torrent url-list entry.
+// @Description  While DB is unavailable, cached lookups are served with X-Vault-Degraded: true header, others return 503.
+// @Description  Directory paths return an HTML index or JSON (Accept: application/json or ?format=json).
+// @Description  ?download=1 or ?disposition=attachment makes browsers save the file under its original name,
+// @Description  ?disposition=inline displays it with media type of the file (sandboxed by Content-Security-Policy).
+// @Description  If-None-Match and If-Modified-Since are evaluated against ETag and Last-Modified of the stored object,
+// @Description  matching GET and HEAD requests return 304. Cache-Control is set by --webseed-cache-control unless the
+// @Description  content class of the file has its own max-age.
+// @Description  Archived resources return 503 with Retry-After estimating when they are served again once restored.
 // @Tags         webseed
-// @Param        id    path      string  true  "Resource ID"
-// @Param        path  path      string  true  "Path inside resource"
+// @Param        id           path      string  true   "Resource ID"
+// @Param        path         path      string  true   "Path inside resource"
+// @Param        download     query     string  false  "1 is a shortcut for disposition=attachment"
+// @Param        disposition  query     string  false  "Content-Disposition type: inline or attachment (with file name)"
+// @Param        If-None-Match      header  string  false  "ETag of the cached copy"
+// @Param        If-Modified-Since  header  string  false  "Last-Modified of the cached copy"
 // @Produce      application/octet-stream
 // @Success      200
 // @Success      206
+// @Success      200  {object}  IndexResponse
+// @Success      304
 // @Failure      404  {object}  ErrorResponse
+// @Failure      410  {object}  GoneResponse
+// @Failure      416
+// @Failure      503  {object}  ErrorResponse
 // @Failure      500  {object}  ErrorResponse
 // @Router       /webseed/{id}/{path} [get]
 // @Router       /webseed/{id}/{path} [head]
@@ -32,44 +56,116 @@ func (s *Web) webSeed(c *gin.Context) {
 	if !s.validateWebSeedDependencies(c) {
 		return
 	}
+	if c.Request.Method == http.MethodHead && s.headTimeout > 0 {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), s.headTimeout)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+	}
 	id := c.Param("id")
-	p := c.Param("path")
-
-	db := s.pg.Get()
-	res, err := ResourceGetByID(c.Request.Context(), db, id)
-
+	// paths are stored normalized (see NormalizePath)
+	p := NormalizePath(c.Param("path"))
+	disposition, err := parseDisposition(c)
 	if err != nil {
 		_ = c.Error(err)
 		return
 	}
-	if res == nil || res.Status != StatusStored {
+
+	db := s.pg.Read()
+	st, err := s.lookupResourceState(c, db, id)
+	if err != nil {
+		s.webseedLookupError(c, err)
+		return
+	}
+	if st.takedown {
+		c.AbortWithStatusJSON(http.StatusGone, &GoneResponse{Error: ErrTakenDown.Error(), ReasonCode: st.reasonCode})
+		return
+	}
+	if st.archived {
+		msg := "resource is archived, restore it with POST /resource/{id}/restore"
+		if st.restoring {
+			msg = "resource is being restored"
+		}
+		c.Header("Retry-After", strconv.Itoa(int(st.retryAfter.Seconds())))
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, &ErrorResponse{Error: msg})
+		return
+	}
+	if !st.stored {
 		c.Status(http.StatusNotFound)
 		return
 	}
+	if c.Writer.Header().Get(degradedHeader) == "" {
+		s.touchResource(id)
+	}
 
-	if p == "" || p == "/" {
-		c.Status(http.StatusOK)
-		return
+	// BEP 19: url without trailing slash of a single-file torrent is the file itself
+	if p == "" {
+		rfs, err := ResourceFileListByPrefix(c.Request.Context(), db, id, "/")
+		if err != nil {
+			s.webseedLookupError(c, err)
+			return
+		}
+		if len(rfs) != 1 {
+			s.serveIndex(c, db, id, "/")
+			return
+		}
+		p = rfs[0].Path
 	}
 
-	hash, ok, err := s.lookupFileHash(c.Request.Context(), db, id, p)
+	f, err := s.lookupFile(c, db, id, p)
 	if err != nil {
-		_ = c.Error(err)
+		s.webseedLookupError(c, err)
 		return
 	}
-	if !ok {
-		c.Status(http.StatusNotFound)
+	if f == nil {
+		s.serveIndex(c, db, id, p)
 		return
 	}
+	conditional(c)
+	if disposition != "" {
+		c.Writer = &dispositionWriter{ResponseWriter: c.Writer, disposition: disposition, path: p}
+	}
 
 	rangeHeader := c.GetHeader("Range")
+	if cc := s.cacheControl(f.class); cc != "" {
+		c.Header("Cache-Control", cc)
+	}
+	if f.size >= 0 {
+		ranges, err := parseRanges(rangeHeader, f.size)
+		if err != nil {
+			c.Header("Content-Range", fmt.Sprintf("bytes */%d", f.size))
+			c.Status(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		if len(ranges) > 1 {
+			s.serveMultiRange(c, f, ranges, id, p)
+			return
+		}
+		// S3 only gets validated single ranges
+		rangeHeader = ""
+		if len(ranges) == 1 {
+			rangeHeader = ranges[0].String()
+		}
+	}
+	if f.chunked {
+		s.serveChunked(c, f, rangeHeader, id, p)
+		return
+	}
 	if c.Request.Method == http.MethodHead {
-		s.handleHeadRequest(c, hash, rangeHeader)
+		s.handleHeadRequest(c, s.bucketOf(f), f.hash, rangeHeader)
 	} else {
-		s.handleGetRequest(c, hash, rangeHeader, id, p)
+		s.handleGetRequest(c, f, rangeHeader, id, p)
 	}
 }
 
+// cacheControl returns Cache-Control header of webseed responses for files of the content class,
+// the content class policy takes precedence over --webseed-cache-control.
+func (s *Web) cacheControl(cc ContentClass) string {
+	if v := s.policies.CacheControl(cc); v != "" {
+		return v
+	}
+	return s.webseedCacheControl
+}
+
 func (s *Web) validateWebSeedDependencies(c *gin.Context) bool {
 	if s.pg.Get() == nil {
 		_ = c.Error(errors.New("DB not configured"))
@@ -86,51 +182,223 @@ func (s *Web) validateWebSeedDependencies(c *gin.Context) bool {
 	return true
 }
 
-func (s *Web) lookupFileHash(ctx context.Context, db *pg.DB, id, path string) (string, bool, error) {
-	rf := &ResourceFile{ResourceID: id, Path: path}
-	if err := db.Model(rf).Context(ctx).Where("resource_id = ? and path = ?", id, path).Select(); err != nil {
+// lookupResourceState returns takedown and store state of the resource,
+// falling back to the cache if DB is unavailable.
+func (s *Web) lookupResourceState(c *gin.Context, db *pg.DB, id string) (webseedResourceState, error) {
+	ctx := c.Request.Context()
+	if st, ok := s.lookup.getResource(ctx, id); ok {
+		return st, nil
+	}
+	st := webseedResourceState{}
+	td, err := TakedownGetLatest(ctx, db, id)
+	if err == nil && td == nil {
+		var res *Resource
+		res, err = ResourceGetByID(ctx, db, id)
+		if err == nil {
+			st.stored = res != nil && res.Status == StatusStored
+			if res != nil && (res.Status == StatusArchived || res.Status == StatusRestoring) {
+				st.archived = true
+				st.restoring = res.Status == StatusRestoring
+				st.retryAfter = s.archival.retryAfter(res, time.Now())
+			}
+		}
+	} else if err == nil {
+		st.takedown = true
+		st.reasonCode = td.ReasonCode
+	}
+	if err != nil {
+		if cst, ok := s.cache.getResource(id); ok && isDBUnavailable(err) {
+			s.markDegraded(c, err)
+			return cst, nil
+		}
+		return st, err
+	}
+	s.cache.setResource(id, st)
+	s.lookup.setResource(ctx, id, st)
+	return st, nil
+}
+
+// webseedFile is a stored file resolved from a webseed path. Size is -1 if unknown.
+type webseedFile struct {
+	hash  string
+	size  int64
+	class ContentClass
+	// chunked files are assembled from chunks instead of read from their object
+	chunked bool
+	// bucket of the object, empty for aws-bucket
+	bucket string
+}
+
+// bucketOf returns the bucket object of the file is read from.
+func (s *Web) bucketOf(f *webseedFile) string {
+	if f.bucket != "" {
+		return f.bucket
+	}
+	return s.bucket
+}
+
+// lookupFile returns the file stored at path or nil, falling back to
+// the cache if DB is unavailable.
+func (s *Web) lookupFile(c *gin.Context, db *pg.DB, id, path string) (*webseedFile, error) {
+	if f, ok := s.lookup.getFile(c.Request.Context(), id, path); ok {
+		return &f, nil
+	}
+	rf := &ResourceFile{}
+	err := db.Model(rf).Context(c.Request.Context()).
+		Relation("File").
+		Where("resource_file.resource_id = ? and resource_file.path = ?", id, path).
+		Select()
+	if err != nil {
 		if errors.Is(err, pg.ErrNoRows) {
-			return "", false, nil
+			return nil, nil
+		}
+		if f, ok := s.cache.getFile(id, path); ok && isDBUnavailable(err) {
+			s.markDegraded(c, err)
+			return &f, nil
+		}
+		return nil, err
+	}
+	f := webseedFile{hash: rf.FileHash, size: -1, class: DetectContentClass(path)}
+	if rf.File != nil {
+		f.size = rf.File.TotalSize
+		f.chunked = rf.File.Chunked
+		f.bucket = rf.File.bucketOr("")
+		if rf.File.ContentClass != "" {
+			f.class = rf.File.ContentClass
 		}
-		return "", false, err
 	}
-	return rf.FileHash, true, nil
+	s.cache.setFile(id, path, f)
+	s.lookup.setFile(c.Request.Context(), id, path, f)
+	return &f, nil
+}
+
+// invalidateLookup is a gin middleware dropping cached lookups of the resource once
+// a request changing it succeeds.
+func (s *Web) invalidateLookup(c *gin.Context) {
+	c.Next()
+	if c.Request.Method == http.MethodGet || c.Writer.Status() >= http.StatusBadRequest {
+		return
+	}
+	s.lookup.Invalidate(c.Request.Context(), c.Param("id"))
+}
+
+func (s *Web) markDegraded(c *gin.Context, err error) {
+	if c.Writer.Header().Get(degradedHeader) == "" {
+		logger(c.Request.Context()).WithError(err).Warn("DB is unavailable, serving webseed from cache")
+		promWebseedDegradedRequests.Inc()
+	}
+	c.Header(degradedHeader, "true")
 }
 
-func (s *Web) handleHeadRequest(c *gin.Context, hash, rangeHeader string) {
+// webseedLookupError responds with 503 instead of 500 when DB is unavailable
+// and the lookup is not cached.
+func (s *Web) webseedLookupError(c *gin.Context, err error) {
+	if isDBUnavailable(err) {
+		logger(c.Request.Context()).WithError(err).Warn("DB is unavailable")
+		c.Header("Retry-After", "5")
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, &ErrorResponse{Error: "database is unavailable"})
+		return
+	}
+	_ = c.Error(err)
+}
+
+func (s *Web) handleHeadRequest(c *gin.Context, bucket, hash, rangeHeader string) {
 	s3cl := s.s3.Get()
 	input := &awss3.HeadObjectInput{
-		Bucket: aws.String(s.bucket),
-		Key:    aws.String(hash),
-		Range:  s.buildRangePointer(rangeHeader),
+		Bucket: aws.String(bucket),
+		Key:    aws.String(s.keys.Key(hash)),
+	}
+	// ranges of client-side encrypted objects are resolved against plaintext size below
+	if !s.enc.ClientSide() {
+		input.Range = s.buildRangePointer(rangeHeader)
 	}
 	req, out := s3cl.HeadObjectRequest(input)
-	if err := req.Send(); err != nil {
-		if s.isS3NotFoundError(err) {
+	req.SetContext(c.Request.Context())
+	status := http.StatusOK
+	err := req.Send()
+	if err == nil && req.HTTPResponse != nil {
+		status = req.HTTPResponse.StatusCode
+	}
+	if err != nil {
+		out, status, err = s.headReplicaObject(c.Request.Context(), input, err)
+	}
+	if err != nil {
+		if isS3NotFoundError(err) {
 			c.Status(http.StatusNotFound)
 			return
 		}
+		if errors.Is(c.Request.Context().Err(), context.DeadlineExceeded) {
+			c.Status(http.StatusGatewayTimeout)
+			return
+		}
 		_ = c.Error(err)
 		return
 	}
 
+	eo, err := s.enc.Object(out.Metadata)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if eo != nil {
+		start, end, partial, ok := resolveRange(rangeHeader, eo.plainSize)
+		if !ok {
+			c.Header("Content-Range", fmt.Sprintf("bytes */%d", eo.plainSize))
+			c.Status(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		s.setEncryptedHeaders(c, out, eo, start, end, partial)
+		if partial {
+			c.Status(http.StatusPartialContent)
+		} else {
+			c.Status(http.StatusOK)
+		}
+		return
+	}
+
 	s.setHeadResponseHeaders(c, out)
-	status := http.StatusOK
-	if req.HTTPResponse != nil && req.HTTPResponse.StatusCode == http.StatusPartialContent {
-		status = http.StatusPartialContent
+	if status != http.StatusPartialContent {
+		status = http.StatusOK
 	}
 	c.Status(status)
 }
 
-func (s *Web) handleGetRequest(c *gin.Context, hash, rangeHeader, id, path string) {
-	s3cl := s.s3.Get()
-	out, err := s3cl.GetObjectWithContext(c.Request.Context(), &awss3.GetObjectInput{
-		Bucket: aws.String(s.bucket),
-		Key:    aws.String(hash),
-		Range:  s.buildRangePointer(rangeHeader),
-	})
+func (s *Web) handleGetRequest(c *gin.Context, f *webseedFile, rangeHeader, id, path string) {
+	bucket, hash := s.bucketOf(f), f.hash
+	// S3 read is bound to the client request, so it's dropped as soon as the client goes away
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+	touch := s.watchIdle(c, cancel)
+	defer touch(false)
+
+	if s.serveChunks(ctx, cancel, touch, c, f, rangeHeader, id, path) {
+		return
+	}
+
+	if s.enc.ClientSide() {
+		eo, head, err := s.headEncryptedObject(ctx, bucket, hash)
+		if err != nil {
+			if isS3NotFoundError(err) {
+				c.Status(http.StatusNotFound)
+				return
+			}
+			_ = c.Error(err)
+			return
+		}
+		if eo != nil {
+			s.serveEncrypted(ctx, cancel, touch, c, eo, head, bucket, hash, rangeHeader, id, path)
+			return
+		}
+	}
+
+	s3Range := rangeHeader
+	br, aligned := s.alignRange(rangeHeader)
+	if aligned != nil {
+		s3Range = aligned.String()
+	}
+	out, err := s.getObject(ctx, touch, bucket, hash, s3Range)
 	if err != nil {
-		if s.isS3NotFoundError(err) {
+		if isS3NotFoundError(err) {
 			c.Status(http.StatusNotFound)
 			return
 		}
@@ -139,6 +407,11 @@ func (s *Web) handleGetRequest(c *gin.Context, hash, rangeHeader, id, path strin
 	}
 	defer func() { _ = out.Body.Close() }()
 
+	if aligned != nil {
+		s.serveAlignedRange(ctx, cancel, c, out, br, aligned, id, path)
+		return
+	}
+
 	s.setGetResponseHeaders(c, out)
 	status := http.StatusOK
 	if rangeHeader != "" && out.ContentRange != nil {
@@ -146,9 +419,313 @@ func (s *Web) handleGetRequest(c *gin.Context, hash, rangeHeader, id, path strin
 	}
 	c.Status(status)
 
-	if _, err := io.Copy(c.Writer, out.Body); err != nil {
-		log.WithError(err).WithField("id", id).WithField("path", path).Warn("webseed stream error")
+	s.streamToClient(ctx, cancel, c, out.Body, id, path)
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// getObject requests an object from S3 and reports every read of its body to the idle watcher.
+func (s *Web) getObject(ctx context.Context, touch func(bool), bucket, hash, rng string) (*awss3.GetObjectOutput, error) {
+	in := &awss3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(s.keys.Key(hash)),
+		Range:  s.buildRangePointer(rng),
+	}
+	out, err := s.s3.Get().GetObjectWithContext(ctx, in)
+	if err != nil {
+		out, err = s.getReplicaObject(ctx, in, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	touch(true)
+	out.Body = &readCloser{
+		Reader: &progressReader{
+			r: out.Body,
+			onRead: func(n int) error {
+				touch(true)
+				return nil
+			},
+		},
+		Closer: out.Body,
+	}
+	return out, nil
+}
+
+// headEncryptedObject loads object metadata and returns decryption parameters
+// if the object was encrypted client-side.
+func (s *Web) headEncryptedObject(ctx context.Context, bucket, hash string) (*encryptedObject, *awss3.HeadObjectOutput, error) {
+	in := &awss3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(s.keys.Key(hash)),
+	}
+	head, err := s.s3.Get().HeadObjectWithContext(ctx, in)
+	if err != nil {
+		head, _, err = s.headReplicaObject(ctx, in, err)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	eo, err := s.enc.Object(head.Metadata)
+	if err != nil {
+		return nil, nil, err
+	}
+	return eo, head, nil
+}
+
+// serveEncrypted fetches segments covering requested plaintext range and streams decrypted content.
+func (s *Web) serveEncrypted(ctx context.Context, cancel context.CancelFunc, touch func(bool), c *gin.Context, eo *encryptedObject, head *awss3.HeadObjectOutput, bucket, hash, rangeHeader, id, path string) {
+	start, end, partial, ok := resolveRange(rangeHeader, eo.plainSize)
+	if !ok {
+		c.Header("Content-Range", fmt.Sprintf("bytes */%d", eo.plainSize))
+		c.Status(http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+	s.setEncryptedHeaders(c, head, eo, start, end, partial)
+	if eo.plainSize == 0 {
+		c.Status(http.StatusOK)
+		return
+	}
+	out, err := s.getObject(ctx, touch, bucket, hash, eo.CipherRange(start, end).String())
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	defer func() { _ = out.Body.Close() }()
+	if partial {
+		c.Status(http.StatusPartialContent)
+	} else {
+		c.Status(http.StatusOK)
+	}
+	s.streamToClient(ctx, cancel, c, eo.Reader(out.Body, start, end), id, path)
+}
+
+func (s *Web) setEncryptedHeaders(c *gin.Context, head *awss3.HeadObjectOutput, eo *encryptedObject, start, end int64, partial bool) {
+	c.Header("Accept-Ranges", "bytes")
+	c.Header("Content-Type", "application/octet-stream")
+	if head.ETag != nil {
+		c.Header("ETag", *head.ETag)
+	}
+	if head.LastModified != nil {
+		c.Header("Last-Modified", head.LastModified.UTC().Format(http.TimeFormat))
+	}
+	c.Header("Content-Length", strconv.FormatInt(end-start+1, 10))
+	if partial {
+		c.Header("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, eo.plainSize))
+	}
+}
+
+// resolveRange resolves a single range header against content size. Empty or multi-range headers
+// resolve to the whole content. ok is false when the range is not satisfiable.
+func resolveRange(h string, size int64) (start, end int64, partial, ok bool) {
+	if h == "" || strings.Contains(h, ",") {
+		return 0, size - 1, false, true
+	}
+	spec, found := strings.CutPrefix(strings.TrimSpace(h), "bytes=")
+	if !found {
+		return 0, size - 1, false, true
+	}
+	if n, cut := strings.CutPrefix(spec, "-"); cut {
+		suffix, err := strconv.ParseInt(n, 10, 64)
+		if err != nil || suffix <= 0 || size == 0 {
+			return 0, 0, false, false
+		}
+		if suffix > size {
+			suffix = size
+		}
+		return size - suffix, size - 1, true, true
+	}
+	br, parsed := parseSingleRange(h)
+	if !parsed || br.start >= size {
+		return 0, 0, false, false
+	}
+	end = br.end
+	if end == -1 || end >= size {
+		end = size - 1
+	}
+	return br.start, end, true, true
+}
+
+// watchIdle cancels streaming if there was no progress during idle timeout. Returned touch function
+// must be called with true on every progress and with false once streaming is finished.
+func (s *Web) watchIdle(c *gin.Context, cancel context.CancelFunc) (touch func(active bool)) {
+	if s.idleTimeout <= 0 {
+		return func(bool) {}
+	}
+	rc := http.NewResponseController(c.Writer)
+	t := time.AfterFunc(s.idleTimeout, cancel)
+	return func(active bool) {
+		if !active {
+			t.Stop()
+			_ = rc.SetWriteDeadline(time.Time{})
+			return
+		}
+		t.Reset(s.idleTimeout)
+		// writes to a stalled client are bounded by the same idle period
+		_ = rc.SetWriteDeadline(time.Now().Add(s.idleTimeout))
+	}
+}
+
+// ctxReader fails reads once ctx is done, so copying stops right after the client disconnects.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *ctxReader) Read(b []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(b)
+}
+
+// streamToClient copies r to the client. If the client disconnects the S3 read is cancelled immediately
+// and the transfer is accounted as aborted.
+func (s *Web) streamToClient(ctx context.Context, cancel context.CancelFunc, c *gin.Context, r io.Reader, id, path string) {
+	if s.readAhead > 0 {
+		r = newReadAheadReader(ctx, r, s.readAhead)
+	}
+	if s.rl != nil {
+		r = s.rl.Reader(ctx, c.ClientIP(), r)
+	}
+	if s.abuse != nil {
+		ip := c.ClientIP()
+		r = &progressReader{r: r, onRead: func(n int) error {
+			s.abuse.Record(AbuseKindDownload, ip, int64(n))
+			return nil
+		}}
+	}
+	// conditional requests answered with 304 get no body
+	if c.Writer.Status() == http.StatusNotModified {
+		return
+	}
+	n, err := io.Copy(c.Writer, &ctxReader{ctx: ctx, r: r})
+	promWebseedBytesServed.Add(float64(n))
+	s.usage.Record(id, path, n)
+	if err == nil {
+		return
+	}
+	if ctx.Err() != nil || isClientGoneError(err) {
+		cancel()
+		promWebseedAbortedTransfers.Inc()
+		promWebseedAbortedBytes.Add(float64(n))
+		log.WithFields(log.Fields{"id": id, "path": path, "served": n}).Debug("webseed transfer aborted by client")
+		return
+	}
+	log.WithError(err).WithField("id", id).WithField("path", path).Warn("webseed stream error")
+}
+
+func isClientGoneError(err error) bool {
+	return errors.Is(err, context.Canceled) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, syscall.ECONNRESET)
+}
+
+// byteRange is a single "bytes=start-end" range. End is -1 for open-ended ranges.
+type byteRange struct {
+	start int64
+	end   int64
+}
+
+func (r *byteRange) String() string {
+	if r.end == -1 {
+		return fmt.Sprintf("bytes=%d-", r.start)
+	}
+	return fmt.Sprintf("bytes=%d-%d", r.start, r.end)
+}
+
+// parseSingleRange parses "bytes=start-" and "bytes=start-end" headers.
+// Suffix and multi-range headers are not handled and reported as not ok.
+func parseSingleRange(h string) (*byteRange, bool) {
+	spec, ok := strings.CutPrefix(strings.TrimSpace(h), "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return nil, false
+	}
+	startStr, endStr, ok := strings.Cut(spec, "-")
+	if !ok || startStr == "" {
+		return nil, false
+	}
+	start, err := strconv.ParseInt(strings.TrimSpace(startStr), 10, 64)
+	if err != nil || start < 0 {
+		return nil, false
+	}
+	end := int64(-1)
+	if endStr = strings.TrimSpace(endStr); endStr != "" {
+		end, err = strconv.ParseInt(endStr, 10, 64)
+		if err != nil || end < start {
+			return nil, false
+		}
+	}
+	return &byteRange{start: start, end: end}, true
+}
+
+// alignRange expands the requested range to block boundaries so that S3 (and any cache in front of it)
+// sees a small set of repeating ranges. Returns nil aligned range if alignment is disabled or not applicable.
+func (s *Web) alignRange(rangeHeader string) (requested *byteRange, aligned *byteRange) {
+	if s.blockSize <= 0 || rangeHeader == "" {
+		return nil, nil
+	}
+	br, ok := parseSingleRange(rangeHeader)
+	if !ok {
+		return nil, nil
+	}
+	a := &byteRange{start: br.start / s.blockSize * s.blockSize, end: -1}
+	if br.end != -1 {
+		a.end = (br.end/s.blockSize+1)*s.blockSize - 1
+	}
+	return br, a
+}
+
+// parseContentRangeTotal extracts the complete length from a "bytes start-end/total" Content-Range value.
+func parseContentRangeTotal(cr string) (int64, bool) {
+	_, totalStr, ok := strings.Cut(cr, "/")
+	if !ok || totalStr == "*" {
+		return 0, false
+	}
+	total, err := strconv.ParseInt(totalStr, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return total, true
+}
+
+// serveAlignedRange writes the client's exact range out of the aligned block returned by S3.
+func (s *Web) serveAlignedRange(ctx context.Context, cancel context.CancelFunc, c *gin.Context, out *awss3.GetObjectOutput, br, aligned *byteRange, id, path string) {
+	if out.ContentRange == nil {
+		_ = c.Error(errors.New("no content range in aligned S3 response"))
+		return
+	}
+	total, ok := parseContentRangeTotal(*out.ContentRange)
+	if !ok {
+		_ = c.Error(errors.Errorf("failed to parse content range %v", *out.ContentRange))
+		return
+	}
+	if br.start >= total {
+		c.Header("Content-Range", fmt.Sprintf("bytes */%d", total))
+		c.Status(http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+	end := br.end
+	if end == -1 || end >= total {
+		end = total - 1
+	}
+	length := end - br.start + 1
+
+	s.setGetResponseHeaders(c, out)
+	c.Header("Content-Length", strconv.FormatInt(length, 10))
+	c.Header("Content-Range", fmt.Sprintf("bytes %d-%d/%d", br.start, end, total))
+	c.Status(http.StatusPartialContent)
+
+	if _, err := io.CopyN(io.Discard, &ctxReader{ctx: ctx, r: out.Body}, br.start-aligned.start); err != nil {
+		if ctx.Err() == nil {
+			log.WithError(err).WithField("id", id).WithField("path", path).Warn("webseed stream error")
+		}
+		return
 	}
+	s.streamToClient(ctx, cancel, c, io.LimitReader(out.Body, length), id, path)
 }
 
 func (s *Web) buildRangePointer(rangeHeader string) *string {
@@ -158,7 +735,7 @@ func (s *Web) buildRangePointer(rangeHeader string) *string {
 	return nil
 }
 
-func (s *Web) isS3NotFoundError(err error) bool {
+func isS3NotFoundError(err error) bool {
 	return strings.Contains(err.Error(), awss3.ErrCodeNoSuchKey) ||
 		strings.Contains(strings.ToLower(err.Error()), "not found")
 }
@@ -0,0 +1,82 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+// webseedCache keeps results of recent successful webseed lookups, so
+// webseed can keep serving already known files in degraded mode while
+// postgres is briefly unavailable.
+type webseedCache struct {
+	mux       sync.RWMutex
+	size      int
+	resources map[string]webseedResourceState
+	files     map[string]webseedFile
+}
+
+type webseedResourceState struct {
+	stored     bool
+	takedown   bool
+	reasonCode string
+	// archived is set for archived and restoring resources, they are served again in about retryAfter
+	archived   bool
+	restoring  bool
+	retryAfter time.Duration
+}
+
+func newWebseedCache(size int) *webseedCache {
+	if size <= 0 {
+		return nil
+	}
+	return &webseedCache{
+		size:      size,
+		resources: map[string]webseedResourceState{},
+		files:     map[string]webseedFile{},
+	}
+}
+
+func (s *webseedCache) setResource(id string, st webseedResourceState) {
+	if s == nil {
+		return
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if _, ok := s.resources[id]; !ok && len(s.resources) >= s.size {
+		s.resources = map[string]webseedResourceState{}
+	}
+	s.resources[id] = st
+}
+
+func (s *webseedCache) getResource(id string) (webseedResourceState, bool) {
+	if s == nil {
+		return webseedResourceState{}, false
+	}
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	st, ok := s.resources[id]
+	return st, ok
+}
+
+func (s *webseedCache) setFile(id, path string, f webseedFile) {
+	if s == nil {
+		return
+	}
+	key := id + "\x00" + path
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if _, ok := s.files[key]; !ok && len(s.files) >= s.size {
+		s.files = map[string]webseedFile{}
+	}
+	s.files[key] = f
+}
+
+func (s *webseedCache) getFile(id, path string) (webseedFile, bool) {
+	if s == nil {
+		return webseedFile{}, false
+	}
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	f, ok := s.files[id+"\x00"+path]
+	return f, ok
+}
@@ -0,0 +1,71 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// chunkedReader reads bytes [start, end] of the chunked file and reports every read to the idle watcher.
+func (s *Web) chunkedReader(ctx context.Context, touch func(bool), chunks []FileChunk, start, end int64) io.ReadCloser {
+	r := newChunkedReader(ctx, s.s3.Get(), s.bucket, s.keys, chunks, start, end)
+	return &readCloser{
+		Reader: &progressReader{
+			r: r,
+			onRead: func(n int) error {
+				touch(true)
+				return nil
+			},
+		},
+		Closer: r,
+	}
+}
+
+// serveChunked serves a single range of a file stored in chunking mode, assembled from its chunks.
+func (s *Web) serveChunked(c *gin.Context, f *webseedFile, rangeHeader, id, p string) {
+	start, end, partial, ok := resolveRange(rangeHeader, f.size)
+	if !ok {
+		c.Header("Content-Range", fmt.Sprintf("bytes */%d", f.size))
+		c.Status(http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+	var chunks []FileChunk
+	if c.Request.Method != http.MethodHead && f.size > 0 {
+		var err error
+		if chunks, err = FileChunkList(c.Request.Context(), s.pg.Read(), f.hash); err != nil {
+			s.webseedLookupError(c, err)
+			return
+		}
+		if len(chunks) == 0 {
+			_ = c.Error(errors.Errorf("no chunks of file %v", f.hash))
+			return
+		}
+	}
+	c.Header("Accept-Ranges", "bytes")
+	c.Header("Content-Type", "application/octet-stream")
+	// content of the file never changes, so its hash identifies it
+	c.Header("ETag", `"`+f.hash+`"`)
+	c.Header("Content-Length", strconv.FormatInt(end-start+1, 10))
+	status := http.StatusOK
+	if partial {
+		c.Header("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, f.size))
+		status = http.StatusPartialContent
+	}
+	if chunks == nil {
+		c.Status(status)
+		return
+	}
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+	touch := s.watchIdle(c, cancel)
+	defer touch(false)
+	r := s.chunkedReader(ctx, touch, chunks, start, end)
+	defer func() { _ = r.Close() }()
+	c.Status(status)
+	s.streamToClient(ctx, cancel, c, r, id, p)
+}
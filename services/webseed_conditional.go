@@ -0,0 +1,94 @@
+package services
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// etagListed reports whether If-None-Match header h lists etag, compared weakly as RFC 7232 requires for it.
+func etagListed(h, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, t := range strings.Split(h, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || strings.TrimPrefix(t, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified evaluates conditional headers of a GET or HEAD request against validators of the response.
+// If-Modified-Since is ignored once If-None-Match is sent.
+func notModified(r *http.Request, h http.Header) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		etag := h.Get("ETag")
+		return etag != "" && etagListed(inm, etag)
+	}
+	ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	lm, err := http.ParseTime(h.Get("Last-Modified"))
+	if err != nil {
+		return false
+	}
+	return !lm.After(ims.Truncate(time.Second))
+}
+
+// conditionalWriter turns successful webseed responses into 304 Not Modified once validators of the
+// file (ETag and Last-Modified of the S3 object) match conditional headers of the request. Validators
+// are only known when the response starts, so it is decided there and the body is discarded.
+type conditionalWriter struct {
+	gin.ResponseWriter
+	r    *http.Request
+	done bool
+}
+
+func (w *conditionalWriter) apply(status int) int {
+	if w.done {
+		return status
+	}
+	w.done = true
+	if status != http.StatusOK && status != http.StatusPartialContent {
+		return status
+	}
+	h := w.Header()
+	if !notModified(w.r, h) {
+		return status
+	}
+	for _, k := range []string{"Content-Length", "Content-Range", "Content-Type", "Content-Disposition"} {
+		h.Del(k)
+	}
+	return http.StatusNotModified
+}
+
+func (w *conditionalWriter) WriteHeader(code int) {
+	w.ResponseWriter.WriteHeader(w.apply(code))
+}
+
+func (w *conditionalWriter) WriteHeaderNow() {
+	w.ResponseWriter.WriteHeader(w.apply(w.Status()))
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *conditionalWriter) Write(b []byte) (int, error) {
+	w.WriteHeaderNow()
+	if w.Status() == http.StatusNotModified {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// conditional wraps the writer of the webseed response if the request has conditional headers.
+func conditional(c *gin.Context) {
+	if c.GetHeader("If-None-Match") == "" && c.GetHeader("If-Modified-Since") == "" {
+		return
+	}
+	c.Writer = &conditionalWriter{ResponseWriter: c.Writer, r: c.Request}
+}
@@ -0,0 +1,131 @@
+package services
+
+import (
+	"context"
+	"html/template"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pg/pg/v10"
+)
+
+// IndexEntry is a single item of webseed directory listing.
+type IndexEntry struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+	Type string `json:"type"` // file or directory
+	Size int64  `json:"size"`
+	Href string `json:"href"`
+}
+
+// IndexResponse is a webseed directory listing.
+type IndexResponse struct {
+	ResourceID string       `json:"resource_id"`
+	Path       string       `json:"path"`
+	Entries    []IndexEntry `json:"entries"`
+}
+
+const (
+	indexEntryFile      = "file"
+	indexEntryDirectory = "directory"
+)
+
+var indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Index of {{.Path}}</title></head>
+<body>
+<h1>Index of {{.Path}}</h1>
+<table>
+<tr><th>Name</th><th>Size</th></tr>
+{{if ne .Path "/"}}<tr><td><a href="../">../</a></td><td></td></tr>
+{{end}}{{range .Entries}}<tr><td><a href="{{.Href}}">{{.Name}}{{if eq .Type "directory"}}/{{end}}</a></td><td>{{.Size}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// listDir returns immediate children of dir (which must end with "/"). Paths are stored with the torrent
+// name as the first segment (BEP 19 multi-file layout), so /webseed/{id}/ lists the torrent root.
+func (s *Web) listDir(ctx context.Context, db *pg.DB, id, dir string) ([]IndexEntry, error) {
+	rfs, err := ResourceFileListByPrefix(ctx, db, id, dir)
+	if err != nil {
+		return nil, err
+	}
+	var entries []IndexEntry
+	dirs := map[string]int{}
+	for _, rf := range rfs {
+		rest := strings.TrimPrefix(rf.Path, dir)
+		var size int64
+		if rf.File != nil {
+			size = rf.File.TotalSize
+		}
+		if i := strings.Index(rest, "/"); i >= 0 {
+			name := rest[:i]
+			if n, ok := dirs[name]; ok {
+				entries[n].Size += size
+				continue
+			}
+			dirs[name] = len(entries)
+			entries = append(entries, IndexEntry{
+				Name: name,
+				Path: dir + name + "/",
+				Type: indexEntryDirectory,
+				Size: size,
+				Href: url.PathEscape(name) + "/",
+			})
+			continue
+		}
+		entries = append(entries, IndexEntry{
+			Name: rest,
+			Path: rf.Path,
+			Type: indexEntryFile,
+			Size: size,
+			Href: url.PathEscape(rest),
+		})
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Type != entries[j].Type {
+			return entries[i].Type == indexEntryDirectory
+		}
+		return entries[i].Name < entries[j].Name
+	})
+	return entries, nil
+}
+
+// serveIndex renders directory listing as JSON (if requested with Accept: application/json or ?format=json) or HTML.
+// Directory urls without trailing slash are redirected so that relative links resolve properly.
+func (s *Web) serveIndex(c *gin.Context, db *pg.DB, id, p string) {
+	dir := strings.TrimSuffix(p, "/") + "/"
+	entries, err := s.listDir(c.Request.Context(), db, id, dir)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if len(entries) == 0 {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	if !strings.HasSuffix(c.Request.URL.Path, "/") {
+		u := *c.Request.URL
+		u.Path += "/"
+		u.RawPath = ""
+		c.Redirect(http.StatusMovedPermanently, u.String())
+		return
+	}
+	res := &IndexResponse{ResourceID: id, Path: dir, Entries: entries}
+	if c.Query("format") == "json" || c.NegotiateFormat(gin.MIMEHTML, gin.MIMEJSON) == gin.MIMEJSON {
+		c.JSON(http.StatusOK, res)
+		return
+	}
+	c.Status(http.StatusOK)
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	if c.Request.Method == http.MethodHead {
+		return
+	}
+	if err := indexTemplate.Execute(c.Writer, res); err != nil {
+		_ = c.Error(err)
+	}
+}
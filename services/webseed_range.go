@@ -0,0 +1,228 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// maxRanges limits number of ranges in a single request, requests with more ranges get whole content.
+const maxRanges = 16
+
+var errRangeNotSatisfiable = errors.New("range not satisfiable")
+
+// parseRanges parses Range header against content size according to RFC 7233. Returned ranges
+// always have explicit ends. Nil is returned if whole content should be served: there is no header,
+// it is not a bytes range, it is malformed or it asks for more than the content itself.
+// errRangeNotSatisfiable is returned if none of the ranges overlaps content.
+func parseRanges(h string, size int64) ([]byteRange, error) {
+	spec, ok := strings.CutPrefix(strings.TrimSpace(h), "bytes=")
+	if !ok {
+		return nil, nil
+	}
+	var (
+		res   []byteRange
+		total int64
+		specs = strings.Split(spec, ",")
+	)
+	if len(specs) > maxRanges {
+		return nil, nil
+	}
+	for _, sp := range specs {
+		sp = strings.TrimSpace(sp)
+		if sp == "" {
+			continue
+		}
+		startStr, endStr, ok := strings.Cut(sp, "-")
+		if !ok {
+			return nil, nil
+		}
+		startStr, endStr = strings.TrimSpace(startStr), strings.TrimSpace(endStr)
+		var r byteRange
+		if startStr == "" {
+			suffix, err := strconv.ParseInt(endStr, 10, 64)
+			if err != nil || suffix < 0 {
+				return nil, nil
+			}
+			if suffix == 0 || size == 0 {
+				continue
+			}
+			r = byteRange{start: max(size-suffix, 0), end: size - 1}
+		} else {
+			start, err := strconv.ParseInt(startStr, 10, 64)
+			if err != nil || start < 0 {
+				return nil, nil
+			}
+			end := size - 1
+			if endStr != "" {
+				if end, err = strconv.ParseInt(endStr, 10, 64); err != nil || end < start {
+					return nil, nil
+				}
+			}
+			if start >= size {
+				continue
+			}
+			r = byteRange{start: start, end: min(end, size-1)}
+		}
+		total += r.end - r.start + 1
+		res = append(res, r)
+	}
+	if len(res) == 0 {
+		return nil, errRangeNotSatisfiable
+	}
+	if len(res) > 1 && total > size {
+		return nil, nil
+	}
+	return res, nil
+}
+
+// serveMultiRange responds with multipart/byteranges body containing every requested range.
+func (s *Web) serveMultiRange(c *gin.Context, f *webseedFile, ranges []byteRange, id, p string) {
+	ct := mime.TypeByExtension(path.Ext(p))
+	if ct == "" {
+		ct = "application/octet-stream"
+	}
+	boundary := multipart.NewWriter(io.Discard).Boundary()
+	parts := make([]string, len(ranges))
+	length := int64(0)
+	for i, r := range ranges {
+		prefix := "\r\n"
+		if i == 0 {
+			prefix = ""
+		}
+		parts[i] = fmt.Sprintf("%s--%s\r\nContent-Type: %s\r\nContent-Range: bytes %d-%d/%d\r\n\r\n",
+			prefix, boundary, ct, r.start, r.end, f.size)
+		length += int64(len(parts[i])) + r.end - r.start + 1
+	}
+	closing := "\r\n--" + boundary + "--\r\n"
+	length += int64(len(closing))
+
+	setHeaders := func() {
+		c.Header("Accept-Ranges", "bytes")
+		c.Header("Content-Type", "multipart/byteranges; boundary="+boundary)
+		c.Header("Content-Length", strconv.FormatInt(length, 10))
+		c.Status(http.StatusPartialContent)
+	}
+	if c.Request.Method == http.MethodHead {
+		setHeaders()
+		return
+	}
+
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+	touch := s.watchIdle(c, cancel)
+	defer touch(false)
+
+	var eo *encryptedObject
+	var chunks []FileChunk
+	if f.chunked {
+		var err error
+		if chunks, err = FileChunkList(ctx, s.pg.Read(), f.hash); err != nil {
+			s.webseedLookupError(c, err)
+			return
+		}
+	} else if s.enc.ClientSide() {
+		var err error
+		if eo, _, err = s.headEncryptedObject(ctx, s.bucketOf(f), f.hash); err != nil {
+			if isS3NotFoundError(err) {
+				c.Status(http.StatusNotFound)
+				return
+			}
+			_ = c.Error(err)
+			return
+		}
+	}
+	rrs := make([]*rangeReader, len(ranges))
+	for i, r := range ranges {
+		rrs[i] = &rangeReader{web: s, ctx: ctx, touch: touch, bucket: s.bucketOf(f), hash: f.hash, eo: eo, chunks: chunks, r: r}
+	}
+	defer func() {
+		for _, rr := range rrs {
+			rr.close()
+		}
+	}()
+	// first range is opened before the response is started, so missing object still results in 404
+	if err := rrs[0].open(); err != nil {
+		if isS3NotFoundError(err) {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		_ = c.Error(err)
+		return
+	}
+	readers := make([]io.Reader, 0, len(ranges)*2+1)
+	for i := range ranges {
+		readers = append(readers, strings.NewReader(parts[i]), rrs[i])
+	}
+	readers = append(readers, strings.NewReader(closing))
+
+	setHeaders()
+	s.streamToClient(ctx, cancel, c, io.MultiReader(readers...), id, p)
+}
+
+// rangeReader lazily requests a single range of the object from S3, so parts of a multi-range
+// response are fetched one by one.
+type rangeReader struct {
+	web    *Web
+	ctx    context.Context
+	touch  func(bool)
+	bucket string
+	hash   string
+	eo     *encryptedObject
+	// chunks of a chunked file, the range is read from them
+	chunks []FileChunk
+	r      byteRange
+	body   io.ReadCloser
+	rd     io.Reader
+}
+
+func (s *rangeReader) open() error {
+	if s.chunks != nil {
+		body := s.web.chunkedReader(s.ctx, s.touch, s.chunks, s.r.start, s.r.end)
+		s.body, s.rd = body, body
+		return nil
+	}
+	rng := s.r.String()
+	if s.eo != nil {
+		rng = s.eo.CipherRange(s.r.start, s.r.end).String()
+	}
+	out, err := s.web.getObject(s.ctx, s.touch, s.bucket, s.hash, rng)
+	if err != nil {
+		return err
+	}
+	s.body = out.Body
+	s.rd = io.LimitReader(out.Body, s.r.end-s.r.start+1)
+	if s.eo != nil {
+		s.rd = s.eo.Reader(out.Body, s.r.start, s.r.end)
+	}
+	return nil
+}
+
+func (s *rangeReader) Read(b []byte) (int, error) {
+	if s.rd == nil {
+		if err := s.open(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := s.rd.Read(b)
+	if err == io.EOF {
+		s.close()
+	}
+	return n, err
+}
+
+func (s *rangeReader) close() {
+	if s.body != nil {
+		_ = s.body.Close()
+		s.body = nil
+	}
+}
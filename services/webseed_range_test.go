@@ -0,0 +1,54 @@
+package services
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseRanges(t *testing.T) {
+	tests := []struct {
+		name    string
+		h       string
+		size    int64
+		want    []byteRange
+		wantErr error
+	}{
+		{name: "no header", h: "", size: 100},
+		{name: "not bytes", h: "items=0-9", size: 100},
+		{name: "closed", h: "bytes=0-9", size: 100, want: []byteRange{{0, 9}}},
+		{name: "spaces", h: " bytes= 10 - 19 ", size: 100, want: []byteRange{{10, 19}}},
+		{name: "single byte", h: "bytes=99-99", size: 100, want: []byteRange{{99, 99}}},
+		{name: "open-ended", h: "bytes=90-", size: 100, want: []byteRange{{90, 99}}},
+		{name: "end past size", h: "bytes=90-1000", size: 100, want: []byteRange{{90, 99}}},
+		{name: "suffix", h: "bytes=-10", size: 100, want: []byteRange{{90, 99}}},
+		{name: "suffix larger than size", h: "bytes=-1000", size: 100, want: []byteRange{{0, 99}}},
+		{name: "multi", h: "bytes=0-9,20-29", size: 100, want: []byteRange{{0, 9}, {20, 29}}},
+		{name: "multi with suffix", h: "bytes=0-0,-1", size: 100, want: []byteRange{{0, 0}, {99, 99}}},
+		{name: "multi skips unsatisfiable", h: "bytes=0-9,200-300", size: 100, want: []byteRange{{0, 9}}},
+		{name: "multi empty spec", h: "bytes=0-9,,20-29", size: 100, want: []byteRange{{0, 9}, {20, 29}}},
+		{name: "multi larger than content", h: "bytes=0-99,0-99", size: 100},
+		{name: "too many ranges", h: "bytes=" + strings.Repeat("0-0,", maxRanges) + "0-0", size: 100},
+		{name: "start past size", h: "bytes=100-", size: 100, wantErr: errRangeNotSatisfiable},
+		{name: "zero suffix", h: "bytes=-0", size: 100, wantErr: errRangeNotSatisfiable},
+		{name: "all unsatisfiable", h: "bytes=100-200,300-", size: 100, wantErr: errRangeNotSatisfiable},
+		{name: "zero size open-ended", h: "bytes=0-", size: 0, wantErr: errRangeNotSatisfiable},
+		{name: "zero size suffix", h: "bytes=-10", size: 0, wantErr: errRangeNotSatisfiable},
+		{name: "reversed", h: "bytes=9-0", size: 100},
+		{name: "no dash", h: "bytes=10", size: 100},
+		{name: "not a number", h: "bytes=a-9", size: 100},
+		{name: "negative suffix", h: "bytes=--5", size: 100},
+		{name: "bad end", h: "bytes=0-b", size: 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseRanges(tt.h, tt.size)
+			if err != tt.wantErr {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseRanges(%q, %v) = %v, want %v", tt.h, tt.size, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	awss3 "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gin-gonic/gin"
+)
+
+func TestResolveRange(t *testing.T) {
+	tests := []struct {
+		name        string
+		h           string
+		size        int64
+		start, end  int64
+		partial, ok bool
+	}{
+		{name: "no header", h: "", size: 100, start: 0, end: 99, ok: true},
+		{name: "not bytes", h: "items=0-9", size: 100, start: 0, end: 99, ok: true},
+		{name: "multi is whole", h: "bytes=0-9,20-29", size: 100, start: 0, end: 99, ok: true},
+		{name: "closed", h: "bytes=10-19", size: 100, start: 10, end: 19, partial: true, ok: true},
+		{name: "open-ended", h: "bytes=90-", size: 100, start: 90, end: 99, partial: true, ok: true},
+		{name: "end past size", h: "bytes=90-1000", size: 100, start: 90, end: 99, partial: true, ok: true},
+		{name: "suffix", h: "bytes=-10", size: 100, start: 90, end: 99, partial: true, ok: true},
+		{name: "suffix larger than size", h: "bytes=-1000", size: 100, start: 0, end: 99, partial: true, ok: true},
+		{name: "last byte", h: "bytes=99-99", size: 100, start: 99, end: 99, partial: true, ok: true},
+		{name: "start past size", h: "bytes=100-", size: 100},
+		{name: "zero suffix", h: "bytes=-0", size: 100},
+		{name: "bad suffix", h: "bytes=-x", size: 100},
+		{name: "reversed", h: "bytes=9-0", size: 100},
+		{name: "zero size whole", h: "", size: 0, start: 0, end: -1, ok: true},
+		{name: "zero size open-ended", h: "bytes=0-", size: 0},
+		{name: "zero size suffix", h: "bytes=-1", size: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end, partial, ok := resolveRange(tt.h, tt.size)
+			if ok != tt.ok {
+				t.Fatalf("resolveRange(%q, %v) ok = %v, want %v", tt.h, tt.size, ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			if start != tt.start || end != tt.end || partial != tt.partial {
+				t.Errorf("resolveRange(%q, %v) = %v, %v, %v, want %v, %v, %v",
+					tt.h, tt.size, start, end, partial, tt.start, tt.end, tt.partial)
+			}
+		})
+	}
+}
+
+func TestAlignRange(t *testing.T) {
+	tests := []struct {
+		name      string
+		blockSize int64
+		h         string
+		requested *byteRange
+		aligned   *byteRange
+	}{
+		{name: "disabled", blockSize: 0, h: "bytes=5-10"},
+		{name: "no header", blockSize: 16, h: ""},
+		{name: "suffix", blockSize: 16, h: "bytes=-10"},
+		{name: "multi", blockSize: 16, h: "bytes=0-1,5-6"},
+		{name: "inside block", blockSize: 16, h: "bytes=5-10", requested: &byteRange{5, 10}, aligned: &byteRange{0, 15}},
+		{name: "whole block", blockSize: 16, h: "bytes=16-31", requested: &byteRange{16, 31}, aligned: &byteRange{16, 31}},
+		{name: "across blocks", blockSize: 16, h: "bytes=15-16", requested: &byteRange{15, 16}, aligned: &byteRange{0, 31}},
+		{name: "open-ended", blockSize: 16, h: "bytes=20-", requested: &byteRange{20, -1}, aligned: &byteRange{16, -1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Web{blockSize: tt.blockSize}
+			requested, aligned := s.alignRange(tt.h)
+			if !reflect.DeepEqual(requested, tt.requested) || !reflect.DeepEqual(aligned, tt.aligned) {
+				t.Errorf("alignRange(%q) = %v, %v, want %v, %v", tt.h, requested, aligned, tt.requested, tt.aligned)
+			}
+		})
+	}
+}
+
+func TestServeAlignedRangeClampsAtEOF(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	content := []byte("0123456789abcdefghijklmnopqrstuvwxyzABCD")
+	size := int64(len(content))
+	tests := []struct {
+		name      string
+		blockSize int64
+		h         string
+		// S3 response to the aligned range, clamped at the end of the object
+		s3Start, s3End int64
+		status         int
+		contentRange   string
+		body           string
+	}{
+		{name: "end past EOF", blockSize: 16, h: "bytes=35-100", s3Start: 32, s3End: 39,
+			status: http.StatusPartialContent, contentRange: "bytes 35-39/40", body: string(content[35:])},
+		{name: "open-ended", blockSize: 16, h: "bytes=20-", s3Start: 16, s3End: 39,
+			status: http.StatusPartialContent, contentRange: "bytes 20-39/40", body: string(content[20:])},
+		{name: "aligned block past EOF", blockSize: 64, h: "bytes=10-12", s3Start: 0, s3End: 39,
+			status: http.StatusPartialContent, contentRange: "bytes 10-12/40", body: string(content[10:13])},
+		{name: "start past EOF", blockSize: 64, h: "bytes=45-50", s3Start: 0, s3End: 39,
+			status: http.StatusRequestedRangeNotSatisfiable, contentRange: "bytes */40"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Web{blockSize: tt.blockSize}
+			br, aligned := s.alignRange(tt.h)
+			if aligned == nil {
+				t.Fatalf("range %q is not aligned", tt.h)
+			}
+			out := &awss3.GetObjectOutput{
+				Body:          io.NopCloser(bytes.NewReader(content[tt.s3Start : tt.s3End+1])),
+				ContentLength: aws.Int64(tt.s3End - tt.s3Start + 1),
+				ContentRange:  aws.String(fmt.Sprintf("bytes %d-%d/%d", tt.s3Start, tt.s3End, size)),
+			}
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			s.serveAlignedRange(ctx, cancel, c, out, br, aligned, "id", "path")
+			c.Writer.WriteHeaderNow()
+			if w.Code != tt.status {
+				t.Fatalf("status = %v, want %v", w.Code, tt.status)
+			}
+			if got := w.Header().Get("Content-Range"); got != tt.contentRange {
+				t.Errorf("Content-Range = %q, want %q", got, tt.contentRange)
+			}
+			if got := w.Body.String(); got != tt.body {
+				t.Errorf("body = %q, want %q", got, tt.body)
+			}
+			if tt.status == http.StatusPartialContent {
+				if got, want := w.Header().Get("Content-Length"), strconv.Itoa(len(tt.body)); got != want {
+					t.Errorf("Content-Length = %v, want %v", got, want)
+				}
+			}
+		})
+	}
+}
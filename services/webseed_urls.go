@@ -0,0 +1,92 @@
+package services
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// WebseedFileURL is a webseed URL of a single file of the resource.
+type WebseedFileURL struct {
+	Path string `json:"path"`
+	URL  string `json:"url"`
+}
+
+// WebseedURLsResponse lists web seed URLs of a stored resource.
+type WebseedURLsResponse struct {
+	ResourceID string `json:"resource_id"`
+	// URLList are url-list entries (BEP 19) of the torrent, clients append name and path of files to them
+	URLList []string `json:"url_list"`
+	// Files are direct URLs of files of the resource
+	Files []WebseedFileURL `json:"files"`
+}
+
+// webseedBaseURL is the public URL webseed is reachable at, taken from the request unless configured.
+func (s *Web) webseedBaseURL(c *gin.Context) string {
+	if s.webseedPublicURL != "" {
+		return s.webseedPublicURL
+	}
+	scheme := "http"
+	if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	host := c.Request.Host
+	if h := c.GetHeader("X-Forwarded-Host"); h != "" {
+		host = h
+	}
+	return scheme + "://" + host
+}
+
+// GET /resource/{id}/webseed-urls — web seed URLs of a stored resource
+// getWebseedURLs godoc
+// @Summary      Get web seed URLs
+// @Description  Returns url-list entries (BEP 19) of the stored resource to inject into magnet links (ws=) and .torrent files,
+// @Description  and direct webseed URLs of its files. URLs are built on --webseed-public-url, or on the host of the request
+// @Description  if it is not set. Torrent clients can't send X-Token, so webseed must be reachable without it.
+// @Description  BEP 17 (httpseeds) is not served by webseed, so no such URLs are returned.
+// @Tags         resource
+// @Param        id   path      string  true  "Resource ID"
+// @Success      200  {object}  WebseedURLsResponse
+// @Failure      404  {object}  ErrorResponse
+// @Failure      410  {object}  GoneResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /resource/{id}/webseed-urls [get]
+func (s *Web) getWebseedURLs(c *gin.Context) {
+	db := s.pg.Read()
+	if db == nil {
+		_ = c.Error(errors.New("DB not configured"))
+		return
+	}
+	id := c.Param("id")
+	st, err := s.lookupResourceState(c, db, id)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if st.takedown {
+		c.AbortWithStatusJSON(http.StatusGone, &GoneResponse{Error: ErrTakenDown.Error(), ReasonCode: st.reasonCode})
+		return
+	}
+	// webseed serves stored resources only
+	if !st.stored {
+		c.AbortWithStatusJSON(http.StatusNotFound, &ErrorResponse{Error: "resource is not stored"})
+		return
+	}
+	rfs, err := ResourceFileListByPrefix(c.Request.Context(), db, id, "/")
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	base := s.webseedBaseURL(c) + "/webseed/" + url.PathEscape(id)
+	res := &WebseedURLsResponse{
+		ResourceID: id,
+		URLList:    []string{base + "/"},
+		Files:      make([]WebseedFileURL, 0, len(rfs)),
+	}
+	for _, rf := range rfs {
+		res.Files = append(res.Files, WebseedFileURL{Path: rf.Path, URL: base + escapeWebseedPath(rf.Path)})
+	}
+	c.JSON(http.StatusOK, res)
+}
@@ -2,21 +2,23 @@ package services
 
 import (
 	"context"
-	"crypto/sha256"
 	"errors"
 	"fmt"
 	"io"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
-	awss3 "github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	pg "github.com/go-pg/pg/v10"
 	log "github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 	cs "github.com/webtor-io/common-services"
 	ra "github.com/webtor-io/rest-api/services"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/errgroup"
 )
 
 // progressReader wraps an io.Reader and invokes onRead with the number of bytes
@@ -37,21 +39,101 @@ func (p *progressReader) Read(b []byte) (int, error) {
 	return n, err
 }
 
+type progressWriter struct {
+	w       io.Writer
+	onWrite func(n int)
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	if n > 0 {
+		p.onWrite(n)
+	}
+	return n, err
+}
+
 // Worker is a placeholder for background tasks (store/delete torrent data, update progress).
 type Worker struct {
 	ctx    context.Context
 	cancel context.CancelFunc
-	pg     *cs.PG
+	pg     *PG
 	s3     *cs.S3Client
 	jobs   chan job
 	nwrks  int
 	api    *Api
 	bucket string
+	enc    *Encryption
+	keys   *ObjectKeys
+	// router chooses buckets of stored files
+	router *BucketRouter
+	// tags of uploaded objects, nil if objects are not tagged
+	tags *ObjectTags
+	// parallel downloads large files with concurrent range requests, nil downloads sequentially
+	parallel *ParallelDownload
+	// idempotencyTTL is how long idempotency keys are kept
+	idempotencyTTL time.Duration
+	// hashAlgo is used for newly stored files, dedup only matches files hashed the same way
+	hashAlgo HashAlgo
+	// hashStreaming computes full content hash while uploading instead of downloading content twice
+	hashStreaming bool
+	events        *Events
+	// storageClass of uploaded objects unless overridden by resource
+	storageClass string
+	// policies pick storage class by content class of the file
+	policies ContentPolicies
+	// archiveIndex lists entries of zip/rar files once they are stored
+	archiveIndex bool
+	transition   *StorageTransition
+	// logRetention prunes finished operation logs, nil keeps them forever
+	logRetention *LogRetention
+	// archival moves objects of archived resources to Glacier and restores them
+	archival *Archival
+	// id identifies the replica holding resource leases
+	id       string
+	leaseTTL time.Duration
+	// schedule restricts heavy jobs to time windows
+	schedule *Schedule
+	// sched limits concurrency of store jobs and their files
+	sched *JobScheduler
+	// retry repeats file downloads failed with transient torrent http proxy errors
+	retry *FileRetry
+	// deleteGrace keeps deleted resources restorable for this period
+	deleteGrace time.Duration
+	// deadAfter is the period without heartbeat after which jobs of an instance are taken over
+	deadAfter time.Duration
+	// bulkRunning is set while this instance runs a bulk operation
+	bulkRunning atomic.Bool
+	// verifier re-hashes files of rehash bulk operations
+	verifier *Verifier
+	// replica receives copies of stored files
+	replica *Replica
+	// replicaRunning is set while this instance copies a batch of files to the replica
+	replicaRunning atomic.Bool
+	// chunking splits new files into deduplicated chunks, nil stores whole objects
+	chunking *Chunking
+	// pollInterval is how often queued resources are polled and periodic sweeps run
+	pollInterval time.Duration
+	// notifyChannel wakes the worker up on queued work, empty if LISTEN/NOTIFY is disabled
+	notifyChannel string
+	// storeTimeout caps storing time of resources without max store duration (0 means no cap)
+	storeTimeout time.Duration
+	// fileTimeout caps storing time of a single file including retries (0 means no cap)
+	fileTimeout time.Duration
+	// breaker pauses new store jobs after consecutive S3 or torrent http proxy failures, nil if disabled
+	breaker *CircuitBreaker
+	// paused is set while new store jobs are held by open circuits
+	paused bool
 }
 
 const (
-	workerCountFlag = "workers"
-	awsBucketFlag   = "aws-bucket"
+	workerCountFlag    = "workers"
+	awsBucketFlag      = "aws-bucket"
+	workerIDFlag       = "worker-id"
+	workerLeaseTTLFlag = "worker-lease-ttl"
+	pollIntervalFlag   = "poll-interval"
+	notifyChannelFlag  = "notify-channel"
+	storeTimeoutFlag   = "store-timeout"
+	fileTimeoutFlag    = "file-timeout"
 )
 
 // RegisterWorkerFlags registers CLI flags for the worker service.
@@ -68,32 +150,163 @@ func RegisterWorkerFlags(f []cli.Flag) []cli.Flag {
 			Usage:  "aws bucket",
 			EnvVar: "AWS_BUCKET",
 		},
+		cli.StringFlag{
+			Name:   workerIDFlag,
+			Usage:  "id of this instance used as resource lease owner (default: hostname-uuid)",
+			EnvVar: "WORKER_ID",
+		},
+		cli.DurationFlag{
+			Name:   workerLeaseTTLFlag,
+			Usage:  "resource lease duration, leases are renewed while the job runs and taken over by other replicas once expired",
+			Value:  time.Minute,
+			EnvVar: "WORKER_LEASE_TTL",
+		},
+		cli.DurationFlag{
+			Name:   pollIntervalFlag,
+			Usage:  "how often the worker polls for queued resources and runs periodic sweeps",
+			Value:  5 * time.Second,
+			EnvVar: "POLL_INTERVAL",
+		},
+		cli.StringFlag{
+			Name:   notifyChannelFlag,
+			Usage:  "postgres LISTEN/NOTIFY channel web handlers notify after queueing work, so workers pick it up without waiting for poll-interval (empty disables)",
+			EnvVar: "NOTIFY_CHANNEL",
+		},
+		cli.DurationFlag{
+			Name:   storeTimeoutFlag,
+			Usage:  "storing of a resource taking longer fails with store timeout error, max_duration of the resource overrides it (0 disables)",
+			EnvVar: "STORE_TIMEOUT",
+		},
+		cli.DurationFlag{
+			Name:   fileTimeoutFlag,
+			Usage:  "storing of a single file taking longer, including download retries, fails the store with file timeout error (0 disables)",
+			EnvVar: "FILE_TIMEOUT",
+		},
 	)
 }
 
 type job struct {
 	status Status
 	id     string
+	// requestID of the API call which queued the resource
+	requestID string
+	// traceContext of the API call which queued the resource
+	traceContext map[string]string
+	// maxStoreDuration caps storing time (0 means no cap)
+	maxStoreDuration time.Duration
+	// takenOverFrom is the instance which held the lease of a job interrupted in progress
+	takenOverFrom string
 }
 
-func NewWorker(c *cli.Context, pgc *cs.PG, s3 *cs.S3Client, api *Api) *Worker {
+func NewWorker(c *cli.Context, pgc *PG, s3 *cs.S3Client, api *Api, enc *Encryption, keys *ObjectKeys, replica *Replica, events *Events, inst *InstanceHeartbeat, breaker *CircuitBreaker, router *BucketRouter) (*Worker, error) {
+	hashAlgo, err := ParseHashAlgo(c.String(hashModeFlag))
+	if err != nil {
+		return nil, err
+	}
+	sc, err := ParseStorageClass(c.String(s3StorageClassFlag))
+	if err != nil {
+		return nil, err
+	}
+	transition, err := NewStorageTransition(c)
+	if err != nil {
+		return nil, err
+	}
+	logRetention, err := NewLogRetention(c)
+	if err != nil {
+		return nil, err
+	}
+	archival, err := NewArchival(c)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := NewObjectTags(c)
+	if err != nil {
+		return nil, err
+	}
+	parallel, err := NewParallelDownload(c, api)
+	if err != nil {
+		return nil, err
+	}
+	schedule, err := NewSchedule(c)
+	if err != nil {
+		return nil, err
+	}
+	policies, err := NewContentPolicies(c)
+	if err != nil {
+		return nil, err
+	}
+	sched, err := NewJobScheduler(c)
+	if err != nil {
+		return nil, err
+	}
+	retry, err := NewFileRetry(c, breaker)
+	if err != nil {
+		return nil, err
+	}
+	chunking, err := NewChunking(c, hashAlgo, enc)
+	if err != nil {
+		return nil, err
+	}
+	// chunks are shared by files of any bucket, they are always stored in aws-bucket
+	if chunking != nil && router.Routed() {
+		return nil, errors.New("bucket rules are not supported with chunking")
+	}
+	if c.Duration(workerLeaseTTLFlag) <= 0 {
+		return nil, errors.New("worker lease ttl must be positive")
+	}
+	if c.Duration(pollIntervalFlag) <= 0 {
+		return nil, errors.New("poll interval must be positive")
+	}
+	if c.Duration(storeTimeoutFlag) < 0 || c.Duration(fileTimeoutFlag) < 0 {
+		return nil, errors.New("store and file timeouts must not be negative")
+	}
 	ctx := context.Background()
 	ctx, cancel := context.WithCancel(ctx)
 	w := &Worker{
-		ctx:    ctx,
-		cancel: cancel,
-		pg:     pgc,
-		s3:     s3,
-		nwrks:  c.Int(workerCountFlag),
-		jobs:   make(chan job, 1024),
-		api:    api,
-		bucket: c.String(awsBucketFlag),
+		ctx:            ctx,
+		cancel:         cancel,
+		pg:             pgc,
+		s3:             s3,
+		nwrks:          c.Int(workerCountFlag),
+		jobs:           make(chan job, 1024),
+		api:            api,
+		bucket:         c.String(awsBucketFlag),
+		enc:            enc,
+		keys:           keys,
+		router:         router,
+		tags:           tags,
+		parallel:       parallel,
+		hashAlgo:       hashAlgo,
+		hashStreaming:  c.Bool(hashStreamingFlag),
+		events:         events,
+		storageClass:   sc,
+		policies:       policies,
+		archiveIndex:   c.Bool(archiveIndexFlag),
+		transition:     transition,
+		logRetention:   logRetention,
+		archival:       archival,
+		id:             inst.ID(),
+		deadAfter:      inst.deadAfter(),
+		leaseTTL:       c.Duration(workerLeaseTTLFlag),
+		schedule:       schedule,
+		sched:          sched,
+		retry:          retry,
+		deleteGrace:    c.Duration(deleteGracePeriodFlag),
+		verifier:       NewVerifier(c, pgc, s3, enc, keys),
+		idempotencyTTL: c.Duration(idempotencyKeyTTLFlag),
+		replica:        replica,
+		chunking:       chunking,
+		pollInterval:   c.Duration(pollIntervalFlag),
+		notifyChannel:  c.String(notifyChannelFlag),
+		storeTimeout:   c.Duration(storeTimeoutFlag),
+		fileTimeout:    c.Duration(fileTimeoutFlag),
+		breaker:        breaker,
 	}
 	// start worker pool
 	for i := 0; i < w.nwrks; i++ {
 		go w.workerLoop()
 	}
-	return w
+	return w, nil
 }
 
 // Serve runs the worker loop until ctx is done.
@@ -103,14 +316,51 @@ func (s *Worker) Serve() error {
 		return errors.New("db is not configured")
 	}
 	log.Info("Worker started")
-	ticker := time.NewTicker(5 * time.Second)
+	ticker := time.NewTicker(s.pollInterval)
 	defer ticker.Stop()
+	wake := s.listen(db)
 	for {
 		select {
 		case <-s.ctx.Done():
 			log.Info("Worker stopped")
 			return nil
+		case <-wake:
+			// queued work is picked up right away, sweeps wait for the next tick
+			if err := s.startBulkOperation(s.ctx, db); err != nil {
+				log.WithError(err).Error("Worker bulk operation error")
+			}
+			if err := s.process(s.ctx, db); err != nil {
+				log.WithError(err).Error("Worker process error")
+			}
+			log.Debug("Worker woken up")
 		case <-ticker.C:
+			if err := s.sweepExpired(s.ctx, db); err != nil {
+				log.WithError(err).Error("Worker expiration sweep error")
+			}
+			if err := s.sweepPurge(s.ctx, db); err != nil {
+				log.WithError(err).Error("Worker purge sweep error")
+			}
+			if err := s.dispatchOutbox(s.ctx, db); err != nil {
+				log.WithError(err).Error("Worker s3 outbox error")
+			}
+			if err := s.sweepIdempotencyKeys(s.ctx, db); err != nil {
+				log.WithError(err).Error("Worker idempotency key sweep error")
+			}
+			if err := s.transitionStorageClass(s.ctx, db); err != nil {
+				log.WithError(err).Error("Worker storage class transition error")
+			}
+			if err := s.pruneLogs(s.ctx, db); err != nil {
+				log.WithError(err).Error("Worker log retention error")
+			}
+			if err := s.archiveObjects(s.ctx, db); err != nil {
+				log.WithError(err).Error("Worker archival error")
+			}
+			if err := s.startBulkOperation(s.ctx, db); err != nil {
+				log.WithError(err).Error("Worker bulk operation error")
+			}
+			if err := s.startReplication(s.ctx, db); err != nil {
+				log.WithError(err).Error("Worker replication error")
+			}
 			processErr := s.process(s.ctx, db)
 			if processErr != nil {
 				log.WithError(processErr).Error("Worker process error")
@@ -123,11 +373,36 @@ func (s *Worker) Serve() error {
 func (s *Worker) process(ctx context.Context, db *pg.DB) error {
 	// 1. Get all resources queued for storing or deletion in one request
 	var list []Resource
-	err := db.Model(&list).
+	q := db.Model(&list).
 		Context(ctx).
-		Where("status != ?", StatusStored).
-		Where("now() - updated_at > interval '10 seconds'").
-		Select()
+		// failed resources stay in dead-letter status until retried via POST /resource/{id}/retry
+		Where("status NOT IN (?)", pg.In([]Status{StatusStored, StatusStoreError, StatusDeleteError, StatusCorrupted, StatusPendingPurge, StatusArchived, StatusRestoring})).
+		// resources leased by other replicas are skipped until the lease expires or the replica dies
+		Where("lease_expires_at IS NULL OR lease_expires_at < now() OR "+deadLeaseOwnerCondition, s.deadAfter.Milliseconds()).
+		// resources just queued for storing are picked up right away, others once recent updates settle
+		Where("status = ? OR now() - updated_at > interval '10 seconds'", StatusQueuedForStoring).
+		// jobs are dispatched in this order, so higher priority resources are stored first
+		Order("priority DESC", "created_at")
+	if !s.schedule.Open(time.Now()) {
+		// heavy stores wait for a window, size of new resources is checked when the job starts
+		if s.schedule.heavyStoreSize == 0 {
+			q = q.Where("status != ?", StatusQueuedForStoring)
+		} else {
+			q = q.Where("NOT (status = ? AND total_size >= ?)", StatusQueuedForStoring, s.schedule.heavyStoreSize)
+		}
+	}
+	if open := s.breaker.OpenCircuits(time.Now()); len(open) > 0 {
+		// new store jobs wait for the cool-down, deletions and jobs in progress go on
+		q = q.Where("status != ?", StatusQueuedForStoring)
+		if !s.paused {
+			log.WithField("circuits", open).Warn("store jobs paused")
+		}
+		s.paused = true
+	} else if s.paused {
+		log.Info("store jobs resumed")
+		s.paused = false
+	}
+	err := q.Select()
 	if err != nil && !errors.Is(err, pg.ErrNoRows) {
 		return err
 	}
@@ -142,12 +417,28 @@ func (s *Worker) process(ctx context.Context, db *pg.DB) error {
 	return nil
 }
 
+// sweepExpired queues expired resources for deletion. Resources under legal hold are kept.
+func (s *Worker) sweepExpired(ctx context.Context, db *pg.DB) error {
+	ids, err := ResourceListExpired(ctx, db, 100)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if _, err := ResourceQueueForDeletion(ctx, db, id); err != nil {
+			log.WithError(err).WithField("id", id).Error("failed to queue expired resource for deletion")
+			continue
+		}
+		log.WithField("id", id).Info("resource expired, queued for deletion")
+	}
+	return nil
+}
+
 func (s *Worker) processResource(ctx context.Context, db *pg.DB, r Resource) error {
 	var processingStatus Status
 	switch r.Status {
-	case StatusQueuedForDeletion:
+	case StatusQueuedForDeletion, StatusDeleting:
 		processingStatus = StatusDeleting
-	case StatusQueuedForStoring:
+	case StatusQueuedForStoring, StatusStoring:
 		processingStatus = StatusStoring
 	}
 	return db.RunInTransaction(s.ctx, func(tx *pg.Tx) error {
@@ -158,6 +449,7 @@ func (s *Worker) processResource(ctx context.Context, db *pg.DB, r Resource) err
 			Context(ctx).
 			Where("resource_id = ?", r.ID).
 			Where("updated_at = ?", r.UpdatedAt).
+			Where("lease_expires_at IS NULL OR lease_expires_at < now() OR lease_owner = ? OR "+deadLeaseOwnerCondition, s.id, s.deadAfter.Milliseconds()).
 			For("UPDATE").
 			Select()
 		if err != nil {
@@ -166,14 +458,34 @@ func (s *Worker) processResource(ctx context.Context, db *pg.DB, r Resource) err
 			}
 			return err
 		}
-		cur.ID = r.ID
-		cur.Status = processingStatus
-		if _, err = tx.Model(cur).Context(ctx).Column("status").WherePK().Update(); err != nil {
+		// store jobs over concurrency limits stay queued until a slot is freed
+		if !s.sched.admit(processingStatus) {
+			return nil
+		}
+		if _, err = tx.Model(&Resource{ID: r.ID}).Context(ctx).
+			Set("status = ?", processingStatus).
+			Set("lease_owner = ?", s.id).
+			Set("lease_expires_at = now() + ? * interval '1 millisecond'", s.leaseTTL.Milliseconds()).
+			WherePK().
+			Update(); err != nil {
+			s.sched.done(processingStatus)
 			return err
 		}
+		j := job{status: processingStatus, id: r.ID}
+		if cur.RequestID != nil {
+			j.requestID = *cur.RequestID
+		}
+		j.traceContext = cur.TraceContext
+		if cur.LeaseOwner != nil && *cur.LeaseOwner != s.id {
+			j.takenOverFrom = *cur.LeaseOwner
+		}
+		if cur.MaxStoreDuration != nil {
+			j.maxStoreDuration = time.Duration(*cur.MaxStoreDuration) * time.Second
+		}
 		select {
-		case s.jobs <- job{status: processingStatus, id: r.ID}:
+		case s.jobs <- j:
 		case <-s.ctx.Done():
+			s.sched.done(processingStatus)
 		}
 		return nil
 	})
@@ -186,8 +498,9 @@ func (s *Worker) Close() {
 
 func (s *Worker) jobCancelContext(inCtx context.Context, db *pg.DB, j job) (ctx context.Context, cancel context.CancelFunc) {
 	ctx, cancel = context.WithCancel(inCtx)
-	t := time.NewTimer(5 * time.Second)
+	t := time.NewTicker(5 * time.Second)
 	go func() {
+		defer t.Stop()
 		for {
 			select {
 			case <-ctx.Done():
@@ -213,41 +526,123 @@ func (s *Worker) jobCancelContext(inCtx context.Context, db *pg.DB, j job) (ctx
 }
 
 func (s *Worker) processJob(ctx context.Context, db *pg.DB, j job) (err error) {
-	ctx, cancel := s.jobCancelContext(ctx, db, j)
+	ctx, span := tracer.Start(withTraceContext(ctx, j.traceContext), "worker."+j.status.String(),
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(attribute.String("resource_id", j.id), attribute.String("worker_id", s.id), jobSpanAttribute.Bool(true)))
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := s.jobCancelContext(WithRequestID(ctx, j.requestID), db, j)
 	defer cancel()
-	opLog, err := LogOperationStart(ctx, db, j.id, j.status)
+	l := logger(ctx).WithField("id", j.id)
+	if j.status == StatusStoring {
+		deferred, derr := s.deferHeavyStore(ctx, db, j.id)
+		if derr != nil {
+			l.WithError(derr).Warn("failed to check heavy job schedule")
+		}
+		if deferred {
+			l.Info("heavy store deferred until schedule window")
+			return nil
+		}
+	}
+	stop := s.keepLease(ctx, cancel, db, j.id)
+	defer stop()
+	ctx, retries := withRetryCount(ctx)
+	opLog, err := LogOperationStart(ctx, db, j.id, j.status, s.id)
 	if err != nil {
-		log.WithError(err).WithField("resource_id", j.id).Warn("failed to create operation log")
+		l.WithError(err).Warn("failed to create operation log")
+	}
+	if opLog != nil && j.takenOverFrom != "" {
+		promJobTakeovers.Inc()
+		l.WithField("from", j.takenOverFrom).Info("job taken over")
+		if terr := LogOperationTakeover(ctx, db, opLog, j.takenOverFrom); terr != nil {
+			l.WithError(terr).Warn("failed to record takeover")
+		}
 	}
 	if opLog != nil {
 		defer func() {
+			if n := retries.Load(); n > 0 {
+				if lerr := LogOperationSetFileRetries(ctx, db, opLog.LogID, n); lerr != nil {
+					l.WithError(lerr).WithField("log_id", opLog.LogID).Warn("failed to record file retries")
+				}
+			}
 			lerr := LogOperationFinish(ctx, db, opLog.LogID, err)
 			if lerr != nil {
-				log.WithError(lerr).WithField("log_id", opLog.LogID).Warn("failed to finish operation log")
+				l.WithError(lerr).WithField("log_id", opLog.LogID).Warn("failed to finish operation log")
 			}
 		}()
 	}
 	switch j.status {
 	case StatusStoring:
-		log.WithField("id", j.id).Info("storing started")
-		if err = s.handleStore(ctx, db, j.id); err != nil {
-			log.WithError(err).WithField("id", j.id).Error("store failed")
+		l.Info("storing started")
+		if err = s.handleStoreWithDeadline(ctx, db, j); err != nil {
+			l.WithError(err).Error("store failed")
 			s.handleError(ctx, j.id, err, StatusStoreError)
 			return
 		}
-		log.WithField("id", j.id).Info("stored successfully")
+		l.Info("stored successfully")
+		ev := &ResourceEvent{ResourceID: j.id, Status: StatusStored.String(), Time: time.Now()}
+		if res, rerr := ResourceGetByID(ctx, db, j.id); rerr == nil && res != nil {
+			ev.TotalSize = res.TotalSize
+			ev.StoredSize = res.StoredSize
+		}
+		s.events.Publish(EventResourceStored, ev)
 	case StatusDeleting:
-		log.WithField("id", j.id).Info("deleting started")
-		if err = s.handleDelete(ctx, db, j.id); err != nil {
-			log.WithError(err).WithField("id", j.id).Error("delete failed")
+		l.Info("deleting started")
+		var purged bool
+		if purged, err = s.handleDelete(ctx, db, j.id); err != nil {
+			l.WithError(err).Error("delete failed")
 			s.handleError(ctx, j.id, err, StatusDeleteError)
 			return
 		}
-		log.WithField("id", j.id).Info("deleted successfully")
+		if !purged {
+			s.events.Publish(EventResourceDeleted, &ResourceEvent{ResourceID: j.id, Status: StatusPendingPurge.String(), Time: time.Now()})
+			return
+		}
+		l.Info("deleted successfully")
+		s.events.Publish(EventResourceDeleted, &ResourceEvent{ResourceID: j.id, Status: "deleted", Time: time.Now()})
 	}
 	return
 }
 
+// handleStoreWithDeadline stores the resource failing with ErrStoreDeadline if it takes longer
+// than max store duration of the resource, or with ErrStoreTimeout if it has none and takes longer
+// than the store timeout.
+func (s *Worker) handleStoreWithDeadline(ctx context.Context, db *pg.DB, j job) error {
+	d, cause := j.maxStoreDuration, ErrStoreDeadline
+	if d <= 0 {
+		d, cause = s.storeTimeout, ErrStoreTimeout
+	}
+	if d <= 0 {
+		return s.handleStore(ctx, db, j.id)
+	}
+	sctx, cancel := context.WithTimeoutCause(ctx, d, cause)
+	defer cancel()
+	err := s.handleStore(sctx, db, j.id)
+	if err == nil || !errors.Is(context.Cause(sctx), cause) {
+		return err
+	}
+	if cause == ErrStoreTimeout {
+		promStoreTimeouts.WithLabelValues("resource").Inc()
+	} else {
+		promStoreDeadlineExceeded.Inc()
+	}
+	return fmt.Errorf("storing took longer than %v: %w", d, cause)
+}
+
+// storeFileWithTimeout stores the file failing with ErrFileTimeout if it takes longer than the file timeout.
+func (s *Worker) storeFileWithTimeout(ctx context.Context, cla *Claims, id string, item ra.ListItem, progress *storeProgress, sc string, labels map[string]string) (*File, error) {
+	if s.fileTimeout <= 0 {
+		return s.storeFile(ctx, cla, id, item, progress, sc, labels)
+	}
+	fctx, cancel := context.WithTimeoutCause(ctx, s.fileTimeout, ErrFileTimeout)
+	defer cancel()
+	f, err := s.storeFile(fctx, cla, id, item, progress, sc, labels)
+	if err != nil && errors.Is(context.Cause(fctx), ErrFileTimeout) {
+		promStoreTimeouts.WithLabelValues("file").Inc()
+		return nil, fmt.Errorf("storing %v took longer than %v: %w", item.PathStr, s.fileTimeout, ErrFileTimeout)
+	}
+	return f, err
+}
+
 func (s *Worker) workerLoop() {
 	db := s.pg.Get()
 	for {
@@ -256,6 +651,7 @@ func (s *Worker) workerLoop() {
 			return
 		case j := <-s.jobs:
 			err := s.processJob(s.ctx, db, j)
+			s.sched.done(j.status)
 			if err != nil {
 				log.WithError(err).Error("process job failed")
 			}
@@ -272,6 +668,31 @@ func (s *Worker) handleStore(ctx context.Context, db *pg.DB, id string) (err err
 		Role: "vault",
 	}
 
+	// Infohash could be blocklisted after it was queued
+	blocked, err := BlocklistIsBlocked(ctx, db, id)
+	if err != nil {
+		return err
+	}
+	if blocked {
+		return ErrBlocked
+	}
+
+	cur, err := ResourceGetByID(ctx, db, id)
+	if err != nil {
+		return err
+	}
+	if cur != nil && !cur.IDType.Torrent() {
+		return fmt.Errorf("resource of id type %v can't be stored from torrent", cur.IDType)
+	}
+	// storage class of the resource overrides per content class one
+	var sc *string
+	// labels of the resource are matched by bucket rules
+	var labels map[string]string
+	if cur != nil {
+		sc = cur.StorageClass
+		labels = cur.Labels
+	}
+
 	// Reset resource counters before (re)storing
 	if _, err := db.Model(&Resource{ID: id}).
 		Context(ctx).
@@ -283,15 +704,80 @@ func (s *Worker) handleStore(ctx context.Context, db *pg.DB, id string) (err err
 		return err
 	}
 
+	// Single-file jobs only store listed paths, other files must be stored already
+	scope := map[string]bool{}
+	if cur != nil {
+		for _, p := range cur.StorePaths {
+			scope[p] = false
+		}
+	}
+	var totalSize int64
+	var missing int
+	progress := newStoreProgress()
+	defer s.trackStoreProgress(ctx, db, id, progress)()
+
+	// Files are stored by a group limited to store file concurrency, failure of a file cancels the others
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(s.sched.fileConcurrency)
+	// finish links stored file to the resource
+	finish := func(item ra.ListItem, f *File) error {
+		if _, err := db.Model(&Resource{ID: id}).
+			Context(gctx).
+			Set("stored_size = ?", progress.finish(item.PathStr, item.Size)).
+			Set("error = ?", "").
+			Where("resource_id = ?", id).
+			Update(); err != nil {
+			return err
+		}
+		rf := &ResourceFile{
+			ResourceID: id,
+			FileHash:   f.Hash,
+			Path:       item.PathStr,
+		}
+		_, err := db.Model(rf).Insert()
+		if err != nil && !strings.Contains(err.Error(), "duplicate key value violates unique constraint") {
+			return err
+		}
+		return nil
+	}
+	store := func(item ra.ListItem, sc string) error {
+		release, err := s.sched.acquireBytes(gctx, item.Size)
+		if err != nil {
+			return err
+		}
+		defer release()
+		f, err := s.storeFileWithTimeout(gctx, cla, id, item, progress, sc, labels)
+		if err != nil {
+			progress.drop(item.PathStr)
+			// files cancelled because another file failed keep their previous error
+			if gctx.Err() == nil || ctx.Err() != nil {
+				if ferr := ResourceFileErrorSet(context.WithoutCancel(ctx), db, id, item.PathStr, err); ferr != nil {
+					logger(ctx).WithError(ferr).Warn("failed to record file error")
+				}
+			}
+			return err
+		}
+		if err = ResourceFileErrorDelete(gctx, db, id, item.PathStr); err != nil {
+			return err
+		}
+		s.indexStoredArchive(gctx, db, f, item.PathStr)
+		return finish(item, f)
+	}
+
 	// Paginate through results to find the file at the specified index
+list:
 	for {
-		resp, err := s.api.ListResourceContent(ctx, cla, id, listArgs)
+		resp, err := s.api.ListResourceContent(gctx, cla, id, listArgs)
 		if err != nil {
+			_ = g.Wait()
 			return err
 		}
-		var totalSize, totalStored int64
 		for _, item := range resp.Items {
+			if gctx.Err() != nil {
+				break list
+			}
 			if item.Type == ra.ListTypeFile {
+				item.PathStr = NormalizePath(item.PathStr)
 				// First, increment total size for the resource
 				totalSize += item.Size
 				if _, err := db.Model(&Resource{ID: id}).
@@ -299,34 +785,59 @@ func (s *Worker) handleStore(ctx context.Context, db *pg.DB, id string) (err err
 					Set("total_size = ?", totalSize).
 					Where("resource_id = ?", id).
 					Update(); err != nil {
+					_ = g.Wait()
 					return err
 				}
 
-				f, err := s.storeFile(ctx, cla, id, item, totalStored)
+				f, err := ResourceFileStored(ctx, db, id, item.PathStr, item.Size)
 				if err != nil {
+					_ = g.Wait()
 					return err
 				}
-				totalStored += item.Size
-
-				if _, err := db.Model(&Resource{ID: id}).
-					Context(ctx).
-					Set("stored_size = ?", totalStored).
-					Set("error = ?", "").
-					Where("resource_id = ?", id).
-					Update(); err != nil {
-					return err
+				// files of the previous version with the same path and size are linked instead of stored
+				// again if their content is unchanged
+				var prev *File
+				if f == nil && cur != nil && cur.PreviousID != nil {
+					if prev, err = ResourceFileStored(ctx, db, *cur.PreviousID, item.PathStr, item.Size); err != nil {
+						_ = g.Wait()
+						return err
+					}
 				}
-				rf := &ResourceFile{
-					ResourceID: id,
-					FileHash:   f.Hash,
-					Path:       item.PathStr,
+				_, scoped := scope[item.PathStr]
+				if f == nil && prev == nil && len(scope) > 0 && !scoped {
+					missing++
+					continue
 				}
-				_, err = db.Model(rf).Insert()
-				if err != nil && !strings.Contains(err.Error(), "duplicate key value violates unique constraint") {
-					return err
-				} else if err != nil {
+				if scoped {
+					scope[item.PathStr] = true
+				}
+				progress.add()
+				if f != nil {
+					g.Go(func() error {
+						return finish(item, f)
+					})
 					continue
 				}
+				fsc := s.policies.StorageClass(DetectContentClass(item.PathStr), s.storageClass)
+				if sc != nil {
+					fsc = *sc
+				}
+				if prev != nil {
+					g.Go(func() error {
+						f, err := s.unchangedFile(gctx, cla, id, item, prev, progress)
+						if err != nil {
+							return err
+						}
+						if f == nil {
+							return store(item, fsc)
+						}
+						return finish(item, f)
+					})
+					continue
+				}
+				g.Go(func() error {
+					return store(item, fsc)
+				})
 			}
 		}
 
@@ -337,87 +848,136 @@ func (s *Worker) handleStore(ctx context.Context, db *pg.DB, id string) (err err
 
 		listArgs.Offset += listArgs.Limit
 	}
+	if err := g.Wait(); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	for p, seen := range scope {
+		if !seen {
+			return fmt.Errorf("file %v not found in resource", p)
+		}
+	}
+	if missing > 0 {
+		return fmt.Errorf("%v files of the resource are not stored", missing)
+	}
 
 	res := &Resource{ID: id, Status: StatusStored}
 	_, err = db.Model(res).
 		Context(ctx).
-		Column("status").
+		Column("status", "store_paths").
 		Where("resource_id = ?", id).
+		// resource aborted since the last status check stays queued for deletion
+		Where("status = ?", StatusStoring).
 		Update()
 	return err
 }
 
-func (s *Worker) handleDelete(ctx context.Context, db *pg.DB, id string) (err error) {
+// handleDelete deletes the resource, or with delete grace period marks it pending purge
+// until its restore window passes. Returns true if the resource was deleted for good.
+func (s *Worker) handleDelete(ctx context.Context, db *pg.DB, id string) (bool, error) {
 	if s.bucket == "" {
-		return errors.New("s3 bucket is not configured")
+		return false, errors.New("s3 bucket is not configured")
 	}
-	// 1) Collect all files linked to this resource
-	var rfs []ResourceFile
-	if err := db.Model(&rfs).Context(ctx).Where("resource_id = ?", id).Select(); err != nil && !errors.Is(err, pg.ErrNoRows) {
-		return err
+	// Hold may have been set after deletion was queued
+	held, err := ResourceGetByID(ctx, db, id)
+	if err != nil {
+		return false, err
+	}
+	if held != nil && held.LegalHold {
+		return false, ErrLegalHold
 	}
+	if held != nil {
+		if soft, err := s.softDelete(ctx, db, held); err != nil || soft {
+			return false, err
+		}
+	}
+	return true, s.purge(ctx, db, id)
+}
 
-	// 2) For each file check if it's referenced by any other resource, if not — delete from S3 and DB
-	for _, rf := range rfs {
-		// Load file to know its size for counters update
-		f := &File{Hash: rf.FileHash}
-		if err := db.Model(f).Context(ctx).WherePK().Select(); err != nil {
-			if !errors.Is(err, pg.ErrNoRows) {
+// purge deletes the resource and files no other resource references in one transaction recording
+// deletion of their S3 objects in the outbox, objects are deleted once it commits.
+func (s *Worker) purge(ctx context.Context, db *pg.DB, id string) error {
+	err := db.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		// 1) Collect all files linked to this resource
+		var rfs []ResourceFile
+		if err := tx.Model(&rfs).Context(ctx).Where("resource_id = ?", id).Select(); err != nil && !errors.Is(err, pg.ErrNoRows) {
+			return err
+		}
+
+		// 2) For each file check if it's referenced by any other resource, if not — delete it
+		for _, rf := range rfs {
+			cnt, err := tx.Model((*ResourceFile)(nil)).Context(ctx).
+				Where("file_hash = ?", rf.FileHash).
+				Where("resource_id <> ?", id).
+				Count()
+			if err != nil {
 				return err
 			}
-		} else {
-			// Decrease resource stored_size by the size currently accounted for this file
-			// Guard against negatives in SQL
-			if _, err := db.Model(&Resource{ID: id}).Context(ctx).
-				Set("stored_size = CASE WHEN stored_size >= ? THEN stored_size - ? ELSE 0 END", f.StoredSize, f.StoredSize).
-				Set("updated_at = now()").
-				Where("resource_id = ?", id).
-				Update(); err != nil {
+			if cnt > 0 {
+				continue
+			}
+			f := &File{Hash: rf.FileHash}
+			if err := tx.Model(f).Context(ctx).WherePK().For("UPDATE").Select(); err != nil {
+				if errors.Is(err, pg.ErrNoRows) {
+					continue
+				}
+				return err
+			}
+			if f.Chunked {
+				err = s.addChunkDeletes(ctx, tx, f, id)
+			} else {
+				err = s.addFileDeletes(ctx, tx, f, f.TotalSize, DeleteReasonRefcountZero, id)
+			}
+			if err != nil {
 				return err
 			}
+			if _, err := tx.Model(f).Context(ctx).WherePK().Delete(); err != nil {
+				return err
+			}
+			logger(ctx).WithFields(log.Fields{"path": rf.Path, "resource_id": id, "hash": f.Hash}).Info("file deleted")
 		}
-		// Count references excluding current resource
-		cnt, err := db.Model((*ResourceFile)(nil)).Context(ctx).
-			Where("file_hash = ?", rf.FileHash).
-			Where("resource_id <> ?", id).
-			Count()
-		if err != nil {
+
+		// 3) Remove uploads abandoned by the resource before files were linked to it
+		if err := s.purgeAbandoned(ctx, tx, id); err != nil {
 			return err
 		}
-		if cnt > 0 {
-			continue
-		}
-		// Mark file status as Deleting before removing the object from S3
-		up := &File{Hash: rf.FileHash, Status: StatusDeleting}
-		if _, err := db.Model(up).Context(ctx).
-			Set("status = ?", StatusDeleting).
-			Set("stored_size = 0").
-			Set("updated_at = now()").
-			WherePK().
-			Update(); err != nil && !errors.Is(err, pg.ErrNoRows) {
+
+		_, err := tx.Model(&Resource{ID: id}).Context(ctx).WherePK().Delete()
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := s.dispatchOutbox(ctx, db); err != nil {
+		logger(ctx).WithError(err).Warn("failed to dispatch s3 outbox")
+	}
+	return nil
+}
+
+// purgeAbandoned deletes rows of files which aborted or failed store jobs of the resource left unreferenced
+// and records deletion of their S3 objects.
+func (s *Worker) purgeAbandoned(ctx context.Context, tx *pg.Tx, id string) error {
+	files, err := FileListAbandoned(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	for i := range files {
+		f := &files[i]
+		res, err := tx.Model(f).Context(ctx).WherePK().Where("status IN (?)", pg.In([]Status{StatusStoring, StatusStoreError})).Delete()
+		if err != nil {
 			return err
 		}
-		// No more references — delete S3 object (if configured) and file row
-		s3Cl := s.s3.Get()
-		_, delErr := s3Cl.DeleteObjectWithContext(ctx, &awss3.DeleteObjectInput{
-			Bucket: aws.String(s.bucket),
-			Key:    aws.String(rf.FileHash),
-		})
-		if delErr != nil {
-			return delErr
+		if res.RowsAffected() == 0 {
+			continue
 		}
-		log.WithFields(log.Fields{"bucket": s.bucket, "path": rf.Path, "resource_id": id, "key": rf.FileHash}).Info("deleted from s3")
-		// Delete file row
-		f = &File{Hash: rf.FileHash}
-		if _, err := db.Model(f).Context(ctx).WherePK().Delete(); err != nil && !errors.Is(err, pg.ErrNoRows) {
+		if err := s.addFileDeletes(ctx, tx, f, f.StoredSize, DeleteReasonAbandoned, id); err != nil {
 			return err
 		}
+		logger(ctx).WithFields(log.Fields{"resource_id": id, "hash": f.Hash, "stored_size": f.StoredSize}).Info("abandoned upload deleted")
 	}
-
-	res := &Resource{ID: id}
-	_, err = db.Model(res).Context(ctx).WherePK().Delete()
-
-	return err
+	return nil
 }
 
 func (s *Worker) handleError(ctx context.Context, id string, err error, status Status) {
@@ -432,26 +992,44 @@ func (s *Worker) handleError(ctx context.Context, id string, err error, status S
 		Where("resource_id = ?", id).
 		Update()
 	if upErr != nil {
-		log.WithError(upErr).Error("update error status failed")
+		logger(ctx).WithError(upErr).Error("update error status failed")
 	}
+	s.events.Publish(EventResourceError, &ResourceEvent{ResourceID: id, Status: status.String(), Error: errMsg, Time: time.Now()})
 }
 
-func (s *Worker) storeFile(ctx context.Context, cla *Claims, id string, item ra.ListItem, totalStored int64) (*File, error) {
+func (s *Worker) storeFile(ctx context.Context, cla *Claims, id string, item ra.ListItem, progress *storeProgress, sc string, labels map[string]string) (*File, error) {
 	if s.bucket == "" {
 		return nil, errors.New("s3 bucket is not configured")
 	}
 	db := s.pg.Get()
 	f := &File{
-		TotalSize: item.Size,
-		Path:      &item.PathStr,
-		Status:    StatusStoring,
+		TotalSize:    item.Size,
+		Path:         &item.PathStr,
+		Status:       StatusStoring,
+		HashAlgo:     s.hashAlgo,
+		StorageClass: storageClassPtr(sc),
+		ContentClass: DetectContentClass(item.PathStr),
 	}
-	err := db.Model(f).Context(ctx).Where("total_size = ? AND path = ?", item.Size, item.PathStr, StatusStored).Select()
+	prev := &File{}
+	err := db.Model(prev).Context(ctx).Where("total_size = ? AND path = ? AND hash_algo = ?", item.Size, item.PathStr, s.hashAlgo).Select()
 	if err != nil && !errors.Is(err, pg.ErrNoRows) {
 		return nil, err
 	}
-	if err == nil && (f.Status == StatusStored || f.UpdatedAt.Add(10*time.Second).After(time.Now())) {
-		return f, nil
+	// full hashes are collision-safe, so files are deduplicated only by the hash computed below, the same
+	// path and size don't make the same content
+	full := s.hashAlgo.Full()
+	if err == nil && prev.Status == StatusStored && !full {
+		return prev, nil
+	}
+	if err == nil && prev.Status != StatusStored && !uploadStale(prev) {
+		// likely the same content is being uploaded by another job, it is linked by its hash once stored
+		done, err := s.awaitUpload(ctx, db, prev.Hash)
+		if err != nil {
+			return nil, err
+		}
+		if done != nil && !full {
+			return done, nil
+		}
 	}
 	ei, err := s.api.ExportResourceContent(ctx, cla, id, item.ID)
 	if err != nil {
@@ -459,26 +1037,29 @@ func (s *Worker) storeFile(ctx context.Context, cla *Claims, id string, item ra.
 	}
 	u := ei.ExportItems["download"].URL
 	log.WithField("url", u).Debug("export url")
-	hash, err := s.generateFileHash(ctx, item, ei)
+	if s.chunking != nil {
+		return s.storeFileChunked(ctx, db, id, item, u, progress, sc)
+	}
+	if s.hashStreaming && s.hashAlgo.Full() {
+		return s.storeFileStreaming(ctx, db, id, item, u, progress, sc, labels)
+	}
+	var hash string
+	err = s.retry.do(ctx, item.PathStr, func() (err error) {
+		hash, err = s.generateFileHash(ctx, item, ei, progress)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
 	log.WithField("hash", hash).Debug("generated hash")
 	f.Hash = hash
-	err = db.Model(f).
-		Context(ctx).
-		WherePK().
-		Select()
-	if err != nil && !errors.Is(err, pg.ErrNoRows) {
-		return nil, err
-	}
-	if err == nil && (f.Status == StatusStored || f.UpdatedAt.Add(10*time.Second).After(time.Now())) {
-		return f, nil
-	}
-	_, err = db.Model(f).Context(ctx).Insert()
-	if err != nil && !strings.Contains(err.Error(), "duplicate key value violates unique constraint") {
-		return nil, err
+	f.StoringResourceID = &id
+	bucket := s.router.Route(item.Size, labels, hash)
+	f.Bucket = &bucket
+	if done, err := s.claimUpload(ctx, db, f); err != nil || done != nil {
+		return done, err
 	}
+	progress.start(item.PathStr, StorePhaseUploading, item.Size, item.Size)
 
 	// Progress reporting wrapper with throttled DB flushes (once every 5 seconds)
 	var stored int64
@@ -494,12 +1075,21 @@ func (s *Worker) storeFile(ctx context.Context, cla *Claims, id string, item ra.
 		}
 		if _, err := db.Model(&Resource{ID: id}).
 			Context(ctx).
-			Set("stored_size = ?", totalStored+stored).
+			Set("stored_size = ?", progress.set(item.PathStr, stored)).
 			Set("updated_at = now()").
 			Where("resource_id = ?", id).
 			Update(); err != nil {
 			return err
 		}
+		s.events.Publish(EventFileProgress, &FileProgressEvent{
+			ResourceID: id,
+			FileHash:   hash,
+			Path:       item.PathStr,
+			StoredSize: stored,
+			TotalSize:  item.Size,
+			Phase:      StorePhaseUploading,
+			Time:       time.Now(),
+		})
 		return nil
 	}
 
@@ -520,27 +1110,41 @@ func (s *Worker) storeFile(ctx context.Context, cla *Claims, id string, item ra.
 		}
 	}()
 	s3Cl := s.s3.Get()
-	r, err := s.api.Download(ctx, u)
-	if err != nil {
-		return nil, err
-	}
-	defer func(r io.ReadCloser) {
-		_ = r.Close()
-	}(r)
+	key := s.keys.Key(hash)
+	// a failed download is retried from the start, S3 upload of the attempt is aborted
+	err = s.retry.do(ctx, item.PathStr, func() error {
+		stored = 0
+		r, err := s.download(ctx, u, item.Size)
+		if err != nil {
+			return err
+		}
+		defer func(r io.ReadCloser) {
+			_ = r.Close()
+		}(r)
 
-	pr := &progressReader{
-		r: r,
-		onRead: func(n int) error {
-			stored += int64(n)
-			return nil
-		},
-	}
-	// Upload stream directly to S3 under the file hash key using s3manager (supports io.Reader)
-	uploader := s3manager.NewUploaderWithClient(s3Cl)
-	_, err = uploader.UploadWithContext(ctx, &s3manager.UploadInput{
-		Bucket: aws.String(s.bucket),
-		Key:    aws.String(hash),
-		Body:   pr,
+		pr := &progressReader{
+			r: r,
+			onRead: func(n int) error {
+				stored += int64(n)
+				return nil
+			},
+		}
+		// Upload stream directly to S3 under the file hash key using s3manager (supports io.Reader)
+		uploader := s3manager.NewUploaderWithClient(s3Cl)
+		input := &s3manager.UploadInput{
+			Bucket:  aws.String(bucket),
+			Key:     aws.String(key),
+			Body:    pr,
+			Tagging: s.tags.Tagging(id, item.PathStr),
+		}
+		if sc != "" {
+			input.StorageClass = aws.String(sc)
+		}
+		setObjectMetadata(input, item.PathStr, s.hashAlgo)
+		if err = s.enc.PrepareUpload(input, item.Size); err != nil {
+			return err
+		}
+		return s.upload(ctx, uploader, input, item.Size)
 	})
 	if err != nil {
 		return nil, err
@@ -551,26 +1155,76 @@ func (s *Worker) storeFile(ctx context.Context, cla *Claims, id string, item ra.
 	if _, err := db.Model(f).Context(ctx).Column("status", "stored_size").WherePK().Update(); err != nil {
 		return nil, err
 	}
-	log.WithFields(log.Fields{"bucket": s.bucket, "resource_id": id, "path": item.PathStr, "key": hash, "size": item.Size}).Info("stored to s3")
+	logger(ctx).WithFields(log.Fields{"bucket": bucket, "resource_id": id, "path": item.PathStr, "key": key, "size": item.Size}).Info("stored to s3")
+	s.events.Publish(EventFileProgress, &FileProgressEvent{
+		ResourceID: id,
+		FileHash:   hash,
+		Path:       item.PathStr,
+		StoredSize: item.Size,
+		TotalSize:  item.Size,
+		Done:       true,
+		Phase:      StorePhaseUploading,
+		Time:       time.Now(),
+	})
 	return f, nil
 }
 
-func (s *Worker) generateFileHash(ctx context.Context, item ra.ListItem, ei *ra.ExportResponse) (string, error) {
+// unchangedFile hashes the file of the new version and returns file prev of the previous version with
+// the same path and size if the hash is the same. Nil is returned if the content changed or prev was hashed
+// with another algorithm, so the file must be stored.
+func (s *Worker) unchangedFile(ctx context.Context, cla *Claims, id string, item ra.ListItem, prev *File, progress *storeProgress) (*File, error) {
+	if prev.HashAlgo != s.hashAlgo {
+		return nil, nil
+	}
+	ei, err := s.api.ExportResourceContent(ctx, cla, id, item.ID)
+	if err != nil {
+		return nil, err
+	}
+	var hash string
+	err = s.retry.do(ctx, item.PathStr, func() (err error) {
+		hash, err = s.generateFileHash(ctx, item, ei, progress)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if hash != prev.Hash {
+		logger(ctx).WithFields(log.Fields{"resource_id": id, "path": item.PathStr, "previous_hash": prev.Hash, "hash": hash}).
+			Info("file changed since the previous version")
+		return nil, nil
+	}
+	return prev, nil
+}
+
+// generateFileHash downloads content of the file (head and tail of it with the sampled hash) and hashes it,
+// reporting hashed bytes to progress.
+func (s *Worker) generateFileHash(ctx context.Context, item ra.ListItem, ei *ra.ExportResponse, progress *storeProgress) (string, error) {
 	u := ei.ExportItems["download"].URL
 	size := item.Size
 	var limitStart int64 = 500 * 1024
 	var limitEnd int64 = 500 * 1024
-	h := sha256.New()
-	h.Write([]byte(fmt.Sprintf("%v", size)))
-	if size < limitStart+limitEnd {
-		r, err := s.api.Download(ctx, u)
+	h := s.hashAlgo.newHasher()
+	if s.hashAlgo == HashAlgoSampled {
+		h.Write([]byte(fmt.Sprintf("%v", size)))
+	}
+	full := s.hashAlgo != HashAlgoSampled || size < limitStart+limitEnd
+	hashSize := limitStart + limitEnd
+	if full {
+		hashSize = size
+	}
+	progress.start(item.PathStr, StorePhaseHashing, hashSize, size)
+	hw := &progressWriter{w: h, onWrite: func(n int) {
+		progress.hashed(item.PathStr, int64(n))
+	}}
+	if full {
+		r, err := s.download(ctx, u, item.Size)
 		if err != nil {
 			return "", err
 		}
 		defer func(r io.ReadCloser) {
 			_ = r.Close()
 		}(r)
-		_, err = io.Copy(h, r)
+		_, err = io.Copy(hw, r)
 		if err != nil {
 			return "", err
 		}
@@ -582,7 +1236,7 @@ func (s *Worker) generateFileHash(ctx context.Context, item ra.ListItem, ei *ra.
 		defer func(r io.ReadCloser) {
 			_ = r.Close()
 		}(r)
-		_, err = io.Copy(h, r)
+		_, err = io.Copy(hw, r)
 		if err != nil {
 			return "", err
 		}
@@ -593,7 +1247,27 @@ func (s *Worker) generateFileHash(ctx context.Context, item ra.ListItem, ei *ra.
 		defer func(r io.ReadCloser) {
 			_ = r.Close()
 		}(r)
-		_, err = io.Copy(h, r)
+		if _, err = io.Copy(hw, r); err != nil {
+			return "", err
+		}
 	}
 	return fmt.Sprintf("%x", h.Sum(nil)), nil
 }
+
+// upload streams input to S3 within a span covering the whole upload.
+func (s *Worker) upload(ctx context.Context, uploader *s3manager.Uploader, input *s3manager.UploadInput, size int64) (err error) {
+	ctx, span := tracer.Start(ctx, "s3.upload", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("bucket", aws.StringValue(input.Bucket)),
+		attribute.String("key", aws.StringValue(input.Key)),
+		attribute.Int64("size", size),
+	))
+	defer func() { endSpan(span, err) }()
+	d := newUploadDigest(input.Body)
+	input.Body = d
+	_, err = uploader.UploadWithContext(ctx, input)
+	s.breaker.recordUpload(ctx, err)
+	if err != nil {
+		return err
+	}
+	return s.verifyUpload(ctx, input, d, size)
+}
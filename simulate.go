@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+
+	cs "github.com/webtor-io/common-services"
+	"github.com/webtor-io/vault/services"
+)
+
+func configureSimulate(c *cli.Command) {
+	c.Flags = services.RegisterConfigFlags(c.Flags)
+	c.Flags = cs.RegisterPGFlags(c.Flags)
+	c.Flags = services.RegisterPGPoolFlags(c.Flags)
+	c.Flags = services.RegisterS3Flags(c.Flags)
+	c.Flags = services.RegisterVerifyCommandFlags(c.Flags)
+	c.Flags = services.RegisterObjectKeyFlags(c.Flags)
+	c.Flags = services.RegisterSecretsFlags(c.Flags)
+	c.Flags = services.RegisterHCVaultFlags(c.Flags)
+	c.Flags = services.RegisterKMSFlags(c.Flags)
+	c.Flags = services.RegisterSimulateFlags(c.Flags)
+}
+
+func makeSimulateCMD() cli.Command {
+	simulateCmd := cli.Command{
+		Name:   "simulate",
+		Usage:  "Runs content lifecycle scenario against a staging deployment and asserts DB and S3 invariants at each step",
+		Action: simulate,
+	}
+	configureSimulate(&simulateCmd)
+	return simulateCmd
+}
+
+func simulate(c *cli.Context) error {
+	// Setting Config
+	if _, err := services.LoadConfig(c); err != nil {
+		return err
+	}
+
+	// Setting DB
+	pg := services.NewPG(c, services.DBRoleWorker)
+	defer pg.Close()
+
+	cl := http.DefaultClient
+
+	// Setting Secrets
+	secrets, err := services.NewSecrets(c, cl)
+	if err != nil {
+		return err
+	}
+	defer secrets.Close()
+
+	// Setting S3Client
+	s3c, err := services.NewS3Client(c)
+	if err != nil {
+		return err
+	}
+	secrets.WatchS3(s3c)
+
+	// Setting ObjectKeys
+	keys, err := services.NewObjectKeys(c)
+	if err != nil {
+		return err
+	}
+
+	// Setting Simulation
+	sim, err := services.NewSimulation(c, pg, s3c, keys)
+	if err != nil {
+		return err
+	}
+
+	r, err := sim.Run(context.Background())
+	if err != nil {
+		return err
+	}
+	je := json.NewEncoder(os.Stdout)
+	je.SetIndent("", "  ")
+	if err := je.Encode(r); err != nil {
+		return err
+	}
+	if !r.OK {
+		log.WithField("resource_id", r.ResourceID).Warn("simulation failed")
+		return cli.NewExitError("", 2)
+	}
+	return nil
+}
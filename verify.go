@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+
+	cs "github.com/webtor-io/common-services"
+	"github.com/webtor-io/vault/services"
+)
+
+const (
+	verifyRehashFlag   = "rehash"
+	verifyPageSizeFlag = "page-size"
+)
+
+func configureVerify(c *cli.Command) {
+	c.Flags = services.RegisterConfigFlags(c.Flags)
+	c.Flags = cs.RegisterPGFlags(c.Flags)
+	c.Flags = services.RegisterPGPoolFlags(c.Flags)
+	c.Flags = services.RegisterS3Flags(c.Flags)
+	c.Flags = services.RegisterVerifyCommandFlags(c.Flags)
+	c.Flags = services.RegisterEncryptionFlags(c.Flags)
+	c.Flags = services.RegisterObjectKeyFlags(c.Flags)
+	c.Flags = services.RegisterSecretsFlags(c.Flags)
+	c.Flags = services.RegisterHCVaultFlags(c.Flags)
+	c.Flags = services.RegisterKMSFlags(c.Flags)
+	c.Flags = append(c.Flags,
+		cli.BoolFlag{
+			Name:  verifyRehashFlag,
+			Usage: "re-hash content of every file of given resources",
+		},
+		cli.IntFlag{
+			Name:  verifyPageSizeFlag,
+			Usage: "number of files loaded from DB at once when verifying whole storage",
+			Value: 100,
+		},
+	)
+}
+
+func makeVerifyCMD() cli.Command {
+	verifyCmd := cli.Command{
+		Name:      "verify",
+		Usage:     "Verifies stored objects against DB and marks mismatching ones as corrupted",
+		ArgsUsage: "[resource-id...]",
+		Action:    verify,
+	}
+	configureVerify(&verifyCmd)
+	return verifyCmd
+}
+
+func verify(c *cli.Context) error {
+	// Setting Config
+	if _, err := services.LoadConfig(c); err != nil {
+		return err
+	}
+
+	// Setting DB
+	pg := services.NewPG(c, services.DBRoleWorker)
+	defer pg.Close()
+
+	cl := http.DefaultClient
+
+	// Setting Secrets
+	secrets, err := services.NewSecrets(c, cl)
+	if err != nil {
+		return err
+	}
+	defer secrets.Close()
+
+	// Setting S3Client
+	s3c, err := services.NewS3Client(c)
+	if err != nil {
+		return err
+	}
+	secrets.WatchS3(s3c)
+
+	// Setting Encryption
+	enc, err := services.NewEncryption(c, secrets)
+	if err != nil {
+		return err
+	}
+
+	// Setting ObjectKeys
+	keys, err := services.NewObjectKeys(c)
+	if err != nil {
+		return err
+	}
+
+	// Setting Verifier
+	v := services.NewVerifier(c, pg, s3c, enc, keys)
+
+	ctx := context.Background()
+	var reports []*services.VerifyReport
+	if c.NArg() == 0 {
+		r, err := v.VerifyAll(ctx, c.Int(verifyPageSizeFlag))
+		if err != nil {
+			return err
+		}
+		reports = append(reports, r)
+	}
+	for _, id := range c.Args() {
+		r, err := v.VerifyResource(ctx, id, c.Bool(verifyRehashFlag))
+		if err != nil {
+			return err
+		}
+		if r == nil {
+			return errors.Errorf("resource %v not found", id)
+		}
+		reports = append(reports, r)
+	}
+	je := json.NewEncoder(os.Stdout)
+	je.SetIndent("", "  ")
+	corrupted := 0
+	for _, r := range reports {
+		if err := je.Encode(r); err != nil {
+			return err
+		}
+		corrupted += r.Corrupted
+	}
+	if corrupted > 0 {
+		log.WithField("corrupted", corrupted).Warn("corrupted files found")
+		return cli.NewExitError("", 2)
+	}
+	return nil
+}